@@ -16,6 +16,25 @@ typedef __u64 u64;
 #define ETH_P_ARP 0x0806
 #define IPPROTO_ICMPV6 58
 
+// Tunnel encapsulations
+#define TUNNEL_NONE 0
+#define TUNNEL_VXLAN 1
+#define TUNNEL_GENEVE 2
+// IANA-assigned UDP destination ports of the tunnel protocols
+#define VXLAN_PORT 4789
+#define GENEVE_PORT 6081
+// Transparent Ethernet Bridging, the protocol type of the Geneve payloads carrying Ethernet frames
+#define ETH_P_TEB 0x6558
+
+// Maximum number of L4 payload bytes that can be sampled for a flow
+#define MAX_PAYLOAD_SAMPLE 64
+// Number of buckets of the packet size histogram
+#define PKT_SIZE_BUCKETS 4
+// Number of IPv4 identifications of the last packets of a flow that are kept to detect duplicates
+#define IP_ID_HISTORY 8
+// Minimum inter-packet gap of the flows with a single packet, so a real 0 ns gap is kept
+#define IPG_UNSET 0xFFFFFFFFFFFFFFFFULL
+
 typedef struct flow_metrics_t {
     u32 packets;
     u64 bytes;
@@ -30,6 +49,39 @@ typedef struct flow_metrics_t {
     // 0 otherwise
     // https://chromium.googlesource.com/chromiumos/docs/+/master/constants/errnos.md
     u8 errno;
+    // Minimum and maximum IPv4 TTL or IPv6 hop limit observed in the flow packets
+    u8 min_ttl;
+    u8 max_ttl;
+    // Number of IP fragments accounted in the flow
+    u32 fragmented_packets;
+    // Number of packets by size, according to the configured bucket boundaries
+    u32 pkt_size_buckets[PKT_SIZE_BUCKETS];
+    // Nanoseconds between a TCP SYN and the SYN-ACK answering it. Only set in the flow carrying
+    // the SYN-ACK, if the SYN has been observed in the same interface. 0 otherwise
+    u64 server_connect_latency;
+    // cgroup v2 id of the socket that sent or received the flow packets, as returned by
+    // bpf_skb_cgroup_id(). 0 if the packets aren't associated to a local socket
+    u64 cgroup_id;
+    // Cookie of the local socket that sent or received the flow packets, as returned by
+    // bpf_get_socket_cookie(). It uniquely identifies the socket in the host. 0 if the packets
+    // aren't associated to a local socket
+    u64 socket_cookie;
+    // Minimum and maximum nanoseconds between two consecutive packets of the flow. min_ipg is
+    // IPG_UNSET and max_ipg is 0 until the flow has two packets
+    u64 min_ipg;
+    u64 max_ipg;
+    // Number of IPv4 packets whose identification matches one of the last IP_ID_HISTORY packets
+    // of the flow, e.g. because they were duplicated by a misconfigured port mirror
+    u32 duplicate_packets;
+    // Identifications of the last IPv4 packets of the flow, as a ring buffer. 0 means empty
+    u16 recent_ip_ids[IP_ID_HISTORY];
+    // Position of recent_ip_ids where the next identification is stored
+    u8 recent_ip_ids_next;
+    // Number of valid bytes in payload_sample. 0 if payload sampling is disabled
+    // or no matching packet carrying payload has been observed yet
+    u16 payload_sample_len;
+    // First bytes of the L4 payload of the first flow packet carrying payload
+    u8 payload_sample[MAX_PAYLOAD_SAMPLE];
 } __attribute__((packed)) flow_metrics;
 
 // Force emitting struct flow_metrics into the ELF.
@@ -56,6 +108,17 @@ typedef struct flow_id_t {
     u8  icmp_code;
     // OS interface index
     u32 if_index;
+    // Encapsulation of the packet (TUNNEL_VXLAN or TUNNEL_GENEVE), if tunnel parsing is enabled.
+    // TUNNEL_NONE otherwise, and the tunnel fields below remain zero
+    u8 tunnel_type;
+    // VXLAN or Geneve Network Identifier
+    u32 tunnel_vni;
+    // Attributes of the encapsulated flow. IPv4 addresses are encoded as the outer addresses
+    u8 inner_src_ip[IP_MAX_LEN];
+    u8 inner_dst_ip[IP_MAX_LEN];
+    u16 inner_src_port;
+    u16 inner_dst_port;
+    u8 inner_transport_protocol;
 } __attribute__((packed)) flow_id;
 
 // Force emitting struct flow_id into the ELF.
@@ -71,4 +134,62 @@ typedef struct flow_record_t {
 
 // Force emitting struct flow_record into the ELF.
 const struct flow_record_t *unused3 __attribute__((unused));
+
+// Attributes that identify the fragments of the same IP datagram
+typedef struct frag_key_t {
+    u8 src_ip[IP_MAX_LEN];
+    u8 dst_ip[IP_MAX_LEN];
+    // IPv4 identification or IPv6 fragment header identification
+    u32 ip_id;
+    u32 if_index;
+    u8 transport_protocol;
+    u8 direction;
+} __attribute__((packed)) frag_key;
+
+// Information about the IP datagram the fragments belong to. It allows attributing the
+// non-first fragments, which don't carry the L4 header, to the flow of the first fragment.
+typedef struct frag_info_t {
+    // Flow identifier of the datagram. The L4 ports are only valid if first_seen is set
+    flow_id id;
+    // 1 if the first fragment of the datagram (carrying the L4 header) has been observed
+    u8 first_seen;
+    // Last time a fragment of this datagram was observed, as output from bpf_ktime_get_ns()
+    u64 last_seen_ts;
+    // Non-first fragments that arrived before the first fragment, and are pending
+    // to be accounted in the flow once the first fragment is observed
+    u32 pending_packets;
+    u64 pending_bytes;
+    u64 pending_start_ts;
+} __attribute__((packed)) frag_info;
+
+// VXLAN header, according to RFC 7348
+typedef struct vxlan_header_t {
+    // the I flag (0x08) must be set for a valid VNI
+    u8 flags;
+    u8 reserved1[3];
+    // VXLAN Network Identifier, in network byte order
+    u8 vni[3];
+    u8 reserved2;
+} __attribute__((packed)) vxlan_header;
+
+// Geneve header, according to RFC 8926. The variable-length options follow it
+typedef struct geneve_header_t {
+    // 2-bit version and 6-bit length of the options, in 4-byte multiples
+    u8 ver_opt_len;
+    u8 flags;
+    // EtherType of the payload, in network byte order
+    u16 protocol_type;
+    // Virtual Network Identifier, in network byte order
+    u8 vni[3];
+    u8 reserved;
+} __attribute__((packed)) geneve_header;
+
+// Attributes that identify a TCP connection handshake, as seen from the client to the server
+typedef struct handshake_key_t {
+    u8 src_ip[IP_MAX_LEN];
+    u8 dst_ip[IP_MAX_LEN];
+    u16 src_port;
+    u16 dst_port;
+    u32 if_index;
+} __attribute__((packed)) handshake_key;
 #endif
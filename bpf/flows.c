@@ -39,6 +39,20 @@
 #define FIN_ACK_FLAG 0x200
 #define RST_ACK_FLAG 0x400
 
+// Error returned when a new entry can't be added to a full map
+#define E2BIG 7
+
+// IP fragmentation
+#define IP_MF 0x2000
+#define IP_OFFSET 0x1FFF
+#define IP6_MF 0x0001
+#define IP6_OFFSET 0xFFF8
+#define NEXTHDR_FRAGMENT 44
+// Position of the packet in a fragmented IP datagram
+#define FRAG_NONE 0
+#define FRAG_FIRST 1
+#define FRAG_NON_FIRST 2
+
 #if defined(__BYTE_ORDER__) && defined(__ORDER_LITTLE_ENDIAN__) && \
 	__BYTE_ORDER__ == __ORDER_LITTLE_ENDIAN__
 #define bpf_ntohs(x)		__builtin_bswap16(x)
@@ -66,9 +80,76 @@ struct {
     __uint(map_flags, BPF_F_NO_PREALLOC);
 } aggregated_flows SEC(".maps");
 
+// Key: the identifier of a fragmented IP datagram. Value: the flow that the fragments belong to,
+// as well as the fragments that couldn't be attributed yet to a flow.
+// Expired entries are removed from userspace
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, frag_key);
+    __type(value, frag_info);
+    __uint(max_entries, 1 << 16);
+} fragments SEC(".maps");
+
+// Key: the client-to-server identifier of a TCP connection. Value: the time the last SYN of the
+// connection was observed, as output from bpf_ktime_get_ns().
+// Entries are removed when the SYN-ACK is observed, or by LRU eviction if it never comes
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, handshake_key);
+    __type(value, u64);
+    __uint(max_entries, 1 << 16);
+} tcp_handshakes SEC(".maps");
+
+// Index 0: the pressure level, written from userspace when it can't keep up with the flows.
+// Each level halves the fraction of packets that are accounted, on top of the sampling
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, u32);
+    __uint(max_entries, 1);
+} pressure SEC(".maps");
+
+// Index 0: if not 0, overrides the sampling constant, so userspace can change the sampling rate
+// without reloading the program (e.g. following a schedule)
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, u32);
+    __uint(max_entries, 1);
+} sampling_override SEC(".maps");
+
+// Index 0: the number of flows that couldn't be added to the full aggregated flows map. It allows
+// sampling them before they are submitted to the ring buffer
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, 1);
+} map_full_flows SEC(".maps");
+
 // Constant definitions, to be overridden by the invoker
 volatile const u32 sampling = 0;
 volatile const u8 trace_messages = 0;
+// Number of L4 payload bytes to sample for each flow. 0 disables payload sampling
+volatile const u16 payload_sample_bytes = 0;
+// If not 0, restricts payload sampling to flows with the given transport protocol
+volatile const u8 payload_sample_protocol = 0;
+// If not 0, restricts payload sampling to flows with the given source or destination port
+volatile const u16 payload_sample_port = 0;
+// Upper bounds (inclusive) of the first buckets of the packet size histogram. The last bucket
+// counts the packets that are larger than pkt_size_bound_2
+volatile const u16 pkt_size_bound_0 = 64;
+volatile const u16 pkt_size_bound_1 = 512;
+volatile const u16 pkt_size_bound_2 = 1500;
+// Ratio of the flows that couldn't be added to the full aggregated flows map that are submitted
+// to the ring buffer (e.g. 10 means 1 out of each 10). 0 or 1 submits all of them
+volatile const u32 map_full_sampling = 0;
+
+// Optional features. When disabled, their maps are shrunk by userspace to the minimum size
+volatile const u8 enable_connect_latency = 0;
+volatile const u8 enable_fragments = 1;
+volatile const u8 enable_tunnel_parsing = 0;
+volatile const u8 enable_ip_id_tracking = 0;
 
 const u8 ip4in6[] = {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff};
 
@@ -102,6 +183,30 @@ static inline void set_flags(struct tcphdr *th, u16 *flags) {
     }
 }
 
+// pkt_info contains per-packet information that does not take part of the flow identifier
+// but is accumulated into the flow metrics.
+typedef struct pkt_info_t {
+    // TCP flags
+    u16 flags;
+    // Start of the L4 payload. NULL if the L4 protocol is unknown or the header is truncated
+    void *payload;
+    // IPv4 TTL or IPv6 hop limit
+    u8 ttl;
+    // FRAG_NONE, FRAG_FIRST or FRAG_NON_FIRST
+    u8 frag_type;
+    // IP identification of the datagram, if the packet is a fragment
+    u32 frag_id;
+    // IPv4 identification of the packet, in host byte order. 0 for IPv6 and for fragments
+    u16 ip_id;
+} pkt_info;
+
+// Non-first fragments that were observed before the first fragment of their datagram
+typedef struct frag_pending_t {
+    u32 packets;
+    u64 bytes;
+    u64 start_ts;
+} frag_pending;
+
 // L4_info structure contains L4 headers parsed information.
 struct l4_info_t {
     // TCP/UDP/SCTP source port in host byte order
@@ -114,6 +219,8 @@ struct l4_info_t {
     u8 icmp_code;
     // TCP flags
     u16 flags;
+    // Start of the L4 payload
+    void *payload;
 };
 
 // Extract L4 info for the supported protocols
@@ -126,6 +233,7 @@ static inline void fill_l4info(void *l4_hdr_start, void *data_end, u8 protocol,
             l4_info->src_port = bpf_ntohs(tcp->source);
             l4_info->dst_port = bpf_ntohs(tcp->dest);
             set_flags(tcp, &l4_info->flags);
+            l4_info->payload = (void *)tcp + tcp->doff * 4;
         }
     } break;
     case IPPROTO_UDP: {
@@ -133,6 +241,7 @@ static inline void fill_l4info(void *l4_hdr_start, void *data_end, u8 protocol,
         if ((void *)udp + sizeof(*udp) <= data_end) {
             l4_info->src_port = bpf_ntohs(udp->source);
             l4_info->dst_port = bpf_ntohs(udp->dest);
+            l4_info->payload = (void *)udp + sizeof(*udp);
         }
     } break;
     case IPPROTO_SCTP: {
@@ -140,6 +249,7 @@ static inline void fill_l4info(void *l4_hdr_start, void *data_end, u8 protocol,
         if ((void *)sctph + sizeof(*sctph) <= data_end) {
             l4_info->src_port = bpf_ntohs(sctph->source);
             l4_info->dst_port = bpf_ntohs(sctph->dest);
+            l4_info->payload = (void *)sctph + sizeof(*sctph);
         }
     } break;
     case IPPROTO_ICMP: {
@@ -147,6 +257,7 @@ static inline void fill_l4info(void *l4_hdr_start, void *data_end, u8 protocol,
         if ((void *)icmph + sizeof(*icmph) <= data_end) {
             l4_info->icmp_type = icmph->type;
             l4_info->icmp_code = icmph->code;
+            l4_info->payload = (void *)icmph + sizeof(*icmph);
         }
     } break;
     case IPPROTO_ICMPV6: {
@@ -154,6 +265,7 @@ static inline void fill_l4info(void *l4_hdr_start, void *data_end, u8 protocol,
          if ((void *)icmp6h + sizeof(*icmp6h) <= data_end) {
             l4_info->icmp_type = icmp6h->icmp6_type;
             l4_info->icmp_code = icmp6h->icmp6_code;
+            l4_info->payload = (void *)icmp6h + sizeof(*icmp6h);
         }
     } break;
     default:
@@ -162,7 +274,7 @@ static inline void fill_l4info(void *l4_hdr_start, void *data_end, u8 protocol,
 }
 
 // sets flow fields from IPv4 header information
-static inline int fill_iphdr(struct iphdr *ip, void *data_end, flow_id *id, u16 *flags) {
+static inline int fill_iphdr(struct iphdr *ip, void *data_end, flow_id *id, pkt_info *pkt) {
     struct l4_info_t l4_info;
     void *l4_hdr_start;
 
@@ -176,18 +288,31 @@ static inline int fill_iphdr(struct iphdr *ip, void *data_end, flow_id *id, u16
     __builtin_memcpy(id->src_ip + sizeof(ip4in6), &ip->saddr, sizeof(ip->saddr));
     __builtin_memcpy(id->dst_ip + sizeof(ip4in6), &ip->daddr, sizeof(ip->daddr));
     id->transport_protocol = ip->protocol;
-    fill_l4info(l4_hdr_start, data_end, ip->protocol, &l4_info);
+    pkt->ttl = ip->ttl;
+    u16 frag_off = bpf_ntohs(ip->frag_off);
+    if (frag_off & (IP_MF | IP_OFFSET)) {
+        pkt->frag_id = ip->id;
+        pkt->frag_type = (frag_off & IP_OFFSET) ? FRAG_NON_FIRST : FRAG_FIRST;
+    } else {
+        // all the fragments of a datagram share its identification
+        pkt->ip_id = bpf_ntohs(ip->id);
+    }
+    // non-first fragments don't carry the L4 header
+    if (pkt->frag_type != FRAG_NON_FIRST) {
+        fill_l4info(l4_hdr_start, data_end, ip->protocol, &l4_info);
+    }
     id->src_port = l4_info.src_port;
     id->dst_port = l4_info.dst_port;
     id->icmp_type = l4_info.icmp_type;
     id->icmp_code = l4_info.icmp_code;
-    *flags = l4_info.flags;
+    pkt->flags = l4_info.flags;
+    pkt->payload = l4_info.payload;
 
     return SUBMIT;
 }
 
 // sets flow fields from IPv6 header information
-static inline int fill_ip6hdr(struct ipv6hdr *ip, void *data_end, flow_id *id, u16 *flags) {
+static inline int fill_ip6hdr(struct ipv6hdr *ip, void *data_end, flow_id *id, pkt_info *pkt) {
     struct l4_info_t l4_info;
     void *l4_hdr_start;
 
@@ -199,17 +324,103 @@ static inline int fill_ip6hdr(struct ipv6hdr *ip, void *data_end, flow_id *id, u
     __builtin_memcpy(id->src_ip, ip->saddr.in6_u.u6_addr8, 16);
     __builtin_memcpy(id->dst_ip, ip->daddr.in6_u.u6_addr8, 16);
     id->transport_protocol = ip->nexthdr;
-    fill_l4info(l4_hdr_start, data_end, ip->nexthdr, &l4_info);
+    pkt->ttl = ip->hop_limit;
+    if (ip->nexthdr == NEXTHDR_FRAGMENT) {
+        struct frag_hdr *fh = l4_hdr_start;
+        if ((void *)fh + sizeof(*fh) > data_end) {
+            return DISCARD;
+        }
+        u16 frag_off = bpf_ntohs(fh->frag_off);
+        id->transport_protocol = fh->nexthdr;
+        pkt->frag_id = fh->identification;
+        pkt->frag_type = (frag_off & IP6_OFFSET) ? FRAG_NON_FIRST : FRAG_FIRST;
+        l4_hdr_start = (void *)fh + sizeof(*fh);
+    }
+    // non-first fragments don't carry the L4 header
+    if (pkt->frag_type != FRAG_NON_FIRST) {
+        fill_l4info(l4_hdr_start, data_end, id->transport_protocol, &l4_info);
+    }
     id->src_port = l4_info.src_port;
     id->dst_port = l4_info.dst_port;
     id->icmp_type = l4_info.icmp_type;
     id->icmp_code = l4_info.icmp_code;
-    *flags = l4_info.flags;
+    pkt->flags = l4_info.flags;
+    pkt->payload = l4_info.payload;
 
     return SUBMIT;
 }
+// sets the inner flow fields from the Ethernet frame encapsulated in a tunnel. The inner
+// attributes remain zero if the frame is truncated or doesn't carry an IP packet
+static inline void fill_inner_ethhdr(struct ethhdr *eth, void *data_end, flow_id *id) {
+    if ((void *)eth + sizeof(*eth) > data_end) {
+        return;
+    }
+    struct l4_info_t l4_info;
+    __builtin_memset(&l4_info, 0, sizeof(l4_info));
+    u16 eth_protocol = bpf_ntohs(eth->h_proto);
+    if (eth_protocol == ETH_P_IP) {
+        struct iphdr *ip = (void *)eth + sizeof(*eth);
+        if ((void *)ip + sizeof(*ip) > data_end) {
+            return;
+        }
+        __builtin_memcpy(id->inner_src_ip, ip4in6, sizeof(ip4in6));
+        __builtin_memcpy(id->inner_dst_ip, ip4in6, sizeof(ip4in6));
+        __builtin_memcpy(id->inner_src_ip + sizeof(ip4in6), &ip->saddr, sizeof(ip->saddr));
+        __builtin_memcpy(id->inner_dst_ip + sizeof(ip4in6), &ip->daddr, sizeof(ip->daddr));
+        id->inner_transport_protocol = ip->protocol;
+        // non-first fragments don't carry the L4 header
+        if (!(bpf_ntohs(ip->frag_off) & IP_OFFSET)) {
+            fill_l4info((void *)ip + sizeof(*ip), data_end, ip->protocol, &l4_info);
+        }
+    } else if (eth_protocol == ETH_P_IPV6) {
+        struct ipv6hdr *ip6 = (void *)eth + sizeof(*eth);
+        if ((void *)ip6 + sizeof(*ip6) > data_end) {
+            return;
+        }
+        __builtin_memcpy(id->inner_src_ip, ip6->saddr.in6_u.u6_addr8, 16);
+        __builtin_memcpy(id->inner_dst_ip, ip6->daddr.in6_u.u6_addr8, 16);
+        id->inner_transport_protocol = ip6->nexthdr;
+        fill_l4info((void *)ip6 + sizeof(*ip6), data_end, ip6->nexthdr, &l4_info);
+    } else {
+        return;
+    }
+    id->inner_src_port = l4_info.src_port;
+    id->inner_dst_port = l4_info.dst_port;
+}
+
+static inline u32 tunnel_vni(u8 *vni) {
+    return ((u32)vni[0] << 16) | ((u32)vni[1] << 8) | vni[2];
+}
+
+// sets the tunnel fields from the VXLAN or Geneve header at the start of the UDP payload, if
+// the destination port of the flow is the port of any of them
+static inline void fill_tunnel(void *udp_payload, void *data_end, flow_id *id) {
+    if (id->dst_port == VXLAN_PORT) {
+        vxlan_header *vxlan = udp_payload;
+        if ((void *)vxlan + sizeof(*vxlan) > data_end || !(vxlan->flags & 0x08)) {
+            return;
+        }
+        id->tunnel_type = TUNNEL_VXLAN;
+        id->tunnel_vni = tunnel_vni(vxlan->vni);
+        fill_inner_ethhdr((void *)vxlan + sizeof(*vxlan), data_end, id);
+    } else if (id->dst_port == GENEVE_PORT) {
+        geneve_header *geneve = udp_payload;
+        if ((void *)geneve + sizeof(*geneve) > data_end) {
+            return;
+        }
+        id->tunnel_type = TUNNEL_GENEVE;
+        id->tunnel_vni = tunnel_vni(geneve->vni);
+        // only the Ethernet payloads are parsed
+        if (bpf_ntohs(geneve->protocol_type) != ETH_P_TEB) {
+            return;
+        }
+        u32 opt_len = (geneve->ver_opt_len & 0x3f) * 4;
+        fill_inner_ethhdr((void *)geneve + sizeof(*geneve) + opt_len, data_end, id);
+    }
+}
+
 // sets flow fields from Ethernet header information
-static inline int fill_ethhdr(struct ethhdr *eth, void *data_end, flow_id *id, u16 *flags) {
+static inline int fill_ethhdr(struct ethhdr *eth, void *data_end, flow_id *id, pkt_info *pkt) {
     if ((void *)eth + sizeof(*eth) > data_end) {
         return DISCARD;
     }
@@ -217,12 +428,20 @@ static inline int fill_ethhdr(struct ethhdr *eth, void *data_end, flow_id *id, u
     __builtin_memcpy(id->src_mac, eth->h_source, ETH_ALEN);
     id->eth_protocol = bpf_ntohs(eth->h_proto);
 
-    if (id->eth_protocol == ETH_P_IP) {
-        struct iphdr *ip = (void *)eth + sizeof(*eth);
-        return fill_iphdr(ip, data_end, id, flags);
-    } else if (id->eth_protocol == ETH_P_IPV6) {
-        struct ipv6hdr *ip6 = (void *)eth + sizeof(*eth);
-        return fill_ip6hdr(ip6, data_end, id, flags);
+    if (id->eth_protocol == ETH_P_IP || id->eth_protocol == ETH_P_IPV6) {
+        int ret;
+        if (id->eth_protocol == ETH_P_IP) {
+            struct iphdr *ip = (void *)eth + sizeof(*eth);
+            ret = fill_iphdr(ip, data_end, id, pkt);
+        } else {
+            struct ipv6hdr *ip6 = (void *)eth + sizeof(*eth);
+            ret = fill_ip6hdr(ip6, data_end, id, pkt);
+        }
+        if (ret == SUBMIT && enable_tunnel_parsing && id->transport_protocol == IPPROTO_UDP &&
+            pkt->payload != NULL) {
+            fill_tunnel(pkt->payload, data_end, id);
+        }
+        return ret;
     } else {
         // TODO : Need to implement other specific ethertypes if needed
         // For now other parts of flow id remain zero
@@ -235,9 +454,236 @@ static inline int fill_ethhdr(struct ethhdr *eth, void *data_end, flow_id *id, u
     return SUBMIT;
 }
 
+// returns whether the payload of the flow must be sampled, according to the user configuration
+static inline bool payload_sample_matches(flow_id *id) {
+    if (payload_sample_protocol != 0 && id->transport_protocol != payload_sample_protocol) {
+        return false;
+    }
+    if (payload_sample_port != 0 && id->src_port != payload_sample_port &&
+        id->dst_port != payload_sample_port) {
+        return false;
+    }
+    return true;
+}
+
+// copies the first bytes of the packet's L4 payload into the flow metrics, if payload sampling
+// is enabled for the flow and no payload has been sampled before
+static inline void sample_payload(struct __sk_buff *skb, void *data, pkt_info *pkt, flow_id *id,
+                                  flow_metrics *metrics) {
+    if (payload_sample_bytes == 0 || metrics->payload_sample_len != 0 || pkt->payload == NULL ||
+        !payload_sample_matches(id)) {
+        return;
+    }
+    u32 offset = pkt->payload - data;
+    if (offset >= skb->len) {
+        // packet without payload (e.g. TCP handshake)
+        return;
+    }
+    // 64 bits, so the verifier tracks the bounds checks below on the same register that is
+    // passed to bpf_skb_load_bytes, instead of on a zero-extended copy
+    u64 len = skb->len - offset;
+    if (len > payload_sample_bytes) {
+        len = payload_sample_bytes;
+    }
+    // strictly enforce the maximum length, whatever the user-provided configuration is
+    if (len == 0 || len > MAX_PAYLOAD_SAMPLE) {
+        return;
+    }
+    if (bpf_skb_load_bytes(skb, offset, metrics->payload_sample, len) == 0) {
+        metrics->payload_sample_len = len;
+    }
+}
+
+static inline void fill_frag_key(flow_id *id, pkt_info *pkt, frag_key *key) {
+    __builtin_memcpy(key->src_ip, id->src_ip, IP_MAX_LEN);
+    __builtin_memcpy(key->dst_ip, id->dst_ip, IP_MAX_LEN);
+    key->ip_id = pkt->frag_id;
+    key->if_index = id->if_index;
+    key->transport_protocol = id->transport_protocol;
+    key->direction = id->direction;
+}
+
+// attributes a non-first fragment to the flow of the first fragment of its datagram, by setting
+// the L4 ports into the flow identifier. If the first fragment hasn't been observed yet (out of
+// order fragments), the fragment is kept as pending in the fragments map and DISCARD is returned
+static inline int resolve_fragment(flow_id *id, pkt_info *pkt, u32 len, u64 now) {
+    frag_key key;
+    __builtin_memset(&key, 0, sizeof(key));
+    fill_frag_key(id, pkt, &key);
+    frag_info *info = bpf_map_lookup_elem(&fragments, &key);
+    if (info != NULL) {
+        info->last_seen_ts = now;
+        if (info->first_seen) {
+            id->src_port = info->id.src_port;
+            id->dst_port = info->id.dst_port;
+            // the tunnel headers are only carried by the first fragment too
+            id->tunnel_type = info->id.tunnel_type;
+            id->tunnel_vni = info->id.tunnel_vni;
+            __builtin_memcpy(id->inner_src_ip, info->id.inner_src_ip, IP_MAX_LEN);
+            __builtin_memcpy(id->inner_dst_ip, info->id.inner_dst_ip, IP_MAX_LEN);
+            id->inner_src_port = info->id.inner_src_port;
+            id->inner_dst_port = info->id.inner_dst_port;
+            id->inner_transport_protocol = info->id.inner_transport_protocol;
+            return SUBMIT;
+        }
+        info->pending_packets += 1;
+        info->pending_bytes += len;
+        return DISCARD;
+    }
+    frag_info new_info;
+    __builtin_memset(&new_info, 0, sizeof(new_info));
+    new_info.id = *id;
+    new_info.last_seen_ts = now;
+    new_info.pending_packets = 1;
+    new_info.pending_bytes = len;
+    new_info.pending_start_ts = now;
+    bpf_map_update_elem(&fragments, &key, &new_info, BPF_ANY);
+    return DISCARD;
+}
+
+// registers the first fragment of a datagram, so the next fragments can be attributed to its flow.
+// If some fragments of the datagram were observed before, they are returned as pending to be
+// accounted in the flow
+static inline void register_first_fragment(flow_id *id, pkt_info *pkt, u64 now,
+                                           frag_pending *pending) {
+    frag_key key;
+    __builtin_memset(&key, 0, sizeof(key));
+    fill_frag_key(id, pkt, &key);
+    frag_info *info = bpf_map_lookup_elem(&fragments, &key);
+    if (info != NULL) {
+        if (!info->first_seen) {
+            pending->packets = info->pending_packets;
+            pending->bytes = info->pending_bytes;
+            pending->start_ts = info->pending_start_ts;
+        }
+        info->id = *id;
+        info->first_seen = 1;
+        info->last_seen_ts = now;
+        info->pending_packets = 0;
+        info->pending_bytes = 0;
+        return;
+    }
+    frag_info new_info;
+    __builtin_memset(&new_info, 0, sizeof(new_info));
+    new_info.id = *id;
+    new_info.first_seen = 1;
+    new_info.last_seen_ts = now;
+    bpf_map_update_elem(&fragments, &key, &new_info, BPF_ANY);
+}
+
+// accounts the packet size in the histogram of the flow
+static inline void count_pkt_size(flow_metrics *metrics, u32 len) {
+    if (len <= pkt_size_bound_0) {
+        metrics->pkt_size_buckets[0]++;
+    } else if (len <= pkt_size_bound_1) {
+        metrics->pkt_size_buckets[1]++;
+    } else if (len <= pkt_size_bound_2) {
+        metrics->pkt_size_buckets[2]++;
+    } else {
+        metrics->pkt_size_buckets[3]++;
+    }
+}
+
+// accounts the time since the previous packet of the flow in its inter-packet gap range
+static inline void track_ipg(flow_metrics *metrics, u64 now) {
+    // another CPU might have accounted a later packet concurrently
+    u64 gap = now > metrics->end_mono_time_ts ? now - metrics->end_mono_time_ts : 0;
+    if (gap < metrics->min_ipg) {
+        metrics->min_ipg = gap;
+    }
+    if (gap > metrics->max_ipg) {
+        metrics->max_ipg = gap;
+    }
+}
+
+// counts the packet as a duplicate if its IPv4 identification matches any of the last packets of
+// the flow, and remembers it otherwise. The packets with a zero identification are ignored, as
+// some stacks don't set it for the packets that can't be fragmented
+static inline void track_ip_id(flow_metrics *metrics, u16 ip_id) {
+    if (!enable_ip_id_tracking || ip_id == 0) {
+        return;
+    }
+    for (int i = 0; i < IP_ID_HISTORY; i++) {
+        if (metrics->recent_ip_ids[i] == ip_id) {
+            metrics->duplicate_packets++;
+            return;
+        }
+    }
+    u8 next = metrics->recent_ip_ids_next % IP_ID_HISTORY;
+    metrics->recent_ip_ids[next] = ip_id;
+    metrics->recent_ip_ids_next = (next + 1) % IP_ID_HISTORY;
+}
+
+// tracks the TCP handshakes to measure the time between a SYN and the SYN-ACK answering it.
+// Returns the latency if the packet is a SYN-ACK whose SYN was observed, 0 otherwise
+static inline u64 track_handshake(flow_id *id, pkt_info *pkt, u64 now) {
+    if (id->transport_protocol != IPPROTO_TCP) {
+        return 0;
+    }
+    handshake_key key;
+    __builtin_memset(&key, 0, sizeof(key));
+    key.if_index = id->if_index;
+    if (pkt->flags & SYN_FLAG) {
+        __builtin_memcpy(key.src_ip, id->src_ip, IP_MAX_LEN);
+        __builtin_memcpy(key.dst_ip, id->dst_ip, IP_MAX_LEN);
+        key.src_port = id->src_port;
+        key.dst_port = id->dst_port;
+        // a retransmitted SYN overrides the previous one, so the latency is measured
+        // from the SYN that is actually answered
+        bpf_map_update_elem(&tcp_handshakes, &key, &now, BPF_ANY);
+        return 0;
+    }
+    if (pkt->flags & SYN_ACK_FLAG) {
+        // the SYN-ACK goes from the server to the client
+        __builtin_memcpy(key.src_ip, id->dst_ip, IP_MAX_LEN);
+        __builtin_memcpy(key.dst_ip, id->src_ip, IP_MAX_LEN);
+        key.src_port = id->dst_port;
+        key.dst_port = id->src_port;
+        u64 *syn_ts = bpf_map_lookup_elem(&tcp_handshakes, &key);
+        if (syn_ts == NULL) {
+            return 0;
+        }
+        u64 latency = now > *syn_ts ? now - *syn_ts : 0;
+        bpf_map_delete_elem(&tcp_handshakes, &key);
+        return latency;
+    }
+    return 0;
+}
+
+// admit_map_full tells whether a flow that couldn't be added to the full aggregated flows map
+// must be submitted to the ring buffer, according to the map_full_sampling ratio. Sampling them
+// here saves the ring buffer and userspace load, instead of discarding them after submission
+static inline bool admit_map_full() {
+    if (map_full_sampling <= 1) {
+        return true;
+    }
+    u32 key = 0;
+    u64 *count = bpf_map_lookup_elem(&map_full_flows, &key);
+    if (count == NULL) {
+        return true;
+    }
+    // concurrent CPUs might read the same count, which only makes the sampling approximate
+    u64 seen = *count;
+    __sync_fetch_and_add(count, 1);
+    return seen % map_full_sampling == 0;
+}
+
 static inline int flow_monitor(struct __sk_buff *skb, u8 direction) {
     // If sampling is defined, will only parse 1 out of "sampling" flows
-    if (sampling != 0 && (bpf_get_prandom_u32() % sampling) != 0) {
+    u32 rate = sampling;
+    u32 override_key = 0;
+    u32 *override = bpf_map_lookup_elem(&sampling_override, &override_key);
+    if (override != NULL && *override != 0) {
+        rate = *override;
+    }
+    if (rate != 0 && (bpf_get_prandom_u32() % rate) != 0) {
+        return TC_ACT_OK;
+    }
+    // Under pressure, will only parse 1 out of 2^level packets
+    u32 pressure_key = 0;
+    u32 *level = bpf_map_lookup_elem(&pressure, &pressure_key);
+    if (level != NULL && *level > 0 && *level < 32 &&
+        (bpf_get_prandom_u32() & ((1U << *level) - 1)) != 0) {
         return TC_ACT_OK;
     }
     void *data_end = (void *)(long)skb->data_end;
@@ -247,21 +693,65 @@ static inline int flow_monitor(struct __sk_buff *skb, u8 direction) {
     __builtin_memset(&id, 0, sizeof(id));
     u64 current_time = bpf_ktime_get_ns();
     struct ethhdr *eth = data;
-    u16 flags = 0;
-    if (fill_ethhdr(eth, data_end, &id, &flags) == DISCARD) {
+    pkt_info pkt;
+    __builtin_memset(&pkt, 0, sizeof(pkt));
+    if (fill_ethhdr(eth, data_end, &id, &pkt) == DISCARD) {
         return TC_ACT_OK;
     }
     id.if_index = skb->ifindex;
     id.direction = direction;
 
+    if (enable_fragments && pkt.frag_type == FRAG_NON_FIRST &&
+        resolve_fragment(&id, &pkt, skb->len, current_time) == DISCARD) {
+        // the fragment will be accounted once the first fragment is observed, or
+        // evicted from userspace on timeout
+        return TC_ACT_OK;
+    }
+    frag_pending pending;
+    __builtin_memset(&pending, 0, sizeof(pending));
+    if (enable_fragments && pkt.frag_type == FRAG_FIRST) {
+        register_first_fragment(&id, &pkt, current_time, &pending);
+    }
+    u32 fragmented = pending.packets;
+    if (pkt.frag_type != FRAG_NONE) {
+        fragmented += 1;
+    }
+    u64 connect_latency = 0;
+    if (enable_connect_latency) {
+        connect_latency = track_handshake(&id, &pkt, current_time);
+    }
+
     // TODO: we need to add spinlock here when we deprecate versions prior to 5.1, or provide
     // a spinlocked alternative version and use it selectively https://lwn.net/Articles/779120/
     flow_metrics *aggregate_flow = bpf_map_lookup_elem(&aggregated_flows, &id);
     if (aggregate_flow != NULL) {
-        aggregate_flow->packets += 1;
-        aggregate_flow->bytes += skb->len;
+        aggregate_flow->packets += 1 + pending.packets;
+        aggregate_flow->bytes += skb->len + pending.bytes;
+        track_ipg(aggregate_flow, current_time);
+        track_ip_id(aggregate_flow, pkt.ip_id);
         aggregate_flow->end_mono_time_ts = current_time;
-        aggregate_flow->flags |= flags;
+        aggregate_flow->fragmented_packets += fragmented;
+        count_pkt_size(aggregate_flow, skb->len);
+        if (pending.packets > 0 && pending.start_ts < aggregate_flow->start_mono_time_ts) {
+            aggregate_flow->start_mono_time_ts = pending.start_ts;
+        }
+        aggregate_flow->flags |= pkt.flags;
+        if (connect_latency != 0) {
+            aggregate_flow->server_connect_latency = connect_latency;
+        }
+        if (aggregate_flow->cgroup_id == 0) {
+            aggregate_flow->cgroup_id = bpf_skb_cgroup_id(skb);
+        }
+        if (aggregate_flow->socket_cookie == 0) {
+            aggregate_flow->socket_cookie = bpf_get_socket_cookie(skb);
+        }
+        if (pkt.ttl < aggregate_flow->min_ttl) {
+            aggregate_flow->min_ttl = pkt.ttl;
+        }
+        if (pkt.ttl > aggregate_flow->max_ttl) {
+            aggregate_flow->max_ttl = pkt.ttl;
+        }
+        sample_payload(skb, data, &pkt, &id, aggregate_flow);
         long ret = bpf_map_update_elem(&aggregated_flows, &id, aggregate_flow, BPF_ANY);
         if (trace_messages && ret != 0) {
             // usually error -16 (-EBUSY) is printed here.
@@ -273,13 +763,23 @@ static inline int flow_monitor(struct __sk_buff *skb, u8 direction) {
         }
     } else {
         // Key does not exist in the map, and will need to create a new entry.
-        flow_metrics new_flow = {
-            .packets = 1,
-            .bytes = skb->len,
-            .start_mono_time_ts = current_time,
-            .end_mono_time_ts = current_time,
-            .flags = flags, 
-        };
+        flow_metrics new_flow;
+        __builtin_memset(&new_flow, 0, sizeof(new_flow));
+        new_flow.packets = 1 + pending.packets;
+        new_flow.bytes = skb->len + pending.bytes;
+        new_flow.fragmented_packets = fragmented;
+        count_pkt_size(&new_flow, skb->len);
+        new_flow.start_mono_time_ts = pending.packets > 0 ? pending.start_ts : current_time;
+        new_flow.end_mono_time_ts = current_time;
+        new_flow.flags = pkt.flags;
+        new_flow.server_connect_latency = connect_latency;
+        new_flow.cgroup_id = bpf_skb_cgroup_id(skb);
+        new_flow.socket_cookie = bpf_get_socket_cookie(skb);
+        new_flow.min_ttl = pkt.ttl;
+        new_flow.max_ttl = pkt.ttl;
+        new_flow.min_ipg = IPG_UNSET;
+        track_ip_id(&new_flow, pkt.ip_id);
+        sample_payload(skb, data, &pkt, &id, &new_flow);
 
         // even if we know that the entry is new, another CPU might be concurrently inserting a flow
         // so we need to specify BPF_ANY
@@ -294,6 +794,9 @@ static inline int flow_monitor(struct __sk_buff *skb, u8 direction) {
                 bpf_printk("error adding flow %d\n", ret);
             }
 
+            if (ret == -E2BIG && !admit_map_full()) {
+                return TC_ACT_OK;
+            }
             new_flow.errno = -ret;
             flow_record *record = bpf_ringbuf_reserve(&direct_flows, sizeof(flow_record), 0);
             if (!record) {
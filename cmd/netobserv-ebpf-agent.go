@@ -53,6 +53,22 @@ func main() {
 		<-stopper
 		canceler()
 	}()
+	if config.FlushOnSignal {
+		// Subscribe to signals for forcing the export of the cached flows.
+		go func() {
+			flusher := make(chan os.Signal, 1)
+			signal.Notify(flusher, syscall.SIGUSR1)
+			for {
+				select {
+				case <-flusher:
+					flowsAgent.Flush()
+				case <-ctx.Done():
+					signal.Stop(flusher)
+					return
+				}
+			}
+		}()
+	}
 	if err := flowsAgent.Run(ctx); err != nil {
 		logrus.WithError(err).Fatal("can't start netobserv-ebpf-agent")
 	}
@@ -5,7 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/cilium/ebpf/ringbuf"
@@ -15,13 +22,51 @@ import (
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/exporter"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ifaces"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
 	kafkago "github.com/segmentio/kafka-go"
 	"github.com/segmentio/kafka-go/compress"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
 )
 
 var alog = logrus.WithField("component", "agent.Flows")
 
+// defaultLowWatermarkRatio is the ratio of the memory high watermark that is used as low
+// watermark when the latter is not specified
+const defaultLowWatermarkRatio = 0.8
+
+// schemaRegistryTimeout is the maximum time to wait for the Kafka schema registry responses
+const schemaRegistryTimeout = 10 * time.Second
+
+// promRemoteWriteTimeout is the maximum time to wait for the Prometheus remote-write responses
+const promRemoteWriteTimeout = 10 * time.Second
+
+// localAddressesRefresh is how often the node addresses are listed again by the heuristic
+// direction inference
+const localAddressesRefresh = time.Minute
+
+// elasticsearchTimeout is the maximum time to wait for the Elasticsearch responses
+const elasticsearchTimeout = 30 * time.Second
+
+// pubsubTimeout is the maximum time to wait for the Pub/Sub and Google authentication responses
+const pubsubTimeout = 30 * time.Second
+
+// maxBackpressureLevel is the highest pressure level that can be notified to the eBPF program,
+// which shifts a 32-bit random number by the level
+const maxBackpressureLevel = 31
+
+// samplingScheduleInterval is how often the sampling schedule is checked. The schedule has a
+// granularity of minutes, so the sampling rate changes up to this interval after a boundary.
+const samplingScheduleInterval = 10 * time.Second
+
+// completedFirstMinDivisor divides the cache size to get the minimum number of completed flows
+// that must be evicted when the map is full, before falling back to evicting all the flows
+const completedFirstMinDivisor = 10
+
+// quicPort is the port whose UDP payload is sampled to track the QUIC connections, when the
+// payload sampling is not configured by the user
+const quicPort = 443
+
 // Status of the agent service. Helps on the health report as well as making some asynchronous
 // tests waiting for the agent to accept flows.
 type Status int
@@ -59,16 +104,51 @@ type Flows struct {
 	interfaces ifaces.Informer
 	filter     interfaceFilter
 	ebpf       ebpfFlowFetcher
+	// ifaceLimiter is nil if the number of attached interfaces is not limited
+	ifaceLimiter *interfaceLimiter
 
 	// processing nodes to be wired in the buildAndStartPipeline method
 	mapTracer *flow.MapTracer
+	// rbTracer and accounter are nil if the kernel doesn't support ring buffers
 	rbTracer  *flow.RingBufTracer
-	accounter *flow.Accounter
+	accounter flowAccounter
 	exporter  node.TerminalFunc[[]*flow.Record]
+	// stopExports aborts the running exports when the agent stops. It is nil in tests
+	stopExports context.CancelFunc
+	// ebpfWriters waits for the goroutines that write into the eBPF maps, so they are done
+	// before the eBPF objects are closed
+	ebpfWriters sync.WaitGroup
+	// liveFeed is nil if the flows are not streamed to WebSocket clients
+	liveFeed node.TerminalFunc[[]*flow.Record]
+	// samplingSchedule is nil if the sampling rate doesn't follow a schedule
+	samplingSchedule *flow.SamplingSchedule
+	// traffic classes that are forwarded and discarded by the traffic class filter, if any
+	trafficClasses        []flow.TrafficClass
+	excludeTrafficClasses []flow.TrafficClass
+	// inferDirection is nil if the direction reported by the kernel is kept
+	inferDirection func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	// prefixKey is nil if the flows are not aggregated by network prefix
+	prefixKey func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	// sortRecords is nil if the exported batches are not sorted
+	sortRecords func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	// heartbeat is nil if no heartbeat records are emitted
+	heartbeat func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	// dedupPreferred tells whether the deduper prefers the flows of an interface. It is nil if
+	// no interface is preferred
+	dedupPreferred func(ifIndex uint32) bool
+	// dedupPurges is nil if the deduper entries of the removed interfaces are not purged
+	dedupPurges flow.DeduperPurges
+	// exportTraffic is nil if the traffic to the collectors is not dropped
+	exportTraffic *flow.ExportTrafficFilter
+	// rawDump is nil if the raw ring buffer events are not recorded
+	rawDump *flow.RawRecordDumper
+	// flushRemovedIface is nil if the flows of the removed interfaces are not flushed
+	flushRemovedIface func(ifIndex uint32)
+
+	// chain of enrichers used to decorate flows with extra information
+	enrichers []flow.Enricher
 
-	// elements used to decorate flows with extra information
-	interfaceNamer flow.InterfaceNamer
-	agentIP        net.IP
+	metrics *metrics.Metrics
 
 	status Status
 }
@@ -79,12 +159,35 @@ type ebpfFlowFetcher interface {
 	Register(iface ifaces.Interface) error
 
 	LookupAndDeleteMap() map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	LookupAndDeleteStartedBefore(monoTs uint64) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	LookupAndDeleteMatching(
+		match func(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool,
+	) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	ringBufReader
+	// RingBufEnabled is false if the kernel doesn't support ring buffers, so the flows can only
+	// be read from the map
+	RingBufEnabled() bool
+	SetPressureLevel(level uint32) error
+	SetSamplingRate(rate uint32) error
+}
+
+// flowAccounter accumulates in userspace the flows that are read from the ring buffer. It is
+// implemented by flow.Accounter and flow.SplitAccounter
+type flowAccounter interface {
+	Account(in <-chan *flow.RawRecord, out chan<- []*flow.Record)
+	Flush()
+}
+
+// ringBufReader reads the flow events from the eBPF ring buffer
+type ringBufReader interface {
 	ReadRingBuf() (ringbuf.Record, error)
 }
 
 // FlowsAgent instantiates a new agent, given a configuration.
-func FlowsAgent(cfg *Config) (*Flows, error) {
-	alog.Info("initializing Flows agent")
+func FlowsAgent(cfg *Config) (flows *Flows, err error) {
+	alog.WithFields(logrus.Fields{
+		"version": agentVersion(), "bpfProgHash": ebpf.ProgramHash(),
+	}).Info("initializing Flows agent")
 
 	// configure informer for new interfaces
 	var informer ifaces.Informer
@@ -109,8 +212,17 @@ func FlowsAgent(cfg *Config) (*Flows, error) {
 	}
 	alog.Debug("agent IP: " + agentIP.String())
 
-	// configure selected exporter
-	exportFunc, err := buildFlowExporter(cfg)
+	m := metrics.NewMetrics(cfg.MetricsPrefix)
+
+	// configure selected exporter. Its context is canceled when the agent stops, so the exports
+	// that are being retried don't delay the shutdown
+	exportCtx, stopExports := context.WithCancel(context.Background())
+	defer func() {
+		if err != nil {
+			stopExports()
+		}
+	}()
+	exportFunc, err := buildFlowExporter(exportCtx, cfg, m)
 	if err != nil {
 		return nil, err
 	}
@@ -122,16 +234,61 @@ func FlowsAgent(cfg *Config) (*Flows, error) {
 		debug = true
 	}
 
-	fetcher, err := ebpf.NewFlowFetcher(debug, cfg.Sampling, cfg.CacheMaxFlows, ingress, egress)
+	payloadProto, err := payloadSampleProtocol(cfg.PayloadSampleProtocol)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PayloadSamplePort < 0 || cfg.PayloadSamplePort > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid PAYLOAD_SAMPLE_PORT: %d", cfg.PayloadSamplePort)
+	}
+	payloadBytes, payloadPort := cfg.PayloadSampleBytes, uint16(cfg.PayloadSamplePort)
+	if cfg.EnableQUICTracking && payloadBytes == 0 {
+		// the payload is only sampled to parse the QUIC long headers
+		payloadBytes, payloadProto, payloadPort = flow.QUICLongHeaderMaxLen, syscall.IPPROTO_UDP, quicPort
+	}
+	pktSizeBounds, err := packetSizeBounds(cfg.PacketSizeBuckets)
+	if err != nil {
+		return nil, err
+	}
+	// the flows that don't fit in the full map are discarded by the eBPF program, before they
+	// are submitted to the ring buffer
+	mapFullPolicy, err := parseMapFullPolicy(cfg.MapFullPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher, err := ebpf.NewFlowFetcher(&ebpf.FlowFetcherConfig{
+		EnableIngress:         ingress,
+		EnableEgress:          egress,
+		Debug:                 debug,
+		Sampling:              cfg.Sampling,
+		CacheMaxSize:          cfg.CacheMaxFlows,
+		PayloadSampleBytes:    payloadBytes,
+		PayloadSampleProtocol: payloadProto,
+		PayloadSamplePort:     payloadPort,
+		PacketSizeBounds:      pktSizeBounds,
+		FragmentTimeout:       cfg.FragmentTimeout,
+		EnableConnectLatency:  cfg.EnableConnectLatency,
+		EnableFragments:       cfg.EnableFragments,
+		EnableTunnelParsing:   cfg.EnableTunnelParsing,
+		EnableIPIDTracking:    cfg.EnableIPIDTracking,
+		MapFullSampling:       mapFullPolicy.KernelSampling(cfg.MapFullSampling),
+		RingBufFallback:       cfg.RingBufFallback,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return flowsAgent(cfg, informer, fetcher, exportFunc, agentIP)
+	flows, err = flowsAgent(cfg, m, informer, fetcher, exportFunc, agentIP)
+	if err != nil {
+		return nil, err
+	}
+	flows.stopExports = stopExports
+	return flows, nil
 }
 
 // flowsAgent is a private constructor with injectable dependencies, usable for tests
-func flowsAgent(cfg *Config,
+func flowsAgent(cfg *Config, m *metrics.Metrics,
 	informer ifaces.Informer,
 	fetcher ebpfFlowFetcher,
 	exporter node.TerminalFunc[[]*flow.Record],
@@ -143,7 +300,7 @@ func flowsAgent(cfg *Config,
 		return nil, fmt.Errorf("configuring interface filters: %w", err)
 	}
 
-	registerer := ifaces.NewRegisterer(informer, cfg.BuffersLength)
+	registerer := ifaces.NewRegisterer(informer, cfg.BuffersLength, cfg.InterfaceCacheTTL)
 
 	interfaceNamer := func(ifIndex int) string {
 		iface, ok := registerer.IfaceNameForIndex(ifIndex)
@@ -153,24 +310,363 @@ func flowsAgent(cfg *Config,
 		return iface
 	}
 
-	mapTracer := flow.NewMapTracer(fetcher, cfg.CacheActiveTimeout)
-	rbTracer := flow.NewRingBufTracer(fetcher, mapTracer, cfg.CacheActiveTimeout)
-	accounter := flow.NewAccounter(
-		cfg.CacheMaxFlows, cfg.CacheActiveTimeout, time.Now, monotime.Now)
+	enricherNames := cfg.Enrichers
+	if len(enricherNames) == 0 {
+		enricherNames = flow.DefaultEnrichers
+	}
+	if cfg.EnableReverseDNS && !containsString(enricherNames, flow.EnricherReverseDNS) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherReverseDNS)
+	}
+	if cfg.EnableProcessInfo && !containsString(enricherNames, flow.EnricherProcess) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherProcess)
+	}
+	if cfg.EnableContainerInfo && !containsString(enricherNames, flow.EnricherContainer) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherContainer)
+	}
+	if cfg.EnableNextHop && !containsString(enricherNames, flow.EnricherNextHop) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherNextHop)
+	}
+	if cfg.EnableQUICTracking && !containsString(enricherNames, flow.EnricherQUIC) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherQUIC)
+	}
+	if cfg.SubnetLabelsFile != "" && !containsString(enricherNames, flow.EnricherSubnetLabels) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherSubnetLabels)
+	}
+	if cfg.GeoIPDatabase != "" && !containsString(enricherNames, flow.EnricherGeoIP) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherGeoIP)
+	}
+	if cfg.EnableKubernetesMetadata && !containsString(enricherNames, flow.EnricherKubernetes) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherKubernetes)
+	}
+	if (cfg.ClusterID != "" || cfg.TenantID != "") &&
+		!containsString(enricherNames, flow.EnricherIdentity) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherIdentity)
+	}
+	if cfg.TagBuildInfo && !containsString(enricherNames, flow.EnricherBuildInfo) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherBuildInfo)
+	}
+	if cfg.QualifyInterfaces && !containsString(enricherNames, flow.EnricherInterfaceID) {
+		enricherNames = append(enricherNames[:len(enricherNames):len(enricherNames)],
+			flow.EnricherInterfaceID)
+	}
+	nodeName := cfg.NodeName
+	if nodeName == "" && containsString(enricherNames, flow.EnricherInterfaceID) {
+		if nodeName, err = os.Hostname(); err != nil {
+			alog.WithError(err).Warn("can't get the hostname to use it as node name")
+		}
+	}
+	ports, err := servicePorts(cfg.ServicePorts)
+	if err != nil {
+		return nil, err
+	}
+	enrichers, err := flow.NewEnrichers(enricherNames, &flow.EnricherContext{
+		AgentIP:        agentIP,
+		InterfaceNamer: interfaceNamer,
+		ServicePorts:   ports,
+		ClusterID:      cfg.ClusterID,
+		TenantID:       cfg.TenantID,
+		AgentVersion:   agentVersion(),
+		BpfProgHash:    ebpf.ProgramHash(),
+		NodeName:       nodeName,
+		ReverseDNS: &flow.ReverseDNSConfig{
+			CacheTTL:      cfg.ReverseDNSCacheTTL,
+			MaxEntries:    cfg.ReverseDNSMaxEntries,
+			LookupsPerSec: cfg.ReverseDNSLookupsPerSec,
+		},
+		SubnetLabels: &flow.SubnetLabelsConfig{
+			Path:         cfg.SubnetLabelsFile,
+			ReloadPeriod: cfg.SubnetLabelsReloadPeriod,
+		},
+		QUIC: &flow.QUICConfig{
+			DropPayloadSample: cfg.EnableQUICTracking && cfg.PayloadSampleBytes == 0,
+		},
+		GeoIPDatabase: cfg.GeoIPDatabase,
+		Kubeconfig:    cfg.Kubeconfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("configuring enrichers: %w", err)
+	}
+
+	breaker, err := memoryBreaker(cfg, m)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.ThresholdMatch {
+	case "", ThresholdAny, ThresholdAll:
+	default:
+		return nil, fmt.Errorf("invalid THRESHOLD_MATCH %q. Accepted values are %s, %s",
+			cfg.ThresholdMatch, ThresholdAny, ThresholdAll)
+	}
+	switch cfg.MaxExportRateMode {
+	case "", ExportRateModeDrop, ExportRateModeDelay:
+	default:
+		return nil, fmt.Errorf("invalid MAX_EXPORT_RATE_MODE %q. Accepted values are %s, %s",
+			cfg.MaxExportRateMode, ExportRateModeDrop, ExportRateModeDelay)
+	}
+	switch cfg.TopNTalkersBy {
+	case "", TopTalkersBytes, TopTalkersPkts:
+	default:
+		return nil, fmt.Errorf("invalid TOP_N_TALKERS_BY %q. Accepted values are %s, %s",
+			cfg.TopNTalkersBy, TopTalkersBytes, TopTalkersPkts)
+	}
+
+	mapFullPolicy, err := parseMapFullPolicy(cfg.MapFullPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	completedFirstMin := 0
+	switch cfg.MapFullEviction {
+	case "", MapFullEvictAll:
+	case MapFullEvictCompletedFirst:
+		completedFirstMin = cfg.CacheMaxFlows / completedFirstMinDivisor
+		if completedFirstMin < 1 {
+			completedFirstMin = 1
+		}
+	default:
+		return nil, fmt.Errorf("invalid MAP_FULL_EVICTION %q. Accepted values are %s, %s",
+			cfg.MapFullEviction, MapFullEvictAll, MapFullEvictCompletedFirst)
+	}
+
+	var ifaceLimiter *interfaceLimiter
+	switch cfg.MaxInterfacesPolicy {
+	case "", InterfacesPolicyName, InterfacesPolicyTraffic:
+		if cfg.MaxInterfaces > 0 {
+			ifaceLimiter = newInterfaceLimiter(cfg.MaxInterfaces,
+				cfg.MaxInterfacesPolicy == InterfacesPolicyTraffic, m)
+		}
+	default:
+		return nil, fmt.Errorf("invalid MAX_INTERFACES_POLICY %q. Accepted values are %s, %s",
+			cfg.MaxInterfacesPolicy, InterfacesPolicyName, InterfacesPolicyTraffic)
+	}
+
+	if cfg.EnableBackpressure &&
+		(cfg.BackpressureMaxLevel < 1 || cfg.BackpressureMaxLevel > maxBackpressureLevel) {
+		return nil, fmt.Errorf("invalid BACKPRESSURE_MAX_LEVEL %d. It must be between 1 and %d",
+			cfg.BackpressureMaxLevel, maxBackpressureLevel)
+	}
+	if cfg.MergeICMPEcho && cfg.MergeICMPEchoTimeout <= 0 {
+		return nil, fmt.Errorf("invalid MERGE_ICMP_ECHO_TIMEOUT %s. It must be positive",
+			cfg.MergeICMPEchoTimeout)
+	}
+
+	samplingSchedule, err := samplingSchedule(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	trafficClasses, err := flow.ParseTrafficClasses(cfg.TrafficClasses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRAFFIC_CLASSES: %w", err)
+	}
+	excludeTrafficClasses, err := flow.ParseTrafficClasses(cfg.ExcludeTrafficClasses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXCLUDE_TRAFFIC_CLASSES: %w", err)
+	}
+
+	var prefixKey func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	aggPrefixes := flow.AggPrefixes{
+		SrcV4: cfg.SrcAggPrefix, DstV4: cfg.DstAggPrefix,
+		SrcV6: cfg.SrcAggPrefixV6, DstV6: cfg.DstAggPrefixV6,
+	}
+	if err := aggPrefixes.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid aggregation prefixes: %w", err)
+	}
+	if aggPrefixes.Enabled() {
+		prefixKey = flow.KeyByPrefix(aggPrefixes)
+	}
+
+	var inferDirection func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	if cfg.DirectionInference != "" && cfg.DirectionInference != flow.DirectionInferenceKernel {
+		inferDirection, err = flow.InferDirection(cfg.DirectionInference,
+			flow.NewLocalAddresses(interfaceAddrs, localAddressesRefresh, time.Now))
+		if err != nil {
+			return nil, fmt.Errorf("invalid DIRECTION_INFERENCE: %w", err)
+		}
+	}
+
+	var dedupPreferred func(ifIndex uint32) bool
+	if len(cfg.DeduperPreferInterfaces) > 0 {
+		preferFilter, err := initInterfaceFilter(cfg.DeduperPreferInterfaces, nil)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DEDUPER_PREFER_INTERFACES: %w", err)
+		}
+		dedupPreferred = func(ifIndex uint32) bool {
+			name, ok := registerer.IfaceNameForIndex(int(ifIndex))
+			return ok && preferFilter.Allowed(name)
+		}
+	}
+
+	var dedupPurges flow.DeduperPurges
+	if cfg.Deduper == DeduperFirstCome && cfg.DeduperPurgeOnFlap {
+		dedupPurges = flow.NewDeduperPurges()
+	}
+
+	var exportTraffic *flow.ExportTrafficFilter
+	if cfg.DropExportTraffic {
+		endpoints, err := collectorEndpoints(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collector endpoints for DROP_EXPORT_TRAFFIC: %w", err)
+		}
+		exportTraffic = flow.NewExportTrafficFilter(
+			endpoints, nil, cfg.DropExportTrafficRefresh, time.Now, m)
+	}
+
+	var sortRecords func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	if len(cfg.ExportSortKeys) > 0 {
+		sorter, err := flow.NewRecordSorter(cfg.ExportSortKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXPORT_SORT_KEYS: %w", err)
+		}
+		sortRecords = sorter.Sort
+	}
+
+	var heartbeat func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+	if cfg.HeartbeatInterval > 0 {
+		heartbeat = flow.Heartbeats(cfg.HeartbeatInterval, func() *flow.Record {
+			return flow.NewHeartbeat(time.Now(), agentIP, cfg.ClusterID, cfg.TenantID)
+		})
+	}
+
+	timeouts, err := protocolTimeouts(cfg.ProtocolTimeouts)
+	if err != nil {
+		return nil, err
+	}
+	longLived, err := longLivedProtocols(cfg.CacheLongLivedProtocols)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMaxFlowLifetime(cfg, timeouts, len(longLived) > 0); err != nil {
+		return nil, err
+	}
+	evictionTimeout := cfg.CacheActiveTimeout
+	ringBuf := fetcher.RingBufEnabled()
+	if !ringBuf && cfg.MapScanInterval > 0 {
+		evictionTimeout = cfg.MapScanInterval
+	}
+	mapTracer := flow.NewMapTracer(fetcher, &flow.MapTracerConfig{
+		EvictionTimeout:   evictionTimeout,
+		MaxLifetime:       cfg.MaxFlowLifetime,
+		FlushJitter:       cfg.CacheFlushJitter,
+		TCPCloseGrace:     cfg.TCPCloseGracePeriod,
+		ProtocolTimeouts:  timeouts,
+		CompletedFirstMin: completedFirstMin,
+		MinFlushFlows:     cfg.MinFlushFlows,
+		MinFlushMaxHold:   cfg.MinFlushMaxHold,
+	})
+	var rbTracer *flow.RingBufTracer
+	var rawDump *flow.RawRecordDumper
+	var accounter flowAccounter
+	if ringBuf {
+		var rbReader ringBufReader = fetcher
+		if cfg.RawRecordDumpFile != "" {
+			if rawDump, err = flow.NewRawRecordDumper(
+				fetcher, cfg.RawRecordDumpFile, cfg.RawRecordDumpMaxBytes); err != nil {
+				return nil, fmt.Errorf("invalid RAW_RECORD_DUMP_FILE: %w", err)
+			}
+			rbReader = rawDump
+		}
+		rbTracer = flow.NewRingBufTracer(rbReader, mapTracer, cfg.CacheActiveTimeout,
+			mapFullPolicy, cfg.MapFullSampling, cfg.RingBufSamplingRate, m)
+		shortAccounter := flow.NewAccounter(cfg.CacheMaxFlows, cfg.CacheActiveTimeout,
+			cfg.MaxBatchAge, cfg.MaxFlowLifetime, time.Now, monotime.Now, breaker)
+		accounter = shortAccounter
+		if len(longLived) > 0 {
+			// both caches share the memory breaker, so the long-lived flows stop being
+			// accepted under memory pressure too
+			accounter = flow.NewSplitAccounter(shortAccounter, flow.NewAccounter(
+				cfg.CacheLongLivedMaxFlows, cfg.CacheLongLivedTimeout, cfg.MaxBatchAge,
+				cfg.MaxFlowLifetime, time.Now, monotime.Now, breaker), longLived)
+		}
+	} else {
+		alog.WithField("scanInterval", evictionTimeout).
+			Warn("the kernel doesn't support eBPF ring buffers. The flows are only read by " +
+				"periodically scanning the eBPF map, and the flows that don't fit in it are dropped")
+		if cfg.RawRecordDumpFile != "" {
+			alog.Warn("ignoring RAW_RECORD_DUMP_FILE, as there isn't any ring buffer to record")
+		}
+	}
+	var flushRemovedIface func(ifIndex uint32)
+	if cfg.FlushOnInterfaceRemoval {
+		flushRemovedIface = mapTracer.InterfaceRemoved
+	}
+	// the live feed is started last, so its listener isn't left open on any configuration error
+	liveFeed, err := buildLiveFeed(cfg, m)
+	if err != nil {
+		return nil, err
+	}
 	return &Flows{
-		ebpf:           fetcher,
-		exporter:       exporter,
-		interfaces:     registerer,
-		filter:         filter,
-		cfg:            cfg,
-		mapTracer:      mapTracer,
-		rbTracer:       rbTracer,
-		accounter:      accounter,
-		agentIP:        agentIP,
-		interfaceNamer: interfaceNamer,
+		ebpf:                  fetcher,
+		exporter:              exporter,
+		liveFeed:              liveFeed,
+		interfaces:            registerer,
+		filter:                filter,
+		ifaceLimiter:          ifaceLimiter,
+		cfg:                   cfg,
+		mapTracer:             mapTracer,
+		rbTracer:              rbTracer,
+		rawDump:               rawDump,
+		flushRemovedIface:     flushRemovedIface,
+		accounter:             accounter,
+		samplingSchedule:      samplingSchedule,
+		trafficClasses:        trafficClasses,
+		excludeTrafficClasses: excludeTrafficClasses,
+		inferDirection:        inferDirection,
+		prefixKey:             prefixKey,
+		heartbeat:             heartbeat,
+		sortRecords:           sortRecords,
+		dedupPreferred:        dedupPreferred,
+		dedupPurges:           dedupPurges,
+		exportTraffic:         exportTraffic,
+		enrichers:             enrichers,
+		metrics:               m,
 	}, nil
 }
 
+// samplingSchedule returns the schedule of the sampling rate, or nil if it is not configured
+func samplingSchedule(cfg *Config) (*flow.SamplingSchedule, error) {
+	if cfg.SamplingSchedule == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(cfg.SamplingScheduleTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMPLING_SCHEDULE_TIMEZONE: %w", err)
+	}
+	schedule, err := flow.ParseSamplingSchedule(cfg.SamplingSchedule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAMPLING_SCHEDULE: %w", err)
+	}
+	return schedule, nil
+}
+
+// memoryBreaker returns the memory circuit breaker for the userspace flows' cache, or nil
+// if it is disabled
+func memoryBreaker(cfg *Config, m *metrics.Metrics) (*flow.MemoryBreaker, error) {
+	if cfg.MemoryHighWatermark == 0 {
+		return nil, nil
+	}
+	low := cfg.MemoryLowWatermark
+	if low == 0 {
+		low = uint64(float64(cfg.MemoryHighWatermark) * defaultLowWatermarkRatio)
+	}
+	if low >= cfg.MemoryHighWatermark {
+		return nil, fmt.Errorf("MEMORY_LOW_WATERMARK (%d) must be lower than MEMORY_HIGH_WATERMARK (%d)",
+			low, cfg.MemoryHighWatermark)
+	}
+	return flow.NewMemoryBreaker(cfg.MemoryHighWatermark, low, nil, time.Now, m), nil
+}
+
 func flowDirections(cfg *Config) (ingress, egress bool) {
 	switch cfg.Direction {
 	case DirectionIngress:
@@ -185,37 +681,260 @@ func flowDirections(cfg *Config) (ingress, egress bool) {
 	}
 }
 
-func buildFlowExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
-	switch cfg.Export {
-	case "grpc":
-		return buildGRPCExporter(cfg)
-	case "kafka":
-		return buildKafkaExporter(cfg)
-	case "ipfix+udp":
-		return buildIPFIXExporter(cfg, "udp")
-	case "ipfix+tcp":
-		return buildIPFIXExporter(cfg, "tcp")
+// parseMapFullPolicy returns the policy for the provided MAP_FULL_POLICY value: spill (default,
+// also for an empty value), drop or sample. It returns an error for any other value
+func parseMapFullPolicy(policy string) (flow.MapFullPolicy, error) {
+	switch policy {
+	case "", MapFullSpill:
+		return flow.MapFullSpill, nil
+	case MapFullDrop:
+		return flow.MapFullDrop, nil
+	case MapFullSample:
+		return flow.MapFullSample, nil
 	default:
-		return nil, fmt.Errorf("wrong export type %s. Admitted values are grpc, kafka", cfg.Export)
+		return 0, fmt.Errorf("invalid MAP_FULL_POLICY %q. Accepted values are %s, %s, %s",
+			policy, MapFullSpill, MapFullDrop, MapFullSample)
+	}
+}
+
+// payloadSampleProtocol returns the transport protocol number for the provided protocol name
+// or number. It returns 0 (any protocol) if the provided value is empty
+func payloadSampleProtocol(proto string) (uint8, error) {
+	if proto == "" {
+		return 0, nil
+	}
+	num, ok := protocolNumber(proto)
+	if !ok {
+		return 0, fmt.Errorf("invalid PAYLOAD_SAMPLE_PROTOCOL %q. Accepted values are tcp, udp, "+
+			"sctp or a protocol number", proto)
+	}
+	return num, nil
+}
+
+// protocolNumber returns the transport protocol number for the provided protocol name or number
+func protocolNumber(proto string) (uint8, bool) {
+	switch strings.ToLower(proto) {
+	case "tcp":
+		return syscall.IPPROTO_TCP, true
+	case "udp":
+		return syscall.IPPROTO_UDP, true
+	case "sctp":
+		return syscall.IPPROTO_SCTP, true
+	case "icmp":
+		return syscall.IPPROTO_ICMP, true
+	case "icmpv6":
+		return syscall.IPPROTO_ICMPV6, true
+	}
+	num, err := strconv.ParseUint(proto, 10, 8)
+	return uint8(num), err == nil
+}
+
+// packetSizeBounds validates the user-provided bounds of the packet size histogram. If empty,
+// the default bounds are used
+func packetSizeBounds(buckets []int) ([]uint16, error) {
+	if len(buckets) == 0 {
+		return nil, nil
 	}
+	if len(buckets) != ebpf.PacketSizeBuckets-1 {
+		return nil, fmt.Errorf("PACKET_SIZE_BUCKETS must contain %d values. Got: %v",
+			ebpf.PacketSizeBuckets-1, buckets)
+	}
+	bounds := make([]uint16, 0, len(buckets))
+	for i, b := range buckets {
+		if b <= 0 || b > math.MaxUint16 || (i > 0 && b <= buckets[i-1]) {
+			return nil, fmt.Errorf("PACKET_SIZE_BUCKETS must contain increasing values "+
+				"between 1 and %d. Got: %v", math.MaxUint16, buckets)
+		}
+		bounds = append(bounds, uint16(b))
+	}
+	return bounds, nil
 }
 
-func buildGRPCExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+// servicePorts parses the user-provided port:name entries of the service enricher. If empty,
+// it returns nil so the default mapping is used
+func servicePorts(entries []string) (map[uint16]string, error) {
+	var ports map[uint16]string
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		port, name, ok := strings.Cut(entry, ":")
+		portNum, err := strconv.ParseUint(strings.TrimSpace(port), 10, 16)
+		name = strings.TrimSpace(name)
+		if !ok || err != nil || portNum == 0 || name == "" {
+			return nil, fmt.Errorf("SERVICE_PORTS entries must have the port:name format. Got: %q",
+				entry)
+		}
+		if ports == nil {
+			ports = map[uint16]string{}
+		}
+		ports[uint16(portNum)] = name
+	}
+	return ports, nil
+}
+
+// protocolTimeouts parses the user-provided protocol:active:inactive entries of the per-protocol
+// eviction timeouts. Any of both timeouts can be left empty to keep the default behavior.
+func protocolTimeouts(entries []string) (map[uint8]flow.ProtocolTimeouts, error) {
+	var timeouts map[uint8]flow.ProtocolTimeouts
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("PROTOCOL_TIMEOUTS entries must have the "+
+				"protocol:active:inactive format. Got: %q", entry)
+		}
+		proto, ok := protocolNumber(strings.TrimSpace(fields[0]))
+		if !ok {
+			return nil, fmt.Errorf("invalid protocol in PROTOCOL_TIMEOUTS entry %q. Accepted "+
+				"values are tcp, udp, sctp, icmp, icmpv6 or a protocol number", entry)
+		}
+		var pt flow.ProtocolTimeouts
+		for i, timeout := range []*time.Duration{&pt.Active, &pt.Inactive} {
+			value := strings.TrimSpace(fields[i+1])
+			if value == "" {
+				continue
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid timeout in PROTOCOL_TIMEOUTS entry %q", entry)
+			}
+			*timeout = d
+		}
+		if timeouts == nil {
+			timeouts = map[uint8]flow.ProtocolTimeouts{}
+		}
+		timeouts[proto] = pt
+	}
+	return timeouts, nil
+}
+
+// checkMaxFlowLifetime verifies that the maximum flow lifetime, if set, is lower than the longest
+// active timeout of the flows, as they would be evicted before reaching it otherwise
+func checkMaxFlowLifetime(
+	cfg *Config, timeouts map[uint8]flow.ProtocolTimeouts, longLived bool,
+) error {
+	if cfg.MaxFlowLifetime <= 0 {
+		return nil
+	}
+	longest := cfg.CacheActiveTimeout
+	for _, pt := range timeouts {
+		if pt.Active > longest {
+			longest = pt.Active
+		}
+	}
+	if longLived && cfg.CacheLongLivedTimeout > longest {
+		longest = cfg.CacheLongLivedTimeout
+	}
+	if cfg.MaxFlowLifetime >= longest {
+		return fmt.Errorf("MAX_FLOW_LIFETIME (%s) has no effect, as it must be lower than the "+
+			"longest active timeout (%s) of CACHE_ACTIVE_TIMEOUT, PROTOCOL_TIMEOUTS and "+
+			"CACHE_LONG_LIVED_TIMEOUT", cfg.MaxFlowLifetime, longest)
+	}
+	return nil
+}
+
+func longLivedProtocols(entries []string) ([]uint8, error) {
+	var protocols []uint8
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		proto, ok := protocolNumber(entry)
+		if !ok {
+			return nil, fmt.Errorf("invalid protocol in CACHE_LONG_LIVED_PROTOCOLS %q. Accepted "+
+				"values are tcp, udp, sctp, icmp, icmpv6 or a protocol number", entry)
+		}
+		protocols = append(protocols, proto)
+	}
+	return protocols, nil
+}
+
+func buildFlowExporter(
+	ctx context.Context, cfg *Config, m *metrics.Metrics,
+) (node.TerminalFunc[[]*flow.Record], error) {
+	if len(cfg.Exporters) > 0 {
+		return buildMultiExporter(ctx, cfg, m)
+	}
+	return buildRegisteredExporter(ctx, cfg, m)
+}
+
+func buildCountersExporter(cfg *Config, m *metrics.Metrics) (exporter.Exporter, error) {
+	if !cfg.MetricsEnable {
+		alog.Warn("EXPORT is set to counters but METRICS_ENABLE is false. " +
+			"The counters won't be exposed")
+	}
+	return exporter.NewCounters(m, cfg.EnableIfCounters), nil
+}
+
+func buildGRPCExporter(
+	ctx context.Context, cfg *Config, m *metrics.Metrics,
+) (node.TerminalFunc[[]*flow.Record], error) {
 	if cfg.TargetHost == "" || cfg.TargetPort == 0 {
 		return nil, fmt.Errorf("missing target host or port: %s:%d",
 			cfg.TargetHost, cfg.TargetPort)
 	}
-	grpcExporter, err := exporter.StartGRPCProto(cfg.TargetHost, cfg.TargetPort, cfg.GRPCMessageMaxFlows)
+	collectors, err := grpcCollectors(cfg.TargetHost, cfg.TargetPort)
+	if err != nil {
+		return nil, err
+	}
+	grpcExporter, err := exporter.StartGRPCProtoFailover(ctx, collectors,
+		cfg.GRPCMessageMaxFlows, cfg.GRPCFailbackInterval, cfg.GRPCSendTimeout)
 	if err != nil {
 		return nil, err
 	}
-	return grpcExporter.ExportFlows, nil
+	return exportTerminal(ctx, cfg, grpcExporter, m), nil
 }
 
-func buildKafkaExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+// grpcCollectors parses the comma-separated list of collector hosts, sorted by priority. Each
+// entry can optionally specify its port (e.g. flp-1:9999,[::1]:9999). Otherwise the
+// default port is used.
+func grpcCollectors(hosts string, defaultPort int) ([]exporter.GRPCCollector, error) {
+	var collectors []exporter.GRPCCollector
+	for _, entry := range strings.Split(hosts, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil {
+			// no port specified
+			collectors = append(collectors, exporter.GRPCCollector{HostIP: entry, HostPort: defaultPort})
+			continue
+		}
+		portNum, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in target host %q: %w", entry, err)
+		}
+		collectors = append(collectors, exporter.GRPCCollector{HostIP: host, HostPort: portNum})
+	}
+	if len(collectors) == 0 {
+		return nil, fmt.Errorf("missing target host: %q", hosts)
+	}
+	return collectors, nil
+}
+
+func buildKafkaExporter(cfg *Config, m *metrics.Metrics) (exporter.Exporter, error) {
 	if len(cfg.KafkaBrokers) == 0 {
 		return nil, errors.New("at least one Kafka broker is needed")
 	}
+	var acks kafkago.RequiredAcks
+	switch cfg.KafkaRequiredAcks {
+	case KafkaAcksNone:
+		acks = kafkago.RequireNone
+	case KafkaAcksLeader:
+		acks = kafkago.RequireOne
+	case KafkaAcksAll:
+		acks = kafkago.RequireAll
+	default:
+		return nil, fmt.Errorf("wrong Kafka required acks %s. Admitted values are %s, %s, %s",
+			cfg.KafkaRequiredAcks, KafkaAcksNone, KafkaAcksLeader, KafkaAcksAll)
+	}
 	var compression compress.Compression
 	if err := compression.UnmarshalText([]byte(cfg.KafkaCompression)); err != nil {
 		return nil, fmt.Errorf("wrong Kafka compression value %s. Admitted values are "+
@@ -236,41 +955,210 @@ func buildKafkaExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error)
 		}
 		transport.SASL = mechanism
 	}
-	return (&exporter.KafkaProto{
-		Writer: &kafkago.Writer{
-			Addr:      kafkago.TCP(cfg.KafkaBrokers...),
-			Topic:     cfg.KafkaTopic,
-			BatchSize: cfg.KafkaBatchMessages,
-			// Assigning KafkaBatchSize to BatchBytes instead of BatchSize might be confusing here.
-			// The reason is that the "standard" Kafka name for this variable is "batch.size",
-			// which specifies the size of messages in terms of bytes, and not in terms of entries.
-			// We have decided to hide this library implementation detail and expose to the
-			// customer the common, standard name and meaning for batch.size
-			BatchBytes: int64(cfg.KafkaBatchSize),
-			// Segmentio's Kafka-go does not behave as standard Kafka library, and would
-			// throttle any Write invocation until reaching the timeout.
-			// Since we invoke write once each CacheActiveTimeout, we can safely disable this
-			// timeout throttling
-			// https://github.com/netobserv/flowlogs-pipeline/pull/233#discussion_r897830057
-			BatchTimeout: time.Nanosecond,
-			Async:        cfg.KafkaAsync,
-			Compression:  compression,
-			Transport:    &transport,
-			Balancer:     &kafkago.RoundRobin{},
-		},
-	}).ExportFlows, nil
+	writer := &kafkago.Writer{
+		Addr:      kafkago.TCP(cfg.KafkaBrokers...),
+		Topic:     cfg.KafkaTopic,
+		BatchSize: cfg.KafkaBatchMessages,
+		// Assigning KafkaBatchSize to BatchBytes instead of BatchSize might be confusing here.
+		// The reason is that the "standard" Kafka name for this variable is "batch.size",
+		// which specifies the size of messages in terms of bytes, and not in terms of entries.
+		// We have decided to hide this library implementation detail and expose to the
+		// customer the common, standard name and meaning for batch.size
+		BatchBytes: int64(cfg.KafkaBatchSize),
+		// Segmentio's Kafka-go does not behave as standard Kafka library, and would
+		// throttle any Write invocation until reaching the timeout.
+		// Since we invoke write once each CacheActiveTimeout, we can safely disable this
+		// timeout throttling
+		// https://github.com/netobserv/flowlogs-pipeline/pull/233#discussion_r897830057
+		BatchTimeout: time.Nanosecond,
+		Async:        cfg.KafkaAsync,
+		RequiredAcks: acks,
+		Compression:  compression,
+		Transport:    &transport,
+		Balancer:     &kafkago.RoundRobin{},
+	}
+	delivery := exporter.NewKafkaDelivery(
+		cfg.Export, cfg.KafkaRetries, cfg.KafkaRetryBackoff, cfg.KafkaRetryMaxBackoff, m)
+	if cfg.KafkaAsync {
+		// the asynchronous writes don't report errors, so they can't be retried. The delivery is
+		// just accounted on completion
+		alog.Info("KAFKA_ASYNC is enabled. The failed writes to Kafka won't be retried")
+		writer.Completion = delivery.Completion
+		delivery = nil
+	} else {
+		// the failed writes are retried by the delivery
+		writer.MaxAttempts = 1
+	}
+	tenantTopicPrefix := ""
+	if cfg.KafkaTenantTopics {
+		// the topic is set for each message
+		writer.Topic = ""
+		tenantTopicPrefix = cfg.KafkaTopic
+	}
+	switch cfg.KafkaEncoding {
+	case KafkaEncodingProtobuf:
+		return &exporter.KafkaProto{
+			Writer: writer, TenantTopicPrefix: tenantTopicPrefix, Delivery: delivery,
+		}, nil
+	case KafkaEncodingAvro:
+		encoder, err := exporter.NewAvroEncoder(
+			&http.Client{Timeout: schemaRegistryTimeout},
+			cfg.KafkaSchemaRegistryURL, cfg.KafkaTopic+"-value")
+		if err != nil {
+			return nil, fmt.Errorf("configuring Kafka Avro encoding: %w", err)
+		}
+		return &exporter.KafkaAvro{
+			Writer: writer, Encoder: encoder, TenantTopicPrefix: tenantTopicPrefix,
+			Delivery: delivery,
+		}, nil
+	default:
+		return nil, fmt.Errorf("wrong Kafka encoding %s. Admitted values are %s, %s",
+			cfg.KafkaEncoding, KafkaEncodingProtobuf, KafkaEncodingAvro)
+	}
 }
 
-func buildIPFIXExporter(cfg *Config, proto string) (node.TerminalFunc[[]*flow.Record], error) {
-	if cfg.TargetHost == "" || cfg.TargetPort == 0 {
-		return nil, fmt.Errorf("missing target host or port: %s:%d",
-			cfg.TargetHost, cfg.TargetPort)
+func buildPromRemoteWriteExporter(cfg *Config, _ *metrics.Metrics) (exporter.Exporter, error) {
+	prw, err := exporter.StartPromRemoteWrite(&http.Client{Timeout: promRemoteWriteTimeout},
+		cfg.PromRemoteWriteURL, cfg.PromRemoteWriteInterval, cfg.PromRemoteWriteLabels,
+		cfg.PromRemoteWriteMaxSeries)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Prometheus remote-write exporter: %w", err)
+	}
+	return prw, nil
+}
+
+func buildElasticsearchExporter(cfg *Config, m *metrics.Metrics) (exporter.Exporter, error) {
+	es, err := exporter.StartElasticsearch(
+		&http.Client{Timeout: elasticsearchTimeout}, cfg.Export, &exporter.ElasticsearchConfig{
+			URL:           cfg.ElasticsearchURL,
+			Index:         cfg.ElasticsearchIndex,
+			Username:      cfg.ElasticsearchUsername,
+			Password:      cfg.ElasticsearchPassword,
+			BatchSize:     cfg.ElasticsearchBatchSize,
+			Retries:       cfg.ElasticsearchRetries,
+			RetryBackoff:  cfg.ElasticsearchRetryBackoff,
+			FieldCase:     cfg.ExportFieldCase,
+			ProtocolNames: cfg.ExportProtocolNames,
+		}, m)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Elasticsearch exporter: %w", err)
+	}
+	return es, nil
+}
+
+func buildPubSubExporter(cfg *Config, m *metrics.Metrics) (exporter.Exporter, error) {
+	client := &http.Client{Timeout: pubsubTimeout}
+	endpoint := cfg.PubSubEndpoint
+	if emulator := os.Getenv("PUBSUB_EMULATOR_HOST"); emulator != "" {
+		// the emulator doesn't require authentication
+		endpoint = "http://" + emulator
+	} else {
+		ts, err := exporter.GoogleTokenSource(&http.Client{Timeout: pubsubTimeout},
+			exporter.PubSubScope)
+		if err != nil {
+			return nil, fmt.Errorf("configuring Pub/Sub exporter: %w", err)
+		}
+		client.Transport = &oauth2.Transport{Source: ts}
+	}
+	ps, err := exporter.StartPubSub(client, cfg.Export, &exporter.PubSubConfig{
+		Endpoint:      endpoint,
+		Project:       cfg.PubSubProject,
+		Topic:         cfg.PubSubTopic,
+		OrderingKey:   cfg.PubSubOrderingKey,
+		BatchSize:     cfg.PubSubBatchSize,
+		Retries:       cfg.PubSubRetries,
+		RetryBackoff:  cfg.PubSubRetryBackoff,
+		BufferLength:  cfg.PubSubBufferLength,
+		FieldCase:     cfg.ExportFieldCase,
+		ProtocolNames: cfg.ExportProtocolNames,
+	}, m)
+	if err != nil {
+		return nil, fmt.Errorf("configuring Pub/Sub exporter: %w", err)
+	}
+	return ps, nil
+}
+
+// buildIPFIXExporter returns the provider of the IPFIX exporter over the provided transport
+func buildIPFIXExporter(proto string) func(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	return func(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+		if cfg.TargetHost == "" || cfg.TargetPort == 0 {
+			return nil, fmt.Errorf("missing target host or port: %s:%d",
+				cfg.TargetHost, cfg.TargetPort)
+		}
+		ipfix, err := exporter.StartIPFIXExporter(cfg.TargetHost, cfg.TargetPort, proto)
+		if err != nil {
+			return nil, err
+		}
+		return ipfix.ExportFlows, nil
 	}
-	ipfix, err := exporter.StartIPFIXExporter(cfg.TargetHost, cfg.TargetPort, proto)
+}
+
+func buildStatsDExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	statsd, err := exporter.StartStatsD(cfg.TargetHost, cfg.TargetPort, cfg.StatsDPrefix, cfg.StatsDTags)
 	if err != nil {
 		return nil, err
 	}
-	return ipfix.ExportFlows, nil
+	return statsd.ExportFlows, nil
+}
+
+func buildSFlowExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	sflow, err := exporter.StartSFlow(cfg.TargetHost, cfg.TargetPort, cfg.SFlowCounterSamples)
+	if err != nil {
+		return nil, err
+	}
+	return sflow.ExportFlows, nil
+}
+
+func buildFileExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	fileExporter, err := exporter.StartFileJSON(
+		cfg.FilePath, cfg.FileDedupWindow, cfg.ExportEncoding, cfg.ExportFieldCase,
+		cfg.ExportProtocolNames, cfg.SinkCompression)
+	if err != nil {
+		return nil, err
+	}
+	return fileExporter.ExportFlows, nil
+}
+
+func buildSyslogExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	syslog, err := exporter.StartSyslog(cfg.TargetHost, cfg.TargetPort, cfg.SyslogTransport,
+		cfg.SyslogFacility, cfg.SyslogSeverity, cfg.SyslogMaxMessageSize)
+	if err != nil {
+		return nil, err
+	}
+	return syslog.ExportFlows, nil
+}
+
+func buildUnixSocketExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	unixExporter, err := exporter.StartUnixSocket(
+		cfg.UnixSocketPath, cfg.BuffersLength, cfg.ExportEncoding, cfg.ExportFieldCase,
+		cfg.ExportProtocolNames, cfg.SinkCompression)
+	if err != nil {
+		return nil, err
+	}
+	return unixExporter.ExportFlows, nil
+}
+
+// buildLiveFeed returns the WebSocket live feed of the flows, or nil if it is disabled
+func buildLiveFeed(cfg *Config, m *metrics.Metrics) (node.TerminalFunc[[]*flow.Record], error) {
+	if cfg.WebSocketPort == 0 {
+		return nil, nil
+	}
+	feed, err := exporter.StartWebSocket(fmt.Sprintf(":%d", cfg.WebSocketPort),
+		cfg.WebSocketClientBuffer, cfg.ExportFieldCase, cfg.ExportProtocolNames, m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WEBSOCKET_PORT: %w", err)
+	}
+	return feed.ExportFlows, nil
+}
+
+func buildFIFOExporter(cfg *Config) (node.TerminalFunc[[]*flow.Record], error) {
+	fifoExporter, err := exporter.StartFIFO(
+		cfg.FifoPath, cfg.BuffersLength, cfg.ExportEncoding, cfg.ExportFieldCase,
+		cfg.ExportProtocolNames)
+	if err != nil {
+		return nil, err
+	}
+	return fifoExporter.ExportFlows, nil
 }
 
 // Run a Flows agent. The function will keep running in the same thread
@@ -278,6 +1166,16 @@ func buildIPFIXExporter(cfg *Config, proto string) (node.TerminalFunc[[]*flow.Re
 func (f *Flows) Run(ctx context.Context) error {
 	f.status = StatusStarting
 	alog.Info("starting Flows agent")
+	if f.cfg.MetricsEnable {
+		f.startMetricsServer(ctx)
+	}
+	if f.cfg.SelfTelemetryInterval > 0 {
+		if !f.cfg.MetricsEnable {
+			alog.Warn("SELF_TELEMETRY_INTERVAL is set but METRICS_ENABLE is false. " +
+				"The agent resource usage won't be exposed")
+		}
+		go metrics.NewSelfTelemetry(f.metrics).Run(ctx, f.cfg.SelfTelemetryInterval)
+	}
 	graph, err := f.buildAndStartPipeline(ctx)
 	if err != nil {
 		return fmt.Errorf("starting processing graph: %w", err)
@@ -289,12 +1187,21 @@ func (f *Flows) Run(ctx context.Context) error {
 
 	f.status = StatusStopping
 	alog.Info("stopping Flows agent")
+	if f.stopExports != nil {
+		f.stopExports()
+	}
+	f.ebpfWriters.Wait()
 	if err := f.ebpf.Close(); err != nil {
 		alog.WithError(err).Warn("eBPF resources not correctly closed")
 	}
 
 	alog.Debug("waiting for all nodes to finish their pending work")
 	<-graph.Done()
+	if f.rawDump != nil {
+		if err := f.rawDump.Close(); err != nil {
+			alog.WithError(err).Warn("raw record dump file not correctly closed")
+		}
+	}
 
 	f.status = StatusStopped
 	alog.Info("Flows agent stopped")
@@ -305,6 +1212,37 @@ func (f *Flows) Status() Status {
 	return f.status
 }
 
+// Flush forces the immediate eviction of all the flows that are cached in the eBPF maps
+// and in the userspace accounter, so they are exported without waiting for the
+// CacheActiveTimeout. The agent keeps running afterwards.
+func (f *Flows) Flush() {
+	alog.Info("flushing flows")
+	f.mapTracer.Flush()
+	if f.accounter != nil {
+		f.accounter.Flush()
+	}
+}
+
+// startMetricsServer exposes the agent internal metrics via HTTP until the context is canceled
+func (f *Flows) startMetricsServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", f.metrics.Handler())
+	server := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", f.cfg.MetricsServerAddress, f.cfg.MetricsPort),
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	go func() {
+		alog.WithField("address", server.Addr).Info("starting metrics HTTP server")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			alog.WithError(err).Error("metrics HTTP server stopped working")
+		}
+	}()
+}
+
 // interfacesManager uses an informer to check new/deleted network interfaces. For each running
 // interface, it registers a flow ebpfFetcher that will forward new flows to the returned channel
 // TODO: consider move this method and "onInterfaceAdded" to another type
@@ -331,6 +1269,7 @@ func (f *Flows) interfacesManager(ctx context.Context) error {
 				case ifaces.EventDeleted:
 					// qdiscs, ingress and egress filters are automatically deleted so we don't need to
 					// specifically detach them from the ebpfFetcher
+					f.onInterfaceDeleted(event.Interface)
 				default:
 					slog.WithField("event", event).Warn("unknown event type")
 				}
@@ -353,43 +1292,80 @@ func (f *Flows) buildAndStartPipeline(ctx context.Context) (*node.Terminal[[]*fl
 
 	alog.Debug("connecting flows' processing graph")
 	mapTracer := node.AsStart(f.mapTracer.TraceLoop(ctx))
-	rbTracer := node.AsStart(f.rbTracer.TraceLoop(ctx))
-
-	accounter := node.AsMiddle(f.accounter.Account,
-		node.ChannelBufferLen(f.cfg.BuffersLength))
+	// the flows from the map tracer, and from the accounter of the ring buffer events if the
+	// kernel supports ring buffers, are sent to the first of the optional stages, or to the
+	// limiter if none is enabled
+	tracedFlows := []node.Sender[[]*flow.Record]{mapTracer}
+	var rbTracer *node.Start[*flow.RawRecord]
+	if f.rbTracer != nil {
+		rbTracer = node.AsStart(f.rbTracer.TraceLoop(ctx))
+		accounter := node.AsMiddle(f.accounter.Account,
+			node.ChannelBufferLen(f.cfg.BuffersLength))
+		rbTracer.SendsTo(accounter)
+		tracedFlows = append(tracedFlows, accounter)
+	}
 
-	limiter := node.AsMiddle((&flow.CapacityLimiter{}).Limit,
-		node.ChannelBufferLen(f.cfg.BuffersLength))
+	capacityLimiter := flow.NewCapacityLimiter(ctx, "")
+	if f.cfg.EnableBackpressure {
+		monitor := flow.NewPressureMonitor(
+			capacityLimiter, f.ebpf, f.cfg.BackpressureMaxLevel, f.metrics)
+		f.ebpfWriters.Add(1)
+		go func() {
+			defer f.ebpfWriters.Done()
+			monitor.Run(ctx, f.cfg.BackpressureInterval)
+		}()
+	}
+	if f.samplingSchedule != nil {
+		scheduler := flow.NewSamplingScheduler(
+			f.samplingSchedule, f.ebpf, f.cfg.Sampling, time.Now, f.metrics)
+		f.ebpfWriters.Add(1)
+		go func() {
+			defer f.ebpfWriters.Done()
+			scheduler.Run(ctx, samplingScheduleInterval)
+		}()
+	}
 
-	decorator := node.AsMiddle(flow.Decorate(f.agentIP, f.interfaceNamer),
-		node.ChannelBufferLen(f.cfg.BuffersLength))
+	var stageTimer *flow.StageTimer
+	if f.cfg.MetricsStageTiming {
+		stageTimer = flow.NewStageTimer(f.metrics)
+	}
 
 	ebl := f.cfg.ExporterBufferLength
 	if ebl == 0 {
 		ebl = f.cfg.BuffersLength
 	}
 
-	export := node.AsTerminal(f.exporter,
-		node.ChannelBufferLen(ebl))
-
-	rbTracer.SendsTo(accounter)
+	flowAge := exporter.NewFlowAgeObserver(f.metrics, exporter.DefaultFlowAgeMaxInterfaces)
+	exportFunc := flowAge.Instrument(f.exporter)
+	if stageTimer != nil {
+		exportFunc = stageTimer.Terminal("export", exportFunc)
+	}
+	export := node.AsTerminal(exportFunc, node.ChannelBufferLen(ebl))
 
-	if f.cfg.Deduper == DeduperFirstCome {
-		deduper := node.AsMiddle(flow.Dedupe(f.cfg.DeduperFCExpiry, f.cfg.DeduperJustMark),
-			node.ChannelBufferLen(f.cfg.BuffersLength))
-		mapTracer.SendsTo(deduper)
-		accounter.SendsTo(deduper)
-		deduper.SendsTo(limiter)
-	} else {
-		mapTracer.SendsTo(limiter)
-		accounter.SendsTo(limiter)
+	flows := &pipeline{tails: tracedFlows, bufferLen: f.cfg.BuffersLength, timer: stageTimer}
+	for _, s := range f.processingStages() {
+		flows.chain(s)
+	}
+	flows.chain(stage{run: capacityLimiter.Limit})
+	flows.chain(stage{"decorate", flow.Enrich(f.enrichers)})
+	for _, s := range f.decorationStages() {
+		flows.chain(s)
+	}
+	if f.liveFeed != nil {
+		// the live feed gets the flows before the export rate limit, which protects the
+		// collectors
+		flows.sendTo(node.AsTerminal(f.liveFeed, node.ChannelBufferLen(f.cfg.BuffersLength)))
 	}
-	limiter.SendsTo(decorator)
-	decorator.SendsTo(export)
+	for _, s := range f.deliveryStages() {
+		flows.chain(s)
+	}
+	flows.sendTo(export)
 
 	alog.Debug("starting graph")
 	mapTracer.Start()
-	rbTracer.Start()
+	if rbTracer != nil {
+		rbTracer.Start()
+	}
 	return export, nil
 }
 
@@ -400,10 +1376,50 @@ func (f *Flows) onInterfaceAdded(iface ifaces.Interface) {
 			Debug("interface does not match the allow/exclusion filters. Ignoring")
 		return
 	}
+	if f.ifaceLimiter != nil && !f.ifaceLimiter.acquire(iface) {
+		alog.WithField("interface", iface).
+			Info("interface detected, but MAX_INTERFACES is reached. Queueing it")
+		return
+	}
 	alog.WithField("interface", iface).Info("interface detected. Registering flow ebpfFetcher")
+	f.register(iface)
+}
+
+// onInterfaceDeleted flushes the flows of the removed interface, if enabled, and frees its
+// slot, if the number of attached interfaces is limited, attaching the next queued interfaces
+func (f *Flows) onInterfaceDeleted(iface ifaces.Interface) {
+	if f.flushRemovedIface != nil && f.filter.Allowed(iface.Name) {
+		alog.WithField("interface", iface).Debug("interface removed. Flushing its flows")
+		f.flushRemovedIface(uint32(iface.Index))
+	}
+	if f.dedupPurges != nil {
+		f.dedupPurges.InterfaceRemoved(uint32(iface.Index))
+	}
+	if f.ifaceLimiter == nil {
+		return
+	}
+	f.ifaceLimiter.release(iface)
+	for next, ok := f.ifaceLimiter.next(); ok; next, ok = f.ifaceLimiter.next() {
+		alog.WithField("interface", next).Info("attaching queued interface. Registering flow ebpfFetcher")
+		f.register(next)
+	}
+}
+
+func (f *Flows) register(iface ifaces.Interface) {
 	if err := f.ebpf.Register(iface); err != nil {
 		alog.WithField("interface", iface).WithError(err).
 			Warn("can't register flow ebpfFetcher. Ignoring")
-		return
+		if f.ifaceLimiter != nil {
+			f.ifaceLimiter.release(iface)
+		}
+	}
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
 	}
+	return false
 }
@@ -3,13 +3,17 @@ package agent
 import (
 	"context"
 	"net"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gavv/monotime"
 	test2 "github.com/mariomac/guara/pkg/test"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/exporter"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ifaces"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,6 +39,50 @@ func TestFlowsAgent_InvalidConfigs(t *testing.T) {
 	}, {
 		d: "Kafka: missing brokers",
 		c: Config{Export: "kafka"},
+	}, {
+		d: "StatsD: invalid tag",
+		c: Config{Export: "statsd", TargetHost: "127.0.0.1", TargetPort: 8125,
+			StatsDTags: []string{"foo"}},
+	}, {
+		d: "Syslog: missing endpoint",
+		c: Config{Export: "syslog", SyslogTransport: "udp", SyslogFacility: "local0",
+			SyslogSeverity: "info", SyslogMaxMessageSize: 2048},
+	}, {
+		d: "Unix: missing path",
+		c: Config{Export: "unix"},
+	}, {
+		d: "File: missing path",
+		c: Config{Export: "file"},
+	}, {
+		d: "File: invalid field case",
+		c: Config{Export: "file", FilePath: "/tmp/flows.json", ExportFieldCase: "kebab"},
+	}, {
+		d: "File: invalid encoding",
+		c: Config{Export: "file", FilePath: "/tmp/flows.json", ExportEncoding: "cbor"},
+	}, {
+		d: "invalid max interfaces policy",
+		c: Config{Export: "grpc", TargetHost: "flp", TargetPort: 3333,
+			MaxInterfaces: 10, MaxInterfacesPolicy: "random"},
+	}, {
+		d: "invalid payload sample protocol",
+		c: Config{Export: "grpc", TargetHost: "flp", TargetPort: 3333,
+			PayloadSampleBytes: 16, PayloadSampleProtocol: "foo"},
+	}, {
+		d: "too few packet size buckets",
+		c: Config{Export: "grpc", TargetHost: "flp", TargetPort: 3333,
+			PacketSizeBuckets: []int{64, 512}},
+	}, {
+		d: "non-increasing packet size buckets",
+		c: Config{Export: "grpc", TargetHost: "flp", TargetPort: 3333,
+			PacketSizeBuckets: []int{64, 1500, 512}},
+	}, {
+		d: "invalid payload sample port",
+		c: Config{Export: "grpc", TargetHost: "flp", TargetPort: 3333,
+			PayloadSampleBytes: 16, PayloadSamplePort: 123456},
+	}, {
+		d: "invalid deduper preferred interface regexp",
+		c: Config{Export: "grpc", TargetHost: "flp", TargetPort: 3333,
+			Deduper: DeduperFirstCome, DeduperPreferInterfaces: []string{"/eth[/"}},
 	}} {
 		t.Run(tc.d, func(t *testing.T) {
 			_, err := FlowsAgent(&tc.c)
@@ -164,10 +212,30 @@ func TestFlowsAgent_Decoration(t *testing.T) {
 	}
 }
 
+func TestFlowsAgent_BuildInfo(t *testing.T) {
+	export := testAgent(t, &Config{
+		CacheActiveTimeout: 10 * time.Millisecond,
+		CacheMaxFlows:      100,
+		TagBuildInfo:       true,
+	})
+
+	exported := export.Get(t, timeout)
+	require.Len(t, exported, 1)
+	assert.NotEmpty(t, exported[0].AgentVersion)
+	assert.Equal(t, agentVersion(), exported[0].AgentVersion)
+	assert.Len(t, exported[0].BpfProgHash, 16)
+	assert.Equal(t, ebpf.ProgramHash(), exported[0].BpfProgHash)
+}
+
 func testAgent(t *testing.T, cfg *Config) *test.ExporterFake {
+	_, export := startTestAgent(t, cfg)
+	return export
+}
+
+func startTestAgent(t *testing.T, cfg *Config) (*Flows, *test.ExporterFake) {
 	ebpfTracer := test.NewTracerFake()
 	export := test.NewExporterFake()
-	agent, err := flowsAgent(cfg,
+	agent, err := flowsAgent(cfg, metrics.NoOp(),
 		test.SliceInformerFake{
 			{Name: "foo", Index: 3},
 			{Name: "bar", Index: 4},
@@ -180,7 +248,7 @@ func testAgent(t *testing.T, cfg *Config) *test.ExporterFake {
 	}()
 	test2.Eventually(t, timeout, func(t require.TestingT) {
 		require.Equal(t, StatusStarted, agent.status)
-	})
+	}, test2.Interval(10*time.Millisecond))
 
 	now := uint64(monotime.Now())
 	key1Metrics := ebpf.BpfFlowMetrics{Packets: 3, Bytes: 44, StartMonoTimeTs: now + 1000, EndMonoTimeTs: now + 1_000_000_000}
@@ -188,5 +256,182 @@ func testAgent(t *testing.T, cfg *Config) *test.ExporterFake {
 	ebpfTracer.AppendLookupResults(map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
 		key1: key1Metrics,
 	})
-	return export
+	return agent, export
+}
+
+func TestServicePorts(t *testing.T) {
+	ports, err := servicePorts([]string{"80:http", " 5432 : postgresql ", ""})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint16]string{80: "http", 5432: "postgresql"}, ports)
+
+	ports, err = servicePorts(nil)
+	require.NoError(t, err)
+	assert.Nil(t, ports)
+
+	for _, invalid := range []string{"http", "80", "80:", "0:foo", "70000:foo", "foo:http"} {
+		_, err = servicePorts([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestGRPCCollectors(t *testing.T) {
+	collectors, err := grpcCollectors("flp-1, flp-2:9999,::1,[::2]:8888", 3333)
+	require.NoError(t, err)
+	assert.Equal(t, []exporter.GRPCCollector{
+		{HostIP: "flp-1", HostPort: 3333},
+		{HostIP: "flp-2", HostPort: 9999},
+		{HostIP: "::1", HostPort: 3333},
+		{HostIP: "::2", HostPort: 8888},
+	}, collectors)
+
+	_, err = grpcCollectors("flp-1:foo", 3333)
+	assert.Error(t, err)
+	_, err = grpcCollectors(" , ", 3333)
+	assert.Error(t, err)
+}
+
+func TestFlowsAgent_Flush(t *testing.T) {
+	flows, export := startTestAgent(t, &Config{
+		CacheActiveTimeout: time.Hour,
+		CacheMaxFlows:      100,
+	})
+
+	// WHEN a flush is requested
+	// THEN the cached flows are exported without waiting for the active timeout
+	// (we retry the flush, as the first request might happen before the tracers are listening)
+	flusher := time.NewTicker(50 * time.Millisecond)
+	defer flusher.Stop()
+	flows.Flush()
+	var exported []*flow.Record
+	deadline := time.After(timeout)
+	for exported == nil {
+		select {
+		case exported = <-export.Messages():
+		case <-flusher.C:
+			flows.Flush()
+		case <-deadline:
+			require.Fail(t, "timeout while waiting for flushed flows")
+		}
+	}
+	require.Len(t, exported, 1)
+	assert.Equal(t, key1, exported[0].Id)
+	assert.EqualValues(t, 44, exported[0].Metrics.Bytes)
+}
+
+func TestProtocolTimeouts(t *testing.T) {
+	timeouts, err := protocolTimeouts([]string{"tcp:30s:", " udp : : 2s ", "132:1m:10s", ""})
+	require.NoError(t, err)
+	assert.Equal(t, map[uint8]flow.ProtocolTimeouts{
+		syscall.IPPROTO_TCP:  {Active: 30 * time.Second},
+		syscall.IPPROTO_UDP:  {Inactive: 2 * time.Second},
+		syscall.IPPROTO_SCTP: {Active: time.Minute, Inactive: 10 * time.Second},
+	}, timeouts)
+	timeouts, err = protocolTimeouts(nil)
+	require.NoError(t, err)
+	assert.Nil(t, timeouts)
+
+	for _, invalid := range []string{"tcp", "tcp:30s", "foo:30s:", "udp:30:", "udp::-1s"} {
+		_, err = protocolTimeouts([]string{invalid})
+		assert.Errorf(t, err, "expected error for %q", invalid)
+	}
+}
+
+func TestCheckMaxFlowLifetime(t *testing.T) {
+	cfg := &Config{CacheActiveTimeout: 5 * time.Second, CacheLongLivedTimeout: time.Minute}
+	// disabled
+	assert.NoError(t, checkMaxFlowLifetime(cfg, nil, false))
+	// the flows are evicted before reaching the cap
+	cfg.MaxFlowLifetime = 5 * time.Second
+	assert.Error(t, checkMaxFlowLifetime(cfg, nil, false))
+	// the cap applies to the flows with longer protocol or long-lived timeouts
+	assert.NoError(t, checkMaxFlowLifetime(cfg, map[uint8]flow.ProtocolTimeouts{
+		syscall.IPPROTO_TCP: {Active: 30 * time.Second},
+	}, false))
+	assert.NoError(t, checkMaxFlowLifetime(cfg, nil, true))
+	cfg.MaxFlowLifetime = 2 * time.Second
+	assert.NoError(t, checkMaxFlowLifetime(cfg, nil, false))
+}
+
+func TestFlowsAgent_FlushOnInterfaceRemoval(t *testing.T) {
+	informer := make(test.EventsInformerFake, 10)
+	foo := ifaces.Interface{Name: "foo", Index: 3}
+	informer <- ifaces.Event{Type: ifaces.EventAdded, Interface: foo}
+	informer <- ifaces.Event{Type: ifaces.EventAdded, Interface: ifaces.Interface{Name: "bar", Index: 4}}
+	ebpfTracer := test.NewTracerFake()
+	export := test.NewExporterFake()
+	agent, err := flowsAgent(&Config{
+		CacheActiveTimeout:      time.Hour,
+		CacheMaxFlows:           100,
+		FlushOnInterfaceRemoval: true,
+	}, metrics.NoOp(), informer, ebpfTracer, export.Export, net.ParseIP(agentIP))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		require.NoError(t, agent.Run(ctx))
+	}()
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.Equal(t, StatusStarted, agent.status)
+	}, test2.Interval(10*time.Millisecond))
+
+	// GIVEN flows from two interfaces, whose active timeout is far from expiring
+	now := uint64(monotime.Now())
+	metrics := ebpf.BpfFlowMetrics{Packets: 3, Bytes: 44, StartMonoTimeTs: now, EndMonoTimeTs: now}
+	barFlow := ebpf.BpfFlowId{SrcPort: 789, DstPort: 456, IfIndex: 4}
+	ebpfTracer.AppendLookupResults(map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		key1: metrics, barFlow: metrics,
+	})
+
+	// WHEN one of the interfaces is removed
+	informer <- ifaces.Event{Type: ifaces.EventDeleted, Interface: foo}
+
+	// THEN its flows are flushed promptly, with the interface removal reason
+	exported := export.Get(t, timeout)
+	require.Len(t, exported, 1)
+	assert.Equal(t, key1, exported[0].Id)
+	assert.Equal(t, flow.FlowEndReasonIfaceRemoved, exported[0].EndReason)
+
+	// AND the flows of the other interface are kept until their eviction
+	select {
+	case records := <-export.Messages():
+		assert.Failf(t, "no more flows expected", "%v", records)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestFlowsAgent_MapScanFallback(t *testing.T) {
+	// GIVEN a kernel without ring buffer support
+	ebpfTracer := test.NewTracerFake()
+	ebpfTracer.DisableRingBuf()
+	export := test.NewExporterFake()
+	agent, err := flowsAgent(&Config{
+		CacheActiveTimeout: time.Hour,
+		CacheMaxFlows:      100,
+		MapScanInterval:    10 * time.Millisecond,
+	}, metrics.NoOp(), test.SliceInformerFake{{Name: "foo", Index: 3}},
+		ebpfTracer, export.Export, net.ParseIP(agentIP))
+	require.NoError(t, err)
+
+	// THEN the pipeline is wired without the ring buffer tracer
+	assert.Nil(t, agent.rbTracer)
+	assert.Nil(t, agent.accounter)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		require.NoError(t, agent.Run(ctx))
+	}()
+
+	// AND the flows are read by scanning the map each scan interval, instead of waiting for the
+	// active timeout
+	now := uint64(monotime.Now())
+	ebpfTracer.AppendLookupResults(map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		key1: {Packets: 3, Bytes: 44, StartMonoTimeTs: now, EndMonoTimeTs: now},
+	})
+	exported := export.Get(t, timeout)
+	require.Len(t, exported, 1)
+	assert.Equal(t, key1, exported[0].Id)
+	assert.EqualValues(t, 44, exported[0].Metrics.Bytes)
+
+	// AND flushing doesn't require the accounter
+	agent.Flush()
 }
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"runtime/debug"
+)
+
+// unknownVersion is reported when the agent binary doesn't embed any version information
+const unknownVersion = "unknown"
+
+// agentVersion returns the version of the agent, derived from the build information that is
+// embedded in the binary: the module version if it has been built from a released module, or the
+// VCS revision (suffixed with "-dirty" if the working tree had local modifications) otherwise.
+func agentVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return unknownVersion
+	}
+	return versionFromBuildInfo(info)
+}
+
+func versionFromBuildInfo(info *debug.BuildInfo) string {
+	if info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value
+		}
+	}
+	if revision == "" {
+		if info.Main.Version != "" {
+			return info.Main.Version
+		}
+		return unknownVersion
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified == "true" {
+		revision += "-dirty"
+	}
+	return revision
+}
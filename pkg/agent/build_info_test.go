@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionFromBuildInfo(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		info     debug.BuildInfo
+		expected string
+	}{
+		{name: "module version",
+			info:     debug.BuildInfo{Main: debug.Module{Version: "v0.3.1"}},
+			expected: "v0.3.1"},
+		{name: "vcs revision",
+			info: debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+				{Key: "vcs.modified", Value: "false"},
+			}},
+			expected: "0123456789ab"},
+		{name: "modified vcs revision",
+			info: debug.BuildInfo{Main: debug.Module{Version: "(devel)"}, Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "0123456789abcdef0123"},
+				{Key: "vcs.modified", Value: "true"},
+			}},
+			expected: "0123456789ab-dirty"},
+		{name: "development build without VCS info",
+			info:     debug.BuildInfo{Main: debug.Module{Version: "(devel)"}},
+			expected: "(devel)"},
+		{name: "no info", expected: unknownVersion},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, versionFromBuildInfo(&tc.info))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"encoding/json"
 	"time"
 )
 
@@ -9,6 +10,23 @@ const (
 	ListenWatch      = "watch"
 	DeduperNone      = "none"
 	DeduperFirstCome = "firstCome"
+	ThresholdAny     = "any"
+	ThresholdAll     = "all"
+	TopTalkersBytes  = "bytes"
+	TopTalkersPkts   = "packets"
+	MapFullSpill     = "spill"
+	MapFullDrop      = "drop"
+	MapFullSample    = "sample"
+
+	ExportRateModeDrop  = "drop"
+	ExportRateModeDelay = "delay"
+
+	MapFullEvictAll            = "all"
+	MapFullEvictCompletedFirst = "completedFirst"
+
+	InterfacesPolicyName    = "name"
+	InterfacesPolicyTraffic = "traffic"
+
 	DirectionIngress = "ingress"
 	DirectionEgress  = "egress"
 	DirectionBoth    = "both"
@@ -17,11 +35,46 @@ const (
 	IPTypeIPV4 = "ipv4"
 	IPTypeIPV6 = "ipv6"
 
+	KafkaEncodingProtobuf = "protobuf"
+	KafkaEncodingAvro     = "avro"
+
+	KafkaAcksNone   = "none"
+	KafkaAcksLeader = "leader"
+	KafkaAcksAll    = "all"
+
+	ExportAggregateNone        = "none"
+	ExportAggregateServicePort = "servicePort"
+
 	IPIfaceExternal    = "external"
 	IPIfaceLocal       = "local"
 	IPIfaceNamedPrefix = "name:"
 )
 
+// ExporterConfig configures one of the exporters of the Config.Exporters property
+type ExporterConfig struct {
+	// Export selects the exporter protocol. It accepts the same values as Config.Export.
+	Export string `json:"export"`
+	// ExportFields is the allowlist of the flow record fields that are sent to this exporter (see
+	// flow.NewFieldProjection for the accepted names). If empty, the whole records are sent.
+	ExportFields []string `json:"exportFields,omitempty"`
+	// Aggregate selects how the flows are aggregated before sending them to this exporter, so
+	// the same flows can be sent in full resolution to an exporter and aggregated to another.
+	// Accepted values are: none (default) or servicePort (the flows of each evicted batch are
+	// keyed and merged by service port, as with the SERVICE_PORT_KEY property). The aggregation
+	// works on copies of the flows, so it doesn't affect the rest of the exporters.
+	Aggregate string `json:"aggregate,omitempty"`
+	// Properties overrides, for this exporter, the agent configuration properties, by their
+	// environment variable names (e.g. {"FLOWS_TARGET_PORT": "8125"}).
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+// ExporterConfigs is a list of ExporterConfig that is read from its JSON representation
+type ExporterConfigs []ExporterConfig
+
+func (ec *ExporterConfigs) UnmarshalText(text []byte) error {
+	return json.Unmarshal(text, (*[]ExporterConfig)(ec))
+}
+
 type Config struct {
 	// AgentIP allows overriding the reported Agent IP address on each flow.
 	AgentIP string `env:"AGENT_IP"`
@@ -34,17 +87,190 @@ type Config struct {
 	// in the AgentID field of each flow. Accepted values are: any (default), ipv4, ipv6.
 	// If the AgentIP configuration property is set, this property has no effect.
 	AgentIPType string `env:"AGENT_IP_TYPE" envDefault:"any"`
-	// Export selects the flows' exporter protocol. Accepted values are: grpc (default) or kafka
-	// or ipfix+udp or ipfix+tcp.
+	// ClusterID identifies the cluster where the agent runs. If set (or if TenantID is set), the
+	// "identity" enricher stamps it on each flow, so the flows from multiple clusters can be told
+	// apart in the same collector.
+	ClusterID string `env:"CLUSTER_ID"`
+	// TenantID identifies the tenant that owns the observed traffic. If set (or if ClusterID is
+	// set), the "identity" enricher stamps it on each flow.
+	TenantID string `env:"TENANT_ID"`
+	// TagBuildInfo adds the "buildInfo" enricher, which stamps on each flow the version of the
+	// agent, derived from the build information of its binary, and the hash of its eBPF program,
+	// to correlate anomalies with specific builds in fleets running mixed versions.
+	TagBuildInfo bool `env:"TAG_BUILD_INFO" envDefault:"false"`
+	// NodeName is the name of the node where the agent runs. If empty, the hostname is used.
+	NodeName string `env:"NODE_NAME"`
+	// QualifyInterfaces adds the "interfaceID" enricher, which stamps on each flow an identifier
+	// of its interface that is qualified by NodeName ("<node>/<ifindex>"), so the centralized
+	// consumers don't conflate the interfaces of different nodes with the same index.
+	QualifyInterfaces bool `env:"QUALIFY_INTERFACES" envDefault:"false"`
+	// Export selects the flows' exporter protocol. Accepted values are the names returned by
+	// RegisteredExporters: grpc (default) or kafka or ipfix+udp or ipfix+tcp or file or statsd or
+	// counters or unix or syslog or prometheus-remote-write or elasticsearch or pubsub or sflow or
+	// fifo, as well as the names of the custom exporters registered with RegisterExporter.
 	Export string `env:"EXPORT" envDefault:"grpc"`
+	// Exporters configures multiple exporters the flows are sent to, as a JSON array of
+	// ExporterConfig objects. If set, the Export property is ignored.
+	Exporters ExporterConfigs `env:"EXPORTERS"`
+	// ExportWorkers is the number of goroutines that concurrently submit the flows to the
+	// exporter. It only applies to the exporters that can be safely used concurrently (kafka,
+	// counters or custom exporters implementing exporter.ConcurrentExporter). The rest of exporters
+	// use a single worker.
+	ExportWorkers int `env:"EXPORT_WORKERS" envDefault:"1"`
+	// ExportSendTimeout is the maximum time that the submission of a batch of flows to the
+	// exporter can take. After it, the batch is considered failed and, if ExportSendRetryBatches
+	// is set, kept to be retried before the next batch. It applies to the exporters implementing exporter.Exporter (grpc, kafka,
+	// prometheus-remote-write, elasticsearch, pubsub, counters and the custom exporters). If zero
+	// (default), the submissions are not bounded.
+	ExportSendTimeout time.Duration `env:"EXPORT_SEND_TIMEOUT" envDefault:"0"`
+	// ExportSendRetryBatches is the maximum number of failed or timed-out batches of flows that
+	// are kept to be retried. When it is exceeded, the oldest batches are discarded. If zero
+	// (default), the failed batches are discarded, so the batches are never submitted twice.
+	ExportSendRetryBatches int `env:"EXPORT_SEND_RETRY_BATCHES" envDefault:"0"`
+	// EnableIfCounters makes the "counters" exporter also expose SNMP-style cumulative counters of
+	// the ingress and egress bytes and packets of each interface (ifInOctets, ifOutOctets,
+	// ifInPkts, ifOutPkts), labeled by ifName.
+	EnableIfCounters bool `env:"ENABLE_IF_COUNTERS" envDefault:"false"`
 	// TargetHost is the host name or IP of the target Flow collector, when the EXPORT variable is
-	// set to "grpc"
+	// set to "grpc".
+	// For the "grpc" exporter, it also accepts a comma-separated list of collectors sorted by
+	// priority, which work in active/standby mode. Each entry can optionally override the
+	// TargetPort (e.g. "flp-1,flp-2:9999").
 	TargetHost string `env:"FLOWS_TARGET_HOST"`
 	// TargetPort is the port the target Flow collector, when the EXPORT variable is set to "grpc"
+	// (or the UDP port of the StatsD endpoint, when EXPORT is "statsd", or the port of the syslog
+	// endpoint, when EXPORT is "syslog", or the UDP port of the sFlow collector, when EXPORT is
+	// "sflow")
 	TargetPort int `env:"FLOWS_TARGET_PORT"`
+	// ExportFieldCase sets the naming convention of the keys in the JSON-encoded flows, for the
+	// JSON-based exporters (file, unix, fifo, elasticsearch). Accepted values are: pascal (default), camel, snake.
+	ExportFieldCase string `env:"EXPORT_FIELD_CASE" envDefault:"pascal"`
+	// ExportProtocolNames adds the IANA name of the transport protocol (e.g. tcp, udp, or the
+	// protocol number if it is unknown) to the flows of the JSON-based exporters (file, unix,
+	// fifo, elasticsearch, pubsub), besides the numeric transport protocol field.
+	ExportProtocolNames bool `env:"EXPORT_PROTOCOL_NAMES" envDefault:"false"`
+	// ExportEncoding sets the serialization of the flows for the file, unix and fifo exporters.
+	// Accepted values are: json (default, one record per line) and msgpack (consecutive
+	// MessagePack maps, with the same fields as the JSON records in pascal case).
+	ExportEncoding string `env:"EXPORT_ENCODING" envDefault:"json"`
+	// HeartbeatInterval is how often a synthetic heartbeat record is exported, even if there is
+	// no traffic, so the collectors can tell an idle agent from a dead one. The heartbeats carry
+	// the agent IP, cluster and tenant IDs, zero flow fields, and the "heartbeat" end reason.
+	// The exporters that account flows (e.g. statsd, counters) or can't represent them (ipfix,
+	// sflow) ignore them. If 0 (default), no heartbeats are emitted.
+	HeartbeatInterval time.Duration `env:"HEARTBEAT_INTERVAL" envDefault:"0"`
+	// StatsDPrefix is the prefix of the metrics' names, when the EXPORT variable is set to "statsd".
+	StatsDPrefix string `env:"STATSD_PREFIX" envDefault:"netobserv."`
+	// StatsDTags is a comma-separated list of the flow fields that are submitted as tags of the
+	// StatsD metrics. The flows are aggregated by the values of these fields, so this list bounds
+	// the cardinality of the metrics. Accepted values are: interface, direction, protocol, srcAddr,
+	// dstAddr, srcPort, dstPort, srcMac, dstMac, agentIP, clusterID, tenantID, trafficClass.
+	StatsDTags []string `env:"STATSD_TAGS" envSeparator:"," envDefault:"interface,direction,protocol"`
+	// PromRemoteWriteURL is the URL of the Prometheus remote-write endpoint, when the EXPORT
+	// variable is set to "prometheus-remote-write" (e.g. http://prometheus:9090/api/v1/write).
+	PromRemoteWriteURL string `env:"PROM_REMOTE_WRITE_URL"`
+	// PromRemoteWriteInterval is how often the accumulated flow counters are submitted to the
+	// Prometheus remote-write endpoint.
+	PromRemoteWriteInterval time.Duration `env:"PROM_REMOTE_WRITE_INTERVAL" envDefault:"30s"`
+	// PromRemoteWriteLabels is a comma-separated list of the flow fields that are submitted as
+	// labels of the remote-write time series. Accepted values are the same as in StatsDTags.
+	PromRemoteWriteLabels []string `env:"PROM_REMOTE_WRITE_LABELS" envSeparator:"," envDefault:"interface,direction,protocol"`
+	// PromRemoteWriteMaxSeries bounds the number of label combinations of the remote-write
+	// counters. The flows with new label values beyond this limit are accounted in a series whose
+	// labels have the "_other" value.
+	PromRemoteWriteMaxSeries int `env:"PROM_REMOTE_WRITE_MAX_SERIES" envDefault:"1000"`
+	// ElasticsearchURL is the URL of the Elasticsearch cluster, when the EXPORT variable is set to
+	// "elasticsearch" (e.g. https://elasticsearch:9200).
+	ElasticsearchURL string `env:"ELASTICSEARCH_URL"`
+	// ElasticsearchIndex is the name of the index where the flows are stored. The {date}
+	// placeholder is replaced by the UTC start date of each flow (e.g. netflow-2023.03.01).
+	ElasticsearchIndex string `env:"ELASTICSEARCH_INDEX" envDefault:"netflow-{date}"`
+	// ElasticsearchUsername and ElasticsearchPassword are the credentials for the basic
+	// authentication. If empty, the requests are not authenticated.
+	ElasticsearchUsername string `env:"ELASTICSEARCH_USERNAME"`
+	ElasticsearchPassword string `env:"ELASTICSEARCH_PASSWORD"`
+	// ElasticsearchBatchSize is the maximum number of flows that are submitted in a single bulk
+	// request.
+	ElasticsearchBatchSize int `env:"ELASTICSEARCH_BATCH_SIZE" envDefault:"1000"`
+	// ElasticsearchRetries is the number of times that the flows that failed to be indexed with a
+	// transient error are submitted again.
+	ElasticsearchRetries int `env:"ELASTICSEARCH_RETRIES" envDefault:"3"`
+	// ElasticsearchRetryBackoff is the time to wait before the first retry. It is doubled in each
+	// retry.
+	ElasticsearchRetryBackoff time.Duration `env:"ELASTICSEARCH_RETRY_BACKOFF" envDefault:"1s"`
+	// PubSubProject and PubSubTopic identify the Google Pub/Sub topic where the flows are
+	// published, when the EXPORT variable is set to "pubsub".
+	PubSubProject string `env:"PUBSUB_PROJECT"`
+	PubSubTopic   string `env:"PUBSUB_TOPIC"`
+	// PubSubEndpoint is the URL of the Pub/Sub API. It is overridden by the PUBSUB_EMULATOR_HOST
+	// environment variable, if set.
+	PubSubEndpoint string `env:"PUBSUB_ENDPOINT" envDefault:"https://pubsub.googleapis.com"`
+	// PubSubOrderingKey is the name of the flow field whose value is the ordering key of the
+	// published messages. Accepted values are the same as in StatsDTags. If empty (default), the
+	// messages have no ordering key.
+	PubSubOrderingKey string `env:"PUBSUB_ORDERING_KEY"`
+	// PubSubBatchSize is the maximum number of flows that are published in a single request
+	// (at most 1000).
+	PubSubBatchSize int `env:"PUBSUB_BATCH_SIZE" envDefault:"1000"`
+	// PubSubRetries is the number of times that a publish request that failed with a transient
+	// error is submitted again.
+	PubSubRetries int `env:"PUBSUB_RETRIES" envDefault:"3"`
+	// PubSubRetryBackoff is the time to wait before the first retry. It is doubled in each retry.
+	PubSubRetryBackoff time.Duration `env:"PUBSUB_RETRY_BACKOFF" envDefault:"1s"`
+	// PubSubBufferLength is the maximum number of flows that couldn't be published after all the
+	// retries, and are kept to be published again in the next export. When it is exceeded, the
+	// oldest flows are discarded.
+	PubSubBufferLength int `env:"PUBSUB_BUFFER_LENGTH" envDefault:"10000"`
+	// SFlowCounterSamples makes the "sflow" exporter also send counter samples with the
+	// cumulative traffic of the interfaces of the exported flows.
+	SFlowCounterSamples bool `env:"SFLOW_COUNTER_SAMPLES" envDefault:"false"`
+	// SyslogTransport is the transport protocol of the syslog endpoint, when the EXPORT variable
+	// is set to "syslog". Accepted values are: udp (default), tcp.
+	SyslogTransport string `env:"SYSLOG_TRANSPORT" envDefault:"udp"`
+	// SyslogFacility is the facility of the syslog messages, as named in RFC 5424 (kern, user,
+	// daemon, local0...local7, etc.)
+	SyslogFacility string `env:"SYSLOG_FACILITY" envDefault:"local0"`
+	// SyslogSeverity is the severity of the syslog messages, as named in RFC 5424 (emerg, alert,
+	// crit, err, warning, notice, info, debug)
+	SyslogSeverity string `env:"SYSLOG_SEVERITY" envDefault:"info"`
+	// SyslogMaxMessageSize is the maximum size, in bytes, of each syslog message. Longer messages
+	// are truncated. It must be at least 480.
+	SyslogMaxMessageSize int `env:"SYSLOG_MAX_MESSAGE_SIZE" envDefault:"2048"`
+	// FilePath is the path of the file where the flows are appended as JSON lines, when the
+	// EXPORT variable is set to "file".
+	FilePath string `env:"FILE_PATH"`
+	// FileDedupWindow is the number of last records written to the file that are remembered
+	// to skip writing identical records (e.g. after a restart of the agent). If 0 (default),
+	// deduplication is disabled.
+	FileDedupWindow int `env:"FILE_DEDUP_WINDOW" envDefault:"0"`
+	// UnixSocketPath is the path of the Unix domain socket that the agent creates to stream the
+	// flows as JSON lines to a local consumer, when the EXPORT variable is set to "unix".
+	UnixSocketPath string `env:"UNIX_SOCKET_PATH"`
+	// FifoPath is the path of the named pipe (FIFO) where the flows are written as JSON lines,
+	// when the EXPORT variable is set to "fifo". If it doesn't exist, the agent creates it.
+	FifoPath string `env:"FIFO_PATH"`
+	// WebSocketPort is the port of a WebSocket endpoint that streams the exported flows, as JSON
+	// records, to the connected clients (e.g. live UIs). It is a read-only feed that works besides
+	// the exporter selected by the EXPORT variable. If 0 (default), the endpoint is disabled.
+	WebSocketPort int `env:"WEBSOCKET_PORT" envDefault:"0"`
+	// WebSocketClientBuffer is the number of flows that can be queued for each WebSocket client.
+	// When the queue of a slow client is full, its oldest flows are dropped.
+	WebSocketClientBuffer int `env:"WEBSOCKET_CLIENT_BUFFER" envDefault:"1000"`
+	// SinkCompression is the compression of the stream written by the "file" and "unix"
+	// exporters. Accepted values are: none (default), gzip, zstd. The stream is flushed after each
+	// batch of flows, so it can be decompressed incrementally.
+	SinkCompression string `env:"SINK_COMPRESSION" envDefault:"none"`
 	// GRPCMessageMaxFlows specifies the limit, in number of flows, of each GRPC message. Messages
 	// larger than that number will be split and submitted sequentially.
 	GRPCMessageMaxFlows int `env:"GRPC_MESSAGE_MAX_FLOWS" envDefault:"10000"`
+	// GRPCFailbackInterval specifies, when multiple collectors are specified in TargetHost and the
+	// agent failed over to a collector with lower priority, how often the agent checks whether
+	// the collectors with higher priority are available again, to switch back to them.
+	GRPCFailbackInterval time.Duration `env:"GRPC_FAILBACK_INTERVAL" envDefault:"30s"`
+	// GRPCSendTimeout is the maximum time that each request to a GRPC collector can take. After
+	// it, the request fails and the flows are sent to the next collector, if any. If it isn't
+	// positive, the default is used.
+	GRPCSendTimeout time.Duration `env:"GRPC_SEND_TIMEOUT" envDefault:"10s"`
 	// Interfaces contains the interface names from where flows will be collected. If empty, the agent
 	// will fetch all the interfaces in the system, excepting the ones listed in ExcludeInterfaces.
 	// If an entry is enclosed by slashes (e.g. `/br-/`), it will match as regular expression,
@@ -55,20 +281,231 @@ type Config struct {
 	// If an entry is enclosed by slashes (e.g. `/br-/`), it will match as regular expression,
 	// otherwise it will be matched as a case-sensitive string.
 	ExcludeInterfaces []string `env:"EXCLUDE_INTERFACES" envSeparator:"," envDefault:"lo"`
+	// MaxInterfaces limits the number of interfaces that the agent attaches to, to bound its
+	// resource usage on nodes with thousands of interfaces (e.g. veths). When the limit is
+	// reached, the new interfaces are queued until an attached interface is removed. If 0
+	// (default), the number of interfaces is not limited.
+	MaxInterfaces int `env:"MAX_INTERFACES" envDefault:"0"`
+	// MaxInterfacesPolicy selects which queued interface is attached when a slot is freed.
+	// Accepted values are: name (default), the first interface by name order; and traffic, the
+	// interface that received and transmitted the most bytes, according to its sysfs statistics.
+	MaxInterfacesPolicy string `env:"MAX_INTERFACES_POLICY" envDefault:"name"`
 	// BuffersLength establishes the length of communication channels between the different processing
 	// stages
 	BuffersLength int `env:"BUFFERS_LENGTH" envDefault:"50"`
+	// MaxExportRate, if higher than zero, caps the number of flow records per second that are
+	// submitted to the exporter, to protect a shared collector. It is applied to the final stream
+	// of flows, after any sampling or filtering. Zero (default) means unlimited.
+	MaxExportRate int `env:"MAX_EXPORT_RATE" envDefault:"0"`
+	// MaxExportRateMode specifies what happens to the records that exceed the MaxExportRate.
+	// Accepted values are: drop (default), which discards them; and delay, which queues them until
+	// they can be exported within the rate.
+	MaxExportRateMode string `env:"MAX_EXPORT_RATE_MODE" envDefault:"drop"`
+	// MaxExportRateQueue is the maximum number of records that are queued in the delay mode of
+	// MaxExportRate. The records that don't fit in the queue are dropped.
+	MaxExportRateQueue int `env:"MAX_EXPORT_RATE_QUEUE" envDefault:"10000"`
+	// ExportSortKeys sorts the records of each exported batch by the provided record fields, in
+	// order of precedence (see flow.NewRecordSorter for the accepted names). If empty (default),
+	// the records are exported unsorted.
+	ExportSortKeys []string `env:"EXPORT_SORT_KEYS" envSeparator:","`
 	// ExporterBufferLength establishes the length of the buffer of flow batches (not individual flows)
 	// that can be accumulated before the Kafka or GRPC exporter. When this buffer is full (e.g.
 	// because the Kafka or GRPC endpoint is slow), incoming flow batches will be dropped. If unset,
 	// its value is the same as the BUFFERS_LENGTH property.
 	ExporterBufferLength int `env:"EXPORTER_BUFFER_LENGTH"`
+	// EnableBackpressure enables an adaptive load shedding loop: when the processing stages can't
+	// keep up with the flows (their buffer is almost full or flows are dropped), the agent raises
+	// a pressure level that makes the eBPF program sample the packets more aggressively, instead of
+	// silently dropping the flows. The pressure is released once the pipeline recovers.
+	EnableBackpressure bool `env:"ENABLE_BACKPRESSURE" envDefault:"false"`
+	// BackpressureInterval is how often the saturation of the processing stages is checked, and
+	// the pressure level is increased or decreased by one.
+	BackpressureInterval time.Duration `env:"BACKPRESSURE_INTERVAL" envDefault:"5s"`
+	// BackpressureMaxLevel is the maximum pressure level. For a level N, only 1 out of 2^N packets
+	// is accounted, on top of the configured Sampling.
+	BackpressureMaxLevel int `env:"BACKPRESSURE_MAX_LEVEL" envDefault:"4"`
 	// CacheMaxFlows specifies how many flows can be accumulated in the accounting cache before
 	// being flushed for its later export
 	CacheMaxFlows int `env:"CACHE_MAX_FLOWS" envDefault:"5000"`
+	// MapFullPolicy specifies how to handle the new flows that can't be added to the eBPF map
+	// because it is full. Accepted values are: spill (default), which forwards them to userspace
+	// through the ring buffer; drop, which discards them; and sample, which forwards one out of
+	// each MapFullSampling flows and discards the rest. The flows are discarded by the eBPF
+	// program, before they are submitted to the ring buffer.
+	MapFullPolicy string `env:"MAP_FULL_POLICY" envDefault:"spill"`
+	// MapFullSampling is the ratio of flows that are forwarded when MapFullPolicy is "sample".
+	// E.g. if set to 10, one out of 10 flows is forwarded.
+	MapFullSampling int `env:"MAP_FULL_SAMPLING" envDefault:"10"`
+	// MapFullEviction specifies which flows are evicted from the eBPF map when it is full.
+	// Accepted values are: all (default), which evicts all the flows; and completedFirst, which
+	// only evicts the TCP flows whose FIN or RST has been observed, so the active flows keep
+	// being aggregated in the map. If less than 10% of CacheMaxFlows are completed, all the flows
+	// are evicted.
+	MapFullEviction string `env:"MAP_FULL_EVICTION" envDefault:"all"`
+	// RingBufSamplingRate samples the flows that are received through the ring buffer (e.g.
+	// because the eBPF map was full or busy), independently of the flows that are aggregated in
+	// the eBPF map. E.g. if set to 10, one out of 10 flows is forwarded. It reduces the userspace
+	// load during eviction storms while keeping the aggregated flows complete. 0 or 1 (default)
+	// disables it.
+	RingBufSamplingRate int `env:"RINGBUF_SAMPLING_RATE" envDefault:"1"`
+	// RingBufFallback, if the kernel doesn't support eBPF ring buffers, runs the agent in a
+	// degraded mode where the flows are only read by periodically scanning the eBPF map, each
+	// MapScanInterval. Then the flows that can't be added to the full map are dropped. If false,
+	// the agent fails to start on such kernels.
+	RingBufFallback bool `env:"RINGBUF_FALLBACK" envDefault:"true"`
+	// MapScanInterval is the period of the eBPF map scans when the ring buffer isn't supported
+	// by the kernel. If zero (default), it is CacheActiveTimeout.
+	MapScanInterval time.Duration `env:"MAP_SCAN_INTERVAL" envDefault:"0"`
+	// RawRecordDumpFile is the path of a file where the raw events received from the ring buffer
+	// are recorded, before being parsed, so they can be replayed offline for debugging. If empty
+	// (default), the events are not recorded.
+	RawRecordDumpFile string `env:"RAW_RECORD_DUMP_FILE"`
+	// RawRecordDumpMaxBytes is the maximum size of the RawRecordDumpFile. Once reached, no more
+	// events are recorded. 0 means unbounded.
+	RawRecordDumpMaxBytes int64 `env:"RAW_RECORD_DUMP_MAX_BYTES" envDefault:"104857600"`
+	// CacheLongLivedProtocols enables a separate userspace accounting cache for the flows that are
+	// expected to be long-lived, so a storm of ephemeral flows doesn't evict them. It is a
+	// comma-separated list of the transport protocols (e.g. "tcp") whose flows are accounted in
+	// that cache. If empty (default), all the flows are accounted in the same cache.
+	CacheLongLivedProtocols []string `env:"CACHE_LONG_LIVED_PROTOCOLS" envSeparator:","`
+	// CacheLongLivedMaxFlows is the capacity of the long-lived flows cache
+	CacheLongLivedMaxFlows int `env:"CACHE_LONG_LIVED_MAX_FLOWS" envDefault:"5000"`
+	// CacheLongLivedTimeout is the maximum duration that the flows are kept in the long-lived
+	// flows cache before being flushed
+	CacheLongLivedTimeout time.Duration `env:"CACHE_LONG_LIVED_TIMEOUT" envDefault:"15s"`
 	// CacheActiveTimeout specifies the maximum duration that flows are kept in the accounting
 	// cache before being flushed for its later export
 	CacheActiveTimeout time.Duration `env:"CACHE_ACTIVE_TIMEOUT" envDefault:"5s"`
+	// MaxBatchAge forces the eviction of the flows in the userspace accounting cache once the
+	// oldest of them has been cached for this duration, independently of CacheActiveTimeout and
+	// CacheMaxFlows. It bounds the export latency at the cost of smaller batches. If zero
+	// (default), the flows are evicted on CacheActiveTimeout or when CacheMaxFlows is reached.
+	MaxBatchAge time.Duration `env:"MAX_BATCH_AGE" envDefault:"0"`
+	// CacheFlushJitter spreads the flush of the flows within a window of this duration, to avoid
+	// the export spikes of flushing all the flows at the same time. Each flow is flushed after
+	// CacheActiveTimeout since it started, plus a per-flow offset within the window. If zero
+	// (default), all the flows are flushed together every CacheActiveTimeout.
+	CacheFlushJitter time.Duration `env:"CACHE_FLUSH_JITTER" envDefault:"0"`
+	// MinFlushFlows skips the flush of each CacheActiveTimeout window while less than this number
+	// of flows are estimated to be in the eBPF map, from the flows per window of the last flush,
+	// so they are retained until the next window. It
+	// avoids exporting tiny batches constantly on quiet nodes. If zero (default), the flows are
+	// flushed every CacheActiveTimeout.
+	MinFlushFlows int `env:"MIN_FLUSH_FLOWS" envDefault:"0"`
+	// MinFlushMaxHold is the maximum time that the flows are retained, since the first skipped
+	// flush, when there are less than MinFlushFlows flows
+	MinFlushMaxHold time.Duration `env:"MIN_FLUSH_MAX_HOLD" envDefault:"1m"`
+	// ProtocolTimeouts is a comma-separated list of protocol:active:inactive entries that override
+	// the eviction timeouts of the flows of a transport protocol (e.g. "tcp:30s:,udp::2s"). The
+	// active timeout replaces the CacheActiveTimeout for the flows of that protocol, and the
+	// inactive timeout evicts them once no packet has been observed during that time. Any of both
+	// can be left empty to keep the default behavior.
+	ProtocolTimeouts []string `env:"PROTOCOL_TIMEOUTS" envSeparator:","`
+	// MaxFlowLifetime forces the export of any flow that started longer than this duration ago,
+	// independently of the CacheActiveTimeout. The next packets of the flow are accounted in a
+	// new flow record. It must be lower than the longest active timeout. If zero (default), the
+	// flows lifetime is not capped.
+	MaxFlowLifetime time.Duration `env:"MAX_FLOW_LIFETIME" envDefault:"0"`
+	// TCPCloseGracePeriod enables the prompt export of the closed TCP flows (whose FIN or RST has
+	// been observed), once no packet has been observed for them during this grace period,
+	// independently of the CacheActiveTimeout. If zero (default), the closed flows are exported
+	// with the rest of the flows.
+	TCPCloseGracePeriod time.Duration `env:"TCP_CLOSE_GRACE_PERIOD" envDefault:"0"`
+	// FlushOnInterfaceRemoval evicts the flows of an interface as soon as it is removed (e.g.
+	// because its pod was deleted), instead of waiting for the CacheActiveTimeout. They are
+	// exported with the "iface-removed" end reason.
+	FlushOnInterfaceRemoval bool `env:"FLUSH_ON_INTERFACE_REMOVAL" envDefault:"false"`
+	// StartupBackfillLimit caps the number of pre-existing flows (the TCP flows whose handshake
+	// hasn't been observed) that are admitted for each interface among the flows started during
+	// the first CacheActiveTimeout window after the agent starts. It smooths the spike of flows
+	// from the connections that were already active on startup. The excess flows are dropped.
+	// Zero (default) means unlimited.
+	StartupBackfillLimit int `env:"STARTUP_BACKFILL_LIMIT" envDefault:"0"`
+	// NormalizeOrientation canonicalizes the orientation of the flows before they are deduplicated
+	// and exported, so the endpoint with the lower IP address (or the lower port, if the IPs are
+	// equal) is always reported as the source. The direction and the handshake flags of the
+	// reversed flows are flipped accordingly, and the flows of each evicted batch that share the
+	// canonical identifier (e.g. both directions of a connection) are merged in a single record,
+	// which carries the flags of both directions.
+	// Disabled by default, so the source and destination fields preserve the actual direction of
+	// the packets.
+	NormalizeOrientation bool `env:"NORMALIZE_ORIENTATION" envDefault:"false"`
+	// MergeICMPEcho merges the ICMP echo request flows with the echo reply flows answering them,
+	// from the same interface, into a single bidirectional record that reports the round trip
+	// time. The flows that aren't paired within MergeICMPEchoTimeout are exported
+	// unidirectionally.
+	MergeICMPEcho bool `env:"MERGE_ICMP_ECHO" envDefault:"false"`
+	// MergeICMPEchoTimeout is how long an ICMP echo flow is held waiting for its counterpart. It
+	// should be longer than CacheActiveTimeout, as the request and reply flows may be evicted in
+	// consecutive periods.
+	MergeICMPEchoTimeout time.Duration `env:"MERGE_ICMP_ECHO_TIMEOUT" envDefault:"10s"`
+	// ServicePortKey keys the TCP and UDP flows by their service port: the endpoint with the
+	// lower port is reported as the destination, the client port is zeroed (the direction of
+	// the reversed flows is flipped accordingly), and the flows of each evicted batch that share
+	// the resulting identifier are merged. Then the views from
+	// the client and the server side of a connection, as well as the connections from different
+	// client ports to the same service, are aggregated together.
+	ServicePortKey bool `env:"SERVICE_PORT_KEY" envDefault:"false"`
+	// SrcAggPrefix and DstAggPrefix are the lengths of the network prefixes that the source and
+	// destination IPv4 addresses are masked to (e.g. 24), so the flows of each evicted batch
+	// between the same subnets are merged. 32 (default) or 0 keep the addresses unchanged.
+	SrcAggPrefix int `env:"SRC_AGG_PREFIX" envDefault:"32"`
+	DstAggPrefix int `env:"DST_AGG_PREFIX" envDefault:"32"`
+	// SrcAggPrefixV6 and DstAggPrefixV6 are the equivalent to SrcAggPrefix and DstAggPrefix for
+	// the IPv6 addresses (e.g. 64). 128 (default) or 0 keep the addresses unchanged.
+	SrcAggPrefixV6 int `env:"SRC_AGG_PREFIX_V6" envDefault:"128"`
+	DstAggPrefixV6 int `env:"DST_AGG_PREFIX_V6" envDefault:"128"`
+	// DropExportTraffic drops the flows from or to the collectors of the configured exporters
+	// (e.g. the gRPC collectors, the Kafka brokers, or the hosts of the exporters' URLs), so the
+	// agent doesn't observe the traffic of its own telemetry.
+	DropExportTraffic bool `env:"DROP_EXPORT_TRAFFIC" envDefault:"false"`
+	// DropExportTrafficRefresh is how often the host names of the collectors are resolved again,
+	// when DropExportTraffic is enabled.
+	DropExportTrafficRefresh time.Duration `env:"DROP_EXPORT_TRAFFIC_REFRESH" envDefault:"1m"`
+	// DropEmptyFlows drops the flows without any accounted packet nor byte, so they don't pollute
+	// the downstream counts.
+	DropEmptyFlows bool `env:"DROP_EMPTY_FLOWS" envDefault:"true"`
+	// MinBytes drops the flows whose number of bytes is below this value. Zero (default) disables
+	// this threshold.
+	MinBytes uint64 `env:"MIN_BYTES" envDefault:"0"`
+	// MinPackets drops the flows whose number of packets is below this value. Zero (default)
+	// disables this threshold.
+	MinPackets uint32 `env:"MIN_PACKETS" envDefault:"0"`
+	// ThresholdMatch specifies, when both MinBytes and MinPackets are set, whether the flows must
+	// reach any of the thresholds to be exported, or all of them. Accepted values are: any
+	// (default) or all.
+	ThresholdMatch string `env:"THRESHOLD_MATCH" envDefault:"any"`
+	// MinFlowDuration drops the flows whose duration, between their first and last packets, is
+	// below this value. Zero (default) disables this filter.
+	MinFlowDuration time.Duration `env:"MIN_FLOW_DURATION" envDefault:"0"`
+	// MinFlowDurationKeepSinglePacket tells whether the single-packet flows, whose duration is
+	// zero, are exported regardless of MinFlowDuration. If false (default), they are dropped.
+	MinFlowDurationKeepSinglePacket bool `env:"MIN_FLOW_DURATION_KEEP_SINGLE_PACKET" envDefault:"false"`
+	// TopNTalkers, if higher than zero, replaces the exported flows by a compact "top talkers"
+	// feed: at the end of each TopNTalkersWindow, only the TopNTalkers flows with the most bytes
+	// or packets (see TopNTalkersBy) are exported. Zero (default) exports all the flows.
+	TopNTalkers int `env:"TOP_N_TALKERS" envDefault:"0"`
+	// TopNTalkersBy specifies the metric that ranks the top talkers. Accepted values are: bytes
+	// (default) or packets.
+	TopNTalkersBy string `env:"TOP_N_TALKERS_BY" envDefault:"bytes"`
+	// TopNTalkersWindow is the period of the top talkers ranking. If zero (default), it is
+	// CacheActiveTimeout, so each flow is ranked once per eviction.
+	TopNTalkersWindow time.Duration `env:"TOP_N_TALKERS_WINDOW" envDefault:"0"`
+	// PortScanThreshold, if higher than zero, flags as ScanSuspect the flows from the source IPs
+	// that hit at least this number of distinct destination ports within a PortScanWindow. Zero
+	// (default) disables the port scan detection.
+	PortScanThreshold int `env:"PORT_SCAN_THRESHOLD" envDefault:"0"`
+	// PortScanWindow is the period during which the distinct destination ports of each source are
+	// counted. If zero (default), it is CacheActiveTimeout, the period of the flows' eviction.
+	PortScanWindow time.Duration `env:"PORT_SCAN_WINDOW" envDefault:"0"`
+	// PortScanMaxSources is the maximum number of source IPs that are tracked by the port scan
+	// detection within a window. The flows from the sources beyond this limit are never flagged.
+	PortScanMaxSources int `env:"PORT_SCAN_MAX_SOURCES" envDefault:"10000"`
+	// PortScanObservedPorts, if higher than zero, exports at the end of each PortScanWindow one
+	// record per source that carries, as ObservedPorts, the sequence of distinct destination
+	// ports that the source has hit within the window, up to this number of ports. It requires
+	// the port scan detection to be enabled. Zero (default) disables it.
+	PortScanObservedPorts int `env:"PORT_SCAN_OBSERVED_PORTS" envDefault:"0"`
 	// Deduper specifies the deduper type. Accepted values are "none" (disabled) and "firstCome".
 	// When enabled, it will detect duplicate flows (flows that have been detected e.g. through
 	// both the physical and a virtual interface).
@@ -82,20 +519,140 @@ type Config struct {
 	DeduperFCExpiry time.Duration `env:"DEDUPER_FC_EXPIRY"`
 	// DeduperJustMark will just mark duplicates (boolean field) instead of dropping them.
 	DeduperJustMark bool `env:"DEDUPER_JUST_MARK"`
+	// DeduperMaxEntries bounds the number of flows that the deduplicator remembers. When the
+	// limit is reached, the least recently seen flows are forgotten, so their next occurrence
+	// could be forwarded from a different interface. Zero (default) means unbounded.
+	DeduperMaxEntries int `env:"DEDUPER_MAX_ENTRIES" envDefault:"0"`
+	// DeduperFused merges the flows by service port (ServicePortKey) and deduplicates them in a
+	// single pipeline stage, with the same output as the separate stages. It only applies if both
+	// ServicePortKey and the "firstCome" Deduper are enabled, and StartupBackfillLimit and the
+	// network prefix aggregation (SrcAggPrefix, DstAggPrefix...) are disabled.
+	DeduperFused bool `env:"DEDUPER_FUSED" envDefault:"false"`
+	// DeduperPreferInterfaces contains the names of the interfaces (e.g. the physical NICs) whose
+	// flows are forwarded by the "firstCome" Deduper instead of their duplicates from the rest of
+	// interfaces (e.g. virtual bridges), regardless of which one arrives first. If an entry is
+	// enclosed by slashes (e.g. `/^eth/`), it will match as regular expression, otherwise it will
+	// be matched as a case-sensitive string.
+	DeduperPreferInterfaces []string `env:"DEDUPER_PREFER_INTERFACES" envSeparator:","`
+	// DeduperPurgeOnFlap makes the "firstCome" Deduper forget the flows that it registered from an
+	// interface when the interface goes down or is removed, so the deduplication restarts cleanly
+	// when the interface comes back, instead of suppressing its flows until the entries expire.
+	DeduperPurgeOnFlap bool `env:"DEDUPER_PURGE_ON_FLAP" envDefault:"true"`
 	// Direction allows selecting which flows to trace according to its direction. Accepted values
 	// are "ingress", "egress" or "both" (default).
 	Direction string `env:"DIRECTION" envDefault:"both"`
+	// DirectionInference selects how the direction of the flows is reported. Accepted values are
+	// "kernel" (default), which keeps the direction of the eBPF hook where the packets were
+	// captured; "heuristic", which keeps the kernel direction when it is reported, and otherwise
+	// infers it from whether the source (egress) or destination (ingress) addresses are local to
+	// the node; and "none", which reports an unknown direction (value 2).
+	DirectionInference string `env:"DIRECTION_INFERENCE" envDefault:"kernel"`
+	// Enrichers is a comma-separated list of the enrichers that will decorate each flow with
+	// extra metadata, in the same order as they are listed. Built-in enrichers are "interfaceName",
+	// "agentIP", "tcpState", "service", "reverseDNS", "trafficClass" and "identity" (the last five
+	// not enabled by default). Other enrichers can be registered by importing the packages that provide them.
+	Enrichers []string `env:"ENRICHERS" envSeparator:"," envDefault:"interfaceName,agentIP"`
+	// TrafficClasses is a comma-separated list of the traffic classes ("unicast", "multicast" or
+	// "broadcast") of the flows that are forwarded. If empty, the flows of all the classes are
+	// forwarded, excepting the ones listed in ExcludeTrafficClasses.
+	TrafficClasses []string `env:"TRAFFIC_CLASSES" envSeparator:","`
+	// ExcludeTrafficClasses is a comma-separated list of the traffic classes of the flows that
+	// are discarded (e.g. "broadcast,multicast" to drop the ARP, DHCP or mDNS chatter).
+	ExcludeTrafficClasses []string `env:"EXCLUDE_TRAFFIC_CLASSES" envSeparator:","`
+	// ServicePorts is a comma-separated list of port:name entries that overrides the mapping of
+	// destination ports to well-known service names used by the "service" enricher
+	// (e.g. "80:http,443:https,5432:postgresql"). If empty, a default mapping is used.
+	ServicePorts []string `env:"SERVICE_PORTS" envSeparator:","`
+	// EnableProcessInfo adds the "process" enricher, which decorates the flows from the local
+	// sockets with the PID and name of the process that owns the socket. It requires the agent to
+	// run in the host PID namespace.
+	EnableProcessInfo bool `env:"ENABLE_PROCESS_INFO" envDefault:"false"`
+	// EnableContainerInfo adds the "container" enricher, which decorates the flows from the local
+	// sockets with the container and pod of their cgroup. It requires the cgroup v2 hierarchy of
+	// the host to be mounted in /sys/fs/cgroup.
+	EnableContainerInfo bool `env:"ENABLE_CONTAINER_INFO" envDefault:"false"`
+	// EnableNextHop adds the "nextHop" enricher, which decorates the flows with the gateway of the
+	// local route towards their destination. The routing table is reloaded on route changes.
+	EnableNextHop bool `env:"ENABLE_NEXT_HOP" envDefault:"false"`
+	// EnableQUICTracking adds the "quic" enricher, which decorates the UDP flows with the version
+	// and connection IDs of the QUIC long header at the start of their payload sample. If
+	// PayloadSampleBytes is 0, the payload of the UDP flows on port 443 is sampled only for the
+	// QUIC parsing, and it is not exported.
+	EnableQUICTracking bool `env:"ENABLE_QUIC_TRACKING" envDefault:"false"`
+	// EnableReverseDNS adds the "reverseDNS" enricher, which decorates the flows with the
+	// hostnames of their external (non-private) addresses. The addresses are resolved
+	// asynchronously and cached, so the hostnames are attached to the flows after the first
+	// lookup of their addresses succeeds.
+	EnableReverseDNS bool `env:"ENABLE_REVERSE_DNS" envDefault:"false"`
+	// ReverseDNSCacheTTL is the time that the resolved hostnames are cached
+	ReverseDNSCacheTTL time.Duration `env:"REVERSE_DNS_CACHE_TTL" envDefault:"1h"`
+	// ReverseDNSMaxEntries bounds the number of addresses in the reverse DNS cache
+	ReverseDNSMaxEntries int `env:"REVERSE_DNS_MAX_ENTRIES" envDefault:"10000"`
+	// ReverseDNSLookupsPerSec limits the rate of reverse DNS lookups sent to the resolvers
+	ReverseDNSLookupsPerSec int `env:"REVERSE_DNS_LOOKUPS_PER_SEC" envDefault:"20"`
+	// SubnetLabelsFile is the path of a CSV or YAML file that maps subnets to labels (e.g. team,
+	// environment). If set, it adds the "subnetLabels" enricher, which decorates the flows with
+	// the labels of the most specific subnets containing their source and destination addresses.
+	SubnetLabelsFile string `env:"SUBNET_LABELS_FILE"`
+	// SubnetLabelsReloadPeriod is how often the SubnetLabelsFile is checked for changes, to reload
+	// it. If 0, the file is only loaded at startup.
+	SubnetLabelsReloadPeriod time.Duration `env:"SUBNET_LABELS_RELOAD_PERIOD" envDefault:"30s"`
+	// GeoIPDatabase is the path of a MaxMind DB file (e.g. GeoLite2-Country.mmdb). If set, it adds
+	// the "geoip" enricher, which decorates the flows with the countries of their source and
+	// destination addresses.
+	GeoIPDatabase string `env:"GEOIP_DATABASE"`
+	// EnableKubernetesMetadata adds the "kubernetes" enricher, which decorates the flows with the
+	// namespaces and names of the pods of their source and destination addresses. The pods of the
+	// whole cluster are watched.
+	EnableKubernetesMetadata bool `env:"ENABLE_KUBERNETES_METADATA" envDefault:"false"`
+	// Kubeconfig is the path of the kubeconfig file used by the "kubernetes" enricher. If empty,
+	// the in-cluster configuration is used.
+	Kubeconfig string `env:"KUBECONFIG"`
+	// RecordIDs sets a deterministic RecordID in each exported record, derived from its flow key
+	// and window start, so the collectors can upsert the records that are exported more than once.
+	RecordIDs bool `env:"RECORD_IDS" envDefault:"false"`
+	// MaxFieldStringLen is the maximum length, in bytes, of the string fields of the exported
+	// flows (e.g. hostnames, interface names, labels). The longer fields are truncated and end
+	// with "...". If 0, the fields are never truncated.
+	MaxFieldStringLen int `env:"MAX_FIELD_STRING_LEN" envDefault:"1024"`
 	// Logger level. From more to less verbose: trace, debug, info, warn, error, fatal, panic.
 	LogLevel string `env:"LOG_LEVEL" envDefault:"info"`
+	// IncludeRawBpf attaches to each flow the hex-encoded eBPF flow identifier and metrics, as
+	// read from the eBPF maps, so they can be compared with the decorated fields when debugging.
+	// Only the JSON-based exporters (file, unix, fifo) include them.
+	IncludeRawBpf bool `env:"INCLUDE_RAW_BPF" envDefault:"false"`
 	// Sampling holds the rate at which packets should be sampled and sent to the target collector.
 	// E.g. if set to 100, one out of 100 packets, on average, will be sent to the target collector.
 	Sampling int `env:"SAMPLING" envDefault:"0"`
+	// FlowSampling samples the flows instead of the packets: one out of each FlowSampling flows is
+	// forwarded, and the decision is kept during the whole flow lifetime, so the sampled flows
+	// report their full byte and packet counts. 0 or 1 (default) disables it.
+	FlowSampling int `env:"FLOW_SAMPLING" envDefault:"0"`
+	// FlowSamplingExpiry is the time after which the sampling decision of a flow is forgotten if
+	// no record has been observed for it
+	FlowSamplingExpiry time.Duration `env:"FLOW_SAMPLING_EXPIRY" envDefault:"5m"`
+	// FlowSamplingMaxFlows is the maximum number of flows whose sampling decision is kept. When
+	// it is reached, the decision of the least recently observed flow is forgotten. 0 means
+	// unbounded
+	FlowSamplingMaxFlows int `env:"FLOW_SAMPLING_MAX_FLOWS" envDefault:"100000"`
+	// SamplingSchedule changes the sampling rate on a daily schedule, without restarting the
+	// agent. It is a comma-separated list of HH:MM-HH:MM=rate entries (e.g.
+	// "22:00-06:00=100,12:00-13:00=20"). If several ranges overlap, the first one in the list is
+	// applied. Outside the ranges, the Sampling rate is applied.
+	SamplingSchedule string `env:"SAMPLING_SCHEDULE"`
+	// SamplingScheduleTimezone is the IANA time zone (e.g. Europe/Madrid) of the
+	// SamplingSchedule times. Defaults to the local time zone of the host.
+	SamplingScheduleTimezone string `env:"SAMPLING_SCHEDULE_TIMEZONE" envDefault:"Local"`
 	// ListenInterfaces specifies the mechanism used by the agent to listen for added or removed
 	// network interfaces. Accepted values are "watch" (default) or "poll".
 	// If the value is "watch", interfaces are traced immediately after they are created. This is
 	// the recommended setting for most configurations. "poll" value is a fallback mechanism that
 	// periodically queries the current network interfaces (frequency specified by ListenPollPeriod).
 	ListenInterfaces string `env:"LISTEN_INTERFACES" envDefault:"watch"`
+	// InterfaceCacheTTL specifies how long the interface names are cached before being looked up
+	// again, since the index of a removed interface can be reused by a new interface. If 0, the
+	// names are cached until the interface deletion is notified.
+	InterfaceCacheTTL time.Duration `env:"INTERFACE_CACHE_TTL" envDefault:"5m"`
 	// ListenPollPeriod specifies the periodicity to query the network interfaces when the
 	// ListenInterfaces value is set to "poll".
 	ListenPollPeriod time.Duration `env:"LISTEN_POLL_PERIOD" envDefault:"10s"`
@@ -113,9 +670,31 @@ type Config struct {
 	// KafkaAsync. If it's true, the message writing process will never block. It also means that
 	// errors are ignored since the caller will not receive the returned value.
 	KafkaAsync bool `env:"KAFKA_ASYNC" envDefault:"true"`
+	// KafkaRequiredAcks is the number of acknowledges from the partition replicas that are required
+	// before a batch of flows is considered delivered. Accepted values are: none, leader or all
+	// (default).
+	KafkaRequiredAcks string `env:"KAFKA_REQUIRED_ACKS" envDefault:"all"`
+	// KafkaRetries is the number of times that the messages whose write failed are retried, when
+	// KafkaAsync is false. Meanwhile, the next flows are kept in the exporter buffer.
+	KafkaRetries int `env:"KAFKA_RETRIES" envDefault:"5"`
+	// KafkaRetryBackoff is the wait before the first retry of a failed write. It is doubled on
+	// each retry.
+	KafkaRetryBackoff time.Duration `env:"KAFKA_RETRY_BACKOFF" envDefault:"500ms"`
+	// KafkaRetryMaxBackoff caps the wait between retries of a failed write
+	KafkaRetryMaxBackoff time.Duration `env:"KAFKA_RETRY_MAX_BACKOFF" envDefault:"10s"`
 	// KafkaCompression sets the compression codec to be used to compress messages. The accepted
 	// values are: none (default), gzip, snappy, lz4, zstd.
 	KafkaCompression string `env:"KAFKA_COMPRESSION" envDefault:"none"`
+	// KafkaEncoding sets the format of the messages submitted to Kafka. Accepted values are:
+	// protobuf (default), avro.
+	KafkaEncoding string `env:"KAFKA_ENCODING" envDefault:"protobuf"`
+	// KafkaTenantTopics routes the flows of each tenant to its own "<KafkaTopic>-<TenantID>"
+	// topic. The flows without tenant are sent to the KafkaTopic topic.
+	KafkaTenantTopics bool `env:"KAFKA_TENANT_TOPICS" envDefault:"false"`
+	// KafkaSchemaRegistryURL is the URL of the schema registry where the Avro schema of the flows
+	// is registered, when KafkaEncoding is avro. The schema is registered under the
+	// "<KafkaTopic>-value" subject.
+	KafkaSchemaRegistryURL string `env:"KAFKA_SCHEMA_REGISTRY_URL"`
 	// KafkaEnableTLS set true to enable TLS
 	KafkaEnableTLS bool `env:"KAFKA_ENABLE_TLS" envDefault:"false"`
 	// KafkaTLSInsecureSkipVerify skips server certificate verification in TLS connections
@@ -136,4 +715,77 @@ type Config struct {
 	KafkaSASLClientSecretPath string `env:"KAFKA_SASL_CLIENT_SECRET_PATH"`
 	// ProfilePort sets the listening port for Go's Pprof tool. If it is not set, profile is disabled
 	ProfilePort int `env:"PROFILE_PORT"`
+	// PayloadSampleBytes sets the number of bytes from the beginning of the transport-layer payload
+	// that are captured for each flow matching the PayloadSampleProtocol and PayloadSamplePort
+	// filters. The payload is captured from the first flow packet carrying any payload. Maximum
+	// accepted value is 64. Default: 0 (payload sampling disabled).
+	PayloadSampleBytes int `env:"PAYLOAD_SAMPLE_BYTES" envDefault:"0"`
+	// PayloadSampleProtocol restricts payload sampling to the flows of the given transport protocol.
+	// Accepted values are: tcp, udp, sctp, or a protocol number. If empty, payload is sampled for
+	// flows of any transport protocol.
+	PayloadSampleProtocol string `env:"PAYLOAD_SAMPLE_PROTOCOL"`
+	// PayloadSamplePort restricts payload sampling to the flows whose source or destination port
+	// matches the provided value. If unset or 0, payload is sampled for flows on any port.
+	PayloadSamplePort int `env:"PAYLOAD_SAMPLE_PORT"`
+	// PacketSizeBuckets is a comma-separated list of the inclusive upper bounds, in bytes, of the
+	// buckets of the packet size histogram that is captured for each flow. It must contain
+	// 3 increasing values. An extra bucket counts the packets larger than the last bound.
+	PacketSizeBuckets []int `env:"PACKET_SIZE_BUCKETS" envSeparator:"," envDefault:"64,512,1500"`
+	// FragmentTimeout is the maximum time to wait for the rest of fragments of an IP datagram. After
+	// this time, the fragments that couldn't be attributed to any flow (because the first fragment
+	// was not observed) are accounted in a flow without transport ports information.
+	FragmentTimeout time.Duration `env:"FRAGMENT_TIMEOUT" envDefault:"30s"`
+	// EnableFragments enables the tracking of the IP fragments in the kernel space, so the
+	// non-first fragments of a datagram are attributed to the flow of the first fragment.
+	// If disabled, the non-first fragments are accounted in flows without transport ports.
+	EnableFragments bool `env:"ENABLE_FRAGMENTS" envDefault:"true"`
+	// EnableConnectLatency enables the tracking of the TCP handshakes in the kernel space, to
+	// measure the time between the SYN and the SYN-ACK packets (ServerConnectLatency field).
+	// Disabling it, as well as EnableFragments, only saves the memory of the feature map and its
+	// per-packet work: the code is still loaded, and the fields are still carried in the records.
+	EnableConnectLatency bool `env:"ENABLE_CONNECT_LATENCY" envDefault:"false"`
+	// EnableTunnelParsing enables the parsing of the VXLAN and Geneve headers in the kernel space,
+	// so the tunneled packets are accounted by their inner flow, whose addresses, ports and
+	// protocol are reported along with the tunnel type and network identifier.
+	EnableTunnelParsing bool `env:"ENABLE_TUNNEL_PARSING" envDefault:"false"`
+	// EnableIPIDTracking enables the capture of the IPv4 identification of the last packets of
+	// each flow in the kernel space, to count the packets whose identification is repeated
+	// (DuplicatePackets field), as the packets duplicated by a misconfigured port mirror.
+	EnableIPIDTracking bool `env:"ENABLE_IP_ID_TRACKING" envDefault:"false"`
+	// FlushOnSignal enables the immediate flush and export of the cached flows when the agent
+	// receives the SIGUSR1 signal.
+	FlushOnSignal bool `env:"FLUSH_ON_SIGNAL" envDefault:"true"`
+	// MemoryHighWatermark is the memory usage (RSS of the agent process), in bytes, beyond which
+	// the agent stops accepting new flows in its userspace cache, and evicts the existing ones.
+	// If 0 (default), the memory circuit breaker is disabled.
+	MemoryHighWatermark uint64 `env:"MEMORY_HIGH_WATERMARK" envDefault:"0"`
+	// MemoryLowWatermark is the memory usage, in bytes, below which the agent accepts again new
+	// flows after reaching the MemoryHighWatermark. If 0, it defaults to 80% of the
+	// MemoryHighWatermark.
+	MemoryLowWatermark uint64 `env:"MEMORY_LOW_WATERMARK" envDefault:"0"`
+	// MetricsEnable enables the HTTP endpoint that exposes the agent internal metrics in the
+	// Prometheus format.
+	MetricsEnable bool `env:"METRICS_ENABLE" envDefault:"false"`
+	// MetricsServerAddress is the address of the metrics HTTP server. If empty, it listens on all
+	// the interfaces.
+	MetricsServerAddress string `env:"METRICS_SERVER_ADDRESS"`
+	// MetricsPort is the port of the metrics HTTP server.
+	MetricsPort int `env:"METRICS_SERVER_PORT" envDefault:"9090"`
+	// MetricsPrefix is the prefix prepended to the name of all the agent internal metrics.
+	MetricsPrefix string `env:"METRICS_PREFIX" envDefault:"ebpf_agent_"`
+	// MetricsStageTiming enables the pipeline_stage_duration_seconds histogram, which records the
+	// time spent by each pipeline stage (deduplication, filters, decoration, export...) processing
+	// each batch of flows, labeled by stage name.
+	MetricsStageTiming bool `env:"METRICS_STAGE_TIMING" envDefault:"false"`
+	// SelfTelemetryInterval is how often the agent samples its own CPU time, memory and number of
+	// goroutines, which are exposed through the metrics endpoint. If 0 (default), self-telemetry
+	// is disabled.
+	SelfTelemetryInterval time.Duration `env:"SELF_TELEMETRY_INTERVAL" envDefault:"0"`
+	// ConnectionGauge enables the active_connections gauge, which exposes through the metrics
+	// endpoint the number of active TCP connections towards each service (destination
+	// address:port), as derived from the SYN, FIN and RST flags of the flows.
+	ConnectionGauge bool `env:"CONNECTION_GAUGE" envDefault:"false"`
+	// ConnectionGaugeExpiry is the time after which a connection is discounted from the
+	// active_connections gauge if no flow has been observed for it.
+	ConnectionGaugeExpiry time.Duration `env:"CONNECTION_GAUGE_EXPIRY" envDefault:"5m"`
 }
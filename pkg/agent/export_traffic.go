@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+// collectorEndpoints returns the endpoints of the collectors the flows are exported to, for all
+// the configured exporters. The exporters writing to local sinks (e.g. file, unix) and the
+// custom exporters have no endpoints.
+func collectorEndpoints(cfg *Config) ([]flow.Endpoint, error) {
+	if len(cfg.Exporters) == 0 {
+		return exporterEndpoints(cfg)
+	}
+	var endpoints []flow.Endpoint
+	for i := range cfg.Exporters {
+		ecfg, err := exporterConfig(cfg, &cfg.Exporters[i])
+		if err != nil {
+			return nil, fmt.Errorf("configuring exporter %d (%s): %w", i, cfg.Exporters[i].Export, err)
+		}
+		ee, err := exporterEndpoints(ecfg)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, ee...)
+	}
+	return endpoints, nil
+}
+
+func exporterEndpoints(cfg *Config) ([]flow.Endpoint, error) {
+	switch cfg.Export {
+	case "grpc":
+		collectors, err := grpcCollectors(cfg.TargetHost, cfg.TargetPort)
+		if err != nil {
+			return nil, err
+		}
+		endpoints := make([]flow.Endpoint, 0, len(collectors))
+		for _, c := range collectors {
+			endpoints = append(endpoints, flow.Endpoint{Host: c.HostIP, Port: uint16(c.HostPort)})
+		}
+		return endpoints, nil
+	case "ipfix+udp", "ipfix+tcp", "statsd", "syslog", "sflow":
+		return []flow.Endpoint{{Host: cfg.TargetHost, Port: uint16(cfg.TargetPort)}}, nil
+	case "kafka":
+		endpoints := make([]flow.Endpoint, 0, len(cfg.KafkaBrokers))
+		for _, broker := range cfg.KafkaBrokers {
+			endpoint, err := hostPortEndpoint(broker, 9092)
+			if err != nil {
+				return nil, fmt.Errorf("invalid Kafka broker: %w", err)
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		return endpoints, nil
+	case "prometheus-remote-write":
+		return urlEndpoints(cfg.PromRemoteWriteURL)
+	case "elasticsearch":
+		return urlEndpoints(cfg.ElasticsearchURL)
+	case "pubsub":
+		if emulator := os.Getenv("PUBSUB_EMULATOR_HOST"); emulator != "" {
+			endpoint, err := hostPortEndpoint(emulator, 8085)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PUBSUB_EMULATOR_HOST: %w", err)
+			}
+			return []flow.Endpoint{endpoint}, nil
+		}
+		return urlEndpoints(cfg.PubSubEndpoint)
+	}
+	return nil, nil
+}
+
+// hostPortEndpoint parses a host:port address, using the default port if it is not specified
+func hostPortEndpoint(address string, defaultPort uint16) (flow.Endpoint, error) {
+	host, port, splitErr := net.SplitHostPort(address)
+	if splitErr != nil {
+		// no port specified
+		return flow.Endpoint{Host: address, Port: defaultPort}, nil
+	}
+	portNum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return flow.Endpoint{}, fmt.Errorf("invalid port in %q: %w", address, err)
+	}
+	return flow.Endpoint{Host: host, Port: uint16(portNum)}, nil
+}
+
+// urlEndpoints returns the endpoint of an HTTP(S) URL, whose default port depends on its scheme
+func urlEndpoints(rawURL string) ([]flow.Endpoint, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid collector URL %q: %w", rawURL, err)
+	}
+	defaultPort := uint16(80)
+	if u.Scheme == "https" {
+		defaultPort = 443
+	}
+	endpoint, err := hostPortEndpoint(u.Host, defaultPort)
+	if err != nil {
+		return nil, err
+	}
+	return []flow.Endpoint{endpoint}, nil
+}
@@ -0,0 +1,42 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestCollectorEndpoints(t *testing.T) {
+	cfg := &Config{
+		TargetHost:         "flp-1,flp-2:9999",
+		TargetPort:         2055,
+		KafkaBrokers:       []string{"kafka-1:9093", "kafka-2"},
+		ElasticsearchURL:   "https://elasticsearch/",
+		PromRemoteWriteURL: "http://prometheus:9090/api/v1/write",
+	}
+	require.NoError(t, cfg.Exporters.UnmarshalText([]byte(`[
+		{"export": "grpc"},
+		{"export": "kafka"},
+		{"export": "statsd", "properties": {"FLOWS_TARGET_HOST": "10.0.0.9", "FLOWS_TARGET_PORT": "8125"}},
+		{"export": "elasticsearch"},
+		{"export": "prometheus-remote-write"},
+		{"export": "file"}
+	]`)))
+	endpoints, err := collectorEndpoints(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []flow.Endpoint{
+		{Host: "flp-1", Port: 2055},
+		{Host: "flp-2", Port: 9999},
+		{Host: "kafka-1", Port: 9093},
+		{Host: "kafka-2", Port: 9092},
+		{Host: "10.0.0.9", Port: 8125},
+		{Host: "elasticsearch", Port: 443},
+		{Host: "prometheus", Port: 9090},
+	}, endpoints)
+
+	_, err = collectorEndpoints(&Config{Export: "kafka", KafkaBrokers: []string{"kafka:port"}})
+	assert.Error(t, err)
+}
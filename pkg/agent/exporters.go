@@ -0,0 +1,281 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/netobserv/gopipes/pkg/node"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/exporter"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// ExporterProvider instantiates an exporter.Exporter from the agent configuration
+type ExporterProvider func(cfg *Config, m *metrics.Metrics) (exporter.Exporter, error)
+
+// terminalProvider instantiates the terminal node that submits the flows to an exporter. The
+// providers of the exporters that consume the flows from their own loop (e.g. because they keep
+// state between exports) are registered directly as terminalProvider. The provided context is
+// done when the agent stops.
+type terminalProvider func(
+	ctx context.Context, cfg *Config, m *metrics.Metrics,
+) (node.TerminalFunc[[]*flow.Record], error)
+
+var (
+	exportersMutex sync.RWMutex
+	exporters      = map[string]terminalProvider{}
+)
+
+func init() {
+	// the grpc exporter requests are canceled when the agent stops
+	registerTerminal("grpc", buildGRPCExporter)
+	RegisterExporter("kafka", buildKafkaExporter)
+	RegisterExporter("prometheus-remote-write", buildPromRemoteWriteExporter)
+	RegisterExporter("elasticsearch", buildElasticsearchExporter)
+	RegisterExporter("pubsub", buildPubSubExporter)
+	RegisterExporter("counters", buildCountersExporter)
+	// these exporters keep state between exports (e.g. connections, templates or
+	// deduplication windows), so they use a single worker
+	registerTerminal("ipfix+udp", singleWorker(buildIPFIXExporter("udp")))
+	registerTerminal("ipfix+tcp", singleWorker(buildIPFIXExporter("tcp")))
+	registerTerminal("file", singleWorker(buildFileExporter))
+	registerTerminal("statsd", singleWorker(buildStatsDExporter))
+	registerTerminal("unix", singleWorker(buildUnixSocketExporter))
+	registerTerminal("syslog", singleWorker(buildSyslogExporter))
+	registerTerminal("sflow", singleWorker(buildSFlowExporter))
+	registerTerminal("fifo", singleWorker(buildFIFOExporter))
+}
+
+// RegisterExporter makes an Exporter available by the provided name, so it can be selected
+// from the EXPORT configuration property. It is intended to be invoked from the init function of
+// the packages that provide custom exporters, so they are registered by just importing them.
+// It panics if an exporter with the same name is already registered.
+func RegisterExporter(name string, provider ExporterProvider) {
+	if provider == nil {
+		panic("agent: RegisterExporter provider is nil for " + name)
+	}
+	registerTerminal(name, func(
+		ctx context.Context, cfg *Config, m *metrics.Metrics,
+	) (node.TerminalFunc[[]*flow.Record], error) {
+		e, err := provider(cfg, m)
+		if err != nil {
+			return nil, err
+		}
+		return exportTerminal(ctx, cfg, e, m), nil
+	})
+}
+
+func registerTerminal(name string, provider terminalProvider) {
+	exportersMutex.Lock()
+	defer exportersMutex.Unlock()
+	if _, ok := exporters[name]; ok {
+		panic("agent: RegisterExporter called twice for " + name)
+	}
+	exporters[name] = provider
+}
+
+// singleWorker adapts the provider of an exporter that doesn't support concurrent exports
+func singleWorker(
+	provider func(cfg *Config) (node.TerminalFunc[[]*flow.Record], error),
+) terminalProvider {
+	return func(
+		_ context.Context, cfg *Config, _ *metrics.Metrics,
+	) (node.TerminalFunc[[]*flow.Record], error) {
+		warnSingleWorker(cfg)
+		return provider(cfg)
+	}
+}
+
+// RegisteredExporters returns the sorted names of all the registered exporters, including the
+// built-in ones
+func RegisteredExporters() []string {
+	exportersMutex.RLock()
+	defer exportersMutex.RUnlock()
+	return registeredExporters()
+}
+
+func registeredExporters() []string {
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// buildRegisteredExporter instantiates the registered exporter that is selected in the
+// configuration
+func buildRegisteredExporter(
+	ctx context.Context, cfg *Config, m *metrics.Metrics,
+) (node.TerminalFunc[[]*flow.Record], error) {
+	exportersMutex.RLock()
+	provider, ok := exporters[cfg.Export]
+	exportersMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("wrong export type %s. Admitted values are %s", cfg.Export,
+			strings.Join(RegisteredExporters(), ", "))
+	}
+	return provider(ctx, cfg, m)
+}
+
+// exportTerminal returns the terminal node function that submits the flows to the provided
+// Exporter. If the Exporter supports concurrent use, the flows are submitted from as many
+// workers as configured. The failed submissions, as well as the ones that exceed the send
+// timeout, if configured, are retried later. The submissions of the exporters implementing
+// exporter.ContextExporter are aborted when ctx is done.
+func exportTerminal(
+	ctx context.Context, cfg *Config, e exporter.Exporter, m *metrics.Metrics,
+) node.TerminalFunc[[]*flow.Record] {
+	e = exporter.WithSendTimeout(
+		ctx, e, cfg.Export, cfg.ExportSendTimeout, cfg.ExportSendRetryBatches, m)
+	if ce, ok := e.(exporter.ConcurrentExporter); ok {
+		return exporter.ConcurrentTerminal(ce, cfg.ExportWorkers)
+	}
+	warnSingleWorker(cfg)
+	return exporter.Terminal(e)
+}
+
+func warnSingleWorker(cfg *Config) {
+	if cfg.ExportWorkers > 1 {
+		alog.WithField("export", cfg.Export).
+			Warn("the selected exporter doesn't support concurrent exports. " +
+				"Ignoring EXPORT_WORKERS and using a single worker")
+	}
+}
+
+// buildMultiExporter instantiates each of the exporters configured in the Exporters property,
+// and returns a terminal that forwards the flows to all of them, aggregated as each exporter
+// requires and projected to the fields that each exporter accepts
+func buildMultiExporter(
+	ctx context.Context, cfg *Config, m *metrics.Metrics,
+) (node.TerminalFunc[[]*flow.Record], error) {
+	terminals := make([]node.TerminalFunc[[]*flow.Record], 0, len(cfg.Exporters))
+	transforms := make([]recordsTransform, 0, len(cfg.Exporters))
+	for i := range cfg.Exporters {
+		ec := &cfg.Exporters[i]
+		ecfg, err := exporterConfig(cfg, ec)
+		if err != nil {
+			return nil, fmt.Errorf("configuring exporter %d (%s): %w", i, ec.Export, err)
+		}
+		transform, err := exporterTransform(ec)
+		if err != nil {
+			return nil, fmt.Errorf("configuring exporter %d (%s): %w", i, ec.Export, err)
+		}
+		terminal, err := buildFlowExporter(ctx, ecfg, m)
+		if err != nil {
+			return nil, fmt.Errorf("configuring exporter %d (%s): %w", i, ec.Export, err)
+		}
+		terminals = append(terminals, terminal)
+		transforms = append(transforms, transform)
+	}
+	return fanOutTerminal(terminals, transforms, cfg.BuffersLength), nil
+}
+
+// recordsTransform returns the records that are forwarded to an exporter. It must not modify
+// the provided records, since they are forwarded to the rest of exporters too.
+type recordsTransform func(records []*flow.Record) []*flow.Record
+
+// exporterTransform returns the aggregation and the projection of the flows that are sent to
+// the provided exporter, or nil if the exporter receives the flows as they are
+func exporterTransform(ec *ExporterConfig) (recordsTransform, error) {
+	var aggregate recordsTransform
+	switch ec.Aggregate {
+	case "", ExportAggregateNone:
+	case ExportAggregateServicePort:
+		aggregate = flow.AggregateByServicePort
+	default:
+		return nil, fmt.Errorf("invalid aggregate %q. Accepted values are %s, %s",
+			ec.Aggregate, ExportAggregateNone, ExportAggregateServicePort)
+	}
+	if len(ec.ExportFields) == 0 {
+		return aggregate, nil
+	}
+	projection, err := flow.NewFieldProjection(ec.ExportFields)
+	if err != nil {
+		return nil, err
+	}
+	if aggregate == nil {
+		return projection.ProjectAll, nil
+	}
+	return func(records []*flow.Record) []*flow.Record {
+		return projection.ProjectAll(aggregate(records))
+	}, nil
+}
+
+// exporterConfig returns a copy of the agent configuration for the provided exporter, with the
+// exporter properties overriding the agent ones
+func exporterConfig(cfg *Config, ec *ExporterConfig) (*Config, error) {
+	if ec.Export == "" {
+		return nil, errors.New("missing export type")
+	}
+	ecfg := *cfg
+	ecfg.Export = ec.Export
+	ecfg.Exporters = nil
+	if len(ec.Properties) == 0 {
+		return &ecfg, nil
+	}
+	// parsing the properties in an empty configuration, to copy only the overridden fields
+	var overrides Config
+	if err := env.Parse(&overrides, env.Options{Environment: ec.Properties}); err != nil {
+		return nil, err
+	}
+	cfgType := reflect.TypeOf(ecfg)
+	found := 0
+	for i := 0; i < cfgType.NumField(); i++ {
+		name := strings.Split(cfgType.Field(i).Tag.Get("env"), ",")[0]
+		if _, ok := ec.Properties[name]; !ok {
+			continue
+		}
+		if name == "EXPORT" || name == "EXPORTERS" {
+			return nil, fmt.Errorf("property %s can't be overridden for an exporter", name)
+		}
+		reflect.ValueOf(&ecfg).Elem().Field(i).Set(reflect.ValueOf(overrides).Field(i))
+		found++
+	}
+	if found != len(ec.Properties) {
+		return nil, fmt.Errorf("unknown properties in %v", ec.Properties)
+	}
+	return &ecfg, nil
+}
+
+// fanOutTerminal returns a terminal that forwards the flows to all the provided terminals, each
+// one from its own buffered channel. If the transform of a terminal is not nil, the flows are
+// transformed before forwarding them.
+func fanOutTerminal(
+	terminals []node.TerminalFunc[[]*flow.Record],
+	transforms []recordsTransform,
+	bufLen int,
+) node.TerminalFunc[[]*flow.Record] {
+	return func(in <-chan []*flow.Record) {
+		outs := make([]chan []*flow.Record, len(terminals))
+		wg := sync.WaitGroup{}
+		wg.Add(len(terminals))
+		for i := range terminals {
+			outs[i] = make(chan []*flow.Record, bufLen)
+			go func(terminal node.TerminalFunc[[]*flow.Record], out <-chan []*flow.Record) {
+				defer wg.Done()
+				terminal(out)
+			}(terminals[i], outs[i])
+		}
+		for records := range in {
+			for i, out := range outs {
+				if transforms[i] != nil {
+					out <- transforms[i](records)
+				} else {
+					out <- records
+				}
+			}
+		}
+		for _, out := range outs {
+			close(out)
+		}
+		wg.Wait()
+	}
+}
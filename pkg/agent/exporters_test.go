@@ -0,0 +1,309 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/gavv/monotime"
+	test2 "github.com/mariomac/guara/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/exporter"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/grpc"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/test"
+)
+
+// customExporter records the exported flows
+type customExporter struct {
+	mt      sync.Mutex
+	prefix  string
+	records []*flow.Record
+	closed  bool
+}
+
+func (ce *customExporter) Export(records []*flow.Record) error {
+	ce.mt.Lock()
+	defer ce.mt.Unlock()
+	ce.records = append(ce.records, records...)
+	return nil
+}
+
+func (ce *customExporter) Close() error {
+	ce.mt.Lock()
+	defer ce.mt.Unlock()
+	ce.closed = true
+	return nil
+}
+
+func TestRegisterExporter(t *testing.T) {
+	custom := &customExporter{}
+	RegisterExporter("test-custom", func(cfg *Config, _ *metrics.Metrics) (exporter.Exporter, error) {
+		custom.prefix = cfg.MetricsPrefix
+		return custom, nil
+	})
+	t.Cleanup(func() {
+		exportersMutex.Lock()
+		delete(exporters, "test-custom")
+		exportersMutex.Unlock()
+	})
+	assert.Contains(t, RegisteredExporters(), "test-custom")
+	// the built-in exporters are in the same registry
+	assert.Subset(t, RegisteredExporters(), []string{
+		"grpc", "kafka", "ipfix+udp", "ipfix+tcp", "file", "statsd", "counters", "unix", "syslog",
+		"sflow", "fifo", "prometheus-remote-write", "elasticsearch", "pubsub",
+	})
+	assert.Panics(t, func() {
+		RegisterExporter("test-custom", func(*Config, *metrics.Metrics) (exporter.Exporter, error) {
+			return nil, nil
+		})
+	})
+	assert.Panics(t, func() {
+		RegisterExporter("file", func(*Config, *metrics.Metrics) (exporter.Exporter, error) {
+			return nil, nil
+		})
+	})
+
+	// GIVEN an agent configured to export the flows to the registered exporter
+	cfg := &Config{
+		Export:             "test-custom",
+		MetricsPrefix:      "custom_",
+		CacheActiveTimeout: 10 * time.Millisecond,
+		CacheMaxFlows:      100,
+	}
+	m := metrics.NoOp()
+	exportFunc, err := buildFlowExporter(context.Background(), cfg, m)
+	require.NoError(t, err)
+	assert.Equal(t, "custom_", custom.prefix)
+
+	ebpfTracer := test.NewTracerFake()
+	agent, err := flowsAgent(cfg, m, test.SliceInformerFake{{Name: "foo", Index: 3}},
+		ebpfTracer, exportFunc, net.ParseIP(agentIP))
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		require.NoError(t, agent.Run(ctx))
+	}()
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.Equal(t, StatusStarted, agent.Status())
+	}, test2.Interval(10*time.Millisecond))
+
+	// WHEN flows are traced
+	now := uint64(monotime.Now())
+	ebpfTracer.AppendLookupResults(map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		key1: {Packets: 3, Bytes: 44, StartMonoTimeTs: now + 1000, EndMonoTimeTs: now + 1_000_000_000},
+	})
+
+	// THEN they are submitted to the custom exporter
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		custom.mt.Lock()
+		defer custom.mt.Unlock()
+		require.Len(t, custom.records, 1)
+	}, test2.Interval(10*time.Millisecond))
+	custom.mt.Lock()
+	exported := custom.records[0]
+	custom.mt.Unlock()
+	assert.Equal(t, key1, exported.Id)
+	assert.EqualValues(t, 44, exported.Metrics.Bytes)
+	assert.Equal(t, "foo", exported.Interface)
+
+	// AND the exporter is closed when the agent stops
+	cancel()
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		custom.mt.Lock()
+		defer custom.mt.Unlock()
+		require.True(t, custom.closed)
+	}, test2.Interval(10*time.Millisecond))
+}
+
+func TestMultiExporter_Projection(t *testing.T) {
+	full, tuple := &customExporter{}, &customExporter{}
+	RegisterExporter("test-full", func(cfg *Config, _ *metrics.Metrics) (exporter.Exporter, error) {
+		full.prefix = cfg.MetricsPrefix
+		return full, nil
+	})
+	RegisterExporter("test-tuple", func(cfg *Config, _ *metrics.Metrics) (exporter.Exporter, error) {
+		tuple.prefix = cfg.MetricsPrefix
+		return tuple, nil
+	})
+	t.Cleanup(func() {
+		exportersMutex.Lock()
+		delete(exporters, "test-full")
+		delete(exporters, "test-tuple")
+		exportersMutex.Unlock()
+	})
+
+	// GIVEN two exporters, one of them only accepting the 5-tuple and the counters of the flows
+	cfg := &Config{MetricsPrefix: "agent_", BuffersLength: 10}
+	require.NoError(t, cfg.Exporters.UnmarshalText([]byte(`[
+		{"export": "test-full"},
+		{
+			"export": "test-tuple",
+			"exportFields": ["Id.SrcIp", "Id.DstIp", "Id.SrcPort", "Id.DstPort",
+				"Id.TransportProtocol", "Metrics.Bytes", "Metrics.Packets"],
+			"properties": {"METRICS_PREFIX": "tuple_"}
+		}
+	]`)))
+	exportFunc, err := buildFlowExporter(context.Background(), cfg, metrics.NoOp())
+	require.NoError(t, err)
+	// the exporter properties override the agent configuration
+	assert.Equal(t, "agent_", full.prefix)
+	assert.Equal(t, "tuple_", tuple.prefix)
+
+	// WHEN flows are exported
+	record := &flow.Record{Interface: "eth0", AgentIP: net.ParseIP(agentIP)}
+	record.Id = key1
+	record.Metrics.Bytes = 44
+	record.Metrics.Packets = 3
+	record.Metrics.Flags = 0x10
+	in := make(chan []*flow.Record, 1)
+	in <- []*flow.Record{record}
+	close(in)
+	exportFunc(in)
+
+	// THEN the first exporter receives the whole records
+	require.Len(t, full.records, 1)
+	assert.Same(t, record, full.records[0])
+	// AND the second exporter only receives the allowed fields
+	require.Len(t, tuple.records, 1)
+	projected := tuple.records[0]
+	assert.Equal(t, key1.SrcIp, projected.Id.SrcIp)
+	assert.Equal(t, key1.DstIp, projected.Id.DstIp)
+	assert.Equal(t, key1.SrcPort, projected.Id.SrcPort)
+	assert.Equal(t, key1.DstPort, projected.Id.DstPort)
+	assert.EqualValues(t, 44, projected.Metrics.Bytes)
+	assert.EqualValues(t, 3, projected.Metrics.Packets)
+	assert.Zero(t, projected.Id.EthProtocol)
+	assert.Zero(t, projected.Id.IfIndex)
+	assert.Zero(t, projected.Metrics.Flags)
+	assert.Empty(t, projected.Interface)
+	assert.Nil(t, projected.AgentIP)
+	// AND the exporters are closed
+	assert.True(t, full.closed)
+	assert.True(t, tuple.closed)
+}
+
+func TestMultiExporter_RawAndAggregated(t *testing.T) {
+	// GIVEN a gRPC collector
+	port, err := test2.FreeTCPPort()
+	require.NoError(t, err)
+	collected := make(chan *pbflow.Records, 10)
+	coll, err := grpc.StartCollector(port, collected)
+	require.NoError(t, err)
+	defer coll.Close()
+
+	// AND a file exporter receiving the raw flows, and a gRPC exporter receiving the flows
+	// aggregated by service port
+	file := path.Join(t.TempDir(), "flows.json")
+	cfg := &Config{BuffersLength: 10, ExportEncoding: "json", ExportFieldCase: "pascal",
+		GRPCMessageMaxFlows: 100}
+	require.NoError(t, cfg.Exporters.UnmarshalText([]byte(fmt.Sprintf(`[
+		{"export": "file", "properties": {"FILE_PATH": %q}},
+		{"export": "grpc", "aggregate": "servicePort",
+			"properties": {"FLOWS_TARGET_HOST": "127.0.0.1", "FLOWS_TARGET_PORT": "%d"}}
+	]`, file, port))))
+	exportFunc, err := buildFlowExporter(context.Background(), cfg, metrics.NoOp())
+	require.NoError(t, err)
+
+	// WHEN the flows from two client ports to the same service are exported
+	client1 := &flow.Record{SubFlowCount: 1}
+	client1.Id = ebpf.BpfFlowId{EthProtocol: 0x0800, TransportProtocol: syscall.IPPROTO_TCP,
+		SrcIp: key1.SrcIp, DstIp: key1.DstIp, SrcPort: 34567, DstPort: 80}
+	client1.Metrics.Bytes = 100
+	client1.Metrics.Packets = 2
+	client2 := &flow.Record{SubFlowCount: 1}
+	client2.Id = client1.Id
+	client2.Id.SrcPort = 34568
+	client2.Metrics.Bytes = 300
+	client2.Metrics.Packets = 4
+	in := make(chan []*flow.Record, 1)
+	in <- []*flow.Record{client1, client2}
+	close(in)
+	exportFunc(in)
+
+	// THEN the file receives the raw records
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var srcPorts []float64
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		srcPorts = append(srcPorts, record["Id"].(map[string]interface{})["SrcPort"].(float64))
+	}
+	assert.Equal(t, []float64{34567, 34568}, srcPorts)
+	assert.EqualValues(t, 34567, client1.Id.SrcPort)
+	assert.EqualValues(t, 100, client1.Metrics.Bytes)
+
+	// AND the gRPC collector receives them aggregated by service port
+	records := test.ReceiveTimeout(t, collected, 5*time.Second)
+	require.Len(t, records.Entries, 1)
+	aggregated := records.Entries[0]
+	assert.Zero(t, aggregated.Transport.SrcPort)
+	assert.EqualValues(t, 80, aggregated.Transport.DstPort)
+	assert.EqualValues(t, 400, aggregated.Bytes)
+	assert.EqualValues(t, 6, aggregated.Packets)
+	assert.EqualValues(t, 2, aggregated.SubFlowCount)
+}
+
+func TestMultiExporter_SameType(t *testing.T) {
+	es := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer es.Close()
+	// GIVEN two exporters of each type, e.g. to send raw and aggregated flows to the same sink
+	cfg := &Config{}
+	require.NoError(t, env.Parse(cfg, env.Options{Environment: map[string]string{
+		"KAFKA_BROKERS":     "127.0.0.1:9092",
+		"KAFKA_TOPIC":       "flows",
+		"ELASTICSEARCH_URL": es.URL,
+	}}))
+	require.NoError(t, cfg.Exporters.UnmarshalText([]byte(`[
+		{"export": "kafka"},
+		{"export": "kafka", "aggregate": "servicePort",
+		 "properties": {"KAFKA_TOPIC": "aggregated-flows"}},
+		{"export": "elasticsearch"},
+		{"export": "elasticsearch", "properties": {"ELASTICSEARCH_INDEX": "aggregated"}}
+	]`)))
+
+	// THEN they share their metrics instead of failing to register them twice
+	m := metrics.NoOp()
+	var err error
+	require.NotPanics(t, func() {
+		_, err = buildFlowExporter(context.Background(), cfg, m)
+	})
+	require.NoError(t, err)
+}
+
+func TestMultiExporter_InvalidConfig(t *testing.T) {
+	for _, exporters := range []string{
+		`[{"export": "nope"}]`,
+		`[{"properties": {"METRICS_PREFIX": "a_"}}]`,
+		`[{"export": "counters", "exportFields": ["Id.Nope"]}]`,
+		`[{"export": "counters", "properties": {"NOPE": "1"}}]`,
+		`[{"export": "counters", "properties": {"EXPORT": "grpc"}}]`,
+		`[{"export": "counters", "properties": {"CACHE_MAX_FLOWS": "many"}}]`,
+		`[{"export": "counters", "aggregate": "byHost"}]`,
+	} {
+		cfg := &Config{}
+		require.NoError(t, cfg.Exporters.UnmarshalText([]byte(exporters)))
+		_, err := buildFlowExporter(context.Background(), cfg, metrics.NoOp())
+		assert.Error(t, err, exporters)
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ifaces"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+const sysClassNet = "/sys/class/net"
+
+// interfaceLimiter bounds the number of interfaces that the agent attaches to. When the limit
+// is reached, the new interfaces are queued until the removal of an attached interface frees a
+// slot, which is then assigned to the queued interface that is selected by the policy: the
+// first one by name order, or the one with the highest traffic.
+// It is not safe for concurrent use: the interfaces events are handled from a single goroutine.
+type interfaceLimiter struct {
+	max       int
+	byTraffic bool
+	attached  map[ifaces.Interface]struct{}
+	queued    map[ifaces.Interface]struct{}
+	// traffic returns the bytes that have been received and transmitted by the interface
+	traffic      func(iface ifaces.Interface) uint64
+	skippedGauge prometheus.Gauge
+}
+
+func newInterfaceLimiter(max int, byTraffic bool, m *metrics.Metrics) *interfaceLimiter {
+	return &interfaceLimiter{
+		max:       max,
+		byTraffic: byTraffic,
+		attached:  map[ifaces.Interface]struct{}{},
+		queued:    map[ifaces.Interface]struct{}{},
+		traffic:   interfaceTraffic,
+		skippedGauge: m.NewGauge("skipped_interfaces",
+			"Number of interfaces that are not attached because MAX_INTERFACES is reached"),
+	}
+}
+
+// acquire returns whether the interface can be attached. Otherwise, it is queued.
+func (il *interfaceLimiter) acquire(iface ifaces.Interface) bool {
+	if _, ok := il.attached[iface]; ok {
+		return true
+	}
+	if len(il.attached) < il.max {
+		il.attached[iface] = struct{}{}
+		return true
+	}
+	il.queued[iface] = struct{}{}
+	il.skippedGauge.Set(float64(len(il.queued)))
+	return false
+}
+
+// release frees the slot of a removed interface, or removes it from the queue
+func (il *interfaceLimiter) release(iface ifaces.Interface) {
+	delete(il.attached, iface)
+	if _, ok := il.queued[iface]; ok {
+		delete(il.queued, iface)
+		il.skippedGauge.Set(float64(len(il.queued)))
+	}
+}
+
+// next returns the queued interface that is selected by the policy, if there is a free slot for
+// it. The returned interface is accounted as attached.
+func (il *interfaceLimiter) next() (ifaces.Interface, bool) {
+	if len(il.queued) == 0 || len(il.attached) >= il.max {
+		return ifaces.Interface{}, false
+	}
+	var selected ifaces.Interface
+	var selectedTraffic uint64
+	first := true
+	for iface := range il.queued {
+		if il.byTraffic {
+			traffic := il.traffic(iface)
+			if first || traffic > selectedTraffic ||
+				(traffic == selectedTraffic && iface.Name < selected.Name) {
+				selected, selectedTraffic = iface, traffic
+			}
+		} else if first || iface.Name < selected.Name {
+			selected = iface
+		}
+		first = false
+	}
+	delete(il.queued, selected)
+	il.skippedGauge.Set(float64(len(il.queued)))
+	il.attached[selected] = struct{}{}
+	return selected, true
+}
+
+// interfaceTraffic returns the received plus transmitted bytes of the interface, as reported by
+// sysfs, or 0 if they can't be read
+func interfaceTraffic(iface ifaces.Interface) uint64 {
+	total := uint64(0)
+	for _, counter := range []string{"rx_bytes", "tx_bytes"} {
+		content, err := os.ReadFile(path.Join(sysClassNet, iface.Name, "statistics", counter))
+		if err != nil {
+			alog.WithError(err).WithField("interface", iface).
+				Debug("can't read interface statistics")
+			return 0
+		}
+		bytes, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil {
+			return 0
+		}
+		total += bytes
+	}
+	return total
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ifaces"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/test"
+)
+
+// registerRecorder records the order in which the interfaces are registered
+type registerRecorder struct {
+	*test.TracerFake
+	registered []string
+}
+
+func (rr *registerRecorder) Register(iface ifaces.Interface) error {
+	rr.registered = append(rr.registered, iface.Name)
+	return nil
+}
+
+func TestMaxInterfaces(t *testing.T) {
+	traffic := map[string]uint64{"veth1": 10, "veth2": 3000, "veth3": 200, "veth4": 5}
+	for _, tc := range []struct {
+		policy   string
+		attached []string
+	}{
+		{policy: InterfacesPolicyName, attached: []string{"veth1", "veth2"}},
+		{policy: InterfacesPolicyTraffic, attached: []string{"veth2", "veth3"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			recorder := &registerRecorder{TracerFake: test.NewTracerFake()}
+			limiter := newInterfaceLimiter(2, tc.policy == InterfacesPolicyTraffic, metrics.NoOp())
+			limiter.traffic = func(iface ifaces.Interface) uint64 {
+				return traffic[iface.Name]
+			}
+			flows := &Flows{ebpf: recorder, ifaceLimiter: limiter}
+
+			// GIVEN a limit of 2 interfaces
+			// WHEN more interfaces are added
+			eth0 := ifaces.Interface{Name: "eth0", Index: 1}
+			eth1 := ifaces.Interface{Name: "eth1", Index: 2}
+			flows.onInterfaceAdded(eth0)
+			flows.onInterfaceAdded(eth1)
+			for i, name := range []string{"veth4", "veth3", "veth2", "veth1"} {
+				flows.onInterfaceAdded(ifaces.Interface{Name: name, Index: 10 + i})
+			}
+			// THEN only the first interfaces are attached, and the others are queued
+			assert.Equal(t, []string{"eth0", "eth1"}, recorder.registered)
+			assert.Len(t, limiter.queued, 4)
+
+			// WHEN attached interfaces are removed
+			flows.onInterfaceDeleted(eth0)
+			flows.onInterfaceDeleted(ifaces.Interface{Name: "veth4", Index: 10})
+			flows.onInterfaceDeleted(eth1)
+			// THEN the freed slots are assigned to the queued interfaces, selected by the policy
+			assert.Equal(t, append([]string{"eth0", "eth1"}, tc.attached...), recorder.registered)
+			assert.Len(t, limiter.queued, 1)
+			assert.Len(t, limiter.attached, 2)
+
+			// AND removing an interface that isn't attached doesn't free any slot
+			flows.onInterfaceDeleted(ifaces.Interface{Name: "unknown", Index: 99})
+			assert.Len(t, recorder.registered, 4)
+		})
+	}
+}
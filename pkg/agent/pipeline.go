@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/netobserv/gopipes/pkg/node"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+// stage is a middle stage of the flows processing graph
+type stage struct {
+	// name of the stage in the stage timing metrics. The stages without name aren't timed
+	name string
+	run  func(in <-chan []*flow.Record, out chan<- []*flow.Record)
+}
+
+// pipeline connects the stages of the flows processing graph in the order they are chained
+type pipeline struct {
+	// tails are the nodes that send their flows to the next chained stage
+	tails     []node.Sender[[]*flow.Record]
+	bufferLen int
+	// timer is nil if the stages aren't timed
+	timer *flow.StageTimer
+}
+
+// chain connects the tails of the pipeline to a new node running the stage, which becomes the
+// only tail of the pipeline
+func (p *pipeline) chain(s stage) {
+	run := s.run
+	if p.timer != nil && s.name != "" {
+		run = p.timer.Middle(s.name, run)
+	}
+	middle := node.AsMiddle(run, node.ChannelBufferLen(p.bufferLen))
+	p.sendTo(middle)
+	p.tails = []node.Sender[[]*flow.Record]{middle}
+}
+
+// sendTo connects the tails of the pipeline to the receiver, without chaining it
+func (p *pipeline) sendTo(receiver node.Receiver[[]*flow.Record]) {
+	for _, sender := range p.tails {
+		sender.SendsTo(receiver)
+	}
+}
+
+// processingStages returns the optional stages that process the traced flows, in their order,
+// before their capacity is limited and they are decorated
+func (f *Flows) processingStages() []stage {
+	var stages []stage
+	if f.cfg.IncludeRawBpf {
+		stages = append(stages, stage{run: flow.AttachRawBpf})
+	}
+	if f.exportTraffic != nil {
+		stages = append(stages, stage{"export_traffic", f.exportTraffic.Filter})
+	}
+	if f.cfg.DropEmptyFlows {
+		stages = append(stages, stage{"drop_empty", flow.DropEmpty(f.metrics)})
+	}
+	if f.cfg.ConnectionGauge {
+		// the connections are tracked before the flows are filtered or their identifiers rewritten
+		stages = append(stages, stage{"connection_gauge", flow.NewConnectionGauge(
+			f.cfg.ConnectionGaugeExpiry, time.Now, f.metrics).Track})
+	}
+	var portScan *flow.PortScanDetector
+	if f.cfg.PortScanThreshold > 0 {
+		// the scans are detected before the flows are sampled, so all the probes are accounted
+		window := f.cfg.PortScanWindow
+		if window <= 0 {
+			window = f.cfg.CacheActiveTimeout
+		}
+		portScan = flow.NewPortScanDetector(
+			f.cfg.PortScanThreshold, window, f.cfg.PortScanMaxSources, f.cfg.PortScanObservedPorts,
+			time.Now, f.metrics)
+		stages = append(stages, stage{"port_scan", portScan.Detect})
+	}
+	if f.cfg.FlowSampling > 1 {
+		stages = append(stages, stage{"flow_sampling", flow.NewFlowSampler(
+			f.cfg.FlowSampling, f.cfg.FlowSamplingExpiry, f.cfg.FlowSamplingMaxFlows, time.Now,
+			f.metrics).Sample})
+	}
+	if len(f.trafficClasses) > 0 || len(f.excludeTrafficClasses) > 0 {
+		stages = append(stages, stage{"traffic_class", flow.FilterTrafficClasses(
+			f.trafficClasses, f.excludeTrafficClasses, f.metrics)})
+	}
+	if f.inferDirection != nil {
+		// the direction is inferred before the flows orientation is normalized
+		stages = append(stages, stage{"direction", f.inferDirection})
+	}
+	if f.cfg.MergeICMPEcho {
+		// the echo flows are paired by their actual orientation, before it is normalized
+		stages = append(stages, stage{"icmp_echo", flow.NewICMPEchoMerger(
+			f.cfg.MergeICMPEchoTimeout, time.Now, f.metrics).Merge})
+	}
+	if f.cfg.NormalizeOrientation {
+		stages = append(stages, stage{"normalize", flow.Normalize})
+	}
+	fused := f.fusedDeduper()
+	if fused {
+		stages = append(stages, stage{"dedup", flow.FusedDedupAggregate(
+			f.cfg.DeduperFCExpiry, f.cfg.DeduperJustMark, f.cfg.DeduperMaxEntries, f.dedupPurges,
+			f.metrics)})
+	}
+	if f.cfg.ServicePortKey && !fused {
+		stages = append(stages, stage{"service_port", flow.KeyByServicePort})
+	}
+	if f.prefixKey != nil {
+		stages = append(stages, stage{"prefix", f.prefixKey})
+	}
+	if f.cfg.StartupBackfillLimit > 0 {
+		stages = append(stages, stage{"backfill", flow.NewBackfillLimiter(
+			f.cfg.StartupBackfillLimit, f.cfg.CacheActiveTimeout, time.Now, f.metrics).Limit})
+	}
+	if f.cfg.Deduper == DeduperFirstCome && !fused {
+		stages = append(stages, stage{"dedup", flow.DedupePreferring(
+			f.cfg.DeduperFCExpiry, f.cfg.DeduperJustMark, f.cfg.DeduperMaxEntries,
+			f.dedupPreferred, f.dedupPurges, f.metrics)})
+	}
+	if f.cfg.MinBytes > 0 || f.cfg.MinPackets > 0 {
+		stages = append(stages, stage{"threshold", flow.FilterBelowThreshold(
+			f.cfg.MinBytes, f.cfg.MinPackets, f.cfg.ThresholdMatch == ThresholdAll, f.metrics)})
+	}
+	if f.cfg.MinFlowDuration > 0 {
+		stages = append(stages, stage{"duration_filter", flow.FilterShorterThan(
+			f.cfg.MinFlowDuration, f.cfg.MinFlowDurationKeepSinglePacket, f.metrics)})
+	}
+	if f.cfg.TopNTalkers > 0 {
+		window := f.cfg.TopNTalkersWindow
+		if window <= 0 {
+			window = f.cfg.CacheActiveTimeout
+		}
+		stages = append(stages, stage{"top_talkers", flow.TopTalkers(
+			f.cfg.TopNTalkers, f.cfg.TopNTalkersBy == TopTalkersPkts, window, f.metrics)})
+	}
+	if portScan != nil && f.cfg.PortScanObservedPorts > 0 {
+		// the per-source records join the flows once they are processed, so they are neither
+		// sampled nor filtered, but they are still decorated
+		stages = append(stages, stage{run: portScan.Summaries})
+	}
+	return stages
+}
+
+// fusedDeduper tells whether the service port aggregation and the deduplication are run by a
+// single stage
+func (f *Flows) fusedDeduper() bool {
+	fused := f.cfg.DeduperFused && f.cfg.ServicePortKey && f.cfg.Deduper == DeduperFirstCome
+	if fused && f.cfg.StartupBackfillLimit > 0 {
+		alog.Warn("DEDUPER_FUSED is ignored, as the startup backfill limit must be applied " +
+			"between the service port and deduplication stages")
+		return false
+	}
+	if fused && f.prefixKey != nil {
+		alog.Warn("DEDUPER_FUSED is ignored, as the network prefix aggregation must be applied " +
+			"between the service port and deduplication stages")
+		return false
+	}
+	if fused && f.dedupPreferred != nil {
+		alog.Warn("DEDUPER_FUSED is ignored, as it doesn't support DEDUPER_PREFER_INTERFACES")
+		return false
+	}
+	return fused
+}
+
+// decorationStages returns the optional stages that complete the decorated flows, in their
+// order. The records are not modified after them, so they can be shared with the live feed.
+func (f *Flows) decorationStages() []stage {
+	var stages []stage
+	if f.cfg.MaxFieldStringLen > 0 {
+		stages = append(stages, stage{"truncate",
+			flow.TruncateStrings(f.cfg.MaxFieldStringLen, f.metrics)})
+	}
+	if f.cfg.RecordIDs {
+		// the identifiers are computed once the records are decorated with the agent IP
+		stages = append(stages, stage{"record_id", flow.SetRecordIDs})
+	}
+	return stages
+}
+
+// deliveryStages returns the optional stages that shape the final stream of records before
+// they are exported, in their order
+func (f *Flows) deliveryStages() []stage {
+	var stages []stage
+	if f.cfg.MaxExportRate > 0 {
+		// the rate is limited on the final stream, so the heartbeats are never throttled
+		stages = append(stages, stage{"export_rate", flow.NewExportRateLimiter(
+			f.cfg.MaxExportRate, f.cfg.MaxExportRateMode == ExportRateModeDelay,
+			f.cfg.MaxExportRateQueue, time.Now, f.metrics).Limit})
+	}
+	if f.heartbeat != nil {
+		// the heartbeats are emitted after the flows processing, so they are neither filtered
+		// nor decorated
+		stages = append(stages, stage{run: f.heartbeat})
+	}
+	if f.sortRecords != nil {
+		stages = append(stages, stage{"sort", f.sortRecords})
+	}
+	return stages
+}
@@ -16,29 +16,50 @@ import (
 type BpfFlowId BpfFlowIdT
 
 type BpfFlowIdT struct {
-	EthProtocol       uint16
-	Direction         uint8
-	SrcMac            [6]uint8
-	DstMac            [6]uint8
-	SrcIp             [16]uint8
-	DstIp             [16]uint8
-	SrcPort           uint16
-	DstPort           uint16
-	TransportProtocol uint8
-	IcmpType          uint8
-	IcmpCode          uint8
-	IfIndex           uint32
+	EthProtocol            uint16
+	Direction              uint8
+	SrcMac                 [6]uint8
+	DstMac                 [6]uint8
+	SrcIp                  [16]uint8
+	DstIp                  [16]uint8
+	SrcPort                uint16
+	DstPort                uint16
+	TransportProtocol      uint8
+	IcmpType               uint8
+	IcmpCode               uint8
+	IfIndex                uint32
+	TunnelType             uint8
+	TunnelVni              uint32
+	InnerSrcIp             [16]uint8
+	InnerDstIp             [16]uint8
+	InnerSrcPort           uint16
+	InnerDstPort           uint16
+	InnerTransportProtocol uint8
 }
 
 type BpfFlowMetrics BpfFlowMetricsT
 
 type BpfFlowMetricsT struct {
-	Packets         uint32
-	Bytes           uint64
-	StartMonoTimeTs uint64
-	EndMonoTimeTs   uint64
-	Flags           uint16
-	Errno           uint8
+	Packets              uint32
+	Bytes                uint64
+	StartMonoTimeTs      uint64
+	EndMonoTimeTs        uint64
+	Flags                uint16
+	Errno                uint8
+	MinTtl               uint8
+	MaxTtl               uint8
+	FragmentedPackets    uint32
+	PktSizeBuckets       [4]uint32
+	ServerConnectLatency uint64
+	CgroupId             uint64
+	SocketCookie         uint64
+	MinIpg               uint64
+	MaxIpg               uint64
+	DuplicatePackets     uint32
+	RecentIpIds          [8]uint16
+	RecentIpIdsNext      uint8
+	PayloadSampleLen     uint16
+	PayloadSample        [64]uint8
 }
 
 type BpfFlowRecordT struct {
@@ -46,6 +67,32 @@ type BpfFlowRecordT struct {
 	Metrics BpfFlowMetrics
 }
 
+type BpfFragInfo struct {
+	Id             BpfFlowId
+	FirstSeen      uint8
+	LastSeenTs     uint64
+	PendingPackets uint32
+	PendingBytes   uint64
+	PendingStartTs uint64
+}
+
+type BpfFragKey struct {
+	SrcIp             [16]uint8
+	DstIp             [16]uint8
+	IpId              uint32
+	IfIndex           uint32
+	TransportProtocol uint8
+	Direction         uint8
+}
+
+type BpfHandshakeKey struct {
+	SrcIp   [16]uint8
+	DstIp   [16]uint8
+	SrcPort uint16
+	DstPort uint16
+	IfIndex uint32
+}
+
 // LoadBpf returns the embedded CollectionSpec for Bpf.
 func LoadBpf() (*ebpf.CollectionSpec, error) {
 	reader := bytes.NewReader(_BpfBytes)
@@ -95,8 +142,13 @@ type BpfProgramSpecs struct {
 //
 // It can be passed ebpf.CollectionSpec.Assign.
 type BpfMapSpecs struct {
-	AggregatedFlows *ebpf.MapSpec `ebpf:"aggregated_flows"`
-	DirectFlows     *ebpf.MapSpec `ebpf:"direct_flows"`
+	AggregatedFlows  *ebpf.MapSpec `ebpf:"aggregated_flows"`
+	DirectFlows      *ebpf.MapSpec `ebpf:"direct_flows"`
+	Fragments        *ebpf.MapSpec `ebpf:"fragments"`
+	MapFullFlows     *ebpf.MapSpec `ebpf:"map_full_flows"`
+	Pressure         *ebpf.MapSpec `ebpf:"pressure"`
+	SamplingOverride *ebpf.MapSpec `ebpf:"sampling_override"`
+	TcpHandshakes    *ebpf.MapSpec `ebpf:"tcp_handshakes"`
 }
 
 // BpfObjects contains all objects after they have been loaded into the kernel.
@@ -118,14 +170,24 @@ func (o *BpfObjects) Close() error {
 //
 // It can be passed to LoadBpfObjects or ebpf.CollectionSpec.LoadAndAssign.
 type BpfMaps struct {
-	AggregatedFlows *ebpf.Map `ebpf:"aggregated_flows"`
-	DirectFlows     *ebpf.Map `ebpf:"direct_flows"`
+	AggregatedFlows  *ebpf.Map `ebpf:"aggregated_flows"`
+	DirectFlows      *ebpf.Map `ebpf:"direct_flows"`
+	Fragments        *ebpf.Map `ebpf:"fragments"`
+	MapFullFlows     *ebpf.Map `ebpf:"map_full_flows"`
+	Pressure         *ebpf.Map `ebpf:"pressure"`
+	SamplingOverride *ebpf.Map `ebpf:"sampling_override"`
+	TcpHandshakes    *ebpf.Map `ebpf:"tcp_handshakes"`
 }
 
 func (m *BpfMaps) Close() error {
 	return _BpfClose(
 		m.AggregatedFlows,
 		m.DirectFlows,
+		m.Fragments,
+		m.MapFullFlows,
+		m.Pressure,
+		m.SamplingOverride,
+		m.TcpHandshakes,
 	)
 }
 
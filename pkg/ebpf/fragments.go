@@ -0,0 +1,45 @@
+package ebpf
+
+import "time"
+
+// DefaultFragmentTimeout is the maximum time to wait for the rest of fragments of an IP datagram
+// before its fragments' tracking information is removed. It matches the default Linux
+// ipfrag_time value.
+const DefaultFragmentTimeout = 30 * time.Second
+
+// MergeExpiredFragments looks for the fragmented IP datagrams from which no fragment has been
+// observed during the provided timeout. The fragments of expired datagrams that couldn't be
+// attributed to any flow (because the first fragment, carrying the L4 header, was never observed)
+// are merged into the provided flows map, without L4 ports information.
+// It returns the keys of the expired datagrams, which need to be removed from the fragments map.
+func MergeExpiredFragments(
+	flows map[BpfFlowId]BpfFlowMetrics,
+	fragments map[BpfFragKey]BpfFragInfo,
+	monoNow uint64,
+	timeout time.Duration,
+) []BpfFragKey {
+	var expired []BpfFragKey
+	for key, info := range fragments {
+		if monoNow < info.LastSeenTs || monoNow-info.LastSeenTs < uint64(timeout) {
+			continue
+		}
+		expired = append(expired, key)
+		if info.FirstSeen != 0 || info.PendingPackets == 0 {
+			continue
+		}
+		metrics, ok := flows[info.Id]
+		if !ok {
+			metrics.StartMonoTimeTs = info.PendingStartTs
+		} else if info.PendingStartTs < metrics.StartMonoTimeTs {
+			metrics.StartMonoTimeTs = info.PendingStartTs
+		}
+		if info.LastSeenTs > metrics.EndMonoTimeTs {
+			metrics.EndMonoTimeTs = info.LastSeenTs
+		}
+		metrics.Packets += info.PendingPackets
+		metrics.Bytes += info.PendingBytes
+		metrics.FragmentedPackets += info.PendingPackets
+		flows[info.Id] = metrics
+	}
+	return expired
+}
@@ -0,0 +1,47 @@
+package ebpf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeExpiredFragments(t *testing.T) {
+	const sec = uint64(time.Second)
+	fragmentedFlow := BpfFlowId{TransportProtocol: 17, SrcPort: 1234, DstPort: 5678}
+	unresolvedFlow := BpfFlowId{TransportProtocol: 17}
+
+	// GIVEN a fragmented UDP flow whose first fragment has been already accounted
+	flows := map[BpfFlowId]BpfFlowMetrics{
+		fragmentedFlow: {Packets: 2, Bytes: 3000, FragmentedPackets: 2,
+			StartMonoTimeTs: 50 * sec, EndMonoTimeTs: 51 * sec},
+	}
+	fragments := map[BpfFragKey]BpfFragInfo{
+		// AND a datagram whose first fragment was observed recently
+		{IpId: 1}: {Id: fragmentedFlow, FirstSeen: 1, LastSeenTs: 51 * sec},
+		// AND a datagram whose first fragment was observed long ago
+		{IpId: 2}: {Id: fragmentedFlow, FirstSeen: 1, LastSeenTs: 10 * sec},
+		// AND out-of-order fragments that are still waiting for their first fragment
+		{IpId: 3}: {Id: unresolvedFlow, LastSeenTs: 45 * sec,
+			PendingPackets: 2, PendingBytes: 2800, PendingStartTs: 44 * sec},
+		// AND fragments whose first fragment never arrived
+		{IpId: 4}: {Id: unresolvedFlow, LastSeenTs: 15 * sec,
+			PendingPackets: 3, PendingBytes: 4000, PendingStartTs: 12 * sec},
+		{IpId: 5}: {Id: unresolvedFlow, LastSeenTs: 20 * sec,
+			PendingPackets: 1, PendingBytes: 500, PendingStartTs: 20 * sec},
+	}
+
+	// WHEN the expired fragments are merged
+	expired := MergeExpiredFragments(flows, fragments, 52*sec, 30*time.Second)
+
+	// THEN only the expired datagrams are removed
+	assert.ElementsMatch(t, []BpfFragKey{{IpId: 2}, {IpId: 4}, {IpId: 5}}, expired)
+	// AND the accounted fragmented flow is not modified
+	assert.Equal(t, BpfFlowMetrics{Packets: 2, Bytes: 3000, FragmentedPackets: 2,
+		StartMonoTimeTs: 50 * sec, EndMonoTimeTs: 51 * sec}, flows[fragmentedFlow])
+	// AND the unresolved fragments are accounted in a flow without port information
+	assert.Equal(t, BpfFlowMetrics{Packets: 4, Bytes: 4500, FragmentedPackets: 4,
+		StartMonoTimeTs: 12 * sec, EndMonoTimeTs: 20 * sec}, flows[unresolvedFlow])
+	assert.Len(t, flows, 2)
+}
@@ -0,0 +1,23 @@
+package ebpf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+var (
+	programHashOnce sync.Once
+	programHash     string
+)
+
+// ProgramHash returns a short hexadecimal digest (the first 8 bytes of its SHA-256) of the
+// eBPF object that is embedded in the agent, so the flows traced by different builds of the eBPF
+// program can be told apart.
+func ProgramHash() string {
+	programHashOnce.Do(func() {
+		sum := sha256.Sum256(_BpfBytes)
+		programHash = hex.EncodeToString(sum[:8])
+	})
+	return programHash
+}
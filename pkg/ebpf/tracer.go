@@ -4,11 +4,16 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/asm"
 	"github.com/cilium/ebpf/ringbuf"
 	"github.com/cilium/ebpf/rlimit"
+	"github.com/gavv/monotime"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ifaces"
 	"github.com/sirupsen/logrus"
 	"github.com/vishvananda/netlink"
@@ -21,17 +26,41 @@ import (
 const (
 	qdiscType = "clsact"
 	// constants defined in flows.c as "volatile const"
-	constSampling      = "sampling"
-	constTraceMessages = "trace_messages"
-	aggregatedFlowsMap = "aggregated_flows"
+	constSampling              = "sampling"
+	constTraceMessages         = "trace_messages"
+	constPayloadSampleBytes    = "payload_sample_bytes"
+	constPayloadSampleProtocol = "payload_sample_protocol"
+	constPayloadSamplePort     = "payload_sample_port"
+	constPktSizeBound          = "pkt_size_bound_"
+	constEnableConnectLatency  = "enable_connect_latency"
+	constEnableFragments       = "enable_fragments"
+	constEnableTunnelParsing   = "enable_tunnel_parsing"
+	constEnableIPIDTracking    = "enable_ip_id_tracking"
+	constMapFullSampling       = "map_full_sampling"
+	aggregatedFlowsMap         = "aggregated_flows"
+	directFlowsMap             = "direct_flows"
+	tcpHandshakesMap           = "tcp_handshakes"
+	fragmentsMap               = "fragments"
 )
 
+// disabledMapEntries is the size of the maps of the disabled features. The kernel doesn't allow
+// creating maps with zero entries
+const disabledMapEntries = 1
+
+// MaxPayloadSampleBytes is the maximum number of L4 payload bytes that can be sampled for
+// each flow. It must match the MAX_PAYLOAD_SAMPLE definition in bpf/flow.h
+const MaxPayloadSampleBytes = len(BpfFlowMetricsT{}.PayloadSample)
+
+// PacketSizeBuckets is the number of buckets of the packet size histogram of each flow. It must
+// match the PKT_SIZE_BUCKETS definition in bpf/flow.h
+const PacketSizeBuckets = len(BpfFlowMetricsT{}.PktSizeBuckets)
+
 var log = logrus.WithField("component", "ebpf.FlowFetcher")
 
 // FlowFetcher reads and forwards the Flows from the Traffic Control hooks in the eBPF kernel space.
 // It provides access both to flows that are aggregated in the kernel space (via PerfCPU hashmap)
 // and to flows that are forwarded by the kernel via ringbuffer because could not be aggregated
-// in the map
+// in the map. If the kernel doesn't support ring buffers, only the former are provided.
 type FlowFetcher struct {
 	objects        *BpfObjects
 	qdiscs         map[ifaces.Interface]*netlink.GenericQdisc
@@ -41,13 +70,69 @@ type FlowFetcher struct {
 	cacheMaxSize   int
 	enableIngress  bool
 	enableEgress   bool
+	// fragmentTimeout is the maximum time to wait for the fragments of an IP datagram
+	fragmentTimeout time.Duration
+	enableFragments bool
+	// lastFragmentsPurge is the monotonic time of the last purge of the fragments map, in
+	// nanoseconds. The evictions are serialized by the MapTracer, so it isn't synchronized.
+	lastFragmentsPurge uint64
 }
 
-func NewFlowFetcher(
-	traceMessages bool,
-	sampling, cacheMaxSize int,
-	ingress, egress bool,
-) (*FlowFetcher, error) {
+// FlowFetcherConfig holds the user-provided configuration of the eBPF flow fetcher
+type FlowFetcherConfig struct {
+	EnableIngress bool
+	EnableEgress  bool
+	Debug         bool
+	Sampling      int
+	CacheMaxSize  int
+	// PayloadSampleBytes is the number of L4 payload bytes to sample for each flow.
+	// 0 disables payload sampling. Must not be higher than MaxPayloadSampleBytes
+	PayloadSampleBytes int
+	// PayloadSampleProtocol restricts payload sampling to the given transport protocol
+	// number (e.g. 6 for TCP). 0 means any protocol
+	PayloadSampleProtocol uint8
+	// PayloadSamplePort restricts payload sampling to flows whose source or destination
+	// port matches it. 0 means any port
+	PayloadSamplePort uint16
+	// PacketSizeBounds are the inclusive upper bounds of the buckets of the packet size
+	// histogram, in bytes, sorted in increasing order. The last bucket counts the packets larger
+	// than the last bound, so it must contain PacketSizeBuckets-1 elements. If empty, the default
+	// bounds from bpf/flows.c are used
+	PacketSizeBounds []uint16
+	// FragmentTimeout is the maximum time to wait for the fragments of an IP datagram
+	// before discarding its tracking information. If 0, DefaultFragmentTimeout is used
+	FragmentTimeout time.Duration
+	// EnableConnectLatency enables the tracking of the TCP handshakes to measure the time between
+	// the SYN and the SYN-ACK packets
+	EnableConnectLatency bool
+	// EnableFragments enables the tracking of the IP fragments, to attribute the non-first
+	// fragments of a datagram to the flow of the first fragment
+	EnableFragments bool
+	// EnableTunnelParsing enables the parsing of the VXLAN and Geneve headers, to account the
+	// tunneled packets by their inner flow too
+	EnableTunnelParsing bool
+	// EnableIPIDTracking enables the tracking of the IPv4 identification of the last packets of
+	// each flow, to count the duplicated packets
+	EnableIPIDTracking bool
+	// MapFullSampling is the ratio of the flows that couldn't be added to the full aggregated
+	// flows map that are submitted through the ring buffer (e.g. 10 means 1 out of each 10). The
+	// rest are discarded by the eBPF program. 0 or 1 submits all of them
+	MapFullSampling int
+	// RingBufFallback loads the eBPF program without the ring buffer if the kernel doesn't
+	// support it, instead of failing. Then the flows that don't fit in the aggregated flows map
+	// are dropped, and they can only be read by scanning the map. See FlowFetcher.RingBufEnabled
+	RingBufFallback bool
+}
+
+func NewFlowFetcher(cfg *FlowFetcherConfig) (*FlowFetcher, error) {
+	if cfg.PayloadSampleBytes < 0 || cfg.PayloadSampleBytes > MaxPayloadSampleBytes {
+		return nil, fmt.Errorf("payload sample bytes must be between 0 and %d. Got: %d",
+			MaxPayloadSampleBytes, cfg.PayloadSampleBytes)
+	}
+	if len(cfg.PacketSizeBounds) != 0 && len(cfg.PacketSizeBounds) != PacketSizeBuckets-1 {
+		return nil, fmt.Errorf("expecting %d packet size bounds. Got: %d",
+			PacketSizeBuckets-1, len(cfg.PacketSizeBounds))
+	}
 	if err := rlimit.RemoveMemlock(); err != nil {
 		log.WithError(err).
 			Warn("can't remove mem lock. The agent could not be able to start eBPF programs")
@@ -59,18 +144,17 @@ func NewFlowFetcher(
 		return nil, fmt.Errorf("loading BPF data: %w", err)
 	}
 
-	// Resize aggregated flows map according to user-provided configuration
-	spec.Maps[aggregatedFlowsMap].MaxEntries = uint32(cacheMaxSize)
-
-	traceMsgs := 0
-	if traceMessages {
-		traceMsgs = 1
+	ringBuf := ringBufSupported()
+	if !ringBuf {
+		if !cfg.RingBufFallback {
+			return nil, errors.New("the kernel doesn't support eBPF ring buffers")
+		}
+		log.Warn("the kernel doesn't support eBPF ring buffers. Running in degraded mode: " +
+			"the flows are only read by periodically scanning the flows map, and the flows " +
+			"that don't fit in the map are dropped")
 	}
-	if err := spec.RewriteConstants(map[string]interface{}{
-		constSampling:      uint32(sampling),
-		constTraceMessages: uint8(traceMsgs),
-	}); err != nil {
-		return nil, fmt.Errorf("rewriting BPF constants definition: %w", err)
+	if err := configureSpec(spec, cfg, ringBuf); err != nil {
+		return nil, err
 	}
 	if err := spec.LoadAndAssign(&objects, nil); err != nil {
 		var ve *ebpf.VerifierError
@@ -82,23 +166,125 @@ func NewFlowFetcher(
 		return nil, fmt.Errorf("loading and assigning BPF objects: %w", err)
 	}
 
+	fragmentTimeout := cfg.FragmentTimeout
+	if fragmentTimeout == 0 {
+		fragmentTimeout = DefaultFragmentTimeout
+	}
+
 	// read events from igress+egress ringbuffer
-	flows, err := ringbuf.NewReader(objects.DirectFlows)
-	if err != nil {
-		return nil, fmt.Errorf("accessing to ringbuffer: %w", err)
+	var flows *ringbuf.Reader
+	if ringBuf {
+		if flows, err = ringbuf.NewReader(objects.DirectFlows); err != nil {
+			return nil, fmt.Errorf("accessing to ringbuffer: %w", err)
+		}
 	}
 	return &FlowFetcher{
-		objects:        &objects,
-		ringbufReader:  flows,
-		egressFilters:  map[ifaces.Interface]*netlink.BpfFilter{},
-		ingressFilters: map[ifaces.Interface]*netlink.BpfFilter{},
-		qdiscs:         map[ifaces.Interface]*netlink.GenericQdisc{},
-		cacheMaxSize:   cacheMaxSize,
-		enableIngress:  ingress,
-		enableEgress:   egress,
+		objects:         &objects,
+		ringbufReader:   flows,
+		egressFilters:   map[ifaces.Interface]*netlink.BpfFilter{},
+		ingressFilters:  map[ifaces.Interface]*netlink.BpfFilter{},
+		qdiscs:          map[ifaces.Interface]*netlink.GenericQdisc{},
+		cacheMaxSize:    cfg.CacheMaxSize,
+		enableIngress:   cfg.EnableIngress,
+		enableEgress:    cfg.EnableEgress,
+		fragmentTimeout: fragmentTimeout,
+		enableFragments: cfg.EnableFragments,
 	}, nil
 }
 
+// configureSpec adapts the eBPF collection to the user-provided configuration, before it is
+// loaded into the kernel. The maps of the disabled features are shrunk to the minimum size, and
+// the code paths that use them are skipped. The code of the disabled features is still loaded,
+// as part of the same program, and their fields are still part of the flow metrics. If ringBuf is false, the ring buffer is replaced by a
+// placeholder map, and the calls to the ring buffer helpers are removed, so the collection can be
+// loaded by the kernels without ring buffer support.
+func configureSpec(spec *ebpf.CollectionSpec, cfg *FlowFetcherConfig, ringBuf bool) error {
+	// Resize aggregated flows map according to user-provided configuration
+	spec.Maps[aggregatedFlowsMap].MaxEntries = uint32(cfg.CacheMaxSize)
+	if !cfg.EnableConnectLatency {
+		spec.Maps[tcpHandshakesMap].MaxEntries = disabledMapEntries
+	}
+	if !cfg.EnableFragments {
+		spec.Maps[fragmentsMap].MaxEntries = disabledMapEntries
+	}
+	if !ringBuf {
+		disableRingBuf(spec)
+	}
+
+	traceMsgs := 0
+	if cfg.Debug {
+		traceMsgs = 1
+	}
+	constants := map[string]interface{}{
+		constSampling:              uint32(cfg.Sampling),
+		constTraceMessages:         uint8(traceMsgs),
+		constPayloadSampleBytes:    uint16(cfg.PayloadSampleBytes),
+		constPayloadSampleProtocol: cfg.PayloadSampleProtocol,
+		constPayloadSamplePort:     cfg.PayloadSamplePort,
+		constEnableConnectLatency:  boolConst(cfg.EnableConnectLatency),
+		constEnableFragments:       boolConst(cfg.EnableFragments),
+		constEnableTunnelParsing:   boolConst(cfg.EnableTunnelParsing),
+		constEnableIPIDTracking:    boolConst(cfg.EnableIPIDTracking),
+		constMapFullSampling:       uint32(cfg.MapFullSampling),
+	}
+	for i, bound := range cfg.PacketSizeBounds {
+		constants[constPktSizeBound+strconv.Itoa(i)] = bound
+	}
+	if err := spec.RewriteConstants(constants); err != nil {
+		return fmt.Errorf("rewriting BPF constants definition: %w", err)
+	}
+	return nil
+}
+
+// disableRingBuf replaces the calls to the ring buffer helpers by a NULL result, as if the ring
+// buffer was always full, so the flows that don't fit in the aggregated flows map are dropped. The
+// verifier skips the code that submits the records, as it is unreachable. The program still loads
+// the address of the ring buffer, so it is replaced by a map of a type that any kernel supports.
+func disableRingBuf(spec *ebpf.CollectionSpec) {
+	spec.Maps[directFlowsMap] = &ebpf.MapSpec{
+		Name:       directFlowsMap,
+		Type:       ebpf.Array,
+		KeySize:    4,
+		ValueSize:  4,
+		MaxEntries: disabledMapEntries,
+	}
+	for _, prog := range spec.Programs {
+		for i, ins := range prog.Instructions {
+			if ins.IsBuiltinCall() && (ins.Constant == int64(asm.FnRingbufReserve) ||
+				ins.Constant == int64(asm.FnRingbufSubmit)) {
+				prog.Instructions[i] = asm.Mov.Imm(asm.R0, 0).WithMetadata(ins.Metadata)
+			}
+		}
+	}
+}
+
+// ringBufSupported tells whether the kernel supports eBPF ring buffers, by creating a small one.
+// The unexpected errors (e.g. missing privileges) are left to be reported by the loading of the
+// eBPF collection.
+func ringBufSupported() bool {
+	rb, err := ebpf.NewMap(&ebpf.MapSpec{
+		Name:       "ringbuf_probe",
+		Type:       ebpf.RingBuf,
+		MaxEntries: uint32(os.Getpagesize()),
+	})
+	if err != nil {
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, ebpf.ErrNotSupported) {
+			return false
+		}
+		log.WithError(err).Debug("can't probe the support of eBPF ring buffers")
+		return true
+	}
+	_ = rb.Close()
+	return true
+}
+
+func boolConst(enabled bool) uint8 {
+	if enabled {
+		return 1
+	}
+	return 0
+}
+
 // Register and links the eBPF fetcher into the system. The program should invoke Unregister
 // before exiting.
 func (m *FlowFetcher) Register(iface ifaces.Interface) error {
@@ -224,16 +410,8 @@ func (m *FlowFetcher) Close() error {
 		}
 	}
 	if m.objects != nil {
-		if err := m.objects.EgressFlowParse.Close(); err != nil {
-			errs = append(errs, err)
-		}
-		if err := m.objects.IngressFlowParse.Close(); err != nil {
-			errs = append(errs, err)
-		}
-		if err := m.objects.AggregatedFlows.Close(); err != nil {
-			errs = append(errs, err)
-		}
-		if err := m.objects.DirectFlows.Close(); err != nil {
+		// all the generated programs and maps are closed, so none of them is missed
+		if err := m.objects.Close(); err != nil {
 			errs = append(errs, err)
 		}
 		m.objects = nil
@@ -295,10 +473,41 @@ func doIgnoreNoDev[T any](sysCall func(T) error, dev T, log *logrus.Entry) error
 	return nil
 }
 
+// SetPressureLevel tells the eBPF program how far userspace is from keeping up with the flows.
+// For a level N > 0, the eBPF program only accounts 1 out of 2^N packets, on top of the
+// configured sampling. Level 0 accounts all the (sampled) packets.
+func (m *FlowFetcher) SetPressureLevel(level uint32) error {
+	if err := m.objects.Pressure.Put(uint32(0), level); err != nil {
+		return fmt.Errorf("writing pressure level %d: %w", level, err)
+	}
+	return nil
+}
+
+// SetSamplingRate overrides, without reloading the eBPF program, the sampling rate that was
+// configured when the FlowFetcher was created. A rate of 0 restores the configured sampling.
+func (m *FlowFetcher) SetSamplingRate(rate uint32) error {
+	if err := m.objects.SamplingOverride.Put(uint32(0), rate); err != nil {
+		return fmt.Errorf("writing sampling rate %d: %w", rate, err)
+	}
+	return nil
+}
+
+// ReadRingBuf blocks until a flow is read from the ring buffer. If the ring buffer is not enabled,
+// it returns ringbuf.ErrClosed.
 func (m *FlowFetcher) ReadRingBuf() (ringbuf.Record, error) {
+	if m.ringbufReader == nil {
+		return ringbuf.Record{}, ringbuf.ErrClosed
+	}
 	return m.ringbufReader.Read()
 }
 
+// RingBufEnabled tells whether the flows that don't fit in the aggregated flows map are forwarded
+// through the ring buffer. It is false if the kernel doesn't support ring buffers, so the flows
+// can only be read by scanning the map.
+func (m *FlowFetcher) RingBufEnabled() bool {
+	return m.ringbufReader != nil
+}
+
 // LookupAndDeleteMap reads all the entries from the eBPF map and removes them from it.
 // It returns a map where the key
 // For synchronization purposes, we get/delete a whole snapshot of the flows map.
@@ -328,5 +537,70 @@ func (m *FlowFetcher) LookupAndDeleteMap() map[BpfFlowId]BpfFlowMetrics {
 		// TODO: instrument how many times the keys are is repeated in the same eviction
 		flow[id] = metric
 	}
+	m.purgeFragments(flow)
 	return flow
 }
+
+// LookupAndDeleteStartedBefore reads and removes from the eBPF map the flows whose start time is
+// older than the provided monotonic timestamp, in nanoseconds. The next packets of these flows
+// will be accounted in new map entries.
+func (m *FlowFetcher) LookupAndDeleteStartedBefore(monoTs uint64) map[BpfFlowId]BpfFlowMetrics {
+	return m.LookupAndDeleteMatching(func(_ *BpfFlowId, metric *BpfFlowMetrics) bool {
+		return metric.StartMonoTimeTs < monoTs
+	})
+}
+
+// LookupAndDeleteMatching reads and removes from the eBPF map the flows for which the provided
+// function returns true. The next packets of these flows will be accounted in new map entries.
+// The fragments that couldn't be attributed to any flow are also returned once they expire, as
+// with LookupAndDeleteMap, so they are accounted whichever eviction path is used. As this function
+// can be invoked much more often than the regular eviction (e.g. for each deadline check), the
+// fragments map is scanned at most once per fragment timeout.
+func (m *FlowFetcher) LookupAndDeleteMatching(
+	match func(id *BpfFlowId, metric *BpfFlowMetrics) bool,
+) map[BpfFlowId]BpfFlowMetrics {
+	flowMap := m.objects.AggregatedFlows
+
+	iterator := flowMap.Iterate()
+	flows := map[BpfFlowId]BpfFlowMetrics{}
+
+	id := BpfFlowId{}
+	var metric BpfFlowMetrics
+	for iterator.Next(&id, &metric) {
+		if !match(&id, &metric) {
+			continue
+		}
+		if err := flowMap.Delete(id); err != nil {
+			log.WithError(err).WithField("flowId", id).
+				Warnf("couldn't delete flow entry")
+			continue
+		}
+		flows[id] = metric
+	}
+	if uint64(monotime.Now())-m.lastFragmentsPurge >= uint64(m.fragmentTimeout) {
+		m.purgeFragments(flows)
+	}
+	return flows
+}
+
+// purgeFragments removes the expired datagrams from the fragments map, and accounts in the
+// provided flows map the fragments that couldn't be attributed to any flow
+func (m *FlowFetcher) purgeFragments(flows map[BpfFlowId]BpfFlowMetrics) {
+	if !m.enableFragments {
+		return
+	}
+	m.lastFragmentsPurge = uint64(monotime.Now())
+	fragMap := m.objects.Fragments
+	fragments := map[BpfFragKey]BpfFragInfo{}
+	key := BpfFragKey{}
+	var info BpfFragInfo
+	iterator := fragMap.Iterate()
+	for iterator.Next(&key, &info) {
+		fragments[key] = info
+	}
+	for _, k := range MergeExpiredFragments(flows, fragments, uint64(monotime.Now()), m.fragmentTimeout) {
+		if err := fragMap.Delete(k); err != nil {
+			log.WithError(err).WithField("fragmentKey", k).Debug("couldn't delete fragment entry")
+		}
+	}
+}
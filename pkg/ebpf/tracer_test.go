@@ -0,0 +1,241 @@
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/cilium/ebpf/rlimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureSpec_Features(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		cfg               FlowFetcherConfig
+		handshakesEntries uint32
+		fragmentsEntries  uint32
+	}{{
+		name:              "all features disabled",
+		cfg:               FlowFetcherConfig{CacheMaxSize: 100},
+		handshakesEntries: disabledMapEntries,
+		fragmentsEntries:  disabledMapEntries,
+	}, {
+		name:              "connect latency enabled",
+		cfg:               FlowFetcherConfig{CacheMaxSize: 100, EnableConnectLatency: true},
+		handshakesEntries: 1 << 16,
+		fragmentsEntries:  disabledMapEntries,
+	}, {
+		name:              "fragments enabled",
+		cfg:               FlowFetcherConfig{CacheMaxSize: 100, EnableFragments: true},
+		handshakesEntries: disabledMapEntries,
+		fragmentsEntries:  1 << 16,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := LoadBpf()
+			require.NoError(t, err)
+			require.NoError(t, configureSpec(spec, &tc.cfg, true))
+
+			// the disabled features don't load any extra program, and their maps are shrunk
+			var programs []string
+			for name := range spec.Programs {
+				programs = append(programs, name)
+			}
+			assert.ElementsMatch(t, []string{"ingress_flow_parse", "egress_flow_parse"}, programs)
+			assert.EqualValues(t, 100, spec.Maps[aggregatedFlowsMap].MaxEntries)
+			assert.Equal(t, tc.handshakesEntries, spec.Maps[tcpHandshakesMap].MaxEntries)
+			assert.Equal(t, tc.fragmentsEntries, spec.Maps[fragmentsMap].MaxEntries)
+		})
+	}
+}
+
+func TestIPIDTracking_DuplicatePackets(t *testing.T) {
+	objects := loadTestObjects(t, &FlowFetcherConfig{CacheMaxSize: 100, EnableIPIDTracking: true}, true)
+
+	// GIVEN a flow whose packets repeat some IPv4 identifications, as a mirror would do
+	for _, ipID := range []uint16{1, 2, 1, 3, 2, 2, 0, 0} {
+		runIngress(t, objects, udpPacket(ipID))
+	}
+
+	// THEN the packets whose identification was observed recently are counted as duplicates,
+	// ignoring the packets without identification
+	metrics := onlyFlow(t, objects)
+	assert.EqualValues(t, 8, metrics.Packets)
+	assert.EqualValues(t, 3, metrics.DuplicatePackets)
+}
+
+func TestIPIDTracking_BoundedHistory(t *testing.T) {
+	objects := loadTestObjects(t, &FlowFetcherConfig{CacheMaxSize: 100, EnableIPIDTracking: true}, true)
+
+	// GIVEN a flow whose first identification is repeated after more packets than the history size
+	ipIDs := []uint16{1}
+	for i := 0; i < len(BpfFlowMetrics{}.RecentIpIds); i++ {
+		ipIDs = append(ipIDs, uint16(100+i))
+	}
+	for _, ipID := range append(ipIDs, 1) {
+		runIngress(t, objects, udpPacket(ipID))
+	}
+
+	// THEN it is not counted as a duplicate, as it is out of the history
+	assert.Zero(t, onlyFlow(t, objects).DuplicatePackets)
+}
+
+func TestIPIDTracking_Disabled(t *testing.T) {
+	objects := loadTestObjects(t, &FlowFetcherConfig{CacheMaxSize: 100}, true)
+	for _, ipID := range []uint16{1, 1, 1} {
+		runIngress(t, objects, udpPacket(ipID))
+	}
+	assert.Zero(t, onlyFlow(t, objects).DuplicatePackets)
+}
+
+func TestRingBufDisabled(t *testing.T) {
+	spec, err := LoadBpf()
+	require.NoError(t, err)
+	require.NoError(t, configureSpec(spec, &FlowFetcherConfig{CacheMaxSize: 100}, false))
+	assert.Equal(t, ebpf.Array, spec.Maps[directFlowsMap].Type)
+
+	// GIVEN the eBPF program loaded without ring buffer, and a flows map that fits a single flow
+	objects := loadTestObjects(t, &FlowFetcherConfig{CacheMaxSize: 1}, false)
+
+	// WHEN the map is full
+	runIngress(t, objects, udpPacket(1))
+	other := udpPacket(2)
+	binary.BigEndian.PutUint16(other[14+20:], 4321)
+	runIngress(t, objects, other)
+
+	// THEN the flows that fit in the map are still aggregated, and the rest are dropped
+	runIngress(t, objects, udpPacket(3))
+	assert.EqualValues(t, 2, onlyFlow(t, objects).Packets)
+}
+
+func TestMapFullSampling(t *testing.T) {
+	// GIVEN a flows map that fits a single flow, and the map-full flows sampled 1 out of 3
+	objects := loadTestObjects(t, &FlowFetcherConfig{CacheMaxSize: 1, MapFullSampling: 3}, true)
+	reader, err := ringbuf.NewReader(objects.DirectFlows)
+	require.NoError(t, err)
+	defer reader.Close()
+	runIngress(t, objects, udpPacket(1))
+
+	// WHEN the flows that don't fit in the map are received
+	for port := uint16(1); port <= 7; port++ {
+		packet := udpPacket(port)
+		binary.BigEndian.PutUint16(packet[14+20:], 4000+port)
+		runIngress(t, objects, packet)
+	}
+
+	// THEN only 1 out of 3 of them is submitted to the ring buffer
+	var ports []uint16
+	reader.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+			break
+		}
+		var flow BpfFlowRecordT
+		require.NoError(t, binary.Read(bytes.NewReader(record.RawSample), binary.LittleEndian, &flow))
+		assert.EqualValues(t, syscall.E2BIG, flow.Metrics.Errno)
+		ports = append(ports, flow.Id.SrcPort)
+	}
+	assert.Equal(t, []uint16{4001, 4004, 4007}, ports)
+}
+
+// loadTestObjects loads the eBPF programs to run them with crafted packets. The test is skipped
+// if the process isn't allowed to load them (e.g. without the CAP_BPF capability), but it fails
+// if they are rejected by the verifier
+func loadTestObjects(t *testing.T, cfg *FlowFetcherConfig, ringBuf bool) *BpfObjects {
+	t.Helper()
+	if err := rlimit.RemoveMemlock(); err != nil {
+		t.Skipf("can't remove mem lock: %v", err)
+	}
+	spec, err := LoadBpf()
+	require.NoError(t, err)
+	require.NoError(t, configureSpec(spec, cfg, ringBuf))
+	objects := &BpfObjects{}
+	if err := spec.LoadAndAssign(objects, nil); err != nil {
+		var ve *ebpf.VerifierError
+		if !errors.As(err, &ve) &&
+			(errors.Is(err, os.ErrPermission) || errors.Is(err, ebpf.ErrNotSupported)) {
+			t.Skipf("can't load the eBPF objects: %v", err)
+		}
+		require.NoError(t, err)
+	}
+	t.Cleanup(func() { objects.Close() })
+	return objects
+}
+
+func runIngress(t *testing.T, objects *BpfObjects, packet []byte) {
+	t.Helper()
+	_, err := objects.IngressFlowParse.Run(&ebpf.RunOptions{Data: packet})
+	require.NoError(t, err)
+}
+
+func onlyFlow(t *testing.T, objects *BpfObjects) BpfFlowMetrics {
+	t.Helper()
+	var id BpfFlowId
+	var metrics BpfFlowMetrics
+	flows := 0
+	iterator := objects.AggregatedFlows.Iterate()
+	for iterator.Next(&id, &metrics) {
+		flows++
+	}
+	require.NoError(t, iterator.Err())
+	require.Equal(t, 1, flows)
+	return metrics
+}
+
+// udpPacket returns an Ethernet frame carrying an IPv4 UDP datagram from 10.0.0.1:1234 to
+// 10.0.0.2:53 with the provided identification
+func udpPacket(ipID uint16) []byte {
+	packet := make([]byte, 14+20+8+4)
+	// Ethernet
+	copy(packet[0:], []byte{0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 1})
+	binary.BigEndian.PutUint16(packet[12:], 0x0800)
+	// IPv4, without fragmentation
+	ip := packet[14:]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)))
+	binary.BigEndian.PutUint16(ip[4:], ipID)
+	ip[8] = 64
+	ip[9] = 17
+	copy(ip[12:], []byte{10, 0, 0, 1, 10, 0, 0, 2})
+	// UDP
+	udp := ip[20:]
+	binary.BigEndian.PutUint16(udp[0:], 1234)
+	binary.BigEndian.PutUint16(udp[2:], 53)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	return packet
+}
+
+func TestLookupAndDeleteMatching_PurgesFragments(t *testing.T) {
+	cfg := &FlowFetcherConfig{CacheMaxSize: 100, EnableFragments: true}
+	objects := loadTestObjects(t, cfg, false)
+	fetcher := &FlowFetcher{
+		objects: objects, enableFragments: true, fragmentTimeout: time.Millisecond,
+	}
+
+	// GIVEN an expired datagram whose fragments couldn't be attributed to any flow
+	id := BpfFlowId{TransportProtocol: 17, SrcPort: 1234, DstPort: 53}
+	require.NoError(t, objects.Fragments.Put(BpfFragKey{IpId: 7, TransportProtocol: 17},
+		BpfFragInfo{Id: id, LastSeenTs: 1, PendingPackets: 2, PendingBytes: 3000, PendingStartTs: 1}))
+
+	// WHEN the flows are evicted selectively, even if none of them matches
+	flows := fetcher.LookupAndDeleteMatching(func(_ *BpfFlowId, _ *BpfFlowMetrics) bool {
+		return false
+	})
+
+	// THEN the pending fragments are accounted in their flow and removed from the fragments map
+	require.Contains(t, flows, id)
+	assert.EqualValues(t, 2, flows[id].Packets)
+	assert.EqualValues(t, 3000, flows[id].Bytes)
+	var key BpfFragKey
+	var info BpfFragInfo
+	assert.False(t, objects.Fragments.Iterate().Next(&key, &info))
+}
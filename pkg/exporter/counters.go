@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var clog = logrus.WithField("component", "exporter/Counters")
+
+// Counters exporter does not forward individual flows. Instead, it accumulates the bytes and
+// packets of the flows into Prometheus counters, broken down by interface, transport protocol
+// and direction. The counters are exposed through the agent metrics endpoint, providing a
+// compact view of the traffic without the cardinality of per-flow records.
+// Optionally, it also accumulates SNMP-style interface counters (ifInOctets, ifOutOctets...),
+// so the flow data can be ingested by traditional network management dashboards.
+type Counters struct {
+	bytes   *prometheus.CounterVec
+	packets *prometheus.CounterVec
+
+	// interface counters. They are nil if disabled
+	ifInOctets  *prometheus.CounterVec
+	ifOutOctets *prometheus.CounterVec
+	ifInPkts    *prometheus.CounterVec
+	ifOutPkts   *prometheus.CounterVec
+}
+
+// NewCounters creates a Counters exporter whose metrics are registered in the provided registry.
+// If ifCounters is true, it also exposes the cumulative ingress and egress bytes and packets of
+// each interface, named after the IF-MIB objects and labeled by ifName.
+func NewCounters(m *metrics.Metrics, ifCounters bool) *Counters {
+	labels := []string{"interface", "protocol", "direction"}
+	c := &Counters{
+		bytes: m.NewCounterVec("flow_bytes_total",
+			"Total bytes of the observed flows", labels...),
+		packets: m.NewCounterVec("flow_packets_total",
+			"Total packets of the observed flows", labels...),
+	}
+	if ifCounters {
+		c.ifInOctets = m.NewCounterVec("ifInOctets",
+			"Total bytes received by the interface, according to the observed flows", "ifName")
+		c.ifOutOctets = m.NewCounterVec("ifOutOctets",
+			"Total bytes sent by the interface, according to the observed flows", "ifName")
+		c.ifInPkts = m.NewCounterVec("ifInPkts",
+			"Total packets received by the interface, according to the observed flows", "ifName")
+		c.ifOutPkts = m.NewCounterVec("ifOutPkts",
+			"Total packets sent by the interface, according to the observed flows", "ifName")
+	}
+	return c
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel and accumulates their
+// metrics into the counters
+func (c *Counters) ExportFlows(input <-chan []*flow.Record) {
+	clog.Info("starting counters exporter")
+	Terminal(c)(input)
+}
+
+// Export accumulates the metrics of the flows into the counters
+func (c *Counters) Export(records []*flow.Record) error {
+	for _, record := range records {
+		if record.IsHeartbeat() {
+			continue
+		}
+		labels := []string{
+			record.Interface,
+			protocolName(record.Id.TransportProtocol),
+			directionName(record.Id.Direction),
+		}
+		c.bytes.WithLabelValues(labels...).Add(float64(record.Metrics.Bytes))
+		c.packets.WithLabelValues(labels...).Add(float64(record.Metrics.Packets))
+		if c.ifInOctets != nil {
+			c.accountInterface(record)
+		}
+	}
+	return nil
+}
+
+// accountInterface adds the metrics of the flow to the interface counters of its direction
+func (c *Counters) accountInterface(record *flow.Record) {
+	switch record.Id.Direction {
+	case flow.DirectionIngress:
+		c.ifInOctets.WithLabelValues(record.Interface).Add(float64(record.Metrics.Bytes))
+		c.ifInPkts.WithLabelValues(record.Interface).Add(float64(record.Metrics.Packets))
+	case flow.DirectionEgress:
+		c.ifOutOctets.WithLabelValues(record.Interface).Add(float64(record.Metrics.Bytes))
+		c.ifOutPkts.WithLabelValues(record.Interface).Add(float64(record.Metrics.Packets))
+	}
+}
+
+func (c *Counters) Close() error {
+	return nil
+}
+
+// ConcurrentSafe marks the exporter as safe for concurrent use, as the Prometheus counters are
+func (c *Counters) ConcurrentSafe() {}
+
+func directionName(direction uint8) string {
+	switch direction {
+	case flow.DirectionIngress:
+		return "ingress"
+	case flow.DirectionEgress:
+		return "egress"
+	case flow.DirectionUnknown:
+		return "unknown"
+	default:
+		return strconv.Itoa(int(direction))
+	}
+}
@@ -0,0 +1,146 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestCounters_Breakdown(t *testing.T) {
+	m := metrics.NoOp()
+	counters := NewCounters(m, false)
+
+	record := func(iface string, proto, direction uint8, bytes uint64, packets uint32) *flow.Record {
+		r := &flow.Record{Interface: iface}
+		r.Id.TransportProtocol = proto
+		r.Id.Direction = direction
+		r.Metrics.Bytes = bytes
+		r.Metrics.Packets = packets
+		return r
+	}
+	input := make(chan []*flow.Record, 10)
+	input <- []*flow.Record{
+		record("eth0", 6, flow.DirectionEgress, 100, 1),
+		record("eth0", 6, flow.DirectionEgress, 200, 2),
+		record("eth0", 6, flow.DirectionIngress, 1000, 3),
+	}
+	input <- []*flow.Record{
+		record("eth0", 17, flow.DirectionEgress, 50, 1),
+		record("eth1", 6, flow.DirectionEgress, 10, 1),
+		record("eth1", 132, flow.DirectionIngress, 20, 1),
+		record("eth1", 99, flow.DirectionIngress, 30, 1),
+	}
+	close(input)
+	counters.ExportFlows(input)
+
+	type key struct{ iface, proto, direction string }
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	values := map[string]map[key]float64{}
+	for _, family := range families {
+		values[family.GetName()] = map[key]float64{}
+		for _, metric := range family.Metric {
+			labels := map[string]string{}
+			for _, l := range metric.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			values[family.GetName()][key{labels["interface"], labels["protocol"], labels["direction"]}] =
+				metric.GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, map[key]float64{
+		{"eth0", "tcp", "egress"}:   300,
+		{"eth0", "tcp", "ingress"}:  1000,
+		{"eth0", "udp", "egress"}:   50,
+		{"eth1", "tcp", "egress"}:   10,
+		{"eth1", "sctp", "ingress"}: 20,
+		{"eth1", "99", "ingress"}:   30,
+	}, values["flow_bytes_total"])
+	assert.Equal(t, map[key]float64{
+		{"eth0", "tcp", "egress"}:   3,
+		{"eth0", "tcp", "ingress"}:  3,
+		{"eth0", "udp", "egress"}:   1,
+		{"eth1", "tcp", "egress"}:   1,
+		{"eth1", "sctp", "ingress"}: 1,
+		{"eth1", "99", "ingress"}:   1,
+	}, values["flow_packets_total"])
+}
+
+func TestCounters_Interfaces(t *testing.T) {
+	m := metrics.NoOp()
+	counters := NewCounters(m, true)
+
+	record := func(iface string, direction uint8, bytes uint64, packets uint32) *flow.Record {
+		r := &flow.Record{Interface: iface}
+		r.Id.TransportProtocol = 6
+		r.Id.Direction = direction
+		r.Metrics.Bytes = bytes
+		r.Metrics.Packets = packets
+		return r
+	}
+	require.NoError(t, counters.Export([]*flow.Record{
+		record("eth0", flow.DirectionIngress, 100, 1),
+		record("eth0", flow.DirectionEgress, 200, 2),
+		record("eth1", flow.DirectionEgress, 300, 3),
+	}))
+	require.NoError(t, counters.Export([]*flow.Record{
+		record("eth0", flow.DirectionIngress, 1000, 10),
+		record("eth1", flow.DirectionEgress, 3000, 30),
+	}))
+
+	// the interface counters are the cumulative sum of the flows in each direction
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	values := map[string]map[string]float64{}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			for _, l := range metric.Label {
+				if l.GetName() == "ifName" {
+					if values[family.GetName()] == nil {
+						values[family.GetName()] = map[string]float64{}
+					}
+					values[family.GetName()][l.GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]map[string]float64{
+		"ifInOctets":  {"eth0": 1100},
+		"ifOutOctets": {"eth0": 200, "eth1": 3300},
+		"ifInPkts":    {"eth0": 11},
+		"ifOutPkts":   {"eth0": 2, "eth1": 33},
+	}, values)
+}
+
+func TestCounters_IgnoreHeartbeats(t *testing.T) {
+	m := metrics.NoOp()
+	counters := NewCounters(m, false)
+
+	record := &flow.Record{Interface: "eth0"}
+	record.Id.TransportProtocol = 6
+	record.Metrics.Bytes = 100
+	record.Metrics.Packets = 1
+	input := make(chan []*flow.Record, 10)
+	input <- []*flow.Record{record, flow.NewHeartbeat(time.Now(), nil, "", "")}
+	input <- []*flow.Record{flow.NewHeartbeat(time.Now(), nil, "", "")}
+	close(input)
+	counters.ExportFlows(input)
+
+	// only the actual flow is accounted
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+	for _, family := range families {
+		require.Len(t, family.Metric, 1, family.GetName())
+		for _, l := range family.Metric[0].Label {
+			if l.GetName() == "interface" {
+				assert.Equal(t, "eth0", l.GetValue())
+			}
+		}
+	}
+}
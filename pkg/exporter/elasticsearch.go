@@ -0,0 +1,323 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var eslog = logrus.WithField("component", "exporter/Elasticsearch")
+
+// esIndexDate is the placeholder of the index name templates that is replaced by the start date
+// of each flow, so the flows can be stored in daily indices
+const esIndexDate = "{date}"
+
+// ElasticsearchConfig configures the Elasticsearch exporter
+type ElasticsearchConfig struct {
+	// URL of the Elasticsearch cluster (e.g. https://elasticsearch:9200)
+	URL string
+	// Index is the name of the index where the flows are stored. The {date} placeholder is
+	// replaced by the UTC start date of each flow, in the YYYY.MM.DD format.
+	Index string
+	// Username and Password of the basic authentication. Both empty to disable authentication.
+	Username string
+	Password string
+	// BatchSize is the maximum number of flows that are submitted in a single bulk request
+	BatchSize int
+	// Retries is the number of times that the flows whose indexing failed are submitted again,
+	// waiting RetryBackoff before the first retry, and doubling it in each retry
+	Retries      int
+	RetryBackoff time.Duration
+	// FieldCase is the naming convention of the document fields (see NewJSONMarshaler)
+	FieldCase string
+	// ProtocolNames adds the IANA name of the transport protocol to the documents
+	ProtocolNames bool
+}
+
+// Elasticsearch exporter indexes the flows, as JSON documents, through the Elasticsearch bulk
+// API. When the bulk request partially fails, only the documents that failed with a transient
+// error (e.g. too many requests) are submitted again.
+type Elasticsearch struct {
+	client    *http.Client
+	cfg       ElasticsearchConfig
+	bulkURL   string
+	marshaler *JSONMarshaler
+	indexed   prometheus.Counter
+	failed    prometheus.Counter
+	sleep     func(time.Duration)
+}
+
+// esBulkItem is a document of a bulk request: the action metadata line and the document source
+type esBulkItem struct {
+	action []byte
+	doc    []byte
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	// each item maps the action name (index) to its result
+	Items []map[string]esBulkItemResult `json:"items"`
+}
+
+type esBulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// StartElasticsearch creates an Elasticsearch exporter. It validates the configuration and
+// verifies that the cluster is reachable with the provided credentials. The metrics are labeled
+// by the provided exporter name.
+func StartElasticsearch(
+	client *http.Client, name string, cfg *ElasticsearchConfig, m *metrics.Metrics,
+) (*Elasticsearch, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q: %w", cfg.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Elasticsearch URL %q: it must be an absolute http(s) URL",
+			cfg.URL)
+	}
+	if err := validateESIndex(cfg.Index); err != nil {
+		return nil, err
+	}
+	if (cfg.Username == "") != (cfg.Password == "") {
+		return nil, errors.New("both the Elasticsearch username and password must be provided")
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid Elasticsearch batch size: %d", cfg.BatchSize)
+	}
+	if cfg.Retries < 0 {
+		return nil, fmt.Errorf("invalid Elasticsearch retries: %d", cfg.Retries)
+	}
+	marshaler, err := NewJSONMarshaler(cfg.FieldCase)
+	if err != nil {
+		return nil, err
+	}
+	es := &Elasticsearch{
+		client:    client,
+		cfg:       *cfg,
+		bulkURL:   strings.TrimSuffix(cfg.URL, "/") + "/_bulk",
+		marshaler: marshaler,
+		indexed: m.NewCounterVec("elasticsearch_indexed_flows_total",
+			"Flows that have been indexed by Elasticsearch", "exporter").WithLabelValues(name),
+		failed: m.NewCounterVec("elasticsearch_failed_flows_total",
+			"Flows that couldn't be indexed by Elasticsearch after all the retries", "exporter").
+			WithLabelValues(name),
+		sleep: time.Sleep,
+	}
+	if err := es.ping(); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+// validateESIndex checks that the index name template produces valid index names
+func validateESIndex(index string) error {
+	if index == "" {
+		return errors.New("missing Elasticsearch index")
+	}
+	name := strings.ReplaceAll(index, esIndexDate, "")
+	if strings.ContainsAny(name, `\/*?"<>| ,#:{}`) || name != strings.ToLower(name) ||
+		strings.HasPrefix(index, "-") || strings.HasPrefix(index, "_") ||
+		strings.HasPrefix(index, "+") {
+		return fmt.Errorf("invalid Elasticsearch index %q. It must be lowercase, not start with"+
+			` -, _ or +, and not contain any of \ / * ? " < > | , # : or spaces. Only the %s`+
+			" placeholder is accepted", index, esIndexDate)
+	}
+	return nil
+}
+
+// ping verifies that the Elasticsearch cluster is reachable and accepts the credentials
+func (es *Elasticsearch) ping() error {
+	req, err := http.NewRequest(http.MethodGet, es.cfg.URL, nil)
+	if err != nil {
+		return fmt.Errorf("creating Elasticsearch request: %w", err)
+	}
+	resp, err := es.do(req)
+	if err != nil {
+		return fmt.Errorf("can't reach Elasticsearch: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("elasticsearch rejected the credentials: %s", resp.Status)
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("elasticsearch returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (es *Elasticsearch) do(req *http.Request) (*http.Response, error) {
+	if es.cfg.Username != "" {
+		req.SetBasicAuth(es.cfg.Username, es.cfg.Password)
+	}
+	return es.client.Do(req)
+}
+
+// Export submits the flows in bulk requests of up to the configured batch size
+func (es *Elasticsearch) Export(records []*flow.Record) error {
+	var errs []string
+	for start := 0; start < len(records); start += es.cfg.BatchSize {
+		end := start + es.cfg.BatchSize
+		if end > len(records) {
+			end = len(records)
+		}
+		items := make([]esBulkItem, 0, end-start)
+		for _, record := range records[start:end] {
+			item, err := es.bulkItem(record)
+			if err != nil {
+				eslog.WithError(err).Debug("can't encode flow. Ignoring")
+				continue
+			}
+			items = append(items, item)
+		}
+		if err := es.bulk(items); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close does nothing, as the exporter doesn't keep any connection open between exports
+func (es *Elasticsearch) Close() error {
+	return nil
+}
+
+// ConcurrentSafe marks the exporter as safe for concurrent use, as it doesn't keep any state
+// between exports
+func (es *Elasticsearch) ConcurrentSafe() {}
+
+func (es *Elasticsearch) bulkItem(record *flow.Record) (esBulkItem, error) {
+	doc, err := es.marshaler.Marshal(toJSONRecord(record, es.cfg.ProtocolNames))
+	if err != nil {
+		return esBulkItem{}, err
+	}
+	index := strings.ReplaceAll(es.cfg.Index, esIndexDate,
+		record.TimeFlowStart.UTC().Format("2006.01.02"))
+	action, err := json.Marshal(map[string]map[string]string{"index": {"_index": index}})
+	if err != nil {
+		return esBulkItem{}, err
+	}
+	return esBulkItem{action: action, doc: doc}, nil
+}
+
+// bulk submits the items, retrying with backoff the ones that failed with a transient error
+func (es *Elasticsearch) bulk(items []esBulkItem) error {
+	pending := items
+	var errs []string
+	var err error
+	for attempt := 0; len(pending) > 0; attempt++ {
+		var rejected error
+		pending, rejected, err = es.submit(pending)
+		if rejected != nil {
+			errs = append(errs, rejected.Error())
+		}
+		if len(pending) == 0 || attempt >= es.cfg.Retries {
+			break
+		}
+		wait := es.cfg.RetryBackoff << attempt
+		eslog.WithError(err).Debugf("couldn't index %d flows. Retrying in %s", len(pending), wait)
+		es.sleep(wait)
+	}
+	if len(pending) > 0 {
+		es.failed.Add(float64(len(pending)))
+		errs = append(errs, fmt.Sprintf("can't index %d flows into Elasticsearch after %d retries: %s",
+			len(pending), es.cfg.Retries, err))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// submit sends a bulk request with the provided items, and returns the items that must be
+// retried, with the transient error that made them fail. Items that fail with a non-transient
+// error (e.g. a mapping error) are discarded, and reported in the returned rejected error.
+func (es *Elasticsearch) submit(items []esBulkItem) (retry []esBulkItem, rejected, err error) {
+	body := bytes.Buffer{}
+	for _, item := range items {
+		body.Write(item.action)
+		body.WriteByte('\n')
+		body.Write(item.doc)
+		body.WriteByte('\n')
+	}
+	req, err := http.NewRequest(http.MethodPost, es.bulkURL, &body)
+	if err != nil {
+		es.failed.Add(float64(len(items)))
+		return nil, fmt.Errorf("creating Elasticsearch bulk request: %w", err), nil
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := es.do(req)
+	if err != nil {
+		return items, nil, fmt.Errorf("submitting Elasticsearch bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("elasticsearch returned %s: %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+		if esTransientStatus(resp.StatusCode) {
+			return items, nil, err
+		}
+		es.failed.Add(float64(len(items)))
+		return nil, err, nil
+	}
+	result := esBulkResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return items, nil, fmt.Errorf("decoding Elasticsearch bulk response: %w", err)
+	}
+	if !result.Errors {
+		es.indexed.Add(float64(len(items)))
+		return nil, nil, nil
+	}
+	if len(result.Items) != len(items) {
+		return items, nil, fmt.Errorf("elasticsearch returned %d results for %d bulk items",
+			len(result.Items), len(items))
+	}
+	var transientErr, rejectedErr json.RawMessage
+	dropped := 0
+	for i, actions := range result.Items {
+		for _, r := range actions {
+			switch {
+			case r.Status/100 == 2:
+				es.indexed.Inc()
+			case esTransientStatus(r.Status):
+				retry = append(retry, items[i])
+				transientErr = r.Error
+			default:
+				dropped++
+				rejectedErr = r.Error
+			}
+		}
+	}
+	if dropped > 0 {
+		es.failed.Add(float64(dropped))
+		rejected = fmt.Errorf("elasticsearch rejected %d flows: %s", dropped, string(rejectedErr))
+	}
+	if len(retry) > 0 {
+		err = fmt.Errorf("elasticsearch failed to index %d flows: %s", len(retry), string(transientErr))
+	}
+	return retry, rejected, err
+}
+
+// esTransientStatus returns whether the request or item failed with an HTTP status that may
+// succeed if it is retried later
+func esTransientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status/100 == 5
+}
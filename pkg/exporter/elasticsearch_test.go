@@ -0,0 +1,194 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// esTestServer is a fake Elasticsearch cluster that records the bodies of the bulk requests and
+// answers them with the queued responses
+type esTestServer struct {
+	*httptest.Server
+	bulks     [][]byte
+	responses []string
+}
+
+func newESTestServer(t *testing.T, responses ...string) *esTestServer {
+	es := &esTestServer{responses: responses}
+	es.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "elastic" || pass != "changeme" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch req.URL.Path {
+		case "/":
+			_, _ = rw.Write([]byte(`{"version":{"number":"8.6.0"}}`))
+		case "/_bulk":
+			assert.Equal(t, http.MethodPost, req.Method)
+			assert.Equal(t, "application/x-ndjson", req.Header.Get("Content-Type"))
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			es.bulks = append(es.bulks, body)
+			require.NotEmpty(t, es.responses, "unexpected bulk request")
+			_, _ = rw.Write([]byte(es.responses[0]))
+			es.responses = es.responses[1:]
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return es
+}
+
+func testESConfig(url string) *ElasticsearchConfig {
+	return &ElasticsearchConfig{
+		URL:          url,
+		Index:        "netflow-{date}",
+		Username:     "elastic",
+		Password:     "changeme",
+		BatchSize:    10,
+		Retries:      2,
+		RetryBackoff: time.Second,
+	}
+}
+
+// bulkLines parses the NDJSON body of a bulk request
+func bulkLines(t *testing.T, body []byte) []map[string]interface{} {
+	t.Helper()
+	require.True(t, bytes.HasSuffix(body, []byte("\n")), "bulk body must end with a newline")
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func esRecord(iface string, start time.Time) *flow.Record {
+	r := &flow.Record{Interface: iface, TimeFlowStart: start, TimeFlowEnd: start.Add(time.Second)}
+	r.Metrics.Bytes = 123
+	return r
+}
+
+func TestElasticsearch_Bulk(t *testing.T) {
+	server := newESTestServer(t, `{"took":3,"errors":false,"items":[]}`)
+	defer server.Close()
+	es, err := StartElasticsearch(server.Client(), "elasticsearch", testESConfig(server.URL), metrics.NoOp())
+	require.NoError(t, err)
+
+	// WHEN flows from different days are exported
+	require.NoError(t, es.Export([]*flow.Record{
+		esRecord("eth0", time.Date(2023, 3, 1, 23, 59, 0, 0, time.UTC)),
+		esRecord("eth1", time.Date(2023, 3, 2, 0, 1, 0, 0, time.UTC)),
+	}))
+
+	// THEN a well-formed bulk request is submitted, indexing each flow in its daily index
+	require.Len(t, server.bulks, 1)
+	lines := bulkLines(t, server.bulks[0])
+	require.Len(t, lines, 4)
+	assert.Equal(t, map[string]interface{}{"index": map[string]interface{}{"_index": "netflow-2023.03.01"}},
+		lines[0])
+	assert.Equal(t, "eth0", lines[1]["Interface"])
+	require.IsType(t, map[string]interface{}{}, lines[1]["Metrics"])
+	assert.EqualValues(t, 123, lines[1]["Metrics"].(map[string]interface{})["Bytes"])
+	assert.EqualValues(t, time.Date(2023, 3, 1, 23, 59, 0, 0, time.UTC).UnixMilli(),
+		lines[1]["TimeFlowStartMs"])
+	assert.Equal(t, map[string]interface{}{"index": map[string]interface{}{"_index": "netflow-2023.03.02"}},
+		lines[2])
+	assert.Equal(t, "eth1", lines[3]["Interface"])
+	assert.EqualValues(t, 2, counterValue(t, es.indexed))
+}
+
+func TestElasticsearch_RetryFailedItems(t *testing.T) {
+	// GIVEN an Elasticsearch cluster that rejects some documents of a bulk request
+	server := newESTestServer(t,
+		`{"errors":true,"items":[
+			{"index":{"status":201}},
+			{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},
+			{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`,
+		`{"errors":false,"items":[{"index":{"status":201}}]}`)
+	defer server.Close()
+	es, err := StartElasticsearch(server.Client(), "elasticsearch", testESConfig(server.URL), metrics.NoOp())
+	require.NoError(t, err)
+	var waits []time.Duration
+	es.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	start := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	err = es.Export([]*flow.Record{
+		esRecord("eth0", start), esRecord("eth1", start), esRecord("eth2", start),
+	})
+	// THEN the documents rejected with a permanent error are reported and discarded
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "mapper_parsing_exception")
+
+	// AND only the documents rejected with a transient error are submitted again
+	require.Len(t, server.bulks, 2)
+	retried := bulkLines(t, server.bulks[1])
+	require.Len(t, retried, 2)
+	assert.Equal(t, "eth1", retried[1]["Interface"])
+	assert.Equal(t, []time.Duration{time.Second}, waits)
+	assert.EqualValues(t, 2, counterValue(t, es.indexed))
+	assert.EqualValues(t, 1, counterValue(t, es.failed))
+}
+
+func TestElasticsearch_Batching(t *testing.T) {
+	server := newESTestServer(t, `{"errors":false}`, `{"errors":false}`)
+	defer server.Close()
+	cfg := testESConfig(server.URL)
+	cfg.BatchSize = 2
+	cfg.Index = "flows"
+	es, err := StartElasticsearch(server.Client(), "elasticsearch", cfg, metrics.NoOp())
+	require.NoError(t, err)
+
+	start := time.Date(2023, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, es.Export([]*flow.Record{
+		esRecord("eth0", start), esRecord("eth1", start), esRecord("eth2", start),
+	}))
+	require.Len(t, server.bulks, 2)
+	assert.Len(t, bulkLines(t, server.bulks[0]), 4)
+	last := bulkLines(t, server.bulks[1])
+	require.Len(t, last, 2)
+	assert.Equal(t, map[string]interface{}{"index": map[string]interface{}{"_index": "flows"}},
+		last[0])
+}
+
+func TestStartElasticsearch_Validation(t *testing.T) {
+	server := newESTestServer(t)
+	defer server.Close()
+
+	cfg := testESConfig(server.URL)
+	cfg.Password = "wrong"
+	_, err := StartElasticsearch(server.Client(), "elasticsearch", cfg, metrics.NoOp())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "credentials")
+
+	cfg = testESConfig(server.URL)
+	cfg.Password = ""
+	_, err = StartElasticsearch(server.Client(), "elasticsearch", cfg, metrics.NoOp())
+	assert.Error(t, err)
+
+	for _, url := range []string{"", "elasticsearch:9200", "ftp://elasticsearch:9200"} {
+		_, err = StartElasticsearch(server.Client(), "elasticsearch", testESConfig(url), metrics.NoOp())
+		assert.Error(t, err, url)
+	}
+
+	for _, index := range []string{"", "Flows", "_flows", "flows-{hour}", "flows/x"} {
+		cfg = testESConfig(server.URL)
+		cfg.Index = index
+		_, err = StartElasticsearch(server.Client(), "elasticsearch", cfg, metrics.NoOp())
+		assert.Error(t, err, index)
+	}
+}
@@ -0,0 +1,104 @@
+package exporter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+var elog = logrus.WithField("component", "exporter.Exporter")
+
+// enterpriseID is the private enterprise number of the agent-specific fields of the standard
+// formats that support vendor extensions (IPFIX, sFlow). 32473 is the private enterprise number
+// reserved for documentation purposes (RFC 5612), as in the syslog structured data.
+const enterpriseID = 32473
+
+// Exporter submits the flows to an external sink. Export is invoked sequentially from the
+// export stage, so implementations don't need to be thread-safe, unless they implement
+// ConcurrentExporter.
+type Exporter interface {
+	// Export submits a batch of flows. A returned error is logged and the batch is discarded.
+	Export(records []*flow.Record) error
+	// Close releases the resources of the exporter. It is invoked after the last Export
+	// invocation, when the agent stops.
+	Close() error
+}
+
+// ConcurrentExporter is an Exporter whose Export method can be safely invoked from multiple
+// goroutines at the same time (e.g. because it does not keep any state between invocations)
+type ConcurrentExporter interface {
+	Exporter
+	// ConcurrentSafe is only used to mark the Exporter as safe for concurrent use
+	ConcurrentSafe()
+}
+
+// ContextExporter is an Exporter that can abort the submission of a batch of flows when the
+// provided context is done (e.g. because the submission exceeded its send timeout)
+type ContextExporter interface {
+	Exporter
+	// ExportContext works as Export, but it returns as soon as possible when ctx is done
+	ExportContext(ctx context.Context, records []*flow.Record) error
+}
+
+// withoutHeartbeats returns the records that are actual flows, for the exporters that account
+// the flows or can't represent the heartbeats. The input slice is returned if it has no heartbeats.
+func withoutHeartbeats(records []*flow.Record) []*flow.Record {
+	for i, record := range records {
+		if record.IsHeartbeat() {
+			flows := append(make([]*flow.Record, 0, len(records)-1), records[:i]...)
+			for _, r := range records[i+1:] {
+				if !r.IsHeartbeat() {
+					flows = append(flows, r)
+				}
+			}
+			return flows
+		}
+	}
+	return records
+}
+
+// Terminal returns a function that can be used as the terminal node of the flows' pipeline. It
+// submits each batch of flows from the input channel to the provided Exporter, and closes the
+// Exporter when the input channel is closed.
+func Terminal(e Exporter) func(input <-chan []*flow.Record) {
+	return func(input <-chan []*flow.Record) {
+		for records := range input {
+			if err := e.Export(records); err != nil {
+				elog.WithError(err).Error("can't export flows")
+			}
+		}
+		if err := e.Close(); err != nil {
+			elog.WithError(err).Warn("couldn't close exporter")
+		}
+	}
+}
+
+// ConcurrentTerminal works as Terminal, but dispatches the batches of flows to a pool of
+// workers that invoke the Export method concurrently. Each batch is exported once, by one
+// worker. The Exporter is closed after all the workers finished exporting their batches.
+func ConcurrentTerminal(e ConcurrentExporter, workers int) func(input <-chan []*flow.Record) {
+	if workers <= 1 {
+		return Terminal(e)
+	}
+	return func(input <-chan []*flow.Record) {
+		wg := sync.WaitGroup{}
+		wg.Add(workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				defer wg.Done()
+				for records := range input {
+					if err := e.Export(records); err != nil {
+						elog.WithError(err).Error("can't export flows")
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if err := e.Close(); err != nil {
+			elog.WithError(err).Warn("couldn't close exporter")
+		}
+	}
+}
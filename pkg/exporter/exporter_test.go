@@ -0,0 +1,86 @@
+package exporter
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+// concurrentFake counts the exported flows, simulating some I/O latency on each export
+type concurrentFake struct {
+	latency  time.Duration
+	mt       sync.Mutex
+	exported map[uint16]int
+	closed   bool
+}
+
+func (c *concurrentFake) Export(records []*flow.Record) error {
+	time.Sleep(c.latency)
+	c.mt.Lock()
+	defer c.mt.Unlock()
+	if c.closed {
+		return fmt.Errorf("exporter is closed")
+	}
+	for _, r := range records {
+		c.exported[r.Id.SrcPort]++
+	}
+	return nil
+}
+
+func (c *concurrentFake) Close() error {
+	c.mt.Lock()
+	defer c.mt.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *concurrentFake) ConcurrentSafe() {}
+
+func TestConcurrentTerminal(t *testing.T) {
+	for _, workers := range []int{0, 1, 4} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			fake := &concurrentFake{latency: time.Millisecond, exported: map[uint16]int{}}
+			input := make(chan []*flow.Record, 100)
+			for i := 0; i < 100; i++ {
+				r := &flow.Record{}
+				r.Id.SrcPort = uint16(i)
+				input <- []*flow.Record{r}
+			}
+			close(input)
+			ConcurrentTerminal(fake, workers)(input)
+
+			// each batch is exported exactly once, and the exporter is closed after all the exports
+			assert.Len(t, fake.exported, 100)
+			for port, times := range fake.exported {
+				assert.Equalf(t, 1, times, "flow %d", port)
+			}
+			assert.True(t, fake.closed)
+		})
+	}
+}
+
+func BenchmarkConcurrentTerminal(b *testing.B) {
+	batch := make([]*flow.Record, 100)
+	for i := range batch {
+		batch[i] = &flow.Record{}
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("%d workers", workers), func(b *testing.B) {
+			fake := &concurrentFake{latency: 100 * time.Microsecond, exported: map[uint16]int{}}
+			input := make(chan []*flow.Record, workers)
+			b.ResetTimer()
+			go func() {
+				for i := 0; i < b.N; i++ {
+					input <- batch
+				}
+				close(input)
+			}()
+			ConcurrentTerminal(fake, workers)(input)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+var fflog = logrus.WithField("component", "exporter/FIFOJSON")
+
+// fifoWriteTimeout bounds the time a write can wait for a stalled reader
+const fifoWriteTimeout = 5 * time.Second
+
+// FIFOJSON writes the flows, as one JSON record per line (or as consecutive MessagePack maps, if
+// configured), to a named pipe (FIFO), so they can be consumed by shell pipelines (e.g.
+// `cat flows.fifo | jq ...`). Opening a FIFO for writing would block until a reader opens it,
+// so the FIFO is opened in non-blocking mode: while no reader is attached, or after the reader
+// detaches, the flows are discarded and the FIFO is reopened for the next flows.
+// A write that can't complete before a deadline, as the reader stopped reading, detaches the
+// reader too, so the partially written line is the last one it receives.
+type FIFOJSON struct {
+	path         string
+	encoder      *RecordEncoder
+	bufLen       int
+	writeTimeout time.Duration
+	// file is the FIFO opened for writing, or nil if no reader is attached
+	file *os.File
+}
+
+// StartFIFO creates an exporter that writes to the FIFO in the provided path. If the path
+// doesn't exist, the FIFO is created. If it exists, it must be a FIFO.
+// The bufLen argument is the number of flow batches that can be buffered while the reader is
+// slow. When this buffer is full, the incoming flow batches are dropped.
+// The encoding, fieldCase and protocolNames arguments specify the serialization of the flows
+// (see NewRecordEncoder).
+func StartFIFO(
+	path string, bufLen int, encoding, fieldCase string, protocolNames bool,
+) (*FIFOJSON, error) {
+	if path == "" {
+		return nil, errors.New("missing FIFO path")
+	}
+	encoder, err := NewRecordEncoder(encoding, fieldCase, protocolNames)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return nil, fmt.Errorf("%s already exists and is not a FIFO", path)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		dir := filepath.Dir(path)
+		if err := unix.Access(dir, unix.W_OK); err != nil {
+			return nil, fmt.Errorf("directory %s is not writable: %w", dir, err)
+		}
+		if err := unix.Mkfifo(path, 0o600); err != nil {
+			return nil, fmt.Errorf("creating FIFO: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("checking FIFO path: %w", err)
+	}
+	if bufLen < 1 {
+		bufLen = 1
+	}
+	return &FIFOJSON{
+		path: path, encoder: encoder, bufLen: bufLen, writeTimeout: fifoWriteTimeout,
+	}, nil
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, encodes them and writes them
+// to the FIFO. Like the rest of the pipeline, the flows are forwarded through a CapacityLimiter,
+// so they are dropped instead of blocking the agent when the reader is slower than the flows'
+// production.
+func (fe *FIFOJSON) ExportFlows(input <-chan []*flow.Record) {
+	fflog.WithField("path", fe.path).Info("starting FIFO exporter")
+	pending := make(chan []*flow.Record, fe.bufLen)
+	go func() {
+		flow.NewCapacityLimiter(context.Background(),
+			"the FIFO reader is slower than the flows' production").Limit(input, pending)
+		close(pending)
+	}()
+	for records := range pending {
+		fe.write(records)
+	}
+	if fe.file != nil {
+		_ = fe.file.Close()
+	}
+}
+
+func (fe *FIFOJSON) write(records []*flow.Record) {
+	if fe.file == nil {
+		// opening with O_NONBLOCK fails with ENXIO instead of blocking if there is no reader.
+		// The writes don't fail with EAGAIN, as the Go runtime polls the file until it is
+		// writable
+		file, err := os.OpenFile(fe.path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		if errors.Is(err, syscall.ENXIO) {
+			fflog.WithField("flows", len(records)).Debug("no FIFO reader attached. Discarding flows")
+			return
+		}
+		if err != nil {
+			fflog.WithError(err).Warn("can't open FIFO. Discarding flows")
+			return
+		}
+		fflog.Info("FIFO reader attached")
+		fe.file = file
+	}
+	err := fe.file.SetWriteDeadline(time.Now().Add(fe.writeTimeout))
+	if err == nil {
+		_, err = fe.file.Write(encodeRecords(fe.encoder, records, fflog))
+	}
+	if err != nil {
+		// EPIPE if the reader detached. The Go runtime ignores the SIGPIPE signal for files
+		// other than the standard output and error. Closing the FIFO after a timeout makes the
+		// reader get an EOF after the partially written line
+		fflog.WithError(err).Warn("can't write flows. Waiting for a reader to attach")
+		_ = fe.file.Close()
+		fe.file = nil
+	}
+}
@@ -0,0 +1,183 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	test2 "github.com/mariomac/guara/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestFIFO_Reattach(t *testing.T) {
+	fifo := path.Join(t.TempDir(), "flows.fifo")
+	fe, err := StartFIFO(fifo, 10, EncodingJSON, FieldCaseSnake, false)
+	require.NoError(t, err)
+	info, err := os.Stat(fifo)
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode()&os.ModeNamedPipe)
+
+	record := func(srcPort uint16) []*flow.Record {
+		r := &flow.Record{}
+		r.Id.SrcPort = srcPort
+		return []*flow.Record{r}
+	}
+	// WHEN no reader is attached, the flows are discarded without blocking the exporter
+	fe.write(record(1))
+	assert.Nil(t, fe.file)
+
+	input := make(chan []*flow.Record, 10)
+	go fe.ExportFlows(input)
+	defer close(input)
+
+	// WHEN a reader is attached
+	lines := attachFIFOReader(t, fifo)
+	// THEN the flows are written as JSON lines
+	input <- record(2)
+	assert.EqualValues(t, 2, readFIFOSrcPort(t, lines))
+
+	// WHEN the reader detaches and a new reader attaches
+	close(lines.done)
+	lines = attachFIFOReader(t, fifo)
+	defer close(lines.done)
+
+	// THEN the flows are written to the new reader, after the exporter recovers from the
+	// broken pipe
+	test2.Eventually(t, unixTestTimeout, func(t require.TestingT) {
+		input <- record(3)
+		select {
+		case line := <-lines.lines:
+			var r struct {
+				ID struct {
+					SrcPort uint16 `json:"src_port"`
+				} `json:"id"`
+			}
+			require.NoError(t, json.Unmarshal(line, &r))
+			require.EqualValues(t, 3, r.ID.SrcPort)
+		case <-time.After(50 * time.Millisecond):
+			require.Fail(t, "no flows received yet")
+		}
+	}, test2.Interval(10*time.Millisecond))
+}
+
+func TestFIFO_ReaderDetached(t *testing.T) {
+	fifo := path.Join(t.TempDir(), "flows.fifo")
+	fe, err := StartFIFO(fifo, 10, EncodingJSON, FieldCasePascal, false)
+	require.NoError(t, err)
+
+	reader, err := os.OpenFile(fifo, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	require.NoError(t, err)
+	fe.write([]*flow.Record{{Interface: "eth0"}})
+	require.NotNil(t, fe.file)
+
+	// WHEN the reader detaches
+	require.NoError(t, reader.Close())
+	// THEN the broken pipe is handled by closing the FIFO until a new reader attaches
+	fe.write([]*flow.Record{{Interface: "eth0"}})
+	assert.Nil(t, fe.file)
+}
+
+func TestFIFO_InvalidPath(t *testing.T) {
+	_, err := StartFIFO("", 10, EncodingJSON, FieldCasePascal, false)
+	assert.Error(t, err)
+
+	_, err = StartFIFO(path.Join(t.TempDir(), "missing", "flows.fifo"), 10, EncodingJSON, FieldCasePascal,
+		false)
+	assert.Error(t, err)
+
+	// a path that exists but is not a FIFO is not overridden
+	file := path.Join(t.TempDir(), "flows.json")
+	require.NoError(t, os.WriteFile(file, []byte("{}"), 0o644))
+	_, err = StartFIFO(file, 10, EncodingJSON, FieldCasePascal, false)
+	assert.Error(t, err)
+
+	// an existing FIFO is reused
+	fifo := path.Join(t.TempDir(), "flows.fifo")
+	require.NoError(t, unix.Mkfifo(fifo, 0o600))
+	_, err = StartFIFO(fifo, 10, EncodingJSON, FieldCasePascal, false)
+	assert.NoError(t, err)
+}
+
+type fifoReader struct {
+	lines chan []byte
+	done  chan struct{}
+}
+
+// attachFIFOReader opens the FIFO for reading and forwards the read lines until the done
+// channel is closed
+func attachFIFOReader(t *testing.T, fifo string) *fifoReader {
+	// opening in non-blocking mode, so the FIFO has a reader before the exporter opens it
+	file, err := os.OpenFile(fifo, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	require.NoError(t, err)
+	fr := &fifoReader{lines: make(chan []byte, 10), done: make(chan struct{})}
+	go func() {
+		<-fr.done
+		_ = file.Close()
+	}()
+	go func() {
+		lines := bufio.NewReader(file)
+		for {
+			line, err := lines.ReadBytes('\n')
+			if err != nil {
+				select {
+				case <-fr.done:
+					return
+				default:
+					// no writer attached yet
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+			}
+			fr.lines <- line
+		}
+	}()
+	return fr
+}
+
+func readFIFOSrcPort(t *testing.T, fr *fifoReader) uint16 {
+	t.Helper()
+	select {
+	case line := <-fr.lines:
+		var record struct {
+			ID struct {
+				SrcPort uint16 `json:"src_port"`
+			} `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(line, &record), string(line))
+		return record.ID.SrcPort
+	case <-time.After(unixTestTimeout):
+		require.Fail(t, "timeout while waiting for flows")
+		return 0
+	}
+}
+
+func TestFIFO_StalledReader(t *testing.T) {
+	fifo := path.Join(t.TempDir(), "flows.fifo")
+	fe, err := StartFIFO(fifo, 10, EncodingJSON, FieldCasePascal, false)
+	require.NoError(t, err)
+	fe.writeTimeout = 50 * time.Millisecond
+
+	// GIVEN a reader that is attached but doesn't read
+	reader, err := os.OpenFile(fifo, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	// WHEN more flows are written than the pipe can buffer
+	records := make([]*flow.Record, 0, 2000)
+	for i := 0; i < cap(records); i++ {
+		records = append(records, &flow.Record{Interface: "eth0"})
+	}
+	start := time.Now()
+	fe.write(records)
+
+	// THEN the write gives up after the timeout and the FIFO is closed
+	assert.Less(t, time.Since(start), unixTestTimeout)
+	assert.Nil(t, fe.file)
+}
@@ -0,0 +1,242 @@
+package exporter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+var flog = logrus.WithField("component", "exporter/FileJSON")
+
+// FileJSON exports flows into a file, as one JSON record per line (or as consecutive MessagePack
+// maps, if configured). New records are appended to the file if it already exists.
+// The file can be continuously compressed (gzip or zstd). Each execution of the agent appends
+// a new compressed stream (a gzip member or a zstd frame), so the file can be decompressed as a
+// whole by the standard tools.
+// Optionally, it skips writing the records that are identical to any of the last
+// written records. This avoids duplicates when the agent restarts and overlapping flows are
+// exported again.
+type FileJSON struct {
+	file    sinkWriter
+	encoder *RecordEncoder
+	dedup   *hashRing
+}
+
+// StartFileJSON opens (or creates) the file in the provided path for appending flows to it.
+// If dedupWindow is higher than zero, the exporter will remember the content hash of the last
+// dedupWindow records and skip writing any identical record. The dedup window is initially
+// populated from the last records of the existing file.
+// The encoding, fieldCase and protocolNames arguments specify the serialization of the flows
+// (see NewRecordEncoder), and the compression argument the compression of the file: none, gzip
+// or zstd.
+func StartFileJSON(
+	path string, dedupWindow int, encoding, fieldCase string, protocolNames bool,
+	compression string,
+) (*FileJSON, error) {
+	if path == "" {
+		return nil, errors.New("missing file path")
+	}
+	encoder, err := NewRecordEncoder(encoding, fieldCase, protocolNames)
+	if err != nil {
+		return nil, err
+	}
+	fe := &FileJSON{encoder: encoder}
+	if dedupWindow > 0 {
+		fe.dedup = newHashRing(dedupWindow)
+		load := fe.dedup.load
+		if encoder.msgpack {
+			load = fe.dedup.loadMsgpack
+		}
+		if err := load(path, compression); err != nil {
+			return nil, fmt.Errorf("reading previous records from %s: %w", path, err)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening flows file: %w", err)
+	}
+	if fe.file, err = newSinkWriter(file, compression); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return fe, nil
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, encodes them and appends
+// them to the file.
+func (fe *FileJSON) ExportFlows(input <-chan []*flow.Record) {
+	flog.Info("starting file exporter")
+	for records := range input {
+		fe.write(records)
+	}
+	if err := fe.file.Close(); err != nil {
+		flog.WithError(err).Warn("couldn't close flows file")
+	}
+}
+
+func (fe *FileJSON) write(records []*flow.Record) {
+	buf := bufio.NewWriter(fe.file)
+	skipped := 0
+	for _, record := range records {
+		encoded, err := fe.encoder.Encode(record)
+		if err != nil {
+			flog.WithError(err).Debug("can't encode record. Ignoring")
+			continue
+		}
+		if fe.dedup != nil && !fe.dedup.add(encoded) {
+			skipped++
+			continue
+		}
+		fe.encoder.writeDelimited(buf, encoded)
+	}
+	if skipped > 0 {
+		flog.Debugf("skipped %d duplicate records", skipped)
+	}
+	if err := buf.Flush(); err != nil {
+		flog.WithError(err).Error("can't write records into file")
+	}
+	// make the compressed records readable without waiting for the file to be closed
+	if err := fe.file.Flush(); err != nil {
+		flog.WithError(err).Error("can't flush compressed records into file")
+	}
+}
+
+// toJSONRecord wraps the record with the fields that are specific to the JSON-based exporters.
+// If protocolNames is true, the name of the transport protocol is added.
+func toJSONRecord(record *flow.Record, protocolNames bool) *JSONRecord {
+	jr := &JSONRecord{
+		Record:          record,
+		TimeFlowStart:   record.TimeFlowStart.Unix(),
+		TimeFlowEnd:     record.TimeFlowEnd.Unix(),
+		TimeFlowStartMs: record.TimeFlowStart.UnixMilli(),
+		TimeFlowEndMs:   record.TimeFlowEnd.UnixMilli(),
+	}
+	if protocolNames {
+		jr.Protocol = protocolName(record.Id.TransportProtocol)
+	}
+	return jr
+}
+
+// hashRing remembers the content hashes of the last N records, using bounded memory
+type hashRing struct {
+	hashes []uint64
+	next   int
+	full   bool
+	// number of occurrences of each hash in the ring
+	present map[uint64]int
+}
+
+func newHashRing(size int) *hashRing {
+	return &hashRing{
+		hashes:  make([]uint64, size),
+		present: make(map[uint64]int, size),
+	}
+}
+
+// add the hash of the provided content to the ring, removing the oldest hash if the ring
+// is full. It returns false, without adding it, if the content was already in the ring.
+func (r *hashRing) add(content []byte) bool {
+	h := fnv.New64a()
+	_, _ = h.Write(content)
+	sum := h.Sum64()
+	if r.present[sum] > 0 {
+		return false
+	}
+	if r.full {
+		oldest := r.hashes[r.next]
+		if r.present[oldest] <= 1 {
+			delete(r.present, oldest)
+		} else {
+			r.present[oldest]--
+		}
+	}
+	r.hashes[r.next] = sum
+	r.present[sum]++
+	r.next++
+	if r.next == len(r.hashes) {
+		r.next = 0
+		r.full = true
+	}
+	return true
+}
+
+// load the hashes of the last records of the provided file, if it exists
+func (r *hashRing) load(path, compression string) error {
+	file, err := openRecordsFile(path, compression)
+	if file == nil || err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	// flow records might be larger than the default token size
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			r.add(scanner.Bytes())
+		}
+	}
+	if errors.Is(scanner.Err(), io.ErrUnexpectedEOF) {
+		flog.Warn("ignoring truncated compressed stream at the end of the flows file")
+		return nil
+	}
+	return scanner.Err()
+}
+
+// loadMsgpack is like load, for files of MessagePack-encoded records. If the file ends with an
+// incomplete record (e.g. the agent was killed while writing it), it is ignored.
+func (r *hashRing) loadMsgpack(path, compression string) error {
+	file, err := openRecordsFile(path, compression)
+	if file == nil || err != nil {
+		return err
+	}
+	defer file.Close()
+	records := newMsgpackRecords(file)
+	for {
+		raw, err := records.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			flog.Warn("ignoring incomplete record at the end of the flows file")
+			return nil
+		case err != nil:
+			return err
+		}
+		r.add(raw)
+	}
+}
+
+// openRecordsFile opens the file for reading its records, decompressing it if needed. It
+// returns nil, without error, if the file doesn't exist.
+func openRecordsFile(path, compression string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	reader, err := newSinkReader(file, compression)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &recordsFile{ReadCloser: reader, file: file}, nil
+}
+
+// recordsFile closes both the decompressed reader and the underlying file
+type recordsFile struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (rf *recordsFile) Close() error {
+	_ = rf.ReadCloser.Close()
+	return rf.file.Close()
+}
@@ -0,0 +1,93 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestFileJSON_Dedup(t *testing.T) {
+	file := path.Join(t.TempDir(), "flows.json")
+	start := time.UnixMilli(1_600_000_000_000)
+	record := func(srcPort uint16) *flow.Record {
+		r := &flow.Record{TimeFlowStart: start, TimeFlowEnd: start.Add(time.Second)}
+		r.Id.SrcPort = srcPort
+		r.Metrics.Bytes = 123
+		return r
+	}
+
+	// GIVEN a file exporter with deduplication
+	fe, err := StartFileJSON(file, 2, EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	require.NoError(t, err)
+
+	// WHEN it receives duplicate records
+	input := make(chan []*flow.Record, 10)
+	input <- []*flow.Record{record(1), record(2), record(1)}
+	input <- []*flow.Record{record(2), record(3)}
+	close(input)
+	fe.ExportFlows(input)
+
+	// THEN the duplicates are written once
+	assert.Equal(t, []uint16{1, 2, 3}, readSrcPorts(t, file))
+
+	// AND WHEN the agent restarts and exports again the last records
+	fe, err = StartFileJSON(file, 2, EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	require.NoError(t, err)
+	input = make(chan []*flow.Record, 10)
+	input <- []*flow.Record{record(3), record(2), record(4)}
+	close(input)
+	fe.ExportFlows(input)
+
+	// THEN only the new records are appended
+	assert.Equal(t, []uint16{1, 2, 3, 4}, readSrcPorts(t, file))
+
+	// AND the records older than the dedup window are written again
+	fe, err = StartFileJSON(file, 2, EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	require.NoError(t, err)
+	input = make(chan []*flow.Record, 10)
+	input <- []*flow.Record{record(1)}
+	close(input)
+	fe.ExportFlows(input)
+	assert.Equal(t, []uint16{1, 2, 3, 4, 1}, readSrcPorts(t, file))
+}
+
+func TestFileJSON_NoDedup(t *testing.T) {
+	file := path.Join(t.TempDir(), "flows.json")
+	fe, err := StartFileJSON(file, 0, EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	require.NoError(t, err)
+
+	r := &flow.Record{}
+	r.Id.SrcPort = 1
+	input := make(chan []*flow.Record, 10)
+	input <- []*flow.Record{r, r}
+	close(input)
+	fe.ExportFlows(input)
+
+	assert.Equal(t, []uint16{1, 1}, readSrcPorts(t, file))
+}
+
+func readSrcPorts(t *testing.T, file string) []uint16 {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	var ports []uint16
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record struct {
+			Id struct{ SrcPort uint16 }
+		}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		ports = append(ports, record.Id.SrcPort)
+	}
+	require.NoError(t, scanner.Err())
+	return ports
+}
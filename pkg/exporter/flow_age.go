@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/netobserv/gopipes/pkg/node"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// DefaultFlowAgeMaxInterfaces is the default maximum number of distinct interface label values
+// in the flow age histogram
+const DefaultFlowAgeMaxInterfaces = 100
+
+// otherInterfaces is the interface label value of the flows whose interface exceeds the maximum
+// number of interfaces of the flow age histogram
+const otherInterfaces = "other"
+
+var flowAgeBuckets = []float64{0.001, 0.01, 0.1, 0.5, 1, 2, 5, 10, 30, 60, 120, 300}
+
+// FlowAgeObserver records, for each flow that is exported, its duration (the time between the
+// first and the last packet of the flow) in a Prometheus histogram. It helps tuning the
+// CacheActiveTimeout value.
+type FlowAgeObserver struct {
+	histogram     *prometheus.HistogramVec
+	maxInterfaces int
+	interfaces    map[string]struct{}
+}
+
+// NewFlowAgeObserver creates a FlowAgeObserver. To keep the metric cardinality bounded, the
+// flows from more than maxInterfaces different interfaces are labeled as "other".
+func NewFlowAgeObserver(m *metrics.Metrics, maxInterfaces int) *FlowAgeObserver {
+	return &FlowAgeObserver{
+		histogram: m.NewHistogramVec("exported_flow_age_seconds",
+			"Duration of the exported flows, from their first to their last packet",
+			flowAgeBuckets, "interface"),
+		maxInterfaces: maxInterfaces,
+		interfaces:    map[string]struct{}{},
+	}
+}
+
+// Instrument wraps the provided exporter to observe the age of the flows before they are
+// submitted to it.
+func (o *FlowAgeObserver) Instrument(
+	export node.TerminalFunc[[]*flow.Record],
+) node.TerminalFunc[[]*flow.Record] {
+	return func(in <-chan []*flow.Record) {
+		observed := make(chan []*flow.Record, cap(in))
+		go func() {
+			defer close(observed)
+			for records := range in {
+				o.observe(records)
+				observed <- records
+			}
+		}()
+		export(observed)
+	}
+}
+
+func (o *FlowAgeObserver) observe(records []*flow.Record) {
+	for _, record := range records {
+		if record.IsHeartbeat() {
+			continue
+		}
+		age := time.Duration(0)
+		if record.Metrics.EndMonoTimeTs > record.Metrics.StartMonoTimeTs {
+			age = time.Duration(record.Metrics.EndMonoTimeTs - record.Metrics.StartMonoTimeTs)
+		}
+		o.histogram.WithLabelValues(o.interfaceLabel(record.Interface)).Observe(age.Seconds())
+	}
+}
+
+func (o *FlowAgeObserver) interfaceLabel(iface string) string {
+	if _, ok := o.interfaces[iface]; ok {
+		return iface
+	}
+	if len(o.interfaces) >= o.maxInterfaces {
+		return otherInterfaces
+	}
+	o.interfaces[iface] = struct{}{}
+	return iface
+}
@@ -0,0 +1,66 @@
+package exporter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestFlowAgeObserver(t *testing.T) {
+	m := metrics.NoOp()
+	observer := NewFlowAgeObserver(m, 2)
+
+	var exported [][]*flow.Record
+	export := observer.Instrument(func(in <-chan []*flow.Record) {
+		for records := range in {
+			exported = append(exported, records)
+		}
+	})
+
+	record := func(iface string, age time.Duration) *flow.Record {
+		r := &flow.Record{Interface: iface}
+		r.Metrics.StartMonoTimeTs = 1_000_000_000
+		r.Metrics.EndMonoTimeTs = 1_000_000_000 + uint64(age)
+		return r
+	}
+	in := make(chan []*flow.Record, 10)
+	in <- []*flow.Record{record("eth0", 20*time.Second), record("eth0", 200*time.Millisecond)}
+	in <- []*flow.Record{record("eth1", 2*time.Millisecond), record("eth2", time.Minute),
+		record("eth3", 3*time.Minute)}
+	close(in)
+	export(in)
+
+	// records are forwarded to the exporter
+	require.Len(t, exported, 2)
+	assert.Len(t, exported[0], 2)
+	assert.Len(t, exported[1], 3)
+
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "exported_flow_age_seconds", families[0].GetName())
+	histograms := map[string]struct {
+		count uint64
+		sum   float64
+	}{}
+	for _, metric := range families[0].Metric {
+		require.Len(t, metric.Label, 1)
+		histograms[metric.Label[0].GetValue()] = struct {
+			count uint64
+			sum   float64
+		}{metric.GetHistogram().GetSampleCount(), metric.GetHistogram().GetSampleSum()}
+	}
+	// the number of interface labels is bounded
+	require.Len(t, histograms, 3)
+	assert.EqualValues(t, 2, histograms["eth0"].count)
+	assert.InDelta(t, 20.2, histograms["eth0"].sum, 0.0001)
+	assert.EqualValues(t, 1, histograms["eth1"].count)
+	assert.InDelta(t, 0.002, histograms["eth1"].sum, 0.0001)
+	assert.EqualValues(t, 2, histograms["other"].count)
+	assert.InDelta(t, 240, histograms["other"].sum, 0.0001)
+}
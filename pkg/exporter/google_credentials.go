@@ -0,0 +1,169 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/jwt"
+)
+
+// Google application default credentials: the credentials are looked up, in order, in the file
+// referenced by the GOOGLE_APPLICATION_CREDENTIALS environment variable, in the well-known file
+// that is created by "gcloud auth application-default login", and in the metadata server of the
+// GCP compute instance (which also provides the GKE workload identity).
+// The service account and user credentials are exchanged by the golang.org/x/oauth2 flows. The
+// golang.org/x/oauth2/google package isn't used because it depends on cloud.google.com/go,
+// so only the metadata server client is implemented here.
+
+const (
+	googleCredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+	googleMetadataHost   = "metadata.google.internal"
+	googleMetadataEnv    = "GCE_METADATA_HOST"
+	googleTokenURL       = "https://oauth2.googleapis.com/token"
+)
+
+// googleCredentialsFile is the content of a service account key or of the gcloud user
+// credentials
+type googleCredentialsFile struct {
+	Type string `json:"type"`
+	// service_account fields
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	// authorized_user fields
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type googleTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// GoogleTokenSource returns a token source for the provided OAuth2 scope, from the Google
+// application default credentials. The tokens are requested through the provided HTTP client,
+// and cached until they expire.
+func GoogleTokenSource(client *http.Client, scope string) (oauth2.TokenSource, error) {
+	file := os.Getenv(googleCredentialsEnv)
+	if file == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			wellKnown := filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+			if _, err := os.Stat(wellKnown); err == nil {
+				file = wellKnown
+			}
+		}
+	}
+	if file == "" {
+		host := os.Getenv(googleMetadataEnv)
+		if host == "" {
+			host = googleMetadataHost
+		}
+		return oauth2.ReuseTokenSource(nil, &googleMetadataTokenSource{
+			client: client, host: host, scope: scope,
+		}), nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading Google credentials: %w", err)
+	}
+	ts, err := googleFileTokenSource(client, content, scope)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Google credentials %s: %w", file, err)
+	}
+	return ts, nil
+}
+
+func googleFileTokenSource(client *http.Client, content []byte, scope string) (oauth2.TokenSource, error) {
+	creds := googleCredentialsFile{}
+	if err := json.Unmarshal(content, &creds); err != nil {
+		return nil, err
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, client)
+	switch creds.Type {
+	case "service_account":
+		if block, _ := pem.Decode([]byte(creds.PrivateKey)); block == nil {
+			return nil, errors.New("invalid private_key: no PEM data found")
+		}
+		conf := jwt.Config{
+			Email:        creds.ClientEmail,
+			PrivateKey:   []byte(creds.PrivateKey),
+			PrivateKeyID: creds.PrivateKeyID,
+			Scopes:       []string{scope},
+			TokenURL:     creds.TokenURI,
+		}
+		return conf.TokenSource(ctx), nil
+	case "authorized_user":
+		if creds.RefreshToken == "" {
+			return nil, errors.New("missing refresh_token")
+		}
+		conf := oauth2.Config{
+			ClientID:     creds.ClientID,
+			ClientSecret: creds.ClientSecret,
+			Endpoint:     oauth2.Endpoint{TokenURL: creds.TokenURI, AuthStyle: oauth2.AuthStyleInParams},
+			Scopes:       []string{scope},
+		}
+		return conf.TokenSource(ctx, &oauth2.Token{RefreshToken: creds.RefreshToken}), nil
+	}
+	return nil, fmt.Errorf("unsupported credentials type %q", creds.Type)
+}
+
+// googleMetadataTokenSource requests the access token of the default service account of the
+// compute instance to the metadata server
+type googleMetadataTokenSource struct {
+	client *http.Client
+	host   string
+	scope  string
+}
+
+func (ts *googleMetadataTokenSource) Token() (*oauth2.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, "http://"+ts.host+
+		"/computeMetadata/v1/instance/service-accounts/default/token?scopes="+
+		url.QueryEscape(ts.scope), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting token to the GCP metadata server: %w", err)
+	}
+	defer resp.Body.Close()
+	return decodeGoogleToken(resp)
+}
+
+func decodeGoogleToken(resp *http.Response) (*oauth2.Token, error) {
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("google access token request failed with %s: %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+	}
+	tr := googleTokenResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("decoding Google access token: %w", err)
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("google returned an empty access token")
+	}
+	token := &oauth2.Token{AccessToken: tr.AccessToken, TokenType: tr.TokenType}
+	if tr.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
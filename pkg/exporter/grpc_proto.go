@@ -2,9 +2,13 @@ package exporter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/grpc"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/utils"
 	"github.com/sirupsen/logrus"
 )
@@ -14,25 +18,87 @@ var glog = logrus.WithField("component", "exporter/GRPCProto")
 // GRPCProto flow exporter. Its ExportFlows method accepts slices of *flow.Record
 // by its input channel, converts them to *pbflow.Records instances, and submits
 // them to the collector.
+// If multiple collectors are provided, they work in active/standby mode: the flows are
+// submitted to the first available collector, in order of priority.
 type GRPCProto struct {
-	hostIP     string
-	hostPort   int
-	clientConn *grpc.ClientConnection
+	// ctx is the parent of the requests to the collectors. It is done when the agent stops
+	ctx     context.Context
+	targets []grpcTarget
+	// sendTimeout bounds each request to a collector, so a collector that doesn't respond is
+	// failed over instead of blocking the export
+	sendTimeout time.Duration
+	// active is the index of the target that is currently receiving the flows
+	active int
+	// failbackInterval is the minimum time the exporter waits before trying again to submit
+	// the flows to a collector with higher priority than the active collector
+	failbackInterval time.Duration
+	lastFailover     time.Time
+	clock            func() time.Time
 	// maxFlowsPerMessage limits the maximum number of flows per GRPC message.
 	// If a message contains more flows than this number, the GRPC message will be split into
 	// multiple messages.
 	maxFlowsPerMessage int
 }
 
-func StartGRPCProto(hostIP string, hostPort int, maxFlowsPerMessage int) (*GRPCProto, error) {
-	clientConn, err := grpc.ConnectClient(hostIP, hostPort)
-	if err != nil {
-		return nil, err
+type grpcTarget struct {
+	socket     string
+	clientConn *grpc.ClientConnection
+}
+
+// GRPCCollector specifies the address of a flows' collector
+type GRPCCollector struct {
+	HostIP   string
+	HostPort int
+}
+
+// defaultGRPCSendTimeout is the timeout of the requests to the collectors when no other
+// timeout is provided
+const defaultGRPCSendTimeout = 10 * time.Second
+
+func StartGRPCProto(
+	ctx context.Context, hostIP string, hostPort int, maxFlowsPerMessage int,
+) (*GRPCProto, error) {
+	return StartGRPCProtoFailover(ctx, []GRPCCollector{{HostIP: hostIP, HostPort: hostPort}},
+		maxFlowsPerMessage, 0, 0)
+}
+
+// StartGRPCProtoFailover starts a GRPCProto exporter that submits the flows to the first
+// available collector from the provided list, which is sorted by priority.
+// If the active collector fails, the exporter fails over to the next collector in the list.
+// After a failover, the exporter will periodically (according to the failbackInterval) check
+// whether any collector with higher priority is available again, and switch back to it.
+// Each request to a collector fails after the sendTimeout (10s if it isn't positive), and all of
+// them are canceled when the provided context is done.
+func StartGRPCProtoFailover(
+	ctx context.Context, collectors []GRPCCollector, maxFlowsPerMessage int,
+	failbackInterval, sendTimeout time.Duration,
+) (*GRPCProto, error) {
+	if len(collectors) == 0 {
+		return nil, errors.New("at least one collector is needed")
+	}
+	if sendTimeout <= 0 {
+		sendTimeout = defaultGRPCSendTimeout
+	}
+	targets := make([]grpcTarget, 0, len(collectors))
+	for _, c := range collectors {
+		clientConn, err := grpc.ConnectClient(c.HostIP, c.HostPort)
+		if err != nil {
+			for _, t := range targets {
+				_ = t.clientConn.Close()
+			}
+			return nil, err
+		}
+		targets = append(targets, grpcTarget{
+			socket:     utils.GetSocket(c.HostIP, c.HostPort),
+			clientConn: clientConn,
+		})
 	}
 	return &GRPCProto{
-		hostIP:             hostIP,
-		hostPort:           hostPort,
-		clientConn:         clientConn,
+		ctx:                ctx,
+		targets:            targets,
+		sendTimeout:        sendTimeout,
+		failbackInterval:   failbackInterval,
+		clock:              time.Now,
 		maxFlowsPerMessage: maxFlowsPerMessage,
 	}, nil
 }
@@ -40,17 +106,69 @@ func StartGRPCProto(hostIP string, hostPort int, maxFlowsPerMessage int) (*GRPCP
 // ExportFlows accepts slices of *flow.Record by its input channel, converts them
 // to *pbflow.Records instances, and submits them to the collector.
 func (g *GRPCProto) ExportFlows(input <-chan []*flow.Record) {
-	socket := utils.GetSocket(g.hostIP, g.hostPort)
-	log := glog.WithField("collector", socket)
-	for inputRecords := range input {
-		for _, pbRecords := range flowsToPB(inputRecords, g.maxFlowsPerMessage) {
-			log.Debugf("sending %d records", len(pbRecords.Entries))
-			if _, err := g.clientConn.Client().Send(context.TODO(), pbRecords); err != nil {
-				log.WithError(err).Error("couldn't send flow records to collector")
-			}
+	Terminal(g)(input)
+}
+
+// Export converts the flows to *pbflow.Records instances, and submits them to the collector.
+func (g *GRPCProto) Export(records []*flow.Record) error {
+	return g.ExportContext(g.ctx, records)
+}
+
+// ExportContext works as Export, but the requests to the collectors are canceled when the
+// provided context is done.
+func (g *GRPCProto) ExportContext(ctx context.Context, records []*flow.Record) error {
+	var err error
+	for _, pbRecords := range flowsToPB(records, g.maxFlowsPerMessage) {
+		if sendErr := g.send(ctx, pbRecords); sendErr != nil {
+			err = sendErr
+		}
+	}
+	return err
+}
+
+// Close the connections to all the collectors
+func (g *GRPCProto) Close() error {
+	var err error
+	for _, t := range g.targets {
+		if closeErr := t.clientConn.Close(); closeErr != nil {
+			glog.WithField("collector", t.socket).WithError(closeErr).
+				Warn("couldn't close flow export client")
+			err = closeErr
 		}
 	}
-	if err := g.clientConn.Close(); err != nil {
-		log.WithError(err).Warn("couldn't close flow export client")
+	return err
+}
+
+// send the records to the active collector. If it fails, it tries the rest of collectors
+// in order of priority.
+func (g *GRPCProto) send(ctx context.Context, pbRecords *pbflow.Records) error {
+	first := g.active
+	if first > 0 && g.clock().Sub(g.lastFailover) >= g.failbackInterval {
+		// give a chance to the collectors with higher priority, which might be healthy again
+		first = 0
+	}
+	var err error
+	for i := first; i < first+len(g.targets); i++ {
+		idx := i % len(g.targets)
+		target := g.targets[idx]
+		log := glog.WithField("collector", target.socket)
+		log.Debugf("sending %d records", len(pbRecords.Entries))
+		sendCtx, cancel := context.WithTimeout(ctx, g.sendTimeout)
+		_, err = target.clientConn.Client().Send(sendCtx, pbRecords)
+		cancel()
+		if err != nil {
+			log.WithError(err).Debug("couldn't send flow records to collector")
+			continue
+		}
+		if idx != 0 && (idx != g.active || first != g.active) {
+			// failed over or failback attempt was unsuccessful: wait again for the next failback
+			g.lastFailover = g.clock()
+		}
+		if idx != g.active {
+			log.WithField("previous", g.targets[g.active].socket).Info("switching active collector")
+			g.active = idx
+		}
+		return nil
 	}
+	return fmt.Errorf("couldn't send flow records to any collector: %w", err)
 }
@@ -1,6 +1,7 @@
 package exporter
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
@@ -28,7 +29,7 @@ func TestIPv4GRPCProto_ExportFlows_AgentIP(t *testing.T) {
 	defer coll.Close()
 
 	// Start GRPCProto exporter stage
-	exporter, err := StartGRPCProto("127.0.0.1", port, 1000)
+	exporter, err := StartGRPCProto(context.Background(), "127.0.0.1", port, 1000)
 	require.NoError(t, err)
 
 	// Send some flows to the input of the exporter stage
@@ -70,7 +71,7 @@ func TestIPv6GRPCProto_ExportFlows_AgentIP(t *testing.T) {
 	defer coll.Close()
 
 	// Start GRPCProto exporter stage
-	exporter, err := StartGRPCProto("::1", port, 1000)
+	exporter, err := StartGRPCProto(context.Background(), "::1", port, 1000)
 	require.NoError(t, err)
 
 	// Send some flows to the input of the exporter stage
@@ -113,7 +114,7 @@ func TestGRPCProto_SplitLargeMessages(t *testing.T) {
 
 	const msgMaxLen = 10000
 	// Start GRPCProto exporter stage
-	exporter, err := StartGRPCProto("127.0.0.1", port, msgMaxLen)
+	exporter, err := StartGRPCProto(context.Background(), "127.0.0.1", port, msgMaxLen)
 	require.NoError(t, err)
 
 	// Send a message much longer than the limit length
@@ -143,3 +144,88 @@ func TestGRPCProto_SplitLargeMessages(t *testing.T) {
 		//ok!
 	}
 }
+
+func TestGRPCProto_Failover(t *testing.T) {
+	primaryPort, err := test.FreeTCPPort()
+	require.NoError(t, err)
+	secondaryPort, err := test.FreeTCPPort()
+	require.NoError(t, err)
+
+	// only the secondary collector is initially running
+	secondaryOut := make(chan *pbflow.Records, 10)
+	secondary, err := grpc.StartCollector(secondaryPort, secondaryOut)
+	require.NoError(t, err)
+	defer secondary.Close()
+
+	exporter, err := StartGRPCProtoFailover(context.Background(), []GRPCCollector{
+		{HostIP: "127.0.0.1", HostPort: primaryPort},
+		{HostIP: "127.0.0.1", HostPort: secondaryPort},
+	}, 1000, 10*time.Millisecond, timeout)
+	require.NoError(t, err)
+
+	flows := make(chan []*flow.Record, 10)
+	go exporter.ExportFlows(flows)
+
+	// flows are sent to the secondary while the primary is unavailable
+	flows <- []*flow.Record{{Interface: "first"}}
+	rs := test2.ReceiveTimeout(t, secondaryOut, timeout)
+	require.Len(t, rs.Entries, 1)
+	assert.Equal(t, "first", rs.Entries[0].Interface)
+
+	// when the primary is available again, the exporter eventually switches back to it
+	primaryOut := make(chan *pbflow.Records, 10)
+	primary, err := grpc.StartCollector(primaryPort, primaryOut)
+	require.NoError(t, err)
+	defer primary.Close()
+
+	test.Eventually(t, 3*timeout, func(t require.TestingT) {
+		flows <- []*flow.Record{{Interface: "second"}}
+		select {
+		case rs := <-primaryOut:
+			require.Len(t, rs.Entries, 1)
+			assert.Equal(t, "second", rs.Entries[0].Interface)
+		case rs := <-secondaryOut:
+			require.Failf(t, "flow sent to secondary collector", "%v", rs)
+		case <-time.After(timeout):
+			require.Fail(t, "timeout waiting for flows")
+		}
+	})
+}
+
+func TestGRPCProto_FailoverOnSendTimeout(t *testing.T) {
+	// the primary collector accepts the connections but never responds
+	primary, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer primary.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := primary.Accept()
+			if err != nil {
+				for _, c := range conns {
+					_ = c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	secondaryPort, err := test.FreeTCPPort()
+	require.NoError(t, err)
+	secondaryOut := make(chan *pbflow.Records, 10)
+	secondary, err := grpc.StartCollector(secondaryPort, secondaryOut)
+	require.NoError(t, err)
+	defer secondary.Close()
+
+	exporter, err := StartGRPCProtoFailover(context.Background(), []GRPCCollector{
+		{HostIP: "127.0.0.1", HostPort: primary.Addr().(*net.TCPAddr).Port},
+		{HostIP: "127.0.0.1", HostPort: secondaryPort},
+	}, 1000, time.Minute, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	// the request to the primary times out, so the flows are sent to the secondary
+	require.NoError(t, exporter.Export([]*flow.Record{{Interface: "eth0"}}))
+	rs := test2.ReceiveTimeout(t, secondaryOut, timeout)
+	require.Len(t, rs.Entries, 1)
+	assert.Equal(t, "eth0", rs.Entries[0].Interface)
+}
@@ -16,6 +16,13 @@ var ilog = logrus.WithField("component", "exporter/IPFIXProto")
 // TODO: encode also the equivalent of the Protobuf's AgentIP field in a format that is binary-
 // compatible with OVN-K.
 
+// ipfixEnterpriseElements are the agent-specific information elements, which aren't part of the
+// IANA registry: the ClusterID and TenantID of the flows
+var ipfixEnterpriseElements = []*entities.InfoElement{
+	entities.NewInfoElement("clusterId", 1, entities.String, enterpriseID, entities.VariableLength),
+	entities.NewInfoElement("tenantId", 2, entities.String, enterpriseID, entities.VariableLength),
+}
+
 type IPFIX struct {
 	hostIP       string
 	hostPort     int
@@ -74,6 +81,14 @@ func AddRecordValuesToTemplate(log *logrus.Entry, elements *[]entities.InfoEleme
 	if err != nil {
 		return err
 	}
+	for _, element := range ipfixEnterpriseElements {
+		ie, err := entities.DecodeAndCreateInfoElementWithValue(element, nil)
+		if err != nil {
+			log.WithError(err).Errorf("Failed to decode element %s", element.Name)
+			return err
+		}
+		*elements = append(*elements, ie)
+	}
 	return nil
 }
 
@@ -288,6 +303,10 @@ func setIERecordValue(record *flow.Record, ieValPtr *entities.InfoElementWithVal
 		ieVal.SetUnsigned64Value(uint64(record.Metrics.Packets))
 	case "interfaceName":
 		ieVal.SetStringValue(record.Interface)
+	case "clusterId":
+		ieVal.SetStringValue(record.ClusterID)
+	case "tenantId":
+		ieVal.SetStringValue(record.TenantID)
 	}
 }
 func setIEValue(record *flow.Record, ieValPtr *entities.InfoElementWithValue) {
@@ -366,6 +385,10 @@ func (ipf *IPFIX) ExportFlows(input <-chan []*flow.Record) {
 	log := ilog.WithField("collector", socket)
 	for inputRecords := range input {
 		for _, record := range inputRecords {
+			// IPFIX has no representation for heartbeats
+			if record.IsHeartbeat() {
+				continue
+			}
 			if record.Id.EthProtocol == flow.IPv6Type {
 				err := ipf.sendDataRecord(log, record, true)
 				if err != nil {
@@ -0,0 +1,30 @@
+package exporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmware/go-ipfix/pkg/entities"
+	"github.com/vmware/go-ipfix/pkg/registry"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestIPFIX_ClusterAndTenant(t *testing.T) {
+	registry.LoadRegistry()
+	var elements []entities.InfoElementWithValue
+	require.NoError(t, AddRecordValuesToTemplate(ilog, &elements))
+
+	// WHEN the values of a flow from a cluster and tenant are set
+	setEntities(&flow.Record{ClusterID: "east", TenantID: "team-a"}, &elements)
+
+	// THEN they are carried by the enterprise-specific elements
+	values := map[string]string{}
+	for _, ie := range elements {
+		if ie.GetInfoElement().EnterpriseId == enterpriseID {
+			values[ie.GetName()] = ie.GetStringValue()
+		}
+	}
+	assert.Equal(t, map[string]string{"clusterId": "east", "tenantId": "team-a"}, values)
+}
@@ -0,0 +1,153 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Naming conventions for the keys of the JSON-encoded flows
+const (
+	// FieldCasePascal keeps the Go field names (e.g. TimeFlowStartMs, AgentIP)
+	FieldCasePascal = "pascal"
+	// FieldCaseCamel lowers the first word of the field names (e.g. timeFlowStartMs, agentIP)
+	FieldCaseCamel = "camel"
+	// FieldCaseSnake converts the field names to lowercase words separated by underscores
+	// (e.g. time_flow_start_ms, agent_ip)
+	FieldCaseSnake = "snake"
+)
+
+// JSONMarshaler encodes flows as JSON, naming the keys according to a given convention.
+// Instead of duplicating the struct tags for each convention, it rewrites the keys of the
+// default Go JSON encoding.
+type JSONMarshaler struct {
+	convert func(string) string
+}
+
+// NewJSONMarshaler returns a JSONMarshaler for the provided naming convention: pascal (default
+// if empty), camel or snake.
+func NewJSONMarshaler(fieldCase string) (*JSONMarshaler, error) {
+	switch fieldCase {
+	case "", FieldCasePascal:
+		return &JSONMarshaler{}, nil
+	case FieldCaseCamel:
+		return &JSONMarshaler{convert: camelCase}, nil
+	case FieldCaseSnake:
+		return &JSONMarshaler{convert: snakeCase}, nil
+	default:
+		return nil, fmt.Errorf("wrong field case %q. Admitted values are %s, %s, %s",
+			fieldCase, FieldCasePascal, FieldCaseCamel, FieldCaseSnake)
+	}
+}
+
+// Marshal returns the JSON encoding of the provided value
+func (m *JSONMarshaler) Marshal(v interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil || m.convert == nil {
+		return encoded, err
+	}
+	return m.rewriteKeys(encoded)
+}
+
+// jsonFrame keeps track of the current JSON object or array while rewriting the keys
+type jsonFrame struct {
+	object bool
+	// number of tokens already written in the object or array
+	tokens int
+}
+
+func (m *JSONMarshaler) rewriteKeys(encoded []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(encoded))
+	dec.UseNumber()
+	out := bytes.Buffer{}
+	out.Grow(len(encoded))
+	var stack []jsonFrame
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.Bytes(), nil
+		} else if err != nil {
+			return nil, err
+		}
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			out.WriteRune(rune(delim))
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		isKey := false
+		if len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			switch {
+			case top.object && top.tokens%2 == 0:
+				isKey = true
+				if top.tokens > 0 {
+					out.WriteByte(',')
+				}
+			case top.object:
+				out.WriteByte(':')
+			case top.tokens > 0:
+				out.WriteByte(',')
+			}
+			top.tokens++
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			out.WriteRune(rune(t))
+			stack = append(stack, jsonFrame{object: t == '{'})
+		case string:
+			if isKey {
+				t = m.convert(t)
+			}
+			str, err := json.Marshal(t)
+			if err != nil {
+				return nil, err
+			}
+			out.Write(str)
+		case json.Number:
+			out.WriteString(t.String())
+		case bool:
+			if t {
+				out.WriteString("true")
+			} else {
+				out.WriteString("false")
+			}
+		case nil:
+			out.WriteString("null")
+		}
+	}
+}
+
+// splitWords splits a Go field name into words, keeping acronyms together
+// (e.g. TCPState is split into TCP and State)
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		prev, cur := runes[i-1], runes[i]
+		nextIsLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsUpper(cur) &&
+			(unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextIsLower)) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+func camelCase(name string) string {
+	words := splitWords(name)
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
+
+func snakeCase(name string) string {
+	words := splitWords(name)
+	for i := range words {
+		words[i] = strings.ToLower(words[i])
+	}
+	return strings.Join(words, "_")
+}
@@ -0,0 +1,71 @@
+package exporter
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestJSONMarshaler_FieldCase(t *testing.T) {
+	record := &flow.Record{
+		TimeFlowStart: time.UnixMilli(1_600_000_000_000),
+		TimeFlowEnd:   time.UnixMilli(1_600_000_001_000),
+		Interface:     "eth0",
+		AgentIP:       net.ParseIP("10.0.0.1"),
+		TCPState:      flow.TCPStateEstablished,
+	}
+	record.Id.SrcPort = 1234
+	record.Id.IfIndex = 3
+	record.Metrics.Bytes = 456
+	record.Metrics.MinTtl = 64
+
+	for _, tc := range []struct {
+		fieldCase string
+		expected  []string
+	}{{
+		fieldCase: FieldCasePascal,
+		expected: []string{`"Id":{`, `"SrcPort":1234`, `"IfIndex":3`, `"Metrics":{`, `"Bytes":456`,
+			`"MinTtl":64`, `"Interface":"eth0"`, `"AgentIP":"10.0.0.1"`, `"TCPState":"ESTABLISHED"`,
+			`"TimeFlowStartMs":1600000000000`},
+	}, {
+		fieldCase: FieldCaseCamel,
+		expected: []string{`"id":{`, `"srcPort":1234`, `"ifIndex":3`, `"metrics":{`, `"bytes":456`,
+			`"minTtl":64`, `"interface":"eth0"`, `"agentIP":"10.0.0.1"`, `"tcpState":"ESTABLISHED"`,
+			`"timeFlowStartMs":1600000000000`},
+	}, {
+		fieldCase: FieldCaseSnake,
+		expected: []string{`"id":{`, `"src_port":1234`, `"if_index":3`, `"metrics":{`, `"bytes":456`,
+			`"min_ttl":64`, `"interface":"eth0"`, `"agent_ip":"10.0.0.1"`, `"tcp_state":"ESTABLISHED"`,
+			`"time_flow_start_ms":1600000000000`},
+	}} {
+		t.Run(tc.fieldCase, func(t *testing.T) {
+			m, err := NewJSONMarshaler(tc.fieldCase)
+			require.NoError(t, err)
+			encoded, err := m.Marshal(toJSONRecord(record, false))
+			require.NoError(t, err)
+			for _, e := range tc.expected {
+				assert.Contains(t, string(encoded), e)
+			}
+			// values are never converted
+			assert.Contains(t, string(encoded), `"eth0"`)
+		})
+	}
+}
+
+func TestJSONMarshaler_InvalidCase(t *testing.T) {
+	_, err := NewJSONMarshaler("kebab")
+	assert.Error(t, err)
+}
+
+func TestSplitWords(t *testing.T) {
+	assert.Equal(t, []string{"TCP", "State"}, splitWords("TCPState"))
+	assert.Equal(t, []string{"Agent", "IP"}, splitWords("AgentIP"))
+	assert.Equal(t, []string{"Time", "Flow", "Start", "Ms"}, splitWords("TimeFlowStartMs"))
+	assert.Equal(t, []string{"Id"}, splitWords("Id"))
+	assert.Equal(t, []string{"Ipv6"}, splitWords("Ipv6"))
+}
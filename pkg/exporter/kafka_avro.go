@@ -0,0 +1,364 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+var kalog = logrus.WithField("component", "exporter/KafkaAvro")
+
+// avroMagicByte prefixes each message as specified by the schema registry wire format:
+// https://docs.confluent.io/platform/current/schema-registry/serdes-develop/index.html#wire-format
+const avroMagicByte = 0x00
+
+const schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+
+// AvroFlowSchema is the Avro schema of the flow records, as submitted to the schema registry.
+// Field names follow the same conventions as the Flowlogs-Pipeline generic maps.
+// Any change in the order or types of the fields must be reflected in the AvroEncoder.Encode
+// method.
+const AvroFlowSchema = `{
+  "type": "record",
+  "name": "Record",
+  "namespace": "netobserv.flow",
+  "fields": [
+    {"name": "Etype", "type": "int"},
+    {"name": "FlowDirection", "type": "int"},
+    {"name": "TimeFlowStartMs", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "TimeFlowEndMs", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+    {"name": "SrcMac", "type": "string"},
+    {"name": "DstMac", "type": "string"},
+    {"name": "SrcAddr", "type": "string"},
+    {"name": "DstAddr", "type": "string"},
+    {"name": "SrcPort", "type": "int"},
+    {"name": "DstPort", "type": "int"},
+    {"name": "Proto", "type": "int"},
+    {"name": "IcmpType", "type": "int"},
+    {"name": "IcmpCode", "type": "int"},
+    {"name": "Bytes", "type": "long"},
+    {"name": "Packets", "type": "long"},
+    {"name": "Flags", "type": "int"},
+    {"name": "Interface", "type": "string"},
+    {"name": "Duplicate", "type": "boolean"},
+    {"name": "AgentIP", "type": "string"},
+    {"name": "PayloadSample", "type": "bytes"},
+    {"name": "TCPState", "type": "string"},
+    {"name": "MinTTL", "type": "int"},
+    {"name": "MaxTTL", "type": "int"},
+    {"name": "FragmentedPackets", "type": "long"},
+    {"name": "PacketSizeBuckets", "type": {"type": "array", "items": "long"}},
+    {"name": "ServerConnectLatencyNs", "type": "long"},
+    {"name": "FlowEndReason", "type": "string"},
+    {"name": "Service", "type": "string"},
+    {"name": "CgroupID", "type": "long"},
+    {"name": "PolicyVerdict", "type": "string"},
+    {"name": "SrcHostname", "type": "string"},
+    {"name": "DstHostname", "type": "string"},
+    {"name": "ConnectionID", "type": "long"},
+    {"name": "ClusterID", "type": "string"},
+    {"name": "TenantID", "type": "string"},
+    {"name": "SubFlowCount", "type": "long"},
+    {"name": "TrafficClass", "type": "string"},
+    {"name": "MinIPGNs", "type": "long"},
+    {"name": "MaxIPGNs", "type": "long"},
+    {"name": "MeanIPGNs", "type": "long"},
+    {"name": "SrcLabels", "type": {"type": "map", "values": "string"}},
+    {"name": "DstLabels", "type": {"type": "map", "values": "string"}},
+    {"name": "EchoRTTNs", "type": "long"},
+    {"name": "EchoReplyPackets", "type": "long"},
+    {"name": "EchoReplyBytes", "type": "long"},
+    {"name": "AgentVersion", "type": "string"},
+    {"name": "BpfProgHash", "type": "string"},
+    {"name": "InterfaceID", "type": "string"},
+    {"name": "TunnelType", "type": "string"},
+    {"name": "TunnelVNI", "type": "long"},
+    {"name": "InnerSrcAddr", "type": "string"},
+    {"name": "InnerDstAddr", "type": "string"},
+    {"name": "InnerSrcPort", "type": "int"},
+    {"name": "InnerDstPort", "type": "int"},
+    {"name": "InnerProto", "type": "int"},
+    {"name": "SchemaVersion", "type": "int"},
+    {"name": "ScanSuspect", "type": "boolean"},
+    {"name": "PID", "type": "long"},
+    {"name": "Comm", "type": "string"},
+    {"name": "RecordID", "type": "long"},
+    {"name": "NextHop", "type": "string"},
+    {"name": "QUICVersion", "type": "long"},
+    {"name": "QUICDCID", "type": "bytes"},
+    {"name": "QUICSCID", "type": "bytes"},
+    {"name": "DuplicatePackets", "type": "long"},
+    {"name": "ObservedPorts", "type": {"type": "array", "items": "long"}},
+    {"name": "ObservedPortsOverflow", "type": "long"},
+    {"name": "ContainerID", "type": "string"},
+    {"name": "PodUID", "type": "string"},
+    {"name": "SrcNamespace", "type": "string"},
+    {"name": "SrcPod", "type": "string"},
+    {"name": "DstNamespace", "type": "string"},
+    {"name": "DstPod", "type": "string"},
+    {"name": "SrcCountry", "type": "string"},
+    {"name": "DstCountry", "type": "string"}
+  ]
+}`
+
+// AvroEncoder serializes flow records into the Avro binary format, prefixed by the
+// schema ID that has been assigned to the AvroFlowSchema by the schema registry
+type AvroEncoder struct {
+	schemaID uint32
+}
+
+// NewAvroEncoder registers the AvroFlowSchema in the schema registry from the provided URL, under
+// the given subject name. It returns error if the schema registry is not reachable or the schema
+// can't be registered.
+func NewAvroEncoder(client *http.Client, registryURL, subject string) (*AvroEncoder, error) {
+	if registryURL == "" {
+		return nil, fmt.Errorf("missing schema registry URL")
+	}
+	body, err := json.Marshal(map[string]string{"schema": AvroFlowSchema})
+	if err != nil {
+		return nil, fmt.Errorf("encoding schema registration request: %w", err)
+	}
+	endpoint := strings.TrimSuffix(registryURL, "/") + "/subjects/" + url.PathEscape(subject) + "/versions"
+	resp, err := client.Post(endpoint, schemaRegistryContentType, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("registering Avro schema into %s: %w", registryURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("registering Avro schema into %s: %s: %s",
+			registryURL, resp.Status, string(msg))
+	}
+	var registered struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&registered); err != nil {
+		return nil, fmt.Errorf("decoding schema registry response: %w", err)
+	}
+	kalog.WithFields(logrus.Fields{
+		"subject":  subject,
+		"schemaID": registered.ID,
+	}).Info("Avro schema registered")
+	return &AvroEncoder{schemaID: registered.ID}, nil
+}
+
+// Encode a flow record as an Avro message prefixed by the schema ID framing.
+func (ae *AvroEncoder) Encode(record *flow.Record) []byte {
+	aw := avroWriter{}
+	aw.buf.WriteByte(avroMagicByte)
+	var schemaID [4]byte
+	binary.BigEndian.PutUint32(schemaID[:], ae.schemaID)
+	aw.buf.Write(schemaID[:])
+
+	aw.writeLong(int64(record.Id.EthProtocol))
+	aw.writeLong(int64(record.Id.Direction))
+	aw.writeLong(record.TimeFlowStart.UnixMilli())
+	aw.writeLong(record.TimeFlowEnd.UnixMilli())
+	srcMac, dstMac := flow.MacAddr(record.Id.SrcMac), flow.MacAddr(record.Id.DstMac)
+	aw.writeString(srcMac.String())
+	aw.writeString(dstMac.String())
+	aw.writeString(flow.IP(record.Id.SrcIp).String())
+	aw.writeString(flow.IP(record.Id.DstIp).String())
+	aw.writeLong(int64(record.Id.SrcPort))
+	aw.writeLong(int64(record.Id.DstPort))
+	aw.writeLong(int64(record.Id.TransportProtocol))
+	aw.writeLong(int64(record.Id.IcmpType))
+	aw.writeLong(int64(record.Id.IcmpCode))
+	aw.writeLong(int64(record.Metrics.Bytes))
+	aw.writeLong(int64(record.Metrics.Packets))
+	aw.writeLong(int64(record.Metrics.Flags))
+	aw.writeString(record.Interface)
+	aw.writeBoolean(record.Duplicate)
+	agentIP := ""
+	if record.AgentIP != nil {
+		agentIP = record.AgentIP.String()
+	}
+	aw.writeString(agentIP)
+	aw.writeBytes(record.PayloadSample)
+	aw.writeString(record.TCPState.String())
+	aw.writeLong(int64(record.Metrics.MinTtl))
+	aw.writeLong(int64(record.Metrics.MaxTtl))
+	aw.writeLong(int64(record.Metrics.FragmentedPackets))
+	// arrays are encoded as a single block with all the items, followed by an empty block
+	aw.writeLong(int64(len(record.Metrics.PktSizeBuckets)))
+	for _, count := range record.Metrics.PktSizeBuckets {
+		aw.writeLong(int64(count))
+	}
+	aw.writeLong(0)
+	aw.writeLong(int64(record.ServerConnectLatency))
+	aw.writeString(record.EndReason.String())
+	aw.writeString(record.Service)
+	aw.writeLong(int64(record.CgroupID))
+	aw.writeString(record.PolicyVerdict.String())
+	aw.writeString(record.SrcHostname)
+	aw.writeString(record.DstHostname)
+	// the IDs with the highest bit set are encoded as negative numbers
+	aw.writeLong(int64(record.ConnectionID))
+	aw.writeString(record.ClusterID)
+	aw.writeString(record.TenantID)
+	aw.writeLong(int64(record.SubFlowCount))
+	aw.writeString(record.TrafficClass.String())
+	aw.writeLong(int64(record.MinIPG))
+	aw.writeLong(int64(record.MaxIPG))
+	aw.writeLong(int64(record.MeanIPG))
+	aw.writeStringMap(record.SrcLabels)
+	aw.writeStringMap(record.DstLabels)
+	aw.writeLong(int64(record.EchoRTT))
+	aw.writeLong(int64(record.EchoReplyPackets))
+	aw.writeLong(int64(record.EchoReplyBytes))
+	aw.writeString(record.AgentVersion)
+	aw.writeString(record.BpfProgHash)
+	aw.writeString(record.InterfaceID)
+	aw.writeString(record.Tunnel().String())
+	aw.writeLong(int64(record.Id.TunnelVni))
+	innerSrc, innerDst := "", ""
+	if record.Id.InnerSrcIp != (flow.IPAddr{}) || record.Id.InnerDstIp != (flow.IPAddr{}) {
+		innerSrc = flow.IP(record.Id.InnerSrcIp).String()
+		innerDst = flow.IP(record.Id.InnerDstIp).String()
+	}
+	aw.writeString(innerSrc)
+	aw.writeString(innerDst)
+	aw.writeLong(int64(record.Id.InnerSrcPort))
+	aw.writeLong(int64(record.Id.InnerDstPort))
+	aw.writeLong(int64(record.Id.InnerTransportProtocol))
+	aw.writeLong(int64(record.SchemaVersion))
+	aw.writeBoolean(record.ScanSuspect)
+	aw.writeLong(int64(record.PID))
+	aw.writeString(record.Comm)
+	aw.writeLong(int64(record.RecordID))
+	nextHop := ""
+	if record.NextHop != nil {
+		nextHop = record.NextHop.String()
+	}
+	aw.writeString(nextHop)
+	aw.writeLong(int64(record.QUICVersion))
+	aw.writeBytes(record.QUICDCID)
+	aw.writeBytes(record.QUICSCID)
+	aw.writeLong(int64(record.Metrics.DuplicatePackets))
+	// an empty array is encoded as the empty block alone
+	if len(record.ObservedPorts) > 0 {
+		aw.writeLong(int64(len(record.ObservedPorts)))
+		for _, port := range record.ObservedPorts {
+			aw.writeLong(int64(port))
+		}
+	}
+	aw.writeLong(0)
+	aw.writeLong(int64(record.ObservedPortsOverflow))
+	aw.writeString(record.ContainerID)
+	aw.writeString(record.PodUID)
+	aw.writeString(record.SrcNamespace)
+	aw.writeString(record.SrcPod)
+	aw.writeString(record.DstNamespace)
+	aw.writeString(record.DstPod)
+	aw.writeString(record.SrcCountry)
+	aw.writeString(record.DstCountry)
+	return aw.buf.Bytes()
+}
+
+// avroWriter implements the encoding of the Avro primitive types, as defined in
+// https://avro.apache.org/docs/1.11.1/specification/#binary-encoding
+type avroWriter struct {
+	buf bytes.Buffer
+}
+
+// writeLong encodes both int and long Avro types, as zig-zag variable-length integers
+func (aw *avroWriter) writeLong(n int64) {
+	var varint [binary.MaxVarintLen64]byte
+	l := binary.PutVarint(varint[:], n)
+	aw.buf.Write(varint[:l])
+}
+
+func (aw *avroWriter) writeBoolean(b bool) {
+	if b {
+		aw.buf.WriteByte(1)
+	} else {
+		aw.buf.WriteByte(0)
+	}
+}
+
+func (aw *avroWriter) writeBytes(b []byte) {
+	aw.writeLong(int64(len(b)))
+	aw.buf.Write(b)
+}
+
+func (aw *avroWriter) writeString(s string) {
+	aw.writeLong(int64(len(s)))
+	aw.buf.WriteString(s)
+}
+
+// writeStringMap encodes a map as a single block with all the entries, sorted by key, followed
+// by an empty block
+func (aw *avroWriter) writeStringMap(m map[string]string) {
+	if len(m) > 0 {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		aw.writeLong(int64(len(keys)))
+		for _, k := range keys {
+			aw.writeString(k)
+			aw.writeString(m[k])
+		}
+	}
+	aw.writeLong(0)
+}
+
+// KafkaAvro exports flows over Kafka, encoded in Avro format and framed with the ID
+// of the schema that is registered in a schema registry
+type KafkaAvro struct {
+	Writer  kafkaWriter
+	Encoder *AvroEncoder
+	// TenantTopicPrefix, if not empty, routes each flow to the "<prefix>-<TenantID>" topic (or
+	// to the "<prefix>" topic, if the flow has no tenant). The Writer must not define any topic.
+	TenantTopicPrefix string
+	// Delivery, if not nil, retries the failed writes and accounts the delivered messages
+	Delivery *KafkaDelivery
+}
+
+func (ka *KafkaAvro) ExportFlows(input <-chan []*flow.Record) {
+	kalog.Info("starting Kafka exporter")
+	Terminal(ka)(input)
+}
+
+// Export encodes the flows in Avro format and writes them into Kafka
+func (ka *KafkaAvro) Export(records []*flow.Record) error {
+	return ka.batchAndSubmit(context.Background(), records)
+}
+
+// ExportContext works as Export, but the write and its retries are aborted when ctx is done
+func (ka *KafkaAvro) ExportContext(ctx context.Context, records []*flow.Record) error {
+	return ka.batchAndSubmit(ctx, records)
+}
+
+func (ka *KafkaAvro) Close() error {
+	return closeWriter(ka.Writer)
+}
+
+// ConcurrentSafe marks the exporter as safe for concurrent use, as the Kafka writer is
+func (ka *KafkaAvro) ConcurrentSafe() {}
+
+func (ka *KafkaAvro) batchAndSubmit(ctx context.Context, records []*flow.Record) error {
+	kalog.Debugf("sending %d records", len(records))
+	msgs := make([]kafkago.Message, 0, len(records))
+	for _, record := range records {
+		msgs = append(msgs, kafkago.Message{
+			Topic: tenantTopic(ka.TenantTopicPrefix, record),
+			Value: ka.Encoder.Encode(record),
+			Key:   getFlowKey(record),
+		})
+	}
+
+	return writeMessages(ctx, ka.Writer, ka.Delivery, msgs)
+}
@@ -0,0 +1,275 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvroRoundTrip(t *testing.T) {
+	var registeredSchema string
+	registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.URL.Path != "/subjects/network-flows-value/versions" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var body struct {
+			Schema string `json:"schema"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		registeredSchema = body.Schema
+		_, _ = rw.Write([]byte(`{"id":1234}`))
+	}))
+	defer registry.Close()
+
+	encoder, err := NewAvroEncoder(registry.Client(), registry.URL, "network-flows-value")
+	require.NoError(t, err)
+	assert.JSONEq(t, AvroFlowSchema, registeredSchema)
+
+	wc := writerCapturer{}
+	ka := KafkaAvro{Writer: &wc, Encoder: encoder}
+	record := flow.Record{}
+	record.Id.EthProtocol = flow.IPv6Type
+	record.Id.Direction = 1
+	record.Id.SrcMac = [...]byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	record.Id.DstMac = [...]byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+	record.Id.SrcIp = IPAddrFromNetIP(net.ParseIP("1::2"))
+	record.Id.DstIp = IPAddrFromNetIP(net.ParseIP("192.1.2.3"))
+	record.Id.SrcPort = 4321
+	record.Id.DstPort = 1234
+	record.Id.TransportProtocol = 6
+	record.Id.IcmpType = 8
+	record.Id.IcmpCode = 3
+	record.TimeFlowStart = time.UnixMilli(1_600_000_000_000)
+	record.TimeFlowEnd = time.UnixMilli(1_600_000_005_000)
+	record.Metrics.Bytes = 1 << 40
+	record.Metrics.Packets = 987
+	record.Metrics.Flags = 0x12
+	record.Interface = "veth0"
+	record.Duplicate = true
+	record.AgentIP = net.ParseIP("10.9.8.7")
+	record.PayloadSample = []byte("GET /")
+	record.TCPState = flow.TCPStateEstablished
+	record.Metrics.MinTtl = 12
+	record.Metrics.MaxTtl = 64
+	record.Metrics.FragmentedPackets = 3
+	record.Metrics.PktSizeBuckets = [4]uint32{10, 20, 30, 40}
+	record.ServerConnectLatency = 2 * time.Millisecond
+	record.EndReason = flow.FlowEndReasonLifetimeCap
+	record.Service = "https"
+	record.CgroupID = 12345
+	record.PolicyVerdict = flow.PolicyVerdictDenied
+	record.DstHostname = "example.com"
+	record.ConnectionID = 1<<63 | 42
+	record.ClusterID = "cluster-a"
+	record.TenantID = "acme"
+	record.TrafficClass = flow.TrafficClassMulticast
+	record.MinIPG = time.Millisecond
+	record.MaxIPG = 30 * time.Millisecond
+	record.MeanIPG = 10 * time.Millisecond
+	record.DstLabels = map[string]string{"team": "payments", "environment": "prod"}
+	record.EchoRTT = 3 * time.Millisecond
+	record.EchoReplyPackets = 5
+	record.EchoReplyBytes = 420
+	record.AgentVersion = "v1.2.3"
+	record.SchemaVersion = flow.SchemaVersion
+	record.ScanSuspect = true
+	record.PID = 1234
+	record.Comm = "curl"
+	record.RecordID = 987
+	record.NextHop = net.ParseIP("10.0.0.1")
+	record.QUICVersion = 1
+	record.QUICDCID = []byte{0x83, 0x94}
+	record.QUICSCID = []byte{0x12}
+	record.Metrics.DuplicatePackets = 6
+	record.ObservedPorts = []uint16{443, 22}
+	record.ObservedPortsOverflow = 3
+	record.ContainerID = "0123"
+	record.PodUID = "4567"
+	record.SrcNamespace = "default"
+	record.SrcPod = "client"
+	record.DstNamespace = "web"
+	record.DstPod = "server"
+	record.SrcCountry = "ES"
+	record.DstCountry = "FR"
+	record.BpfProgHash = "0123456789abcdef"
+	record.InterfaceID = "worker-1/3"
+	record.Id.TunnelType = uint8(flow.TunnelVXLAN)
+	record.Id.TunnelVni = 4096
+	copy(record.Id.InnerSrcIp[:], net.ParseIP("10.244.0.5").To16())
+	copy(record.Id.InnerDstIp[:], net.ParseIP("10.244.1.7").To16())
+	record.Id.InnerSrcPort = 40000
+	record.Id.InnerDstPort = 8080
+	record.Id.InnerTransportProtocol = 6
+
+	input := make(chan []*flow.Record, 1)
+	input <- []*flow.Record{&record}
+	close(input)
+	ka.ExportFlows(input)
+
+	require.Len(t, wc.messages, 1)
+	msg := wc.messages[0]
+	assert.Equal(t, getFlowKey(&record), msg.Key)
+
+	// check schema ID framing
+	require.Greater(t, len(msg.Value), 5)
+	assert.EqualValues(t, 0, msg.Value[0])
+	assert.EqualValues(t, 1234, binary.BigEndian.Uint32(msg.Value[1:5]))
+
+	ar := avroReader{t: t, r: bytes.NewReader(msg.Value[5:])}
+	assert.EqualValues(t, flow.IPv6Type, ar.readLong())
+	assert.EqualValues(t, 1, ar.readLong())
+	assert.EqualValues(t, 1_600_000_000_000, ar.readLong())
+	assert.EqualValues(t, 1_600_000_005_000, ar.readLong())
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", ar.readString())
+	assert.Equal(t, "11:22:33:44:55:66", ar.readString())
+	assert.Equal(t, "1::2", ar.readString())
+	assert.Equal(t, "192.1.2.3", ar.readString())
+	assert.EqualValues(t, 4321, ar.readLong())
+	assert.EqualValues(t, 1234, ar.readLong())
+	assert.EqualValues(t, 6, ar.readLong())
+	assert.EqualValues(t, 8, ar.readLong())
+	assert.EqualValues(t, 3, ar.readLong())
+	assert.EqualValues(t, 1<<40, ar.readLong())
+	assert.EqualValues(t, 987, ar.readLong())
+	assert.EqualValues(t, 0x12, ar.readLong())
+	assert.Equal(t, "veth0", ar.readString())
+	assert.True(t, ar.readBoolean())
+	assert.Equal(t, "10.9.8.7", ar.readString())
+	assert.Equal(t, []byte("GET /"), ar.readBytes())
+	assert.Equal(t, "ESTABLISHED", ar.readString())
+	assert.EqualValues(t, 12, ar.readLong())
+	assert.EqualValues(t, 64, ar.readLong())
+	assert.EqualValues(t, 3, ar.readLong())
+	// packet size buckets array
+	assert.EqualValues(t, 4, ar.readLong())
+	for _, count := range []int64{10, 20, 30, 40} {
+		assert.Equal(t, count, ar.readLong())
+	}
+	assert.EqualValues(t, 0, ar.readLong())
+	assert.EqualValues(t, 2_000_000, ar.readLong())
+	assert.Equal(t, "lifetime-cap", ar.readString())
+	assert.Equal(t, "https", ar.readString())
+	assert.EqualValues(t, 12345, ar.readLong())
+	assert.Equal(t, "denied", ar.readString())
+	assert.Empty(t, ar.readString())
+	assert.Equal(t, "example.com", ar.readString())
+	assert.EqualValues(t, uint64(1<<63|42), uint64(ar.readLong()))
+	assert.Equal(t, "cluster-a", ar.readString())
+	assert.Equal(t, "acme", ar.readString())
+	assert.EqualValues(t, 0, ar.readLong())
+	assert.Equal(t, "multicast", ar.readString())
+	assert.EqualValues(t, time.Millisecond, ar.readLong())
+	assert.EqualValues(t, 30*time.Millisecond, ar.readLong())
+	assert.EqualValues(t, 10*time.Millisecond, ar.readLong())
+	// empty source labels map
+	assert.EqualValues(t, 0, ar.readLong())
+	// destination labels map, sorted by key
+	assert.EqualValues(t, 2, ar.readLong())
+	for _, entry := range []string{"environment", "prod", "team", "payments"} {
+		assert.Equal(t, entry, ar.readString())
+	}
+	assert.EqualValues(t, 0, ar.readLong())
+	assert.EqualValues(t, 3*time.Millisecond, ar.readLong())
+	assert.EqualValues(t, 5, ar.readLong())
+	assert.EqualValues(t, 420, ar.readLong())
+	assert.Equal(t, "v1.2.3", ar.readString())
+	assert.Equal(t, "0123456789abcdef", ar.readString())
+	assert.Equal(t, "worker-1/3", ar.readString())
+	assert.Equal(t, "vxlan", ar.readString())
+	assert.EqualValues(t, 4096, ar.readLong())
+	assert.Equal(t, "10.244.0.5", ar.readString())
+	assert.Equal(t, "10.244.1.7", ar.readString())
+	assert.EqualValues(t, 40000, ar.readLong())
+	assert.EqualValues(t, 8080, ar.readLong())
+	assert.EqualValues(t, 6, ar.readLong())
+	assert.EqualValues(t, flow.SchemaVersion, ar.readLong())
+	assert.True(t, ar.readBoolean())
+	assert.EqualValues(t, 1234, ar.readLong())
+	assert.Equal(t, "curl", ar.readString())
+	assert.EqualValues(t, 987, ar.readLong())
+	assert.Equal(t, "10.0.0.1", ar.readString())
+	assert.EqualValues(t, 1, ar.readLong())
+	assert.Equal(t, []byte{0x83, 0x94}, ar.readBytes())
+	assert.Equal(t, []byte{0x12}, ar.readBytes())
+	assert.EqualValues(t, 6, ar.readLong())
+	assert.EqualValues(t, 2, ar.readLong())
+	assert.EqualValues(t, 443, ar.readLong())
+	assert.EqualValues(t, 22, ar.readLong())
+	assert.EqualValues(t, 0, ar.readLong())
+	assert.EqualValues(t, 3, ar.readLong())
+	assert.Equal(t, "0123", ar.readString())
+	assert.Equal(t, "4567", ar.readString())
+	assert.Equal(t, "default", ar.readString())
+	assert.Equal(t, "client", ar.readString())
+	assert.Equal(t, "web", ar.readString())
+	assert.Equal(t, "server", ar.readString())
+	assert.Equal(t, "ES", ar.readString())
+	assert.Equal(t, "FR", ar.readString())
+	// the whole message has been read
+	assert.Zero(t, ar.r.Len())
+}
+
+func TestNewAvroEncoder_RegistryErrors(t *testing.T) {
+	t.Run("unreachable registry", func(t *testing.T) {
+		registry := httptest.NewServer(http.NotFoundHandler())
+		registryURL := registry.URL
+		registry.Close()
+		_, err := NewAvroEncoder(http.DefaultClient, registryURL, "flows-value")
+		assert.Error(t, err)
+	})
+	t.Run("registry rejects schema", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+			rw.WriteHeader(http.StatusConflict)
+		}))
+		defer registry.Close()
+		_, err := NewAvroEncoder(registry.Client(), registry.URL, "flows-value")
+		assert.Error(t, err)
+	})
+	t.Run("missing URL", func(t *testing.T) {
+		_, err := NewAvroEncoder(http.DefaultClient, "", "flows-value")
+		assert.Error(t, err)
+	})
+}
+
+// avroReader decodes the Avro primitive types to verify the output of the AvroEncoder
+type avroReader struct {
+	t *testing.T
+	r *bytes.Reader
+}
+
+func (ar *avroReader) readLong() int64 {
+	n, err := binary.ReadVarint(ar.r)
+	require.NoError(ar.t, err)
+	return n
+}
+
+func (ar *avroReader) readBoolean() bool {
+	b, err := ar.r.ReadByte()
+	require.NoError(ar.t, err)
+	return b != 0
+}
+
+func (ar *avroReader) readBytes() []byte {
+	b := make([]byte, ar.readLong())
+	_, err := io.ReadFull(ar.r, b)
+	require.NoError(ar.t, err)
+	return b
+}
+
+func (ar *avroReader) readString() string {
+	return string(ar.readBytes())
+}
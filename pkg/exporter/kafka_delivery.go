@@ -0,0 +1,119 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	kafkago "github.com/segmentio/kafka-go"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// KafkaDelivery provides at-least-once delivery to the Kafka exporters: a batch of messages is
+// only considered delivered once the Kafka writer acknowledges it (according to its
+// RequiredAcks), and the messages whose write failed are retried with an exponential backoff,
+// until the context of the export is done. Meanwhile, the next batches of flows are kept in the bounded buffer that precedes the
+// exporter, and discarded if it is full.
+type KafkaDelivery struct {
+	retries    int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	delivered  prometheus.Counter
+	failed     prometheus.Counter
+	after      func(time.Duration) <-chan time.Time
+}
+
+// NewKafkaDelivery creates a KafkaDelivery that retries a failed write up to the provided
+// number of retries. The first retry waits for the provided backoff, which is doubled on
+// each retry up to maxBackoff. The metrics are labeled by the provided exporter name, so
+// multiple Kafka exporters can coexist. The Kafka writer must not retry the writes by itself
+// (its MaxAttempts must be 1), as the retries would be multiplied.
+func NewKafkaDelivery(
+	name string, retries int, backoff, maxBackoff time.Duration, m *metrics.Metrics,
+) *KafkaDelivery {
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+	return &KafkaDelivery{
+		retries:    retries,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+		delivered: m.NewCounterVec("kafka_delivered_messages_total",
+			"Flow messages whose delivery has been acknowledged by Kafka", "exporter").
+			WithLabelValues(name),
+		failed: m.NewCounterVec("kafka_failed_messages_total",
+			"Flow messages that couldn't be delivered to Kafka after all the retries", "exporter").
+			WithLabelValues(name),
+		after: time.After,
+	}
+}
+
+// Completion accounts the delivered and failed messages. It is intended to be used as the
+// Completion function of asynchronous Kafka writers, whose writes are not retried.
+func (kd *KafkaDelivery) Completion(messages []kafkago.Message, err error) {
+	if err != nil {
+		kd.failed.Add(float64(len(messages)))
+		klog.WithError(err).Warnf("couldn't deliver %d messages to Kafka", len(messages))
+		return
+	}
+	kd.delivered.Add(float64(len(messages)))
+}
+
+func (kd *KafkaDelivery) write(ctx context.Context, w kafkaWriter, msgs []kafkago.Message) error {
+	pending := msgs
+	wait := kd.backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = w.WriteMessages(ctx, pending...); err == nil {
+			kd.delivered.Add(float64(len(pending)))
+			return nil
+		}
+		// if the writer reports the error of each message, only the failed ones are retried
+		var writeErrs kafkago.WriteErrors
+		if errors.As(err, &writeErrs) && len(writeErrs) == len(pending) {
+			failed := make([]kafkago.Message, 0, writeErrs.Count())
+			for i, msgErr := range writeErrs {
+				if msgErr != nil {
+					failed = append(failed, pending[i])
+				}
+			}
+			kd.delivered.Add(float64(len(pending) - len(failed)))
+			pending = failed
+		}
+		if attempt >= kd.retries {
+			break
+		}
+		klog.WithError(err).Debugf("couldn't write %d messages into Kafka. Retrying in %s",
+			len(pending), wait)
+		select {
+		case <-ctx.Done():
+			kd.failed.Add(float64(len(pending)))
+			return fmt.Errorf("can't write %d messages into Kafka before the export was "+
+				"canceled: %w", len(pending), err)
+		case <-kd.after(wait):
+		}
+		if wait *= 2; wait > kd.maxBackoff {
+			wait = kd.maxBackoff
+		}
+	}
+	kd.failed.Add(float64(len(pending)))
+	return fmt.Errorf("can't write %d messages into Kafka after %d retries: %w",
+		len(pending), kd.retries, err)
+}
+
+// writeMessages writes the messages through the KafkaDelivery, if provided, or just once
+// otherwise
+func writeMessages(
+	ctx context.Context, w kafkaWriter, kd *KafkaDelivery, msgs []kafkago.Message,
+) error {
+	if kd != nil {
+		return kd.write(ctx, w, msgs)
+	}
+	if err := w.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("can't write messages into Kafka: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// flakyWriter fails the writes with the errors that are queued in its failures slice, and
+// captures the messages of the successful writes
+type flakyWriter struct {
+	failures []error
+	attempts int
+	messages []kafkago.Message
+}
+
+func (w *flakyWriter) WriteMessages(_ context.Context, msgs ...kafkago.Message) error {
+	w.attempts++
+	if len(w.failures) > 0 {
+		err := w.failures[0]
+		w.failures = w.failures[1:]
+		var writeErrs kafkago.WriteErrors
+		if errors.As(err, &writeErrs) {
+			for i, msgErr := range writeErrs {
+				if msgErr == nil {
+					w.messages = append(w.messages, msgs[i])
+				}
+			}
+		}
+		return err
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func newTestDelivery(retries int) (*KafkaDelivery, *[]time.Duration) {
+	delivery := NewKafkaDelivery("kafka", retries, 100*time.Millisecond, 300*time.Millisecond,
+		metrics.NoOp())
+	var waits []time.Duration
+	delivery.after = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	return delivery, &waits
+}
+
+func TestKafkaDelivery_RetryTransientFailure(t *testing.T) {
+	// GIVEN a Kafka writer that fails transiently
+	writer := &flakyWriter{failures: []error{
+		kafkago.LeaderNotAvailable, kafkago.NotEnoughReplicas,
+	}}
+	delivery, waits := newTestDelivery(3)
+	kp := KafkaProto{Writer: writer, Delivery: delivery}
+
+	// WHEN a batch of flows is exported
+	require.NoError(t, kp.Export([]*flow.Record{{Interface: "eth0"}, {Interface: "eth1"}}))
+
+	// THEN the batch is retried with backoff until the writer succeeds
+	assert.Equal(t, 3, writer.attempts)
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, *waits)
+	assert.Len(t, writer.messages, 2)
+	assert.EqualValues(t, 2, counterValue(t, delivery.delivered))
+	assert.Zero(t, counterValue(t, delivery.failed))
+}
+
+func TestKafkaDelivery_RetryFailedMessagesOnly(t *testing.T) {
+	// GIVEN a Kafka writer that fails to write some messages of the batch
+	writer := &flakyWriter{failures: []error{
+		kafkago.WriteErrors{nil, kafkago.RequestTimedOut, nil},
+	}}
+	delivery, _ := newTestDelivery(3)
+	ka := KafkaAvro{Writer: writer, Encoder: &AvroEncoder{}, Delivery: delivery}
+
+	require.NoError(t, ka.Export([]*flow.Record{
+		{Interface: "eth0"}, {Interface: "eth1"}, {Interface: "eth2"},
+	}))
+
+	// THEN only the failed messages are retried, so they aren't duplicated
+	assert.Equal(t, 2, writer.attempts)
+	assert.Len(t, writer.messages, 3)
+	assert.EqualValues(t, 3, counterValue(t, delivery.delivered))
+	assert.Zero(t, counterValue(t, delivery.failed))
+}
+
+func TestKafkaDelivery_RetriesExhausted(t *testing.T) {
+	writer := &flakyWriter{failures: []error{
+		kafkago.LeaderNotAvailable, kafkago.LeaderNotAvailable, kafkago.LeaderNotAvailable,
+	}}
+	delivery, waits := newTestDelivery(2)
+	kp := KafkaProto{Writer: writer, Delivery: delivery}
+
+	err := kp.Export([]*flow.Record{{Interface: "eth0"}})
+	require.ErrorIs(t, err, kafkago.LeaderNotAvailable)
+	assert.Equal(t, 3, writer.attempts)
+	assert.Len(t, *waits, 2)
+	assert.Zero(t, counterValue(t, delivery.delivered))
+	assert.EqualValues(t, 1, counterValue(t, delivery.failed))
+}
+
+func TestKafkaDelivery_MaxBackoff(t *testing.T) {
+	writer := &flakyWriter{failures: []error{
+		kafkago.LeaderNotAvailable, kafkago.LeaderNotAvailable, kafkago.LeaderNotAvailable,
+		kafkago.LeaderNotAvailable,
+	}}
+	delivery, waits := newTestDelivery(4)
+	kp := KafkaProto{Writer: writer, Delivery: delivery}
+
+	require.NoError(t, kp.Export([]*flow.Record{{Interface: "eth0"}}))
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond,
+		300 * time.Millisecond,
+	}, *waits)
+}
+
+func TestKafkaDelivery_Canceled(t *testing.T) {
+	writer := &flakyWriter{failures: []error{kafkago.LeaderNotAvailable}}
+	delivery := NewKafkaDelivery("kafka", 3, time.Hour, time.Hour, metrics.NoOp())
+	kp := KafkaProto{Writer: writer, Delivery: delivery}
+
+	// WHEN the export is canceled while it waits to retry a failed write
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := kp.ExportContext(ctx, []*flow.Record{{Interface: "eth0"}})
+
+	// THEN the export is aborted without waiting for the backoff
+	require.ErrorIs(t, err, kafkago.LeaderNotAvailable)
+	assert.Equal(t, 1, writer.attempts)
+	assert.EqualValues(t, 1, counterValue(t, delivery.failed))
+}
+
+func TestKafkaDelivery_Completion(t *testing.T) {
+	delivery, _ := newTestDelivery(2)
+	delivery.Completion(make([]kafkago.Message, 3), nil)
+	delivery.Completion(make([]kafkago.Message, 2), kafkago.RequestTimedOut)
+	assert.EqualValues(t, 3, counterValue(t, delivery.delivered))
+	assert.EqualValues(t, 2, counterValue(t, delivery.failed))
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	m := dto.Metric{}
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
@@ -2,6 +2,7 @@ package exporter
 
 import (
 	"context"
+	"io"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
 	kafkago "github.com/segmentio/kafka-go"
@@ -15,19 +16,47 @@ type kafkaWriter interface {
 	WriteMessages(ctx context.Context, msgs ...kafkago.Message) error
 }
 
+// closeWriter closes the Kafka writer, if it can be closed
+func closeWriter(w kafkaWriter) error {
+	if closer, ok := w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // KafkaProto exports flows over Kafka, encoded as a protobuf that is understandable by the
 // Flowlogs-Pipeline collector
 type KafkaProto struct {
 	Writer kafkaWriter
+	// TenantTopicPrefix, if not empty, routes each flow to the "<prefix>-<TenantID>" topic (or
+	// to the "<prefix>" topic, if the flow has no tenant). The Writer must not define any topic.
+	TenantTopicPrefix string
+	// Delivery, if not nil, retries the failed writes and accounts the delivered messages
+	Delivery *KafkaDelivery
 }
 
 func (kp *KafkaProto) ExportFlows(input <-chan []*flow.Record) {
 	klog.Info("starting Kafka exporter")
-	for records := range input {
-		kp.batchAndSubmit(records)
-	}
+	Terminal(kp)(input)
+}
+
+// Export encodes the flows as protobuf messages and writes them into Kafka
+func (kp *KafkaProto) Export(records []*flow.Record) error {
+	return kp.batchAndSubmit(context.Background(), records)
+}
+
+// ExportContext works as Export, but the write and its retries are aborted when ctx is done
+func (kp *KafkaProto) ExportContext(ctx context.Context, records []*flow.Record) error {
+	return kp.batchAndSubmit(ctx, records)
 }
 
+func (kp *KafkaProto) Close() error {
+	return closeWriter(kp.Writer)
+}
+
+// ConcurrentSafe marks the exporter as safe for concurrent use, as the Kafka writer is
+func (kp *KafkaProto) ConcurrentSafe() {}
+
 func getFlowKey(record *flow.Record) []byte {
 	// We are sorting IP address so flows from on ip to a second IP get the same key whatever the direction is
 	for k := range record.Id.SrcIp {
@@ -40,7 +69,16 @@ func getFlowKey(record *flow.Record) []byte {
 	return append(record.Id.SrcIp[:], record.Id.DstIp[:]...)
 }
 
-func (kp *KafkaProto) batchAndSubmit(records []*flow.Record) {
+// tenantTopic returns the topic where the flow is routed according to its tenant, or an empty
+// string if the flows are not routed by tenant
+func tenantTopic(prefix string, record *flow.Record) string {
+	if prefix == "" || record.TenantID == "" {
+		return prefix
+	}
+	return prefix + "-" + record.TenantID
+}
+
+func (kp *KafkaProto) batchAndSubmit(ctx context.Context, records []*flow.Record) error {
 	klog.Debugf("sending %d records", len(records))
 	msgs := make([]kafkago.Message, 0, len(records))
 	for _, record := range records {
@@ -49,12 +87,14 @@ func (kp *KafkaProto) batchAndSubmit(records []*flow.Record) {
 			klog.WithError(err).Debug("can't encode protobuf message. Ignoring")
 			continue
 		}
-		msgs = append(msgs, kafkago.Message{Value: pbBytes, Key: getFlowKey(record)})
+		msgs = append(msgs, kafkago.Message{
+			Topic: tenantTopic(kp.TenantTopicPrefix, record),
+			Value: pbBytes,
+			Key:   getFlowKey(record),
+		})
 	}
 
-	if err := kp.Writer.WriteMessages(context.TODO(), msgs...); err != nil {
-		klog.WithError(err).Error("can't write messages into Kafka")
-	}
+	return writeMessages(ctx, kp.Writer, kp.Delivery, msgs)
 }
 
 type JSONRecord struct {
@@ -63,4 +103,8 @@ type JSONRecord struct {
 	TimeFlowEnd     int64
 	TimeFlowStartMs int64
 	TimeFlowEndMs   int64
+	// Protocol is the IANA name of the transport protocol (or its number, if unknown). It is
+	// only set when the protocol names are enabled, and complements the numeric
+	// Id.TransportProtocol field.
+	Protocol string `json:",omitempty"`
 }
@@ -2,10 +2,12 @@ package exporter
 
 import (
 	"context"
+	"encoding/json"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
 	kafkago "github.com/segmentio/kafka-go"
@@ -47,7 +49,23 @@ func TestProtoConversion(t *testing.T) {
 	record.Metrics.Bytes = 789
 	record.Metrics.Packets = 987
 	record.Metrics.Flags = uint16(1)
+	record.Metrics.MinTtl = 60
+	record.Metrics.MaxTtl = 64
+	record.Metrics.FragmentedPackets = 4
+	record.Metrics.DuplicatePackets = 2
+	record.ObservedPorts = []uint16{80, 8080}
+	record.ObservedPortsOverflow = 1
+	record.Metrics.PktSizeBuckets = [4]uint32{5, 0, 7, 1}
 	record.Interface = "veth0"
+	record.Service = "http"
+	record.CgroupID = 4321
+	record.DstHostname = "example.com"
+	record.ConnectionID = 987654
+	record.ClusterID = "cluster-a"
+	record.TenantID = "acme"
+	record.SubFlowCount = 1
+	record.TrafficClass = flow.TrafficClassBroadcast
+	record.MaxIPG = 30 * time.Millisecond
 
 	input <- []*flow.Record{&record}
 	close(input)
@@ -71,6 +89,29 @@ func TestProtoConversion(t *testing.T) {
 	assert.EqualValues(t, 789, r.Bytes)
 	assert.EqualValues(t, 987, r.Packets)
 	assert.EqualValues(t, uint16(1), r.Flags)
+	assert.EqualValues(t, 60, r.MinTtl)
+	assert.EqualValues(t, 64, r.MaxTtl)
+	assert.EqualValues(t, 4, r.FragmentedPackets)
+	assert.EqualValues(t, 2, r.DuplicatePackets)
+	assert.Equal(t, []uint32{80, 8080}, r.ObservedPorts)
+	assert.EqualValues(t, 1, r.ObservedPortsOverflow)
+	assert.Equal(t, []uint32{5, 0, 7, 1}, r.PacketSizeBuckets)
+	// the server connect latency is absent if it wasn't measured
+	assert.Nil(t, r.ServerConnectLatency)
+	assert.Equal(t, pbflow.FlowEndReason_FLOW_END_REASON_EVICTION, r.EndReason)
+	assert.Equal(t, "http", r.Service)
+	assert.EqualValues(t, 4321, r.CgroupId)
+	assert.Equal(t, pbflow.PolicyVerdict_POLICY_VERDICT_UNKNOWN, r.PolicyVerdict)
+	assert.Empty(t, r.SrcHostname)
+	assert.Equal(t, "example.com", r.DstHostname)
+	assert.EqualValues(t, 987654, r.ConnectionId)
+	assert.Equal(t, "cluster-a", r.ClusterId)
+	assert.Equal(t, "acme", r.TenantId)
+	assert.EqualValues(t, 1, r.SubFlowCount)
+	assert.Equal(t, pbflow.TrafficClass_TRAFFIC_CLASS_BROADCAST, r.TrafficClass)
+	// the inter-packet gaps are absent if they are unknown
+	assert.Nil(t, r.MinIpg)
+	assert.Equal(t, 30*time.Millisecond, r.MaxIpg.AsDuration())
 	assert.Equal(t, "veth0", r.Interface)
 	assert.Equal(t, ByteArrayFromNetIP(net.ParseIP("127.3.2.1")), wc.messages[0].Key[0:16])
 	assert.Equal(t, ByteArrayFromNetIP(net.ParseIP("192.1.2.3")), wc.messages[0].Key[16:])
@@ -106,6 +147,21 @@ func TestIdenticalKeys(t *testing.T) {
 
 }
 
+func TestKafkaProto_TenantTopics(t *testing.T) {
+	wc := writerCapturer{}
+	kj := KafkaProto{Writer: &wc, TenantTopicPrefix: "flows"}
+	input := make(chan []*flow.Record, 1)
+	input <- []*flow.Record{{TenantID: "acme"}, {}, {TenantID: "globex"}}
+	close(input)
+	kj.ExportFlows(input)
+
+	require.Len(t, wc.messages, 3)
+	assert.Equal(t, "flows-acme", wc.messages[0].Topic)
+	// flows without tenant are sent to the base topic
+	assert.Equal(t, "flows", wc.messages[1].Topic)
+	assert.Equal(t, "flows-globex", wc.messages[2].Topic)
+}
+
 type writerCapturer struct {
 	messages []kafkago.Message
 }
@@ -114,3 +170,79 @@ func (w *writerCapturer) WriteMessages(_ context.Context, msgs ...kafkago.Messag
 	w.messages = append(w.messages, msgs...)
 	return nil
 }
+
+func TestFlowToPB_PolicyVerdict(t *testing.T) {
+	for verdict, expected := range map[flow.PolicyVerdict]pbflow.PolicyVerdict{
+		flow.PolicyVerdictUnknown: pbflow.PolicyVerdict_POLICY_VERDICT_UNKNOWN,
+		flow.PolicyVerdictAllowed: pbflow.PolicyVerdict_POLICY_VERDICT_ALLOWED,
+		flow.PolicyVerdictDenied:  pbflow.PolicyVerdict_POLICY_VERDICT_DENIED,
+	} {
+		v4 := flow.Record{PolicyVerdict: verdict}
+		v4.Id.EthProtocol = 0x0800
+		v6 := flow.Record{PolicyVerdict: verdict}
+		v6.Id.EthProtocol = flow.IPv6Type
+		assert.Equal(t, expected, flowToPB(&v4).PolicyVerdict)
+		assert.Equal(t, expected, flowToPB(&v6).PolicyVerdict)
+	}
+}
+
+func TestFlowToPB_Tunnel(t *testing.T) {
+	// GIVEN an IPv4 VXLAN flow carrying an IPv6 inner flow
+	vxlan := flow.Record{}
+	vxlan.Id.EthProtocol = 0x0800
+	vxlan.Id.TransportProtocol = 17
+	vxlan.Id.DstPort = 4789
+	vxlan.Id.TunnelType = uint8(flow.TunnelVXLAN)
+	vxlan.Id.TunnelVni = 0x123456
+	copy(vxlan.Id.InnerSrcIp[:], net.ParseIP("fd00::1"))
+	copy(vxlan.Id.InnerDstIp[:], net.ParseIP("fd00::2"))
+	vxlan.Id.InnerSrcPort = 34567
+	vxlan.Id.InnerDstPort = 443
+	vxlan.Id.InnerTransportProtocol = 6
+
+	// THEN the inner flow is reported in the tunnel field
+	tunnel := flowToPB(&vxlan).Tunnel
+	require.NotNil(t, tunnel)
+	assert.Equal(t, pbflow.TunnelType_TUNNEL_TYPE_VXLAN, tunnel.Type)
+	assert.EqualValues(t, 0x123456, tunnel.Vni)
+	assert.Equal(t, []byte(net.ParseIP("fd00::1")), tunnel.InnerNetwork.GetSrcAddr().GetIpv6())
+	assert.Equal(t, []byte(net.ParseIP("fd00::2")), tunnel.InnerNetwork.GetDstAddr().GetIpv6())
+	assert.EqualValues(t, 34567, tunnel.InnerTransport.SrcPort)
+	assert.EqualValues(t, 443, tunnel.InnerTransport.DstPort)
+	assert.EqualValues(t, 6, tunnel.InnerTransport.Protocol)
+
+	// AND a Geneve flow whose inner headers couldn't be parsed only reports the tunnel identifier
+	geneve := flow.Record{}
+	geneve.Id.EthProtocol = flow.IPv6Type
+	geneve.Id.TunnelType = uint8(flow.TunnelGeneve)
+	geneve.Id.TunnelVni = 7
+	tunnel = flowToPB(&geneve).Tunnel
+	require.NotNil(t, tunnel)
+	assert.Equal(t, pbflow.TunnelType_TUNNEL_TYPE_GENEVE, tunnel.Type)
+	assert.EqualValues(t, 7, tunnel.Vni)
+	assert.Nil(t, tunnel.InnerNetwork)
+	assert.Nil(t, tunnel.InnerTransport)
+
+	// AND the tunnel field is absent for the non-tunneled flows
+	plain := flow.Record{}
+	plain.Id.EthProtocol = 0x0800
+	assert.Nil(t, flowToPB(&plain).Tunnel)
+}
+
+func TestSchemaVersion(t *testing.T) {
+	// GIVEN a flow record and a heartbeat created by the agent
+	record := flow.NewRecord(ebpf.BpfFlowId{EthProtocol: 0x0800}, ebpf.BpfFlowMetrics{Packets: 1},
+		time.Now(), 1000)
+	heartbeat := flow.NewHeartbeat(time.Now(), net.ParseIP("10.0.0.1"), "", "")
+
+	for _, r := range []*flow.Record{record, heartbeat} {
+		// THEN the current schema version is stamped on the exported protobuf records
+		assert.EqualValues(t, flow.SchemaVersion, flowToPB(r).SchemaVersion)
+		// AND on the JSON records
+		encoded, err := json.Marshal(r)
+		require.NoError(t, err)
+		decoded := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.EqualValues(t, flow.SchemaVersion, decoded["SchemaVersion"])
+	}
+}
@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+// msgpackRecord is the MessagePack representation of a flow. The record fields are encoded
+// directly, keeping the names and omitempty options of their JSON tags, so they match the JSON
+// records in the pascal field case. The flow times are shadowed by their Unix seconds and
+// milliseconds, as in JSONRecord.
+type msgpackRecord struct {
+	TimeFlowStart   int64
+	TimeFlowEnd     int64
+	TimeFlowStartMs int64
+	TimeFlowEndMs   int64
+	Protocol        string `json:",omitempty"`
+	// it must be the last field, so the fields above shadow the ones of the record
+	flow.Record `msgpack:",inline"`
+}
+
+func encodeMsgpack(jr *JSONRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(enc)
+	enc.Reset(&buf)
+	enc.SetCustomStructTag("json")
+	// sorting the labels keeps the encoding of a given record stable, for the deduplication
+	enc.SetSortMapKeys(true)
+	enc.UseCompactInts(true)
+	if err := enc.Encode(&msgpackRecord{
+		TimeFlowStart:   jr.TimeFlowStart,
+		TimeFlowEnd:     jr.TimeFlowEnd,
+		TimeFlowStartMs: jr.TimeFlowStartMs,
+		TimeFlowEndMs:   jr.TimeFlowEndMs,
+		Protocol:        jr.Protocol,
+		Record:          *jr.Record,
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// msgpackRecords iterates the raw MessagePack records of a stream
+type msgpackRecords struct {
+	dec *msgpack.Decoder
+}
+
+func newMsgpackRecords(r io.Reader) *msgpackRecords {
+	return &msgpackRecords{dec: msgpack.NewDecoder(r)}
+}
+
+// Next returns the bytes of the next record. It returns io.EOF if there are no more records, or
+// io.ErrUnexpectedEOF if the stream ends in the middle of a record.
+func (mr *msgpackRecords) Next() ([]byte, error) {
+	if _, err := mr.dec.PeekCode(); err != nil {
+		return nil, err
+	}
+	raw, err := mr.dec.DecodeRaw()
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return raw, err
+}
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestRecordEncoder_MsgpackRoundTrip(t *testing.T) {
+	record := &flow.Record{
+		TimeFlowStart: time.UnixMilli(1_600_000_000_000),
+		TimeFlowEnd:   time.UnixMilli(1_600_000_001_000),
+		Interface:     "eth0",
+		AgentIP:       net.ParseIP("10.0.0.1"),
+		TCPState:      flow.TCPStateEstablished,
+		MeanIPG:       1500 * time.Microsecond,
+	}
+	record.Id.SrcPort = 1234
+	record.Id.DstPort = 443
+	record.Id.IfIndex = 3
+	record.Id.TransportProtocol = 6
+	copy(record.Id.SrcIp[:], net.ParseIP("10.0.0.1").To16())
+	copy(record.Id.DstIp[:], net.ParseIP("10.0.0.2").To16())
+	record.Id.SrcMac = [6]uint8{0x1, 0x2, 0x3, 0x4, 0x5, 0x6}
+	record.Metrics.Bytes = 5_000_000_000
+	record.Metrics.Packets = 300
+	record.Metrics.MinTtl = 64
+
+	jsonEncoder, err := NewRecordEncoder(EncodingJSON, FieldCasePascal, false)
+	require.NoError(t, err)
+	msgpackEncoder, err := NewRecordEncoder(EncodingMsgpack, FieldCasePascal, false)
+	require.NoError(t, err)
+	jsonDoc, err := jsonEncoder.Encode(record)
+	require.NoError(t, err)
+	encoded, err := msgpackEncoder.Encode(record)
+	require.NoError(t, err)
+
+	// the MessagePack encoding is more compact
+	assert.Less(t, len(encoded), len(jsonDoc))
+
+	// and it provides the same fields as the JSON encoding
+	jsonFields := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(jsonDoc, &jsonFields))
+	fields := decodeMsgpack(t, encoded)
+	assert.Equal(t, mapKeys(jsonFields), mapKeys(fields))
+	id := fields["Id"].(map[string]interface{})
+	assert.EqualValues(t, 1234, id["SrcPort"])
+	assert.EqualValues(t, 6, id["TransportProtocol"])
+	metrics := fields["Metrics"].(map[string]interface{})
+	assert.EqualValues(t, 5_000_000_000, metrics["Bytes"])
+	assert.Equal(t, "eth0", fields["Interface"])
+	assert.Equal(t, "10.0.0.1", fields["AgentIP"])
+	assert.Equal(t, "ESTABLISHED", fields["TCPState"])
+	assert.EqualValues(t, 1500*time.Microsecond, fields["MeanIPG"])
+	assert.EqualValues(t, 1_600_000_000, fields["TimeFlowStart"])
+	assert.EqualValues(t, 1_600_000_000_000, fields["TimeFlowStartMs"])
+
+	// and the labels are sorted, so the encoding of a record is stable
+	record.SrcLabels = map[string]string{"a": "1", "b": "2", "c": "3", "d": "4"}
+	first := mustEncode(t, msgpackEncoder, record)
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, mustEncode(t, msgpackEncoder, record))
+	}
+}
+
+func TestMsgpackRecords_Truncated(t *testing.T) {
+	encoder, err := NewRecordEncoder(EncodingMsgpack, FieldCasePascal, false)
+	require.NoError(t, err)
+	encoded := mustEncode(t, encoder, &flow.Record{Interface: "eth0"})
+	stream := append(append([]byte{}, encoded...), encoded[:len(encoded)-1]...)
+
+	records := newMsgpackRecords(bytes.NewReader(stream))
+	raw, err := records.Next()
+	require.NoError(t, err)
+	assert.Equal(t, encoded, raw)
+	_, err = records.Next()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	_, err = newMsgpackRecords(bytes.NewReader(nil)).Next()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestNewRecordEncoder_Invalid(t *testing.T) {
+	_, err := NewRecordEncoder("cbor", FieldCasePascal, false)
+	assert.Error(t, err)
+	_, err = NewRecordEncoder(EncodingMsgpack, "kebab", false)
+	assert.Error(t, err)
+}
+
+func TestFileJSON_MsgpackDedup(t *testing.T) {
+	file := path.Join(t.TempDir(), "flows.msgpack")
+	record := func(srcPort uint16) *flow.Record {
+		r := &flow.Record{}
+		r.Id.SrcPort = srcPort
+		return r
+	}
+
+	// GIVEN a file exporter that writes MessagePack records, with deduplication
+	fe, err := StartFileJSON(file, 2, EncodingMsgpack, FieldCasePascal, false, SinkCompressionNone)
+	require.NoError(t, err)
+	input := make(chan []*flow.Record, 10)
+	input <- []*flow.Record{record(1), record(2), record(1)}
+	close(input)
+	fe.ExportFlows(input)
+	assert.Equal(t, []int64{1, 2}, readMsgpackSrcPorts(t, file))
+
+	// WHEN the agent restarts after having written an incomplete record
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x81, 0xa2, 'I'})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	fe, err = StartFileJSON(file, 2, EncodingMsgpack, FieldCasePascal, false, SinkCompressionNone)
+	require.NoError(t, err)
+
+	// THEN the dedup window is loaded from the complete records
+	assert.False(t, fe.dedup.add(mustEncode(t, fe.encoder, record(2))))
+	assert.True(t, fe.dedup.add(mustEncode(t, fe.encoder, record(3))))
+}
+
+func mustEncode(t *testing.T, encoder *RecordEncoder, record *flow.Record) []byte {
+	t.Helper()
+	encoded, err := encoder.Encode(record)
+	require.NoError(t, err)
+	return encoded
+}
+
+func decodeMsgpack(t *testing.T, encoded []byte) map[string]interface{} {
+	t.Helper()
+	dec := msgpack.NewDecoder(bytes.NewReader(encoded))
+	dec.UseLooseInterfaceDecoding(true)
+	fields := map[string]interface{}{}
+	require.NoError(t, dec.Decode(&fields))
+	return fields
+}
+
+func mapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func readMsgpackSrcPorts(t *testing.T, file string) []int64 {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	records := newMsgpackRecords(f)
+	var ports []int64
+	for {
+		raw, err := records.Next()
+		if errors.Is(err, io.EOF) {
+			return ports
+		}
+		require.NoError(t, err)
+		id := decodeMsgpack(t, raw)["Id"].(map[string]interface{})
+		ports = append(ports, id["SrcPort"].(int64))
+	}
+}
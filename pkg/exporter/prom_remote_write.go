@@ -0,0 +1,273 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+var prwlog = logrus.WithField("component", "exporter/PromRemoteWrite")
+
+const (
+	promBytesMetric   = "netobserv_flow_bytes_total"
+	promPacketsMetric = "netobserv_flow_packets_total"
+	// promOverflowValue replaces the values of all the labels of the flows that don't fit in the
+	// maximum number of series
+	promOverflowValue = "_other"
+)
+
+// PromRemoteWriteLabelFields maps the names of the flow fields that can be used as labels of the
+// remote-write time series to the functions extracting their values from the flow records.
+// They are the same fields that can be used as StatsD tags.
+var PromRemoteWriteLabelFields = StatsDTagFields
+
+// PromRemoteWrite exporter accumulates the bytes and packets of the flows into Prometheus
+// counters, broken down by the configured labels, and periodically submits them to a
+// Prometheus remote-write endpoint. The number of label combinations is bounded: once the
+// maximum number of series is reached, the flows with new label values are accounted in an
+// overflow series whose labels have the "_other" value.
+type PromRemoteWrite struct {
+	client   *http.Client
+	endpoint string
+	labels   []string
+	labelFn  []func(*flow.Record) string
+	max      int
+	clock    func() time.Time
+
+	mt     sync.Mutex
+	series map[string]*promSeries
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// promSeries accumulates the counters of the flows sharing the same label values
+type promSeries struct {
+	values  []string
+	bytes   float64
+	packets float64
+}
+
+// StartPromRemoteWrite creates a PromRemoteWrite exporter that submits the counters to the
+// provided endpoint URL each interval. The counters are labeled with the provided flow fields
+// (see PromRemoteWriteLabelFields for the accepted values).
+func StartPromRemoteWrite(
+	client *http.Client, endpoint string, interval time.Duration, labels []string, maxSeries int,
+) (*PromRemoteWrite, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote-write URL %q: %w", endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote-write URL %q: it must be an absolute http(s) URL",
+			endpoint)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid remote-write interval: %s", interval)
+	}
+	if maxSeries <= 0 {
+		return nil, fmt.Errorf("invalid remote-write maximum series: %d", maxSeries)
+	}
+	prw := &PromRemoteWrite{
+		client:   client,
+		endpoint: endpoint,
+		max:      maxSeries,
+		clock:    time.Now,
+		series:   map[string]*promSeries{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		lf, ok := PromRemoteWriteLabelFields[label]
+		if !ok {
+			return nil, fmt.Errorf("unknown remote-write label %q", label)
+		}
+		prw.labels = append(prw.labels, label)
+		prw.labelFn = append(prw.labelFn, lf)
+	}
+	go prw.flushLoop(interval)
+	return prw, nil
+}
+
+// Export accumulates the metrics of the flows into the counters, to be submitted in the
+// next remote-write request
+func (p *PromRemoteWrite) Export(records []*flow.Record) error {
+	values := make([]string, len(p.labelFn))
+	key := strings.Builder{}
+	p.mt.Lock()
+	defer p.mt.Unlock()
+	for _, record := range records {
+		if record.IsHeartbeat() {
+			continue
+		}
+		key.Reset()
+		for i, lf := range p.labelFn {
+			values[i] = lf(record)
+			key.WriteString(values[i])
+			key.WriteByte(0)
+		}
+		s, ok := p.series[key.String()]
+		if !ok {
+			s = p.newSeries(key.String(), values)
+		}
+		s.bytes += float64(record.Metrics.Bytes)
+		s.packets += float64(record.Metrics.Packets)
+	}
+	return nil
+}
+
+// newSeries adds a new series for the provided label values, or returns the overflow series
+// if the maximum number of series has been reached
+func (p *PromRemoteWrite) newSeries(key string, values []string) *promSeries {
+	if len(p.series) >= p.max {
+		key = strings.Repeat(promOverflowValue+"\x00", len(values))
+		if s, ok := p.series[key]; ok {
+			return s
+		}
+		values = make([]string, len(values))
+		for i := range values {
+			values[i] = promOverflowValue
+		}
+	}
+	s := &promSeries{values: append([]string{}, values...)}
+	p.series[key] = s
+	return s
+}
+
+// Close submits the last values of the counters and stops the exporter
+func (p *PromRemoteWrite) Close() error {
+	close(p.stop)
+	<-p.done
+	return p.flush()
+}
+
+// ConcurrentSafe marks the exporter as safe for concurrent use, as the counters are protected
+// by a mutex
+func (p *PromRemoteWrite) ConcurrentSafe() {}
+
+func (p *PromRemoteWrite) flushLoop(interval time.Duration) {
+	defer close(p.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.flush(); err != nil {
+				prwlog.WithError(err).Error("can't submit flow metrics")
+			}
+		}
+	}
+}
+
+// flush submits the current value of all the counters
+func (p *PromRemoteWrite) flush() error {
+	body := p.writeRequest()
+	if body == nil {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, p.endpoint,
+		bytes.NewReader(snappy.Encode(nil, body)))
+	if err != nil {
+		return fmt.Errorf("creating remote-write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("submitting remote-write request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("remote-write endpoint returned %s: %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// writeRequest returns the protobuf-encoded remote-write WriteRequest message with the current
+// value of the counters, or nil if there are no counters yet
+func (p *PromRemoteWrite) writeRequest() []byte {
+	p.mt.Lock()
+	defer p.mt.Unlock()
+	if len(p.series) == 0 {
+		return nil
+	}
+	// sorting the series for a deterministic output
+	keys := make([]string, 0, len(p.series))
+	for k := range p.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	// the labels of each series must be sorted by name
+	order := make([]int, len(p.labels))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return p.labels[order[i]] < p.labels[order[j]] })
+
+	timestamp := p.clock().UnixMilli()
+	var msg []byte
+	for _, k := range keys {
+		s := p.series[k]
+		msg = p.appendTimeSeries(msg, promBytesMetric, s, order, s.bytes, timestamp)
+		msg = p.appendTimeSeries(msg, promPacketsMetric, s, order, s.packets, timestamp)
+	}
+	return msg
+}
+
+// appendTimeSeries appends a TimeSeries message, as the field 1 of the WriteRequest message
+func (p *PromRemoteWrite) appendTimeSeries(
+	msg []byte, name string, s *promSeries, order []int, value float64, timestamp int64,
+) []byte {
+	var ts []byte
+	ts = appendPromLabel(ts, "__name__", name)
+	for _, i := range order {
+		// Prometheus considers a label with an empty value as if it were missing
+		if s.values[i] != "" {
+			ts = appendPromLabel(ts, p.labels[i], s.values[i])
+		}
+	}
+	// Sample message: value (1) and timestamp (2)
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, uint64(timestamp))
+	ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+	ts = protowire.AppendBytes(ts, sample)
+
+	msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+	return protowire.AppendBytes(msg, ts)
+}
+
+// appendPromLabel appends a Label message, as the field 1 of the TimeSeries message
+func appendPromLabel(ts []byte, name, value string) []byte {
+	var label []byte
+	label = protowire.AppendTag(label, 1, protowire.BytesType)
+	label = protowire.AppendString(label, name)
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, value)
+	ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+	return protowire.AppendBytes(ts, label)
+}
@@ -0,0 +1,185 @@
+package exporter
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+// promTestSeries is the decoded form of a remote-write TimeSeries message
+type promTestSeries struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+}
+
+func TestPromRemoteWrite(t *testing.T) {
+	// GIVEN a remote-write endpoint
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		requests <- req
+		bodies <- body
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// AND a remote-write exporter that labels the counters by interface and protocol
+	prw, err := StartPromRemoteWrite(server.Client(), server.URL+"/api/v1/write", time.Hour,
+		[]string{"protocol", "interface"}, 100)
+	require.NoError(t, err)
+	prw.clock = func() time.Time { return time.UnixMilli(1234567) }
+
+	record := func(iface string, proto uint8, bytes uint64, packets uint32) *flow.Record {
+		r := &flow.Record{Interface: iface}
+		r.Id.TransportProtocol = proto
+		r.Metrics.Bytes = bytes
+		r.Metrics.Packets = packets
+		return r
+	}
+	// WHEN it exports flows
+	require.NoError(t, prw.Export([]*flow.Record{
+		record("eth0", 6, 100, 2),
+		record("eth0", 6, 200, 3),
+		record("eth1", 17, 60, 1),
+	}))
+	require.NoError(t, prw.Export([]*flow.Record{record("eth0", 6, 1000, 10)}))
+	// AND the exporter is closed before the window ends
+	require.NoError(t, prw.Close())
+
+	// THEN a valid remote-write request is submitted with the accumulated counters
+	req := <-requests
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, "/api/v1/write", req.URL.Path)
+	assert.Equal(t, "snappy", req.Header.Get("Content-Encoding"))
+	assert.Equal(t, "application/x-protobuf", req.Header.Get("Content-Type"))
+	assert.Equal(t, "0.1.0", req.Header.Get("X-Prometheus-Remote-Write-Version"))
+
+	body, err := snappy.Decode(nil, <-bodies)
+	require.NoError(t, err)
+	series := decodeWriteRequest(t, body)
+	assert.Equal(t, []promTestSeries{{
+		labels:    map[string]string{"__name__": promBytesMetric, "interface": "eth0", "protocol": "tcp"},
+		value:     1300,
+		timestamp: 1234567,
+	}, {
+		labels:    map[string]string{"__name__": promPacketsMetric, "interface": "eth0", "protocol": "tcp"},
+		value:     15,
+		timestamp: 1234567,
+	}, {
+		labels:    map[string]string{"__name__": promBytesMetric, "interface": "eth1", "protocol": "udp"},
+		value:     60,
+		timestamp: 1234567,
+	}, {
+		labels:    map[string]string{"__name__": promPacketsMetric, "interface": "eth1", "protocol": "udp"},
+		value:     1,
+		timestamp: 1234567,
+	}}, series)
+}
+
+func TestPromRemoteWrite_MaxSeries(t *testing.T) {
+	prw, err := StartPromRemoteWrite(http.DefaultClient, "http://localhost:9090/api/v1/write",
+		time.Hour, []string{"interface"}, 2)
+	require.NoError(t, err)
+	defer close(prw.stop)
+
+	for _, iface := range []string{"eth0", "eth1", "eth2", "eth3", "eth0"} {
+		r := &flow.Record{Interface: iface}
+		r.Metrics.Bytes = 10
+		require.NoError(t, prw.Export([]*flow.Record{r}))
+	}
+	series := decodeWriteRequest(t, prw.writeRequest())
+	bytesByIface := map[string]float64{}
+	for _, s := range series {
+		if s.labels["__name__"] == promBytesMetric {
+			bytesByIface[s.labels["interface"]] = s.value
+		}
+	}
+	assert.Equal(t, map[string]float64{"eth0": 20, "eth1": 10, "_other": 20}, bytesByIface)
+}
+
+func TestPromRemoteWrite_InvalidConfig(t *testing.T) {
+	for _, endpoint := range []string{"", "localhost:9090", "/api/v1/write", "ftp://host/write", "http://%zz"} {
+		_, err := StartPromRemoteWrite(http.DefaultClient, endpoint, time.Minute, nil, 10)
+		assert.Errorf(t, err, "URL %q should be rejected", endpoint)
+	}
+	_, err := StartPromRemoteWrite(http.DefaultClient, "http://localhost:9090/api/v1/write",
+		time.Minute, []string{"foo"}, 10)
+	assert.Error(t, err)
+}
+
+// decodeWriteRequest decodes a remote-write WriteRequest protobuf message
+func decodeWriteRequest(t *testing.T, msg []byte) []promTestSeries {
+	var series []promTestSeries
+	forEachField(t, msg, func(num protowire.Number, _ protowire.Type, tsMsg []byte) {
+		require.EqualValues(t, 1, num, "unexpected WriteRequest field")
+		s := promTestSeries{labels: map[string]string{}}
+		forEachField(t, tsMsg, func(num protowire.Number, _ protowire.Type, field []byte) {
+			switch num {
+			case 1:
+				var name, value string
+				forEachField(t, field, func(num protowire.Number, _ protowire.Type, v []byte) {
+					if num == 1 {
+						name = string(v)
+					} else {
+						value = string(v)
+					}
+				})
+				s.labels[name] = value
+			case 2:
+				forEachField(t, field, func(num protowire.Number, typ protowire.Type, v []byte) {
+					if num == 1 {
+						require.Equal(t, protowire.Fixed64Type, typ)
+						bits, n := protowire.ConsumeFixed64(v)
+						require.Positive(t, n)
+						s.value = math.Float64frombits(bits)
+					} else {
+						require.Equal(t, protowire.VarintType, typ)
+						ts, n := protowire.ConsumeVarint(v)
+						require.Positive(t, n)
+						s.timestamp = int64(ts)
+					}
+				})
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", num)
+			}
+		})
+		series = append(series, s)
+	})
+	return series
+}
+
+// forEachField invokes the provided function for each field of a protobuf message. The length
+// prefix is removed from the bytes fields.
+func forEachField(t *testing.T, msg []byte, fn func(protowire.Number, protowire.Type, []byte)) {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		require.Positive(t, n)
+		msg = msg[n:]
+		fn(num, typ, fieldValue(t, typ, msg))
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		require.Positive(t, n)
+		msg = msg[n:]
+	}
+}
+
+func fieldValue(t *testing.T, typ protowire.Type, msg []byte) []byte {
+	if typ == protowire.BytesType {
+		v, n := protowire.ConsumeBytes(msg)
+		require.Positive(t, n)
+		return v
+	}
+	return msg
+}
@@ -3,9 +3,11 @@ package exporter
 import (
 	"encoding/binary"
 	"net"
+	"time"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/pbflow"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -67,11 +69,59 @@ func v4FlowToPB(fr *flow.Record) *pbflow.Record {
 			Seconds: fr.TimeFlowEnd.Unix(),
 			Nanos:   int32(fr.TimeFlowEnd.Nanosecond()),
 		},
-		Packets:   uint64(fr.Metrics.Packets),
-		Duplicate: fr.Duplicate,
-		AgentIp:   agentIP(fr.AgentIP),
-		Flags:     uint32(fr.Metrics.Flags),
-		Interface: string(fr.Interface),
+		Packets:               uint64(fr.Metrics.Packets),
+		Duplicate:             fr.Duplicate,
+		AgentIp:               ipToPB(fr.AgentIP),
+		Flags:                 uint32(fr.Metrics.Flags),
+		Interface:             string(fr.Interface),
+		PayloadSample:         fr.PayloadSample,
+		TcpState:              pbflow.TCPState(fr.TCPState),
+		MinTtl:                uint32(fr.Metrics.MinTtl),
+		MaxTtl:                uint32(fr.Metrics.MaxTtl),
+		FragmentedPackets:     fr.Metrics.FragmentedPackets,
+		PacketSizeBuckets:     fr.Metrics.PktSizeBuckets[:],
+		ServerConnectLatency:  serverConnectLatency(fr),
+		EndReason:             pbflow.FlowEndReason(fr.EndReason),
+		Service:               fr.Service,
+		CgroupId:              fr.CgroupID,
+		PolicyVerdict:         pbflow.PolicyVerdict(fr.PolicyVerdict),
+		SrcHostname:           fr.SrcHostname,
+		DstHostname:           fr.DstHostname,
+		ConnectionId:          fr.ConnectionID,
+		ClusterId:             fr.ClusterID,
+		TenantId:              fr.TenantID,
+		SubFlowCount:          fr.SubFlowCount,
+		TrafficClass:          pbflow.TrafficClass(fr.TrafficClass),
+		MinIpg:                optionalDuration(fr.MinIPG),
+		MaxIpg:                optionalDuration(fr.MaxIPG),
+		MeanIpg:               optionalDuration(fr.MeanIPG),
+		SrcLabels:             fr.SrcLabels,
+		DstLabels:             fr.DstLabels,
+		EchoRtt:               optionalDuration(fr.EchoRTT),
+		EchoReplyPackets:      fr.EchoReplyPackets,
+		EchoReplyBytes:        fr.EchoReplyBytes,
+		AgentVersion:          fr.AgentVersion,
+		SchemaVersion:         fr.SchemaVersion,
+		ScanSuspect:           fr.ScanSuspect,
+		Pid:                   fr.PID,
+		Comm:                  fr.Comm,
+		RecordId:              fr.RecordID,
+		NextHop:               nextHopToPB(fr),
+		Quic:                  quicToPB(fr),
+		DuplicatePackets:      fr.Metrics.DuplicatePackets,
+		ObservedPorts:         portsToPB(fr.ObservedPorts),
+		ObservedPortsOverflow: fr.ObservedPortsOverflow,
+		ContainerId:           fr.ContainerID,
+		PodUid:                fr.PodUID,
+		SrcNamespace:          fr.SrcNamespace,
+		SrcPod:                fr.SrcPod,
+		DstNamespace:          fr.DstNamespace,
+		DstPod:                fr.DstPod,
+		SrcCountry:            fr.SrcCountry,
+		DstCountry:            fr.DstCountry,
+		BpfProgHash:           fr.BpfProgHash,
+		InterfaceId:           fr.InterfaceID,
+		Tunnel:                tunnelToPB(fr),
 	}
 }
 
@@ -105,14 +155,135 @@ func v6FlowToPB(fr *flow.Record) *pbflow.Record {
 			Seconds: fr.TimeFlowEnd.Unix(),
 			Nanos:   int32(fr.TimeFlowEnd.Nanosecond()),
 		},
-		Packets:   uint64(fr.Metrics.Packets),
-		Flags:     uint32(fr.Metrics.Flags),
-		Interface: fr.Interface,
-		Duplicate: fr.Duplicate,
-		AgentIp:   agentIP(fr.AgentIP),
+		Packets:               uint64(fr.Metrics.Packets),
+		Flags:                 uint32(fr.Metrics.Flags),
+		Interface:             fr.Interface,
+		PayloadSample:         fr.PayloadSample,
+		TcpState:              pbflow.TCPState(fr.TCPState),
+		MinTtl:                uint32(fr.Metrics.MinTtl),
+		MaxTtl:                uint32(fr.Metrics.MaxTtl),
+		FragmentedPackets:     fr.Metrics.FragmentedPackets,
+		PacketSizeBuckets:     fr.Metrics.PktSizeBuckets[:],
+		ServerConnectLatency:  serverConnectLatency(fr),
+		EndReason:             pbflow.FlowEndReason(fr.EndReason),
+		Service:               fr.Service,
+		CgroupId:              fr.CgroupID,
+		PolicyVerdict:         pbflow.PolicyVerdict(fr.PolicyVerdict),
+		SrcHostname:           fr.SrcHostname,
+		DstHostname:           fr.DstHostname,
+		ConnectionId:          fr.ConnectionID,
+		ClusterId:             fr.ClusterID,
+		TenantId:              fr.TenantID,
+		SubFlowCount:          fr.SubFlowCount,
+		TrafficClass:          pbflow.TrafficClass(fr.TrafficClass),
+		MinIpg:                optionalDuration(fr.MinIPG),
+		MaxIpg:                optionalDuration(fr.MaxIPG),
+		MeanIpg:               optionalDuration(fr.MeanIPG),
+		SrcLabels:             fr.SrcLabels,
+		DstLabels:             fr.DstLabels,
+		EchoRtt:               optionalDuration(fr.EchoRTT),
+		EchoReplyPackets:      fr.EchoReplyPackets,
+		EchoReplyBytes:        fr.EchoReplyBytes,
+		AgentVersion:          fr.AgentVersion,
+		SchemaVersion:         fr.SchemaVersion,
+		ScanSuspect:           fr.ScanSuspect,
+		Pid:                   fr.PID,
+		Comm:                  fr.Comm,
+		RecordId:              fr.RecordID,
+		NextHop:               nextHopToPB(fr),
+		Quic:                  quicToPB(fr),
+		DuplicatePackets:      fr.Metrics.DuplicatePackets,
+		ObservedPorts:         portsToPB(fr.ObservedPorts),
+		ObservedPortsOverflow: fr.ObservedPortsOverflow,
+		ContainerId:           fr.ContainerID,
+		PodUid:                fr.PodUID,
+		SrcNamespace:          fr.SrcNamespace,
+		SrcPod:                fr.SrcPod,
+		DstNamespace:          fr.DstNamespace,
+		DstPod:                fr.DstPod,
+		SrcCountry:            fr.SrcCountry,
+		DstCountry:            fr.DstCountry,
+		BpfProgHash:           fr.BpfProgHash,
+		InterfaceId:           fr.InterfaceID,
+		Tunnel:                tunnelToPB(fr),
+		Duplicate:             fr.Duplicate,
+		AgentIp:               ipToPB(fr.AgentIP),
 	}
 }
 
+// tunnelToPB returns nil for the non-tunneled flows, so the field is absent in the protobuf
+// message
+func tunnelToPB(fr *flow.Record) *pbflow.Tunnel {
+	if fr.Tunnel() == flow.TunnelNone {
+		return nil
+	}
+	tunnel := &pbflow.Tunnel{
+		Type: pbflow.TunnelType(fr.Id.TunnelType),
+		Vni:  fr.Id.TunnelVni,
+	}
+	if fr.Id.InnerSrcIp != (flow.IPAddr{}) || fr.Id.InnerDstIp != (flow.IPAddr{}) {
+		tunnel.InnerNetwork = &pbflow.Network{
+			SrcAddr: ipToPB(flow.IP(fr.Id.InnerSrcIp)),
+			DstAddr: ipToPB(flow.IP(fr.Id.InnerDstIp)),
+		}
+		tunnel.InnerTransport = &pbflow.Transport{
+			Protocol: uint32(fr.Id.InnerTransportProtocol),
+			SrcPort:  uint32(fr.Id.InnerSrcPort),
+			DstPort:  uint32(fr.Id.InnerDstPort),
+		}
+	}
+	return tunnel
+}
+
+// nextHopToPB returns nil if the next hop is unknown, so the field is absent in the protobuf
+// message
+func nextHopToPB(fr *flow.Record) *pbflow.IP {
+	if fr.NextHop == nil {
+		return nil
+	}
+	return ipToPB(fr.NextHop)
+}
+
+// quicToPB returns nil for the flows that aren't recognized as QUIC, so the field is absent in
+// the protobuf message
+func quicToPB(fr *flow.Record) *pbflow.Quic {
+	if fr.QUICVersion == 0 {
+		return nil
+	}
+	return &pbflow.Quic{
+		Version: fr.QUICVersion,
+		Dcid:    fr.QUICDCID,
+		Scid:    fr.QUICSCID,
+	}
+}
+
+// portsToPB widens the ports to the protobuf integer type. It returns nil for no ports, so the
+// field is absent in the protobuf message
+func portsToPB(ports []uint16) []uint32 {
+	if len(ports) == 0 {
+		return nil
+	}
+	pb := make([]uint32, len(ports))
+	for i, port := range ports {
+		pb[i] = uint32(port)
+	}
+	return pb
+}
+
+// serverConnectLatency returns nil if the latency hasn't been measured for the flow, so the
+// field is absent in the protobuf message
+func serverConnectLatency(fr *flow.Record) *durationpb.Duration {
+	return optionalDuration(fr.ServerConnectLatency)
+}
+
+// optionalDuration returns nil for zero durations, so they are absent from the messages
+func optionalDuration(d time.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationpb.New(d)
+}
+
 // Mac bytes are encoded in the same order as in the array. This is, a Mac
 // like 11:22:33:44:55:66 will be encoded as 0x112233445566
 func macToUint64(m *[flow.MacLen]uint8) uint64 {
@@ -124,7 +295,7 @@ func macToUint64(m *[flow.MacLen]uint8) uint64 {
 		(uint64(m[0]) << 40)
 }
 
-func agentIP(nip net.IP) *pbflow.IP {
+func ipToPB(nip net.IP) *pbflow.IP {
 	if ip := nip.To4(); ip != nil {
 		return &pbflow.IP{IpFamily: &pbflow.IP_Ipv4{Ipv4: binary.BigEndian.Uint32(ip)}}
 	}
@@ -0,0 +1,49 @@
+package exporter
+
+import "strconv"
+
+// ianaProtocols maps the most common IP protocol numbers to their lowercase keywords, as
+// assigned by the IANA Protocol Numbers registry. ICMP for IPv6 keeps the widely used
+// "icmpv6" name instead of the registry's "ipv6-icmp" keyword.
+var ianaProtocols = map[uint8]string{
+	1:   "icmp",
+	2:   "igmp",
+	4:   "ipv4",
+	6:   "tcp",
+	17:  "udp",
+	27:  "rdp",
+	33:  "dccp",
+	41:  "ipv6",
+	43:  "ipv6-route",
+	44:  "ipv6-frag",
+	46:  "rsvp",
+	47:  "gre",
+	50:  "esp",
+	51:  "ah",
+	58:  "icmpv6",
+	59:  "ipv6-nonxt",
+	60:  "ipv6-opts",
+	88:  "eigrp",
+	89:  "ospf",
+	94:  "ipip",
+	97:  "etherip",
+	98:  "encap",
+	103: "pim",
+	108: "ipcomp",
+	112: "vrrp",
+	115: "l2tp",
+	132: "sctp",
+	135: "mobility-header",
+	136: "udplite",
+	137: "mpls-in-ip",
+	143: "ethernet",
+}
+
+// protocolName returns the IANA keyword of the provided IP protocol, or its decimal number if
+// the protocol is unknown
+func protocolName(proto uint8) string {
+	if name, ok := ianaProtocols[proto]; ok {
+		return name
+	}
+	return strconv.Itoa(int(proto))
+}
@@ -0,0 +1,52 @@
+package exporter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestRecordEncoder_ProtocolNames(t *testing.T) {
+	encoder, err := NewRecordEncoder(EncodingJSON, FieldCaseSnake, true)
+	require.NoError(t, err)
+	for _, tc := range []struct {
+		proto    uint8
+		expected string
+	}{
+		{proto: 1, expected: "icmp"},
+		{proto: 6, expected: "tcp"},
+		{proto: 17, expected: "udp"},
+		{proto: 47, expected: "gre"},
+		{proto: 50, expected: "esp"},
+		{proto: 58, expected: "icmpv6"},
+		{proto: 132, expected: "sctp"},
+		{proto: 253, expected: "253"},
+	} {
+		t.Run(tc.expected, func(t *testing.T) {
+			record := &flow.Record{}
+			record.Id.TransportProtocol = tc.proto
+			encoded, err := encoder.Encode(record)
+			require.NoError(t, err)
+			var decoded map[string]interface{}
+			require.NoError(t, json.Unmarshal(encoded, &decoded))
+			assert.Equal(t, tc.expected, decoded["protocol"])
+			// the numeric field is kept for the machine consumers
+			assert.EqualValues(t, tc.proto, decoded["id"].(map[string]interface{})["transport_protocol"])
+		})
+	}
+}
+
+func TestRecordEncoder_ProtocolNamesDisabled(t *testing.T) {
+	encoder, err := NewRecordEncoder(EncodingJSON, FieldCasePascal, false)
+	require.NoError(t, err)
+	record := &flow.Record{}
+	record.Id.TransportProtocol = 6
+	encoded, err := encoder.Encode(record)
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), `"Protocol"`)
+	assert.Contains(t, string(encoded), `"TransportProtocol":6`)
+}
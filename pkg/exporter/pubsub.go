@@ -0,0 +1,300 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var pslog = logrus.WithField("component", "exporter/PubSub")
+
+const (
+	// PubSubEndpoint is the default endpoint of the Google Pub/Sub API
+	PubSubEndpoint = "https://pubsub.googleapis.com"
+	// PubSubScope is the OAuth2 scope that is required to publish in Pub/Sub
+	PubSubScope = "https://www.googleapis.com/auth/pubsub"
+
+	// limits of the publish requests of the Pub/Sub API. The size is kept below the 10MB limit to
+	// leave room for the JSON envelope of the messages.
+	pubsubMaxBatchSize    = 1000
+	pubsubMaxRequestBytes = 9 * 1024 * 1024
+	// pubsubMessageOverhead estimates the bytes of the JSON envelope of each published message
+	pubsubMessageOverhead = 64
+)
+
+// PubSubConfig configures the Google Pub/Sub exporter
+type PubSubConfig struct {
+	// Endpoint is the base URL of the Pub/Sub API (e.g. PubSubEndpoint, or the URL of an emulator)
+	Endpoint string
+	// Project and Topic identify the topic where the flows are published
+	Project string
+	Topic   string
+	// OrderingKey is the name of the flow field whose value is the ordering key of the messages
+	// (see StatsDTagFields for the accepted names). If empty, the messages have no ordering key.
+	OrderingKey string
+	// BatchSize is the maximum number of flows that are published in a single request
+	BatchSize int
+	// Retries is the number of times that a failed publish request is submitted again, waiting
+	// RetryBackoff before the first retry, and doubling it in each retry
+	Retries      int
+	RetryBackoff time.Duration
+	// BufferLength is the maximum number of flows that are kept, after all the retries failed, to
+	// be published again in the next export. When it is exceeded, the oldest flows are discarded.
+	BufferLength int
+	// FieldCase is the naming convention of the fields of the JSON messages (see NewJSONMarshaler)
+	FieldCase string
+	// ProtocolNames adds the IANA name of the transport protocol to the messages
+	ProtocolNames bool
+}
+
+// PubSub exporter publishes the flows, as JSON messages, to a Google Pub/Sub topic through its
+// REST API. The provided HTTP client is responsible for the authentication (e.g. through an
+// oauth2.Transport).
+type PubSub struct {
+	client      *http.Client
+	cfg         PubSubConfig
+	topicURL    string
+	marshaler   *JSONMarshaler
+	orderingKey func(*flow.Record) string
+	published   prometheus.Counter
+	failed      prometheus.Counter
+	sleep       func(time.Duration)
+
+	// pending holds the messages that couldn't be published in previous exports
+	pendingMt sync.Mutex
+	pending   []pubsubMessage
+}
+
+type pubsubMessage struct {
+	// Data is encoded as base64 by the JSON marshaler, as required by the API
+	Data        []byte `json:"data"`
+	OrderingKey string `json:"orderingKey,omitempty"`
+}
+
+type pubsubPublishRequest struct {
+	Messages []pubsubMessage `json:"messages"`
+}
+
+type pubsubPublishResponse struct {
+	MessageIDs []string `json:"messageIds"`
+}
+
+// StartPubSub creates a Pub/Sub exporter. It validates the configuration and verifies that the
+// topic exists and is accessible with the credentials of the provided client. The metrics are
+// labeled by the provided exporter name.
+func StartPubSub(
+	client *http.Client, name string, cfg *PubSubConfig, m *metrics.Metrics,
+) (*PubSub, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pub/Sub endpoint %q: %w", cfg.Endpoint, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid Pub/Sub endpoint %q: it must be an absolute http(s) URL",
+			cfg.Endpoint)
+	}
+	if cfg.Project == "" || cfg.Topic == "" {
+		return nil, errors.New("both the Pub/Sub project and topic must be provided")
+	}
+	if cfg.BatchSize <= 0 || cfg.BatchSize > pubsubMaxBatchSize {
+		return nil, fmt.Errorf("invalid Pub/Sub batch size: %d. It must be between 1 and %d",
+			cfg.BatchSize, pubsubMaxBatchSize)
+	}
+	if cfg.Retries < 0 {
+		return nil, fmt.Errorf("invalid Pub/Sub retries: %d", cfg.Retries)
+	}
+	if cfg.BufferLength < 0 {
+		return nil, fmt.Errorf("invalid Pub/Sub buffer length: %d", cfg.BufferLength)
+	}
+	marshaler, err := NewJSONMarshaler(cfg.FieldCase)
+	if err != nil {
+		return nil, err
+	}
+	ps := &PubSub{
+		client: client,
+		cfg:    *cfg,
+		topicURL: fmt.Sprintf("%s/v1/projects/%s/topics/%s", strings.TrimSuffix(cfg.Endpoint, "/"),
+			url.PathEscape(cfg.Project), url.PathEscape(cfg.Topic)),
+		marshaler: marshaler,
+		published: m.NewCounterVec("pubsub_published_flows_total",
+			"Flows that have been published to Pub/Sub", "exporter").WithLabelValues(name),
+		failed: m.NewCounterVec("pubsub_failed_flows_total",
+			"Flows that couldn't be published to Pub/Sub and were discarded", "exporter").
+			WithLabelValues(name),
+		sleep: time.Sleep,
+	}
+	if cfg.OrderingKey != "" {
+		if ps.orderingKey = StatsDTagFields[cfg.OrderingKey]; ps.orderingKey == nil {
+			return nil, fmt.Errorf("unknown Pub/Sub ordering key field %q", cfg.OrderingKey)
+		}
+	}
+	if err := ps.checkTopic(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// checkTopic verifies that the topic exists and that the credentials give access to it
+func (ps *PubSub) checkTopic() error {
+	resp, err := ps.client.Get(ps.topicURL)
+	if err != nil {
+		return fmt.Errorf("can't reach Pub/Sub: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("topic %s not found in Pub/Sub project %s", ps.cfg.Topic, ps.cfg.Project)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("access to Pub/Sub topic %s rejected: %s", ps.cfg.Topic, resp.Status)
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("unexpected Pub/Sub response: %s", resp.Status)
+	}
+	return nil
+}
+
+// Export publishes the flows, after the ones that couldn't be published in previous exports,
+// in requests of up to the configured batch size
+func (ps *PubSub) Export(records []*flow.Record) error {
+	ps.pendingMt.Lock()
+	defer ps.pendingMt.Unlock()
+	messages := ps.pending
+	for _, record := range records {
+		data, err := ps.marshaler.Marshal(toJSONRecord(record, ps.cfg.ProtocolNames))
+		if err != nil {
+			pslog.WithError(err).Debug("can't encode flow. Ignoring")
+			continue
+		}
+		msg := pubsubMessage{Data: data}
+		if ps.orderingKey != nil {
+			msg.OrderingKey = ps.orderingKey(record)
+		}
+		messages = append(messages, msg)
+	}
+	ps.pending = nil
+	var errs []string
+	for len(messages) > 0 {
+		batch := ps.nextBatch(messages)
+		messages = messages[len(batch):]
+		if err := ps.publish(batch); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// nextBatch returns the first messages that fit in a publish request
+func (ps *PubSub) nextBatch(messages []pubsubMessage) []pubsubMessage {
+	size := 0
+	for i, msg := range messages {
+		size += base64.StdEncoding.EncodedLen(len(msg.Data)) + len(msg.OrderingKey) +
+			pubsubMessageOverhead
+		if i > 0 && (i == ps.cfg.BatchSize || size > pubsubMaxRequestBytes) {
+			return messages[:i]
+		}
+	}
+	return messages
+}
+
+// publish submits the messages, retrying with backoff if they fail with a transient error. If
+// all the retries fail, the messages are kept to be published in the next export.
+func (ps *PubSub) publish(messages []pubsubMessage) error {
+	body, err := json.Marshal(pubsubPublishRequest{Messages: messages})
+	if err != nil {
+		ps.failed.Add(float64(len(messages)))
+		return fmt.Errorf("encoding Pub/Sub publish request: %w", err)
+	}
+	for attempt := 0; ; attempt++ {
+		transient, err := ps.submit(body, len(messages))
+		if err == nil {
+			ps.published.Add(float64(len(messages)))
+			return nil
+		}
+		if !transient {
+			ps.failed.Add(float64(len(messages)))
+			return err
+		}
+		if attempt >= ps.cfg.Retries {
+			ps.buffer(messages)
+			return fmt.Errorf("can't publish %d flows to Pub/Sub after %d retries: %w",
+				len(messages), ps.cfg.Retries, err)
+		}
+		wait := ps.cfg.RetryBackoff << attempt
+		pslog.WithError(err).Debugf("couldn't publish %d flows. Retrying in %s", len(messages), wait)
+		ps.sleep(wait)
+	}
+}
+
+// buffer keeps the messages to be published in the next export, discarding the oldest ones
+// if the buffer length is exceeded
+func (ps *PubSub) buffer(messages []pubsubMessage) {
+	ps.pending = append(ps.pending, messages...)
+	if excess := len(ps.pending) - ps.cfg.BufferLength; excess > 0 {
+		ps.failed.Add(float64(excess))
+		pslog.Debugf("Pub/Sub buffer is full. Discarding %d flows", excess)
+		ps.pending = append([]pubsubMessage(nil), ps.pending[excess:]...)
+	}
+}
+
+// submit sends a publish request and returns whether its error, if any, is transient
+func (ps *PubSub) submit(body []byte, messages int) (transient bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, ps.topicURL+":publish", bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("creating Pub/Sub publish request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ps.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("submitting Pub/Sub publish request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return pubsubTransientStatus(resp.StatusCode), fmt.Errorf("publishing to Pub/Sub failed with %s: %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+	}
+	result := pubsubPublishResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return true, fmt.Errorf("decoding Pub/Sub publish response: %w", err)
+	}
+	if len(result.MessageIDs) != messages {
+		return true, fmt.Errorf("publishing to Pub/Sub returned %d message IDs for %d messages",
+			len(result.MessageIDs), messages)
+	}
+	return false, nil
+}
+
+// Close discards the flows that couldn't be published. No connection is kept open between exports.
+func (ps *PubSub) Close() error {
+	ps.pendingMt.Lock()
+	defer ps.pendingMt.Unlock()
+	if len(ps.pending) > 0 {
+		pslog.WithField("flows", len(ps.pending)).Warn("discarding unpublished flows")
+		ps.failed.Add(float64(len(ps.pending)))
+		ps.pending = nil
+	}
+	return nil
+}
+
+// pubsubTransientStatus returns whether the request failed with an HTTP status that may
+// succeed if it is retried later
+func pubsubTransientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusRequestTimeout ||
+		status/100 == 5
+}
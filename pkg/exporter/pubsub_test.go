@@ -0,0 +1,276 @@
+package exporter
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+const pubsubTestTopic = "/v1/projects/my-project/topics/flows"
+
+// pubsubTestServer is a fake Pub/Sub emulator that records the published messages and answers
+// the publish requests with the queued HTTP statuses (200 if there are none)
+type pubsubTestServer struct {
+	*httptest.Server
+	requests [][]pubsubMessage
+	statuses []int
+}
+
+func newPubSubTestServer(t *testing.T, statuses ...int) *pubsubTestServer {
+	ps := &pubsubTestServer{statuses: statuses}
+	ps.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case pubsubTestTopic:
+			assert.Equal(t, http.MethodGet, req.Method)
+			_, _ = rw.Write([]byte(`{"name":"projects/my-project/topics/flows"}`))
+		case pubsubTestTopic + ":publish":
+			assert.Equal(t, http.MethodPost, req.Method)
+			assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+			if len(ps.statuses) > 0 && ps.statuses[0] != http.StatusOK {
+				rw.WriteHeader(ps.statuses[0])
+				ps.statuses = ps.statuses[1:]
+				return
+			}
+			publish := pubsubPublishRequest{}
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&publish))
+			ps.requests = append(ps.requests, publish.Messages)
+			ids := make([]string, len(publish.Messages))
+			for i := range ids {
+				ids[i] = fmt.Sprint(i)
+			}
+			require.NoError(t, json.NewEncoder(rw).Encode(pubsubPublishResponse{MessageIDs: ids}))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return ps
+}
+
+func testPubSubConfig(endpoint string) *PubSubConfig {
+	return &PubSubConfig{
+		Endpoint:     endpoint,
+		Project:      "my-project",
+		Topic:        "flows",
+		BatchSize:    10,
+		Retries:      1,
+		RetryBackoff: time.Second,
+		BufferLength: 100,
+	}
+}
+
+func pubsubRecord(srcIP string, srcPort uint16) *flow.Record {
+	r := &flow.Record{Interface: "eth0"}
+	copy(r.Id.SrcIp[:], net.ParseIP(srcIP).To16())
+	r.Id.SrcPort = srcPort
+	return r
+}
+
+// publishedSrcPorts returns the source ports of the flows in the published messages
+func publishedSrcPorts(t *testing.T, messages []pubsubMessage) []int {
+	t.Helper()
+	var ports []int
+	for _, msg := range messages {
+		decoded := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(msg.Data, &decoded))
+		ports = append(ports, int(decoded["Id"].(map[string]interface{})["SrcPort"].(float64)))
+	}
+	return ports
+}
+
+func TestPubSub_Publish(t *testing.T) {
+	server := newPubSubTestServer(t)
+	defer server.Close()
+	cfg := testPubSubConfig(server.URL)
+	cfg.BatchSize = 2
+	cfg.OrderingKey = "srcAddr"
+	ps, err := StartPubSub(server.Client(), "pubsub", cfg, metrics.NoOp())
+	require.NoError(t, err)
+
+	// WHEN exporting more flows than the batch size
+	require.NoError(t, ps.Export([]*flow.Record{
+		pubsubRecord("10.0.0.1", 1), pubsubRecord("10.0.0.2", 2), pubsubRecord("10.0.0.1", 3),
+	}))
+
+	// THEN they are published in batches, as JSON messages with the configured ordering key
+	require.Len(t, server.requests, 2)
+	assert.Equal(t, []int{1, 2}, publishedSrcPorts(t, server.requests[0]))
+	assert.Equal(t, []int{3}, publishedSrcPorts(t, server.requests[1]))
+	assert.Equal(t, "10.0.0.1", server.requests[0][0].OrderingKey)
+	assert.Equal(t, "10.0.0.2", server.requests[0][1].OrderingKey)
+	assert.Equal(t, "10.0.0.1", server.requests[1][0].OrderingKey)
+	assert.Equal(t, 3.0, counterValue(t, ps.published))
+}
+
+func TestPubSub_RetryAndBuffer(t *testing.T) {
+	// GIVEN a Pub/Sub server that fails with transient errors
+	server := newPubSubTestServer(t,
+		http.StatusServiceUnavailable, http.StatusServiceUnavailable,
+		http.StatusTooManyRequests, http.StatusOK)
+	defer server.Close()
+	cfg := testPubSubConfig(server.URL)
+	cfg.BufferLength = 2
+	ps, err := StartPubSub(server.Client(), "pubsub", cfg, metrics.NoOp())
+	require.NoError(t, err)
+	var waits []time.Duration
+	ps.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	// WHEN the publication fails after all the retries
+	err = ps.Export([]*flow.Record{
+		pubsubRecord("10.0.0.1", 1), pubsubRecord("10.0.0.1", 2), pubsubRecord("10.0.0.1", 3),
+	})
+	require.Error(t, err)
+	assert.Equal(t, []time.Duration{time.Second}, waits)
+	// THEN the flows are buffered, discarding the oldest ones that exceed the buffer length
+	assert.Empty(t, server.requests)
+	assert.Equal(t, 1.0, counterValue(t, ps.failed))
+
+	// AND they are published, with backoff retries, before the next flows
+	require.NoError(t, ps.Export([]*flow.Record{pubsubRecord("10.0.0.1", 4)}))
+	require.Len(t, server.requests, 1)
+	assert.Equal(t, []int{2, 3, 4}, publishedSrcPorts(t, server.requests[0]))
+	assert.Equal(t, 3.0, counterValue(t, ps.published))
+	assert.Empty(t, ps.pending)
+}
+
+func TestPubSub_NonTransientError(t *testing.T) {
+	server := newPubSubTestServer(t, http.StatusBadRequest)
+	defer server.Close()
+	ps, err := StartPubSub(server.Client(), "pubsub", testPubSubConfig(server.URL), metrics.NoOp())
+	require.NoError(t, err)
+	ps.sleep = func(time.Duration) { require.Fail(t, "unexpected retry") }
+
+	// flows rejected with a non-transient error are neither retried nor buffered
+	require.Error(t, ps.Export([]*flow.Record{pubsubRecord("10.0.0.1", 1)}))
+	assert.Empty(t, ps.pending)
+	assert.Equal(t, 1.0, counterValue(t, ps.failed))
+}
+
+func TestStartPubSub_Validation(t *testing.T) {
+	server := newPubSubTestServer(t)
+	defer server.Close()
+	forbidden := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+
+	for _, tc := range []struct {
+		name   string
+		modify func(cfg *PubSubConfig)
+	}{
+		{"invalid endpoint", func(cfg *PubSubConfig) { cfg.Endpoint = "pubsub:8085" }},
+		{"missing topic", func(cfg *PubSubConfig) { cfg.Topic = "" }},
+		{"too big batch", func(cfg *PubSubConfig) { cfg.BatchSize = 1001 }},
+		{"unknown ordering key", func(cfg *PubSubConfig) { cfg.OrderingKey = "foo" }},
+		{"topic not found", func(cfg *PubSubConfig) { cfg.Topic = "other" }},
+		{"forbidden topic", func(cfg *PubSubConfig) { cfg.Endpoint = forbidden.URL }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := testPubSubConfig(server.URL)
+			tc.modify(cfg)
+			_, err := StartPubSub(server.Client(), "pubsub", cfg, metrics.NoOp())
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGoogleServiceAccountTokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	pemKey := pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	// GIVEN a token endpoint that verifies the signed assertion of the service account
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		assert.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", req.PostForm.Get("grant_type"))
+		parts := strings.Split(req.PostForm.Get("assertion"), ".")
+		require.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		claims := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(claimsJSON, &claims))
+		assert.Equal(t, "agent@my-project.iam.gserviceaccount.com", claims["iss"])
+		assert.Equal(t, "https://www.googleapis.com/auth/pubsub", claims["scope"])
+		assert.Equal(t, "http://"+req.Host, claims["aud"])
+		assert.Less(t, claims["iat"], claims["exp"])
+		_, _ = rw.Write([]byte(`{"access_token":"secret","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "agent@my-project.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+		"token_uri":    tokenServer.URL,
+	})
+	require.NoError(t, err)
+
+	// WHEN a token is requested from the service account credentials
+	ts, err := googleFileTokenSource(tokenServer.Client(), credentials, PubSubScope)
+	require.NoError(t, err)
+	token, err := ts.Token()
+
+	// THEN the access token is returned
+	require.NoError(t, err)
+	assert.Equal(t, "secret", token.AccessToken)
+	assert.True(t, token.Valid())
+
+	// AND invalid credentials are reported
+	_, err = googleFileTokenSource(tokenServer.Client(),
+		[]byte(`{"type":"service_account","private_key":"foo"}`), PubSubScope)
+	assert.Error(t, err)
+	_, err = googleFileTokenSource(tokenServer.Client(), []byte(`{"type":"external_account"}`),
+		PubSubScope)
+	assert.Error(t, err)
+}
+
+func TestGoogleUserTokenSource(t *testing.T) {
+	// GIVEN a token endpoint that refreshes the gcloud user credentials
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		assert.Equal(t, "refresh_token", req.PostForm.Get("grant_type"))
+		assert.Equal(t, "my-refresh-token", req.PostForm.Get("refresh_token"))
+		assert.Equal(t, "my-client", req.PostForm.Get("client_id"))
+		rw.Header().Set("Content-Type", "application/json")
+		_, _ = rw.Write([]byte(`{"access_token":"secret","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	// WHEN a token is requested from the user credentials
+	ts, err := googleFileTokenSource(tokenServer.Client(), []byte(`{"type":"authorized_user",`+
+		`"client_id":"my-client","client_secret":"foo","refresh_token":"my-refresh-token",`+
+		`"token_uri":"`+tokenServer.URL+`"}`), PubSubScope)
+	require.NoError(t, err)
+	token, err := ts.Token()
+
+	// THEN the refreshed access token is returned
+	require.NoError(t, err)
+	assert.Equal(t, "secret", token.AccessToken)
+	assert.True(t, token.Valid())
+}
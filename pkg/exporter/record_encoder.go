@@ -0,0 +1,88 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+// Encodings of the flows for the stream-based exporters (file, unix, fifo)
+const (
+	// EncodingJSON writes each flow as a JSON object followed by a newline
+	EncodingJSON = "json"
+	// EncodingMsgpack writes each flow as a MessagePack map. MessagePack values are
+	// self-delimited, so the flows are just concatenated.
+	EncodingMsgpack = "msgpack"
+)
+
+// RecordEncoder serializes the flows for the stream-based exporters. Both encodings provide
+// the same fields. The field case only applies to the JSON encoding: the MessagePack fields keep
+// the pascal case of the record.
+type RecordEncoder struct {
+	marshaler     *JSONMarshaler
+	msgpack       bool
+	protocolNames bool
+}
+
+// NewRecordEncoder returns a RecordEncoder for the provided encoding: json (default if empty)
+// or msgpack. The fieldCase argument specifies the naming convention of the JSON keys
+// (see NewJSONMarshaler). If protocolNames is true, the records also provide the IANA name of
+// their transport protocol, besides its number.
+func NewRecordEncoder(encoding, fieldCase string, protocolNames bool) (*RecordEncoder, error) {
+	marshaler, err := NewJSONMarshaler(fieldCase)
+	if err != nil {
+		return nil, err
+	}
+	switch encoding {
+	case "", EncodingJSON:
+		return &RecordEncoder{marshaler: marshaler, protocolNames: protocolNames}, nil
+	case EncodingMsgpack:
+		return &RecordEncoder{marshaler: marshaler, msgpack: true, protocolNames: protocolNames}, nil
+	default:
+		return nil, fmt.Errorf("wrong encoding %q. Admitted values are %s, %s",
+			encoding, EncodingJSON, EncodingMsgpack)
+	}
+}
+
+// Encode returns the encoding of the provided record, without any delimiter
+func (e *RecordEncoder) Encode(record *flow.Record) ([]byte, error) {
+	jr := toJSONRecord(record, e.protocolNames)
+	if e.msgpack {
+		return encodeMsgpack(jr)
+	}
+	return e.marshaler.Marshal(jr)
+}
+
+// writeDelimited writes the encoded record, followed by a newline if it is JSON-encoded
+func (e *RecordEncoder) writeDelimited(w bufferedWriter, encoded []byte) {
+	_, _ = w.Write(encoded)
+	if !e.msgpack {
+		_ = w.WriteByte('\n')
+	}
+}
+
+// bufferedWriter is implemented by bytes.Buffer and bufio.Writer, whose write errors are
+// deferred to the flush of the buffer
+type bufferedWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+// encodeRecords encodes the records, one after the other. The records that can't be encoded
+// are logged and skipped.
+func encodeRecords(encoder *RecordEncoder, records []*flow.Record, log *logrus.Entry) []byte {
+	var buf bytes.Buffer
+	for _, record := range records {
+		encoded, err := encoder.Encode(record)
+		if err != nil {
+			log.WithError(err).Debug("can't encode record. Ignoring")
+			continue
+		}
+		encoder.writeDelimited(&buf, encoded)
+	}
+	return buf.Bytes()
+}
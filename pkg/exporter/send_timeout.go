@@ -0,0 +1,202 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var stlog = logrus.WithField("component", "exporter.SendTimeout")
+
+// SendTimeout wraps an Exporter to retry the batches of flows whose submission fails, and to
+// bound the time each batch takes to be submitted, so a slow collector doesn't stall the
+// pipeline. A batch whose submission returns an error, or doesn't finish within the timeout, is
+// kept in a bounded retry buffer to be submitted again before the next batch, until it is
+// acknowledged. If the retry buffer is full, its oldest batches are discarded.
+// A zero timeout doesn't bound the submissions, which are only retried.
+// If the wrapped exporter implements ContextExporter, the submission is aborted by cancelling its
+// context. Otherwise, the submission keeps running in background, and the next batches are
+// kept in the retry buffer until it finishes, as the exporter can't be invoked concurrently.
+// Then a batch whose submission eventually succeeds after the timeout is submitted twice.
+type SendTimeout struct {
+	// ctx is the parent of the submissions' contexts
+	ctx        context.Context
+	exporter   Exporter
+	timeout    time.Duration
+	maxBatches int
+	concurrent bool
+	mt         sync.Mutex
+	retry      [][]*flow.Record
+	// running receives the result of the timed-out submission that is still running, if any
+	running  <-chan error
+	timedOut prometheus.Counter
+	dropped  prometheus.Counter
+}
+
+// concurrentSendTimeout is a SendTimeout that wraps a ConcurrentExporter, so it can be used
+// concurrently too
+type concurrentSendTimeout struct {
+	*SendTimeout
+}
+
+func (concurrentSendTimeout) ConcurrentSafe() {}
+
+// WithSendTimeout returns an Exporter that submits the flows through the provided exporter,
+// failing the submissions that exceed the timeout, if not zero. The failed submissions are
+// retried from a buffer of up to maxBatches batches. The submissions of the ContextExporters
+// are aborted when the provided context is done. The metrics are labeled by the provided
+// exporter name. The returned Exporter is a ConcurrentExporter if the provided exporter is.
+func WithSendTimeout(
+	ctx context.Context, e Exporter, name string, timeout time.Duration, maxBatches int,
+	m *metrics.Metrics,
+) Exporter {
+	st := &SendTimeout{
+		ctx:        ctx,
+		exporter:   e,
+		timeout:    timeout,
+		maxBatches: maxBatches,
+		timedOut: m.NewCounterVec("export_timed_out_batches_total",
+			"Batches of flows whose export exceeded the send timeout", "exporter").
+			WithLabelValues(name),
+		dropped: m.NewCounterVec("export_retry_dropped_flows_total",
+			"Flows whose export failed and were discarded because the retry buffer was full",
+			"exporter").
+			WithLabelValues(name),
+	}
+	if _, ok := e.(ConcurrentExporter); ok {
+		st.concurrent = true
+		return concurrentSendTimeout{SendTimeout: st}
+	}
+	return st
+}
+
+// Export submits first the batches pending to be retried, and then the provided batch
+func (st *SendTimeout) Export(records []*flow.Record) error {
+	if st.busy() {
+		st.enqueue(records)
+		return fmt.Errorf("a previous export is still running after timing out. "+
+			"%d flows are kept to be retried", len(records))
+	}
+	pending := st.dequeue()
+	for i, batch := range pending {
+		if err := st.send(batch); err != nil {
+			// the batch has been enqueued again. The rest are enqueued after it, in order
+			st.enqueue(pending[i+1:]...)
+			st.enqueue(records)
+			return fmt.Errorf("retrying previous flows: %w", err)
+		}
+	}
+	return st.send(records)
+}
+
+// Close closes the wrapped exporter, after waiting up to the timeout for the submission that
+// is still running, if any. The batches pending to be retried are discarded.
+func (st *SendTimeout) Close() error {
+	st.mt.Lock()
+	for _, batch := range st.retry {
+		st.dropped.Add(float64(len(batch)))
+	}
+	st.retry = nil
+	running := st.running
+	st.mt.Unlock()
+	if running != nil {
+		select {
+		case <-running:
+		case <-time.After(st.timeout):
+			stlog.Warn("closing the exporter while a timed out export is still running")
+		}
+	}
+	return st.exporter.Close()
+}
+
+// send submits a batch of flows, waiting for the timeout at most. If the submission fails or
+// the timeout is exceeded, the batch is enqueued to be retried.
+func (st *SendTimeout) send(records []*flow.Record) error {
+	if st.timeout <= 0 {
+		err := st.export(st.ctx, records)
+		if err != nil {
+			st.enqueue(records)
+		}
+		return err
+	}
+	ctx, cancel := context.WithTimeout(st.ctx, st.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- st.export(ctx, records)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			st.enqueue(records)
+		}
+		return err
+	case <-ctx.Done():
+		st.timedOut.Inc()
+		if !st.concurrent {
+			st.mt.Lock()
+			st.running = done
+			st.mt.Unlock()
+		}
+		st.enqueue(records)
+		return fmt.Errorf("export of %d flows timed out after %s", len(records), st.timeout)
+	}
+}
+
+func (st *SendTimeout) export(ctx context.Context, records []*flow.Record) error {
+	if ce, ok := st.exporter.(ContextExporter); ok {
+		return ce.ExportContext(ctx, records)
+	}
+	return st.exporter.Export(records)
+}
+
+// busy returns whether a submission that timed out is still running
+func (st *SendTimeout) busy() bool {
+	st.mt.Lock()
+	defer st.mt.Unlock()
+	if st.running == nil {
+		return false
+	}
+	select {
+	case err := <-st.running:
+		if err != nil {
+			stlog.WithError(err).Debug("timed out export finished with error")
+		}
+		st.running = nil
+		return false
+	default:
+		return true
+	}
+}
+
+// enqueue appends the batches to the retry buffer, discarding the oldest ones if it is full
+func (st *SendTimeout) enqueue(batches ...[]*flow.Record) {
+	st.mt.Lock()
+	defer st.mt.Unlock()
+	for _, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		st.retry = append(st.retry, batch)
+	}
+	for len(st.retry) > st.maxBatches {
+		st.dropped.Add(float64(len(st.retry[0])))
+		st.retry = st.retry[1:]
+	}
+}
+
+// dequeue removes and returns all the batches of the retry buffer
+func (st *SendTimeout) dequeue() [][]*flow.Record {
+	st.mt.Lock()
+	defer st.mt.Unlock()
+	pending := st.retry
+	st.retry = nil
+	return pending
+}
@@ -0,0 +1,211 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+const sendTimeout = 50 * time.Millisecond
+
+// slowExporter blocks the submissions until the release channel is closed, if it is not nil,
+// and records the source ports of the submitted flows
+type slowExporter struct {
+	mt       sync.Mutex
+	release  chan struct{}
+	exported []uint16
+}
+
+func (se *slowExporter) Export(records []*flow.Record) error {
+	se.mt.Lock()
+	release := se.release
+	se.mt.Unlock()
+	if release != nil {
+		<-release
+	}
+	se.record(records)
+	return nil
+}
+
+func (se *slowExporter) record(records []*flow.Record) {
+	se.mt.Lock()
+	defer se.mt.Unlock()
+	for _, r := range records {
+		se.exported = append(se.exported, r.Id.SrcPort)
+	}
+}
+
+func (se *slowExporter) unblock() {
+	se.mt.Lock()
+	defer se.mt.Unlock()
+	close(se.release)
+	se.release = nil
+}
+
+func (se *slowExporter) srcPorts() []uint16 {
+	se.mt.Lock()
+	defer se.mt.Unlock()
+	return append([]uint16{}, se.exported...)
+}
+
+func (se *slowExporter) Close() error { return nil }
+
+// slowContextExporter blocks the first submission until its context is done
+type slowContextExporter struct {
+	slowExporter
+	canceled error
+}
+
+func (se *slowContextExporter) ExportContext(ctx context.Context, records []*flow.Record) error {
+	se.mt.Lock()
+	first := se.exported == nil && se.canceled == nil
+	se.mt.Unlock()
+	if first {
+		<-ctx.Done()
+		se.mt.Lock()
+		se.canceled = ctx.Err()
+		se.mt.Unlock()
+		return ctx.Err()
+	}
+	se.record(records)
+	return nil
+}
+
+type concurrentSlowExporter struct {
+	slowExporter
+}
+
+func (*concurrentSlowExporter) ConcurrentSafe() {}
+
+func portsBatch(ports ...uint16) []*flow.Record {
+	records := make([]*flow.Record, 0, len(ports))
+	for _, p := range ports {
+		r := &flow.Record{}
+		r.Id.SrcPort = p
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestSendTimeout_RetryTimedOutBatch(t *testing.T) {
+	// GIVEN a slow exporter whose submissions don't finish
+	slow := &slowExporter{release: make(chan struct{})}
+	e := WithSendTimeout(context.Background(), slow, "slow", sendTimeout, 10, metrics.NoOp())
+	st := e.(*SendTimeout)
+
+	// WHEN a batch is exported
+	start := time.Now()
+	err := e.Export(portsBatch(1, 2))
+
+	// THEN the export fails after the timeout
+	require.Error(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), sendTimeout)
+	assert.EqualValues(t, 1, counterValue(t, st.timedOut))
+
+	// AND while the slow submission is still running, the next batches are kept to be retried
+	require.Error(t, e.Export(portsBatch(3)))
+	assert.Empty(t, slow.srcPorts())
+
+	// AND once the collector recovers, the pending batches are retried before the next batch
+	slow.unblock()
+	require.Eventually(t, func() bool { return !st.busy() }, timeout, 10*time.Millisecond)
+	require.NoError(t, e.Export(portsBatch(4)))
+	// the timed out batch is submitted twice, as its slow submission eventually succeeded
+	assert.Equal(t, []uint16{1, 2, 1, 2, 3, 4}, slow.srcPorts())
+	assert.Zero(t, counterValue(t, st.dropped))
+}
+
+func TestSendTimeout_CancelContextExporter(t *testing.T) {
+	slow := &slowContextExporter{}
+	e := WithSendTimeout(context.Background(), slow, "slow", sendTimeout, 10, metrics.NoOp())
+
+	// WHEN the export of a batch exceeds the timeout
+	require.Error(t, e.Export(portsBatch(1)))
+
+	// THEN the submission is canceled
+	require.Eventually(t, func() bool {
+		slow.mt.Lock()
+		defer slow.mt.Unlock()
+		return errors.Is(slow.canceled, context.DeadlineExceeded)
+	}, timeout, 10*time.Millisecond)
+
+	// AND the batch is retried with the next one
+	require.NoError(t, e.Export(portsBatch(2)))
+	assert.Equal(t, []uint16{1, 2}, slow.srcPorts())
+}
+
+func TestSendTimeout_BoundedRetryBuffer(t *testing.T) {
+	slow := &slowExporter{release: make(chan struct{})}
+	defer slow.unblock()
+	e := WithSendTimeout(context.Background(), slow, "slow", sendTimeout, 2, metrics.NoOp())
+	st := e.(*SendTimeout)
+
+	// WHEN more batches time out than the retry buffer can keep
+	require.Error(t, e.Export(portsBatch(1, 2)))
+	require.Error(t, e.Export(portsBatch(3)))
+	require.Error(t, e.Export(portsBatch(4)))
+
+	// THEN the oldest batches are discarded
+	assert.EqualValues(t, 2, counterValue(t, st.dropped))
+	assert.Equal(t, [][]*flow.Record{portsBatch(3), portsBatch(4)}, st.dequeue())
+}
+
+func TestSendTimeout_Concurrent(t *testing.T) {
+	m := metrics.NoOp()
+	_, ok := WithSendTimeout(context.Background(), &slowExporter{}, "single", sendTimeout, 1, m).(ConcurrentExporter)
+	assert.False(t, ok)
+	// the metrics are shared by the exporters
+	e := WithSendTimeout(context.Background(), &concurrentSlowExporter{}, "concurrent", sendTimeout, 1, m)
+	_, ok = e.(ConcurrentExporter)
+	assert.True(t, ok)
+	require.NoError(t, e.Export(portsBatch(1)))
+}
+
+// failingExporter fails the submissions until it is recovered
+type failingExporter struct {
+	slowExporter
+	failing bool
+}
+
+func (fe *failingExporter) Export(records []*flow.Record) error {
+	fe.mt.Lock()
+	failing := fe.failing
+	fe.mt.Unlock()
+	if failing {
+		return errors.New("collector unavailable")
+	}
+	fe.record(records)
+	return nil
+}
+
+func TestSendTimeout_RetryFailedBatch(t *testing.T) {
+	// GIVEN an exporter whose submissions fail, without send timeout
+	failing := &failingExporter{failing: true}
+	e := WithSendTimeout(context.Background(), failing, "failing", 0, 2, metrics.NoOp())
+	st := e.(*SendTimeout)
+
+	// WHEN more batches fail than the retry buffer can keep
+	require.Error(t, e.Export(portsBatch(1, 2)))
+	require.Error(t, e.Export(portsBatch(3)))
+	require.Error(t, e.Export(portsBatch(4)))
+
+	// THEN the oldest batches are discarded
+	assert.EqualValues(t, 2, counterValue(t, st.dropped))
+	assert.Empty(t, failing.srcPorts())
+
+	// AND once the collector recovers, the pending batches are retried before the next batch
+	failing.mt.Lock()
+	failing.failing = false
+	failing.mt.Unlock()
+	require.NoError(t, e.Export(portsBatch(5)))
+	assert.Equal(t, []uint16{3, 4, 5}, failing.srcPorts())
+	assert.Empty(t, st.dequeue())
+}
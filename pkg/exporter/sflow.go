@@ -0,0 +1,337 @@
+package exporter
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/utils"
+)
+
+var sflog = logrus.WithField("component", "exporter/SFlow")
+
+const (
+	// sflowMaxDatagramSize is the maximum size of the sFlow datagrams, to avoid IP fragmentation
+	// in most networks
+	sflowMaxDatagramSize = 1400
+	// sflowMaxHeaderSize is the size of the datagram header with an IPv6 agent address
+	sflowMaxHeaderSize = 40
+
+	sflowVersion       = 5
+	sflowAddressIPv4   = 1
+	sflowAddressIPv6   = 2
+	sflowFlowSample    = 1
+	sflowCounterSample = 2
+	// flow record formats
+	sflowSampledEthernet = 2
+	sflowSampledIPv4     = 3
+	sflowSampledIPv6     = 4
+	// sflowFlowIdentity is the agent-specific flow record with the ClusterID and TenantID of
+	// the flow. Enterprise formats carry the enterprise number in their 20 higher bits
+	sflowFlowIdentity = enterpriseID<<12 | 1
+	// counter record formats
+	sflowGenericIfCounters = 1
+	// ifStatus with the admin (bit 0) and the operational (bit 1) status up
+	sflowIfStatusUp = 3
+	// EtherType of the IPv4 packets
+	sflowIPv4Type = 0x0800
+	// the TCP flags are the lower 8 bits of the flow flags. The higher bits are agent-specific
+	sflowTCPFlagsMask = 0xff
+)
+
+// SFlow exporter sends the flows as sFlow v5 datagrams to a UDP collector. sFlow flow samples
+// describe individual packets, so each flow is mapped to a flow sample whose sampling rate is the
+// number of packets of the flow and whose packet length is their average size. Then the
+// collector's estimation of the traffic (sampling rate × length) matches the flow's bytes and
+// packets. Optionally, it also sends counter samples with the cumulative traffic of each
+// interface.
+type SFlow struct {
+	conn           net.Conn
+	counterSamples bool
+	start          time.Time
+	clock          func() time.Time
+
+	datagramSeq uint32
+	flowSeq     uint32
+	counterSeq  uint32
+	// samplePool accounts the total packets observed for each interface
+	samplePool map[uint32]uint32
+	ifCounters map[uint32]*sflowIfCounters
+}
+
+// sflowIfCounters accumulates the traffic of an interface, for the counter samples
+type sflowIfCounters struct {
+	inOctets   uint64
+	inPackets  uint32
+	outOctets  uint64
+	outPackets uint32
+}
+
+// StartSFlow creates an SFlow exporter that sends the datagrams to the provided UDP endpoint.
+// If counterSamples is true, each datagram is followed by the counter samples of the interfaces
+// of the exported flows.
+func StartSFlow(hostIP string, hostPort int, counterSamples bool) (*SFlow, error) {
+	if hostIP == "" || hostPort <= 0 {
+		return nil, fmt.Errorf("invalid sFlow endpoint: %s:%d", hostIP, hostPort)
+	}
+	socket := utils.GetSocket(hostIP, hostPort)
+	addr, err := net.ResolveUDPAddr("udp", socket)
+	if err != nil {
+		return nil, fmt.Errorf("resolving sFlow endpoint %s: %w", socket, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to sFlow endpoint %s: %w", socket, err)
+	}
+	return &SFlow{
+		conn:           conn,
+		counterSamples: counterSamples,
+		start:          time.Now(),
+		clock:          time.Now,
+		samplePool:     map[uint32]uint32{},
+		ifCounters:     map[uint32]*sflowIfCounters{},
+	}, nil
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, converts them to sFlow
+// samples and submits them to the collector
+func (sf *SFlow) ExportFlows(input <-chan []*flow.Record) {
+	sflog.WithField("endpoint", sf.conn.RemoteAddr()).Info("starting sFlow exporter")
+	for records := range input {
+		for _, datagram := range sf.datagrams(records) {
+			if _, err := sf.conn.Write(datagram); err != nil {
+				sflog.WithError(err).Error("can't send flows to sFlow collector")
+			}
+		}
+	}
+	if err := sf.conn.Close(); err != nil {
+		sflog.WithError(err).Warn("couldn't close sFlow connection")
+	}
+}
+
+// datagrams converts the records to sFlow samples and splits them into datagrams
+func (sf *SFlow) datagrams(records []*flow.Record) [][]byte {
+	// sFlow has no representation for heartbeats
+	records = withoutHeartbeats(records)
+	if len(records) == 0 {
+		return nil
+	}
+	samples := make([][]byte, 0, len(records))
+	for _, record := range records {
+		samples = append(samples, sf.flowSample(record))
+	}
+	if sf.counterSamples {
+		samples = append(samples, sf.counterSamplesOf(records)...)
+	}
+	// all the records of a batch are decorated with the same agent IP
+	agentIP := records[0].AgentIP
+
+	var datagrams [][]byte
+	var pending [][]byte
+	size := sflowMaxHeaderSize
+	for _, sample := range samples {
+		if len(pending) > 0 && size+len(sample) > sflowMaxDatagramSize {
+			datagrams = append(datagrams, sf.datagram(agentIP, pending))
+			pending, size = nil, sflowMaxHeaderSize
+		}
+		pending = append(pending, sample)
+		size += len(sample)
+	}
+	return append(datagrams, sf.datagram(agentIP, pending))
+}
+
+// datagram encodes the header of an sFlow datagram, followed by the provided samples
+func (sf *SFlow) datagram(agentIP net.IP, samples [][]byte) []byte {
+	sf.datagramSeq++
+	d := make([]byte, 0, sflowMaxDatagramSize)
+	d = xdrUint32(d, sflowVersion)
+	if ip4 := agentIP.To4(); ip4 != nil || agentIP == nil {
+		if ip4 == nil {
+			ip4 = net.IPv4zero.To4()
+		}
+		d = xdrUint32(d, sflowAddressIPv4)
+		d = append(d, ip4...)
+	} else {
+		d = xdrUint32(d, sflowAddressIPv6)
+		d = append(d, agentIP.To16()...)
+	}
+	d = xdrUint32(d, 0) // sub agent ID
+	d = xdrUint32(d, sf.datagramSeq)
+	d = xdrUint32(d, uint32(sf.clock().Sub(sf.start).Milliseconds()))
+	d = xdrUint32(d, uint32(len(samples)))
+	for _, sample := range samples {
+		d = append(d, sample...)
+	}
+	return d
+}
+
+// flowSample encodes a flow sample with a sampled Ethernet record and, for IP flows, a sampled
+// IPv4/IPv6 record. If the flow has a ClusterID or a TenantID, they are encoded in an
+// enterprise-specific flow identity record (sflowFlowIdentity), with both XDR strings.
+func (sf *SFlow) flowSample(record *flow.Record) []byte {
+	packets := record.Metrics.Packets
+	if packets == 0 {
+		packets = 1
+	}
+	ifIndex := record.Id.IfIndex
+	sf.flowSeq++
+	sf.samplePool[ifIndex] += packets
+	avgLength := uint32(record.Metrics.Bytes / uint64(packets))
+
+	var input, output uint32
+	if record.Id.Direction == flow.DirectionIngress {
+		input = ifIndex
+	} else {
+		output = ifIndex
+	}
+
+	var records []byte
+	numRecords := uint32(1)
+	var eth []byte
+	eth = xdrUint32(eth, avgLength)
+	eth = append(eth, record.Id.SrcMac[:]...)
+	eth = append(eth, 0, 0) // XDR padding of the 6-byte MAC
+	eth = append(eth, record.Id.DstMac[:]...)
+	eth = append(eth, 0, 0)
+	eth = xdrUint32(eth, uint32(record.Id.EthProtocol))
+	records = xdrOpaqueStruct(records, sflowSampledEthernet, eth)
+
+	if format, ipRecord := sflowIPRecord(record, avgLength); ipRecord != nil {
+		numRecords++
+		records = xdrOpaqueStruct(records, format, ipRecord)
+	}
+	if record.ClusterID != "" || record.TenantID != "" {
+		numRecords++
+		var identity []byte
+		identity = xdrString(identity, record.ClusterID)
+		identity = xdrString(identity, record.TenantID)
+		records = xdrOpaqueStruct(records, sflowFlowIdentity, identity)
+	}
+
+	var sample []byte
+	sample = xdrUint32(sample, sf.flowSeq)
+	sample = xdrUint32(sample, ifIndex) // source ID: type 0 (ifIndex) and the index
+	sample = xdrUint32(sample, packets) // sampling rate
+	sample = xdrUint32(sample, sf.samplePool[ifIndex])
+	sample = xdrUint32(sample, 0) // drops
+	sample = xdrUint32(sample, input)
+	sample = xdrUint32(sample, output)
+	sample = xdrUint32(sample, numRecords)
+	sample = append(sample, records...)
+	return xdrOpaqueStruct(nil, sflowFlowSample, sample)
+}
+
+// sflowIPRecord returns the format and the encoded sampled IPv4/IPv6 record of the flow, or
+// nil if it is not an IP flow
+func sflowIPRecord(record *flow.Record, avgLength uint32) (uint32, []byte) {
+	var ip []byte
+	var format uint32
+	switch record.Id.EthProtocol {
+	case flow.IPv6Type:
+		format = sflowSampledIPv6
+		ip = xdrUint32(ip, avgLength)
+		ip = xdrUint32(ip, uint32(record.Id.TransportProtocol))
+		ip = append(ip, record.Id.SrcIp[:]...)
+		ip = append(ip, record.Id.DstIp[:]...)
+	case sflowIPv4Type:
+		format = sflowSampledIPv4
+		ip = xdrUint32(ip, avgLength)
+		ip = xdrUint32(ip, uint32(record.Id.TransportProtocol))
+		ip = append(ip, record.Id.SrcIp[12:]...)
+		ip = append(ip, record.Id.DstIp[12:]...)
+	default:
+		return 0, nil
+	}
+	ip = xdrUint32(ip, uint32(record.Id.SrcPort))
+	ip = xdrUint32(ip, uint32(record.Id.DstPort))
+	ip = xdrUint32(ip, uint32(record.Metrics.Flags&sflowTCPFlagsMask))
+	ip = xdrUint32(ip, 0) // ToS (IPv4) or priority (IPv6)
+	return format, ip
+}
+
+// counterSamplesOf accounts the traffic of the records in their interface counters, and
+// encodes a counter sample for each of these interfaces
+func (sf *SFlow) counterSamplesOf(records []*flow.Record) [][]byte {
+	for _, record := range records {
+		c, ok := sf.ifCounters[record.Id.IfIndex]
+		if !ok {
+			c = &sflowIfCounters{}
+			sf.ifCounters[record.Id.IfIndex] = c
+		}
+		if record.Id.Direction == flow.DirectionIngress {
+			c.inOctets += record.Metrics.Bytes
+			c.inPackets += record.Metrics.Packets
+		} else {
+			c.outOctets += record.Metrics.Bytes
+			c.outPackets += record.Metrics.Packets
+		}
+	}
+	indices := make([]uint32, 0, len(sf.ifCounters))
+	seen := map[uint32]struct{}{}
+	for _, record := range records {
+		if _, ok := seen[record.Id.IfIndex]; !ok {
+			seen[record.Id.IfIndex] = struct{}{}
+			indices = append(indices, record.Id.IfIndex)
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	samples := make([][]byte, 0, len(indices))
+	for _, ifIndex := range indices {
+		c := sf.ifCounters[ifIndex]
+		var counters []byte
+		counters = xdrUint32(counters, ifIndex)
+		counters = xdrUint32(counters, 0) // ifType
+		counters = xdrUint64(counters, 0) // ifSpeed
+		counters = xdrUint32(counters, 0) // ifDirection
+		counters = xdrUint32(counters, sflowIfStatusUp)
+		counters = xdrUint64(counters, c.inOctets)
+		counters = xdrUint32(counters, c.inPackets)
+		// multicast, broadcast, discards, errors and unknown protocols input packets
+		for i := 0; i < 5; i++ {
+			counters = xdrUint32(counters, 0)
+		}
+		counters = xdrUint64(counters, c.outOctets)
+		counters = xdrUint32(counters, c.outPackets)
+		// multicast, broadcast, discards and errors output packets, and promiscuous mode
+		for i := 0; i < 5; i++ {
+			counters = xdrUint32(counters, 0)
+		}
+		sf.counterSeq++
+		var sample []byte
+		sample = xdrUint32(sample, sf.counterSeq)
+		sample = xdrUint32(sample, ifIndex)
+		sample = xdrUint32(sample, 1)
+		sample = xdrOpaqueStruct(sample, sflowGenericIfCounters, counters)
+		samples = append(samples, xdrOpaqueStruct(nil, sflowCounterSample, sample))
+	}
+	return samples
+}
+
+// xdrOpaqueStruct appends an sFlow structure: its format, its length and its data
+func xdrOpaqueStruct(b []byte, format uint32, data []byte) []byte {
+	b = xdrUint32(b, format)
+	b = xdrUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func xdrUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func xdrUint64(b []byte, v uint64) []byte {
+	return xdrUint32(xdrUint32(b, uint32(v>>32)), uint32(v))
+}
+
+// xdrString appends the length and the bytes of the string, padded to a multiple of 4 bytes
+func xdrString(b []byte, s string) []byte {
+	b = xdrUint32(b, uint32(len(s)))
+	b = append(b, s...)
+	for i := len(s); i%4 != 0; i++ {
+		b = append(b, 0)
+	}
+	return b
+}
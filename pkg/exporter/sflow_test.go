@@ -0,0 +1,216 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestSFlow(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer server.Close()
+
+	sf, err := StartSFlow("127.0.0.1", server.LocalAddr().(*net.UDPAddr).Port, true)
+	require.NoError(t, err)
+	sf.clock = func() time.Time { return sf.start.Add(1234 * time.Millisecond) }
+
+	v4 := &flow.Record{AgentIP: net.ParseIP("10.0.0.100")}
+	v4.Id.EthProtocol = 0x0800
+	v4.Id.Direction = flow.DirectionIngress
+	v4.Id.IfIndex = 3
+	v4.Id.SrcMac = [...]byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}
+	v4.Id.DstMac = [...]byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}
+	v4.Id.SrcIp = IPAddrFromNetIP(net.ParseIP("10.0.0.1"))
+	v4.Id.DstIp = IPAddrFromNetIP(net.ParseIP("10.0.0.2"))
+	v4.Id.SrcPort = 34567
+	v4.Id.DstPort = 443
+	v4.Id.TransportProtocol = 6
+	v4.Metrics.Bytes = 1000
+	v4.Metrics.Packets = 4
+	v4.Metrics.Flags = 0x112 // SYN+ACK, and the agent-specific SYN_ACK flag
+	v6 := &flow.Record{AgentIP: net.ParseIP("10.0.0.100")}
+	v6.Id.EthProtocol = flow.IPv6Type
+	v6.Id.Direction = flow.DirectionEgress
+	v6.Id.IfIndex = 3
+	v6.Id.SrcIp = IPAddrFromNetIP(net.ParseIP("fd00::1"))
+	v6.Id.DstIp = IPAddrFromNetIP(net.ParseIP("fd00::2"))
+	v6.Id.SrcPort = 53
+	v6.Id.DstPort = 45678
+	v6.Id.TransportProtocol = 17
+	v6.Metrics.Bytes = 300
+	v6.Metrics.Packets = 2
+
+	input := make(chan []*flow.Record, 1)
+	input <- []*flow.Record{v4, v6}
+	close(input)
+	go sf.ExportFlows(input)
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 65536)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+
+	// decoding the datagram header
+	d := sflowDecoder{t: t, r: bytes.NewReader(buf[:n])}
+	assert.EqualValues(t, 5, d.u32())                  // version
+	assert.EqualValues(t, 1, d.u32())                  // IPv4 agent address
+	assert.Equal(t, []byte{10, 0, 0, 100}, d.bytes(4)) // agent address
+	assert.EqualValues(t, 0, d.u32())                  // sub agent ID
+	assert.EqualValues(t, 1, d.u32())                  // sequence number
+	assert.EqualValues(t, 1234, d.u32())               // uptime
+	require.EqualValues(t, 3, d.u32())                 // samples
+
+	// IPv4 flow sample
+	assert.EqualValues(t, 1, d.u32()) // flow sample format
+	d.u32()                           // sample length
+	assert.EqualValues(t, 1, d.u32()) // sequence number
+	assert.EqualValues(t, 3, d.u32()) // source ID
+	assert.EqualValues(t, 4, d.u32()) // sampling rate: the packets of the flow
+	assert.EqualValues(t, 4, d.u32()) // sample pool
+	assert.EqualValues(t, 0, d.u32()) // drops
+	assert.EqualValues(t, 3, d.u32()) // input interface
+	assert.EqualValues(t, 0, d.u32()) // output interface
+	require.EqualValues(t, 2, d.u32())
+	assert.EqualValues(t, 2, d.u32())  // sampled Ethernet
+	assert.EqualValues(t, 24, d.u32()) // length
+	assert.EqualValues(t, 250, d.u32())
+	assert.Equal(t, []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0, 0}, d.bytes(8))
+	assert.Equal(t, []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0, 0}, d.bytes(8))
+	assert.EqualValues(t, 0x0800, d.u32())
+	assert.EqualValues(t, 3, d.u32())  // sampled IPv4
+	assert.EqualValues(t, 32, d.u32()) // length
+	assert.EqualValues(t, 250, d.u32())
+	assert.EqualValues(t, 6, d.u32())
+	assert.Equal(t, []byte{10, 0, 0, 1}, d.bytes(4))
+	assert.Equal(t, []byte{10, 0, 0, 2}, d.bytes(4))
+	assert.EqualValues(t, 34567, d.u32())
+	assert.EqualValues(t, 443, d.u32())
+	assert.EqualValues(t, 0x12, d.u32()) // TCP flags
+	assert.EqualValues(t, 0, d.u32())
+
+	// IPv6 flow sample
+	assert.EqualValues(t, 1, d.u32())
+	d.u32()
+	assert.EqualValues(t, 2, d.u32()) // sequence number
+	assert.EqualValues(t, 3, d.u32())
+	assert.EqualValues(t, 2, d.u32()) // sampling rate
+	assert.EqualValues(t, 6, d.u32()) // sample pool
+	assert.EqualValues(t, 0, d.u32())
+	assert.EqualValues(t, 0, d.u32()) // input interface
+	assert.EqualValues(t, 3, d.u32()) // output interface
+	require.EqualValues(t, 2, d.u32())
+	assert.EqualValues(t, 2, d.u32())
+	d.bytes(4 + 24)                    // Ethernet record
+	assert.EqualValues(t, 4, d.u32())  // sampled IPv6
+	assert.EqualValues(t, 56, d.u32()) // length
+	assert.EqualValues(t, 150, d.u32())
+	assert.EqualValues(t, 17, d.u32())
+	assert.Equal(t, []byte(net.ParseIP("fd00::1")), d.bytes(16))
+	assert.Equal(t, []byte(net.ParseIP("fd00::2")), d.bytes(16))
+	assert.EqualValues(t, 53, d.u32())
+	assert.EqualValues(t, 45678, d.u32())
+	assert.EqualValues(t, 0, d.u32())
+	assert.EqualValues(t, 0, d.u32())
+
+	// counter sample of the interface
+	assert.EqualValues(t, 2, d.u32()) // counter sample format
+	d.u32()
+	assert.EqualValues(t, 1, d.u32()) // sequence number
+	assert.EqualValues(t, 3, d.u32()) // source ID
+	require.EqualValues(t, 1, d.u32())
+	assert.EqualValues(t, 1, d.u32())  // generic interface counters
+	assert.EqualValues(t, 88, d.u32()) // length
+	assert.EqualValues(t, 3, d.u32())  // ifIndex
+	d.bytes(4 + 8 + 4)                 // ifType, ifSpeed, ifDirection
+	assert.EqualValues(t, 3, d.u32())  // ifStatus
+	assert.EqualValues(t, 1000, d.u64())
+	assert.EqualValues(t, 4, d.u32())
+	d.bytes(5 * 4)
+	assert.EqualValues(t, 300, d.u64())
+	assert.EqualValues(t, 2, d.u32())
+	d.bytes(5 * 4)
+
+	// the whole datagram has been read
+	assert.Zero(t, d.r.Len())
+}
+
+func TestSFlow_SplitDatagrams(t *testing.T) {
+	sf := &SFlow{clock: time.Now, samplePool: map[uint32]uint32{}, ifCounters: map[uint32]*sflowIfCounters{}}
+	records := make([]*flow.Record, 50)
+	for i := range records {
+		records[i] = &flow.Record{}
+		records[i].Id.EthProtocol = 0x0800
+		records[i].Metrics.Packets = 1
+	}
+	datagrams := sf.datagrams(records)
+	require.Greater(t, len(datagrams), 1)
+	samples := 0
+	for i, d := range datagrams {
+		assert.LessOrEqual(t, len(d), sflowMaxDatagramSize)
+		dec := sflowDecoder{t: t, r: bytes.NewReader(d)}
+		dec.bytes(4 + 4 + 4 + 4) // version, address, sub agent
+		assert.EqualValues(t, i+1, dec.u32())
+		dec.u32()
+		samples += int(dec.u32())
+	}
+	assert.Equal(t, 50, samples)
+}
+
+func TestSFlow_FlowIdentity(t *testing.T) {
+	sf := &SFlow{clock: time.Now, samplePool: map[uint32]uint32{}, ifCounters: map[uint32]*sflowIfCounters{}}
+	record := &flow.Record{ClusterID: "east", TenantID: "team-a"}
+	record.Metrics.Packets = 1
+
+	d := sflowDecoder{t: t, r: bytes.NewReader(sf.flowSample(record))}
+	d.bytes(4 + 4 + 4*7) // format, length and flow sample fields
+	require.EqualValues(t, 2, d.u32())
+	assert.EqualValues(t, 2, d.u32()) // sampled Ethernet
+	d.bytes(4 + 24)
+	// the identity record is in the enterprise space: 32473 << 12 | 1
+	assert.EqualValues(t, 0x7ed9001, d.u32())
+	assert.EqualValues(t, 4+4+4+8, d.u32()) // length
+	assert.EqualValues(t, 4, d.u32())
+	assert.Equal(t, []byte("east"), d.bytes(4))
+	assert.EqualValues(t, 6, d.u32())
+	assert.Equal(t, []byte("team-a\x00\x00"), d.bytes(8))
+	assert.Zero(t, d.r.Len())
+}
+
+func TestSFlow_InvalidEndpoint(t *testing.T) {
+	_, err := StartSFlow("", 6343, false)
+	assert.Error(t, err)
+	_, err = StartSFlow("127.0.0.1", 0, false)
+	assert.Error(t, err)
+}
+
+type sflowDecoder struct {
+	t *testing.T
+	r *bytes.Reader
+}
+
+func (d *sflowDecoder) u32() uint32 {
+	var v uint32
+	require.NoError(d.t, binary.Read(d.r, binary.BigEndian, &v))
+	return v
+}
+
+func (d *sflowDecoder) u64() uint64 {
+	var v uint64
+	require.NoError(d.t, binary.Read(d.r, binary.BigEndian, &v))
+	return v
+}
+
+func (d *sflowDecoder) bytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := d.r.Read(b)
+	require.NoError(d.t, err)
+	return b
+}
@@ -0,0 +1,107 @@
+package exporter
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of the streams of the file and unix exporters
+const (
+	SinkCompressionNone = "none"
+	SinkCompressionGzip = "gzip"
+	SinkCompressionZstd = "zstd"
+)
+
+// sinkWriter writes the encoded flows into the destination of a stream-based exporter. Flush
+// must be invoked after each batch of flows, so the consumers can decode the stream
+// incrementally without waiting for it to be closed.
+type sinkWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// newSinkWriter returns a sinkWriter that compresses the stream written to dst, according to
+// the provided compression: none (default if empty), gzip or zstd. Closing it finishes the
+// compressed stream and closes dst.
+func newSinkWriter(dst io.WriteCloser, compression string) (sinkWriter, error) {
+	if err := checkSinkCompression(compression); err != nil {
+		return nil, err
+	}
+	// gzip.Writer and zstd.Encoder don't close dst, so they are wrapped by compressedSink
+	var comp sinkWriter
+	switch compression {
+	case "", SinkCompressionNone:
+		return plainSink{WriteCloser: dst}, nil
+	case SinkCompressionGzip:
+		comp = gzip.NewWriter(dst)
+	case SinkCompressionZstd:
+		// a single goroutine is enough, as each batch is flushed right after being written
+		encoder, err := zstd.NewWriter(dst, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("creating zstd encoder: %w", err)
+		}
+		comp = encoder
+	}
+	return &compressedSink{sinkWriter: comp, dst: dst}, nil
+}
+
+// checkSinkCompression returns error if the compression is not supported
+func checkSinkCompression(compression string) error {
+	switch compression {
+	case "", SinkCompressionNone, SinkCompressionGzip, SinkCompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("wrong compression %q. Admitted values are %s, %s, %s",
+		compression, SinkCompressionNone, SinkCompressionGzip, SinkCompressionZstd)
+}
+
+// newSinkReader returns a reader that decompresses a stream written by a sinkWriter with the
+// same compression. The consecutive compressed streams (e.g. appended to the same file by
+// different executions of the agent) are read as a single stream. Closing the reader doesn't
+// close src.
+func newSinkReader(src io.Reader, compression string) (io.ReadCloser, error) {
+	if err := checkSinkCompression(compression); err != nil {
+		return nil, err
+	}
+	switch compression {
+	case SinkCompressionGzip:
+		reader, err := gzip.NewReader(src)
+		if errors.Is(err, io.EOF) {
+			// empty stream
+			return io.NopCloser(src), nil
+		}
+		return reader, err
+	case SinkCompressionZstd:
+		decoder, err := zstd.NewReader(src, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return io.NopCloser(src), nil
+}
+
+type plainSink struct {
+	io.WriteCloser
+}
+
+func (plainSink) Flush() error {
+	return nil
+}
+
+type compressedSink struct {
+	sinkWriter
+	dst io.Closer
+}
+
+func (cs *compressedSink) Close() error {
+	err := cs.sinkWriter.Close()
+	if cerr := cs.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
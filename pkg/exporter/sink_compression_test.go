@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func compressedRecord(srcPort uint16) *flow.Record {
+	r := &flow.Record{Interface: "eth0", AgentIP: net.ParseIP("10.0.0.1")}
+	r.Id.SrcPort = srcPort
+	r.Metrics.Bytes = 1234
+	r.Metrics.Packets = 5
+	return r
+}
+
+func TestFileJSON_Compression(t *testing.T) {
+	for _, compression := range []string{SinkCompressionGzip, SinkCompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			file := path.Join(t.TempDir(), "flows.json."+compression)
+
+			// GIVEN a file exporter with compression and deduplication
+			fe, err := StartFileJSON(file, 2, EncodingJSON, FieldCasePascal, false, compression)
+			require.NoError(t, err)
+			input := make(chan []*flow.Record, 10)
+			input <- []*flow.Record{compressedRecord(1), compressedRecord(2)}
+			close(input)
+			fe.ExportFlows(input)
+
+			// WHEN the agent restarts and appends more records to the same file
+			fe, err = StartFileJSON(file, 2, EncodingJSON, FieldCasePascal, false, compression)
+			require.NoError(t, err)
+			input = make(chan []*flow.Record, 10)
+			input <- []*flow.Record{compressedRecord(2), compressedRecord(3)}
+
+			// THEN the written batch is readable before the file is closed
+			go fe.ExportFlows(input)
+			var records []map[string]interface{}
+			require.Eventually(t, func() bool {
+				records = readCompressedRecords(t, file, compression)
+				return len(records) == 3
+			}, 5*time.Second, 10*time.Millisecond)
+			close(input)
+
+			// AND all the records are decompressed back, skipping the duplicates from the
+			// previous execution
+			var ports []float64
+			for _, record := range records {
+				ports = append(ports, record["Id"].(map[string]interface{})["SrcPort"].(float64))
+				assert.Equal(t, "eth0", record["Interface"])
+				assert.Equal(t, "10.0.0.1", record["AgentIP"])
+				metrics := record["Metrics"].(map[string]interface{})
+				assert.EqualValues(t, 1234, metrics["Bytes"])
+				assert.EqualValues(t, 5, metrics["Packets"])
+			}
+			assert.Equal(t, []float64{1, 2, 3}, ports)
+		})
+	}
+}
+
+func TestUnixSocket_Compression(t *testing.T) {
+	socket := path.Join(t.TempDir(), "flows.sock")
+	us, err := StartUnixSocket(socket, 10, EncodingJSON, FieldCaseSnake, false, SinkCompressionZstd)
+	require.NoError(t, err)
+	input := make(chan []*flow.Record, 10)
+	go us.ExportFlows(input)
+	defer close(input)
+
+	// GIVEN a connected consumer that decompresses the stream
+	conn := connectConsumer(t, us, socket)
+	defer conn.Close()
+	reader, err := newSinkReader(conn, SinkCompressionZstd)
+	require.NoError(t, err)
+	defer reader.Close()
+	lines := bufio.NewReader(reader)
+
+	// WHEN flows are exported
+	input <- []*flow.Record{compressedRecord(1), compressedRecord(2)}
+
+	// THEN they are decompressed as JSON lines, without waiting for the stream to be closed
+	assert.EqualValues(t, 1, readUnixSrcPort(t, conn, lines))
+	assert.EqualValues(t, 2, readUnixSrcPort(t, conn, lines))
+}
+
+func TestSinkCompression_Invalid(t *testing.T) {
+	_, err := StartFileJSON(path.Join(t.TempDir(), "flows.json"), 0, EncodingJSON,
+		FieldCasePascal, false, "lz4")
+	assert.Error(t, err)
+	_, err = StartUnixSocket(path.Join(t.TempDir(), "flows.sock"), 10, EncodingJSON,
+		FieldCasePascal, false, "lz4")
+	assert.Error(t, err)
+}
+
+func readCompressedRecords(t *testing.T, file, compression string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	reader, err := newSinkReader(f, compression)
+	require.NoError(t, err)
+	defer reader.Close()
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		record := map[string]interface{}{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	// the last stream is not finished while the exporter is writing it
+	return records
+}
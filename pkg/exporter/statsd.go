@@ -0,0 +1,184 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/utils"
+)
+
+var slog = logrus.WithField("component", "exporter/StatsD")
+
+// statsdMaxPacketSize is the maximum size of the UDP packets sent to the StatsD endpoint,
+// to avoid IP fragmentation in most networks
+const statsdMaxPacketSize = 1432
+
+// StatsDTagFields maps the names of the flow fields that can be used as StatsD tags to the
+// functions extracting their values from the flow records
+var StatsDTagFields = map[string]func(*flow.Record) string{
+	"interface": func(r *flow.Record) string { return r.Interface },
+	"direction": func(r *flow.Record) string { return directionName(r.Id.Direction) },
+	"protocol":  func(r *flow.Record) string { return protocolName(r.Id.TransportProtocol) },
+	"srcAddr":   func(r *flow.Record) string { return flow.IP(r.Id.SrcIp).String() },
+	"dstAddr":   func(r *flow.Record) string { return flow.IP(r.Id.DstIp).String() },
+	"srcPort":   func(r *flow.Record) string { return strconv.Itoa(int(r.Id.SrcPort)) },
+	"dstPort":   func(r *flow.Record) string { return strconv.Itoa(int(r.Id.DstPort)) },
+	"srcMac": func(r *flow.Record) string {
+		mac := flow.MacAddr(r.Id.SrcMac)
+		return mac.String()
+	},
+	"dstMac": func(r *flow.Record) string {
+		mac := flow.MacAddr(r.Id.DstMac)
+		return mac.String()
+	},
+	"agentIP":      func(r *flow.Record) string { return r.AgentIP.String() },
+	"clusterID":    func(r *flow.Record) string { return r.ClusterID },
+	"tenantID":     func(r *flow.Record) string { return r.TenantID },
+	"trafficClass": func(r *flow.Record) string { return r.TrafficClass.String() },
+}
+
+// StatsD exporter submits the bytes and packets of the flows as StatsD counters, in the
+// DogStatsD format (with tags), to a UDP endpoint. The flows of each exported batch are
+// aggregated by the values of the configured tags, so the cardinality of the metrics is
+// bounded by the selected tag fields.
+type StatsD struct {
+	conn    net.Conn
+	prefix  string
+	tags    []string
+	tagFunc []func(*flow.Record) string
+}
+
+// StartStatsD creates a StatsD exporter that sends the metrics to the provided UDP endpoint.
+// The name of the metrics is prefixed by the provided prefix, and each metric is tagged with
+// the provided fields (see StatsDTagFields for the accepted values).
+func StartStatsD(hostIP string, hostPort int, prefix string, tags []string) (*StatsD, error) {
+	if hostIP == "" || hostPort <= 0 {
+		return nil, fmt.Errorf("invalid StatsD endpoint: %s:%d", hostIP, hostPort)
+	}
+	sd := &StatsD{prefix: prefix}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		tf, ok := StatsDTagFields[tag]
+		if !ok {
+			return nil, fmt.Errorf("unknown StatsD tag %q", tag)
+		}
+		sd.tags = append(sd.tags, tag)
+		sd.tagFunc = append(sd.tagFunc, tf)
+	}
+	socket := utils.GetSocket(hostIP, hostPort)
+	addr, err := net.ResolveUDPAddr("udp", socket)
+	if err != nil {
+		return nil, fmt.Errorf("resolving StatsD endpoint %s: %w", socket, err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to StatsD endpoint %s: %w", socket, err)
+	}
+	sd.conn = conn
+	return sd, nil
+}
+
+// statsdCounts accumulates the metrics of the flows sharing the same tag values
+type statsdCounts struct {
+	bytes   uint64
+	packets uint64
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, aggregates them by
+// the configured tags and submits their counters to the StatsD endpoint
+func (sd *StatsD) ExportFlows(input <-chan []*flow.Record) {
+	slog.WithField("endpoint", sd.conn.RemoteAddr()).Info("starting StatsD exporter")
+	for records := range input {
+		sd.send(sd.aggregate(records))
+	}
+	if err := sd.conn.Close(); err != nil {
+		slog.WithError(err).Warn("couldn't close StatsD connection")
+	}
+}
+
+// aggregate the flow records by the DogStatsD tags suffix
+func (sd *StatsD) aggregate(records []*flow.Record) map[string]*statsdCounts {
+	counts := map[string]*statsdCounts{}
+	tags := strings.Builder{}
+	for _, record := range records {
+		if record.IsHeartbeat() {
+			continue
+		}
+		tags.Reset()
+		for i, tag := range sd.tags {
+			if i == 0 {
+				tags.WriteString("|#")
+			} else {
+				tags.WriteByte(',')
+			}
+			tags.WriteString(tag)
+			tags.WriteByte(':')
+			tags.WriteString(sanitizeStatsDTag(sd.tagFunc[i](record)))
+		}
+		c, ok := counts[tags.String()]
+		if !ok {
+			c = &statsdCounts{}
+			counts[tags.String()] = c
+		}
+		c.bytes += record.Metrics.Bytes
+		c.packets += uint64(record.Metrics.Packets)
+	}
+	return counts
+}
+
+func (sd *StatsD) send(counts map[string]*statsdCounts) {
+	// sorting the tags for a deterministic output
+	tags := make([]string, 0, len(counts))
+	for t := range counts {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	packet := bytes.Buffer{}
+	for _, t := range tags {
+		c := counts[t]
+		for _, line := range []string{
+			sd.prefix + "bytes:" + strconv.FormatUint(c.bytes, 10) + "|c" + t,
+			sd.prefix + "packets:" + strconv.FormatUint(c.packets, 10) + "|c" + t,
+		} {
+			if packet.Len() > 0 && packet.Len()+len(line)+1 > statsdMaxPacketSize {
+				sd.write(packet.Bytes())
+				packet.Reset()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+	}
+	if packet.Len() > 0 {
+		sd.write(packet.Bytes())
+	}
+}
+
+func (sd *StatsD) write(packet []byte) {
+	if _, err := sd.conn.Write(packet); err != nil {
+		slog.WithError(err).Error("can't send metrics to StatsD endpoint")
+	}
+}
+
+// sanitizeStatsDTag replaces the characters that have a special meaning in the DogStatsD
+// format
+func sanitizeStatsDTag(value string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '|', ',', '#', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,105 @@
+package exporter
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func TestStatsD(t *testing.T) {
+	// GIVEN a StatsD endpoint
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer server.Close()
+	port := server.LocalAddr().(*net.UDPAddr).Port
+
+	// AND a StatsD exporter that tags the metrics by interface and protocol
+	sd, err := StartStatsD("127.0.0.1", port, "netobserv.", []string{"interface", "protocol"})
+	require.NoError(t, err)
+
+	record := func(iface string, proto uint8, srcPort uint16, bytes uint64, packets uint32) *flow.Record {
+		r := &flow.Record{Interface: iface}
+		r.Id.TransportProtocol = proto
+		r.Id.SrcPort = srcPort
+		r.Metrics.Bytes = bytes
+		r.Metrics.Packets = packets
+		return r
+	}
+	// WHEN it exports flows
+	input := make(chan []*flow.Record, 10)
+	input <- []*flow.Record{
+		record("eth0", 6, 1234, 100, 2),
+		record("eth0", 6, 4321, 200, 3),
+		record("eth0", 17, 53, 60, 1),
+		record("eth1", 6, 8080, 1000, 10),
+	}
+	close(input)
+	go sd.ExportFlows(input)
+
+	// THEN the flows are aggregated by the configured tags
+	buf := make([]byte, statsdMaxPacketSize)
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(timeout)))
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"netobserv.bytes:300|c|#interface:eth0,protocol:tcp",
+		"netobserv.packets:5|c|#interface:eth0,protocol:tcp",
+		"netobserv.bytes:60|c|#interface:eth0,protocol:udp",
+		"netobserv.packets:1|c|#interface:eth0,protocol:udp",
+		"netobserv.bytes:1000|c|#interface:eth1,protocol:tcp",
+		"netobserv.packets:10|c|#interface:eth1,protocol:tcp",
+	}, strings.Split(string(buf[:n]), "\n"))
+}
+
+func TestStatsD_SplitPackets(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer server.Close()
+	port := server.LocalAddr().(*net.UDPAddr).Port
+
+	// GIVEN a StatsD exporter with per-flow tags
+	sd, err := StartStatsD("127.0.0.1", port, "", []string{"srcAddr", "dstAddr", "srcPort"})
+	require.NoError(t, err)
+
+	// WHEN it exports more flows than fit in a single UDP packet
+	var records []*flow.Record
+	for i := 0; i < 100; i++ {
+		r := &flow.Record{}
+		r.Id.SrcPort = uint16(i)
+		r.Metrics.Bytes = 1
+		records = append(records, r)
+	}
+	input := make(chan []*flow.Record, 1)
+	input <- records
+	close(input)
+	go sd.ExportFlows(input)
+
+	// THEN the metrics are split into multiple packets
+	lines := 0
+	buf := make([]byte, 64*1024)
+	for lines < 200 {
+		require.NoError(t, server.SetReadDeadline(time.Now().Add(timeout)))
+		n, err := server.Read(buf)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, statsdMaxPacketSize)
+		lines += len(strings.Split(string(buf[:n]), "\n"))
+	}
+	assert.Equal(t, 200, lines)
+}
+
+func TestStartStatsD_Errors(t *testing.T) {
+	_, err := StartStatsD("", 8125, "", nil)
+	assert.Error(t, err)
+	_, err = StartStatsD("127.0.0.1", 0, "", nil)
+	assert.Error(t, err)
+	_, err = StartStatsD("127.0.0.1", 8125, "", []string{"foo"})
+	assert.Error(t, err)
+	_, err = StartStatsD("invalid host name", 8125, "", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,218 @@
+package exporter
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/utils"
+)
+
+var sylog = logrus.WithField("component", "exporter/Syslog")
+
+const (
+	syslogAppName = "netobserv-ebpf-agent"
+	syslogMsgID   = "flow"
+	// syslogSDID is the identifier of the flow structured data element. 32473 is the private
+	// enterprise number reserved for documentation purposes (RFC 5612)
+	syslogSDID = "flow@32473"
+	// syslogMinMessageSize is the minimum size that a syslog receiver must accept (RFC 5426)
+	syslogMinMessageSize = 480
+	// syslogNilValue represents an empty field in the RFC 5424 format
+	syslogNilValue = "-"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// Syslog exporter sends each flow as an RFC 5424 syslog message, whose structured data contains
+// the flow fields, to a UDP or TCP syslog endpoint. The messages sent over TCP are framed
+// with the octet-counting method (RFC 6587).
+type Syslog struct {
+	conn      net.Conn
+	transport string
+	address   string
+	priority  int
+	maxSize   int
+	hostname  string
+	procID    string
+	clock     func() time.Time
+}
+
+// StartSyslog creates a Syslog exporter that sends the flows to the provided endpoint, over the
+// given transport ("udp" or "tcp"). The facility and severity names are the ones defined in
+// RFC 5424 (e.g. "local0", "info"). Messages longer than maxSize bytes are truncated.
+func StartSyslog(
+	hostIP string, hostPort int, transport, facility, severity string, maxSize int,
+) (*Syslog, error) {
+	if hostIP == "" || hostPort <= 0 {
+		return nil, fmt.Errorf("invalid syslog endpoint: %s:%d", hostIP, hostPort)
+	}
+	if transport != "udp" && transport != "tcp" {
+		return nil, fmt.Errorf("invalid syslog transport %q. Accepted values are udp, tcp", transport)
+	}
+	fac, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", facility)
+	}
+	sev, ok := syslogSeverities[severity]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog severity %q", severity)
+	}
+	if maxSize < syslogMinMessageSize {
+		return nil, fmt.Errorf("syslog max message size must be at least %d. Got: %d",
+			syslogMinMessageSize, maxSize)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = syslogNilValue
+	}
+	sl := &Syslog{
+		transport: transport,
+		address:   utils.GetSocket(hostIP, hostPort),
+		priority:  fac*8 + sev,
+		maxSize:   maxSize,
+		hostname:  hostname,
+		procID:    strconv.Itoa(os.Getpid()),
+		clock:     time.Now,
+	}
+	if err := sl.connect(); err != nil {
+		return nil, err
+	}
+	return sl, nil
+}
+
+func (sl *Syslog) connect() error {
+	conn, err := net.Dial(sl.transport, sl.address)
+	if err != nil {
+		return fmt.Errorf("connecting to syslog endpoint %s: %w", sl.address, err)
+	}
+	sl.conn = conn
+	return nil
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, and sends each flow as a
+// syslog message
+func (sl *Syslog) ExportFlows(input <-chan []*flow.Record) {
+	sylog.WithField("endpoint", sl.address).Info("starting syslog exporter")
+	for records := range input {
+		for _, record := range records {
+			sl.send(sl.format(record))
+		}
+	}
+	if sl.conn != nil {
+		if err := sl.conn.Close(); err != nil {
+			sylog.WithError(err).Warn("couldn't close syslog connection")
+		}
+	}
+}
+
+func (sl *Syslog) send(msg []byte) {
+	if sl.conn == nil {
+		// the TCP connection was lost. Trying to reconnect
+		if err := sl.connect(); err != nil {
+			sylog.WithError(err).Debug("can't reconnect to syslog endpoint. Discarding flow")
+			return
+		}
+	}
+	if sl.transport == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	if _, err := sl.conn.Write(msg); err != nil {
+		sylog.WithError(err).Error("can't send flow to syslog endpoint")
+		if sl.transport == "tcp" {
+			_ = sl.conn.Close()
+			sl.conn = nil
+		}
+	}
+}
+
+// format a flow record as an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [STRUCTURED-DATA] MSG
+// If the message exceeds the maximum size, the free-form MSG part is truncated first. If it is
+// still too long, the structured data is omitted, so the message is never malformed.
+func (sl *Syslog) format(record *flow.Record) []byte {
+	header := fmt.Sprintf("<%d>1 %s %s %s %s %s ",
+		sl.priority, sl.clock().UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		sl.hostname, syslogAppName, sl.procID, syslogMsgID)
+	sd := syslogStructuredData(record)
+	msg := fmt.Sprintf("%s:%d > %s:%d %s %d bytes %d packets",
+		flow.IP(record.Id.SrcIp), record.Id.SrcPort, flow.IP(record.Id.DstIp), record.Id.DstPort,
+		protocolName(record.Id.TransportProtocol), record.Metrics.Bytes, record.Metrics.Packets)
+
+	if len(header)+len(sd) > sl.maxSize {
+		sd = syslogNilValue
+	}
+	out := bytes.NewBufferString(header)
+	out.WriteString(sd)
+	if room := sl.maxSize - out.Len() - 1; room > 0 {
+		out.WriteByte(' ')
+		out.WriteString(truncateUTF8(msg, room))
+	}
+	return out.Bytes()
+}
+
+func syslogStructuredData(record *flow.Record) string {
+	sd := strings.Builder{}
+	sd.WriteString("[" + syslogSDID)
+	params := []struct{ name, value string }{
+		{"srcAddr", flow.IP(record.Id.SrcIp).String()},
+		{"dstAddr", flow.IP(record.Id.DstIp).String()},
+		{"srcPort", strconv.Itoa(int(record.Id.SrcPort))},
+		{"dstPort", strconv.Itoa(int(record.Id.DstPort))},
+		{"proto", protocolName(record.Id.TransportProtocol)},
+		{"direction", directionName(record.Id.Direction)},
+		{"interface", record.Interface},
+		{"bytes", strconv.FormatUint(record.Metrics.Bytes, 10)},
+		{"packets", strconv.FormatUint(uint64(record.Metrics.Packets), 10)},
+		{"timeFlowStartMs", strconv.FormatInt(record.TimeFlowStart.UnixMilli(), 10)},
+		{"timeFlowEndMs", strconv.FormatInt(record.TimeFlowEnd.UnixMilli(), 10)},
+	}
+	if record.ClusterID != "" {
+		params = append(params, struct{ name, value string }{"clusterID", record.ClusterID})
+	}
+	if record.TenantID != "" {
+		params = append(params, struct{ name, value string }{"tenantID", record.TenantID})
+	}
+	for _, param := range params {
+		sd.WriteString(" " + param.name + `="`)
+		sd.WriteString(escapeSDParam(param.value))
+		sd.WriteByte('"')
+	}
+	sd.WriteByte(']')
+	return sd.String()
+}
+
+// escapeSDParam escapes the characters that must be escaped in the structured data parameter
+// values, according to RFC 5424
+func escapeSDParam(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// truncateUTF8 truncates the string to at most maxLen bytes, without splitting multi-byte
+// characters
+func truncateUTF8(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	for maxLen > 0 && !utf8.RuneStart(s[maxLen]) {
+		maxLen--
+	}
+	return s[:maxLen]
+}
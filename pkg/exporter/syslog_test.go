@@ -0,0 +1,142 @@
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+func syslogTestRecord() *flow.Record {
+	r := &flow.Record{
+		TimeFlowStart: time.UnixMilli(1_600_000_000_000),
+		TimeFlowEnd:   time.UnixMilli(1_600_000_001_000),
+		Interface:     `eth"0]`,
+	}
+	r.Id.SrcIp = IPAddrFromNetIP(net.ParseIP("10.0.0.1"))
+	r.Id.DstIp = IPAddrFromNetIP(net.ParseIP("10.0.0.2"))
+	r.Id.SrcPort = 34567
+	r.Id.DstPort = 443
+	r.Id.TransportProtocol = 6
+	r.Id.Direction = flow.DirectionEgress
+	r.Metrics.Bytes = 1234
+	r.Metrics.Packets = 5
+	return r
+}
+
+func TestSyslog_UDP(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer server.Close()
+
+	sl, err := StartSyslog("127.0.0.1", server.LocalAddr().(*net.UDPAddr).Port,
+		"udp", "local3", "notice", 2048)
+	require.NoError(t, err)
+	sl.hostname = "node-1"
+	sl.procID = "123"
+	sl.clock = func() time.Time { return time.Date(2023, 2, 1, 10, 20, 30, 456789000, time.UTC) }
+
+	input := make(chan []*flow.Record, 1)
+	input <- []*flow.Record{syslogTestRecord()}
+	close(input)
+	go sl.ExportFlows(input)
+
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 4096)
+	n, err := server.Read(buf)
+	require.NoError(t, err)
+	// local3 (19) * 8 + notice (5) = 157
+	assert.Equal(t, `<157>1 2023-02-01T10:20:30.456789Z node-1 netobserv-ebpf-agent 123 flow `+
+		`[flow@32473 srcAddr="10.0.0.1" dstAddr="10.0.0.2" srcPort="34567" dstPort="443" `+
+		`proto="tcp" direction="egress" interface="eth\"0\]" bytes="1234" packets="5" `+
+		`timeFlowStartMs="1600000000000" timeFlowEndMs="1600000001000"] `+
+		`10.0.0.1:34567 > 10.0.0.2:443 tcp 1234 bytes 5 packets`,
+		string(buf[:n]))
+}
+
+func TestSyslog_TCPFraming(t *testing.T) {
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer server.Close()
+
+	sl, err := StartSyslog("127.0.0.1", server.Addr().(*net.TCPAddr).Port,
+		"tcp", "user", "info", 2048)
+	require.NoError(t, err)
+	sl.clock = func() time.Time { return time.Date(2023, 2, 1, 10, 20, 30, 0, time.UTC) }
+	expected := sl.format(syslogTestRecord())
+	conn, err := server.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	input := make(chan []*flow.Record, 1)
+	input <- []*flow.Record{syslogTestRecord(), syslogTestRecord()}
+	close(input)
+	go sl.ExportFlows(input)
+
+	// messages are framed with the octet-counting method: "LENGTH SP MESSAGE"
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		length, err := reader.ReadString(' ')
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(len(expected))+" ", length)
+		msg := make([]byte, len(expected))
+		_, err = io.ReadFull(reader, msg)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(msg))
+		// user (1) * 8 + info (6) = 14
+		assert.True(t, strings.HasPrefix(string(msg), "<14>1 "), string(msg))
+	}
+}
+
+func TestSyslog_Truncation(t *testing.T) {
+	sl := &Syslog{priority: 14, hostname: "node-1", procID: "1",
+		clock: func() time.Time { return time.Unix(1_600_000_000, 0) }}
+	record := syslogTestRecord()
+	sl.maxSize = 4096
+	full := string(sl.format(record))
+
+	// the free-form message is truncated first
+	sl.maxSize = len(full) - 10
+	truncated := string(sl.format(record))
+	assert.Len(t, truncated, sl.maxSize)
+	assert.Contains(t, truncated, "[flow@32473 ")
+	assert.True(t, strings.HasPrefix(full, truncated))
+
+	// if the structured data doesn't fit, it is omitted instead of being malformed
+	sl.maxSize = syslogMinMessageSize
+	record.Interface = strings.Repeat("ñ", 300)
+	truncated = string(sl.format(record))
+	assert.LessOrEqual(t, len(truncated), syslogMinMessageSize)
+	assert.NotContains(t, truncated, "[flow@32473")
+	assert.Contains(t, truncated, " flow - 10.0.0.1:34567 > 10.0.0.2:443")
+}
+
+func TestStartSyslog_Validation(t *testing.T) {
+	_, err := StartSyslog("", 514, "udp", "local0", "info", 2048)
+	assert.Error(t, err)
+	_, err = StartSyslog("127.0.0.1", 514, "sctp", "local0", "info", 2048)
+	assert.Error(t, err)
+	_, err = StartSyslog("127.0.0.1", 514, "udp", "local9", "info", 2048)
+	assert.Error(t, err)
+	_, err = StartSyslog("127.0.0.1", 514, "udp", "local0", "verbose", 2048)
+	assert.Error(t, err)
+	_, err = StartSyslog("127.0.0.1", 514, "udp", "local0", "info", 100)
+	assert.Error(t, err)
+
+	// unreachable TCP endpoint
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := server.Addr().(*net.TCPAddr).Port
+	require.NoError(t, server.Close())
+	_, err = StartSyslog("127.0.0.1", port, "tcp", "local0", "info", 2048)
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+var ulog = logrus.WithField("component", "exporter/UnixSocketJSON")
+
+// UnixSocketJSON streams the flows, as one JSON record per line (or as consecutive MessagePack
+// maps, if configured), to a consumer connected to a Unix domain socket that is created by the
+// agent. Only one consumer is served at a time: if a new consumer connects, it replaces the
+// previous one. If the consumer disconnects, the flows are discarded until a new consumer
+// connects. If compression is configured, each consumer receives a new compressed stream.
+type UnixSocketJSON struct {
+	listener    net.Listener
+	encoder     *RecordEncoder
+	compression string
+	bufLen      int
+	// accepted connections, pending to be used by the writer goroutine
+	conns chan net.Conn
+	// current consumer stream. Only accessed from the writer goroutine
+	conn sinkWriter
+}
+
+// StartUnixSocket creates a Unix domain socket in the provided path and starts accepting
+// consumers' connections. If a stale socket file exists in the path, it is replaced.
+// The bufLen argument is the number of flow batches that can be buffered while the consumer is
+// slow. When this buffer is full, the incoming flow batches are dropped.
+// The encoding, fieldCase and protocolNames arguments specify the serialization of the flows
+// (see NewRecordEncoder), and the compression argument the compression of the stream: none,
+// gzip or zstd.
+func StartUnixSocket(
+	path string, bufLen int, encoding, fieldCase string, protocolNames bool,
+	compression string,
+) (*UnixSocketJSON, error) {
+	if path == "" {
+		return nil, errors.New("missing Unix socket path")
+	}
+	encoder, err := NewRecordEncoder(encoding, fieldCase, protocolNames)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSinkCompression(compression); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(path)
+	if err := unix.Access(dir, unix.W_OK); err != nil {
+		return nil, fmt.Errorf("directory %s is not writable: %w", dir, err)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s already exists and is not a socket", path)
+		}
+		// stale socket from a previous execution
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing previous socket: %w", err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on Unix socket: %w", err)
+	}
+	if bufLen < 1 {
+		bufLen = 1
+	}
+	us := &UnixSocketJSON{
+		listener:    listener,
+		encoder:     encoder,
+		compression: compression,
+		bufLen:      bufLen,
+		conns:       make(chan net.Conn, 1),
+	}
+	go us.acceptLoop()
+	return us, nil
+}
+
+func (us *UnixSocketJSON) acceptLoop() {
+	for {
+		conn, err := us.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			ulog.WithError(err).Warn("can't accept consumer connection")
+			continue
+		}
+		ulog.Info("flows consumer connected")
+		// discard any accepted connection that hasn't been used yet by the writer
+		select {
+		case old := <-us.conns:
+			_ = old.Close()
+		default:
+		}
+		us.conns <- conn
+	}
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, encodes them and writes them
+// to the connected consumer. Like the rest of the pipeline, the flows are forwarded through a
+// CapacityLimiter, so they are dropped instead of blocking the agent when the consumer is
+// slower than the flows' production.
+func (us *UnixSocketJSON) ExportFlows(input <-chan []*flow.Record) {
+	ulog.Info("starting Unix socket exporter")
+	pending := make(chan []*flow.Record, us.bufLen)
+	go func() {
+		flow.NewCapacityLimiter(context.Background(),
+			"the Unix socket consumer is slower than the flows' production").Limit(input, pending)
+		close(pending)
+	}()
+	for records := range pending {
+		us.write(records)
+	}
+	if err := us.listener.Close(); err != nil {
+		ulog.WithError(err).Warn("couldn't close Unix socket")
+	}
+	if us.conn != nil {
+		_ = us.conn.Close()
+	}
+}
+
+func (us *UnixSocketJSON) write(records []*flow.Record) {
+	select {
+	case conn := <-us.conns:
+		if us.conn != nil {
+			_ = us.conn.Close()
+			us.conn = nil
+		}
+		stream, err := newSinkWriter(conn, us.compression)
+		if err != nil {
+			ulog.WithError(err).Warn("can't create consumer stream")
+			_ = conn.Close()
+		} else {
+			us.conn = stream
+		}
+	default:
+	}
+	if us.conn == nil {
+		ulog.WithField("flows", len(records)).Debug("no consumer connected. Discarding flows")
+		return
+	}
+	_, err := us.conn.Write(encodeRecords(us.encoder, records, ulog))
+	if err == nil {
+		err = us.conn.Flush()
+	}
+	if err != nil {
+		ulog.WithError(err).Warn("can't write flows. Waiting for a consumer to reconnect")
+		_ = us.conn.Close()
+		us.conn = nil
+	}
+}
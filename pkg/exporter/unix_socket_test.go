@@ -0,0 +1,94 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	test2 "github.com/mariomac/guara/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+)
+
+const unixTestTimeout = 5 * time.Second
+
+func TestUnixSocket_Reconnect(t *testing.T) {
+	socket := path.Join(t.TempDir(), "flows.sock")
+	us, err := StartUnixSocket(socket, 10, EncodingJSON, FieldCaseSnake, false, SinkCompressionNone)
+	require.NoError(t, err)
+
+	input := make(chan []*flow.Record, 10)
+	go us.ExportFlows(input)
+	defer close(input)
+
+	record := func(srcPort uint16) *flow.Record {
+		r := &flow.Record{}
+		r.Id.SrcPort = srcPort
+		return r
+	}
+
+	// GIVEN a connected consumer
+	conn := connectConsumer(t, us, socket)
+	lines := bufio.NewReader(conn)
+
+	// WHEN flows are exported
+	input <- []*flow.Record{record(1), record(2)}
+	// THEN they are streamed as JSON lines
+	assert.EqualValues(t, 1, readUnixSrcPort(t, conn, lines))
+	assert.EqualValues(t, 2, readUnixSrcPort(t, conn, lines))
+
+	// WHEN the consumer disconnects and a new consumer connects
+	require.NoError(t, conn.Close())
+	conn = connectConsumer(t, us, socket)
+	defer conn.Close()
+	lines = bufio.NewReader(conn)
+
+	// THEN the flows are streamed to the new consumer
+	input <- []*flow.Record{record(3)}
+	assert.EqualValues(t, 3, readUnixSrcPort(t, conn, lines))
+}
+
+func TestUnixSocket_InvalidPath(t *testing.T) {
+	_, err := StartUnixSocket("", 10, EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	assert.Error(t, err)
+
+	_, err = StartUnixSocket(path.Join(t.TempDir(), "missing", "flows.sock"), 10,
+		EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	assert.Error(t, err)
+
+	// a path that exists but is not a socket is not overridden
+	file := path.Join(t.TempDir(), "flows.json")
+	require.NoError(t, os.WriteFile(file, []byte("{}"), 0o644))
+	_, err = StartUnixSocket(file, 10, EncodingJSON, FieldCasePascal, false, SinkCompressionNone)
+	assert.Error(t, err)
+}
+
+// connectConsumer connects to the socket and waits for the exporter to accept the connection
+func connectConsumer(t *testing.T, us *UnixSocketJSON, socket string) net.Conn {
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	test2.Eventually(t, unixTestTimeout, func(t require.TestingT) {
+		require.Len(t, us.conns, 1)
+	}, test2.Interval(10*time.Millisecond))
+	return conn
+}
+
+func readUnixSrcPort(t *testing.T, conn net.Conn, lines *bufio.Reader) uint16 {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(unixTestTimeout)))
+	line, err := lines.ReadBytes('\n')
+	require.NoError(t, err)
+	var record struct {
+		ID struct {
+			SrcPort uint16 `json:"src_port"`
+		} `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(line, &record), string(line))
+	return record.ID.SrcPort
+}
@@ -0,0 +1,327 @@
+package exporter
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // required by the WebSocket handshake, not used for security
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var wslog = logrus.WithField("component", "exporter/WebSocket")
+
+// magic GUID that is appended to the client key to compute the handshake accept key (RFC 6455)
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes (RFC 6455, section 5.2)
+const (
+	wsOpText  = 0x1
+	wsOpClose = 0x8
+)
+
+// maximum size of the payload of the frames sent by the clients, which are discarded anyway
+const wsMaxClientPayload = 64 * 1024
+
+// WebSocketFeed serves a read-only live feed of the flows to the WebSocket clients that connect
+// to it (e.g. live dashboards in a browser). Each flow is sent to all the connected clients as a
+// text message with the flow as a JSON record. It doesn't replace the main exporter: the feed
+// receives a copy of the exported flows, and the flows are discarded when no client is
+// connected. Each client has its own queue of pending flows, so a slow client never blocks
+// the agent nor the rest of the clients: when its queue is full, its oldest pending flows are
+// dropped in favor of the newest ones.
+type WebSocketFeed struct {
+	listener  net.Listener
+	server    *http.Server
+	encoder   *RecordEncoder
+	clientBuf int
+	mt        sync.Mutex
+	clients   map[*wsClient]struct{}
+	closed    bool
+	dropped   prometheus.Counter
+}
+
+type wsClient struct {
+	conn    net.Conn
+	pending chan []byte
+	once    sync.Once
+}
+
+// StartWebSocket starts listening for WebSocket clients in the provided address
+// (e.g. ":9999"). The clientBuf argument is the number of flows that can be queued for each
+// client. The fieldCase and protocolNames arguments specify the serialization of the flows
+// (see NewRecordEncoder).
+func StartWebSocket(
+	address string, clientBuf int, fieldCase string, protocolNames bool, m *metrics.Metrics,
+) (*WebSocketFeed, error) {
+	encoder, err := NewRecordEncoder("json", fieldCase, protocolNames)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("listening for WebSocket clients: %w", err)
+	}
+	if clientBuf < 1 {
+		clientBuf = 1
+	}
+	ws := &WebSocketFeed{
+		listener:  listener,
+		encoder:   encoder,
+		clientBuf: clientBuf,
+		clients:   map[*wsClient]struct{}{},
+		dropped: m.NewCounterVec("websocket_dropped_records_total",
+			"Number of records that have been dropped for a slow WebSocket client", "exporter").
+			WithLabelValues("websocket"),
+	}
+	ws.server = &http.Server{Handler: http.HandlerFunc(ws.serveClient)}
+	go func() {
+		wslog.WithField("address", listener.Addr()).Info("starting WebSocket server")
+		if err := ws.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			wslog.WithError(err).Error("WebSocket server stopped working")
+		}
+	}()
+	return ws, nil
+}
+
+// Addr returns the address where the WebSocket clients are accepted
+func (ws *WebSocketFeed) Addr() net.Addr {
+	return ws.listener.Addr()
+}
+
+// ExportFlows accepts slices of *flow.Record by its input channel, encodes them and queues them
+// for all the connected clients. It never blocks on the clients. When the input channel is
+// closed, the server is stopped and the clients are disconnected.
+func (ws *WebSocketFeed) ExportFlows(input <-chan []*flow.Record) {
+	wslog.Info("starting WebSocket exporter")
+	for records := range input {
+		ws.broadcast(records)
+	}
+	if err := ws.server.Close(); err != nil {
+		wslog.WithError(err).Warn("couldn't close WebSocket server")
+	}
+	ws.mt.Lock()
+	defer ws.mt.Unlock()
+	ws.closed = true
+	for client := range ws.clients {
+		client.close()
+		delete(ws.clients, client)
+	}
+}
+
+func (ws *WebSocketFeed) broadcast(records []*flow.Record) {
+	ws.mt.Lock()
+	defer ws.mt.Unlock()
+	if len(ws.clients) == 0 {
+		return
+	}
+	for _, record := range records {
+		encoded, err := ws.encoder.Encode(record)
+		if err != nil {
+			wslog.WithError(err).Warn("can't encode flow. Ignoring")
+			continue
+		}
+		// the frame is built once and shared by all the clients
+		frame := wsFrame(wsOpText, encoded)
+		for client := range ws.clients {
+			if !client.enqueue(frame) {
+				ws.dropped.Inc()
+			}
+		}
+	}
+}
+
+// enqueue adds a frame to the client queue. If the queue is full, the oldest frame is dropped
+// and false is returned. It must be invoked from a single goroutine at a time.
+func (c *wsClient) enqueue(frame []byte) bool {
+	select {
+	case c.pending <- frame:
+		return true
+	default:
+	}
+	select {
+	case <-c.pending:
+	default:
+		// the writer has just released some room
+	}
+	select {
+	case c.pending <- frame:
+	default:
+		// the frame doesn't fit yet, so it's the one that is dropped
+	}
+	return false
+}
+
+func (c *wsClient) close() {
+	c.once.Do(func() {
+		close(c.pending)
+		_ = c.conn.Close()
+	})
+}
+
+func (ws *WebSocketFeed) serveClient(rw http.ResponseWriter, req *http.Request) {
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet ||
+		!strings.EqualFold(req.Header.Get("Upgrade"), "websocket") ||
+		!headerContains(req.Header.Get("Connection"), "upgrade") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		http.Error(rw, "expecting a WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		http.Error(rw, "connection can't be upgraded", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		wslog.WithError(err).Warn("can't upgrade WebSocket connection")
+		return
+	}
+	// the client is registered before completing the handshake, so it doesn't miss any flow
+	// that is exported after it's connected
+	client := &wsClient{conn: conn, pending: make(chan []byte, ws.clientBuf)}
+	ws.mt.Lock()
+	if ws.closed {
+		// the feed has been stopped during the handshake
+		ws.mt.Unlock()
+		_ = conn.Close()
+		return
+	}
+	ws.clients[client] = struct{}{}
+	ws.mt.Unlock()
+	if _, err := fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		webSocketAccept(key)); err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		wslog.WithError(err).Warn("can't complete WebSocket handshake")
+		ws.disconnect(client)
+		return
+	}
+	wslog.WithField("client", conn.RemoteAddr()).Info("WebSocket client connected")
+	go ws.writeLoop(client)
+	go ws.readLoop(client, buf.Reader)
+}
+
+// writeLoop sends the queued frames to the client until its queue is closed
+func (ws *WebSocketFeed) writeLoop(client *wsClient) {
+	for frame := range client.pending {
+		if _, err := client.conn.Write(frame); err != nil {
+			wslog.WithError(err).Debug("can't write to WebSocket client")
+			ws.disconnect(client)
+			return
+		}
+	}
+}
+
+// readLoop discards the messages from the client, as the feed is read-only, until the client
+// closes the connection
+func (ws *WebSocketFeed) readLoop(client *wsClient, reader *bufio.Reader) {
+	defer ws.disconnect(client)
+	for {
+		opcode, err := discardFrame(reader)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				wslog.WithError(err).Debug("can't read from WebSocket client")
+			}
+			return
+		}
+		if opcode == wsOpClose {
+			// the connection is closed without echoing the close frame, as the frames can only
+			// be written from the writer goroutine
+			return
+		}
+	}
+}
+
+func (ws *WebSocketFeed) disconnect(client *wsClient) {
+	ws.mt.Lock()
+	defer ws.mt.Unlock()
+	if _, ok := ws.clients[client]; !ok {
+		return
+	}
+	delete(ws.clients, client)
+	client.close()
+	wslog.WithField("client", client.conn.RemoteAddr()).Info("WebSocket client disconnected")
+}
+
+// webSocketAccept returns the Sec-WebSocket-Accept header that answers a client key
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains tells whether a comma-separated header contains the token, ignoring case
+func headerContains(header, token string) bool {
+	for _, value := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsFrame builds an unmasked and unfragmented frame, as sent by the servers
+func wsFrame(opcode byte, payload []byte) []byte {
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|opcode)
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xFFFF:
+		frame = append(frame, 126, 0, 0)
+		binary.BigEndian.PutUint16(frame[2:], uint16(length))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(length))
+	}
+	return append(frame, payload...)
+}
+
+// discardFrame reads a frame from the client, discarding its payload, and returns its opcode
+func discardFrame(reader *bufio.Reader) (byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, err
+	}
+	opcode := header[0] & 0x0F
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			return 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(reader, ext[:]); err != nil {
+			return 0, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > wsMaxClientPayload {
+		return 0, fmt.Errorf("client frame too large: %d bytes", length)
+	}
+	if header[1]&0x80 != 0 {
+		// masking key
+		length += 4
+	}
+	if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+		return 0, err
+	}
+	return opcode, nil
+}
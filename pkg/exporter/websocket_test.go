@@ -0,0 +1,115 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/flow"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+const wsTestTimeout = 5 * time.Second
+
+func TestWebSocket_StreamRecords(t *testing.T) {
+	ws, err := StartWebSocket("127.0.0.1:0", 10, FieldCaseSnake, false, metrics.NoOp())
+	require.NoError(t, err)
+
+	input := make(chan []*flow.Record, 10)
+	go ws.ExportFlows(input)
+	defer close(input)
+
+	// GIVEN a connected WebSocket client
+	conn, reader := connectWebSocket(t, ws.Addr().String())
+	defer conn.Close()
+
+	// WHEN flows are exported
+	r1, r2 := &flow.Record{}, &flow.Record{}
+	r1.Id.SrcPort = 1
+	r2.Id.SrcPort = 2
+	input <- []*flow.Record{r1, r2}
+
+	// THEN each flow is received as a JSON text message
+	assert.EqualValues(t, 1, readWebSocketSrcPort(t, conn, reader))
+	assert.EqualValues(t, 2, readWebSocketSrcPort(t, conn, reader))
+}
+
+func TestWebSocket_RejectPlainHTTP(t *testing.T) {
+	ws, err := StartWebSocket("127.0.0.1:0", 10, FieldCaseSnake, false, metrics.NoOp())
+	require.NoError(t, err)
+	input := make(chan []*flow.Record)
+	go ws.ExportFlows(input)
+	defer close(input)
+
+	resp, err := http.Get("http://" + ws.Addr().String())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestWebSocket_DropOldest(t *testing.T) {
+	client := &wsClient{pending: make(chan []byte, 2)}
+	assert.True(t, client.enqueue([]byte("1")))
+	assert.True(t, client.enqueue([]byte("2")))
+	// the queue is full, so the oldest frame is dropped
+	assert.False(t, client.enqueue([]byte("3")))
+	assert.Equal(t, "2", string(<-client.pending))
+	assert.Equal(t, "3", string(<-client.pending))
+}
+
+func TestWebSocketAccept(t *testing.T) {
+	// example from RFC 6455, section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func connectWebSocket(t *testing.T, address string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", address, wsTestTimeout)
+	require.NoError(t, err)
+	require.NoError(t, conn.SetDeadline(time.Now().Add(wsTestTimeout)))
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: "+address+"\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	require.NoError(t, err)
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+	return conn, reader
+}
+
+// readWebSocketSrcPort reads an unmasked text frame and returns the source port of its record
+func readWebSocketSrcPort(t *testing.T, conn net.Conn, reader *bufio.Reader) uint16 {
+	t.Helper()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(wsTestTimeout)))
+	var header [2]byte
+	_, err := io.ReadFull(reader, header[:])
+	require.NoError(t, err)
+	require.Equal(t, byte(0x80|wsOpText), header[0])
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		_, err := io.ReadFull(reader, ext[:])
+		require.NoError(t, err)
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(reader, payload)
+	require.NoError(t, err)
+	var record struct {
+		ID struct {
+			SrcPort uint16 `json:"src_port"`
+		} `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &record))
+	return record.ID.SrcPort
+}
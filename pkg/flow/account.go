@@ -15,37 +15,80 @@ import (
 type Accounter struct {
 	maxEntries   int
 	evictTimeout time.Duration
+	maxBatchAge  time.Duration
+	maxLifetime  time.Duration
 	entries      map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics
 	clock        func() time.Time
 	monoClock    func() time.Duration
+	breaker      *MemoryBreaker
+	flush        chan struct{}
 }
 
 var alog = logrus.WithField("component", "flow/Accounter")
 
 // NewAccounter creates a new Accounter.
 // The cache has no limit and it's assumed that eviction is done by the caller.
+// If maxBatchAge is higher than zero, the accumulated flows are also evicted once the oldest of
+// them has been in the cache for that duration, even if neither the eviction timeout nor the
+// maximum number of entries have been reached.
+// If maxLifetime is higher than zero, the flows that started longer than maxLifetime ago are
+// also evicted, with the FlowEndReasonLifetimeCap end reason, as the MapTracer does.
+// If a MemoryBreaker is provided, the Accounter evicts all its entries when the breaker opens,
+// and drops any new flow until the breaker is closed again.
 func NewAccounter(
-	maxEntries int, evictTimeout time.Duration,
+	maxEntries int, evictTimeout, maxBatchAge, maxLifetime time.Duration,
 	clock func() time.Time,
 	monoClock func() time.Duration,
+	breaker *MemoryBreaker,
 ) *Accounter {
 	return &Accounter{
 		maxEntries:   maxEntries,
 		evictTimeout: evictTimeout,
+		maxBatchAge:  maxBatchAge,
+		maxLifetime:  maxLifetime,
 		entries:      map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics{},
 		clock:        clock,
 		monoClock:    monoClock,
+		breaker:      breaker,
+		flush:        make(chan struct{}, 1),
+	}
+}
+
+// Flush forces the eviction of all the accumulated flows, without waiting for the eviction
+// timeout nor for the maximum number of entries to be reached.
+func (c *Accounter) Flush() {
+	select {
+	case c.flush <- struct{}{}:
+	default:
+		// a flush is already pending
 	}
 }
 
 // Account runs in a new goroutine. It reads all the records from the input channel
 // and accumulate their metrics internally. Once the metrics have reached their max size
 // or the eviction times out, it evicts all the accumulated flows by the returned channel.
+// The evicted entries are removed from the accounter, so if a flow spans multiple eviction
+// windows, each exported record only reports the metrics of its own window.
 func (c *Accounter) Account(in <-chan *RawRecord, out chan<- []*Record) {
 	evictTick := time.NewTicker(c.evictTimeout)
 	defer evictTick.Stop()
+	// fires when the oldest accumulated flow reaches the max batch age. It is nil while the
+	// cache is empty or the max batch age is disabled
+	var batchAge <-chan time.Time
+	// a nil channel never receives, so the lifetime check is disabled if there is no cap
+	var lifetimeTick <-chan time.Time
+	if c.maxLifetime > 0 {
+		lifetimeTicker := time.NewTicker(c.maxLifetime / lifetimeChecksPerCap)
+		defer lifetimeTicker.Stop()
+		lifetimeTick = lifetimeTicker.C
+	}
 	for {
 		select {
+		case <-lifetimeTick:
+			c.evictLongLived(out)
+			if len(c.entries) == 0 {
+				batchAge = nil
+			}
 		case <-evictTick.C:
 			if len(c.entries) == 0 {
 				break
@@ -55,6 +98,28 @@ func (c *Accounter) Account(in <-chan *RawRecord, out chan<- []*Record) {
 			logrus.WithField("flows", len(evictingEntries)).
 				Debug("evicting flows from userspace accounter on timeout")
 			c.evict(evictingEntries, out)
+			batchAge = nil
+		case <-batchAge:
+			batchAge = nil
+			if len(c.entries) == 0 {
+				break
+			}
+			evictingEntries := c.entries
+			c.entries = map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics{}
+			alog.WithField("flows", len(evictingEntries)).
+				Debug("evicting flows from userspace accounter after reaching max batch age")
+			c.evict(evictingEntries, out)
+		case <-c.flush:
+			if len(c.entries) == 0 {
+				break
+			}
+			evictingEntries := c.entries
+			c.entries = map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics{}
+			alog.WithField("flows", len(evictingEntries)).
+				Debug("evicting flows from userspace accounter on flush request")
+			c.evict(evictingEntries, out)
+			batchAge = nil
+			evictTick.Reset(c.evictTimeout)
 		case record, ok := <-in:
 			if !ok {
 				alog.Debug("input channel closed. Evicting entries")
@@ -66,22 +131,65 @@ func (c *Accounter) Account(in <-chan *RawRecord, out chan<- []*Record) {
 				return
 			}
 			if _, ok := c.entries[record.Id]; !ok {
+				if c.breaker != nil && c.breaker.Open() {
+					if len(c.entries) > 0 {
+						evictingEntries := c.entries
+						c.entries = map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics{}
+						alog.WithField("flows", len(evictingEntries)).
+							Debug("evicting flows from userspace accounter under memory pressure")
+						c.evict(evictingEntries, out)
+						batchAge = nil
+					}
+					c.breaker.Dropped()
+					break
+				}
 				if len(c.entries) >= c.maxEntries {
 					evictingEntries := c.entries
 					c.entries = map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics{}
 					logrus.WithField("flows", len(evictingEntries)).
 						Debug("evicting flows from userspace accounter after reaching cache max length")
 					c.evict(evictingEntries, out)
+					batchAge = nil
 					// Since we will evict flows because we reached to cacheMaxFlows then reset
 					// evictTimer to avoid unnecessary another eviction when timer expires.
 					evictTick.Reset(c.evictTimeout)
 				}
+				if len(c.entries) == 0 && c.maxBatchAge > 0 {
+					batchAge = time.After(c.maxBatchAge)
+				}
 				c.entries[record.Id] = &record.Metrics
 			}
 		}
 	}
 }
 
+// evictLongLived evicts the flows that exceeded the maximum flow lifetime
+func (c *Accounter) evictLongLived(evictor chan<- []*Record) {
+	monotonicNow := c.monoClock()
+	if monotonicNow < c.maxLifetime {
+		// the host has been running for less than the flow lifetime
+		return
+	}
+	startedBefore := uint64(monotonicNow - c.maxLifetime)
+	var records []*Record
+	now := c.clock()
+	for key, metrics := range c.entries {
+		if metrics.StartMonoTimeTs >= startedBefore {
+			continue
+		}
+		record := NewRecord(key, *metrics, now, uint64(monotonicNow))
+		record.EndReason = FlowEndReasonLifetimeCap
+		records = append(records, record)
+		delete(c.entries, key)
+	}
+	if len(records) == 0 {
+		return
+	}
+	alog.WithField("flows", len(records)).
+		Debug("evicting flows from userspace accounter after reaching max flow lifetime")
+	evictor <- records
+}
+
 func (c *Accounter) evict(entries map[ebpf.BpfFlowId]*ebpf.BpfFlowMetrics, evictor chan<- []*Record) {
 	now := c.clock()
 	monotonicNow := uint64(c.monoClock())
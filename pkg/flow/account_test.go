@@ -1,13 +1,16 @@
 package flow
 
 import (
+	"sync"
 	"testing"
 	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
 )
 
 const timeout = 5 * time.Second
@@ -45,11 +48,11 @@ var k3 = ebpf.BpfFlowId{
 func TestEvict_MaxEntries(t *testing.T) {
 	// GIVEN an accounter
 	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
-	acc := NewAccounter(2, time.Hour, func() time.Time {
+	acc := NewAccounter(2, time.Hour, 0, 0, func() time.Time {
 		return now
 	}, func() time.Duration {
 		return 1000
-	})
+	}, nil)
 
 	// WHEN it starts accounting new records
 	inputs := make(chan *RawRecord, 20)
@@ -109,6 +112,9 @@ func TestEvict_MaxEntries(t *testing.T) {
 			},
 			TimeFlowStart: now.Add(-(1000 - 123) * time.Nanosecond),
 			TimeFlowEnd:   now.Add(-(1000 - 123) * time.Nanosecond),
+			ConnectionID:  ConnectionID(&k1),
+			SubFlowCount:  1,
+			SchemaVersion: SchemaVersion,
 		},
 		k2: {
 			RawRecord: RawRecord{
@@ -119,6 +125,9 @@ func TestEvict_MaxEntries(t *testing.T) {
 			},
 			TimeFlowStart: now.Add(-(1000 - 456) * time.Nanosecond),
 			TimeFlowEnd:   now.Add(-(1000 - 456) * time.Nanosecond),
+			ConnectionID:  ConnectionID(&k2),
+			SubFlowCount:  1,
+			SchemaVersion: SchemaVersion,
 		},
 	}, received)
 }
@@ -126,11 +135,11 @@ func TestEvict_MaxEntries(t *testing.T) {
 func TestEvict_Period(t *testing.T) {
 	// GIVEN an accounter
 	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
-	acc := NewAccounter(200, 20*time.Millisecond, func() time.Time {
+	acc := NewAccounter(200, 20*time.Millisecond, 0, 0, func() time.Time {
 		return now
 	}, func() time.Duration {
 		return 1000
-	})
+	}, nil)
 
 	// WHEN it starts accounting new records
 	inputs := make(chan *RawRecord, 20)
@@ -187,6 +196,9 @@ func TestEvict_Period(t *testing.T) {
 		},
 		TimeFlowStart: now.Add(-1000 + 123),
 		TimeFlowEnd:   now.Add(-1000 + 123),
+		ConnectionID:  ConnectionID(&k1),
+		SubFlowCount:  1,
+		SchemaVersion: SchemaVersion,
 	}, *records[0])
 	records = receiveTimeout(t, evictor)
 	require.Len(t, records, 1)
@@ -203,6 +215,9 @@ func TestEvict_Period(t *testing.T) {
 		},
 		TimeFlowStart: now.Add(-1000 + 1123),
 		TimeFlowEnd:   now.Add(-1000 + 1123),
+		ConnectionID:  ConnectionID(&k1),
+		SubFlowCount:  1,
+		SchemaVersion: SchemaVersion,
 	}, *records[0])
 
 	// no more flows are evicted
@@ -210,6 +225,255 @@ func TestEvict_Period(t *testing.T) {
 	requireNoEviction(t, evictor)
 }
 
+func TestEvict_Flush(t *testing.T) {
+	// GIVEN an accounter with a long eviction timeout
+	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
+	acc := NewAccounter(200, time.Hour, 0, 0, func() time.Time {
+		return now
+	}, func() time.Duration {
+		return 1000
+	}, nil)
+	inputs := make(chan *RawRecord, 20)
+	evictor := make(chan []*Record, 20)
+	go acc.Account(inputs, evictor)
+
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{Bytes: 123, Packets: 1}}
+	inputs <- &RawRecord{Id: k2, Metrics: ebpf.BpfFlowMetrics{Bytes: 456, Packets: 1}}
+	// wait for the accounter to process the records
+	time.Sleep(30 * time.Millisecond)
+	requireNoEviction(t, evictor)
+
+	// WHEN a flush is requested
+	acc.Flush()
+
+	// THEN all the flows are evicted immediately
+	records := receiveTimeout(t, evictor)
+	assert.Len(t, records, 2)
+}
+
+func TestEvict_MaxBatchAge(t *testing.T) {
+	// GIVEN an accounter with a long eviction timeout and a short max batch age
+	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
+	acc := NewAccounter(200, time.Hour, 50*time.Millisecond, 0, func() time.Time {
+		return now
+	}, func() time.Duration {
+		return 1000
+	}, nil)
+	inputs := make(chan *RawRecord, 20)
+	evictor := make(chan []*Record, 20)
+	go acc.Account(inputs, evictor)
+
+	// WHEN a lone record is accounted
+	start := time.Now()
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{Bytes: 123, Packets: 1}}
+
+	// THEN it is evicted once the max batch age is reached, without waiting for the timeout
+	records := receiveTimeout(t, evictor)
+	require.Len(t, records, 1)
+	assert.EqualValues(t, 123, records[0].Metrics.Bytes)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// AND the next batch waits again for its own max batch age
+	inputs <- &RawRecord{Id: k2, Metrics: ebpf.BpfFlowMetrics{Bytes: 456, Packets: 1}}
+	time.Sleep(20 * time.Millisecond)
+	requireNoEviction(t, evictor)
+	records = receiveTimeout(t, evictor)
+	require.Len(t, records, 1)
+	assert.EqualValues(t, 456, records[0].Metrics.Bytes)
+}
+
+func TestEvict_MaxLifetime(t *testing.T) {
+	// GIVEN an accounter with a long eviction timeout and a short max flow lifetime
+	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
+	monoNow := time.Duration(0)
+	var mt sync.Mutex
+	acc := NewAccounter(200, time.Hour, 0, 40*time.Millisecond, func() time.Time {
+		return now
+	}, func() time.Duration {
+		mt.Lock()
+		defer mt.Unlock()
+		return monoNow
+	}, nil)
+	inputs := make(chan *RawRecord, 20)
+	evictor := make(chan []*Record, 20)
+
+	// WHEN a flow that started long ago and a recent flow are accounted
+	mt.Lock()
+	monoNow = time.Second
+	mt.Unlock()
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{
+		Bytes: 123, Packets: 1, StartMonoTimeTs: 100, EndMonoTimeTs: 100,
+	}}
+	inputs <- &RawRecord{Id: k2, Metrics: ebpf.BpfFlowMetrics{
+		Bytes: 456, Packets: 1, StartMonoTimeTs: uint64(time.Second), EndMonoTimeTs: uint64(time.Second),
+	}}
+	go acc.Account(inputs, evictor)
+
+	// THEN only the flow that exceeded the lifetime is evicted, with its end reason
+	records := receiveTimeout(t, evictor)
+	require.Len(t, records, 1)
+	assert.Equal(t, k1, records[0].Id)
+	assert.Equal(t, FlowEndReasonLifetimeCap, records[0].EndReason)
+	time.Sleep(30 * time.Millisecond)
+	requireNoEviction(t, evictor)
+
+	// AND the recent flow is evicted once it exceeds the lifetime too
+	mt.Lock()
+	monoNow = 2 * time.Second
+	mt.Unlock()
+	records = receiveTimeout(t, evictor)
+	require.Len(t, records, 1)
+	assert.Equal(t, k2, records[0].Id)
+	assert.Equal(t, FlowEndReasonLifetimeCap, records[0].EndReason)
+}
+
+func TestEvict_WindowDeltas(t *testing.T) {
+	// GIVEN an accounter
+	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
+	acc := NewAccounter(200, time.Hour, 0, 0, func() time.Time {
+		return now
+	}, func() time.Duration {
+		return 1000
+	}, nil)
+	inputs := make(chan *RawRecord, 20)
+	evictor := make(chan []*Record, 20)
+	go acc.Account(inputs, evictor)
+
+	// WHEN a flow spans two eviction windows
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{
+		Bytes: 100, Packets: 2, StartMonoTimeTs: 100, EndMonoTimeTs: 200}}
+	time.Sleep(30 * time.Millisecond)
+	acc.Flush()
+	first := receiveTimeout(t, evictor)
+
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{
+		Bytes: 30, Packets: 1, StartMonoTimeTs: 300, EndMonoTimeTs: 300}}
+	time.Sleep(30 * time.Millisecond)
+	acc.Flush()
+	second := receiveTimeout(t, evictor)
+
+	// THEN each exported record only reports the packets of its own window
+	require.Len(t, first, 1)
+	assert.EqualValues(t, 100, first[0].Metrics.Bytes)
+	assert.EqualValues(t, 2, first[0].Metrics.Packets)
+	require.Len(t, second, 1)
+	assert.EqualValues(t, 30, second[0].Metrics.Bytes)
+	assert.EqualValues(t, 1, second[0].Metrics.Packets)
+	// AND the windows don't overlap
+	assert.False(t, second[0].TimeFlowStart.Before(first[0].TimeFlowEnd))
+}
+
+func TestEvict_MemoryBreaker(t *testing.T) {
+	// GIVEN an accounter with a memory circuit breaker
+	now := time.Date(2022, 8, 23, 16, 33, 22, 0, time.UTC)
+	// memory usage, as checked on each new flow
+	memUsage := []uint64{100, 1200, 1200, 700, 300}
+	breakerNow := now
+	m := metrics.NoOp()
+	breaker := NewMemoryBreaker(1000, 500, func() uint64 {
+		usage := memUsage[0]
+		memUsage = memUsage[1:]
+		return usage
+	}, func() time.Time {
+		// make sure that the memory usage is checked on each invocation
+		breakerNow = breakerNow.Add(breakerCheckPeriod)
+		return breakerNow
+	}, m)
+	acc := NewAccounter(200, time.Hour, 0, 0, func() time.Time {
+		return now
+	}, func() time.Duration {
+		return 1000
+	}, breaker)
+
+	inputs := make(chan *RawRecord, 20)
+	evictor := make(chan []*Record, 20)
+	go acc.Account(inputs, evictor)
+
+	// WHEN the memory usage is below the high watermark
+	// THEN new flows are accepted
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{Bytes: 123, Packets: 1}}
+
+	// WHEN the memory usage goes beyond the high watermark
+	inputs <- &RawRecord{Id: k2, Metrics: ebpf.BpfFlowMetrics{Bytes: 456, Packets: 1}}
+
+	// THEN the existing flows are evicted
+	records := receiveTimeout(t, evictor)
+	require.Len(t, records, 1)
+	assert.Equal(t, k1, records[0].Id)
+	assert.EqualValues(t, 1, gaugeValue(t, m, "memory_breaker_open"))
+
+	// AND new flows are dropped while the memory usage does not go below the low watermark
+	// (even if it goes below the high watermark)
+	inputs <- &RawRecord{Id: k3, Metrics: ebpf.BpfFlowMetrics{Bytes: 789, Packets: 1}}
+	inputs <- &RawRecord{Id: k1, Metrics: ebpf.BpfFlowMetrics{Bytes: 321, Packets: 1}}
+
+	// WHEN the memory usage goes below the low watermark
+	// THEN new flows are accepted again
+	inputs <- &RawRecord{Id: k2, Metrics: ebpf.BpfFlowMetrics{Bytes: 654, Packets: 1}}
+	close(inputs)
+	records = receiveTimeout(t, evictor)
+	require.Len(t, records, 1)
+	assert.Equal(t, k2, records[0].Id)
+	assert.EqualValues(t, 654, records[0].Metrics.Bytes)
+
+	assert.EqualValues(t, 0, gaugeValue(t, m, "memory_breaker_open"))
+	assert.EqualValues(t, 3, counterValue(t, m, "memory_breaker_dropped_flows_total"))
+}
+
+func TestMemoryBreaker_Concurrent(t *testing.T) {
+	// GIVEN a breaker whose memory usage is checked on each invocation
+	var clockMt sync.Mutex
+	now := time.Now()
+	m := metrics.NoOp()
+	breaker := NewMemoryBreaker(1000, 500, func() uint64 { return 2000 }, func() time.Time {
+		clockMt.Lock()
+		defer clockMt.Unlock()
+		now = now.Add(breakerCheckPeriod)
+		return now
+	}, m)
+
+	// WHEN it is shared by concurrent caches
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				breaker.Open()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// THEN it is opened once
+	assert.True(t, breaker.Open())
+	assert.EqualValues(t, 1, gaugeValue(t, m, "memory_breaker_open"))
+}
+
+func gaugeValue(t *testing.T, m *metrics.Metrics, name string) float64 {
+	t.Helper()
+	return metricFamily(t, m, name).Metric[0].GetGauge().GetValue()
+}
+
+func counterValue(t *testing.T, m *metrics.Metrics, name string) float64 {
+	t.Helper()
+	return metricFamily(t, m, name).Metric[0].GetCounter().GetValue()
+}
+
+func metricFamily(t *testing.T, m *metrics.Metrics, name string) *dto.MetricFamily {
+	t.Helper()
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() == name {
+			require.NotEmpty(t, f.Metric)
+			return f
+		}
+	}
+	require.Failf(t, "metric not found", "%s", name)
+	return nil
+}
+
 func receiveTimeout(t *testing.T, evictor <-chan []*Record) []*Record {
 	t.Helper()
 	select {
@@ -0,0 +1,98 @@
+package flow
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var bflog = logrus.WithField("component", "flow.BackfillLimiter")
+
+// BackfillLimiter smooths the spike of flows that happens when the agent starts tracing
+// interfaces that already carry many active connections. During the first window after the agent
+// starts, it admits at most a limited number of pre-existing flows for each interface, and drops
+// the rest. A flow is pre-existing if it is a TCP flow whose handshake (SYN or SYN-ACK) hasn't been
+// observed, as its connection was opened before it was traced. The connections of the rest of
+// protocols can't be told apart, so their flows are never limited, as well as the new TCP
+// connections. After the startup window, all the flows are forwarded.
+type BackfillLimiter struct {
+	limit  int
+	window time.Duration
+	clock  func() time.Time
+	// flows started before this time are considered part of the startup backfill
+	windowEnd time.Time
+	// admitted flows during the startup window, by interface index
+	admitted       map[uint32]int
+	droppedCounter prometheus.Counter
+}
+
+// NewBackfillLimiter creates a BackfillLimiter that admits up to limit pre-existing flows per
+// interface, among the flows that started during the first window after the limiter starts.
+func NewBackfillLimiter(
+	limit int, window time.Duration, clock func() time.Time, m *metrics.Metrics,
+) *BackfillLimiter {
+	return &BackfillLimiter{
+		limit:  limit,
+		window: window,
+		clock:  clock,
+		droppedCounter: m.NewCounter("startup_backfill_dropped_flows_total",
+			"Flows dropped because they exceeded the per-interface limit of the startup window"),
+	}
+}
+
+// Limit forwards the flows from the input to the output channel, discarding the flows that
+// exceed the startup backfill limit
+func (b *BackfillLimiter) Limit(in <-chan []*Record, out chan<- []*Record) {
+	b.windowEnd = b.clock().Add(b.window)
+	b.admitted = map[uint32]int{}
+	for records := range in {
+		if b.admitted == nil {
+			out <- records
+			continue
+		}
+		if b.clock().After(b.windowEnd.Add(b.window)) {
+			// any flow from the startup window has been already evicted, so the admission
+			// counters aren't required anymore
+			bflog.Debug("startup window finished. Forwarding all the flows")
+			b.admitted = nil
+			out <- records
+			continue
+		}
+		if admitted := b.admit(records); len(admitted) > 0 {
+			out <- admitted
+		}
+	}
+}
+
+func (b *BackfillLimiter) admit(records []*Record) []*Record {
+	admitted := make([]*Record, 0, len(records))
+	dropped := 0
+	for _, r := range records {
+		if !r.TimeFlowStart.Before(b.windowEnd) || !preexisting(r) {
+			admitted = append(admitted, r)
+			continue
+		}
+		if b.admitted[r.Id.IfIndex] >= b.limit {
+			dropped++
+			continue
+		}
+		b.admitted[r.Id.IfIndex]++
+		admitted = append(admitted, r)
+	}
+	if dropped > 0 {
+		b.droppedCounter.Add(float64(dropped))
+		bflog.WithField("dropped", dropped).Debug("startup backfill limit exceeded. Dropping flows")
+	}
+	return admitted
+}
+
+// preexisting tells whether the flow belongs to a connection that was opened before it was
+// traced, as far as it can be known from the flow alone
+func preexisting(r *Record) bool {
+	return r.Id.TransportProtocol == syscall.IPPROTO_TCP &&
+		r.Metrics.Flags&(TCPFlagSYN|TCPFlagSYNACK) == 0
+}
@@ -0,0 +1,87 @@
+package flow
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestBackfillLimiter(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	m := metrics.NoOp()
+	bl := NewBackfillLimiter(3, 5*time.Second, func() time.Time { return now }, m)
+
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go bl.Limit(in, out)
+	defer close(in)
+
+	tcpFlowAt := func(ifIndex uint32, srcPort uint16, flags uint16, started time.Time) *Record {
+		return &Record{
+			RawRecord: RawRecord{
+				Id: ebpf.BpfFlowId{
+					IfIndex: ifIndex, SrcPort: srcPort, TransportProtocol: syscall.IPPROTO_TCP,
+				},
+				Metrics: ebpf.BpfFlowMetrics{Flags: flags},
+			},
+			TimeFlowStart: started,
+		}
+	}
+	flowAt := func(ifIndex uint32, srcPort uint16, started time.Time) *Record {
+		return tcpFlowAt(ifIndex, srcPort, TCPFlagACK, started)
+	}
+
+	// GIVEN a large initial batch of flows from two interfaces, from connections that were
+	// already established
+	var initial []*Record
+	for i := 0; i < 10; i++ {
+		initial = append(initial, flowAt(1, uint16(i), start.Add(time.Second)))
+		initial = append(initial, flowAt(2, uint16(i), start.Add(time.Second)))
+	}
+	// AND the flows of new connections, and of a protocol without connections
+	fresh := []*Record{
+		tcpFlowAt(1, 100, TCPFlagSYN|TCPFlagACK, start.Add(time.Second)),
+		tcpFlowAt(1, 101, TCPFlagSYNACK|TCPFlagACK, start.Add(time.Second)),
+		{
+			RawRecord: RawRecord{Id: ebpf.BpfFlowId{
+				IfIndex: 1, SrcPort: 102, TransportProtocol: syscall.IPPROTO_UDP,
+			}},
+			TimeFlowStart: start.Add(time.Second),
+		},
+	}
+	in <- append(initial, fresh...)
+
+	// THEN only the configured number of pre-existing flows are admitted for each interface
+	admitted := receiveTimeout(t, out)
+	require.Len(t, admitted, 9)
+	perIface := map[uint32]int{}
+	for _, r := range admitted[:6] {
+		perIface[r.Id.IfIndex]++
+	}
+	assert.Equal(t, map[uint32]int{1: 3, 2: 3}, perIface)
+	assert.EqualValues(t, 14, counterValue(t, m, "startup_backfill_dropped_flows_total"))
+	// AND the rest of flows are never limited
+	assert.Equal(t, fresh, admitted[6:])
+
+	// AND the flows that started after the startup window are not limited
+	now = start.Add(6 * time.Second)
+	var later []*Record
+	for i := 0; i < 10; i++ {
+		later = append(later, flowAt(1, uint16(i), start.Add(5*time.Second)))
+	}
+	in <- later
+	assert.Len(t, receiveTimeout(t, out), 10)
+
+	// AND once the startup window is over, no flow is limited anymore
+	now = start.Add(time.Minute)
+	in <- initial
+	assert.Len(t, receiveTimeout(t, out), 20)
+	assert.EqualValues(t, 14, counterValue(t, m, "startup_backfill_dropped_flows_total"))
+}
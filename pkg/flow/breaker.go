@@ -0,0 +1,101 @@
+package flow
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var blog = logrus.WithField("component", "flow/MemoryBreaker")
+
+// breakerCheckPeriod is the minimum time between two consecutive checks of the memory usage
+const breakerCheckPeriod = time.Second
+
+// MemoryBreaker is a circuit breaker that opens when the memory usage of the agent goes
+// beyond a high watermark, and closes again when the memory usage drops below a low watermark.
+// While the breaker is open, the components that cache flows in userspace should stop
+// accepting new flows. It is safe for concurrent use, so it can be shared by several caches.
+type MemoryBreaker struct {
+	highWatermark uint64
+	lowWatermark  uint64
+	usage         func() uint64
+	clock         func() time.Time
+	// mt guards lastCheck and open
+	mt        sync.Mutex
+	lastCheck time.Time
+	open      bool
+
+	openGauge      prometheus.Gauge
+	droppedCounter prometheus.Counter
+}
+
+// NewMemoryBreaker creates a MemoryBreaker for the given high and low watermarks, in bytes.
+// The usage function returns the current memory usage. If nil, the resident set size (RSS) of
+// the agent process is used.
+func NewMemoryBreaker(
+	highWatermark, lowWatermark uint64,
+	usage func() uint64,
+	clock func() time.Time,
+	m *metrics.Metrics,
+) *MemoryBreaker {
+	if usage == nil {
+		usage = residentMemory
+	}
+	return &MemoryBreaker{
+		highWatermark: highWatermark,
+		lowWatermark:  lowWatermark,
+		usage:         usage,
+		clock:         clock,
+		openGauge: m.NewGauge("memory_breaker_open",
+			"1 if the memory circuit breaker is open and new flows are not accepted, 0 otherwise"),
+		droppedCounter: m.NewCounter("memory_breaker_dropped_flows_total",
+			"Number of new flows that have been dropped because the memory circuit breaker was open"),
+	}
+}
+
+// Open checks the memory usage, if it hasn't been checked recently, and returns whether
+// the breaker is open.
+func (b *MemoryBreaker) Open() bool {
+	b.mt.Lock()
+	defer b.mt.Unlock()
+	now := b.clock()
+	if now.Sub(b.lastCheck) < breakerCheckPeriod {
+		return b.open
+	}
+	b.lastCheck = now
+	usage := b.usage()
+	if !b.open && usage >= b.highWatermark {
+		blog.WithField("usage", usage).Warn("memory high watermark reached. Not accepting new flows")
+		b.open = true
+		b.openGauge.Set(1)
+	} else if b.open && usage < b.lowWatermark {
+		blog.WithField("usage", usage).Info("memory below low watermark. Accepting new flows again")
+		b.open = false
+		b.openGauge.Set(0)
+	}
+	return b.open
+}
+
+// Dropped accounts a flow that has been dropped because the breaker was open
+func (b *MemoryBreaker) Dropped() {
+	b.droppedCounter.Inc()
+}
+
+func residentMemory() uint64 {
+	proc, err := procfs.Self()
+	if err != nil {
+		blog.WithError(err).Debug("can't read process information")
+		return 0
+	}
+	stat, err := proc.Stat()
+	if err != nil {
+		blog.WithError(err).Debug("can't read process stats")
+		return 0
+	}
+	return uint64(stat.ResidentMemory())
+}
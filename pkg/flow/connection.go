@@ -0,0 +1,29 @@
+package flow
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+// ConnectionID returns an identifier of the connection the flow belongs to, so downstream
+// consumers can correlate the different flows (e.g. the flows from both directions, or the flows
+// observed from different interfaces or hosts) of the same connection.
+// It is a hash of the transport protocol and the addresses and ports of both endpoints,
+// independently of the flow direction. The socket cookie isn't used, as it only identifies the
+// local socket, so the flows of the same connection that aren't associated to it (e.g. the flows
+// from the other host, or from a forwarding interface) would get a different identifier.
+func ConnectionID(id *ebpf.BpfFlowId) uint64 {
+	tuple := *id
+	NormalizeOrientation(&tuple)
+	var ports [5]byte
+	ports[0] = tuple.TransportProtocol
+	binary.BigEndian.PutUint16(ports[1:], tuple.SrcPort)
+	binary.BigEndian.PutUint16(ports[3:], tuple.DstPort)
+	h := fnv.New64a()
+	_, _ = h.Write(tuple.SrcIp[:])
+	_, _ = h.Write(tuple.DstIp[:])
+	_, _ = h.Write(ports[:])
+	return h.Sum64()
+}
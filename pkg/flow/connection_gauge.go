@@ -0,0 +1,112 @@
+package flow
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// openConn is a TCP connection whose opening has been observed, and not its closing yet
+type openConn struct {
+	service  string
+	lastSeen time.Time
+}
+
+// ConnectionGauge tracks the number of active TCP connections towards each service, identified
+// by the destination address and port of the connection request. A connection is counted when
+// its SYN is observed, and discounted when a FIN or RST is observed in any direction, or when
+// no flow has been observed for it during the expiry time (e.g. because its closing was missed).
+type ConnectionGauge struct {
+	open      map[connKey]*openConn
+	counts    map[string]int
+	gauge     *prometheus.GaugeVec
+	expiry    time.Duration
+	lastPurge time.Time
+	clock     func() time.Time
+}
+
+// NewConnectionGauge creates a ConnectionGauge that exposes the active connections in the
+// active_connections gauge, labeled by service (address:port)
+func NewConnectionGauge(expiry time.Duration, clock func() time.Time, m *metrics.Metrics) *ConnectionGauge {
+	return &ConnectionGauge{
+		open:   map[connKey]*openConn{},
+		counts: map[string]int{},
+		gauge: m.NewGaugeVec("active_connections",
+			"Number of active TCP connections towards each service (destination address:port)",
+			"service"),
+		expiry:    expiry,
+		lastPurge: clock(),
+		clock:     clock,
+	}
+}
+
+// Track updates the active connections from the flows, and forwards them unmodified
+func (cg *ConnectionGauge) Track(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		now := cg.clock()
+		cg.purgeExpired(now)
+		for _, record := range records {
+			if record.Id.TransportProtocol == syscall.IPPROTO_TCP {
+				cg.update(record, now)
+			}
+		}
+		out <- records
+	}
+}
+
+func (cg *ConnectionGauge) update(record *Record, now time.Time) {
+	key, _ := connectionOf(record)
+	flags := record.Metrics.Flags
+	conn, ok := cg.open[key]
+	if !ok && flags&TCPFlagSYN != 0 {
+		// the connection request is sent by the client, towards the service
+		conn = &openConn{service: net.JoinHostPort(
+			IP(record.Id.DstIp).String(), strconv.Itoa(int(record.Id.DstPort)))}
+		cg.open[key] = conn
+		cg.add(conn.service, 1)
+		ok = true
+	}
+	if !ok {
+		// the opening of the connection wasn't observed
+		return
+	}
+	conn.lastSeen = now
+	if flags&(TCPFlagFIN|TCPFlagFINACK|TCPFlagRST|TCPFlagRSTACK) != 0 {
+		cg.close(key, conn)
+	}
+}
+
+func (cg *ConnectionGauge) close(key connKey, conn *openConn) {
+	delete(cg.open, key)
+	cg.add(conn.service, -1)
+}
+
+// add updates the count of a service, removing it from the gauge when it has no connections, so
+// the gauge cardinality is bounded by the services with active connections
+func (cg *ConnectionGauge) add(service string, delta int) {
+	count := cg.counts[service] + delta
+	if count <= 0 {
+		delete(cg.counts, service)
+		cg.gauge.DeleteLabelValues(service)
+		return
+	}
+	cg.counts[service] = count
+	cg.gauge.WithLabelValues(service).Set(float64(count))
+}
+
+func (cg *ConnectionGauge) purgeExpired(now time.Time) {
+	if now.Sub(cg.lastPurge) < cg.expiry {
+		return
+	}
+	cg.lastPurge = now
+	for key, conn := range cg.open {
+		if now.Sub(conn.lastSeen) >= cg.expiry {
+			cg.close(key, conn)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func connFlow(src string, srcPort uint16, dst string, dstPort uint16, flags uint16) *Record {
+	r := &Record{RawRecord: RawRecord{
+		Id:      ebpf.BpfFlowId{TransportProtocol: 6, SrcPort: srcPort, DstPort: dstPort},
+		Metrics: ebpf.BpfFlowMetrics{Packets: 1, Flags: flags},
+	}}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+// activeConnections returns the values of the active_connections gauge, by service
+func activeConnections(t *testing.T, m *metrics.Metrics) map[string]float64 {
+	t.Helper()
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() != "active_connections" {
+			continue
+		}
+		for _, metric := range f.Metric {
+			values[metric.Label[0].GetValue()] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestConnectionGauge(t *testing.T) {
+	m := metrics.NoOp()
+	now := time.Now()
+	cg := NewConnectionGauge(time.Minute, func() time.Time { return now }, m)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go cg.Track(in, out)
+	defer close(in)
+	send := func(records ...*Record) {
+		in <- records
+		assert.Equal(t, records, receiveTimeout(t, out))
+	}
+
+	// WHEN two connections are opened towards a service, and one towards another service
+	send(
+		connFlow("10.0.0.1", 40001, "10.0.0.100", 443, TCPFlagSYN),
+		connFlow("10.0.0.100", 443, "10.0.0.1", 40001, TCPFlagSYNACK),
+		connFlow("10.0.0.2", 40002, "10.0.0.100", 443, TCPFlagSYN|TCPFlagACK),
+		connFlow("10.0.0.1", 40003, "10.0.0.200", 5432, TCPFlagSYN),
+		// a connection whose opening wasn't observed is not counted
+		connFlow("10.0.0.3", 40004, "10.0.0.100", 443, TCPFlagACK),
+	)
+	// THEN the gauge tracks them by service
+	assert.Equal(t, map[string]float64{"10.0.0.100:443": 2, "10.0.0.200:5432": 1}, activeConnections(t, m))
+
+	// WHEN one connection is closed from the server side, and another is reset
+	send(
+		connFlow("10.0.0.100", 443, "10.0.0.1", 40001, TCPFlagFIN|TCPFlagACK),
+		connFlow("10.0.0.200", 5432, "10.0.0.1", 40003, TCPFlagRST),
+		connFlow("10.0.0.3", 40004, "10.0.0.100", 443, TCPFlagFIN),
+	)
+	// THEN they are discounted, and the services without connections are removed from the gauge
+	assert.Equal(t, map[string]float64{"10.0.0.100:443": 1}, activeConnections(t, m))
+
+	// AND a connection that is opened and closed within the same flow is not counted
+	send(connFlow("10.0.0.4", 40005, "10.0.0.100", 443, TCPFlagSYN|TCPFlagACK|TCPFlagFIN))
+	assert.Equal(t, map[string]float64{"10.0.0.100:443": 1}, activeConnections(t, m))
+
+	// AND a connection whose closing is missed is discounted after the expiry time
+	now = now.Add(time.Minute)
+	send()
+	assert.Empty(t, activeConnections(t, m))
+}
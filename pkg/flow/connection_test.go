@@ -0,0 +1,50 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestConnectionID(t *testing.T) {
+	flowID := func(src string, srcPort uint16, dst string, dstPort uint16, ifIndex uint32) ebpf.BpfFlowId {
+		id := ebpf.BpfFlowId{
+			SrcPort: srcPort, DstPort: dstPort, TransportProtocol: 6, IfIndex: ifIndex,
+		}
+		copy(id.SrcIp[:], net.ParseIP(src).To16())
+		copy(id.DstIp[:], net.ParseIP(dst).To16())
+		return id
+	}
+	now := time.Now()
+	record := func(id ebpf.BpfFlowId, direction uint8, cookie uint64) *Record {
+		id.Direction = direction
+		return NewRecord(id, ebpf.BpfFlowMetrics{Packets: 1, SocketCookie: cookie}, now, 1000)
+	}
+
+	// the flows of the same connection share the ID, in both directions and interfaces
+	request := record(flowID("10.0.0.1", 34567, "10.0.0.2", 80, 1), DirectionEgress, 0)
+	response := record(flowID("10.0.0.2", 80, "10.0.0.1", 34567, 1), DirectionIngress, 0)
+	otherIface := record(flowID("10.0.0.1", 34567, "10.0.0.2", 80, 2), DirectionIngress, 0)
+	assert.NotZero(t, request.ConnectionID)
+	assert.Equal(t, request.ConnectionID, response.ConnectionID)
+	assert.Equal(t, request.ConnectionID, otherIface.ConnectionID)
+
+	// the ID is stable across records of the same connection
+	later := record(flowID("10.0.0.1", 34567, "10.0.0.2", 80, 1), DirectionEgress, 0)
+	assert.Equal(t, request.ConnectionID, later.ConnectionID)
+
+	// other connections get different IDs
+	otherPort := record(flowID("10.0.0.1", 34568, "10.0.0.2", 80, 1), DirectionEgress, 0)
+	assert.NotEqual(t, request.ConnectionID, otherPort.ConnectionID)
+	udp := flowID("10.0.0.1", 34567, "10.0.0.2", 80, 1)
+	udp.TransportProtocol = 17
+	assert.NotEqual(t, request.ConnectionID, record(udp, DirectionEgress, 0).ConnectionID)
+
+	// the flows associated to a local socket share the ID with the flows that aren't
+	withCookie := record(flowID("10.0.0.2", 80, "10.0.0.1", 34567, 1), DirectionIngress, 1234)
+	assert.Equal(t, request.ConnectionID, withCookie.ConnectionID)
+}
@@ -0,0 +1,198 @@
+package flow
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EnricherContainer decorates the flows from local sockets with the container and the pod that
+// own the socket, from its cgroup
+const EnricherContainer = "container"
+
+// DefaultCgroupRoot is the mount point of the cgroup v2 hierarchy of the host
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// DefaultContainerRefreshPeriod is the minimum time between two scans of the cgroup hierarchy
+const DefaultContainerRefreshPeriod = 5 * time.Second
+
+// length of the hex-encoded container identifiers of the container runtimes
+const containerIDLen = 64
+
+var ctrlog = logrus.WithField("component", "flow.ContainerResolver")
+
+func init() {
+	RegisterEnricher(EnricherContainer, func(_ *EnricherContext) (Enricher, error) {
+		return NewContainerResolver(DefaultCgroupRoot, DefaultContainerRefreshPeriod, time.Now), nil
+	})
+}
+
+type containerInfo struct {
+	containerID string
+	podUID      string
+}
+
+// ContainerResolver enricher sets the ContainerID and PodUID fields of the flows whose CgroupID
+// is known, which are the flows whose packets have been associated to a local socket by the eBPF
+// program. As the cgroup v2 identifiers are the inode numbers of the cgroup directories, the
+// cgroups are found by walking the hierarchy from cgroupRoot, and the container and pod are
+// parsed from the cgroup path as created by the kubelet and the usual container runtimes
+// (containerd, CRI-O, Docker and Podman), for both the systemd and cgroupfs drivers. Host-network
+// pods are resolved too, as they also run in their own cgroup. The scan is performed in the
+// background, at most once per refresh period, when a flow whose cgroup is unknown is observed,
+// so the container is attached to the next flows of the same cgroup.
+type ContainerResolver struct {
+	cgroupRoot string
+	mt         sync.RWMutex
+	// containers by cgroup ID. The cgroups that don't belong to a container are also stored,
+	// with empty info, so they don't trigger new scans
+	cgroups map[uint64]containerInfo
+	refresh chan struct{}
+}
+
+// NewContainerResolver creates a ContainerResolver and starts its background scans worker
+func NewContainerResolver(cgroupRoot string, refreshPeriod time.Duration, clock func() time.Time) *ContainerResolver {
+	cr := &ContainerResolver{
+		cgroupRoot: cgroupRoot,
+		refresh:    make(chan struct{}, 1),
+	}
+	go cr.scanWorker(refreshPeriod, clock)
+	cr.requestScan()
+	return cr
+}
+
+func (cr *ContainerResolver) Enrich(record *Record) {
+	if record.CgroupID == 0 {
+		return
+	}
+	cr.mt.RLock()
+	info, ok := cr.cgroups[record.CgroupID]
+	cr.mt.RUnlock()
+	if !ok {
+		cr.requestScan()
+		return
+	}
+	record.ContainerID = info.containerID
+	record.PodUID = info.podUID
+}
+
+func (cr *ContainerResolver) requestScan() {
+	select {
+	case cr.refresh <- struct{}{}:
+	default:
+		// a scan is already pending
+	}
+}
+
+func (cr *ContainerResolver) scanWorker(refreshPeriod time.Duration, clock func() time.Time) {
+	var lastScan time.Time
+	for range cr.refresh {
+		if wait := refreshPeriod - clock().Sub(lastScan); wait > 0 {
+			time.Sleep(wait)
+		}
+		lastScan = clock()
+		cgroups, err := scanCgroups(cr.cgroupRoot)
+		if err != nil {
+			ctrlog.WithError(err).Debug("can't scan the cgroup hierarchy")
+			continue
+		}
+		cr.mt.Lock()
+		cr.cgroups = cgroups
+		cr.mt.Unlock()
+	}
+}
+
+// scanCgroups maps the identifiers of all the cgroups below cgroupRoot to their container
+func scanCgroups(cgroupRoot string) (map[uint64]containerInfo, error) {
+	cgroups := map[uint64]containerInfo{}
+	err := filepath.WalkDir(cgroupRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == cgroupRoot {
+				return err
+			}
+			// the cgroup has been removed during the scan
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		st, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		rel, _ := filepath.Rel(cgroupRoot, path)
+		cgroups[st.Ino] = parseCgroupPath(rel)
+		return nil
+	})
+	return cgroups, err
+}
+
+// parseCgroupPath returns the container and pod of a cgroup, from its path. The path of the
+// containers is like:
+//   - systemd driver: kubepods.slice/kubepods-burstable.slice/
+//     kubepods-burstable-pod<uid with underscores>.slice/cri-containerd-<id>.scope
+//   - cgroupfs driver: kubepods/burstable/pod<uid>/<id>
+//   - outside Kubernetes: system.slice/docker-<id>.scope
+func parseCgroupPath(path string) containerInfo {
+	info := containerInfo{}
+	for _, elem := range strings.Split(path, "/") {
+		if uid, ok := parsePodUID(elem); ok {
+			info.podUID = uid
+			continue
+		}
+		if id, ok := parseContainerID(elem); ok {
+			info.containerID = id
+		}
+	}
+	return info
+}
+
+func parsePodUID(elem string) (string, bool) {
+	elem = strings.TrimSuffix(elem, ".slice")
+	uid := ""
+	if i := strings.LastIndex(elem, "-pod"); i >= 0 {
+		// systemd driver, where the dashes of the UID are replaced by underscores
+		uid = strings.ReplaceAll(elem[i+len("-pod"):], "_", "-")
+	} else if strings.HasPrefix(elem, "pod") {
+		uid = elem[len("pod"):]
+	}
+	// the UIDs are UUIDs, or MD5 hashes for the static pods
+	if (len(uid) != 36 && len(uid) != 32) || !isHex(strings.ReplaceAll(uid, "-", "")) {
+		return "", false
+	}
+	return uid, true
+}
+
+func parseContainerID(elem string) (string, bool) {
+	elem = strings.TrimSuffix(elem, ".scope")
+	if i := strings.LastIndexByte(elem, '-'); i >= 0 {
+		// runtime prefix, e.g. cri-containerd-, crio-, docker-, libpod-
+		if strings.Contains(elem[:i], "conmon") {
+			// the monitor process of CRI-O and Podman, which doesn't belong to the container
+			return "", false
+		}
+		elem = elem[i+1:]
+	}
+	if len(elem) != containerIDLen || !isHex(elem) {
+		return "", false
+	}
+	return elem, true
+}
+
+func isHex(str string) bool {
+	for _, c := range str {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
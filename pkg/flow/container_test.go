@@ -0,0 +1,96 @@
+package flow
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testContainerID = strings.Repeat("0123456789abcdef", 4)
+	testPodUID      = "0f8e2a6c-3b1d-4c5e-9a7f-112233445566"
+)
+
+func TestParseCgroupPath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		info containerInfo
+	}{{
+		path: "kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" +
+			strings.ReplaceAll(testPodUID, "-", "_") + ".slice/cri-containerd-" + testContainerID + ".scope",
+		info: containerInfo{containerID: testContainerID, podUID: testPodUID},
+	}, {
+		path: "kubepods.slice/kubepods-pod" + strings.ReplaceAll(testPodUID, "-", "_") +
+			".slice/crio-" + testContainerID + ".scope",
+		info: containerInfo{containerID: testContainerID, podUID: testPodUID},
+	}, {
+		path: "kubepods/besteffort/pod" + testPodUID + "/" + testContainerID,
+		info: containerInfo{containerID: testContainerID, podUID: testPodUID},
+	}, {
+		path: "system.slice/docker-" + testContainerID + ".scope",
+		info: containerInfo{containerID: testContainerID},
+	}, {
+		// the pod cgroup itself
+		path: "kubepods/besteffort/pod" + testPodUID,
+		info: containerInfo{podUID: testPodUID},
+	}, {
+		path: "kubepods.slice/kubepods-pod" + strings.ReplaceAll(testPodUID, "-", "_") +
+			".slice/crio-conmon-" + testContainerID + ".scope",
+		info: containerInfo{podUID: testPodUID},
+	}, {
+		path: "system.slice/podman.service",
+	}, {
+		path: "user.slice/user-1000.slice/session-2.scope",
+	}} {
+		assert.Equal(t, tc.info, parseCgroupPath(tc.path), tc.path)
+	}
+}
+
+func cgroupID(t *testing.T, path string) uint64 {
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	return fi.Sys().(*syscall.Stat_t).Ino
+}
+
+func TestContainerResolver(t *testing.T) {
+	// GIVEN a cgroup hierarchy with a container cgroup and a system service cgroup
+	root := t.TempDir()
+	container := filepath.Join(root, "kubepods", "burstable", "pod"+testPodUID, testContainerID)
+	service := filepath.Join(root, "system.slice", "kubelet.service")
+	require.NoError(t, os.MkdirAll(container, 0o755))
+	require.NoError(t, os.MkdirAll(service, 0o755))
+	cr := NewContainerResolver(root, time.Millisecond, time.Now)
+
+	// WHEN the flows from sockets of both cgroups are enriched, once the cgroups are scanned
+	fromContainer := &Record{CgroupID: cgroupID(t, container)}
+	fromService := &Record{CgroupID: cgroupID(t, service)}
+	require.Eventually(t, func() bool {
+		cr.Enrich(fromContainer)
+		return fromContainer.ContainerID != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	cr.Enrich(fromService)
+
+	// THEN the container flow is attributed to its container and pod
+	assert.Equal(t, testContainerID, fromContainer.ContainerID)
+	assert.Equal(t, testPodUID, fromContainer.PodUID)
+	// AND the flows that don't belong to a container are left empty
+	assert.Empty(t, fromService.ContainerID)
+	assert.Empty(t, fromService.PodUID)
+
+	// AND a container that is created later is found by a new scan
+	newContainer := filepath.Join(root, "system.slice", "docker-"+strings.Repeat("f", 64)+".scope")
+	require.NoError(t, os.MkdirAll(newContainer, 0o755))
+	fromNewContainer := &Record{CgroupID: cgroupID(t, newContainer)}
+	require.Eventually(t, func() bool {
+		cr.Enrich(fromNewContainer)
+		return fromNewContainer.ContainerID != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, strings.Repeat("f", 64), fromNewContainer.ContainerID)
+	assert.Empty(t, fromNewContainer.PodUID)
+}
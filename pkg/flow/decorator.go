@@ -1,22 +1,55 @@
 package flow
 
 import (
+	"io"
 	"net"
+
+	"github.com/sirupsen/logrus"
 )
 
+var declog = logrus.WithField("component", "flow.Decorate")
+
 type InterfaceNamer func(ifIndex int) string
 
 // Decorate adds to the flows extra metadata fields that are not directly fetched by eBPF:
 // - The interface name (corresponding to the interface index in the flow).
 // - The IP address of the agent host.
+// The enrichers that can't be instantiated are logged and skipped.
 func Decorate(agentIP net.IP, ifaceNamer InterfaceNamer) func(in <-chan []*Record, out chan<- []*Record) {
+	ctx := &EnricherContext{
+		AgentIP:        agentIP,
+		InterfaceNamer: ifaceNamer,
+	}
+	chain := make([]Enricher, 0, len(DefaultEnrichers))
+	for _, name := range DefaultEnrichers {
+		enricher, err := NewEnrichers([]string{name}, ctx)
+		if err != nil {
+			declog.WithError(err).Warn("skipping enricher")
+			continue
+		}
+		chain = append(chain, enricher...)
+	}
+	return Enrich(chain)
+}
+
+// Enrich invokes, for each flow, the provided chain of enrichers in the same order as they
+// are provided. The enrichers implementing io.Closer are closed once the input is closed.
+func Enrich(enrichers []Enricher) func(in <-chan []*Record, out chan<- []*Record) {
 	return func(in <-chan []*Record, out chan<- []*Record) {
 		for flows := range in {
 			for _, flow := range flows {
-				flow.Interface = ifaceNamer(int(flow.Id.IfIndex))
-				flow.AgentIP = agentIP
+				for _, enricher := range enrichers {
+					enricher.Enrich(flow)
+				}
 			}
 			out <- flows
 		}
+		for _, enricher := range enrichers {
+			if closer, ok := enricher.(io.Closer); ok {
+				if err := closer.Close(); err != nil {
+					declog.WithError(err).Warn("can't close enricher")
+				}
+			}
+		}
 	}
 }
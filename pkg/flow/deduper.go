@@ -4,24 +4,58 @@ import (
 	"container/list"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
 )
 
 var dlog = logrus.WithField("component", "flow/Deduper")
 var timeNow = time.Now
 
+// maximum number of removed interfaces whose purge can be pending in the deduper
+const maxPendingPurges = 100
+
+// DeduperPurges notifies the deduper of the interfaces that went down or were removed, so it
+// forgets the flows that it registered from them. Otherwise, if an interface flaps, the duplicates
+// of its flows from other interfaces would keep being suppressed until the deduper entries expire,
+// and its own flows would be discarded as duplicates of the flows from other interfaces.
+type DeduperPurges chan uint32
+
+// NewDeduperPurges creates a DeduperPurges to be passed to the deduper stage
+func NewDeduperPurges() DeduperPurges {
+	return make(DeduperPurges, maxPendingPurges)
+}
+
+// InterfaceRemoved requests, asynchronously, the purge of the deduper entries of an interface
+func (dp DeduperPurges) InterfaceRemoved(ifIndex uint32) {
+	select {
+	case dp <- ifIndex:
+	default:
+		dlog.WithField("ifIndex", ifIndex).
+			Debug("too many removed interfaces pending. Its deduper entries will expire later")
+	}
+}
+
 // deduperCache implement a LRU cache whose elements are evicted if they haven't been accessed
-// during the expire duration.
+// during the expire duration, or if the cache has reached its maximum number of entries.
 // It is not safe for concurrent access.
 type deduperCache struct {
 	expire time.Duration
+	// maxEntries bounds the size of the cache. Zero means unbounded
+	maxEntries int
 	// key: ebpf.BpfFlowId with the interface and MACs erased, to detect duplicates
 	// value: listElement pointing to a struct entry
 	ifaces map[ebpf.BpfFlowId]*list.Element
 	// element: entry structs of the ifaces map ordered by expiry time
 	entries *list.List
+	// evictedCounter accounts the entries that have been evicted before expiring, to keep the
+	// cache below maxEntries
+	evictedCounter prometheus.Counter
+	// preferred returns whether the flows from an interface take precedence over the flows from
+	// the non-preferred interfaces. Nil if no interface is preferred
+	preferred func(ifIndex uint32) bool
 }
 
 type entry struct {
@@ -35,18 +69,36 @@ type entry struct {
 // (no activity for it during the expiration time)
 // The justMark argument tells that the deduper should not drop the duplicate flows but
 // set their Duplicate field.
-func Dedupe(expireTime time.Duration, justMark bool) func(in <-chan []*Record, out chan<- []*Record) {
-	cache := &deduperCache{
-		expire:  expireTime,
-		entries: list.New(),
-		ifaces:  map[ebpf.BpfFlowId]*list.Element{},
-	}
+// If maxEntries is greater than zero, the least recently accessed flows are forgotten when the
+// cache is full, so their next occurrence will be forwarded from whatever interface comes first.
+func Dedupe(
+	expireTime time.Duration, justMark bool, maxEntries int, m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	return DedupePreferring(expireTime, justMark, maxEntries, nil, nil, m)
+}
+
+// DedupePreferring works as Dedupe, but the flows from the preferred interfaces (e.g. the
+// physical NICs) are forwarded instead of their duplicates from the non-preferred interfaces
+// (e.g. virtual bridges), regardless of their arrival order: within a batch, the flows from
+// the preferred interfaces are checked first, and a flow from a preferred interface takes over
+// a flow that was previously registered from a non-preferred interface, so its next duplicates
+// from the non-preferred interface are discarded. The flows that were already forwarded from the
+// non-preferred interface can't be recalled. A nil preferred function disables the preference.
+// The entries of the interfaces notified through purges are forgotten. A nil purges disables it.
+func DedupePreferring(
+	expireTime time.Duration, justMark bool, maxEntries int,
+	preferred func(ifIndex uint32) bool, purges DeduperPurges, m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	cache := newDeduperCache(expireTime, maxEntries, m)
+	cache.preferred = preferred
 	return func(in <-chan []*Record, out chan<- []*Record) {
-		for records := range in {
+		var dupes []bool
+		for records, ok := cache.next(in, purges); ok; records, ok = cache.next(in, purges) {
 			cache.removeExpired()
+			dupes = cache.checkBatch(records, dupes[:0])
 			fwd := make([]*Record, 0, len(records))
-			for _, record := range records {
-				if cache.isDupe((*ebpf.BpfFlowId)(&record.Id)) {
+			for i, record := range records {
+				if dupes[i] {
 					if justMark {
 						record.Duplicate = true
 					} else {
@@ -62,6 +114,97 @@ func Dedupe(expireTime time.Duration, justMark bool) func(in <-chan []*Record, o
 	}
 }
 
+// checkBatch appends to dupes whether each record is a duplicate. If some interfaces are
+// preferred, their records are checked before the rest of records of the batch.
+func (c *deduperCache) checkBatch(records []*Record, dupes []bool) []bool {
+	dupes = append(dupes, make([]bool, len(records))...)
+	if c.preferred == nil {
+		for i, record := range records {
+			dupes[i] = c.isDupe(&record.Id)
+		}
+		return dupes
+	}
+	for _, preferredPass := range []bool{true, false} {
+		for i, record := range records {
+			if c.preferred(record.Id.IfIndex) == preferredPass {
+				dupes[i] = c.isDupe(&record.Id)
+			}
+		}
+	}
+	return dupes
+}
+
+// FusedDedupAggregate works as the KeyByServicePort stage followed by the Dedupe stage, but
+// it merges the flows by service port and takes the deduplication decision in a single pass
+// over each batch. The deduplication decision is only taken for the first flow of each
+// service key, and the following flows with the same key are merged into it. The output is
+// the same as running both stages separately. The entries of the interfaces notified through
+// purges are forgotten. A nil purges disables it.
+func FusedDedupAggregate(
+	expireTime time.Duration, justMark bool, maxEntries int, purges DeduperPurges,
+	m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	cache := newDeduperCache(expireTime, maxEntries, m)
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records, ok := cache.next(in, purges); ok; records, ok = cache.next(in, purges) {
+			cache.removeExpired()
+			fwd := make([]*Record, 0, len(records))
+			byKey := make(map[ebpf.BpfFlowId]*Record, len(records))
+			for _, record := range records {
+				ServicePortKey(&record.Id)
+				if first, ok := byKey[record.Id]; ok {
+					// the first record might have been dropped as duplicate, but the
+					// records merged into it must be dropped too
+					mergeRecord(first, record)
+					continue
+				}
+				byKey[record.Id] = record
+				if cache.isDupe(&record.Id) {
+					if !justMark {
+						continue
+					}
+					record.Duplicate = true
+				}
+				fwd = append(fwd, record)
+			}
+			if len(fwd) > 0 {
+				out <- fwd
+			}
+		}
+	}
+}
+
+// next returns the next batch of records from the input, or false if the input is closed.
+// Meanwhile, it purges the entries of the interfaces notified through purges, so the cache is
+// only accessed from the deduper goroutine.
+func (c *deduperCache) next(in <-chan []*Record, purges DeduperPurges) ([]*Record, bool) {
+	// the pending purges go first, so the flows that are received after an interface went down
+	// are not checked against its stale entries
+	for pending := len(purges); pending > 0; pending-- {
+		c.purgeInterface(<-purges)
+	}
+	for {
+		select {
+		case ifIndex := <-purges:
+			c.purgeInterface(ifIndex)
+		case records, ok := <-in:
+			return records, ok
+		}
+	}
+}
+
+func newDeduperCache(expireTime time.Duration, maxEntries int, m *metrics.Metrics) *deduperCache {
+	return &deduperCache{
+		expire:     expireTime,
+		maxEntries: maxEntries,
+		entries:    list.New(),
+		ifaces:     map[ebpf.BpfFlowId]*list.Element{},
+		evictedCounter: m.NewCounter("deduper_evicted_entries_total",
+			"Number of deduper entries that have been evicted before expiring because the"+
+				" deduper cache reached its maximum size"),
+	}
+}
+
 // isDupe returns whether the passed record has been already checked for duplicate for
 // another interface
 func (c *deduperCache) isDupe(key *ebpf.BpfFlowId) bool {
@@ -77,12 +220,21 @@ func (c *deduperCache) isDupe(key *ebpf.BpfFlowId) bool {
 		fEntry := ele.Value.(*entry)
 		fEntry.expiryTime = timeNow().Add(c.expire)
 		c.entries.MoveToFront(ele)
+		if fEntry.ifIndex != key.IfIndex && c.preferred != nil &&
+			c.preferred(key.IfIndex) && !c.preferred(fEntry.ifIndex) {
+			// the flow from the preferred interface takes over the registered flow
+			fEntry.ifIndex = key.IfIndex
+			return false
+		}
 		// The input flow is duplicate if its interface is different to the interface
 		// of the non-duplicate flow that was first registered in the cache
 		return fEntry.ifIndex != key.IfIndex
 	}
 	// The flow has not been accounted previously (or was forgotten after expiration)
 	// so we register it for that concrete interface
+	if c.maxEntries > 0 && c.entries.Len() >= c.maxEntries {
+		c.evictOldest()
+	}
 	e := entry{
 		key:        &rk,
 		ifIndex:    key.IfIndex,
@@ -92,6 +244,38 @@ func (c *deduperCache) isDupe(key *ebpf.BpfFlowId) bool {
 	return false
 }
 
+// evictOldest forgets the least recently accessed flow
+func (c *deduperCache) evictOldest() {
+	ele := c.entries.Back()
+	if ele == nil {
+		return
+	}
+	c.entries.Remove(ele)
+	delete(c.ifaces, *ele.Value.(*entry).key)
+	c.evictedCounter.Inc()
+	dlog.WithField("maxEntries", c.maxEntries).
+		Debug("deduper cache is full. Evicting least recently accessed entry")
+}
+
+// purgeInterface forgets the flows that were registered from an interface, so their next
+// occurrence is forwarded from whatever interface comes first
+func (c *deduperCache) purgeInterface(ifIndex uint32) {
+	purged := 0
+	for ele := c.entries.Front(); ele != nil; {
+		next := ele.Next()
+		if e := ele.Value.(*entry); e.ifIndex == ifIndex {
+			c.entries.Remove(ele)
+			delete(c.ifaces, *e.key)
+			purged++
+		}
+		ele = next
+	}
+	dlog.WithFields(logrus.Fields{
+		"ifIndex": ifIndex,
+		"purged":  purged,
+	}).Debug("interface removed. Purging its entries from the deduper cache")
+}
+
 func (c *deduperCache) removeExpired() {
 	now := timeNow()
 	ele := c.entries.Back()
@@ -0,0 +1,181 @@
+package flow
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// randomBatches generates batches of flows sharing a small set of hosts, ports and interfaces,
+// so they are often merged by service port and deduplicated
+func randomBatches(seed int64, batches, flowsPerBatch int) [][]*Record {
+	rnd := rand.New(rand.NewSource(seed))
+	hosts := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "fd00::1"}
+	ports := []uint16{0, 80, 443, 5432, 34567, 40000}
+	protocols := []uint8{1, 6, 17}
+	result := make([][]*Record, 0, batches)
+	for b := 0; b < batches; b++ {
+		batch := make([]*Record, 0, flowsPerBatch)
+		for f := 0; f < flowsPerBatch; f++ {
+			r := &Record{SubFlowCount: 1}
+			copy(r.Id.SrcIp[:], net.ParseIP(hosts[rnd.Intn(len(hosts))]).To16())
+			copy(r.Id.DstIp[:], net.ParseIP(hosts[rnd.Intn(len(hosts))]).To16())
+			r.Id.SrcPort = ports[rnd.Intn(len(ports))]
+			r.Id.DstPort = ports[rnd.Intn(len(ports))]
+			r.Id.TransportProtocol = protocols[rnd.Intn(len(protocols))]
+			r.Id.IfIndex = uint32(rnd.Intn(3))
+			r.Id.Direction = uint8(rnd.Intn(2))
+			r.Id.SrcMac[0] = uint8(r.Id.IfIndex)
+			r.Metrics.Packets = uint32(1 + rnd.Intn(10))
+			r.Metrics.Bytes = uint64(r.Metrics.Packets) * uint64(60+rnd.Intn(1400))
+			r.Metrics.Flags = uint16(rnd.Intn(0x800))
+			r.Metrics.StartMonoTimeTs = uint64(rnd.Intn(1000))
+			r.Metrics.EndMonoTimeTs = r.Metrics.StartMonoTimeTs + uint64(rnd.Intn(1000))
+			start := time.Unix(1_600_000_000, 0)
+			r.TimeFlowStart = start.Add(time.Duration(r.Metrics.StartMonoTimeTs))
+			r.TimeFlowEnd = start.Add(time.Duration(r.Metrics.EndMonoTimeTs))
+			batch = append(batch, r)
+		}
+		result = append(result, batch)
+	}
+	return result
+}
+
+func copyBatches(batches [][]*Record) [][]*Record {
+	copied := make([][]*Record, 0, len(batches))
+	for _, batch := range batches {
+		cb := make([]*Record, 0, len(batch))
+		for _, r := range batch {
+			cr := *r
+			cb = append(cb, &cr)
+		}
+		copied = append(copied, cb)
+	}
+	return copied
+}
+
+// steppingClock returns a clock that advances one second each time it is invoked
+func steppingClock() func() time.Time {
+	now := time.Unix(1_600_000_000, 0)
+	return func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+}
+
+func runSeparate(batches [][]*Record, justMark bool, maxEntries int) []*Record {
+	in := make(chan []*Record, len(batches))
+	keyed := make(chan []*Record, len(batches))
+	out := make(chan []*Record, len(batches))
+	for _, b := range batches {
+		in <- b
+	}
+	close(in)
+	KeyByServicePort(in, keyed)
+	close(keyed)
+	Dedupe(20*time.Second, justMark, maxEntries, metrics.NoOp())(keyed, out)
+	close(out)
+	var result []*Record
+	for records := range out {
+		result = append(result, records...)
+	}
+	return result
+}
+
+func runFused(batches [][]*Record, justMark bool, maxEntries int) []*Record {
+	in := make(chan []*Record, len(batches))
+	out := make(chan []*Record, len(batches))
+	for _, b := range batches {
+		in <- b
+	}
+	close(in)
+	FusedDedupAggregate(20*time.Second, justMark, maxEntries, nil, metrics.NoOp())(in, out)
+	close(out)
+	var result []*Record
+	for records := range out {
+		result = append(result, records...)
+	}
+	return result
+}
+
+func TestFusedDedupAggregate_MatchesSeparateStages(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	for _, tc := range []struct {
+		name       string
+		justMark   bool
+		maxEntries int
+	}{
+		{name: "drop"},
+		{name: "just mark", justMark: true},
+		{name: "bounded cache", maxEntries: 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			batches := randomBatches(42, 50, 40)
+
+			timeNow = steppingClock()
+			separate := runSeparate(copyBatches(batches), tc.justMark, tc.maxEntries)
+			timeNow = steppingClock()
+			fused := runFused(copyBatches(batches), tc.justMark, tc.maxEntries)
+
+			require.NotEmpty(t, separate)
+			require.Less(t, len(separate), 50*40, "the test flows should be merged or deduplicated")
+			assert.Equal(t, separate, fused)
+		})
+	}
+}
+
+func TestFusedDedupAggregate(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	timeNow = time.Now
+	record := func(ifIndex uint32, srcPort, dstPort uint16, bytes uint64) *Record {
+		r := &Record{SubFlowCount: 1}
+		r.Id = ebpf.BpfFlowId{TransportProtocol: 6, SrcPort: srcPort, DstPort: dstPort, IfIndex: ifIndex}
+		r.Metrics.Bytes = bytes
+		r.Metrics.Packets = 1
+		return r
+	}
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go FusedDedupAggregate(time.Minute, false, 0, nil, metrics.NoOp())(in, out)
+
+	in <- []*Record{
+		record(1, 34567, 443, 100),
+		// another client port at the same interface is merged
+		record(1, 40000, 443, 200),
+		// the same service observed from another interface is a duplicate
+		record(2, 34567, 443, 100),
+		record(2, 40000, 443, 200),
+	}
+	fwd := receiveTimeout(t, out)
+	require.Len(t, fwd, 1)
+	assert.EqualValues(t, 1, fwd[0].Id.IfIndex)
+	assert.EqualValues(t, 300, fwd[0].Metrics.Bytes)
+	assert.EqualValues(t, 2, fwd[0].SubFlowCount)
+	assert.Zero(t, fwd[0].Id.SrcPort)
+}
+
+func benchmarkDedupAggregate(b *testing.B, run func([][]*Record, bool, int) []*Record) {
+	batches := randomBatches(42, 100, 500)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		copied := copyBatches(batches)
+		b.StartTimer()
+		run(copied, false, 0)
+	}
+}
+
+func BenchmarkDedupAggregate_Separate(b *testing.B) {
+	benchmarkDedupAggregate(b, runSeparate)
+}
+
+func BenchmarkDedupAggregate_Fused(b *testing.B) {
+	benchmarkDedupAggregate(b, runFused)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
 )
 
 var (
@@ -42,7 +43,7 @@ func TestDedupe(t *testing.T) {
 	input := make(chan []*Record, 100)
 	output := make(chan []*Record, 100)
 
-	go Dedupe(time.Minute, false)(input, output)
+	go Dedupe(time.Minute, false, 0, metrics.NoOp())(input, output)
 
 	input <- []*Record{
 		oneIf2, // record 1 at interface 2: should be accepted
@@ -68,7 +69,7 @@ func TestDedupe_EvictFlows(t *testing.T) {
 	input := make(chan []*Record, 100)
 	output := make(chan []*Record, 100)
 
-	go Dedupe(15*time.Second, false)(input, output)
+	go Dedupe(15*time.Second, false, 0, metrics.NoOp())(input, output)
 
 	// Should only accept records 1 and 2, at interface 1
 	input <- []*Record{oneIf1, twoIf1, oneIf2}
@@ -99,6 +100,87 @@ func TestDedupe_EvictFlows(t *testing.T) {
 		receiveTimeout(t, output))
 }
 
+func TestDedupe_MaxEntries(t *testing.T) {
+	input := make(chan []*Record, 100)
+	output := make(chan []*Record, 100)
+	m := metrics.NoOp()
+
+	go Dedupe(time.Minute, false, 2, m)(input, output)
+
+	threeIf1 := *twoIf1
+	threeIf1.Id.SrcPort = 999
+	threeIf2 := *twoIf2
+	threeIf2.Id.SrcPort = 999
+
+	// filling the cache: only the first interface of each flow is accepted
+	input <- []*Record{oneIf1, twoIf1, oneIf2, twoIf2}
+	assert.Equal(t, []*Record{oneIf1, twoIf1}, receiveTimeout(t, output))
+	assert.EqualValues(t, 0, counterValue(t, m, "deduper_evicted_entries_total"))
+
+	// a third flow exceeds the bound, so record 1 (the least recently accessed) is evicted
+	input <- []*Record{&threeIf2, &threeIf1}
+	assert.Equal(t, []*Record{&threeIf2}, receiveTimeout(t, output))
+	assert.EqualValues(t, 1, counterValue(t, m, "deduper_evicted_entries_total"))
+
+	// record 1 starts a fresh first-come race, so it is now accepted from interface 2.
+	// That evicts record 2, which is accepted later from interface 2 too
+	input <- []*Record{oneIf2, oneIf1, twoIf2}
+	assert.Equal(t, []*Record{oneIf2, twoIf2}, receiveTimeout(t, output))
+	assert.EqualValues(t, 3, counterValue(t, m, "deduper_evicted_entries_total"))
+}
+
+func TestDedupePreferring(t *testing.T) {
+	input := make(chan []*Record, 100)
+	output := make(chan []*Record, 100)
+
+	// interface 1 is the physical one, and interface 2 is a virtual bridge
+	physical := func(ifIndex uint32) bool { return ifIndex == 1 }
+	go DedupePreferring(time.Minute, false, 0, physical, nil, metrics.NoOp())(input, output)
+
+	// the physical interface wins even if its flow comes after the virtual one
+	input <- []*Record{oneIf2, oneIf1}
+	assert.Equal(t, []*Record{oneIf1}, receiveTimeout(t, output))
+	input <- []*Record{oneIf2, oneIf1}
+	assert.Equal(t, []*Record{oneIf1}, receiveTimeout(t, output))
+
+	// a flow that was registered from the virtual interface is taken over by the physical one
+	input <- []*Record{twoIf2}
+	assert.Equal(t, []*Record{twoIf2}, receiveTimeout(t, output))
+	input <- []*Record{twoIf1}
+	assert.Equal(t, []*Record{twoIf1}, receiveTimeout(t, output))
+	input <- []*Record{twoIf2, twoIf1}
+	assert.Equal(t, []*Record{twoIf1}, receiveTimeout(t, output))
+}
+
+func TestDedupe_PurgeOnInterfaceFlap(t *testing.T) {
+	input := make(chan []*Record, 100)
+	output := make(chan []*Record, 100)
+	purges := NewDeduperPurges()
+	go DedupePreferring(time.Minute, false, 0, nil, purges, metrics.NoOp())(input, output)
+
+	// GIVEN flows that are registered from interface 1
+	input <- []*Record{oneIf1, twoIf1}
+	assert.Equal(t, []*Record{oneIf1, twoIf1}, receiveTimeout(t, output))
+	// so their duplicates from interface 2 are suppressed
+	input <- []*Record{oneIf2, twoIf2}
+	input <- []*Record{oneIf1}
+	assert.Equal(t, []*Record{oneIf1}, receiveTimeout(t, output))
+
+	// WHEN interface 2 flaps, the flows of interface 1 are still suppressed from interface 2
+	purges.InterfaceRemoved(2)
+	input <- []*Record{oneIf2, oneIf1}
+	assert.Equal(t, []*Record{oneIf1}, receiveTimeout(t, output))
+
+	// WHEN interface 1 goes down
+	purges.InterfaceRemoved(1)
+	// THEN its deduper entries are purged, so the flows are accepted from interface 2
+	input <- []*Record{oneIf2, twoIf2}
+	assert.Equal(t, []*Record{oneIf2, twoIf2}, receiveTimeout(t, output))
+	// AND when interface 1 returns, its flows are now the duplicates
+	input <- []*Record{oneIf1, twoIf1, oneIf2}
+	assert.Equal(t, []*Record{oneIf2}, receiveTimeout(t, output))
+}
+
 type timerMock struct {
 	now time.Time
 }
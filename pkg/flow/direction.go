@@ -0,0 +1,122 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var dilog = logrus.WithField("component", "flow.DirectionInference")
+
+// Modes of the direction inference
+const (
+	// DirectionInferenceKernel keeps the direction reported by the eBPF hook where the packets
+	// were captured
+	DirectionInferenceKernel = "kernel"
+	// DirectionInferenceHeuristic keeps the direction reported by the eBPF hook, and infers it
+	// from whether the source or destination addresses are local to the node for the flows
+	// without a reported direction
+	DirectionInferenceHeuristic = "heuristic"
+	// DirectionInferenceNone doesn't report any direction (DirectionUnknown)
+	DirectionInferenceNone = "none"
+)
+
+// LocalAddresses tells whether an IP address belongs to any interface of the node. The
+// addresses are listed again once the refresh period has elapsed, so the changes of the node
+// addresses are eventually noticed. It is not safe for concurrent use.
+type LocalAddresses struct {
+	list    func() ([]net.Addr, error)
+	refresh time.Duration
+	clock   func() time.Time
+	addrs   map[IPAddr]struct{}
+	listed  time.Time
+}
+
+// NewLocalAddresses creates a LocalAddresses that lists the node addresses with the provided
+// function (e.g. net.InterfaceAddrs) each refresh period
+func NewLocalAddresses(
+	list func() ([]net.Addr, error), refresh time.Duration, clock func() time.Time,
+) *LocalAddresses {
+	return &LocalAddresses{list: list, refresh: refresh, clock: clock}
+}
+
+// Contains returns whether the provided address is local to the node
+func (la *LocalAddresses) Contains(ip IPAddr) bool {
+	if now := la.clock(); la.addrs == nil || now.Sub(la.listed) >= la.refresh {
+		la.listed = now
+		la.load()
+	}
+	_, ok := la.addrs[ip]
+	return ok
+}
+
+func (la *LocalAddresses) load() {
+	addrs, err := la.list()
+	if err != nil {
+		dilog.WithError(err).Warn("can't list the local addresses. Keeping the previous ones")
+		if la.addrs == nil {
+			la.addrs = map[IPAddr]struct{}{}
+		}
+		return
+	}
+	la.addrs = make(map[IPAddr]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet != nil {
+			var ip IPAddr
+			copy(ip[:], ipnet.IP.To16())
+			la.addrs[ip] = struct{}{}
+		}
+	}
+}
+
+// InferDirection returns a stage that sets the direction of the flows according to the provided
+// inference mode. The heuristic mode requires the local addresses of the node. It must be placed
+// before the stages that change the orientation of the flows (e.g. Normalize).
+func InferDirection(
+	mode string, local *LocalAddresses,
+) (func(in <-chan []*Record, out chan<- []*Record), error) {
+	var infer func(r *Record)
+	switch mode {
+	case DirectionInferenceKernel:
+		infer = func(*Record) {}
+	case DirectionInferenceHeuristic:
+		infer = func(r *Record) {
+			if r.Id.Direction != DirectionIngress && r.Id.Direction != DirectionEgress {
+				r.Id.Direction = heuristicDirection(r, local)
+			}
+		}
+	case DirectionInferenceNone:
+		infer = func(r *Record) {
+			r.Id.Direction = DirectionUnknown
+		}
+	default:
+		return nil, fmt.Errorf("invalid direction inference %q. Accepted values are %s, %s, %s",
+			mode, DirectionInferenceKernel, DirectionInferenceHeuristic, DirectionInferenceNone)
+	}
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			for _, record := range records {
+				infer(record)
+			}
+			out <- records
+		}
+	}, nil
+}
+
+// heuristicDirection considers that the flows sent from a local address are egress, and the flows
+// sent to a local address are ingress. Otherwise (e.g. forwarded or loopback traffic), the
+// direction of the flow is kept.
+func heuristicDirection(r *Record, local *LocalAddresses) uint8 {
+	srcLocal := local.Contains(r.Id.SrcIp)
+	dstLocal := local.Contains(r.Id.DstIp)
+	switch {
+	case srcLocal && !dstLocal:
+		return DirectionEgress
+	case dstLocal && !srcLocal:
+		return DirectionIngress
+	default:
+		return r.Id.Direction
+	}
+}
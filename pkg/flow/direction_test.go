@@ -0,0 +1,112 @@
+package flow
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func directionFlow(src, dst string, direction uint8) *Record {
+	r := &Record{}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	r.Id.Direction = direction
+	return r
+}
+
+func staticAddrs(cidrs ...string) func() ([]net.Addr, error) {
+	return func() ([]net.Addr, error) {
+		var addrs []net.Addr
+		for _, cidr := range cidrs {
+			ip, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, err
+			}
+			ipnet.IP = ip
+			addrs = append(addrs, ipnet)
+		}
+		return addrs, nil
+	}
+}
+
+func inferDirections(t *testing.T, mode string, local *LocalAddresses, records ...*Record) []uint8 {
+	t.Helper()
+	stage, err := InferDirection(mode, local)
+	require.NoError(t, err)
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	in <- records
+	close(in)
+	stage(in, out)
+	var directions []uint8
+	for _, r := range <-out {
+		directions = append(directions, r.Id.Direction)
+	}
+	return directions
+}
+
+func TestInferDirection_Kernel(t *testing.T) {
+	assert.Equal(t, []uint8{DirectionIngress, DirectionEgress},
+		inferDirections(t, DirectionInferenceKernel, nil,
+			directionFlow("10.0.0.1", "10.0.0.2", DirectionIngress),
+			directionFlow("10.0.0.2", "10.0.0.1", DirectionEgress)))
+}
+
+func TestInferDirection_Heuristic(t *testing.T) {
+	local := NewLocalAddresses(staticAddrs("10.0.0.1/24", "fd00::1/64"), time.Minute, time.Now)
+	assert.Equal(t,
+		[]uint8{
+			DirectionIngress, DirectionEgress,
+			DirectionEgress, DirectionIngress, DirectionEgress, DirectionUnknown,
+		},
+		inferDirections(t, DirectionInferenceHeuristic, local,
+			// the direction reported by the kernel is kept
+			directionFlow("10.0.0.1", "10.0.0.2", DirectionIngress),
+			directionFlow("10.0.0.4", "10.0.0.3", DirectionEgress),
+			// the flows without a reported direction are inferred from the local addresses
+			directionFlow("10.0.0.1", "10.0.0.2", DirectionUnknown),
+			directionFlow("10.0.0.2", "10.0.0.1", DirectionUnknown),
+			directionFlow("fd00::1", "fd00::2", DirectionUnknown),
+			// unless none of them is local
+			directionFlow("10.0.0.3", "10.0.0.4", DirectionUnknown)))
+}
+
+func TestInferDirection_None(t *testing.T) {
+	assert.Equal(t, []uint8{DirectionUnknown, DirectionUnknown},
+		inferDirections(t, DirectionInferenceNone, nil,
+			directionFlow("10.0.0.1", "10.0.0.2", DirectionIngress),
+			directionFlow("10.0.0.2", "10.0.0.1", DirectionEgress)))
+}
+
+func TestInferDirection_InvalidMode(t *testing.T) {
+	_, err := InferDirection("guess", nil)
+	assert.Error(t, err)
+}
+
+func TestLocalAddresses_Refresh(t *testing.T) {
+	now := time.Now()
+	listed := staticAddrs("10.0.0.1/24")
+	local := NewLocalAddresses(func() ([]net.Addr, error) { return listed() },
+		time.Minute, func() time.Time { return now })
+	var ip1, ip2 IPAddr
+	copy(ip1[:], net.ParseIP("10.0.0.1").To16())
+	copy(ip2[:], net.ParseIP("10.0.0.2").To16())
+	assert.True(t, local.Contains(ip1))
+	assert.False(t, local.Contains(ip2))
+
+	// the node addresses change, but they are not listed until the refresh period elapses
+	listed = staticAddrs("10.0.0.2/24")
+	assert.True(t, local.Contains(ip1))
+	now = now.Add(time.Minute)
+	assert.False(t, local.Contains(ip1))
+	assert.True(t, local.Contains(ip2))
+
+	// listing errors keep the previous addresses
+	listed = func() ([]net.Addr, error) { return nil, errors.New("boom") }
+	now = now.Add(time.Minute)
+	assert.True(t, local.Contains(ip2))
+}
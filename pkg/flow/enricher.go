@@ -0,0 +1,173 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	// EnricherInterfaceName decorates the flows with the name of the interface they were captured from
+	EnricherInterfaceName = "interfaceName"
+	// EnricherAgentIP decorates the flows with the IP address of the agent host
+	EnricherAgentIP = "agentIP"
+	// EnricherIdentity decorates the flows with the configured cluster and tenant identifiers
+	EnricherIdentity = "identity"
+	// EnricherBuildInfo decorates the flows with the version of the agent and the hash of its
+	// eBPF program
+	EnricherBuildInfo = "buildInfo"
+	// EnricherInterfaceID decorates the flows with an identifier of their interface that is
+	// qualified by the node name, so it is unique across the cluster
+	EnricherInterfaceID = "interfaceID"
+)
+
+// DefaultEnrichers is the list of enrichers that are applied when the user does not
+// provide any
+var DefaultEnrichers = []string{EnricherInterfaceName, EnricherAgentIP}
+
+// Enricher adds to a flow record extra metadata that is not directly fetched by eBPF.
+// Enrichers are invoked sequentially from the decoration stage, so implementations don't need
+// to be thread-safe, but they should not block for long.
+type Enricher interface {
+	Enrich(record *Record)
+}
+
+// EnricherFunc allows using a function as an Enricher
+type EnricherFunc func(record *Record)
+
+func (ef EnricherFunc) Enrich(record *Record) {
+	ef(record)
+}
+
+// EnricherContext provides the information that an EnricherProvider might require to
+// instantiate an Enricher
+type EnricherContext struct {
+	AgentIP        net.IP
+	InterfaceNamer InterfaceNamer
+	// ServicePorts maps destination ports to well-known service names. If nil,
+	// DefaultServicePorts is used
+	ServicePorts map[uint16]string
+	// ReverseDNS configures the reverse DNS enricher. If nil, the default configuration is used
+	ReverseDNS *ReverseDNSConfig
+	// ClusterID and TenantID are the identifiers set by the identity enricher
+	ClusterID string
+	TenantID  string
+	// SubnetLabels configures the subnet labels enricher. It is required by that enricher
+	SubnetLabels *SubnetLabelsConfig
+	// AgentVersion and BpfProgHash are the build identifiers set by the build info enricher
+	AgentVersion string
+	BpfProgHash  string
+	// NodeName qualifies the interface identifiers set by the interface ID enricher
+	NodeName string
+	// QUIC configures the QUIC enricher. If nil, the default configuration is used
+	QUIC *QUICConfig
+	// GeoIPDatabase is the path of the MaxMind DB file of the GeoIP enricher. It is required by
+	// that enricher
+	GeoIPDatabase string
+	// Kubeconfig is the path of the kubeconfig file of the Kubernetes enricher. If empty, the
+	// in-cluster configuration is used
+	Kubeconfig string
+}
+
+// EnricherProvider instantiates an Enricher from the provided context
+type EnricherProvider func(ctx *EnricherContext) (Enricher, error)
+
+var (
+	enrichersMutex sync.RWMutex
+	enrichers      = map[string]EnricherProvider{}
+)
+
+func init() {
+	RegisterEnricher(EnricherInterfaceName, func(ctx *EnricherContext) (Enricher, error) {
+		return EnricherFunc(func(record *Record) {
+			record.Interface = ctx.InterfaceNamer(int(record.Id.IfIndex))
+		}), nil
+	})
+	RegisterEnricher(EnricherAgentIP, func(ctx *EnricherContext) (Enricher, error) {
+		return EnricherFunc(func(record *Record) {
+			record.AgentIP = ctx.AgentIP
+		}), nil
+	})
+	RegisterEnricher(EnricherIdentity, func(ctx *EnricherContext) (Enricher, error) {
+		return EnricherFunc(func(record *Record) {
+			record.ClusterID = ctx.ClusterID
+			record.TenantID = ctx.TenantID
+		}), nil
+	})
+	RegisterEnricher(EnricherBuildInfo, func(ctx *EnricherContext) (Enricher, error) {
+		return EnricherFunc(func(record *Record) {
+			record.AgentVersion = ctx.AgentVersion
+			record.BpfProgHash = ctx.BpfProgHash
+		}), nil
+	})
+	RegisterEnricher(EnricherInterfaceID, func(ctx *EnricherContext) (Enricher, error) {
+		if ctx.NodeName == "" {
+			return nil, errors.New("missing node name")
+		}
+		return EnricherFunc(func(record *Record) {
+			record.InterfaceID = QualifiedInterfaceID(ctx.NodeName, record.Id.IfIndex)
+		}), nil
+	})
+}
+
+// QualifiedInterfaceID returns the identifier of an interface that is unique across the
+// cluster, as the interface indexes of different nodes may collide: the node name and the
+// interface index, separated by a slash (e.g. "worker-1/3")
+func QualifiedInterfaceID(nodeName string, ifIndex uint32) string {
+	return nodeName + "/" + strconv.FormatUint(uint64(ifIndex), 10)
+}
+
+// RegisterEnricher makes an Enricher available by the provided name, so it can be selected
+// from the agent configuration. It is intended to be invoked from the init function of the
+// packages that provide custom enrichers, so they are registered by just importing them.
+// It panics if an enricher with the same name is already registered.
+func RegisterEnricher(name string, provider EnricherProvider) {
+	enrichersMutex.Lock()
+	defer enrichersMutex.Unlock()
+	if provider == nil {
+		panic("flow: RegisterEnricher provider is nil for " + name)
+	}
+	if _, ok := enrichers[name]; ok {
+		panic("flow: RegisterEnricher called twice for " + name)
+	}
+	enrichers[name] = provider
+}
+
+// RegisteredEnrichers returns the sorted names of all the registered enrichers
+func RegisteredEnrichers() []string {
+	enrichersMutex.RLock()
+	defer enrichersMutex.RUnlock()
+	return registeredEnrichers()
+}
+
+func registeredEnrichers() []string {
+	names := make([]string, 0, len(enrichers))
+	for name := range enrichers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewEnrichers instantiates the chain of enrichers with the provided names, in the same order.
+func NewEnrichers(names []string, ctx *EnricherContext) ([]Enricher, error) {
+	enrichersMutex.RLock()
+	defer enrichersMutex.RUnlock()
+	chain := make([]Enricher, 0, len(names))
+	for _, name := range names {
+		provider, ok := enrichers[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown enricher %q. Registered enrichers: %v",
+				name, registeredEnrichers())
+		}
+		enricher, err := provider(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("instantiating enricher %q: %w", name, err)
+		}
+		chain = append(chain, enricher)
+	}
+	return chain, nil
+}
@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type invocationRecorder struct {
+	name        string
+	invocations *[]string
+}
+
+func (ir *invocationRecorder) Enrich(record *Record) {
+	*ir.invocations = append(*ir.invocations, ir.name)
+	record.Interface += ir.name
+}
+
+func TestEnrich_InvokedInOrder(t *testing.T) {
+	var invocations []string
+	for _, name := range []string{"test-foo", "test-bar", "test-baz"} {
+		name := name
+		RegisterEnricher(name, func(_ *EnricherContext) (Enricher, error) {
+			return &invocationRecorder{name: name, invocations: &invocations}, nil
+		})
+	}
+	chain, err := NewEnrichers([]string{"test-baz", "test-foo", EnricherAgentIP, "test-bar"},
+		&EnricherContext{AgentIP: net.ParseIP("10.0.0.1")})
+	require.NoError(t, err)
+
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go Enrich(chain)(in, out)
+	in <- []*Record{{}}
+	flows := <-out
+
+	require.Len(t, flows, 1)
+	assert.Equal(t, []string{"test-baz", "test-foo", "test-bar"}, invocations)
+	assert.Equal(t, "test-baztest-footest-bar", flows[0].Interface)
+	assert.Equal(t, "10.0.0.1", flows[0].AgentIP.String())
+}
+
+func TestIdentityEnricher(t *testing.T) {
+	chain, err := NewEnrichers([]string{EnricherIdentity},
+		&EnricherContext{ClusterID: "east-1", TenantID: "acme"})
+	require.NoError(t, err)
+	record := &Record{}
+	chain[0].Enrich(record)
+	assert.Equal(t, "east-1", record.ClusterID)
+	assert.Equal(t, "acme", record.TenantID)
+}
+
+func TestBuildInfoEnricher(t *testing.T) {
+	chain, err := NewEnrichers([]string{EnricherBuildInfo},
+		&EnricherContext{AgentVersion: "v1.2.3", BpfProgHash: "0123456789abcdef"})
+	require.NoError(t, err)
+	record := &Record{}
+	chain[0].Enrich(record)
+	assert.Equal(t, "v1.2.3", record.AgentVersion)
+	assert.Equal(t, "0123456789abcdef", record.BpfProgHash)
+}
+
+func TestInterfaceIDEnricher(t *testing.T) {
+	// GIVEN the same interface indexes in two different nodes
+	ids := map[string]struct{}{}
+	for _, node := range []string{"worker-1", "worker-2"} {
+		chain, err := NewEnrichers([]string{EnricherInterfaceID}, &EnricherContext{NodeName: node})
+		require.NoError(t, err)
+		for _, ifIndex := range []uint32{1, 2, 3} {
+			record := &Record{}
+			record.Id.IfIndex = ifIndex
+			chain[0].Enrich(record)
+			ids[record.InterfaceID] = struct{}{}
+		}
+	}
+	// THEN the qualified identifiers are unique across the nodes
+	assert.Len(t, ids, 6)
+	assert.Contains(t, ids, "worker-1/3")
+	assert.Contains(t, ids, "worker-2/3")
+
+	// AND the node name is required
+	_, err := NewEnrichers([]string{EnricherInterfaceID}, &EnricherContext{})
+	assert.Error(t, err)
+}
+
+func TestNewEnrichers_Unknown(t *testing.T) {
+	_, err := NewEnrichers([]string{EnricherInterfaceName, "not-registered"}, &EnricherContext{})
+	assert.Error(t, err)
+}
+
+func TestRegisterEnricher_Twice(t *testing.T) {
+	assert.Panics(t, func() {
+		RegisterEnricher(EnricherAgentIP, func(_ *EnricherContext) (Enricher, error) {
+			return EnricherFunc(func(*Record) {}), nil
+		})
+	})
+}
+
+func TestDecorate_SkipsFailingEnrichers(t *testing.T) {
+	RegisterEnricher("test-failing", func(_ *EnricherContext) (Enricher, error) {
+		return nil, errors.New("can't instantiate")
+	})
+	defaults := DefaultEnrichers
+	DefaultEnrichers = []string{"test-failing", EnricherAgentIP, "not-registered"}
+	defer func() { DefaultEnrichers = defaults }()
+
+	// GIVEN default enrichers that can't be instantiated
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	decorate := Decorate(net.ParseIP("10.0.0.1"), func(int) string { return "eth0" })
+
+	// THEN the rest of enrichers are still invoked
+	go decorate(in, out)
+	in <- []*Record{{}}
+	flows := <-out
+	require.Len(t, flows, 1)
+	assert.Equal(t, "10.0.0.1", flows[0].AgentIP.String())
+}
@@ -0,0 +1,145 @@
+package flow
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// number of releases per second of the delayed records, so they aren't forwarded one by one
+const exportRateReleasesPerSecond = 10
+
+// ExportRateLimiter caps the number of records per second that are forwarded to the exporter,
+// through a token bucket that is refilled at the given rate and holds up to one second of
+// records. The excess records are dropped or, if delay is true, queued until the bucket is
+// refilled. The queue holds up to maxQueued records, and the records that don't fit are
+// dropped, so a sustained excess never blocks the pipeline.
+type ExportRateLimiter struct {
+	rate      float64
+	tokens    float64
+	last      time.Time
+	delay     bool
+	maxQueued int
+	queue     []*Record
+	clock     func() time.Time
+	dropped   prometheus.Counter
+	delayed   prometheus.Counter
+}
+
+// NewExportRateLimiter creates an ExportRateLimiter that forwards up to rate records per second
+func NewExportRateLimiter(
+	rate int, delay bool, maxQueued int, clock func() time.Time, m *metrics.Metrics,
+) *ExportRateLimiter {
+	return &ExportRateLimiter{
+		rate:      float64(rate),
+		tokens:    float64(rate),
+		last:      clock(),
+		delay:     delay,
+		maxQueued: maxQueued,
+		clock:     clock,
+		dropped: m.NewCounter("export_rate_dropped_records_total",
+			"Number of records that have been dropped because they exceeded the maximum export rate"),
+		delayed: m.NewCounter("export_rate_delayed_records_total",
+			"Number of records whose export has been delayed because they exceeded the maximum "+
+				"export rate"),
+	}
+}
+
+// Limit forwards the records within the maximum export rate. When the input channel is closed,
+// the queued records are forwarded without further delay.
+func (el *ExportRateLimiter) Limit(in <-chan []*Record, out chan<- []*Record) {
+	for {
+		var release <-chan time.Time
+		if len(el.queue) > 0 {
+			release = time.After(el.nextRelease())
+		}
+		select {
+		case records, ok := <-in:
+			if !ok {
+				if len(el.queue) > 0 {
+					out <- el.queue
+				}
+				return
+			}
+			el.admit(records, out)
+		case <-release:
+			el.release(out)
+		}
+	}
+}
+
+func (el *ExportRateLimiter) refill() {
+	now := el.clock()
+	el.tokens += now.Sub(el.last).Seconds() * el.rate
+	if el.tokens > el.rate {
+		el.tokens = el.rate
+	}
+	el.last = now
+}
+
+// take returns the number of the n records that can be forwarded now, and consumes their tokens
+func (el *ExportRateLimiter) take(n int) int {
+	el.refill()
+	if allowed := int(el.tokens); allowed < n {
+		n = allowed
+	}
+	el.tokens -= float64(n)
+	return n
+}
+
+func (el *ExportRateLimiter) admit(records []*Record, out chan<- []*Record) {
+	if !el.delay {
+		n := el.take(len(records))
+		if n > 0 {
+			out <- records[:n]
+		}
+		if excess := len(records) - n; excess > 0 {
+			el.dropped.Add(float64(excess))
+		}
+		return
+	}
+	// the queued records go first, so the records are forwarded in order
+	if len(el.queue) == 0 {
+		n := el.take(len(records))
+		if n > 0 {
+			out <- records[:n]
+		}
+		records = records[n:]
+	}
+	if free := el.maxQueued - len(el.queue); len(records) > free {
+		el.dropped.Add(float64(len(records) - free))
+		records = records[:free]
+	}
+	el.delayed.Add(float64(len(records)))
+	el.queue = append(el.queue, records...)
+}
+
+// release forwards the queued records that fit in the bucket
+func (el *ExportRateLimiter) release(out chan<- []*Record) {
+	n := el.take(len(el.queue))
+	if n == 0 {
+		return
+	}
+	out <- el.queue[:n]
+	// the forwarded records are not reused, so the rest are copied to a new slice
+	el.queue = append([]*Record(nil), el.queue[n:]...)
+}
+
+// nextRelease returns how long to wait until the bucket holds enough tokens to release a chunk
+// of the queued records
+func (el *ExportRateLimiter) nextRelease() time.Duration {
+	chunk := el.rate / exportRateReleasesPerSecond
+	if chunk < 1 {
+		chunk = 1
+	}
+	if queued := float64(len(el.queue)); queued < chunk {
+		chunk = queued
+	}
+	el.refill()
+	if el.tokens >= chunk {
+		return 0
+	}
+	return time.Duration((chunk - el.tokens) / el.rate * float64(time.Second))
+}
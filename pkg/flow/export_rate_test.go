@@ -0,0 +1,82 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func rateRecords(n int) []*Record {
+	records := make([]*Record, n)
+	for i := range records {
+		records[i] = &Record{RawRecord: RawRecord{Id: ebpf.BpfFlowId{SrcPort: uint16(i)}}}
+	}
+	return records
+}
+
+func TestExportRateLimiter_Drop(t *testing.T) {
+	m := metrics.NoOp()
+	now := time.Now()
+	el := NewExportRateLimiter(100, false, 0, func() time.Time { return now }, m)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go el.Limit(in, out)
+	defer close(in)
+
+	// WHEN the records exceed the rate
+	in <- rateRecords(250)
+
+	// THEN only a second worth of records is forwarded, and the rest are dropped
+	records := receiveTimeout(t, out)
+	assert.Len(t, records, 100)
+	assert.EqualValues(t, 0, records[0].Id.SrcPort)
+	assert.EqualValues(t, 150, counterValue(t, m, "export_rate_dropped_records_total"))
+
+	// AND the bucket is refilled over time
+	now = now.Add(300 * time.Millisecond)
+	in <- rateRecords(50)
+	records = receiveTimeout(t, out)
+	assert.Len(t, records, 30)
+	assert.EqualValues(t, 170, counterValue(t, m, "export_rate_dropped_records_total"))
+}
+
+func TestExportRateLimiter_Delay(t *testing.T) {
+	m := metrics.NoOp()
+	el := NewExportRateLimiter(200, true, 100, time.Now, m)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go el.Limit(in, out)
+	defer close(in)
+
+	// WHEN the records exceed the rate and the queue
+	start := time.Now()
+	in <- rateRecords(400)
+
+	// THEN a second worth of records is forwarded immediately
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 200)
+
+	// AND the queued records are forwarded later, in order, within the rate
+	var delayed []*Record
+	for len(delayed) < 100 {
+		delayed = append(delayed, receiveTimeout(t, out)...)
+	}
+	require.Len(t, delayed, 100)
+	for i, r := range delayed {
+		assert.EqualValues(t, 200+i, r.Id.SrcPort)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	assert.EqualValues(t, 100, counterValue(t, m, "export_rate_delayed_records_total"))
+	// AND the records that didn't fit in the queue are dropped
+	assert.EqualValues(t, 100, counterValue(t, m, "export_rate_dropped_records_total"))
+	select {
+	case r := <-out:
+		require.Failf(t, "unexpected records", "%d records", len(r))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,123 @@
+package flow
+
+import (
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var etlog = logrus.WithField("component", "flow.ExportTrafficFilter")
+
+// Endpoint is the host name or IP, and the port, of a collector the flows are exported to
+type Endpoint struct {
+	Host string
+	Port uint16
+}
+
+type endpointKey struct {
+	ip   IPAddr
+	port uint16
+}
+
+// ExportTrafficFilter drops the flows from or to the collector endpoints, so the agent doesn't
+// observe the traffic of its own telemetry. The host names of the endpoints are resolved again
+// after each refresh period, to follow the changes of the collectors' addresses.
+type ExportTrafficFilter struct {
+	endpoints      []Endpoint
+	lookup         func(host string) ([]net.IP, error)
+	refresh        time.Duration
+	clock          func() time.Time
+	resolved       map[endpointKey]struct{}
+	resolvedAt     time.Time
+	droppedCounter prometheus.Counter
+}
+
+// NewExportTrafficFilter creates an ExportTrafficFilter for the provided endpoints. The host
+// names are resolved with the lookup function (net.LookupIP if nil).
+func NewExportTrafficFilter(
+	endpoints []Endpoint, lookup func(host string) ([]net.IP, error), refresh time.Duration,
+	clock func() time.Time, m *metrics.Metrics,
+) *ExportTrafficFilter {
+	if lookup == nil {
+		lookup = net.LookupIP
+	}
+	return &ExportTrafficFilter{
+		endpoints: endpoints,
+		lookup:    lookup,
+		refresh:   refresh,
+		clock:     clock,
+		resolved:  map[endpointKey]struct{}{},
+		droppedCounter: m.NewCounter("export_traffic_dropped_flows_total",
+			"Number of flows that have been dropped because they were from or to the collectors "+
+				"the flows are exported to"),
+	}
+}
+
+// Filter forwards the flows from the input to the output channel, except those whose source or
+// destination address and port match any collector endpoint
+func (ef *ExportTrafficFilter) Filter(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		if now := ef.clock(); ef.resolvedAt.IsZero() || now.Sub(ef.resolvedAt) >= ef.refresh {
+			ef.resolve()
+			ef.resolvedAt = now
+		}
+		fwd := make([]*Record, 0, len(records))
+		for _, record := range records {
+			if !ef.matches(record) {
+				fwd = append(fwd, record)
+			}
+		}
+		if dropped := len(records) - len(fwd); dropped > 0 {
+			ef.droppedCounter.Add(float64(dropped))
+		}
+		if len(fwd) > 0 {
+			out <- fwd
+		}
+	}
+}
+
+func (ef *ExportTrafficFilter) matches(record *Record) bool {
+	if _, ok := ef.resolved[endpointKey{ip: record.Id.DstIp, port: record.Id.DstPort}]; ok {
+		return true
+	}
+	_, ok := ef.resolved[endpointKey{ip: record.Id.SrcIp, port: record.Id.SrcPort}]
+	return ok
+}
+
+// resolve the addresses of the endpoints. If a host name can't be resolved, its previously
+// resolved addresses are kept.
+func (ef *ExportTrafficFilter) resolve() {
+	resolved := map[endpointKey]struct{}{}
+	for _, endpoint := range ef.endpoints {
+		ips := []net.IP{net.ParseIP(endpoint.Host)}
+		if ips[0] == nil {
+			var err error
+			if ips, err = ef.lookup(endpoint.Host); err != nil {
+				etlog.WithError(err).WithField("host", endpoint.Host).
+					Warn("can't resolve collector host. Keeping its previous addresses")
+				ef.keepPrevious(resolved, endpoint.Port)
+				continue
+			}
+		}
+		for _, ip := range ips {
+			var addr IPAddr
+			copy(addr[:], ip.To16())
+			resolved[endpointKey{ip: addr, port: endpoint.Port}] = struct{}{}
+		}
+	}
+	ef.resolved = resolved
+}
+
+// keepPrevious copies the previously resolved addresses with the provided port. Since the
+// previous addresses aren't tracked by host, those of other hosts with the same port are kept too.
+func (ef *ExportTrafficFilter) keepPrevious(resolved map[endpointKey]struct{}, port uint16) {
+	for key := range ef.resolved {
+		if key.port == port {
+			resolved[key] = struct{}{}
+		}
+	}
+}
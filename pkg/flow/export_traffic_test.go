@@ -0,0 +1,91 @@
+package flow
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func collectorFlow(src string, srcPort uint16, dst string, dstPort uint16) *Record {
+	r := &Record{RawRecord: RawRecord{
+		Id: ebpf.BpfFlowId{
+			TransportProtocol: syscall.IPPROTO_TCP, SrcPort: srcPort, DstPort: dstPort,
+		},
+		Metrics: ebpf.BpfFlowMetrics{Packets: 1, Bytes: 100},
+	}}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestExportTrafficFilter(t *testing.T) {
+	m := metrics.NoOp()
+	lookups := 0
+	lookup := func(host string) ([]net.IP, error) {
+		lookups++
+		assert.Equal(t, "flp.netobserv", host)
+		return []net.IP{net.ParseIP("10.0.0.100"), net.ParseIP("fd00::100")}, nil
+	}
+	// GIVEN a filter for a collector host name and a collector IP
+	filter := NewExportTrafficFilter([]Endpoint{
+		{Host: "flp.netobserv", Port: 2055},
+		{Host: "10.0.0.200", Port: 9092},
+	}, lookup, time.Hour, time.Now, m)
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go filter.Filter(in, out)
+	defer close(in)
+
+	// WHEN it receives flows to and from the collectors, and other flows
+	toCollector := collectorFlow("10.0.0.1", 34567, "10.0.0.100", 2055)
+	toCollectorV6 := collectorFlow("fd00::1", 34567, "fd00::100", 2055)
+	fromBroker := collectorFlow("10.0.0.200", 9092, "10.0.0.1", 45678)
+	otherPort := collectorFlow("10.0.0.1", 34567, "10.0.0.100", 8080)
+	otherHost := collectorFlow("10.0.0.1", 34567, "10.0.0.101", 2055)
+	in <- []*Record{toCollector, toCollectorV6, fromBroker, otherPort, otherHost}
+
+	// THEN only the flows from and to the collector endpoints are dropped
+	assert.Equal(t, []*Record{otherPort, otherHost}, receiveTimeout(t, out))
+	assert.EqualValues(t, 3, counterValue(t, m, "export_traffic_dropped_flows_total"))
+	// AND the host names are resolved once per refresh period
+	in <- []*Record{collectorFlow("10.0.0.1", 34567, "10.0.0.100", 2055), otherHost}
+	assert.Equal(t, []*Record{otherHost}, receiveTimeout(t, out))
+	assert.Equal(t, 1, lookups)
+}
+
+func TestExportTrafficFilter_Refresh(t *testing.T) {
+	now := time.Now()
+	addresses := []net.IP{net.ParseIP("10.0.0.100")}
+	var lookupErr error
+	filter := NewExportTrafficFilter([]Endpoint{{Host: "flp", Port: 2055}},
+		func(string) ([]net.IP, error) { return addresses, lookupErr },
+		time.Minute, func() time.Time { return now }, metrics.NoOp())
+	toFirst := collectorFlow("10.0.0.1", 34567, "10.0.0.100", 2055)
+	toSecond := collectorFlow("10.0.0.1", 34567, "10.0.0.101", 2055)
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go filter.Filter(in, out)
+	defer close(in)
+
+	in <- []*Record{toFirst, toSecond}
+	assert.Equal(t, []*Record{toSecond}, receiveTimeout(t, out))
+
+	// when the collector's address changes, the new address is used after the refresh period
+	addresses = []net.IP{net.ParseIP("10.0.0.101")}
+	now = now.Add(time.Minute)
+	in <- []*Record{toFirst, toSecond}
+	assert.Equal(t, []*Record{toFirst}, receiveTimeout(t, out))
+
+	// if the host can't be resolved, its previous address is kept
+	lookupErr = errors.New("no such host")
+	now = now.Add(time.Minute)
+	in <- []*Record{toFirst, toSecond}
+	assert.Equal(t, []*Record{toFirst}, receiveTimeout(t, out))
+}
@@ -0,0 +1,114 @@
+package flow
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// sampledConn identifies the flows whose sampling decision is shared: both directions of a
+// connection, as observed from any interface
+type sampledConn struct {
+	connKey
+	protocol uint8
+}
+
+type samplingDecision struct {
+	conn     sampledConn
+	sampled  bool
+	lastSeen time.Time
+}
+
+// FlowSampler samples one out of each "rate" flows. Unlike packet sampling, the decision is taken
+// when a flow is first observed and is kept for the whole lifetime of the flow, so the records of
+// a sampled flow are forwarded in all the eviction windows, with their full byte and packet
+// counts, and the records of a discarded flow are never forwarded. The decision is shared by
+// both directions of the connection, and by all the interfaces where it is observed. A decision
+// is forgotten when no record has been observed for the flow during the expiry time, or when
+// it is the least recently observed and the maximum number of decisions is reached.
+type FlowSampler struct {
+	sampler   sampler
+	decisions map[sampledConn]*list.Element
+	// elements: *samplingDecision ordered by lastSeen time
+	lru        *list.List
+	expiry     time.Duration
+	maxFlows   int
+	clock      func() time.Time
+	sampledOut prometheus.Counter
+	evicted    prometheus.Counter
+}
+
+// NewFlowSampler creates a FlowSampler that forwards one out of each rate flows, keeping the
+// decisions of up to maxFlows flows. Zero means unbounded.
+func NewFlowSampler(
+	rate int, expiry time.Duration, maxFlows int, clock func() time.Time, m *metrics.Metrics,
+) *FlowSampler {
+	return &FlowSampler{
+		sampler:   sampler{rate: rate},
+		decisions: map[sampledConn]*list.Element{},
+		lru:       list.New(),
+		expiry:    expiry,
+		maxFlows:  maxFlows,
+		clock:     clock,
+		sampledOut: m.NewCounter("flow_sampling_dropped_flows_total",
+			"Number of flow records that have been discarded by the flow sampling"),
+		evicted: m.NewCounter("flow_sampling_evicted_decisions_total",
+			"Number of flow sampling decisions that have been forgotten before expiring, "+
+				"because the maximum number of decisions was reached"),
+	}
+}
+
+// Sample forwards the records of the sampled flows
+func (fs *FlowSampler) Sample(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		now := fs.clock()
+		fs.purgeExpired(now)
+		fwd := make([]*Record, 0, len(records))
+		for _, record := range records {
+			if fs.decide(record, now) {
+				fwd = append(fwd, record)
+			}
+		}
+		if dropped := len(records) - len(fwd); dropped > 0 {
+			fs.sampledOut.Add(float64(dropped))
+		}
+		if len(fwd) > 0 {
+			out <- fwd
+		}
+	}
+}
+
+// decide returns whether the record is sampled, taking the decision if its connection is new
+func (fs *FlowSampler) decide(record *Record, now time.Time) bool {
+	conn, _ := connectionOf(record)
+	key := sampledConn{connKey: conn, protocol: record.Id.TransportProtocol}
+	if elem, ok := fs.decisions[key]; ok {
+		decision := elem.Value.(*samplingDecision)
+		decision.lastSeen = now
+		fs.lru.MoveToBack(elem)
+		return decision.sampled
+	}
+	if fs.maxFlows > 0 && fs.lru.Len() >= fs.maxFlows {
+		oldest := fs.lru.Front()
+		delete(fs.decisions, oldest.Value.(*samplingDecision).conn)
+		fs.lru.Remove(oldest)
+		fs.evicted.Inc()
+	}
+	decision := &samplingDecision{conn: key, sampled: fs.sampler.admit(), lastSeen: now}
+	fs.decisions[key] = fs.lru.PushBack(decision)
+	return decision.sampled
+}
+
+func (fs *FlowSampler) purgeExpired(now time.Time) {
+	for elem := fs.lru.Front(); elem != nil; elem = fs.lru.Front() {
+		decision := elem.Value.(*samplingDecision)
+		if now.Sub(decision.lastSeen) < fs.expiry {
+			return
+		}
+		delete(fs.decisions, decision.conn)
+		fs.lru.Remove(elem)
+	}
+}
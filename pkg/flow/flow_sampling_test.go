@@ -0,0 +1,100 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestFlowSampler_Sticky(t *testing.T) {
+	m := metrics.NoOp()
+	now := time.Now()
+	fs := NewFlowSampler(2, time.Minute, 0, func() time.Time { return now }, m)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go fs.Sample(in, out)
+	defer close(in)
+
+	sampled := ebpf.BpfFlowId{SrcPort: 1}
+	discarded := ebpf.BpfFlowId{SrcPort: 2}
+	window := func(first, second ebpf.BpfFlowId, bytes uint64) []*Record {
+		return []*Record{
+			{RawRecord: RawRecord{Id: first, Metrics: ebpf.BpfFlowMetrics{Packets: 1, Bytes: bytes}}},
+			{RawRecord: RawRecord{Id: second, Metrics: ebpf.BpfFlowMetrics{Packets: 1, Bytes: bytes}}},
+		}
+	}
+
+	// GIVEN two flows spanning several eviction windows, in varying order
+	in <- window(sampled, discarded, 100)
+	in <- window(discarded, sampled, 200)
+	in <- window(discarded, sampled, 300)
+
+	// THEN the records of the sampled flow are forwarded in all the windows, so its full byte
+	// count is preserved, while the unsampled flow is entirely absent
+	var sampledBytes uint64
+	for i := 0; i < 3; i++ {
+		records := receiveTimeout(t, out)
+		assert.Len(t, records, 1)
+		assert.Equal(t, sampled, records[0].Id)
+		sampledBytes += records[0].Metrics.Bytes
+	}
+	assert.EqualValues(t, 600, sampledBytes)
+	assert.EqualValues(t, 3, counterValue(t, m, "flow_sampling_dropped_flows_total"))
+
+	// AND the decisions are forgotten after the flows expire, so the next flow is sampled again
+	now = now.Add(time.Minute)
+	in <- window(discarded, sampled, 400)
+	records := receiveTimeout(t, out)
+	assert.Len(t, records, 1)
+	assert.Equal(t, discarded, records[0].Id)
+}
+
+func TestFlowSampler_SharedByConnection(t *testing.T) {
+	fs := NewFlowSampler(2, time.Minute, 0, time.Now, metrics.NoOp())
+	now := time.Now()
+	flowID := func(src, dst uint16, ifIndex uint32, direction uint8) *Record {
+		r := &Record{}
+		r.Id.SrcPort, r.Id.DstPort = src, dst
+		r.Id.IfIndex, r.Id.Direction = ifIndex, direction
+		r.Id.TransportProtocol = 6
+		return r
+	}
+
+	// GIVEN a sampled connection
+	require.True(t, fs.decide(flowID(1234, 80, 1, DirectionEgress), now))
+	// THEN its reverse direction and its flows from other interfaces are sampled too
+	assert.True(t, fs.decide(flowID(80, 1234, 1, DirectionIngress), now))
+	assert.True(t, fs.decide(flowID(1234, 80, 2, DirectionIngress), now))
+	// AND a new connection takes a new decision
+	assert.False(t, fs.decide(flowID(1235, 80, 1, DirectionEgress), now))
+}
+
+func TestFlowSampler_MaxFlows(t *testing.T) {
+	m := metrics.NoOp()
+	fs := NewFlowSampler(2, time.Minute, 2, time.Now, m)
+	now := time.Now()
+	flowID := func(src uint16) *Record {
+		r := &Record{}
+		r.Id.SrcPort = src
+		return r
+	}
+
+	// GIVEN the decisions of two flows, where the first one is the most recently observed
+	require.True(t, fs.decide(flowID(1), now))
+	require.False(t, fs.decide(flowID(2), now))
+	require.True(t, fs.decide(flowID(1), now))
+
+	// WHEN a third flow is observed
+	require.True(t, fs.decide(flowID(3), now))
+
+	// THEN the least recently observed decision is forgotten
+	assert.Len(t, fs.decisions, 2)
+	assert.EqualValues(t, 1, counterValue(t, m, "flow_sampling_evicted_decisions_total"))
+	conn, _ := connectionOf(flowID(2))
+	assert.NotContains(t, fs.decisions, sampledConn{connKey: conn})
+}
@@ -0,0 +1,64 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+)
+
+// EnricherGeoIP decorates the flows with the countries of their source and destination addresses,
+// as found in a MaxMind DB file
+const EnricherGeoIP = "geoip"
+
+var geolog = logrus.WithField("component", "flow.GeoIP")
+
+func init() {
+	RegisterEnricher(EnricherGeoIP, func(ctx *EnricherContext) (Enricher, error) {
+		if ctx.GeoIPDatabase == "" {
+			return nil, errors.New("missing GeoIP database file")
+		}
+		return NewGeoIP(ctx.GeoIPDatabase)
+	})
+}
+
+// geoIPRecord is the subset of the GeoIP2/GeoLite2 Country and City records that is decoded
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// GeoIP enricher sets the SrcCountry and DstCountry fields of the flows with the ISO 3166-1
+// country codes of their source and destination addresses. The addresses are looked up in a
+// MaxMind DB file (e.g. GeoLite2-Country.mmdb or any database with the same country fields),
+// which is memory-mapped. The fields are left empty for the addresses that aren't found, such as
+// the private ones.
+type GeoIP struct {
+	db *maxminddb.Reader
+}
+
+// NewGeoIP opens the MaxMind DB file in the provided path
+func NewGeoIP(path string) (*GeoIP, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening GeoIP database: %w", err)
+	}
+	return &GeoIP{db: db}, nil
+}
+
+func (g *GeoIP) Enrich(record *Record) {
+	record.SrcCountry = g.country(record.Id.SrcIp)
+	record.DstCountry = g.country(record.Id.DstIp)
+}
+
+func (g *GeoIP) country(addr IPAddr) string {
+	geoRecord := geoIPRecord{}
+	if err := g.db.Lookup(IP(addr), &geoRecord); err != nil {
+		// e.g. an IPv6 address in an IPv4-only database
+		geolog.WithError(err).Debug("can't look up address")
+		return ""
+	}
+	return geoRecord.Country.ISOCode
+}
@@ -0,0 +1,90 @@
+package flow
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testMMDB returns an IPv4 MaxMind DB where the 1.0.0.0/8 network belongs to the provided
+// country. Its search tree has a node for each bit of the prefix.
+func testMMDB(country string) []byte {
+	const nodeCount = 8
+	record := func(v uint32) []byte {
+		return []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	}
+	var db []byte
+	for node := uint32(0); node < nodeCount-1; node++ {
+		// the first 7 bits of the prefix are zeros
+		db = append(db, record(node+1)...)
+		db = append(db, record(nodeCount)...)
+	}
+	// the last bit of the prefix is a one, which points to the first entry of the data section
+	db = append(db, record(nodeCount)...)
+	db = append(db, record(nodeCount+16)...)
+	db = append(db, make([]byte, 16)...)
+
+	str := func(s string) []byte {
+		return append([]byte{0x40 | byte(len(s))}, s...)
+	}
+	// {"country": {"iso_code": country}}
+	db = append(db, 0xe1)
+	db = append(db, str("country")...)
+	db = append(db, 0xe1)
+	db = append(db, str("iso_code")...)
+	db = append(db, str(country)...)
+
+	db = append(db, "\xab\xcd\xefMaxMind.com"...)
+	db = append(db, 0xe3)
+	db = append(db, str("node_count")...)
+	db = append(db, 0xc1, nodeCount)
+	db = append(db, str("record_size")...)
+	db = append(db, 0xa1, 24)
+	db = append(db, str("ip_version")...)
+	db = append(db, 0xa1, 4)
+	return db
+}
+
+func TestGeoIP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	require.NoError(t, os.WriteFile(path, testMMDB("AU"), 0o644))
+	g, err := NewGeoIP(path)
+	require.NoError(t, err)
+
+	// GIVEN a flow between an address of the database and a private address
+	record := &Record{}
+	copy(record.Id.SrcIp[:], net.ParseIP("1.2.3.4").To16())
+	copy(record.Id.DstIp[:], net.ParseIP("10.0.0.1").To16())
+	// AND a flow between IPv6 addresses, which aren't in the IPv4 database
+	v6 := &Record{}
+	copy(v6.Id.SrcIp[:], net.ParseIP("2001:db8::1"))
+	copy(v6.Id.DstIp[:], net.ParseIP("2001:db8::2"))
+
+	// WHEN they are enriched
+	g.Enrich(record)
+	g.Enrich(v6)
+
+	// THEN the country of the known address is set
+	assert.Equal(t, "AU", record.SrcCountry)
+	assert.Empty(t, record.DstCountry)
+	// AND the unknown addresses are left empty
+	assert.Empty(t, v6.SrcCountry)
+	assert.Empty(t, v6.DstCountry)
+}
+
+func TestGeoIP_InvalidDatabase(t *testing.T) {
+	_, err := NewGeoIP(filepath.Join(t.TempDir(), "missing.mmdb"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("not a database"), 0o644))
+	_, err = NewGeoIP(path)
+	assert.Error(t, err)
+
+	_, err = NewEnrichers([]string{EnricherGeoIP}, &EnricherContext{})
+	assert.Error(t, err)
+}
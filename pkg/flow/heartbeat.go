@@ -0,0 +1,49 @@
+package flow
+
+import (
+	"net"
+	"time"
+)
+
+// NewHeartbeat returns a heartbeat record: a synthetic record whose EndReason is
+// FlowEndReasonHeartbeat, that only carries the time and the identity of the agent, and whose
+// flow fields are zero. It tells the collectors that the agent is alive even if there is no
+// traffic, and must not be accounted as a flow.
+func NewHeartbeat(now time.Time, agentIP net.IP, clusterID, tenantID string) *Record {
+	return &Record{
+		TimeFlowStart: now,
+		TimeFlowEnd:   now,
+		AgentIP:       agentIP,
+		ClusterID:     clusterID,
+		TenantID:      tenantID,
+		EndReason:     FlowEndReasonHeartbeat,
+		SchemaVersion: SchemaVersion,
+	}
+}
+
+// IsHeartbeat returns whether the record is a heartbeat instead of an actual flow
+func (r *Record) IsHeartbeat() bool {
+	return r.EndReason == FlowEndReasonHeartbeat
+}
+
+// Heartbeats forwards the flows and, every interval, a heartbeat record in its own batch, as
+// returned by the newHeartbeat function.
+func Heartbeats(
+	interval time.Duration, newHeartbeat func() *Record,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case records, ok := <-in:
+				if !ok {
+					return
+				}
+				out <- records
+			case <-ticker.C:
+				out <- []*Record{newHeartbeat()}
+			}
+		}
+	}
+}
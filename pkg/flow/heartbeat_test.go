@@ -0,0 +1,53 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeats(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	agentIP := net.ParseIP("10.0.0.1")
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go Heartbeats(interval, func() *Record {
+		return NewHeartbeat(time.Now(), agentIP, "cluster", "tenant")
+	})(in, out)
+
+	// GIVEN an idle pipeline
+	// THEN a heartbeat is emitted at each interval
+	start := time.Now()
+	for beats := 0; beats < 3; beats++ {
+		batch := receiveTimeout(t, out)
+		require.Len(t, batch, 1)
+		hb := batch[0]
+		require.True(t, hb.IsHeartbeat())
+		assert.Equal(t, agentIP, hb.AgentIP)
+		assert.Equal(t, "cluster", hb.ClusterID)
+		assert.Equal(t, "tenant", hb.TenantID)
+		assert.Zero(t, hb.Id)
+		assert.Zero(t, hb.Metrics)
+	}
+	elapsed := time.Since(start)
+	assert.GreaterOrEqual(t, elapsed, 3*interval)
+	assert.Less(t, elapsed, 10*interval)
+
+	// WHEN flows are received
+	record := &Record{Interface: "eth0"}
+	in <- []*Record{record}
+	// THEN they are forwarded, and they aren't heartbeats
+	for {
+		batch := receiveTimeout(t, out)
+		if batch[0].IsHeartbeat() {
+			continue
+		}
+		assert.Equal(t, []*Record{record}, batch)
+		assert.False(t, record.IsHeartbeat())
+		break
+	}
+	close(in)
+}
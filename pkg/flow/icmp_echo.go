@@ -0,0 +1,180 @@
+package flow
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// ICMP echo types, according to RFC 792 and RFC 4443
+const (
+	icmpEchoReply     = 0
+	icmpEchoRequest   = 8
+	icmpv6EchoRequest = 128
+	icmpv6EchoReply   = 129
+)
+
+// icmpEchoKey identifies the echo request and reply flows between the same pair of hosts, as
+// observed from the same interface
+type icmpEchoKey struct {
+	requester IPAddr
+	responder IPAddr
+	ifIndex   uint32
+}
+
+type pendingEcho struct {
+	record  *Record
+	request bool
+	since   time.Time
+}
+
+// ICMPEchoMerger merges the ICMP echo request flows with the echo reply flows answering them
+// into a single bidirectional record, which keeps the identifier of the request flow and
+// accounts the packets and bytes of both directions. The reply packets and bytes, and the round
+// trip time, are reported in the EchoReplyPackets, EchoReplyBytes and EchoRTT fields.
+// The eBPF flow key doesn't include the echo identifier, so all the echo requests from a host
+// to another, in the same interface, are aggregated in the same flow and paired as a whole.
+// The request and reply flows that aren't paired within the timeout are forwarded
+// unidirectionally.
+type ICMPEchoMerger struct {
+	timeout       time.Duration
+	clock         func() time.Time
+	pending       map[icmpEchoKey]*pendingEcho
+	mergedCounter prometheus.Counter
+}
+
+func NewICMPEchoMerger(timeout time.Duration, clock func() time.Time, m *metrics.Metrics) *ICMPEchoMerger {
+	return &ICMPEchoMerger{
+		timeout: timeout,
+		clock:   clock,
+		pending: map[icmpEchoKey]*pendingEcho{},
+		mergedCounter: m.NewCounter("icmp_echo_merged_flows_total",
+			"Number of ICMP echo request flows that have been merged with their reply flows"),
+	}
+}
+
+// Merge forwards the flows from the input to the output channel, holding the ICMP echo flows
+// until their counterpart is received or the timeout expires
+func (em *ICMPEchoMerger) Merge(in <-chan []*Record, out chan<- []*Record) {
+	ticker := time.NewTicker(em.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case records, ok := <-in:
+			if !ok {
+				if expired := em.expire(time.Time{}); len(expired) > 0 {
+					out <- expired
+				}
+				return
+			}
+			if fwd := em.pair(records); len(fwd) > 0 {
+				out <- fwd
+			}
+		case <-ticker.C:
+			if expired := em.expire(em.clock().Add(-em.timeout)); len(expired) > 0 {
+				out <- expired
+			}
+		}
+	}
+}
+
+// pair returns the non-echo flows and the echo flows whose counterpart has been found, keeping
+// the rest as pending
+func (em *ICMPEchoMerger) pair(records []*Record) []*Record {
+	fwd := make([]*Record, 0, len(records))
+	for _, record := range records {
+		request, ok := icmpEchoKind(record)
+		if !ok {
+			fwd = append(fwd, record)
+			continue
+		}
+		key := icmpEchoKey{
+			requester: record.Id.SrcIp, responder: record.Id.DstIp, ifIndex: record.Id.IfIndex,
+		}
+		if !request {
+			key.requester, key.responder = record.Id.DstIp, record.Id.SrcIp
+		}
+		if pending, ok := em.pending[key]; ok {
+			if pending.request != request {
+				delete(em.pending, key)
+				if request {
+					fwd = append(fwd, mergeEcho(record, pending.record))
+				} else {
+					fwd = append(fwd, mergeEcho(pending.record, record))
+				}
+				em.mergedCounter.Inc()
+				continue
+			}
+			// a newer flow in the same direction replaces the pending one, which is forwarded
+			// unidirectionally
+			fwd = append(fwd, pending.record)
+		}
+		em.pending[key] = &pendingEcho{record: record, request: request, since: em.clock()}
+	}
+	return fwd
+}
+
+// expire removes and returns the pending flows that have been held since before the provided
+// time. All the pending flows are returned for a zero time.
+func (em *ICMPEchoMerger) expire(before time.Time) []*Record {
+	var expired []*Record
+	for key, pending := range em.pending {
+		if before.IsZero() || pending.since.Before(before) {
+			expired = append(expired, pending.record)
+			delete(em.pending, key)
+		}
+	}
+	return expired
+}
+
+// icmpEchoKind returns whether the record is an ICMP echo request or reply flow, and which one
+func icmpEchoKind(record *Record) (request bool, ok bool) {
+	if record.Id.IcmpCode != 0 {
+		return false, false
+	}
+	switch record.Id.TransportProtocol {
+	case syscall.IPPROTO_ICMP:
+		switch record.Id.IcmpType {
+		case icmpEchoRequest:
+			return true, true
+		case icmpEchoReply:
+			return false, true
+		}
+	case syscall.IPPROTO_ICMPV6:
+		switch record.Id.IcmpType {
+		case icmpv6EchoRequest:
+			return true, true
+		case icmpv6EchoReply:
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// mergeEcho accounts the reply flow into the request flow. The round trip time is the time
+// between the first request packet and the first reply packet, as timestamped by the eBPF probes.
+func mergeEcho(request, reply *Record) *Record {
+	request.EchoReplyPackets = reply.Metrics.Packets
+	request.EchoReplyBytes = reply.Metrics.Bytes
+	if reply.Metrics.StartMonoTimeTs > request.Metrics.StartMonoTimeTs {
+		request.EchoRTT = time.Duration(reply.Metrics.StartMonoTimeTs - request.Metrics.StartMonoTimeTs)
+	}
+	request.Metrics.Packets += reply.Metrics.Packets
+	request.Metrics.Bytes += reply.Metrics.Bytes
+	if reply.Metrics.StartMonoTimeTs < request.Metrics.StartMonoTimeTs {
+		request.Metrics.StartMonoTimeTs = reply.Metrics.StartMonoTimeTs
+	}
+	if reply.Metrics.EndMonoTimeTs > request.Metrics.EndMonoTimeTs {
+		request.Metrics.EndMonoTimeTs = reply.Metrics.EndMonoTimeTs
+	}
+	if reply.TimeFlowStart.Before(request.TimeFlowStart) {
+		request.TimeFlowStart = reply.TimeFlowStart
+	}
+	if reply.TimeFlowEnd.After(request.TimeFlowEnd) {
+		request.TimeFlowEnd = reply.TimeFlowEnd
+	}
+	return request
+}
@@ -0,0 +1,96 @@
+package flow
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func echoFlow(src, dst string, icmpType uint8, startNs, endNs uint64, packets uint32) *Record {
+	r := &Record{RawRecord: RawRecord{
+		Id: ebpf.BpfFlowId{TransportProtocol: syscall.IPPROTO_ICMP, IcmpType: icmpType, IfIndex: 3},
+		Metrics: ebpf.BpfFlowMetrics{
+			Packets: packets, Bytes: uint64(packets) * 84,
+			StartMonoTimeTs: startNs, EndMonoTimeTs: endNs,
+		},
+	}}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestICMPEchoMerger_Pair(t *testing.T) {
+	m := metrics.NoOp()
+	merger := NewICMPEchoMerger(time.Hour, time.Now, m)
+	in := make(chan []*Record, 2)
+	out := make(chan []*Record, 2)
+	go merger.Merge(in, out)
+	defer close(in)
+
+	// GIVEN the echo reply flow and a non-echo flow
+	udp := &Record{RawRecord: RawRecord{Id: ebpf.BpfFlowId{TransportProtocol: syscall.IPPROTO_UDP}}}
+	in <- []*Record{
+		echoFlow("10.0.0.2", "10.0.0.1", icmpEchoReply, 1_500_000, 3_500_000, 3),
+		udp,
+	}
+	// THEN the non-echo flow is forwarded while the reply waits for its request
+	assert.Equal(t, []*Record{udp}, receiveTimeout(t, out))
+
+	// WHEN the echo request flow is received
+	in <- []*Record{echoFlow("10.0.0.1", "10.0.0.2", icmpEchoRequest, 1_000_000, 3_000_000, 3)}
+
+	// THEN both are merged into a bidirectional record with the round trip time
+	merged := receiveTimeout(t, out)
+	require.Len(t, merged, 1)
+	assert.Equal(t, "10.0.0.1", IP(merged[0].Id.SrcIp).String())
+	assert.EqualValues(t, icmpEchoRequest, merged[0].Id.IcmpType)
+	assert.Equal(t, 500*time.Microsecond, merged[0].EchoRTT)
+	assert.EqualValues(t, 6, merged[0].Metrics.Packets)
+	assert.EqualValues(t, 6*84, merged[0].Metrics.Bytes)
+	assert.EqualValues(t, 3, merged[0].EchoReplyPackets)
+	assert.EqualValues(t, 3*84, merged[0].EchoReplyBytes)
+	assert.EqualValues(t, 1_000_000, merged[0].Metrics.StartMonoTimeTs)
+	assert.EqualValues(t, 3_500_000, merged[0].Metrics.EndMonoTimeTs)
+	assert.EqualValues(t, 1, counterValue(t, m, "icmp_echo_merged_flows_total"))
+}
+
+func TestICMPEchoMerger_UnmatchedTimeout(t *testing.T) {
+	now := time.Now()
+	merger := NewICMPEchoMerger(50*time.Millisecond, func() time.Time { return now }, metrics.NoOp())
+
+	// the echo flows without counterpart are held while the timeout lasts
+	fwd := merger.pair([]*Record{
+		echoFlow("10.0.0.1", "10.0.0.2", icmpEchoRequest, 1_000, 2_000, 2),
+		echoFlow("10.0.0.1", "10.0.0.3", icmpEchoRequest, 1_000, 2_000, 2),
+		echoFlow("10.0.0.4", "10.0.0.1", icmpEchoReply, 1_000, 2_000, 2),
+	})
+	assert.Empty(t, fwd)
+	assert.Empty(t, merger.expire(now.Add(-50*time.Millisecond)))
+
+	// after the timeout, they are forwarded unidirectionally
+	now = now.Add(time.Second)
+	expired := merger.expire(now.Add(-50 * time.Millisecond))
+	require.Len(t, expired, 3)
+	for _, r := range expired {
+		assert.Zero(t, r.EchoRTT)
+		assert.EqualValues(t, 2, r.Metrics.Packets)
+	}
+	assert.Empty(t, merger.pending)
+}
+
+func TestICMPEchoMerger_FlushOnClose(t *testing.T) {
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go NewICMPEchoMerger(time.Hour, time.Now, metrics.NoOp()).Merge(in, out)
+
+	in <- []*Record{echoFlow("10.0.0.1", "10.0.0.2", icmpEchoRequest, 1_000, 2_000, 1)}
+	close(in)
+	assert.Len(t, receiveTimeout(t, out), 1)
+}
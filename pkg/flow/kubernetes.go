@@ -0,0 +1,175 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// EnricherKubernetes decorates the flows with the namespaces and names of the pods of their
+// source and destination addresses
+const EnricherKubernetes = "kubernetes"
+
+var k8slog = logrus.WithField("component", "flow.KubernetesResolver")
+
+func init() {
+	RegisterEnricher(EnricherKubernetes, func(ctx *EnricherContext) (Enricher, error) {
+		config, err := kubernetesConfig(ctx.Kubeconfig)
+		if err != nil {
+			return nil, err
+		}
+		client, err := kubernetes.NewForConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("creating Kubernetes client: %w", err)
+		}
+		return NewKubernetesResolver(cache.NewListWatchFromClient(client.CoreV1().RESTClient(),
+			"pods", metav1.NamespaceAll, fields.Everything())), nil
+	})
+}
+
+// kubernetesConfig returns the configuration from the kubeconfig file, if provided, or the
+// in-cluster configuration otherwise
+func kubernetesConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, fmt.Errorf("loading kubeconfig %s: %w", kubeconfig, err)
+		}
+		return config, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading Kubernetes in-cluster configuration: %w", err)
+	}
+	return config, nil
+}
+
+type podMeta struct {
+	namespace string
+	name      string
+}
+
+// KubernetesResolver enricher sets the SrcNamespace, SrcPod, DstNamespace and DstPod fields of
+// the flows with the pods that own their source and destination addresses. The pods of the whole
+// cluster are watched, so the flows towards remote pods are also decorated. The host-network pods
+// are ignored, as their addresses are shared with the node and the rest of its host-network pods,
+// as well as the terminated pods, whose addresses can be reused. The fields are left empty until
+// the pods are listed, and for the addresses that don't belong to a pod.
+type KubernetesResolver struct {
+	mt   sync.RWMutex
+	pods map[IPAddr]podMeta
+	// addresses of each pod, to remove them when the pod changes or is deleted
+	podIPs map[podMeta][]IPAddr
+}
+
+// NewKubernetesResolver creates a KubernetesResolver and starts watching the pods from the
+// provided source
+func NewKubernetesResolver(pods cache.ListerWatcher) *KubernetesResolver {
+	kr := &KubernetesResolver{
+		pods:   map[IPAddr]podMeta{},
+		podIPs: map[podMeta][]IPAddr{},
+	}
+	informer := cache.NewSharedIndexInformer(pods, &v1.Pod{}, 0, cache.Indexers{})
+	// only the fields used by the resolver are kept in the informer cache
+	if err := informer.SetTransform(trimPod); err != nil {
+		k8slog.WithError(err).Warn("can't trim the cached pods")
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { kr.onUpdate(obj) },
+		UpdateFunc: func(_, obj interface{}) { kr.onUpdate(obj) },
+		DeleteFunc: kr.onDelete,
+	})
+	go informer.Run(wait.NeverStop)
+	return kr
+}
+
+func trimPod(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: pod.Name, Namespace: pod.Namespace, ResourceVersion: pod.ResourceVersion,
+		},
+		Spec:   v1.PodSpec{HostNetwork: pod.Spec.HostNetwork},
+		Status: v1.PodStatus{Phase: pod.Status.Phase, PodIPs: pod.Status.PodIPs},
+	}, nil
+}
+
+func (kr *KubernetesResolver) Enrich(record *Record) {
+	kr.mt.RLock()
+	src, srcOk := kr.pods[record.Id.SrcIp]
+	dst, dstOk := kr.pods[record.Id.DstIp]
+	kr.mt.RUnlock()
+	if srcOk {
+		record.SrcNamespace = src.namespace
+		record.SrcPod = src.name
+	}
+	if dstOk {
+		record.DstNamespace = dst.namespace
+		record.DstPod = dst.name
+	}
+}
+
+func (kr *KubernetesResolver) onUpdate(obj interface{}) {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	meta := podMeta{namespace: pod.Namespace, name: pod.Name}
+	kr.mt.Lock()
+	defer kr.mt.Unlock()
+	kr.remove(meta)
+	if pod.Spec.HostNetwork ||
+		pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return
+	}
+	var addrs []IPAddr
+	for _, podIP := range pod.Status.PodIPs {
+		ip := net.ParseIP(podIP.IP)
+		if ip == nil {
+			continue
+		}
+		var addr IPAddr
+		copy(addr[:], ip.To16())
+		kr.pods[addr] = meta
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) > 0 {
+		kr.podIPs[meta] = addrs
+	}
+}
+
+func (kr *KubernetesResolver) onDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return
+	}
+	kr.mt.Lock()
+	kr.remove(podMeta{namespace: pod.Namespace, name: pod.Name})
+	kr.mt.Unlock()
+}
+
+// remove forgets the addresses of a pod, unless they have been already taken by another pod
+func (kr *KubernetesResolver) remove(meta podMeta) {
+	for _, addr := range kr.podIPs[meta] {
+		if kr.pods[addr] == meta {
+			delete(kr.pods, addr)
+		}
+	}
+	delete(kr.podIPs, meta)
+}
@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func testPod(namespace, name string, hostNetwork bool, ips ...string) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec:       v1.PodSpec{HostNetwork: hostNetwork},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	for _, ip := range ips {
+		pod.Status.PodIPs = append(pod.Status.PodIPs, v1.PodIP{IP: ip})
+	}
+	return pod
+}
+
+func podFlow(src, dst string) *Record {
+	r := &Record{}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestKubernetesResolver(t *testing.T) {
+	// GIVEN a cluster with a dual-stack pod and a host-network pod
+	watcher := watch.NewFake()
+	kr := NewKubernetesResolver(&cache.ListWatch{
+		ListFunc: func(_ metav1.ListOptions) (runtime.Object, error) {
+			return &v1.PodList{Items: []v1.Pod{
+				*testPod("web", "server", false, "10.128.0.5", "fd00::5"),
+				*testPod("kube-system", "proxy", true, "192.168.0.10"),
+			}}, nil
+		},
+		WatchFunc: func(_ metav1.ListOptions) (watch.Interface, error) {
+			return watcher, nil
+		},
+	})
+
+	// WHEN the flows between the pods are enriched, once the pods are listed
+	v4 := podFlow("10.128.0.5", "192.168.0.10")
+	v6 := podFlow("fd00::7", "fd00::5")
+	require.Eventually(t, func() bool {
+		kr.Enrich(v4)
+		return v4.SrcPod != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	kr.Enrich(v6)
+
+	// THEN the addresses of the pods are attributed to them
+	assert.Equal(t, "web", v4.SrcNamespace)
+	assert.Equal(t, "server", v4.SrcPod)
+	assert.Equal(t, "web", v6.DstNamespace)
+	assert.Equal(t, "server", v6.DstPod)
+	// AND the host-network pods and the unknown addresses are left empty
+	assert.Empty(t, v4.DstNamespace)
+	assert.Empty(t, v4.DstPod)
+	assert.Empty(t, v6.SrcNamespace)
+	assert.Empty(t, v6.SrcPod)
+
+	// AND WHEN a new pod takes an address, and the previous pod is deleted
+	watcher.Add(testPod("db", "postgres", false, "fd00::7"))
+	watcher.Delete(testPod("web", "server", false, "10.128.0.5", "fd00::5"))
+
+	// THEN the flows are attributed to the current pods
+	require.Eventually(t, func() bool {
+		v6 = podFlow("fd00::7", "fd00::5")
+		kr.Enrich(v6)
+		return v6.SrcPod == "postgres" && v6.DstPod == ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "db", v6.SrcNamespace)
+	assert.Empty(t, v6.DstNamespace)
+}
+
+func TestKubernetesResolver_TerminatedPods(t *testing.T) {
+	kr := &KubernetesResolver{pods: map[IPAddr]podMeta{}, podIPs: map[podMeta][]IPAddr{}}
+
+	// GIVEN a pod whose address is reused by a new pod after it completes
+	completed := testPod("batch", "job-1", false, "10.128.0.9")
+	kr.onUpdate(completed)
+	completed.Status.Phase = v1.PodSucceeded
+	kr.onUpdate(completed)
+	kr.onUpdate(testPod("batch", "job-2", false, "10.128.0.9"))
+
+	// WHEN the completed pod is deleted later
+	kr.onDelete(cache.DeletedFinalStateUnknown{Obj: completed})
+
+	// THEN the address is still attributed to the new pod
+	flow := podFlow("10.128.0.9", "10.0.0.1")
+	kr.Enrich(flow)
+	assert.Equal(t, "job-2", flow.SrcPod)
+}
@@ -1,6 +1,8 @@
 package flow
 
 import (
+	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -11,39 +13,80 @@ const maxLogPeriod = time.Hour
 
 var cllog = logrus.WithField("component", "capacity.Limiter")
 
+// defaultDropReason explains the dropped flows of the CapacityLimiter in the agent pipeline
+const defaultDropReason = "the agent is forwarding more flows than the remote ingestor is able " +
+	"to process. You might want to increase the CACHE_MAX_FLOWS and CACHE_ACTIVE_TIMEOUT property"
+
 // CapacityLimiter forwards the flows between two nodes but checks the status of the destination
 // node's buffered channel. If it is already full, it drops the incoming flow and periodically will
-// log a message about the number of lost flows.
+// log a message about the number of lost flows. The zero value logs the message of the agent
+// pipeline until the input channel is closed.
 type CapacityLimiter struct {
+	// totalDropped and occupancy are atomically accessed, as they are read by the
+	// PressureMonitor. totalDropped is the first field to keep 64-bit alignment on 32-bit archs
+	totalDropped uint64
+	// occupancy of the destination node's buffer, in percent
+	occupancy    uint32
 	droppedFlows int
+	ctx          context.Context
+	dropReason   string
+}
+
+// NewCapacityLimiter creates a CapacityLimiter whose dropped flows are logged, explained by the
+// provided reason, until the context is done or the input channel is closed
+func NewCapacityLimiter(ctx context.Context, dropReason string) *CapacityLimiter {
+	return &CapacityLimiter{ctx: ctx, dropReason: dropReason}
 }
 
 func (c *CapacityLimiter) Limit(in <-chan []*Record, out chan<- []*Record) {
-	go c.logDroppedFlows()
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go c.logDroppedFlows(ctx)
 	for i := range in {
+		if cap(out) > 0 {
+			atomic.StoreUint32(&c.occupancy, uint32(100*len(out)/cap(out)))
+		}
 		if len(out) < cap(out) || cap(out) == 0 {
 			out <- i
 		} else {
 			c.droppedFlows += len(i)
+			atomic.AddUint64(&c.totalDropped, uint64(len(i)))
 		}
 	}
 }
 
-func (c *CapacityLimiter) logDroppedFlows() {
+// Pressure returns the total number of flows that have been dropped since the limiter started,
+// and the occupancy of the destination node's buffer (from 0 to 1) when the last flows were
+// forwarded
+func (c *CapacityLimiter) Pressure() (dropped uint64, occupancy float64) {
+	return atomic.LoadUint64(&c.totalDropped), float64(atomic.LoadUint32(&c.occupancy)) / 100
+}
+
+func (c *CapacityLimiter) logDroppedFlows(ctx context.Context) {
+	dropReason := c.dropReason
+	if dropReason == "" {
+		dropReason = defaultDropReason
+	}
 	logPeriod := initialLogPeriod
 	debugging := logrus.IsLevelEnabled(logrus.DebugLevel)
 	for {
-		time.Sleep(logPeriod)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(logPeriod):
+		}
 
 		// a race condition might happen in this counter but it's not important as it's just for
 		// logging purposes
 		df := c.droppedFlows
 		if df > 0 {
 			c.droppedFlows = 0
-			cllog.Warnf("%d flows were dropped during the last %s because the agent is forwarding "+
-				"more flows than the remote ingestor is able to process. You might "+
-				"want to increase the CACHE_MAX_FLOWS and CACHE_ACTIVE_TIMEOUT property",
-				df, logPeriod)
+			cllog.Warnf("%d flows were dropped during the last %s because %s",
+				df, logPeriod, dropReason)
 
 			// if not debug logs, backoff to avoid flooding the log with warning messages
 			if !debugging && logPeriod < maxLogPeriod {
@@ -0,0 +1,192 @@
+package flow
+
+import (
+	"net"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// EnricherNextHop decorates the flows with the next hop (gateway) of the local route towards
+// their destination
+const EnricherNextHop = "nextHop"
+
+// maximum number of destinations whose next hop is cached between two route changes
+const nextHopCacheSize = 10000
+
+var nhlog = logrus.WithField("component", "flow.NextHopResolver")
+
+func init() {
+	RegisterEnricher(EnricherNextHop, func(_ *EnricherContext) (Enricher, error) {
+		return NewNextHopResolver(), nil
+	})
+}
+
+// NextHopResolver enricher sets the NextHop field of the flows with the gateway of the route
+// that the local routing table (main table) selects for their destination address, by longest
+// prefix match and, for the same prefix, lowest metric. For multipath routes, the gateway of
+// the first path is set. NextHop is left empty if no route matches the destination, or if the
+// destination is directly reachable (the route has no gateway). The routing table is cached and
+// reloaded whenever the kernel notifies a route change.
+type NextHopResolver struct {
+	listRoutes func(family int) ([]netlink.Route, error)
+	mt         sync.RWMutex
+	table      *routeTable
+	// next hops of the recent destinations. Only accessed from the Enrich method
+	cache        map[IPAddr]net.IP
+	cachedTable  *routeTable
+	routeUpdates chan netlink.RouteUpdate
+}
+
+// routeTable indexes the gateways by prefix length and masked destination. IPv4 routes are
+// stored as IPv4-mapped IPv6 prefixes, as the flow addresses are.
+type routeTable struct {
+	// prefix lengths in the table, from the longest to the shortest
+	lengths []int
+	routes  map[int]map[IPAddr]routeEntry
+}
+
+type routeEntry struct {
+	gateway  net.IP
+	priority int
+}
+
+// NewNextHopResolver creates a NextHopResolver that reads the routing table of the host, and
+// subscribes to its changes through netlink
+func NewNextHopResolver() *NextHopResolver {
+	return newNextHopResolver(func(family int) ([]netlink.Route, error) {
+		return netlink.RouteList(nil, family)
+	}, netlink.RouteSubscribe)
+}
+
+func newNextHopResolver(
+	listRoutes func(family int) ([]netlink.Route, error),
+	subscribe func(ch chan<- netlink.RouteUpdate, done <-chan struct{}) error,
+) *NextHopResolver {
+	nh := &NextHopResolver{
+		listRoutes:   listRoutes,
+		table:        &routeTable{},
+		routeUpdates: make(chan netlink.RouteUpdate, 100),
+	}
+	// the subscription goes first, so no change is missed between the first load and the
+	// subscription
+	if err := subscribe(nh.routeUpdates, nil); err != nil {
+		nhlog.WithError(err).Warn("can't subscribe to route changes. The routes won't be refreshed")
+	} else {
+		go nh.watch()
+	}
+	nh.reload()
+	return nh
+}
+
+func (nh *NextHopResolver) Enrich(record *Record) {
+	nh.mt.RLock()
+	table := nh.table
+	nh.mt.RUnlock()
+	if table != nh.cachedTable || len(nh.cache) >= nextHopCacheSize {
+		nh.cache = map[IPAddr]net.IP{}
+		nh.cachedTable = table
+	}
+	gateway, ok := nh.cache[record.Id.DstIp]
+	if !ok {
+		gateway = table.lookup(record.Id.DstIp)
+		nh.cache[record.Id.DstIp] = gateway
+	}
+	record.NextHop = gateway
+}
+
+// watch reloads the routing table after each batch of route changes
+func (nh *NextHopResolver) watch() {
+	for range nh.routeUpdates {
+		// a route change usually comes along with others (e.g. when an interface goes down), so
+		// they are all applied with a single reload
+		for pending := len(nh.routeUpdates); pending > 0; pending-- {
+			<-nh.routeUpdates
+		}
+		nh.reload()
+	}
+}
+
+// reload replaces the routing table. If it can't be read, the previous table is kept
+func (nh *NextHopResolver) reload() {
+	table := &routeTable{routes: map[int]map[IPAddr]routeEntry{}}
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		routes, err := nh.listRoutes(family)
+		if err != nil {
+			nhlog.WithError(err).WithField("family", family).
+				Warn("can't read the routing table. Keeping the previous routes")
+			return
+		}
+		for i := range routes {
+			table.add(family, &routes[i])
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(table.lengths)))
+	nh.mt.Lock()
+	nh.table = table
+	nh.mt.Unlock()
+}
+
+func (rt *routeTable) add(family int, route *netlink.Route) {
+	var addr IPAddr
+	ones := 0
+	if route.Dst != nil {
+		copy(addr[:], route.Dst.IP.To16())
+		ones, _ = route.Dst.Mask.Size()
+	}
+	if family == netlink.FAMILY_V4 {
+		if route.Dst == nil {
+			copy(addr[:], net.IPv4zero.To16())
+		}
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	addr = maskIPAddr(addr, ones)
+	entry := routeEntry{gateway: route.Gw, priority: route.Priority}
+	if entry.gateway == nil && len(route.MultiPath) > 0 {
+		entry.gateway = route.MultiPath[0].Gw
+	}
+	byDst, ok := rt.routes[ones]
+	if !ok {
+		byDst = map[IPAddr]routeEntry{}
+		rt.routes[ones] = byDst
+		rt.lengths = append(rt.lengths, ones)
+	}
+	if prev, ok := byDst[addr]; ok && prev.priority <= entry.priority {
+		return
+	}
+	byDst[addr] = entry
+}
+
+// lookup returns the gateway of the longest prefix that contains the address, or nil if none
+func (rt *routeTable) lookup(addr IPAddr) net.IP {
+	// the IPv6 routes with shorter prefixes than IPv4-mapped addresses (e.g. the default route)
+	// must not match IPv4 destinations
+	minLength := 0
+	if IP(addr).To4() != nil {
+		minLength = 8 * (net.IPv6len - net.IPv4len)
+	}
+	for _, ones := range rt.lengths {
+		if ones < minLength {
+			break
+		}
+		if entry, ok := rt.routes[ones][maskIPAddr(addr, ones)]; ok {
+			return entry.gateway
+		}
+	}
+	return nil
+}
+
+// maskIPAddr returns the address with all the bits beyond the prefix length set to zero
+func maskIPAddr(addr IPAddr, ones int) IPAddr {
+	for i := range addr {
+		switch bits := ones - 8*i; {
+		case bits <= 0:
+			addr[i] = 0
+		case bits < 8:
+			addr[i] &= ^byte(0xff >> bits)
+		}
+	}
+	return addr
+}
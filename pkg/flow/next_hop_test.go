@@ -0,0 +1,133 @@
+package flow
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeRoutes stubs the routing table of the host
+type fakeRoutes struct {
+	mt      sync.Mutex
+	byFam   map[int][]netlink.Route
+	updates chan<- netlink.RouteUpdate
+}
+
+func (fr *fakeRoutes) list(family int) ([]netlink.Route, error) {
+	fr.mt.Lock()
+	defer fr.mt.Unlock()
+	return fr.byFam[family], nil
+}
+
+func (fr *fakeRoutes) subscribe(ch chan<- netlink.RouteUpdate, _ <-chan struct{}) error {
+	fr.updates = ch
+	return nil
+}
+
+func (fr *fakeRoutes) set(family int, routes ...netlink.Route) {
+	fr.mt.Lock()
+	fr.byFam[family] = routes
+	fr.mt.Unlock()
+	fr.updates <- netlink.RouteUpdate{}
+}
+
+func route(dst, gw string, priority int) netlink.Route {
+	r := netlink.Route{Priority: priority}
+	if dst != "" {
+		_, r.Dst, _ = net.ParseCIDR(dst)
+	}
+	if gw != "" {
+		r.Gw = net.ParseIP(gw)
+	}
+	return r
+}
+
+func nextHopOf(nh *NextHopResolver, dst string) string {
+	r := subnetLabelsRecord("10.0.0.2", dst)
+	nh.Enrich(r)
+	if r.NextHop == nil {
+		return ""
+	}
+	return r.NextHop.String()
+}
+
+func TestNextHopResolver_LongestPrefix(t *testing.T) {
+	routes := &fakeRoutes{byFam: map[int][]netlink.Route{
+		netlink.FAMILY_V4: {
+			route("", "10.0.0.1", 100),
+			route("192.168.0.0/16", "10.0.0.253", 0),
+			route("192.168.1.0/24", "10.0.0.254", 0),
+			// the route with the lowest metric is preferred
+			route("172.16.0.0/12", "10.0.0.100", 200),
+			route("172.16.0.0/12", "10.0.0.99", 50),
+			// directly connected
+			route("10.0.0.0/24", "", 0),
+		},
+		netlink.FAMILY_V6: {
+			route("", "fe80::1", 0),
+			route("2001:db8::/32", "fe80::2", 0),
+			{Dst: &net.IPNet{IP: net.ParseIP("2001:db9::"), Mask: net.CIDRMask(32, 128)},
+				MultiPath: []*netlink.NexthopInfo{{Gw: net.ParseIP("fe80::3")}, {Gw: net.ParseIP("fe80::4")}}},
+		},
+	}}
+	nh := newNextHopResolver(routes.list, routes.subscribe)
+
+	assert.Equal(t, "10.0.0.1", nextHopOf(nh, "8.8.8.8"))
+	assert.Equal(t, "10.0.0.253", nextHopOf(nh, "192.168.2.1"))
+	assert.Equal(t, "10.0.0.254", nextHopOf(nh, "192.168.1.77"))
+	assert.Equal(t, "10.0.0.99", nextHopOf(nh, "172.20.1.1"))
+	assert.Empty(t, nextHopOf(nh, "10.0.0.7"))
+	assert.Equal(t, "fe80::1", nextHopOf(nh, "2001:db7::1"))
+	assert.Equal(t, "fe80::2", nextHopOf(nh, "2001:db8::1"))
+	assert.Equal(t, "fe80::3", nextHopOf(nh, "2001:db9::1"))
+}
+
+func TestNextHopResolver_NoRoute(t *testing.T) {
+	routes := &fakeRoutes{byFam: map[int][]netlink.Route{
+		netlink.FAMILY_V4: {route("192.168.0.0/16", "10.0.0.253", 0)},
+		// the IPv6 default route must not match the IPv4 destinations
+		netlink.FAMILY_V6: {route("", "fe80::1", 0)},
+	}}
+	nh := newNextHopResolver(routes.list, routes.subscribe)
+
+	assert.Empty(t, nextHopOf(nh, "8.8.8.8"))
+	assert.Equal(t, "10.0.0.253", nextHopOf(nh, "192.168.0.1"))
+}
+
+func TestNextHopResolver_RouteChanges(t *testing.T) {
+	routes := &fakeRoutes{byFam: map[int][]netlink.Route{
+		netlink.FAMILY_V4: {route("", "10.0.0.1", 0)},
+	}}
+	nh := newNextHopResolver(routes.list, routes.subscribe)
+	assert.Equal(t, "10.0.0.1", nextHopOf(nh, "8.8.8.8"))
+
+	// WHEN the default gateway changes
+	routes.set(netlink.FAMILY_V4, route("", "10.0.0.2", 0))
+
+	// THEN the cached next hops are refreshed
+	assert.Eventually(t, func() bool {
+		return nextHopOf(nh, "8.8.8.8") == "10.0.0.2"
+	}, timeout, 10*time.Millisecond)
+}
+
+func TestNextHopResolver_KeepRoutesOnError(t *testing.T) {
+	fail := false
+	routes := &fakeRoutes{byFam: map[int][]netlink.Route{
+		netlink.FAMILY_V4: {route("", "10.0.0.1", 0)},
+	}}
+	nh := newNextHopResolver(func(family int) ([]netlink.Route, error) {
+		if fail {
+			return nil, errors.New("netlink error")
+		}
+		return routes.list(family)
+	}, routes.subscribe)
+
+	fail = true
+	nh.reload()
+	assert.Equal(t, "10.0.0.1", nextHopOf(nh, "8.8.8.8"))
+}
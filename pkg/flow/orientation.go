@@ -0,0 +1,103 @@
+package flow
+
+import (
+	"bytes"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+// NormalizeOrientation canonicalizes the orientation of the flow identifier, so the endpoint
+// with the lower IP address (or the lower port, if both IPs are equal) is always the source.
+// Then the two directions of a connection (A->B and B->A) share the same source and destination
+// addresses, ports and MACs. It returns whether the flow identifier has been reversed.
+func NormalizeOrientation(id *ebpf.BpfFlowId) bool {
+	cmp := bytes.Compare(id.SrcIp[:], id.DstIp[:])
+	if cmp < 0 || (cmp == 0 && id.SrcPort <= id.DstPort) {
+		return false
+	}
+	id.SrcIp, id.DstIp = id.DstIp, id.SrcIp
+	id.SrcPort, id.DstPort = id.DstPort, id.SrcPort
+	id.SrcMac, id.DstMac = id.DstMac, id.SrcMac
+	return true
+}
+
+// Normalize receives flows and canonicalizes their orientation, according to
+// NormalizeOrientation. Then the flows of each batch that share the same canonical identifier
+// (e.g. the two directions of a connection, as observed from the same interface) are merged in a
+// single record, before they are forwarded to the deduplication and export stages.
+// The fields that describe the orientation of the packets are kept consistent with the canonical
+// source and destination: the direction of the reversed flows is flipped (e.g. the ingress packets
+// from B to A are the egress side of A to B). The handshake flags of the reversed flows are
+// flipped too (the SYN-ACK from B is reported as if A sent SYN), so the client of the connection
+// is still told apart, unless the flow is merged with its opposite direction: then the record
+// carries the flags of both directions, as they are.
+func Normalize(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		out <- normalizeBatch(records)
+	}
+}
+
+// orientedFlags accumulates the TCP flags of the flows that are merged in a normalized record,
+// by their original orientation
+type orientedFlags struct {
+	record                  *Record
+	forward, reversed       uint16
+	hasForward, hasReversed bool
+}
+
+func normalizeBatch(records []*Record) []*Record {
+	merged := make([]*Record, 0, len(records))
+	byKey := make(map[ebpf.BpfFlowId]*orientedFlags, len(records))
+	for _, record := range records {
+		flags := record.Metrics.Flags
+		reversed := NormalizeOrientation(&record.Id)
+		if reversed {
+			record.Id.Direction = reverseDirection(record.Id.Direction)
+		}
+		group, ok := byKey[record.Id]
+		if ok {
+			mergeRecord(group.record, record)
+		} else {
+			group = &orientedFlags{record: record}
+			byKey[record.Id] = group
+			merged = append(merged, record)
+		}
+		if reversed {
+			group.reversed |= flags
+			group.hasReversed = true
+		} else {
+			group.forward |= flags
+			group.hasForward = true
+		}
+	}
+	for _, group := range byKey {
+		if group.hasReversed && !group.hasForward {
+			group.record.Metrics.Flags = reverseHandshakeFlags(group.reversed)
+		}
+	}
+	return merged
+}
+
+func reverseDirection(direction uint8) uint8 {
+	switch direction {
+	case DirectionIngress:
+		return DirectionEgress
+	case DirectionEgress:
+		return DirectionIngress
+	default:
+		return direction
+	}
+}
+
+// reverseHandshakeFlags swaps the SYN and SYN-ACK flags, which tell which endpoint opened the
+// connection
+func reverseHandshakeFlags(flags uint16) uint16 {
+	reversed := flags &^ (TCPFlagSYN | TCPFlagSYNACK)
+	if flags&TCPFlagSYN != 0 {
+		reversed |= TCPFlagSYNACK
+	}
+	if flags&TCPFlagSYNACK != 0 {
+		reversed |= TCPFlagSYN
+	}
+	return reversed
+}
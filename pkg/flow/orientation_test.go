@@ -0,0 +1,103 @@
+package flow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestNormalizeOrientation(t *testing.T) {
+	ip := func(addr string) IPAddr {
+		var ia IPAddr
+		copy(ia[:], net.ParseIP(addr).To16())
+		return ia
+	}
+	for _, tc := range []struct {
+		name     string
+		src, dst IPAddr
+		srcPort  uint16
+		dstPort  uint16
+	}{
+		{name: "different IPs", src: ip("10.0.0.9"), dst: ip("10.0.0.1"), srcPort: 80, dstPort: 34567},
+		{name: "same IPs", src: ip("10.0.0.1"), dst: ip("10.0.0.1"), srcPort: 8080, dstPort: 443},
+		{name: "IPv6", src: ip("fd00::2"), dst: ip("fd00::1"), srcPort: 443, dstPort: 34567},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			forward := ebpf.BpfFlowId{
+				EthProtocol:       0x0800,
+				TransportProtocol: 6,
+				SrcIp:             tc.src,
+				DstIp:             tc.dst,
+				SrcPort:           tc.srcPort,
+				DstPort:           tc.dstPort,
+				SrcMac:            MacAddr{1, 1, 1, 1, 1, 1},
+				DstMac:            MacAddr{2, 2, 2, 2, 2, 2},
+				IfIndex:           3,
+				Direction:         DirectionEgress,
+			}
+			reverse := forward
+			reverse.SrcIp, reverse.DstIp = forward.DstIp, forward.SrcIp
+			reverse.SrcPort, reverse.DstPort = forward.DstPort, forward.SrcPort
+			reverse.SrcMac, reverse.DstMac = forward.DstMac, forward.SrcMac
+
+			// the reversed 5-tuples canonicalize identically
+			assert.True(t, NormalizeOrientation(&forward))
+			assert.False(t, NormalizeOrientation(&reverse))
+			assert.Equal(t, forward, reverse)
+
+			// the canonical orientation is stable
+			normalized := forward
+			assert.False(t, NormalizeOrientation(&normalized))
+			assert.Equal(t, forward, normalized)
+			assert.EqualValues(t, tc.dst, forward.SrcIp)
+			assert.Equal(t, tc.dstPort, forward.SrcPort)
+			assert.EqualValues(t, MacAddr{2, 2, 2, 2, 2, 2}, forward.SrcMac)
+			// the fields that aren't related to the orientation are kept
+			assert.EqualValues(t, 3, forward.IfIndex)
+			assert.Equal(t, DirectionEgress, forward.Direction)
+		})
+	}
+}
+
+func TestNormalize_MergesBothDirections(t *testing.T) {
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go Normalize(in, out)
+	defer close(in)
+
+	flowOf := func(src, dst string, srcPort, dstPort uint16, dir uint8, flags uint16) *Record {
+		r := scanFlow(src, dst, srcPort, dstPort, flags)
+		r.Id.IfIndex = 3
+		r.Id.Direction = dir
+		return r
+	}
+	// GIVEN both directions of a connection, as observed by the same interface
+	request := flowOf("10.0.0.1", "10.0.0.2", 34567, 443, DirectionEgress, TCPFlagSYN)
+	reply := flowOf("10.0.0.2", "10.0.0.1", 443, 34567, DirectionIngress, TCPFlagSYNACK)
+	// AND the reply of another connection, whose request wasn't observed
+	lonely := flowOf("10.0.0.9", "10.0.0.1", 80, 40000, DirectionIngress, TCPFlagSYNACK)
+
+	in <- []*Record{request, reply, lonely}
+	records := receiveTimeout(t, out)
+
+	// THEN both directions are merged in a single record, with the canonical orientation and
+	// the flags of both directions
+	require.Len(t, records, 2)
+	merged := records[0]
+	assert.Equal(t, "10.0.0.1", IP(merged.Id.SrcIp).String())
+	assert.EqualValues(t, 34567, merged.Id.SrcPort)
+	assert.Equal(t, DirectionEgress, merged.Id.Direction)
+	assert.EqualValues(t, 2, merged.Metrics.Packets)
+	assert.Equal(t, TCPFlagSYN|TCPFlagSYNACK, merged.Metrics.Flags)
+
+	// AND the reversed record flips its direction and handshake flags, so its new source is
+	// still reported as the client
+	assert.Equal(t, "10.0.0.1", IP(records[1].Id.SrcIp).String())
+	assert.EqualValues(t, 40000, records[1].Id.SrcPort)
+	assert.Equal(t, DirectionEgress, records[1].Id.Direction)
+	assert.Equal(t, TCPFlagSYN, records[1].Metrics.Flags)
+}
@@ -0,0 +1,222 @@
+package flow
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// portScanSource tracks the distinct destination ports that a source has hit during the current
+// window. Once the threshold is reached the ports are released, as only the verdict matters,
+// unless the observed ports are recorded.
+type portScanSource struct {
+	ports   map[uint16]struct{}
+	suspect bool
+	// flows counts the flows from the source that opened connections during the window
+	flows uint32
+	// observed is the sequence of distinct destination ports, in the order they were first hit,
+	// up to maxObservedPorts. overflow counts the distinct ports that didn't fit.
+	observed []uint16
+	overflow uint32
+}
+
+// PortScanDetector flags, as ScanSuspect, the flows from the source IPs that have hit at least
+// "threshold" distinct destination ports during the same window. Only the flows that open
+// connections are accounted: TCP flows with a SYN but no SYN-ACK, and UDP or SCTP flows, so the
+// replies of the scanned hosts, towards the ephemeral ports of the scanner, don't count. The
+// state is reset at the end of each window. The memory is bounded: each source keeps up to
+// threshold ports, and at most maxSources sources are tracked in a window. The flows from the
+// sources beyond that limit are never flagged.
+// If maxObservedPorts is higher than zero, the detector also records the sequence of distinct
+// destination ports hit by each source within the window (e.g. to spot the port-hopping malware),
+// up to maxObservedPorts. Then the ports of the suspect sources are kept until the end of the
+// window, to tell the distinct ones apart. At the end of each window, the detector emits, through
+// the Summaries stage, one aggregated record per source that carries its ObservedPorts, so the flows themselves aren't inflated with the ports of their source.
+type PortScanDetector struct {
+	threshold        int
+	window           time.Duration
+	maxSources       int
+	maxObservedPorts int
+	sources          map[IPAddr]*portScanSource
+	windowStart      time.Time
+	clock            func() time.Time
+	suspects         prometheus.Counter
+	untracked        prometheus.Counter
+	observedOverflow prometheus.Counter
+	// summaries forwards the per-source records of each window to the Summaries stage. It is
+	// unbuffered, so the records of the last window are handed over before Detect returns.
+	summaries chan []*Record
+}
+
+// NewPortScanDetector creates a PortScanDetector that flags the sources that hit threshold
+// distinct destination ports within a window, and records up to maxObservedPorts distinct
+// destination ports of each source. Zero maxObservedPorts disables the recording.
+func NewPortScanDetector(
+	threshold int, window time.Duration, maxSources, maxObservedPorts int,
+	clock func() time.Time, m *metrics.Metrics,
+) *PortScanDetector {
+	return &PortScanDetector{
+		threshold:        threshold,
+		window:           window,
+		maxSources:       maxSources,
+		maxObservedPorts: maxObservedPorts,
+		sources:          map[IPAddr]*portScanSource{},
+		windowStart:      clock(),
+		clock:            clock,
+		suspects: m.NewCounter("port_scan_suspects_total",
+			"Number of source IPs that have been flagged as potential port scanners in a window"),
+		untracked: m.NewCounter("port_scan_untracked_flows_total",
+			"Number of flows whose source IP couldn't be tracked by the port scan detector "+
+				"because the maximum number of sources was reached"),
+		observedOverflow: m.NewCounter("port_scan_observed_ports_overflow_total",
+			"Number of distinct destination ports that weren't recorded as observed ports "+
+				"because the maximum number of observed ports of the source was reached"),
+		summaries: make(chan []*Record),
+	}
+}
+
+// Detect accounts the destination ports of each batch of flows and sets ScanSuspect in the flows
+// from the suspect sources. The whole batch is accounted before flagging it, so all the flows of
+// a batch that makes a source reach the threshold are flagged. If the observed ports are
+// recorded, the per-source records of a window are handed over to the Summaries stage once the
+// first batch after the end of the window is received, or once the input is closed, so the
+// Summaries stage must be running.
+func (pd *PortScanDetector) Detect(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		if now := pd.clock(); now.Sub(pd.windowStart) >= pd.window {
+			pd.summarize(now)
+			pd.windowStart = now
+			pd.sources = map[IPAddr]*portScanSource{}
+		}
+		for _, record := range records {
+			if opensConnection(record) {
+				pd.account(record)
+			}
+		}
+		for _, record := range records {
+			if src, ok := pd.sources[record.Id.SrcIp]; ok && src.suspect {
+				record.ScanSuspect = true
+			}
+		}
+		out <- records
+	}
+	pd.summarize(pd.clock())
+}
+
+// Summaries forwards the flows and, at the end of each window, the per-source records of the
+// window in their own batch. It is meant to run after the flows processing stages, so the
+// per-source records are neither sampled, filtered nor aggregated as if they were flows.
+func (pd *PortScanDetector) Summaries(in <-chan []*Record, out chan<- []*Record) {
+	for {
+		select {
+		case records, ok := <-in:
+			if !ok {
+				return
+			}
+			out <- records
+		case summaries := <-pd.summaries:
+			out <- summaries
+		}
+	}
+}
+
+// summarize hands over one record per source of the current window with observed ports
+func (pd *PortScanDetector) summarize(now time.Time) {
+	if pd.maxObservedPorts <= 0 {
+		return
+	}
+	var summaries []*Record
+	for addr, src := range pd.sources {
+		if len(src.observed) == 0 {
+			continue
+		}
+		summaries = append(summaries, newPortScanSummary(addr, pd.windowStart, now, src))
+	}
+	if len(summaries) > 0 {
+		pd.summaries <- summaries
+	}
+}
+
+// newPortScanSummary returns the aggregated record of a port scan source: a synthetic record
+// whose EndReason is FlowEndReasonPortScanSummary, that only carries the source address, the
+// window, the number of flows that the source opened in the window as SubFlowCount, and its
+// ScanSuspect verdict and ObservedPorts. Its other flow fields are zero.
+func newPortScanSummary(addr IPAddr, windowStart, windowEnd time.Time, src *portScanSource) *Record {
+	record := &Record{
+		TimeFlowStart:         windowStart,
+		TimeFlowEnd:           windowEnd,
+		EndReason:             FlowEndReasonPortScanSummary,
+		SchemaVersion:         SchemaVersion,
+		SubFlowCount:          src.flows,
+		ScanSuspect:           src.suspect,
+		ObservedPorts:         src.observed,
+		ObservedPortsOverflow: src.overflow,
+	}
+	record.Id.SrcIp = addr
+	record.Id.EthProtocol = IPv6Type
+	if IP(addr).To4() != nil {
+		record.Id.EthProtocol = IPv4Type
+	}
+	return record
+}
+
+func (pd *PortScanDetector) account(record *Record) {
+	src, ok := pd.sources[record.Id.SrcIp]
+	if !ok {
+		if len(pd.sources) >= pd.maxSources {
+			pd.untracked.Inc()
+			return
+		}
+		src = &portScanSource{ports: map[uint16]struct{}{}}
+		pd.sources[record.Id.SrcIp] = src
+	}
+	src.flows++
+	if src.ports == nil {
+		// suspect source whose ports were released
+		return
+	}
+	port := record.Id.DstPort
+	if _, ok := src.ports[port]; ok {
+		return
+	}
+	src.ports[port] = struct{}{}
+	pd.observe(src, port)
+	if !src.suspect && len(src.ports) >= pd.threshold {
+		src.suspect = true
+		if pd.maxObservedPorts <= 0 {
+			src.ports = nil
+		}
+		pd.suspects.Inc()
+	}
+}
+
+// observe appends a new distinct port to the observed ports of the source, or accounts it as
+// overflow if the list is full
+func (pd *PortScanDetector) observe(src *portScanSource, port uint16) {
+	if pd.maxObservedPorts <= 0 {
+		return
+	}
+	if len(src.observed) >= pd.maxObservedPorts {
+		src.overflow++
+		pd.observedOverflow.Inc()
+		return
+	}
+	src.observed = append(src.observed, port)
+}
+
+// opensConnection tells whether the flow comes from the endpoint that opened the connection, as
+// far as it can be known from the flow alone
+func opensConnection(record *Record) bool {
+	switch record.Id.TransportProtocol {
+	case syscall.IPPROTO_TCP:
+		flags := record.Metrics.Flags
+		return flags&TCPFlagSYN != 0 && flags&TCPFlagSYNACK == 0
+	case syscall.IPPROTO_UDP, syscall.IPPROTO_SCTP:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,174 @@
+package flow
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func scanFlow(src, dst string, srcPort, dstPort uint16, flags uint16) *Record {
+	r := &Record{RawRecord: RawRecord{
+		Id: ebpf.BpfFlowId{
+			SrcPort:           srcPort,
+			DstPort:           dstPort,
+			TransportProtocol: syscall.IPPROTO_TCP,
+		},
+		Metrics: ebpf.BpfFlowMetrics{Packets: 1, Bytes: 60, Flags: flags},
+	}}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestPortScanDetector(t *testing.T) {
+	m := metrics.NoOp()
+	now := time.Now()
+	pd := NewPortScanDetector(10, time.Minute, 100, 0, func() time.Time { return now }, m)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go pd.Detect(in, out)
+	defer close(in)
+
+	// GIVEN a scanner that probes 20 ports of a host, in two batches, and the host replies
+	var probes, replies []*Record
+	for port := uint16(1); port <= 20; port++ {
+		probes = append(probes, scanFlow("10.0.0.1", "10.0.0.3", 40000, port, TCPFlagSYN))
+		replies = append(replies,
+			scanFlow("10.0.0.3", "10.0.0.1", port, 40000+port, TCPFlagSYNACK))
+	}
+	// AND a client that opens many connections towards the same service
+	var clients []*Record
+	for port := uint16(50000); port < 50020; port++ {
+		clients = append(clients, scanFlow("10.0.0.2", "10.0.0.3", port, 443, TCPFlagSYN))
+	}
+
+	// WHEN the first probes don't reach the threshold
+	in <- append(probes[:5:5], clients[:10]...)
+	records := receiveTimeout(t, out)
+	for _, r := range records {
+		assert.False(t, r.ScanSuspect)
+	}
+
+	// THEN all the flows of the scanner are flagged once it reaches the threshold
+	in <- append(append(probes[5:], replies...), clients[10:]...)
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 45)
+	for _, r := range records {
+		isScanner := net.IP(r.Id.SrcIp[:]).Equal(net.ParseIP("10.0.0.1"))
+		assert.Equal(t, isScanner, r.ScanSuspect, "src port %d", r.Id.SrcPort)
+	}
+	assert.EqualValues(t, 1, counterValue(t, m, "port_scan_suspects_total"))
+
+	// AND the count is reset in the next window
+	now = now.Add(time.Minute)
+	in <- []*Record{scanFlow("10.0.0.1", "10.0.0.3", 40000, 1, TCPFlagSYN)}
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.False(t, records[0].ScanSuspect)
+}
+
+func TestPortScanDetector_MaxSources(t *testing.T) {
+	m := metrics.NoOp()
+	pd := NewPortScanDetector(2, time.Minute, 1, 0, time.Now, m)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go pd.Detect(in, out)
+	defer close(in)
+
+	// GIVEN that only one source can be tracked
+	// WHEN two sources hit enough distinct ports
+	in <- []*Record{
+		scanFlow("10.0.0.1", "10.0.0.3", 40000, 1, TCPFlagSYN),
+		scanFlow("10.0.0.2", "10.0.0.3", 40000, 1, TCPFlagSYN),
+		scanFlow("10.0.0.1", "10.0.0.3", 40000, 2, TCPFlagSYN),
+		scanFlow("10.0.0.2", "10.0.0.3", 40000, 2, TCPFlagSYN),
+	}
+
+	// THEN only the tracked source is flagged
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 4)
+	assert.True(t, records[0].ScanSuspect)
+	assert.False(t, records[1].ScanSuspect)
+	assert.True(t, records[2].ScanSuspect)
+	assert.False(t, records[3].ScanSuspect)
+	assert.EqualValues(t, 2, counterValue(t, m, "port_scan_untracked_flows_total"))
+}
+
+func TestPortScanDetector_ObservedPorts(t *testing.T) {
+	m := metrics.NoOp()
+	now := time.Now()
+	start := now
+	pd := NewPortScanDetector(3, time.Minute, 100, 4, func() time.Time { return now }, m)
+	in := make(chan []*Record, 10)
+	detected := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go pd.Detect(in, detected)
+	go pd.Summaries(detected, out)
+
+	// GIVEN a source that hops across destination ports, repeating some of them
+	var hops []*Record
+	for _, port := range []uint16{8080, 443, 8080, 22, 6667} {
+		hops = append(hops, scanFlow("10.0.0.1", "10.0.0.3", 40000, port, TCPFlagSYN))
+	}
+	// AND the replies of the destination, which don't open connections
+	reply := scanFlow("10.0.0.3", "10.0.0.1", 443, 40000, TCPFlagSYNACK)
+
+	// WHEN the flows are accounted
+	in <- append(hops, reply)
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 6)
+
+	// THEN the flows are flagged, but they don't carry the observed ports
+	for _, r := range records[:5] {
+		assert.True(t, r.ScanSuspect)
+		assert.Empty(t, r.ObservedPorts)
+	}
+	assert.Empty(t, records[5].ObservedPorts)
+
+	// AND the ports beyond the bound are only counted, even after the source is flagged
+	in <- []*Record{
+		scanFlow("10.0.0.1", "10.0.0.3", 40000, 3389, TCPFlagSYN),
+		scanFlow("10.0.0.1", "10.0.0.3", 40000, 443, TCPFlagSYN),
+		scanFlow("10.0.0.1", "10.0.0.3", 40000, 5900, TCPFlagSYN),
+	}
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 3)
+	assert.EqualValues(t, 2, counterValue(t, m, "port_scan_observed_ports_overflow_total"))
+
+	// AND WHEN the window ends
+	now = now.Add(time.Minute)
+	in <- []*Record{scanFlow("10.0.0.1", "10.0.0.3", 40000, 53, TCPFlagSYN)}
+
+	// THEN a single record of the source carries the distinct ports, in the order they were
+	// first hit, before the flows of the next window
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	summary := records[0]
+	assert.Equal(t, FlowEndReasonPortScanSummary, summary.EndReason)
+	assert.True(t, net.IP(summary.Id.SrcIp[:]).Equal(net.ParseIP("10.0.0.1")))
+	assert.EqualValues(t, IPv4Type, summary.Id.EthProtocol)
+	assert.Equal(t, []uint16{8080, 443, 22, 6667}, summary.ObservedPorts)
+	assert.EqualValues(t, 2, summary.ObservedPortsOverflow)
+	assert.EqualValues(t, 8, summary.SubFlowCount)
+	assert.True(t, summary.ScanSuspect)
+	assert.Equal(t, start, summary.TimeFlowStart)
+	assert.Equal(t, now, summary.TimeFlowEnd)
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.Empty(t, records[0].ObservedPorts)
+
+	// AND the observed ports of the last window are emitted once the input is closed
+	close(in)
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.Equal(t, []uint16{53}, records[0].ObservedPorts)
+	assert.Zero(t, records[0].ObservedPortsOverflow)
+	assert.False(t, records[0].ScanSuspect)
+}
@@ -0,0 +1,86 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+// AggPrefixes are the lengths of the network prefixes that the source and destination
+// addresses of the flows are masked to, for IPv4 and IPv6 flows. The full lengths (32 and 128)
+// keep the addresses unchanged, as well as 0, so the zero value doesn't aggregate the flows.
+type AggPrefixes struct {
+	SrcV4 int
+	DstV4 int
+	SrcV6 int
+	DstV6 int
+}
+
+// Validate returns error if any prefix length is out of the range of its address family
+func (p *AggPrefixes) Validate() error {
+	for _, pl := range []struct {
+		name   string
+		length int
+		max    int
+	}{
+		{"source IPv4", p.SrcV4, net.IPv4len * 8},
+		{"destination IPv4", p.DstV4, net.IPv4len * 8},
+		{"source IPv6", p.SrcV6, net.IPv6len * 8},
+		{"destination IPv6", p.DstV6, net.IPv6len * 8},
+	} {
+		if pl.length < 0 || pl.length > pl.max {
+			return fmt.Errorf("invalid %s prefix length %d. Accepted values are 0 to %d",
+				pl.name, pl.length, pl.max)
+		}
+	}
+	return nil
+}
+
+// Enabled tells whether any of the prefixes masks the addresses
+func (p *AggPrefixes) Enabled() bool {
+	return masks(p.SrcV4, net.IPv4len*8) || masks(p.DstV4, net.IPv4len*8) ||
+		masks(p.SrcV6, net.IPv6len*8) || masks(p.DstV6, net.IPv6len*8)
+}
+
+func masks(length, bits int) bool {
+	return length > 0 && length < bits
+}
+
+// PrefixKey masks the source and destination addresses of the flow identifier to their
+// aggregation prefixes. Since the MAC address of a masked endpoint doesn't identify the whole
+// subnet, it is zeroed.
+func (p *AggPrefixes) PrefixKey(id *ebpf.BpfFlowId) {
+	srcLen, dstLen, bits, offset := p.SrcV6, p.DstV6, net.IPv6len*8, 0
+	if IP(id.SrcIp).To4() != nil {
+		// the IPv4 addresses are IPv4-mapped IPv6 addresses, so the prefix of the IPv4 part
+		// starts after the first 96 bits
+		srcLen, dstLen, bits, offset = p.SrcV4, p.DstV4, net.IPv4len*8, 96
+	}
+	if masks(srcLen, bits) {
+		maskPrefix(&id.SrcIp, offset+srcLen)
+		id.SrcMac = [MacLen]uint8{}
+	}
+	if masks(dstLen, bits) {
+		maskPrefix(&id.DstIp, offset+dstLen)
+		id.DstMac = [MacLen]uint8{}
+	}
+}
+
+func maskPrefix(addr *[net.IPv6len]uint8, ones int) {
+	mask := net.CIDRMask(ones, net.IPv6len*8)
+	for i := range addr {
+		addr[i] &= mask[i]
+	}
+}
+
+// KeyByPrefix returns a stage that masks the addresses of the flows to their aggregation
+// prefixes, and merges the flows of each batch that end up sharing the same identifier, so the
+// flows between the same subnets are aggregated in a single record.
+func KeyByPrefix(prefixes AggPrefixes) func(in <-chan []*Record, out chan<- []*Record) {
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			out <- mergeByKey(records, prefixes.PrefixKey)
+		}
+	}
+}
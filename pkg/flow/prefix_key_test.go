@@ -0,0 +1,78 @@
+package flow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func prefixFlow(src, dst string, dstPort uint16, packets uint32, bytes uint64) *Record {
+	r := &Record{
+		RawRecord: RawRecord{
+			Id: ebpf.BpfFlowId{
+				TransportProtocol: 6, DstPort: dstPort,
+				SrcMac: [MacLen]uint8{1, 2, 3, 4, 5, 6}, DstMac: [MacLen]uint8{6, 5, 4, 3, 2, 1},
+			},
+			Metrics: ebpf.BpfFlowMetrics{Packets: packets, Bytes: bytes},
+		},
+		SubFlowCount: 1,
+	}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestKeyByPrefix(t *testing.T) {
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go KeyByPrefix(AggPrefixes{SrcV4: 24, DstV4: 32, SrcV6: 64, DstV6: 128})(in, out)
+	defer close(in)
+
+	in <- []*Record{
+		prefixFlow("203.0.113.10", "10.0.0.1", 443, 2, 200),
+		prefixFlow("203.0.113.20", "10.0.0.1", 443, 3, 300),
+		// different destination: not merged
+		prefixFlow("203.0.113.30", "10.0.0.2", 443, 1, 100),
+		// different source subnet: not merged
+		prefixFlow("203.0.114.10", "10.0.0.1", 443, 1, 100),
+		prefixFlow("2001:db8:1:1::10", "fd00::1", 443, 1, 100),
+		prefixFlow("2001:db8:1:1::20", "fd00::1", 443, 4, 400),
+	}
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 4)
+
+	// the flows from the same /24 towards the same destination are merged
+	assert.Equal(t, "203.0.113.0", IP(records[0].Id.SrcIp).String())
+	assert.Equal(t, "10.0.0.1", IP(records[0].Id.DstIp).String())
+	assert.EqualValues(t, 5, records[0].Metrics.Packets)
+	assert.EqualValues(t, 500, records[0].Metrics.Bytes)
+	assert.EqualValues(t, 2, records[0].SubFlowCount)
+	// the MAC of the masked endpoint is zeroed, and the other is kept
+	assert.Equal(t, [MacLen]uint8{}, records[0].Id.SrcMac)
+	assert.Equal(t, [MacLen]uint8{6, 5, 4, 3, 2, 1}, records[0].Id.DstMac)
+
+	assert.Equal(t, "203.0.113.0", IP(records[1].Id.SrcIp).String())
+	assert.Equal(t, "10.0.0.2", IP(records[1].Id.DstIp).String())
+	assert.Equal(t, "203.0.114.0", IP(records[2].Id.SrcIp).String())
+
+	// the IPv6 flows from the same /64 are merged
+	assert.Equal(t, "2001:db8:1:1::", IP(records[3].Id.SrcIp).String())
+	assert.Equal(t, "fd00::1", IP(records[3].Id.DstIp).String())
+	assert.EqualValues(t, 5, records[3].Metrics.Packets)
+}
+
+func TestAggPrefixes(t *testing.T) {
+	// the full lengths and the zero value don't aggregate the flows
+	assert.False(t, (&AggPrefixes{SrcV4: 32, DstV4: 32, SrcV6: 128, DstV6: 128}).Enabled())
+	assert.False(t, (&AggPrefixes{}).Enabled())
+	assert.True(t, (&AggPrefixes{DstV6: 48}).Enabled())
+
+	assert.NoError(t, (&AggPrefixes{SrcV4: 24, DstV4: 16, SrcV6: 64, DstV6: 48}).Validate())
+	assert.Error(t, (&AggPrefixes{SrcV4: 33}).Validate())
+	assert.Error(t, (&AggPrefixes{DstV6: 129}).Validate())
+	assert.Error(t, (&AggPrefixes{DstV4: -1}).Validate())
+}
@@ -0,0 +1,106 @@
+package flow
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var plog = logrus.WithField("component", "flow.PressureMonitor")
+
+const (
+	// pressureHighOccupancy is the occupancy of the pipeline buffer above which the pressure level
+	// is increased, even if no flows have been dropped yet
+	pressureHighOccupancy = 0.8
+	// pressureLowOccupancy is the occupancy of the pipeline buffer below which the pressure level
+	// is decreased, if no flows have been dropped since the last check
+	pressureLowOccupancy = 0.2
+)
+
+// PressureSource reports how saturated the flows' pipeline is. It is implemented by the
+// CapacityLimiter.
+type PressureSource interface {
+	// Pressure returns the total number of dropped flows, and the occupancy of the pipeline
+	// buffer, from 0 to 1
+	Pressure() (dropped uint64, occupancy float64)
+}
+
+// PressureWriter forwards the pressure level to the eBPF program, which sheds the packets
+// accordingly. It is implemented by the ebpf.FlowFetcher.
+type PressureWriter interface {
+	SetPressureLevel(level uint32) error
+}
+
+// PressureMonitor implements an adaptive load shedding loop: it periodically checks the
+// saturation of the flows' pipeline and raises the pressure level that is read by the eBPF
+// program when userspace can't keep up with the flows (the pipeline buffer is almost full or
+// flows are being dropped), so the eBPF program samples the packets more aggressively instead
+// of having the flows silently dropped. The level is decreased once the pipeline recovers.
+type PressureMonitor struct {
+	source      PressureSource
+	writer      PressureWriter
+	maxLevel    uint32
+	level       uint32
+	lastDropped uint64
+	levelGauge  prometheus.Gauge
+}
+
+// NewPressureMonitor creates a PressureMonitor whose pressure level ranges from 0 to maxLevel
+func NewPressureMonitor(
+	source PressureSource, writer PressureWriter, maxLevel int, m *metrics.Metrics,
+) *PressureMonitor {
+	return &PressureMonitor{
+		source:   source,
+		writer:   writer,
+		maxLevel: uint32(maxLevel),
+		levelGauge: m.NewGauge("ebpf_pressure_level",
+			"Pressure level notified to the eBPF program. Each level halves the fraction "+
+				"of accounted packets"),
+	}
+}
+
+// Run checks the pipeline saturation each interval, until the context is canceled. On exit,
+// the pressure level is left as is, since the eBPF map is destroyed with the program.
+func (p *PressureMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Check()
+		}
+	}
+}
+
+// Check samples the saturation of the pipeline, updates the pressure level accordingly and
+// returns it
+func (p *PressureMonitor) Check() uint32 {
+	dropped, occupancy := p.source.Pressure()
+	newDrops := dropped > p.lastDropped
+	p.lastDropped = dropped
+	switch {
+	case (newDrops || occupancy >= pressureHighOccupancy) && p.level < p.maxLevel:
+		p.setLevel(p.level + 1)
+	case !newDrops && occupancy <= pressureLowOccupancy && p.level > 0:
+		p.setLevel(p.level - 1)
+	}
+	return p.level
+}
+
+// setLevel writes the pressure level. If it fails, the previous level is kept, so the write is
+// retried in the next check.
+func (p *PressureMonitor) setLevel(level uint32) {
+	if err := p.writer.SetPressureLevel(level); err != nil {
+		plog.WithError(err).Warn("can't notify the pressure level to the eBPF program")
+		return
+	}
+	plog.WithFields(logrus.Fields{"from": p.level, "to": level}).Info("pressure level changed")
+	p.level = level
+	p.levelGauge.Set(float64(level))
+}
@@ -0,0 +1,129 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	test2 "github.com/mariomac/guara/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+type pressureSourceFake struct {
+	dropped   uint64
+	occupancy float64
+}
+
+func (p *pressureSourceFake) Pressure() (uint64, float64) {
+	return p.dropped, p.occupancy
+}
+
+type pressureWriterFake struct {
+	mt      sync.Mutex
+	written []uint32
+	err     error
+	// objects mimics the eBPF objects of the FlowFetcher, which are released on Close without
+	// synchronization, so a write racing with Close is reported by the race detector
+	objects *uint32
+}
+
+func (p *pressureWriterFake) SetPressureLevel(level uint32) error {
+	if p.objects != nil {
+		*p.objects = level
+	}
+	p.mt.Lock()
+	defer p.mt.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.written = append(p.written, level)
+	return nil
+}
+
+func (p *pressureWriterFake) Close() {
+	p.objects = nil
+}
+
+func (p *pressureWriterFake) last() uint32 {
+	p.mt.Lock()
+	defer p.mt.Unlock()
+	if len(p.written) == 0 {
+		return 0
+	}
+	return p.written[len(p.written)-1]
+}
+
+func TestPressureMonitor(t *testing.T) {
+	source := &pressureSourceFake{}
+	writer := &pressureWriterFake{}
+	pm := NewPressureMonitor(source, writer, 2, metrics.NoOp())
+
+	// WHEN the pipeline is not saturated
+	source.occupancy = 0.5
+	// THEN no pressure is written
+	assert.Zero(t, pm.Check())
+	assert.Empty(t, writer.written)
+
+	// WHEN the pipeline buffer is almost full
+	source.occupancy = 0.9
+	// THEN the pressure level is raised
+	assert.EqualValues(t, 1, pm.Check())
+	// WHEN flows are dropped
+	source.occupancy = 0.5
+	source.dropped = 10
+	assert.EqualValues(t, 2, pm.Check())
+	// THEN the pressure level doesn't exceed the maximum
+	source.dropped = 20
+	assert.EqualValues(t, 2, pm.Check())
+	assert.Equal(t, []uint32{1, 2}, writer.written)
+
+	// WHEN no more flows are dropped but the buffer is still busy, the level is kept
+	assert.EqualValues(t, 2, pm.Check())
+	// AND it is progressively released once the pipeline recovers
+	source.occupancy = 0.1
+	assert.EqualValues(t, 1, pm.Check())
+	assert.EqualValues(t, 0, pm.Check())
+	assert.Equal(t, []uint32{1, 2, 1, 0}, writer.written)
+}
+
+func TestPressureMonitor_WriteError(t *testing.T) {
+	source := &pressureSourceFake{occupancy: 1}
+	writer := &pressureWriterFake{err: errors.New("map not available")}
+	pm := NewPressureMonitor(source, writer, 4, metrics.NoOp())
+
+	// a failed write keeps the previous level, so it is retried in the next check
+	assert.Zero(t, pm.Check())
+	writer.err = nil
+	assert.EqualValues(t, 1, pm.Check())
+	assert.Equal(t, []uint32{1}, writer.written)
+}
+
+func TestPressureMonitor_Run(t *testing.T) {
+	// GIVEN a limiter whose destination buffer is full
+	limiter := &CapacityLimiter{occupancy: 100}
+	writer := &pressureWriterFake{objects: new(uint32)}
+	pm := NewPressureMonitor(limiter, writer, 3, metrics.NoOp())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pm.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	// THEN the pressure is raised up to the maximum level
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.EqualValues(t, 3, writer.last())
+	}, test2.Interval(10*time.Millisecond))
+
+	// WHEN the context is canceled while the eBPF objects are closed
+	cancel()
+	writer.Close()
+	<-done
+	// THEN the pressure level isn't written on exit, since the eBPF map is destroyed anyway
+	assert.EqualValues(t, 3, writer.last())
+}
@@ -0,0 +1,301 @@
+package flow
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+// EnricherProcess decorates the flows from local sockets with the PID and the name of the
+// process that owns the socket
+const EnricherProcess = "process"
+
+// DefaultProcessRefreshPeriod is the minimum time between two scans of the processes' sockets
+const DefaultProcessRefreshPeriod = 5 * time.Second
+
+var proclog = logrus.WithField("component", "flow.ProcessResolver")
+
+// hostEndian is the byte order of the addresses in the /proc/net socket tables
+var hostEndian binary.ByteOrder = func() binary.ByteOrder {
+	probe := uint16(1)
+	if *(*byte)(unsafe.Pointer(&probe)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+func init() {
+	RegisterEnricher(EnricherProcess, func(_ *EnricherContext) (Enricher, error) {
+		return NewProcessResolver("/proc", DefaultProcessRefreshPeriod, time.Now), nil
+	})
+}
+
+// socketEndpoint identifies the local or remote endpoint of a socket
+type socketEndpoint struct {
+	proto uint8
+	ip    IPAddr
+	port  uint16
+}
+
+type socketPair struct {
+	local  socketEndpoint
+	remote socketEndpoint
+}
+
+type processInfo struct {
+	pid  uint32
+	comm string
+}
+
+// processTable indexes the owner processes of the host sockets by their connected endpoints
+// and by their bound local endpoints
+type processTable struct {
+	connected map[socketPair]processInfo
+	bound     map[socketEndpoint]processInfo
+}
+
+// ProcessResolver enricher sets the PID and Comm fields of the TCP and UDP flows from the
+// local sockets, which are the flows whose packets have been associated to a socket by the eBPF
+// program (it reported a socket cookie). The forwarded or transit flows are left untouched.
+// The owner of each socket is found by matching the flow endpoints with the socket tables of
+// procRoot/net and the socket descriptors of the processes in procRoot, which requires access
+// to the host PID namespace. The scan is performed in the background, at most once per refresh
+// period, when a flow whose socket is unknown is observed, so the process is attached to the
+// next flows of the same socket.
+type ProcessResolver struct {
+	procRoot string
+	mt       sync.RWMutex
+	table    processTable
+	refresh  chan struct{}
+}
+
+// NewProcessResolver creates a ProcessResolver and starts its background scans worker
+func NewProcessResolver(procRoot string, refreshPeriod time.Duration, clock func() time.Time) *ProcessResolver {
+	pr := &ProcessResolver{
+		procRoot: procRoot,
+		refresh:  make(chan struct{}, 1),
+	}
+	go pr.scanWorker(refreshPeriod, clock)
+	pr.requestScan()
+	return pr
+}
+
+func (pr *ProcessResolver) Enrich(record *Record) {
+	if record.Metrics.SocketCookie == 0 {
+		return
+	}
+	switch record.Id.TransportProtocol {
+	case syscall.IPPROTO_TCP, syscall.IPPROTO_UDP:
+	default:
+		return
+	}
+	pr.mt.RLock()
+	info, ok := pr.table.lookup(&record.Id)
+	pr.mt.RUnlock()
+	if !ok {
+		pr.requestScan()
+		return
+	}
+	record.PID = info.pid
+	record.Comm = info.comm
+}
+
+func (pr *ProcessResolver) requestScan() {
+	select {
+	case pr.refresh <- struct{}{}:
+	default:
+		// a scan is already pending
+	}
+}
+
+func (pr *ProcessResolver) scanWorker(refreshPeriod time.Duration, clock func() time.Time) {
+	var lastScan time.Time
+	for range pr.refresh {
+		if wait := refreshPeriod - clock().Sub(lastScan); wait > 0 {
+			time.Sleep(wait)
+		}
+		lastScan = clock()
+		table, err := scanProcesses(pr.procRoot)
+		if err != nil {
+			proclog.WithError(err).Debug("can't scan the processes' sockets")
+			continue
+		}
+		pr.mt.Lock()
+		pr.table = table
+		pr.mt.Unlock()
+	}
+}
+
+// lookup returns the process that owns the socket of a flow, whose local endpoint can be either
+// the source or the destination
+func (pt *processTable) lookup(id *ebpf.BpfFlowId) (processInfo, bool) {
+	src := socketEndpoint{proto: id.TransportProtocol, ip: id.SrcIp, port: id.SrcPort}
+	dst := socketEndpoint{proto: id.TransportProtocol, ip: id.DstIp, port: id.DstPort}
+	if info, ok := pt.connected[socketPair{local: src, remote: dst}]; ok {
+		return info, true
+	}
+	if info, ok := pt.connected[socketPair{local: dst, remote: src}]; ok {
+		return info, true
+	}
+	for _, local := range []socketEndpoint{src, dst} {
+		if info, ok := pt.bound[local]; ok {
+			return info, true
+		}
+		// sockets bound to any address, for both IP families
+		for _, wildcard := range []net.IP{net.IPv4zero, net.IPv6zero} {
+			copy(local.ip[:], wildcard.To16())
+			if info, ok := pt.bound[local]; ok {
+				return info, true
+			}
+		}
+	}
+	return processInfo{}, false
+}
+
+// scanProcesses builds the processTable from the socket tables and the processes' descriptors
+func scanProcesses(procRoot string) (processTable, error) {
+	owners, err := socketOwners(procRoot)
+	if err != nil {
+		return processTable{}, err
+	}
+	table := processTable{
+		connected: map[socketPair]processInfo{},
+		bound:     map[socketEndpoint]processInfo{},
+	}
+	for _, st := range []struct {
+		file  string
+		proto uint8
+	}{
+		{file: "tcp", proto: syscall.IPPROTO_TCP},
+		{file: "tcp6", proto: syscall.IPPROTO_TCP},
+		{file: "udp", proto: syscall.IPPROTO_UDP},
+		{file: "udp6", proto: syscall.IPPROTO_UDP},
+	} {
+		if err := table.load(filepath.Join(procRoot, "net", st.file), st.proto, owners); err != nil {
+			// the IPv6 tables are missing if IPv6 is disabled
+			proclog.WithError(err).Debug("can't read socket table")
+		}
+	}
+	return table, nil
+}
+
+// load adds to the table the sockets of a /proc/net socket table that are owned by a process
+func (pt *processTable) load(file string, proto uint8, owners map[uint64]processInfo) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	// skip the header
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode
+		if len(fields) < 10 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		info, ok := owners[inode]
+		if !ok {
+			continue
+		}
+		local, err := parseSocketEndpoint(fields[1], proto)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", file, err)
+		}
+		remote, err := parseSocketEndpoint(fields[2], proto)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", file, err)
+		}
+		if remote.port == 0 {
+			pt.bound[local] = info
+		} else {
+			pt.connected[socketPair{local: local, remote: remote}] = info
+		}
+	}
+	return scanner.Err()
+}
+
+// parseSocketEndpoint parses an address:port endpoint of the /proc/net socket tables, where the
+// address is hex-encoded as 32-bit words in host byte order, and the port is hex-encoded
+func parseSocketEndpoint(str string, proto uint8) (socketEndpoint, error) {
+	addr, port, ok := strings.Cut(str, ":")
+	if !ok {
+		return socketEndpoint{}, fmt.Errorf("invalid endpoint %q", str)
+	}
+	words, err := hex.DecodeString(addr)
+	if err != nil || (len(words) != net.IPv4len && len(words) != net.IPv6len) {
+		return socketEndpoint{}, fmt.Errorf("invalid address in endpoint %q", str)
+	}
+	portNum, err := strconv.ParseUint(port, 16, 16)
+	if err != nil {
+		return socketEndpoint{}, fmt.Errorf("invalid port in endpoint %q", str)
+	}
+	ip := make(net.IP, len(words))
+	for i := 0; i < len(words); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], hostEndian.Uint32(words[i:]))
+	}
+	ep := socketEndpoint{proto: proto, port: uint16(portNum)}
+	copy(ep.ip[:], ip.To16())
+	return ep, nil
+}
+
+// socketOwners maps the inodes of the sockets to the processes that hold a descriptor of them
+func socketOwners(procRoot string) (map[uint64]processInfo, error) {
+	procs, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	owners := map[uint64]processInfo{}
+	for _, proc := range procs {
+		pid, err := strconv.ParseUint(proc.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procRoot, proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			// the process has exited, or its descriptors aren't accessible
+			continue
+		}
+		var info *processInfo
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !strings.HasPrefix(target, "socket:[") {
+				continue
+			}
+			inode, err := strconv.ParseUint(strings.TrimSuffix(target[len("socket:["):], "]"), 10, 64)
+			if err != nil {
+				continue
+			}
+			if _, ok := owners[inode]; ok {
+				// the socket is shared by several processes. The first one keeps its ownership
+				continue
+			}
+			if info == nil {
+				comm, _ := os.ReadFile(filepath.Join(procRoot, proc.Name(), "comm"))
+				info = &processInfo{pid: uint32(pid), comm: strings.TrimSpace(string(comm))}
+			}
+			owners[inode] = *info
+		}
+	}
+	return owners, nil
+}
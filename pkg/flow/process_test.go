@@ -0,0 +1,107 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+// procEndpoint encodes an endpoint as in the /proc/net socket tables
+func procEndpoint(ip string, port uint16) string {
+	addr := net.ParseIP(ip)
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	hex := ""
+	for i := 0; i < len(addr); i += 4 {
+		hex += fmt.Sprintf("%08X", hostEndian.Uint32(addr[i:]))
+	}
+	return fmt.Sprintf("%s:%04X", hex, port)
+}
+
+// fakeProc creates a procfs-like tree with the provided socket tables and processes
+func fakeProc(t *testing.T, tables map[string][]string, procs map[int]map[int]uint64, comms map[int]string) string {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "net"), 0o755))
+	for name, lines := range tables {
+		content := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+		for i, line := range lines {
+			content += fmt.Sprintf("%4d: %s\n", i, line)
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(root, "net", name), []byte(content), 0o644))
+	}
+	for pid, fds := range procs {
+		fdDir := filepath.Join(root, fmt.Sprint(pid), "fd")
+		require.NoError(t, os.MkdirAll(fdDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(root, fmt.Sprint(pid), "comm"),
+			[]byte(comms[pid]+"\n"), 0o644))
+		for fd, inode := range fds {
+			require.NoError(t, os.Symlink(fmt.Sprintf("socket:[%d]", inode),
+				filepath.Join(fdDir, fmt.Sprint(fd))))
+		}
+	}
+	return root
+}
+
+func socketLine(local, remote string, inode uint64) string {
+	return fmt.Sprintf("%s %s 01 00000000:00000000 00:00000000 00000000  1000        0 %d 1 0000000000000000",
+		local, remote, inode)
+}
+
+func processFlow(proto uint8, src string, srcPort uint16, dst string, dstPort uint16, cookie uint64) *Record {
+	r := &Record{RawRecord: RawRecord{
+		Id:      ebpf.BpfFlowId{SrcPort: srcPort, DstPort: dstPort, TransportProtocol: proto},
+		Metrics: ebpf.BpfFlowMetrics{Packets: 1, SocketCookie: cookie},
+	}}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestProcessResolver(t *testing.T) {
+	root := fakeProc(t, map[string][]string{
+		"tcp":  {socketLine(procEndpoint("10.0.0.1", 40000), procEndpoint("10.0.0.2", 443), 1001)},
+		"udp6": {socketLine(procEndpoint("::", 53), procEndpoint("::", 0), 1002)},
+	}, map[int]map[int]uint64{
+		1234: {3: 1001},
+		4321: {5: 1002},
+	}, map[int]string{1234: "curl", 4321: "dnsmasq"})
+	pr := NewProcessResolver(root, time.Millisecond, time.Now)
+
+	// GIVEN a local TCP flow, in both directions
+	egress := processFlow(syscall.IPPROTO_TCP, "10.0.0.1", 40000, "10.0.0.2", 443, 77)
+	ingress := processFlow(syscall.IPPROTO_TCP, "10.0.0.2", 443, "10.0.0.1", 40000, 77)
+	// AND a local UDP flow towards a socket that is bound to any address
+	dns := processFlow(syscall.IPPROTO_UDP, "fd00::2", 5353, "fd00::1", 53, 78)
+	// AND a transit flow that isn't associated to a local socket, but matches the same ports
+	transit := processFlow(syscall.IPPROTO_TCP, "10.0.0.1", 40000, "10.0.0.2", 443, 0)
+
+	// WHEN they are enriched, once the sockets have been scanned
+	require.Eventually(t, func() bool {
+		pr.Enrich(egress)
+		return egress.PID != 0
+	}, 5*time.Second, 10*time.Millisecond)
+	pr.Enrich(ingress)
+	pr.Enrich(dns)
+	pr.Enrich(transit)
+
+	// THEN the local flows are attributed to the process that owns their socket
+	assert.EqualValues(t, 1234, egress.PID)
+	assert.Equal(t, "curl", egress.Comm)
+	assert.EqualValues(t, 1234, ingress.PID)
+	assert.Equal(t, "curl", ingress.Comm)
+	assert.EqualValues(t, 4321, dns.PID)
+	assert.Equal(t, "dnsmasq", dns.Comm)
+	// AND the transit flow is left empty
+	assert.Zero(t, transit.PID)
+	assert.Empty(t, transit.Comm)
+}
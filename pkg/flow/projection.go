@@ -0,0 +1,74 @@
+package flow
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var recordType = reflect.TypeOf(Record{})
+
+// FieldProjection restricts the flow records to an allowlist of their fields, so each exporter
+// only receives the fields that its collector needs.
+type FieldProjection struct {
+	// indices of the allowed fields, as accepted by reflect.Value.FieldByIndex
+	fields [][]int
+}
+
+// NewFieldProjection returns a FieldProjection that keeps the provided fields of the records.
+// The fields are named after the Record struct fields (e.g. Interface or TimeFlowStart), as
+// well as the fields of the flow identifier and metrics, prefixed by Id and Metrics (e.g.
+// Id.SrcIp or Metrics.Bytes). The Id and Metrics names keep all their fields.
+func NewFieldProjection(fields []string) (*FieldProjection, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields provided")
+	}
+	fp := &FieldProjection{}
+	for _, name := range fields {
+		index, err := fieldIndex(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		fp.fields = append(fp.fields, index)
+	}
+	return fp, nil
+}
+
+func fieldIndex(name string) ([]int, error) {
+	var index []int
+	typ := recordType
+	for _, part := range strings.Split(name, ".") {
+		if typ.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("unknown record field %q", name)
+		}
+		field, ok := typ.FieldByName(part)
+		// the embedded RawRecord is not a field of the exported records, but its Id and Metrics
+		if !ok || !field.IsExported() || field.Anonymous {
+			return nil, fmt.Errorf("unknown record field %q", name)
+		}
+		index = append(index, field.Index...)
+		typ = field.Type
+	}
+	return index, nil
+}
+
+// Project returns a copy of the record that only contains the allowed fields. The rest of fields
+// are left to their zero value, except the schema version, which is always kept.
+func (fp *FieldProjection) Project(record *Record) *Record {
+	projected := &Record{SchemaVersion: record.SchemaVersion}
+	src := reflect.ValueOf(record).Elem()
+	dst := reflect.ValueOf(projected).Elem()
+	for _, index := range fp.fields {
+		dst.FieldByIndex(index).Set(src.FieldByIndex(index))
+	}
+	return projected
+}
+
+// ProjectAll returns the projection of each of the provided records
+func (fp *FieldProjection) ProjectAll(records []*Record) []*Record {
+	projected := make([]*Record, 0, len(records))
+	for _, r := range records {
+		projected = append(projected, fp.Project(r))
+	}
+	return projected
+}
@@ -0,0 +1,52 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestFieldProjection(t *testing.T) {
+	fp, err := NewFieldProjection([]string{"Id", "Metrics.Bytes", "Interface", " TimeFlowEnd"})
+	require.NoError(t, err)
+
+	record := &Record{
+		Interface:     "eth0",
+		AgentIP:       net.ParseIP("10.0.0.1"),
+		TimeFlowStart: time.Unix(1, 0),
+		TimeFlowEnd:   time.Unix(2, 0),
+	}
+	record.Id.SrcPort = 1234
+	record.Id.IfIndex = 3
+	record.Metrics.Bytes = 100
+	record.Metrics.Packets = 2
+
+	projected := fp.ProjectAll([]*Record{record})
+	require.Len(t, projected, 1)
+	assert.Equal(t, &Record{
+		RawRecord:   RawRecord{Id: record.Id, Metrics: ebpf.BpfFlowMetrics{Bytes: 100}},
+		Interface:   "eth0",
+		TimeFlowEnd: time.Unix(2, 0),
+	}, projected[0])
+	// the original record is not modified
+	assert.EqualValues(t, 2, record.Metrics.Packets)
+}
+
+func TestFieldProjection_InvalidFields(t *testing.T) {
+	for _, fields := range [][]string{
+		nil,
+		{"Nope"},
+		{"Id.Nope"},
+		{"RawRecord"},
+		{"Interface.Length"},
+		{"TimeFlowStart.wall"},
+	} {
+		_, err := NewFieldProjection(fields)
+		assert.Error(t, err, fields)
+	}
+}
@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+// EnricherQUIC decorates the UDP flows with the version and connection IDs of the QUIC long
+// header at the start of their payload sample
+const EnricherQUIC = "quic"
+
+// QUICLongHeaderMaxLen is the maximum length of the QUIC long header fields that are parsed:
+// flags (1 byte), version (4), destination connection ID length (1) and value (up to 20), and
+// source connection ID length (1) and value (up to 20)
+const QUICLongHeaderMaxLen = 47
+
+// QUIC versions that are recognized (RFC 9000 and RFC 9369), besides the IETF drafts
+const (
+	quicVersion1        = 0x00000001
+	quicVersion2        = 0x6b3343cf
+	quicDraftMask       = 0xffffff00
+	quicDraftPrefix     = 0xff000000
+	quicMaxConnIDLen    = 20
+	quicLongHeaderForm  = 0x80
+	quicFixedBit        = 0x40
+	quicVersionOffset   = 1
+	quicConnIDLenOffset = 5
+)
+
+// QUICConfig configures the QUIC enricher
+type QUICConfig struct {
+	// DropPayloadSample removes the payload sample from the flows once it has been parsed, when
+	// the payload is only sampled for the QUIC parsing
+	DropPayloadSample bool
+}
+
+func init() {
+	RegisterEnricher(EnricherQUIC, func(ctx *EnricherContext) (Enricher, error) {
+		dropSample := ctx.QUIC != nil && ctx.QUIC.DropPayloadSample
+		return EnricherFunc(func(record *Record) {
+			EnrichQUIC(record)
+			if dropSample {
+				record.PayloadSample = nil
+			}
+		}), nil
+	})
+}
+
+// EnrichQUIC sets the QUICVersion, QUICDCID and QUICSCID fields of the UDP flows whose payload
+// sample starts with a QUIC long header (e.g. an Initial or Handshake packet) of a known version.
+// The fields are left empty if the payload isn't QUIC, it is truncated, or the first packet with
+// payload of the flow has a short header (e.g. when the connection started in a previous flow
+// record), whose fields are encrypted. The ALPN is not extracted, as it is carried by the TLS
+// handshake, which is encrypted with the Initial keys and goes beyond the payload sample.
+func EnrichQUIC(record *Record) {
+	if record.Id.TransportProtocol != syscall.IPPROTO_UDP {
+		return
+	}
+	version, dcid, scid, ok := parseQUICLongHeader(record.PayloadSample)
+	if !ok {
+		return
+	}
+	record.QUICVersion = version
+	record.QUICDCID = dcid
+	record.QUICSCID = scid
+}
+
+// parseQUICLongHeader parses the version-independent fields of a QUIC long header packet
+// (RFC 8999, section 5.1)
+func parseQUICLongHeader(payload []byte) (version uint32, dcid, scid HexBytes, ok bool) {
+	if len(payload) < quicConnIDLenOffset+1 ||
+		payload[0]&(quicLongHeaderForm|quicFixedBit) != quicLongHeaderForm|quicFixedBit {
+		return 0, nil, nil, false
+	}
+	version = binary.BigEndian.Uint32(payload[quicVersionOffset:])
+	if version != quicVersion1 && version != quicVersion2 &&
+		version&quicDraftMask != quicDraftPrefix {
+		return 0, nil, nil, false
+	}
+	rest := payload[quicConnIDLenOffset:]
+	if dcid, rest, ok = quicConnID(rest); !ok {
+		return 0, nil, nil, false
+	}
+	if scid, _, ok = quicConnID(rest); !ok {
+		return 0, nil, nil, false
+	}
+	return version, dcid, scid, true
+}
+
+// quicConnID reads a connection ID that is prefixed by its length, and returns the rest of bytes
+func quicConnID(data []byte) (HexBytes, []byte, bool) {
+	if len(data) < 1 {
+		return nil, nil, false
+	}
+	length := int(data[0])
+	if length > quicMaxConnIDLen || len(data) < 1+length {
+		return nil, nil, false
+	}
+	id := make(HexBytes, length)
+	copy(id, data[1:1+length])
+	return id, data[1+length:], true
+}
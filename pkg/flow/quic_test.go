@@ -0,0 +1,81 @@
+package flow
+
+import (
+	"encoding/hex"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// first bytes of the client and server Initial packets of the examples in RFC 9001, appendix A
+const (
+	quicClientInitial = "c000000001088394c8f03e5157080000449e7b9aec34d1b1c98dd7689fb8ec11" +
+		"d242b123dc9bd8bab936b47d92ec356c0bab7df5976d27cd449f63300099f399"
+	quicServerInitial = "cf000000010008f067a5502a4262b5004075c0d95a482cd0991cd25b0aac406a" +
+		"5816b6394100f37a1c69797554780bb38cc5a99f5ede4cf73c3ec2493a1839b3"
+)
+
+func quicFlow(t *testing.T, proto uint8, payload string) *Record {
+	t.Helper()
+	sample, err := hex.DecodeString(payload)
+	require.NoError(t, err)
+	r := &Record{PayloadSample: sample}
+	r.Id.TransportProtocol = proto
+	return r
+}
+
+func TestEnrichQUIC_Initial(t *testing.T) {
+	client := quicFlow(t, syscall.IPPROTO_UDP, quicClientInitial)
+	EnrichQUIC(client)
+	assert.EqualValues(t, 1, client.QUICVersion)
+	assert.Equal(t, "8394c8f03e515708", hex.EncodeToString(client.QUICDCID))
+	assert.Empty(t, client.QUICSCID)
+
+	server := quicFlow(t, syscall.IPPROTO_UDP, quicServerInitial)
+	EnrichQUIC(server)
+	assert.EqualValues(t, 1, server.QUICVersion)
+	assert.Empty(t, server.QUICDCID)
+	assert.Equal(t, "f067a5502a4262b5", hex.EncodeToString(server.QUICSCID))
+}
+
+func TestEnrichQUIC_NotParsed(t *testing.T) {
+	for name, tc := range map[string]struct {
+		proto   uint8
+		payload string
+	}{
+		"not UDP":    {proto: syscall.IPPROTO_TCP, payload: quicClientInitial},
+		"no payload": {proto: syscall.IPPROTO_UDP, payload: ""},
+		// encrypted fields of an established connection
+		"short header": {proto: syscall.IPPROTO_UDP, payload: "4f8394c8f03e5157080000449e7b9aec"},
+		"unknown version": {proto: syscall.IPPROTO_UDP,
+			payload: "c012345678088394c8f03e5157080000449e"},
+		"connection ID too long": {proto: syscall.IPPROTO_UDP,
+			payload: "c000000001158394c8f03e5157080000449e"},
+		"truncated": {proto: syscall.IPPROTO_UDP, payload: quicClientInitial[:20]},
+		// e.g. a DNS query
+		"plain UDP": {proto: syscall.IPPROTO_UDP,
+			payload: "12340100000100000000000003777777076578616d706c6503636f6d0000010001"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := quicFlow(t, tc.proto, tc.payload)
+			EnrichQUIC(r)
+			assert.Zero(t, r.QUICVersion)
+			assert.Empty(t, r.QUICDCID)
+			assert.Empty(t, r.QUICSCID)
+		})
+	}
+}
+
+func TestQUICEnricher_DropPayloadSample(t *testing.T) {
+	for _, drop := range []bool{false, true} {
+		chain, err := NewEnrichers([]string{EnricherQUIC},
+			&EnricherContext{QUIC: &QUICConfig{DropPayloadSample: drop}})
+		require.NoError(t, err)
+		r := quicFlow(t, syscall.IPPROTO_UDP, quicClientInitial)
+		chain[0].Enrich(r)
+		assert.EqualValues(t, 1, r.QUICVersion)
+		assert.Equal(t, drop, r.PayloadSample == nil)
+	}
+}
@@ -0,0 +1,38 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// HexBytes is a byte slice that is encoded as a hexadecimal string in JSON
+type HexBytes []byte
+
+func (h HexBytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(hex.EncodeToString(h))
+}
+
+// AttachRawBpf receives flows and attaches to them the binary encoding of their eBPF flow
+// identifier and metrics, as read from the eBPF maps, before the next processing stages modify
+// them. It allows comparing the captured data with the decorated fields of the exported flows.
+func AttachRawBpf(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		for _, record := range records {
+			record.RawBpfID = encodeRaw(&record.Id)
+			record.RawBpfMetrics = encodeRaw(&record.Metrics)
+		}
+		out <- records
+	}
+}
+
+// encodeRaw encodes the eBPF structs in LittleEndian order, as ReadFrom reads them
+func encodeRaw(data interface{}) HexBytes {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, data); err != nil {
+		// can't happen, as the eBPF structs only contain fixed-size fields
+		panic(err)
+	}
+	return buf.Bytes()
+}
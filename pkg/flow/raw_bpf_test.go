@@ -0,0 +1,66 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestAttachRawBpf(t *testing.T) {
+	record := &Record{RawRecord: RawRecord{
+		Id: ebpf.BpfFlowId{
+			EthProtocol:       0x0800,
+			Direction:         DirectionEgress,
+			SrcIp:             IPAddr{0: 10, 15: 2},
+			DstIp:             IPAddr{0: 10, 15: 1},
+			SrcPort:           34567,
+			DstPort:           443,
+			TransportProtocol: 6,
+			IfIndex:           3,
+		},
+		Metrics: ebpf.BpfFlowMetrics{Packets: 3, Bytes: 456, StartMonoTimeTs: 123, EndMonoTimeTs: 789},
+	}}
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go AttachRawBpf(in, out)
+	defer close(in)
+	in <- []*Record{record}
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 1)
+
+	// a later stage modifies the decorated fields
+	expectedID, expectedMetrics := record.Id, record.Metrics
+	require.True(t, NormalizeOrientation(&record.Id))
+
+	// the raw fields keep the values read from the eBPF maps
+	var rawID ebpf.BpfFlowId
+	require.NoError(t, binary.Read(bytes.NewReader(record.RawBpfID), binary.LittleEndian, &rawID))
+	assert.Equal(t, expectedID, rawID)
+	var rawMetrics ebpf.BpfFlowMetrics
+	require.NoError(t,
+		binary.Read(bytes.NewReader(record.RawBpfMetrics), binary.LittleEndian, &rawMetrics))
+	assert.Equal(t, expectedMetrics, rawMetrics)
+
+	// the raw fields are hex-encoded in JSON
+	encoded, err := json.Marshal(record)
+	require.NoError(t, err)
+	var decoded struct {
+		RawBpfID      string
+		RawBpfMetrics string
+	}
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, hex.EncodeToString(record.RawBpfID), decoded.RawBpfID)
+	assert.Equal(t, hex.EncodeToString(record.RawBpfMetrics), decoded.RawBpfMetrics)
+
+	// they are omitted if not attached
+	encoded, err = json.Marshal(&Record{})
+	require.NoError(t, err)
+	assert.NotContains(t, string(encoded), "RawBpf")
+}
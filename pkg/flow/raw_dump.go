@@ -0,0 +1,103 @@
+package flow
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/sirupsen/logrus"
+)
+
+var rawlog = logrus.WithField("component", "flow.RawRecordDumper")
+
+// rawFrameHeaderLen is the length of the header that precedes each raw event in a dump file:
+// the length of the event, as a little-endian uint32
+const rawFrameHeaderLen = 4
+
+// RawRecordDumper wraps a ring buffer reader, writing to a file the raw bytes of each event, as
+// they were produced by the kernel, before they are parsed. The dump file can be read back with
+// a RawRecordReplayReader, to reproduce the processing of the events offline. The dump stops when
+// the file would exceed the maximum size.
+type RawRecordDumper struct {
+	reader   ringBufReader
+	file     *os.File
+	maxBytes int64
+	written  int64
+	// stopped is set when the dump has reached the maximum size or failed writing
+	stopped bool
+}
+
+// NewRawRecordDumper creates a RawRecordDumper that writes the events read from the provided
+// reader to the file in the path, which is truncated if it already exists. If maxBytes is 0, the
+// size of the dump is unbounded.
+func NewRawRecordDumper(reader ringBufReader, path string, maxBytes int64) (*RawRecordDumper, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("creating raw record dump file: %w", err)
+	}
+	return &RawRecordDumper{reader: reader, file: file, maxBytes: maxBytes}, nil
+}
+
+// ReadRingBuf reads the next event from the wrapped reader and dumps it before returning it
+func (d *RawRecordDumper) ReadRingBuf() (ringbuf.Record, error) {
+	event, err := d.reader.ReadRingBuf()
+	if err == nil && !d.stopped {
+		d.dump(event.RawSample)
+	}
+	return event, err
+}
+
+func (d *RawRecordDumper) dump(sample []byte) {
+	frameLen := int64(rawFrameHeaderLen + len(sample))
+	if d.maxBytes > 0 && d.written+frameLen > d.maxBytes {
+		rawlog.WithField("maxBytes", d.maxBytes).
+			Warn("raw record dump file reached its maximum size. Stopping the dump")
+		d.stopped = true
+		return
+	}
+	frame := make([]byte, frameLen)
+	binary.LittleEndian.PutUint32(frame, uint32(len(sample)))
+	copy(frame[rawFrameHeaderLen:], sample)
+	if _, err := d.file.Write(frame); err != nil {
+		rawlog.WithError(err).Warn("can't write the raw record dump file. Stopping the dump")
+		d.stopped = true
+		return
+	}
+	d.written += frameLen
+}
+
+// Close the dump file
+func (d *RawRecordDumper) Close() error {
+	return d.file.Close()
+}
+
+// RawRecordReplayReader reads the events from a raw record dump, as if they were read from the
+// ring buffer, so they can be replayed through a RingBufTracer. When all the events have been
+// read, it returns ringbuf.ErrClosed.
+type RawRecordReplayReader struct {
+	reader io.Reader
+}
+
+// NewRawRecordReplayReader creates a RawRecordReplayReader from the contents of a dump file
+func NewRawRecordReplayReader(reader io.Reader) *RawRecordReplayReader {
+	return &RawRecordReplayReader{reader: reader}
+}
+
+// ReadRingBuf returns the next event of the dump
+func (r *RawRecordReplayReader) ReadRingBuf() (ringbuf.Record, error) {
+	var header [rawFrameHeaderLen]byte
+	if _, err := io.ReadFull(r.reader, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return ringbuf.Record{}, ringbuf.ErrClosed
+		}
+		return ringbuf.Record{}, fmt.Errorf("reading raw record header: %w", err)
+	}
+	sample := make([]byte, binary.LittleEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r.reader, sample); err != nil {
+		return ringbuf.Record{}, fmt.Errorf("reading raw record: %w", err)
+	}
+	return ringbuf.Record{RawSample: sample}, nil
+}
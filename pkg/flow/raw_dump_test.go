@@ -0,0 +1,93 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestRawRecordDump_Replay(t *testing.T) {
+	rawSample := func(srcPort uint16, size uint64) []byte {
+		rec := RawRecord{}
+		rec.Id.SrcPort = srcPort
+		rec.Metrics.Packets = 1
+		rec.Metrics.Bytes = size
+		buf := bytes.Buffer{}
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, &rec))
+		return buf.Bytes()
+	}
+	samples := [][]byte{rawSample(1234, 100), rawSample(5678, 200)}
+
+	// GIVEN a ring buffer whose events are recorded by a dumper
+	reader := &ringBufFake{events: make(chan ringBufEvent, 10)}
+	for _, sample := range samples {
+		reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: sample}}
+	}
+	close(reader.events)
+	dumpFile := path.Join(t.TempDir(), "raw.dump")
+	dumper, err := NewRawRecordDumper(reader, dumpFile, 0)
+	require.NoError(t, err)
+
+	// WHEN the events are read through the dumper
+	for _, sample := range samples {
+		event, err := dumper.ReadRingBuf()
+		require.NoError(t, err)
+		assert.Equal(t, sample, event.RawSample)
+	}
+	require.NoError(t, dumper.Close())
+
+	// THEN the replay reader returns the exact same raw events
+	dump, err := os.ReadFile(dumpFile)
+	require.NoError(t, err)
+	replay := NewRawRecordReplayReader(bytes.NewReader(dump))
+	for _, sample := range samples {
+		event, err := replay.ReadRingBuf()
+		require.NoError(t, err)
+		assert.Equal(t, sample, event.RawSample)
+	}
+	_, err = replay.ReadRingBuf()
+	assert.ErrorIs(t, err, ringbuf.ErrClosed)
+
+	// AND they can be replayed through a ring buffer tracer
+	tracer := NewRingBufTracer(NewRawRecordReplayReader(bytes.NewReader(dump)),
+		flusherFake{}, time.Minute, MapFullSpill, 1, 1, metrics.NoOp())
+	out := make(chan *RawRecord, 10)
+	tracer.TraceLoop(context.Background())(out)
+	require.Len(t, out, 2)
+	first, second := <-out, <-out
+	assert.EqualValues(t, 1234, first.Id.SrcPort)
+	assert.EqualValues(t, 100, first.Metrics.Bytes)
+	assert.EqualValues(t, 5678, second.Id.SrcPort)
+	assert.EqualValues(t, 200, second.Metrics.Bytes)
+}
+
+func TestRawRecordDump_MaxBytes(t *testing.T) {
+	reader := &ringBufFake{events: make(chan ringBufEvent, 10)}
+	for i := 0; i < 3; i++ {
+		reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: []byte{1, 2, 3, 4, 5, 6}}}
+	}
+	close(reader.events)
+	dumpFile := path.Join(t.TempDir(), "raw.dump")
+	// room for two events only
+	dumper, err := NewRawRecordDumper(reader, dumpFile, 25)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := dumper.ReadRingBuf()
+		require.NoError(t, err)
+	}
+	require.NoError(t, dumper.Close())
+
+	dump, err := os.ReadFile(dumpFile)
+	require.NoError(t, err)
+	assert.Len(t, dump, 20)
+}
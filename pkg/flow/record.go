@@ -4,7 +4,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"syscall"
 	"time"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
@@ -14,11 +16,25 @@ import (
 const (
 	DirectionIngress = uint8(0)
 	DirectionEgress  = uint8(1)
+	// DirectionUnknown is reported when the direction inference is disabled
+	DirectionUnknown = uint8(2)
 )
 const MacLen = 6
 
-// IPv6Type value as defined in IEEE 802: https://www.iana.org/assignments/ieee-802-numbers/ieee-802-numbers.xhtml
-const IPv6Type = 0x86DD
+// SchemaVersion is the version of the schema of the exported records. It must be bumped once
+// per release whose exported fields change (e.g. a field is added, removed or changes its
+// meaning), as the consumers only see the released versions.
+const SchemaVersion = 1
+
+// IPv4Type and IPv6Type values as defined in IEEE 802: https://www.iana.org/assignments/ieee-802-numbers/ieee-802-numbers.xhtml
+const (
+	IPv4Type = 0x0800
+	IPv6Type = 0x86DD
+)
+
+// ipgUnset is the minimum inter-packet gap that the eBPF program reports for the flows without
+// gaps (IPG_UNSET in bpf/flow.h)
+const ipgUnset = math.MaxUint64
 
 type HumanBytes uint64
 type MacAddr [MacLen]uint8
@@ -40,6 +56,9 @@ type Record struct {
 	TimeFlowStart time.Time
 	TimeFlowEnd   time.Time
 	Interface     string
+	// InterfaceID identifies the interface of the flow across the cluster, qualifying its
+	// index by the node name (see QualifiedInterfaceID), if the interface ID enricher is enabled
+	InterfaceID string
 	// Duplicate tells whether this flow has another duplicate so it has to be excluded from
 	// any metrics' aggregation (e.g. bytes/second rates between two pods).
 	// The reason for this field is that the same flow can be observed from multiple interfaces,
@@ -50,6 +69,153 @@ type Record struct {
 
 	// AgentIP provides information about the source of the flow (the Agent that traced it)
 	AgentIP net.IP
+
+	// PayloadSample contains the first bytes of the transport-layer payload of the flow, if
+	// payload sampling is enabled and the flow matches the sampling criteria. It is encoded as
+	// base64 in JSON.
+	PayloadSample []byte
+
+	// TCPState is the state of the TCP connection the flow belongs to, if the TCP state
+	// enricher is enabled
+	TCPState TCPState
+
+	// Service is the name of the well-known service associated to the destination port of the
+	// flow, if the service enricher is enabled
+	Service string
+
+	// EndReason tells why the flow record has been exported
+	EndReason FlowEndReason
+
+	// ServerConnectLatency is the time between a TCP SYN and the SYN-ACK answering it. It is only
+	// set in the server-to-client flow carrying the SYN-ACK, if both packets have been observed
+	ServerConnectLatency time.Duration
+
+	// MinIPG, MaxIPG and MeanIPG are the minimum, maximum and mean inter-packet gaps: the times
+	// between two consecutive packets of the flow. They are only set for flows with more than one
+	// packet.
+	MinIPG  time.Duration
+	MaxIPG  time.Duration
+	MeanIPG time.Duration
+
+	// CgroupID is the cgroup v2 identifier of the local socket that sent or received the flow
+	// packets. It allows attributing the flow to a container by looking up the cgroup path,
+	// also for host-network pods. Zero if the packets aren't associated to a local socket.
+	CgroupID uint64
+
+	// ContainerID and PodUID identify the container and the pod of the local socket of the flow,
+	// as resolved from CgroupID, if the container enricher is enabled. They are empty if the
+	// cgroup doesn't belong to a container or a pod.
+	ContainerID string
+	PodUID      string
+
+	// SrcNamespace, SrcPod, DstNamespace and DstPod are the namespaces and names of the pods of
+	// the source and destination addresses, if the Kubernetes enricher is enabled. They are empty
+	// if the address doesn't belong to a pod (or to a host-network pod).
+	SrcNamespace string
+	SrcPod       string
+	DstNamespace string
+	DstPod       string
+
+	// SrcCountry and DstCountry are the ISO 3166-1 country codes of the source and destination
+	// addresses, if the GeoIP enricher is enabled. They are empty if the address isn't found in
+	// the GeoIP database.
+	SrcCountry string
+	DstCountry string
+
+	// ConnectionID identifies the connection the flow belongs to, so the flows from both
+	// directions or from different interfaces of the same connection can be correlated. See
+	// ConnectionID function.
+	ConnectionID uint64
+
+	// PolicyVerdict tells whether the flow was allowed or denied by a network policy. The agent
+	// doesn't observe the policy decisions by itself, so it is PolicyVerdictUnknown unless a
+	// custom enricher provides it.
+	PolicyVerdict PolicyVerdict
+
+	// ClusterID and TenantID identify the cluster and the tenant where the flow has been
+	// observed, so the flows from multiple clusters or tenants can be told apart once they land
+	// in the same collector
+	ClusterID string
+	TenantID  string
+
+	// AgentVersion and BpfProgHash identify the build of the agent and of its eBPF program that
+	// traced the flow, if the build info enricher is enabled
+	AgentVersion string
+	BpfProgHash  string
+
+	// SrcHostname and DstHostname are the hostnames of the external source and destination
+	// addresses, if the reverse DNS enricher is enabled and they have been already resolved
+	SrcHostname string
+	DstHostname string
+
+	// SrcLabels and DstLabels are the labels (e.g. team, environment) of the most specific
+	// subnets containing the source and destination addresses, if the subnet labels enricher is
+	// enabled. They are shared between records, so they must not be modified.
+	SrcLabels map[string]string `json:",omitempty"`
+	DstLabels map[string]string `json:",omitempty"`
+
+	// SubFlowCount is the number of distinct flows that have been merged into this record (e.g.
+	// when the flows are keyed by service port). It is 1 for the records that aggregate a single
+	// flow.
+	SubFlowCount uint32
+
+	// EchoRTT, EchoReplyPackets and EchoReplyBytes are only set in the ICMP echo request flows
+	// that have been merged with their echo reply flows. EchoRTT is the time between the first
+	// request packet and the first reply packet, and EchoReplyPackets and EchoReplyBytes are the
+	// traffic of the reply flow, which is also accounted in the flow metrics.
+	EchoRTT          time.Duration
+	EchoReplyPackets uint32
+	EchoReplyBytes   uint64
+
+	// TrafficClass tells whether the flow is unicast, multicast or broadcast, if the traffic
+	// class enricher is enabled
+	TrafficClass TrafficClass
+
+	// RawBpfID and RawBpfMetrics are the binary encoding of the flow identifier and metrics, as
+	// they were read from the eBPF maps, before any processing stage modified them. They are only
+	// set for debugging purposes, if the agent is configured to include them.
+	RawBpfID      HexBytes `json:",omitempty"`
+	RawBpfMetrics HexBytes `json:",omitempty"`
+
+	// SchemaVersion is the version of the schema of the exported records, so the consumers can
+	// tell which fields they are parsing. It is always SchemaVersion for the records created by
+	// the agent.
+	SchemaVersion uint32
+
+	// ScanSuspect tells whether the source of the flow has hit many distinct destination ports
+	// in a short time, so it might be scanning the ports of the network, if the port scan
+	// detection is enabled
+	ScanSuspect bool
+
+	// ObservedPorts is the sequence of distinct destination ports that the source of the record
+	// has hit within the port scan window, in the order they were first hit. It is only set in
+	// the per-source records whose EndReason is FlowEndReasonPortScanSummary, if the observed
+	// ports are recorded. It is bounded, and ObservedPortsOverflow counts the distinct ports that
+	// didn't fit.
+	ObservedPorts         []uint16 `json:",omitempty"`
+	ObservedPortsOverflow uint32
+
+	// PID and Comm are the process identifier and name of the process that owns the local
+	// socket of the flow, if the process enricher is enabled. They are empty for the forwarded
+	// or transit flows, whose packets don't belong to a local socket.
+	PID  uint32
+	Comm string
+
+	// RecordID is a deterministic identifier of the record, if record identifiers are enabled,
+	// so the collectors can upsert the records that are exported more than once. See RecordID.
+	RecordID uint64
+
+	// NextHop is the gateway of the local route towards the destination of the flow, if the
+	// next hop enricher is enabled. It is empty if no route matches the destination, or if the
+	// destination is directly reachable.
+	NextHop net.IP
+
+	// QUICVersion, QUICDCID and QUICSCID are the version, and the destination and source
+	// connection IDs, of the QUIC long header at the start of the payload sample of the flow, if
+	// QUIC tracking is enabled. QUICVersion is 0 if the flow isn't recognized as QUIC.
+	QUICVersion uint32
+	QUICDCID    HexBytes `json:",omitempty"`
+	QUICSCID    HexBytes `json:",omitempty"`
 }
 
 func NewRecord(
@@ -60,16 +226,128 @@ func NewRecord(
 ) *Record {
 	startDelta := time.Duration(monotonicCurrentTime - metrics.StartMonoTimeTs)
 	endDelta := time.Duration(monotonicCurrentTime - metrics.EndMonoTimeTs)
-	return &Record{
+	record := &Record{
+		SchemaVersion: SchemaVersion,
 		RawRecord: RawRecord{
 			Id:      key,
 			Metrics: metrics,
 		},
 		TimeFlowStart: currentTime.Add(-startDelta),
 		TimeFlowEnd:   currentTime.Add(-endDelta),
+		CgroupID:      metrics.CgroupId,
+		ConnectionID:  ConnectionID(&key),
+		SubFlowCount:  1,
+	}
+	if metrics.PayloadSampleLen > 0 {
+		// never trust the length reported by the kernel space beyond the array capacity
+		sampleLen := int(metrics.PayloadSampleLen)
+		if sampleLen > len(metrics.PayloadSample) {
+			sampleLen = len(metrics.PayloadSample)
+		}
+		record.PayloadSample = make([]byte, sampleLen)
+		copy(record.PayloadSample, metrics.PayloadSample[:sampleLen])
+	}
+	if key.TransportProtocol == syscall.IPPROTO_TCP {
+		record.ServerConnectLatency = time.Duration(metrics.ServerConnectLatency)
+	}
+	if metrics.Packets > 1 && metrics.EndMonoTimeTs > metrics.StartMonoTimeTs {
+		// the minimum gap is unset if the flow packets were accounted before the flow entry
+		// was created in the eBPF map, without gaps between them
+		if metrics.MinIpg != ipgUnset {
+			record.MinIPG = time.Duration(metrics.MinIpg)
+		}
+		record.MaxIPG = time.Duration(metrics.MaxIpg)
+		record.MeanIPG = time.Duration(metrics.EndMonoTimeTs-metrics.StartMonoTimeTs) /
+			time.Duration(metrics.Packets-1)
+	}
+	return record
+}
+
+// FlowEndReason tells why a flow record has been evicted from the agent caches and exported
+type FlowEndReason uint8
+
+const (
+	// FlowEndReasonEviction means that the flow has been evicted from the agent caches as part
+	// of the normal accounting process (e.g. on active timeout, or because the cache is full)
+	FlowEndReasonEviction FlowEndReason = iota
+	// FlowEndReasonLifetimeCap means that the flow has been exported because it exceeded the
+	// maximum flow lifetime. The next packets of the flow are accounted in a continuation record.
+	FlowEndReasonLifetimeCap
+	// FlowEndReasonHeartbeat means that the record is not a flow, but a heartbeat that is
+	// periodically exported to signal that the agent is alive (see NewHeartbeat)
+	FlowEndReasonHeartbeat
+	// FlowEndReasonTCPClose means that the flow has been exported shortly after its TCP
+	// connection was closed (FIN or RST observed), without waiting for the eviction timeout
+	FlowEndReasonTCPClose
+	// FlowEndReasonIfaceRemoved means that the flow has been exported because the interface where
+	// it was observed has been removed (e.g. the pod was deleted), so no more packets are expected
+	FlowEndReasonIfaceRemoved
+	// FlowEndReasonPortScanSummary means that the record is not a flow, but the aggregated
+	// record of a source that is exported at the end of each port scan window, carrying its
+	// ObservedPorts
+	FlowEndReasonPortScanSummary
+)
+
+func (r FlowEndReason) String() string {
+	switch r {
+	case FlowEndReasonEviction:
+		return "eviction"
+	case FlowEndReasonLifetimeCap:
+		return "lifetime-cap"
+	case FlowEndReasonHeartbeat:
+		return "heartbeat"
+	case FlowEndReasonTCPClose:
+		return "tcp-close"
+	case FlowEndReasonIfaceRemoved:
+		return "iface-removed"
+	case FlowEndReasonPortScanSummary:
+		return "port-scan-summary"
+	default:
+		return "invalid"
+	}
+}
+
+func (r FlowEndReason) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + r.String() + `"`), nil
+}
+
+func (r FlowEndReason) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// PolicyVerdict is the decision of a network policy about a flow
+type PolicyVerdict uint8
+
+const (
+	// PolicyVerdictUnknown means that no policy decision is available for the flow
+	PolicyVerdictUnknown PolicyVerdict = iota
+	// PolicyVerdictAllowed means that the flow was allowed by a network policy
+	PolicyVerdictAllowed
+	// PolicyVerdictDenied means that the flow was denied by a network policy
+	PolicyVerdictDenied
+)
+
+func (v PolicyVerdict) String() string {
+	switch v {
+	case PolicyVerdictUnknown:
+		return "unknown"
+	case PolicyVerdictAllowed:
+		return "allowed"
+	case PolicyVerdictDenied:
+		return "denied"
+	default:
+		return "invalid"
 	}
 }
 
+func (v PolicyVerdict) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + v.String() + `"`), nil
+}
+
+func (v PolicyVerdict) MarshalText() ([]byte, error) {
+	return []byte(v.String()), nil
+}
+
 // IP returns the net.IP equivalent object
 func IP(ia IPAddr) net.IP {
 	return ia[:]
@@ -0,0 +1,36 @@
+package flow
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// RecordID returns a deterministic identifier of the flow record, so the collectors that upsert
+// the records can use it as their unique key, and the batches that are exported more than once
+// (e.g. retried after a partial failure) replace the previously stored records instead of
+// duplicating them. It is the 64-bit FNV-1a hash of:
+//   - the flow key (the Id field, encoded as big endian, in the order of its fields),
+//   - the start of the record window (TimeFlowStart, as nanoseconds since the Unix epoch),
+//   - and the agent IP, if set, so the same flow observed by different agents gets different
+//     identifiers.
+//
+// The records of the same flow in different eviction windows get different identifiers.
+func RecordID(record *Record) uint64 {
+	h := fnv.New64a()
+	_ = binary.Write(h, binary.BigEndian, &record.Id)
+	var start [8]byte
+	binary.BigEndian.PutUint64(start[:], uint64(record.TimeFlowStart.UnixNano()))
+	_, _ = h.Write(start[:])
+	_, _ = h.Write(record.AgentIP.To16())
+	return h.Sum64()
+}
+
+// SetRecordIDs sets the RecordID field of the records
+func SetRecordIDs(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		for _, record := range records {
+			record.RecordID = RecordID(record)
+		}
+		out <- records
+	}
+}
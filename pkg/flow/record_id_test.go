@@ -0,0 +1,63 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestRecordID(t *testing.T) {
+	start := time.Date(2023, 5, 4, 3, 2, 1, 123456789, time.UTC)
+	newRecord := func() *Record {
+		r := &Record{
+			RawRecord: RawRecord{
+				Id:      ebpf.BpfFlowId{SrcPort: 1234, DstPort: 443, TransportProtocol: 6, IfIndex: 3},
+				Metrics: ebpf.BpfFlowMetrics{Packets: 3, Bytes: 300},
+			},
+			TimeFlowStart: start,
+			TimeFlowEnd:   start.Add(time.Second),
+			AgentIP:       net.ParseIP("10.0.0.1"),
+		}
+		copy(r.Id.SrcIp[:], net.ParseIP("10.1.1.1").To16())
+		copy(r.Id.DstIp[:], net.ParseIP("10.2.2.2").To16())
+		return r
+	}
+
+	// GIVEN a record that is exported several times (e.g. in a retried batch)
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go SetRecordIDs(in, out)
+	defer close(in)
+	first := newRecord()
+	retried := newRecord()
+	// the metrics and the rest of fields don't change the identifier of the logical record
+	retried.Metrics.Bytes = 400
+	retried.Interface = "eth0"
+	in <- []*Record{first}
+	in <- []*Record{retried}
+	firstID := receiveTimeout(t, out)[0].RecordID
+	retriedID := receiveTimeout(t, out)[0].RecordID
+
+	// THEN it always gets the same identifier
+	require.NotZero(t, firstID)
+	assert.Equal(t, firstID, retriedID)
+	assert.Equal(t, firstID, RecordID(newRecord()))
+
+	// AND the records of another window, flow or agent get different identifiers
+	nextWindow := newRecord()
+	nextWindow.TimeFlowStart = start.Add(5 * time.Second)
+	otherFlow := newRecord()
+	otherFlow.Id.SrcPort = 1235
+	otherAgent := newRecord()
+	otherAgent.AgentIP = net.ParseIP("10.0.0.2")
+	ids := map[uint64]struct{}{firstID: {}}
+	for _, r := range []*Record{nextWindow, otherFlow, otherAgent} {
+		ids[RecordID(r)] = struct{}{}
+	}
+	assert.Len(t, ids, 4)
+}
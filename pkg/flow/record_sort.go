@@ -0,0 +1,154 @@
+package flow
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// RecordSorter sorts the records of each batch by a list of keys, so the collectors receive
+// them in a deterministic order
+type RecordSorter struct {
+	keys []sortKey
+}
+
+type sortKey struct {
+	// index of the field, as accepted by reflect.Value.FieldByIndex
+	index      []int
+	compare    func(a, b reflect.Value) int
+	descending bool
+}
+
+// NewRecordSorter returns a RecordSorter that sorts the records by the provided keys, in
+// order of precedence. The keys are named as the fields of a FieldProjection (e.g. Id.SrcIp or
+// TimeFlowStart), and are sorted in ascending order, unless they are prefixed by "-". Only the
+// fields of numeric, string, boolean, time, IP, MAC or byte types can be keys.
+func NewRecordSorter(keys []string) (*RecordSorter, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no sort keys provided")
+	}
+	rs := &RecordSorter{}
+	for _, name := range keys {
+		name = strings.TrimSpace(name)
+		key := sortKey{}
+		if strings.HasPrefix(name, "-") {
+			key.descending = true
+			name = name[1:]
+		}
+		index, err := fieldIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		key.index = index
+		if key.compare = fieldComparator(recordType.FieldByIndex(index).Type); key.compare == nil {
+			return nil, fmt.Errorf("records can't be sorted by field %q", name)
+		}
+		rs.keys = append(rs.keys, key)
+	}
+	return rs, nil
+}
+
+// fieldComparator returns a function that compares two values of the provided type, or nil if
+// the type can't be compared
+func fieldComparator(typ reflect.Type) func(a, b reflect.Value) int {
+	if typ == timeType {
+		return func(a, b reflect.Value) int {
+			ta, tb := a.Interface().(time.Time), b.Interface().(time.Time)
+			switch {
+			case ta.Before(tb):
+				return -1
+			case ta.After(tb):
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	switch typ.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(a, b reflect.Value) int { return compareOrdered(a.Uint(), b.Uint()) }
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(a, b reflect.Value) int { return compareOrdered(a.Int(), b.Int()) }
+	case reflect.Float32, reflect.Float64:
+		return func(a, b reflect.Value) int { return compareOrdered(a.Float(), b.Float()) }
+	case reflect.String:
+		return func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) }
+	case reflect.Bool:
+		return func(a, b reflect.Value) int {
+			// false goes first
+			return compareOrdered(boolToInt(a.Bool()), boolToInt(b.Bool()))
+		}
+	case reflect.Array:
+		if typ.Elem().Kind() != reflect.Uint8 {
+			return nil
+		}
+		// IP and MAC addresses of the flow identifier
+		return func(a, b reflect.Value) int {
+			for i := 0; i < a.Len(); i++ {
+				if cmp := compareOrdered(a.Index(i).Uint(), b.Index(i).Uint()); cmp != 0 {
+					return cmp
+				}
+			}
+			return 0
+		}
+	case reflect.Slice:
+		if typ.Elem().Kind() != reflect.Uint8 {
+			return nil
+		}
+		// IP addresses of the enrichers, and raw bytes
+		return func(a, b reflect.Value) int { return bytes.Compare(a.Bytes(), b.Bytes()) }
+	default:
+		return nil
+	}
+}
+
+func compareOrdered[T uint64 | int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Sort forwards each batch of records sorted by the configured keys. The records with equal
+// keys keep their relative order.
+func (rs *RecordSorter) Sort(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		rs.sortRecords(records)
+		out <- records
+	}
+}
+
+// sortRecords sorts a batch of records in place
+func (rs *RecordSorter) sortRecords(records []*Record) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := reflect.ValueOf(records[i]).Elem(), reflect.ValueOf(records[j]).Elem()
+		for k := range rs.keys {
+			key := &rs.keys[k]
+			cmp := key.compare(a.FieldByIndex(key.index), b.FieldByIndex(key.index))
+			if cmp == 0 {
+				continue
+			}
+			if key.descending {
+				return cmp > 0
+			}
+			return cmp < 0
+		}
+		return false
+	})
+}
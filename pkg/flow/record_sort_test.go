@@ -0,0 +1,69 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sortFlow(src, dst string, bytes uint64) *Record {
+	r := subnetLabelsRecord(src, dst)
+	r.Metrics.Bytes = bytes
+	return r
+}
+
+func TestRecordSorter_Sort(t *testing.T) {
+	sorter, err := NewRecordSorter([]string{"Id.SrcIp", " Id.DstIp", "-Metrics.Bytes"})
+	require.NoError(t, err)
+
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go sorter.Sort(in, out)
+	defer close(in)
+
+	in <- []*Record{
+		sortFlow("10.0.0.2", "10.0.0.1", 10),
+		sortFlow("10.0.0.1", "192.168.0.1", 10),
+		sortFlow("10.0.0.1", "10.0.0.3", 10),
+		sortFlow("10.0.0.1", "10.0.0.3", 30),
+		sortFlow("9.0.0.1", "10.0.0.1", 10),
+	}
+	sorted := receiveTimeout(t, out)
+	var keys []string
+	for _, r := range sorted {
+		keys = append(keys, fmt.Sprintf("%s>%s:%d",
+			IP(r.Id.SrcIp), IP(r.Id.DstIp), r.Metrics.Bytes))
+	}
+	assert.Equal(t, []string{
+		"9.0.0.1>10.0.0.1:10",
+		"10.0.0.1>10.0.0.3:30",
+		"10.0.0.1>10.0.0.3:10",
+		"10.0.0.1>192.168.0.1:10",
+		"10.0.0.2>10.0.0.1:10",
+	}, keys)
+}
+
+func TestRecordSorter_FieldTypes(t *testing.T) {
+	now := time.Now()
+	a := &Record{TimeFlowStart: now, Interface: "eth1", NextHop: net.ParseIP("10.0.0.2")}
+	b := &Record{TimeFlowStart: now.Add(-time.Second), Interface: "eth0",
+		NextHop: net.ParseIP("10.0.0.1"), Duplicate: true}
+	for _, key := range []string{"TimeFlowStart", "Interface", "NextHop", "-Duplicate"} {
+		sorter, err := NewRecordSorter([]string{key})
+		require.NoError(t, err, key)
+		records := []*Record{a, b}
+		sorter.sortRecords(records)
+		assert.Equal(t, []*Record{b, a}, records, key)
+	}
+}
+
+func TestNewRecordSorter_Errors(t *testing.T) {
+	for _, keys := range [][]string{nil, {"Unknown"}, {"Id"}, {"SrcLabels"}, {"-"}} {
+		_, err := NewRecordSorter(keys)
+		assert.Error(t, err, keys)
+	}
+}
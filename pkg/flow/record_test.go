@@ -2,7 +2,10 @@ package flow
 
 import (
 	"bytes"
+	"encoding/json"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -26,13 +29,36 @@ func TestRecordBinaryEncoding(t *testing.T) {
 		0x00,                   // icmp: u8 icmp_type
 		0x00,                   // icmp: u8 icmp_code
 		0x13, 0x14, 0x15, 0x16, // interface index
+		0x01,                   // u8 tunnel_type
+		0x00, 0x10, 0x00, 0x00, // u32 tunnel_vni
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x0a, 0xf4, 0x00, 0x05, // u8[16] inner_src_ip
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x0a, 0xf4, 0x01, 0x07, // u8[16] inner_dst_ip
+		0x40, 0x9c, // u16 inner_src_port
+		0x90, 0x1f, // u16 inner_dst_port
+		0x06,                   // u8 inner_transport_protocol
 		0x06, 0x07, 0x08, 0x09, // u32 packets
 		0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, // u64 bytes
 		0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, // u64 flow_start_time
 		0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, // u64 flow_end_time
 		0x13, 0x14, //flags
-		0x33, // u8 errno
-
+		0x33,                   // u8 errno
+		0x20,                   // u8 min_ttl
+		0x40,                   // u8 max_ttl
+		0x05, 0x00, 0x00, 0x00, // u32 fragmented_packets
+		0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x04, 0x01, 0x00, 0x00, // u32[4] pkt_size_buckets
+		0x40, 0x42, 0x0f, 0x00, 0x00, 0x00, 0x00, 0x00, // u64 server_connect_latency
+		0x21, 0x43, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // u64 cgroup_id
+		0x65, 0x87, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // u64 socket_cookie
+		0x10, 0x27, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // u64 min_ipg
+		0x40, 0x42, 0x0f, 0x00, 0x00, 0x00, 0x00, 0x00, // u64 max_ipg
+		0x02, 0x00, 0x00, 0x00, // u32 duplicate_packets
+		0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // u16[8] recent_ip_ids
+		0x03,       // u8 recent_ip_ids_next
+		0x03, 0x00, // u16 payload_sample_len
+		0xaa, 0xbb, 0xcc, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // u8[64] payload_sample
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
 	}))
 	require.NoError(t, err)
 
@@ -50,17 +76,186 @@ func TestRecordBinaryEncoding(t *testing.T) {
 			IcmpType:          0x00,
 			IcmpCode:          0x00,
 			IfIndex:           0x16151413,
+			// VXLAN-encapsulated flow
+			TunnelType:             1,
+			TunnelVni:              4096,
+			InnerSrcIp:             IPAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x0a, 0xf4, 0x00, 0x05},
+			InnerDstIp:             IPAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0x0a, 0xf4, 0x01, 0x07},
+			InnerSrcPort:           40000,
+			InnerDstPort:           8080,
+			InnerTransportProtocol: 6,
 		},
 		Metrics: ebpf.BpfFlowMetrics{
-			Packets:         0x09080706,
-			Bytes:           0x1a19181716151413,
-			StartMonoTimeTs: 0x1a19181716151413,
-			EndMonoTimeTs:   0x1a19181716151413,
-			Flags:           0x1413,
-			Errno:           0x33,
+			Packets:              0x09080706,
+			Bytes:                0x1a19181716151413,
+			StartMonoTimeTs:      0x1a19181716151413,
+			EndMonoTimeTs:        0x1a19181716151413,
+			Flags:                0x1413,
+			Errno:                0x33,
+			MinTtl:               0x20,
+			MaxTtl:               0x40,
+			FragmentedPackets:    5,
+			PktSizeBuckets:       [4]uint32{1, 2, 3, 0x104},
+			ServerConnectLatency: 1_000_000,
+			CgroupId:             0x4321,
+			SocketCookie:         0x8765,
+			MinIpg:               10_000,
+			MaxIpg:               1_000_000,
+			DuplicatePackets:     2,
+			RecentIpIds:          [8]uint16{1, 2, 3},
+			RecentIpIdsNext:      3,
+			PayloadSampleLen:     3,
+			PayloadSample:        [64]uint8{0xaa, 0xbb, 0xcc},
 		},
 	}, *fr)
 	// assert that IP addresses are interpreted as IPv4 addresses
 	assert.Equal(t, "6.7.8.9", IP(fr.Id.SrcIp).String())
 	assert.Equal(t, "10.11.12.13", IP(fr.Id.DstIp).String())
+	assert.Equal(t, TunnelVXLAN, (&Record{RawRecord: *fr}).Tunnel())
+	assert.Equal(t, "10.244.0.5", IP(fr.Id.InnerSrcIp).String())
+	assert.Equal(t, "10.244.1.7", IP(fr.Id.InnerDstIp).String())
+}
+
+func TestNewRecord_PayloadSample(t *testing.T) {
+	now := time.Now()
+	t.Run("no payload sampled", func(t *testing.T) {
+		r := NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{Packets: 1}, now, 1000)
+		assert.Nil(t, r.PayloadSample)
+	})
+	t.Run("payload sampled", func(t *testing.T) {
+		metrics := ebpf.BpfFlowMetrics{PayloadSampleLen: 4}
+		copy(metrics.PayloadSample[:], "GET /index.html")
+		r := NewRecord(ebpf.BpfFlowId{}, metrics, now, 1000)
+		assert.Equal(t, []byte("GET "), r.PayloadSample)
+
+		// payload is encoded as base64 in JSON
+		encoded, err := json.Marshal(r)
+		require.NoError(t, err)
+		assert.Contains(t, string(encoded), `"PayloadSample":"R0VUIA=="`)
+	})
+	t.Run("length is capped to the maximum sample size", func(t *testing.T) {
+		metrics := ebpf.BpfFlowMetrics{PayloadSampleLen: 1000}
+		r := NewRecord(ebpf.BpfFlowId{}, metrics, now, 1000)
+		assert.Len(t, r.PayloadSample, ebpf.MaxPayloadSampleBytes)
+	})
+}
+
+func TestNewRecord_ServerConnectLatency(t *testing.T) {
+	now := time.Now()
+	client := ebpf.BpfFlowId{
+		TransportProtocol: syscall.IPPROTO_TCP,
+		SrcIp:             IPAddr{0: 0x0a, 15: 0x01},
+		DstIp:             IPAddr{0: 0x0a, 15: 0x02},
+		SrcPort:           34567,
+		DstPort:           80,
+	}
+	server := ebpf.BpfFlowId{
+		TransportProtocol: syscall.IPPROTO_TCP,
+		SrcIp:             client.DstIp,
+		DstIp:             client.SrcIp,
+		SrcPort:           client.DstPort,
+		DstPort:           client.SrcPort,
+	}
+	// the kernel space only sets the latency in the flow carrying the SYN-ACK
+	syn := NewRecord(client, ebpf.BpfFlowMetrics{Packets: 1, Flags: TCPFlagSYN}, now, 1000)
+	synAck := NewRecord(server, ebpf.BpfFlowMetrics{
+		Packets: 1, Flags: TCPFlagSYNACK, ServerConnectLatency: 1_500_000,
+	}, now, 1000)
+	assert.Zero(t, syn.ServerConnectLatency)
+	assert.Equal(t, 1500*time.Microsecond, synAck.ServerConnectLatency)
+
+	// the latency is ignored for non-TCP flows
+	udp := server
+	udp.TransportProtocol = syscall.IPPROTO_UDP
+	r := NewRecord(udp, ebpf.BpfFlowMetrics{ServerConnectLatency: 1_500_000}, now, 1000)
+	assert.Zero(t, r.ServerConnectLatency)
+}
+
+func TestNewRecord_CgroupID(t *testing.T) {
+	now := time.Now()
+	r := NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{Packets: 1, CgroupId: 0x1234}, now, 1000)
+	assert.EqualValues(t, 0x1234, r.CgroupID)
+
+	// packets not associated to a local socket
+	r = NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{Packets: 1}, now, 1000)
+	assert.Zero(t, r.CgroupID)
+}
+
+func TestNewRecord_InterPacketGaps(t *testing.T) {
+	// GIVEN a flow whose packets arrived at 0, 10, 40 and 50 ms
+	r := NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{
+		Packets:         4,
+		StartMonoTimeTs: uint64(time.Second),
+		EndMonoTimeTs:   uint64(time.Second + 50*time.Millisecond),
+		MinIpg:          uint64(10 * time.Millisecond),
+		MaxIpg:          uint64(30 * time.Millisecond),
+	}, time.Now(), uint64(2*time.Second))
+	// THEN the record reports the minimum, maximum and mean gaps between them
+	assert.Equal(t, 10*time.Millisecond, r.MinIPG)
+	assert.Equal(t, 30*time.Millisecond, r.MaxIPG)
+	assert.Equal(t, 50*time.Millisecond/3, r.MeanIPG)
+
+	// the unset minimum of the flows without tracked gaps is not reported
+	r = NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{
+		Packets:         3,
+		StartMonoTimeTs: uint64(time.Second),
+		EndMonoTimeTs:   uint64(time.Second + 20*time.Millisecond),
+		MinIpg:          ipgUnset,
+	}, time.Now(), uint64(2*time.Second))
+	assert.Zero(t, r.MinIPG)
+	assert.Zero(t, r.MaxIPG)
+
+	// single-packet flows have no gaps
+	r = NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{
+		Packets:         1,
+		StartMonoTimeTs: uint64(time.Second),
+		EndMonoTimeTs:   uint64(time.Second),
+	}, time.Now(), uint64(2*time.Second))
+	assert.Zero(t, r.MinIPG)
+	assert.Zero(t, r.MaxIPG)
+	assert.Zero(t, r.MeanIPG)
+}
+
+func TestMergeIPG(t *testing.T) {
+	record := func(packets uint32, minIPG, maxIPG, meanIPG time.Duration) *Record {
+		r := &Record{MinIPG: minIPG, MaxIPG: maxIPG, MeanIPG: meanIPG}
+		r.Metrics.Packets = packets
+		return r
+	}
+	// 3 gaps with a 10ms mean, and 1 gap of 50ms
+	dst := record(4, 5*time.Millisecond, 20*time.Millisecond, 10*time.Millisecond)
+	mergeIPG(dst, record(2, 50*time.Millisecond, 50*time.Millisecond, 50*time.Millisecond))
+	assert.Equal(t, 5*time.Millisecond, dst.MinIPG)
+	assert.Equal(t, 50*time.Millisecond, dst.MaxIPG)
+	assert.Equal(t, 20*time.Millisecond, dst.MeanIPG)
+
+	// single-packet records don't change the gaps
+	mergeIPG(dst, record(1, 0, 0, 0))
+	assert.Equal(t, 20*time.Millisecond, dst.MeanIPG)
+	single := record(1, 0, 0, 0)
+	mergeIPG(single, record(2, 50*time.Millisecond, 50*time.Millisecond, 50*time.Millisecond))
+	assert.Equal(t, 50*time.Millisecond, single.MeanIPG)
+}
+
+func TestNewRecord_SubFlowCount(t *testing.T) {
+	r := NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{Packets: 1}, time.Now(), 1000)
+	assert.EqualValues(t, 1, r.SubFlowCount)
+}
+
+func TestPolicyVerdict(t *testing.T) {
+	// no verdict is available by default
+	r := NewRecord(ebpf.BpfFlowId{}, ebpf.BpfFlowMetrics{Packets: 1}, time.Now(), 1000)
+	assert.Equal(t, PolicyVerdictUnknown, r.PolicyVerdict)
+
+	for verdict, expected := range map[PolicyVerdict]string{
+		PolicyVerdictUnknown: "unknown",
+		PolicyVerdictAllowed: "allowed",
+		PolicyVerdictDenied:  "denied",
+	} {
+		r.PolicyVerdict = verdict
+		assert.Equal(t, expected, verdict.String())
+		encoded, err := json.Marshal(r)
+		require.NoError(t, err)
+		assert.Contains(t, string(encoded), `"PolicyVerdict":"`+expected+`"`)
+	}
 }
@@ -0,0 +1,245 @@
+package flow
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EnricherReverseDNS decorates the flows with the hostnames of their external IP addresses,
+// as resolved by reverse DNS (PTR) lookups
+const EnricherReverseDNS = "reverseDNS"
+
+var rdlog = logrus.WithField("component", "flow.ReverseDNS")
+
+// Default configuration of the reverse DNS enricher
+const (
+	DefaultReverseDNSCacheTTL      = time.Hour
+	DefaultReverseDNSMaxEntries    = 10000
+	DefaultReverseDNSLookupsPerSec = 20
+	// reverseDNSLookupTimeout bounds the time a lookup can take, so a slow resolver doesn't
+	// delay the rest of pending lookups for too long
+	reverseDNSLookupTimeout = 2 * time.Second
+)
+
+// Resolver performs reverse DNS lookups. It is implemented by *net.Resolver
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// ReverseDNSConfig configures the reverse DNS enricher
+type ReverseDNSConfig struct {
+	// Resolver performs the lookups. If nil, net.DefaultResolver is used
+	Resolver Resolver
+	// CacheTTL is the time that the resolved hostnames (or failed lookups) are cached
+	CacheTTL time.Duration
+	// MaxEntries bounds the number of cached addresses. When the cache is full, the addresses
+	// that aren't cached yet are not resolved until some entries expire.
+	MaxEntries int
+	// LookupsPerSec limits the rate of lookups that are sent to the resolver
+	LookupsPerSec int
+}
+
+func init() {
+	RegisterEnricher(EnricherReverseDNS, func(ctx *EnricherContext) (Enricher, error) {
+		cfg := ReverseDNSConfig{}
+		if ctx.ReverseDNS != nil {
+			cfg = *ctx.ReverseDNS
+		}
+		return NewReverseDNS(&cfg, time.Now)
+	})
+}
+
+// ReverseDNS enricher sets the SrcHostname and DstHostname fields of the flows whose addresses
+// are external (not private, loopback, link-local...). It never blocks the pipeline: the
+// addresses that aren't cached yet are marked as pending and resolved asynchronously, at a
+// limited rate, so the hostnames are attached to the next flows from the same addresses.
+// Its lookups worker is stopped by Close.
+type ReverseDNS struct {
+	resolver Resolver
+	ttl      time.Duration
+	max      int
+	clock    func() time.Time
+	mt       sync.Mutex
+	cache    map[IPAddr]*hostnameEntry
+	// expiries lists the resolved addresses by expiry time, as all of them have the same TTL
+	expiries *list.List
+	pending  chan IPAddr
+	stop     context.CancelFunc
+}
+
+type hostnameEntry struct {
+	hostname string
+	// resolved is false while the lookup is pending
+	resolved bool
+	expiry   time.Time
+	// expiryElem is the element of the entry in the expiries list. It is nil while the lookup
+	// is pending
+	expiryElem *list.Element
+}
+
+// NewReverseDNS creates a ReverseDNS enricher, and starts its background lookups worker.
+func NewReverseDNS(cfg *ReverseDNSConfig, clock func() time.Time) (*ReverseDNS, error) {
+	rate := cfg.LookupsPerSec
+	if rate <= 0 {
+		rate = DefaultReverseDNSLookupsPerSec
+	}
+	if rate > int(time.Second) {
+		return nil, fmt.Errorf("reverse DNS lookups rate must be %d per second at most. Got %d",
+			int(time.Second), rate)
+	}
+	rd := &ReverseDNS{
+		resolver: cfg.Resolver,
+		ttl:      cfg.CacheTTL,
+		max:      cfg.MaxEntries,
+		clock:    clock,
+		cache:    map[IPAddr]*hostnameEntry{},
+		expiries: list.New(),
+	}
+	if rd.resolver == nil {
+		rd.resolver = net.DefaultResolver
+	}
+	if rd.ttl <= 0 {
+		rd.ttl = DefaultReverseDNSCacheTTL
+	}
+	if rd.max <= 0 {
+		rd.max = DefaultReverseDNSMaxEntries
+	}
+	rd.pending = make(chan IPAddr, rd.max)
+	var ctx context.Context
+	ctx, rd.stop = context.WithCancel(context.Background())
+	go rd.lookupLoop(ctx, time.Second/time.Duration(rate))
+	return rd, nil
+}
+
+func (rd *ReverseDNS) Enrich(record *Record) {
+	record.SrcHostname = rd.hostname(record.Id.SrcIp)
+	record.DstHostname = rd.hostname(record.Id.DstIp)
+}
+
+// Close stops the lookups worker, aborting the running lookup, if any
+func (rd *ReverseDNS) Close() error {
+	rd.stop()
+	return nil
+}
+
+// hostname returns the cached hostname of the address, or an empty string if the address
+// is not external, or its lookup is still pending or failed
+func (rd *ReverseDNS) hostname(addr IPAddr) string {
+	if !isExternal(IP(addr)) {
+		return ""
+	}
+	hostname, lookup := rd.cached(addr)
+	if lookup {
+		select {
+		case rd.pending <- addr:
+		default:
+			// the lookups queue is full. The address is looked up again with the next flows
+			rd.forget(addr)
+		}
+	}
+	return hostname
+}
+
+// cached returns the cached hostname of the address, and whether it must be looked up, as it
+// is not cached yet or it has expired
+func (rd *ReverseDNS) cached(addr IPAddr) (hostname string, lookup bool) {
+	rd.mt.Lock()
+	defer rd.mt.Unlock()
+	now := rd.clock()
+	if entry, ok := rd.cache[addr]; ok {
+		if entry.resolved && !now.Before(entry.expiry) {
+			// the expired hostname is still reported until the address is resolved again
+			entry.resolved = false
+			rd.expiries.Remove(entry.expiryElem)
+			entry.expiryElem = nil
+			return entry.hostname, true
+		}
+		return entry.hostname, false
+	}
+	if len(rd.cache) >= rd.max {
+		rd.removeExpired(now)
+		if len(rd.cache) >= rd.max {
+			return "", false
+		}
+	}
+	rd.cache[addr] = &hostnameEntry{}
+	return "", true
+}
+
+// forget removes an address whose lookup couldn't be queued
+func (rd *ReverseDNS) forget(addr IPAddr) {
+	rd.mt.Lock()
+	defer rd.mt.Unlock()
+	delete(rd.cache, addr)
+}
+
+// removeExpired removes the expired entries from the front of the expiries list
+func (rd *ReverseDNS) removeExpired(now time.Time) {
+	for elem := rd.expiries.Front(); elem != nil; elem = rd.expiries.Front() {
+		addr := elem.Value.(IPAddr)
+		if now.Before(rd.cache[addr].expiry) {
+			return
+		}
+		rd.expiries.Remove(elem)
+		delete(rd.cache, addr)
+	}
+}
+
+// lookupLoop resolves the pending addresses, at most one each lookupPeriod, until the context
+// is done
+func (rd *ReverseDNS) lookupLoop(ctx context.Context, lookupPeriod time.Duration) {
+	ticker := time.NewTicker(lookupPeriod)
+	defer ticker.Stop()
+	for {
+		var addr IPAddr
+		select {
+		case <-ctx.Done():
+			return
+		case addr = <-rd.pending:
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		hostname := rd.lookup(ctx, addr)
+		rd.mt.Lock()
+		entry, ok := rd.cache[addr]
+		if !ok {
+			entry = &hostnameEntry{}
+			rd.cache[addr] = entry
+		}
+		entry.hostname = hostname
+		entry.resolved = true
+		entry.expiry = rd.clock().Add(rd.ttl)
+		if entry.expiryElem != nil {
+			rd.expiries.Remove(entry.expiryElem)
+		}
+		entry.expiryElem = rd.expiries.PushBack(addr)
+		rd.mt.Unlock()
+	}
+}
+
+func (rd *ReverseDNS) lookup(ctx context.Context, addr IPAddr) string {
+	ctx, cancel := context.WithTimeout(ctx, reverseDNSLookupTimeout)
+	defer cancel()
+	names, err := rd.resolver.LookupAddr(ctx, IP(addr).String())
+	if err != nil || len(names) == 0 {
+		rdlog.WithError(err).WithField("addr", IP(addr)).Debug("can't resolve address")
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
+
+// isExternal returns whether the address might have a public PTR record
+func isExternal(ip net.IP) bool {
+	return !ip.IsPrivate() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
@@ -0,0 +1,170 @@
+package flow
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	test2 "github.com/mariomac/guara/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resolverStub resolves the addresses from a static map, and counts the lookups
+type resolverStub struct {
+	mt      sync.Mutex
+	names   map[string]string
+	lookups map[string]int
+}
+
+func (r *resolverStub) LookupAddr(_ context.Context, addr string) ([]string, error) {
+	r.mt.Lock()
+	defer r.mt.Unlock()
+	r.lookups[addr]++
+	if name, ok := r.names[addr]; ok {
+		return []string{name + "."}, nil
+	}
+	return nil, errors.New("not found")
+}
+
+func (r *resolverStub) lookupsFor(addr string) int {
+	r.mt.Lock()
+	defer r.mt.Unlock()
+	return r.lookups[addr]
+}
+
+func TestReverseDNS(t *testing.T) {
+	resolver := &resolverStub{
+		names:   map[string]string{"8.8.8.8": "dns.google", "10.0.0.1": "internal.local"},
+		lookups: map[string]int{},
+	}
+	now := time.Now()
+	clock := func() time.Time { return now }
+	rd, err := NewReverseDNS(&ReverseDNSConfig{
+		Resolver: resolver, CacheTTL: time.Hour, LookupsPerSec: 1000,
+	}, clock)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	flowTo := func(dst string) *Record {
+		r := &Record{}
+		copy(r.Id.SrcIp[:], net.ParseIP("10.0.0.1").To16())
+		copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+		return r
+	}
+
+	// the first flow is not blocked by the pending lookup
+	r := flowTo("8.8.8.8")
+	rd.Enrich(r)
+	assert.Empty(t, r.DstHostname)
+
+	// the hostname is attached once it is resolved
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		r := flowTo("8.8.8.8")
+		rd.Enrich(r)
+		require.Equal(t, "dns.google", r.DstHostname)
+	}, test2.Interval(10*time.Millisecond))
+
+	// and it is cached
+	for i := 0; i < 10; i++ {
+		r := flowTo("8.8.8.8")
+		rd.Enrich(r)
+		assert.Equal(t, "dns.google", r.DstHostname)
+	}
+	assert.Equal(t, 1, resolver.lookupsFor("8.8.8.8"))
+
+	// private addresses are never resolved
+	assert.Empty(t, r.SrcHostname)
+	assert.Zero(t, resolver.lookupsFor("10.0.0.1"))
+
+	// failed lookups are cached too
+	rd.Enrich(flowTo("1.1.1.1"))
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.Equal(t, 1, resolver.lookupsFor("1.1.1.1"))
+	}, test2.Interval(10*time.Millisecond))
+	r = flowTo("1.1.1.1")
+	rd.Enrich(r)
+	assert.Empty(t, r.DstHostname)
+
+	// after the cache TTL, the address is resolved again, keeping the previous hostname meanwhile
+	rd.mt.Lock()
+	now = now.Add(2 * time.Hour)
+	rd.mt.Unlock()
+	r = flowTo("8.8.8.8")
+	rd.Enrich(r)
+	assert.Equal(t, "dns.google", r.DstHostname)
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.Equal(t, 2, resolver.lookupsFor("8.8.8.8"))
+	}, test2.Interval(10*time.Millisecond))
+}
+
+func TestReverseDNS_MaxEntries(t *testing.T) {
+	resolver := &resolverStub{names: map[string]string{}, lookups: map[string]int{}}
+	rd, err := NewReverseDNS(&ReverseDNSConfig{
+		Resolver: resolver, MaxEntries: 2, LookupsPerSec: 1000,
+	}, time.Now)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	for _, addr := range []string{"8.8.8.8", "8.8.4.4", "1.1.1.1"} {
+		r := &Record{}
+		copy(r.Id.DstIp[:], net.ParseIP(addr).To16())
+		rd.Enrich(r)
+	}
+	rd.mt.Lock()
+	defer rd.mt.Unlock()
+	assert.Len(t, rd.cache, 2)
+}
+
+func TestReverseDNS_InvalidRate(t *testing.T) {
+	_, err := NewReverseDNS(&ReverseDNSConfig{LookupsPerSec: int(time.Second) + 1}, time.Now)
+	assert.Error(t, err)
+}
+
+func TestReverseDNS_ExpiredEntriesAreReplaced(t *testing.T) {
+	resolver := &resolverStub{names: map[string]string{}, lookups: map[string]int{}}
+	now := time.Now()
+	var mt sync.Mutex
+	clock := func() time.Time {
+		mt.Lock()
+		defer mt.Unlock()
+		return now
+	}
+	rd, err := NewReverseDNS(&ReverseDNSConfig{
+		Resolver: resolver, MaxEntries: 2, CacheTTL: time.Minute, LookupsPerSec: 1000,
+	}, clock)
+	require.NoError(t, err)
+	defer rd.Close()
+	enrich := func(addr string) {
+		r := &Record{}
+		copy(r.Id.DstIp[:], net.ParseIP(addr).To16())
+		rd.Enrich(r)
+	}
+
+	// GIVEN a full cache whose addresses are resolved
+	enrich("8.8.8.8")
+	enrich("8.8.4.4")
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		rd.mt.Lock()
+		defer rd.mt.Unlock()
+		require.Equal(t, 2, rd.expiries.Len())
+	}, test2.Interval(10*time.Millisecond))
+
+	// WHEN a new address is found after the entries expire
+	mt.Lock()
+	now = now.Add(2 * time.Minute)
+	mt.Unlock()
+	enrich("1.1.1.1")
+
+	// THEN the expired entries are removed to make room for it
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.Equal(t, 1, resolver.lookupsFor("1.1.1.1"))
+	}, test2.Interval(10*time.Millisecond))
+	rd.mt.Lock()
+	defer rd.mt.Unlock()
+	assert.Len(t, rd.cache, 1)
+	assert.Equal(t, 1, rd.expiries.Len())
+}
@@ -0,0 +1,180 @@
+package flow
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var sslog = logrus.WithField("component", "flow.SamplingScheduler")
+
+// SamplingRange is a daily time range, in minutes since midnight, during which the packets are
+// sampled at the given rate. If End is lower than Start, the range spans midnight.
+type SamplingRange struct {
+	Start int
+	End   int
+	Rate  uint32
+}
+
+// contains returns whether the provided minute of the day is in the range. The start minute is
+// included, and the end minute is excluded.
+func (r *SamplingRange) contains(minute int) bool {
+	if r.Start <= r.End {
+		return minute >= r.Start && minute < r.End
+	}
+	return minute >= r.Start || minute < r.End
+}
+
+// SamplingSchedule is a list of daily time ranges with their sampling rates, evaluated in a
+// given time zone
+type SamplingSchedule struct {
+	Ranges   []SamplingRange
+	Location *time.Location
+}
+
+// ParseSamplingSchedule parses a comma-separated list of HH:MM-HH:MM=rate entries (e.g.
+// "22:00-06:00=100,12:00-13:00=20"), whose times are evaluated in the provided location.
+func ParseSamplingSchedule(schedule string, loc *time.Location) (*SamplingSchedule, error) {
+	ss := &SamplingSchedule{Location: loc}
+	for _, entry := range strings.Split(schedule, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		times, rate, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing sampling rate in %q", entry)
+		}
+		start, end, ok := strings.Cut(times, "-")
+		if !ok {
+			return nil, fmt.Errorf("expecting a HH:MM-HH:MM time range in %q", entry)
+		}
+		r := SamplingRange{}
+		var err error
+		if r.Start, err = minuteOfDay(start); err != nil {
+			return nil, fmt.Errorf("invalid start time in %q: %w", entry, err)
+		}
+		if r.End, err = minuteOfDay(end); err != nil {
+			return nil, fmt.Errorf("invalid end time in %q: %w", entry, err)
+		}
+		if r.Start == r.End {
+			return nil, fmt.Errorf("empty time range in %q", entry)
+		}
+		rt, err := strconv.ParseUint(strings.TrimSpace(rate), 10, 32)
+		if err != nil || rt == 0 {
+			return nil, fmt.Errorf("invalid sampling rate in %q. Expecting a positive integer", entry)
+		}
+		r.Rate = uint32(rt)
+		ss.Ranges = append(ss.Ranges, r)
+	}
+	if len(ss.Ranges) == 0 {
+		return nil, fmt.Errorf("empty sampling schedule")
+	}
+	return ss, nil
+}
+
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(hhmm))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// RateAt returns the sampling rate of the first range, in the schedule order, that contains the
+// provided time, so overlapping ranges are resolved deterministically. If no range contains it,
+// it returns the provided default rate.
+func (ss *SamplingSchedule) RateAt(t time.Time, defaultRate uint32) uint32 {
+	local := t.In(ss.Location)
+	minute := local.Hour()*60 + local.Minute()
+	for i := range ss.Ranges {
+		if ss.Ranges[i].contains(minute) {
+			return ss.Ranges[i].Rate
+		}
+	}
+	return defaultRate
+}
+
+// SamplingWriter changes the sampling rate of the eBPF program. It is implemented by the
+// ebpf.FlowFetcher.
+type SamplingWriter interface {
+	SetSamplingRate(rate uint32) error
+}
+
+// SamplingScheduler periodically checks the SamplingSchedule and updates the sampling rate of
+// the eBPF program when the current time enters or leaves a range of the schedule.
+type SamplingScheduler struct {
+	schedule    *SamplingSchedule
+	writer      SamplingWriter
+	defaultRate uint32
+	clock       func() time.Time
+	rate        uint32
+	rateGauge   prometheus.Gauge
+}
+
+// NewSamplingScheduler creates a SamplingScheduler that applies the provided schedule, falling
+// back to the defaultRate (the configured sampling) outside the schedule ranges
+func NewSamplingScheduler(
+	schedule *SamplingSchedule, writer SamplingWriter, defaultRate int,
+	clock func() time.Time, m *metrics.Metrics,
+) *SamplingScheduler {
+	return &SamplingScheduler{
+		schedule:    schedule,
+		writer:      writer,
+		defaultRate: uint32(defaultRate),
+		clock:       clock,
+		rate:        uint32(defaultRate),
+		rateGauge: m.NewGauge("sampling_rate",
+			"Sampling rate of the eBPF program, as set by the sampling schedule"),
+	}
+}
+
+// Run checks the schedule each interval, until the context is canceled. On exit, the sampling
+// rate is left as is, since the eBPF map is destroyed with the program.
+func (s *SamplingScheduler) Run(ctx context.Context, interval time.Duration) {
+	s.rateGauge.Set(float64(s.rate))
+	s.Check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Check()
+		}
+	}
+}
+
+// Check updates the sampling rate of the eBPF program if it differs from the rate of the
+// schedule at the current time, and returns the current rate
+func (s *SamplingScheduler) Check() uint32 {
+	if rate := s.schedule.RateAt(s.clock(), s.defaultRate); rate != s.rate {
+		s.setRate(rate)
+	}
+	return s.rate
+}
+
+// setRate writes the sampling rate. If it fails, the previous rate is kept, so the write is
+// retried in the next check.
+func (s *SamplingScheduler) setRate(rate uint32) {
+	// writing 0 restores the sampling rate that the eBPF program was configured with
+	override := rate
+	if rate == s.defaultRate {
+		override = 0
+	}
+	if err := s.writer.SetSamplingRate(override); err != nil {
+		sslog.WithError(err).Warn("can't change the sampling rate of the eBPF program")
+		return
+	}
+	sslog.WithFields(logrus.Fields{"from": s.rate, "to": rate}).Info("sampling rate changed")
+	s.rate = rate
+	s.rateGauge.Set(float64(rate))
+}
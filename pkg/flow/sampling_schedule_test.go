@@ -0,0 +1,139 @@
+package flow
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	test2 "github.com/mariomac/guara/pkg/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+type samplingWriterFake struct {
+	mt      sync.Mutex
+	written []uint32
+	// objects mimics the eBPF objects of the FlowFetcher, which are released on Close without
+	// synchronization, so a write racing with Close is reported by the race detector
+	objects *uint32
+}
+
+func (s *samplingWriterFake) SetSamplingRate(rate uint32) error {
+	if s.objects != nil {
+		*s.objects = rate
+	}
+	s.mt.Lock()
+	defer s.mt.Unlock()
+	s.written = append(s.written, rate)
+	return nil
+}
+
+func (s *samplingWriterFake) count() int {
+	s.mt.Lock()
+	defer s.mt.Unlock()
+	return len(s.written)
+}
+
+func (s *samplingWriterFake) Close() {
+	s.objects = nil
+}
+
+func TestSamplingScheduler(t *testing.T) {
+	// GIVEN a schedule with quiet hours crossing midnight
+	schedule, err := ParseSamplingSchedule("22:00-06:00=100", time.UTC)
+	require.NoError(t, err)
+	now := time.Date(2023, 3, 1, 21, 59, 0, 0, time.UTC)
+	writer := &samplingWriterFake{}
+	ss := NewSamplingScheduler(schedule, writer, 10, func() time.Time { return now }, metrics.NoOp())
+
+	// WHEN the current time is out of the schedule ranges
+	// THEN the configured sampling rate is kept
+	assert.EqualValues(t, 10, ss.Check())
+	assert.Empty(t, writer.written)
+
+	// WHEN the clock reaches the start of the range
+	now = now.Add(time.Minute)
+	// THEN the sampling rate changes
+	assert.EqualValues(t, 100, ss.Check())
+	now = now.Add(7*time.Hour + 59*time.Minute)
+	assert.EqualValues(t, 100, ss.Check())
+	assert.Equal(t, []uint32{100}, writer.written)
+
+	// AND the configured sampling is restored when the clock reaches the end of the range
+	now = now.Add(time.Minute)
+	assert.EqualValues(t, 10, ss.Check())
+	assert.Equal(t, []uint32{100, 0}, writer.written)
+}
+
+func TestSamplingScheduler_Run(t *testing.T) {
+	// GIVEN a schedule whose range includes the current time
+	schedule, err := ParseSamplingSchedule("00:00-23:59=100", time.UTC)
+	require.NoError(t, err)
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	writer := &samplingWriterFake{objects: new(uint32)}
+	ss := NewSamplingScheduler(schedule, writer, 10, func() time.Time { return now }, metrics.NoOp())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ss.Run(ctx, time.Hour)
+		close(done)
+	}()
+	test2.Eventually(t, timeout, func(t require.TestingT) {
+		require.Equal(t, 1, writer.count())
+	}, test2.Interval(10*time.Millisecond))
+
+	// WHEN the context is canceled while the eBPF objects are closed
+	cancel()
+	writer.Close()
+	<-done
+	// THEN the sampling rate of the schedule was set, and it isn't restored on exit, since the
+	// eBPF map is destroyed anyway
+	assert.Equal(t, []uint32{100}, writer.written)
+}
+
+func TestSamplingSchedule_Overlap(t *testing.T) {
+	schedule, err := ParseSamplingSchedule("00:00-12:00=50, 08:00-09:00=5", time.UTC)
+	require.NoError(t, err)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2023, 3, 1, hour, minute, 0, 0, time.UTC)
+	}
+	// the first range in the schedule takes precedence
+	assert.EqualValues(t, 50, schedule.RateAt(at(8, 30), 1))
+	assert.EqualValues(t, 50, schedule.RateAt(at(0, 0), 1))
+	assert.EqualValues(t, 1, schedule.RateAt(at(12, 0), 1))
+
+	schedule, err = ParseSamplingSchedule("08:00-09:00=5,00:00-12:00=50", time.UTC)
+	require.NoError(t, err)
+	assert.EqualValues(t, 5, schedule.RateAt(at(8, 30), 1))
+	assert.EqualValues(t, 50, schedule.RateAt(at(9, 0), 1))
+}
+
+func TestSamplingSchedule_Timezone(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	schedule, err := ParseSamplingSchedule("22:00-23:00=100", loc)
+	require.NoError(t, err)
+	// 20:30 UTC is 22:30 in the schedule time zone
+	assert.EqualValues(t, 100, schedule.RateAt(time.Date(2023, 3, 1, 20, 30, 0, 0, time.UTC), 1))
+	assert.EqualValues(t, 1, schedule.RateAt(time.Date(2023, 3, 1, 22, 30, 0, 0, time.UTC), 1))
+}
+
+func TestParseSamplingSchedule_Errors(t *testing.T) {
+	for _, schedule := range []string{
+		"",
+		"22:00-06:00",
+		"22:00=100",
+		"25:00-06:00=100",
+		"22:00-06:61=100",
+		"22:00-22:00=100",
+		"22:00-06:00=0",
+		"22:00-06:00=-1",
+		"22:00-06:00=many",
+	} {
+		_, err := ParseSamplingSchedule(schedule, time.UTC)
+		assert.Error(t, err, schedule)
+	}
+}
@@ -0,0 +1,32 @@
+package flow
+
+// EnricherService decorates the flows with the name of the well-known service that is
+// associated to their destination port
+const EnricherService = "service"
+
+// DefaultServicePorts maps the destination ports to the names of the well-known services that
+// are tagged by the service enricher, unless the user provides its own mapping
+var DefaultServicePorts = map[uint16]string{
+	22:  "ssh",
+	53:  "dns",
+	80:  "http",
+	443: "https",
+}
+
+func init() {
+	RegisterEnricher(EnricherService, func(ctx *EnricherContext) (Enricher, error) {
+		ports := ctx.ServicePorts
+		if ports == nil {
+			ports = DefaultServicePorts
+		}
+		return ServiceTagger(ports), nil
+	})
+}
+
+// ServiceTagger sets the Service field of the flows whose destination port is in the map.
+// The flows towards any other port get an empty service.
+type ServiceTagger map[uint16]string
+
+func (st ServiceTagger) Enrich(record *Record) {
+	record.Service = st[record.Id.DstPort]
+}
@@ -0,0 +1,133 @@
+package flow
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+// ServicePortKey rewrites the identifier of TCP and UDP flows so it is keyed by the service
+// port: the endpoint with the lower port, assumed to be the well-known port of the service, is
+// always the destination, and the source (client) port is zeroed. Then the flows observed from
+// the client or from the server side, as well as the flows from different client ports towards
+// the same service, share the same identifier. The direction of the reversed identifiers is
+// flipped, so it refers to the traffic from the client to the service (e.g. the ingress packets
+// from the server are the egress side of the client). It returns whether the endpoints have been
+// reversed. The identifiers of other protocols, or without ports, are left unchanged.
+func ServicePortKey(id *ebpf.BpfFlowId) bool {
+	if id.TransportProtocol != syscall.IPPROTO_TCP && id.TransportProtocol != syscall.IPPROTO_UDP {
+		return false
+	}
+	if id.SrcPort == 0 || id.DstPort == 0 {
+		return false
+	}
+	reversed := false
+	if id.SrcPort < id.DstPort {
+		id.SrcIp, id.DstIp = id.DstIp, id.SrcIp
+		id.SrcPort, id.DstPort = id.DstPort, id.SrcPort
+		id.SrcMac, id.DstMac = id.DstMac, id.SrcMac
+		id.Direction = reverseDirection(id.Direction)
+		reversed = true
+	}
+	id.SrcPort = 0
+	return reversed
+}
+
+// KeyByServicePort receives flows, rewrites their identifiers according to ServicePortKey and
+// merges the flows of each batch that end up sharing the same identifier, so the flows towards
+// a given service are aggregated in a single record.
+func KeyByServicePort(in <-chan []*Record, out chan<- []*Record) {
+	for records := range in {
+		out <- mergeByServicePort(records)
+	}
+}
+
+// AggregateByServicePort returns copies of the records, keyed by service port and merged as in
+// KeyByServicePort. The provided records aren't modified, so they can be forwarded to other
+// stages at the same time.
+func AggregateByServicePort(records []*Record) []*Record {
+	copies := make([]*Record, 0, len(records))
+	for _, record := range records {
+		c := *record
+		copies = append(copies, &c)
+	}
+	return mergeByServicePort(copies)
+}
+
+func mergeByServicePort(records []*Record) []*Record {
+	return mergeByKey(records, func(id *ebpf.BpfFlowId) { ServicePortKey(id) })
+}
+
+// mergeByKey rewrites the identifiers of the records with the rekey function, and merges the
+// records that end up sharing the same identifier
+func mergeByKey(records []*Record, rekey func(id *ebpf.BpfFlowId)) []*Record {
+	merged := make([]*Record, 0, len(records))
+	byKey := make(map[ebpf.BpfFlowId]*Record, len(records))
+	for _, record := range records {
+		rekey(&record.Id)
+		if first, ok := byKey[record.Id]; ok {
+			mergeRecord(first, record)
+			continue
+		}
+		byKey[record.Id] = record
+		merged = append(merged, record)
+	}
+	return merged
+}
+
+// mergeRecord accumulates the metrics of src into dst. The rest of the fields of dst (e.g.
+// the connection identifier or the enrichment fields) are kept.
+func mergeRecord(dst, src *Record) {
+	dst.SubFlowCount += src.SubFlowCount
+	dst.ScanSuspect = dst.ScanSuspect || src.ScanSuspect
+	mergeIPG(dst, src)
+	dm, sm := &dst.Metrics, &src.Metrics
+	dm.Packets += sm.Packets
+	dm.Bytes += sm.Bytes
+	dm.Flags |= sm.Flags
+	dm.FragmentedPackets += sm.FragmentedPackets
+	dm.DuplicatePackets += sm.DuplicatePackets
+	for i := range dm.PktSizeBuckets {
+		dm.PktSizeBuckets[i] += sm.PktSizeBuckets[i]
+	}
+	if sm.MinTtl < dm.MinTtl {
+		dm.MinTtl = sm.MinTtl
+	}
+	if sm.MaxTtl > dm.MaxTtl {
+		dm.MaxTtl = sm.MaxTtl
+	}
+	if sm.StartMonoTimeTs < dm.StartMonoTimeTs {
+		dm.StartMonoTimeTs = sm.StartMonoTimeTs
+	}
+	if sm.EndMonoTimeTs > dm.EndMonoTimeTs {
+		dm.EndMonoTimeTs = sm.EndMonoTimeTs
+	}
+	if src.TimeFlowStart.Before(dst.TimeFlowStart) {
+		dst.TimeFlowStart = src.TimeFlowStart
+	}
+	if src.TimeFlowEnd.After(dst.TimeFlowEnd) {
+		dst.TimeFlowEnd = src.TimeFlowEnd
+	}
+}
+
+// mergeIPG merges the inter-packet gaps of the records. The mean is weighted by the number of
+// gaps of each record. It must be invoked before the packets of the records are merged.
+func mergeIPG(dst, src *Record) {
+	if src.MeanIPG == 0 {
+		return
+	}
+	if dst.MeanIPG == 0 {
+		dst.MinIPG, dst.MaxIPG, dst.MeanIPG = src.MinIPG, src.MaxIPG, src.MeanIPG
+		return
+	}
+	if src.MinIPG < dst.MinIPG {
+		dst.MinIPG = src.MinIPG
+	}
+	if src.MaxIPG > dst.MaxIPG {
+		dst.MaxIPG = src.MaxIPG
+	}
+	dstGaps := time.Duration(dst.Metrics.Packets - 1)
+	srcGaps := time.Duration(src.Metrics.Packets - 1)
+	dst.MeanIPG = (dst.MeanIPG*dstGaps + src.MeanIPG*srcGaps) / (dstGaps + srcGaps)
+}
@@ -0,0 +1,127 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestKeyByServicePort(t *testing.T) {
+	ip := func(addr string) IPAddr {
+		var ia IPAddr
+		copy(ia[:], net.ParseIP(addr).To16())
+		return ia
+	}
+	start := time.Now()
+	record := func(src, dst string, srcPort, dstPort uint16, bytes uint64, offset time.Duration) *Record {
+		r := &Record{TimeFlowStart: start.Add(offset), TimeFlowEnd: start.Add(offset + time.Second)}
+		r.Id = ebpf.BpfFlowId{
+			EthProtocol:       0x0800,
+			TransportProtocol: 6,
+			SrcIp:             ip(src),
+			DstIp:             ip(dst),
+			SrcPort:           srcPort,
+			DstPort:           dstPort,
+			Direction:         DirectionEgress,
+		}
+		r.Metrics.Bytes = bytes
+		r.Metrics.Packets = 1
+		r.SubFlowCount = 1
+		return r
+	}
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go KeyByServicePort(in, out)
+
+	icmp := record("10.0.0.1", "10.0.0.2", 0, 0, 7, 0)
+	icmp.Id.TransportProtocol = 1
+	icmp.Id.IcmpType = 8
+	// server-initiated view of the same connection, as observed from the client host
+	reply := record("10.0.0.2", "10.0.0.1", 443, 34567, 200, time.Second)
+	reply.Id.Direction = DirectionIngress
+	in <- []*Record{
+		// client-initiated view
+		record("10.0.0.1", "10.0.0.2", 34567, 443, 100, 0),
+		reply,
+		// another client port towards the same service
+		record("10.0.0.1", "10.0.0.2", 40000, 443, 300, 2*time.Second),
+		// another service of the same host
+		record("10.0.0.1", "10.0.0.2", 34568, 80, 50, 0),
+		icmp,
+	}
+	close(in)
+	merged := receiveTimeout(t, out)
+	require.Len(t, merged, 3)
+
+	https := merged[0]
+	assert.EqualValues(t, ip("10.0.0.1"), https.Id.SrcIp)
+	assert.EqualValues(t, ip("10.0.0.2"), https.Id.DstIp)
+	assert.Zero(t, https.Id.SrcPort)
+	assert.EqualValues(t, 443, https.Id.DstPort)
+	assert.EqualValues(t, 600, https.Metrics.Bytes)
+	assert.EqualValues(t, 3, https.Metrics.Packets)
+	// the record tells how many flows have been merged into it
+	assert.EqualValues(t, 3, https.SubFlowCount)
+	assert.Equal(t, start, https.TimeFlowStart)
+	assert.Equal(t, start.Add(3*time.Second), https.TimeFlowEnd)
+
+	http := merged[1]
+	assert.Zero(t, http.Id.SrcPort)
+	assert.EqualValues(t, 80, http.Id.DstPort)
+	assert.EqualValues(t, 50, http.Metrics.Bytes)
+	assert.EqualValues(t, 1, http.SubFlowCount)
+
+	// flows without ports are left unchanged
+	assert.Same(t, icmp, merged[2])
+	assert.EqualValues(t, 1, icmp.SubFlowCount)
+	assert.EqualValues(t, ip("10.0.0.1"), icmp.Id.SrcIp)
+	assert.EqualValues(t, 8, icmp.Id.IcmpType)
+}
+
+func TestKeyByServicePort_OppositeDirections(t *testing.T) {
+	ip := func(addr string) IPAddr {
+		var ia IPAddr
+		copy(ia[:], net.ParseIP(addr).To16())
+		return ia
+	}
+	record := func(src, dst string, srcPort, dstPort uint16, direction uint8) *Record {
+		r := &Record{}
+		r.Id = ebpf.BpfFlowId{
+			EthProtocol:       0x0800,
+			TransportProtocol: 6,
+			SrcIp:             ip(src),
+			DstIp:             ip(dst),
+			SrcPort:           srcPort,
+			DstPort:           dstPort,
+			Direction:         direction,
+		}
+		r.Metrics.Bytes = 100
+		r.Metrics.Packets = 1
+		r.SubFlowCount = 1
+		return r
+	}
+	in := make(chan []*Record, 1)
+	out := make(chan []*Record, 1)
+	go KeyByServicePort(in, out)
+
+	// GIVEN the request and the reply of a connection, as observed from the client host
+	in <- []*Record{
+		record("10.0.0.1", "10.0.0.2", 34567, 443, DirectionEgress),
+		record("10.0.0.2", "10.0.0.1", 443, 34567, DirectionIngress),
+	}
+	close(in)
+
+	// THEN both are merged in a single record, whose direction refers to the client requests
+	merged := receiveTimeout(t, out)
+	require.Len(t, merged, 1)
+	assert.EqualValues(t, DirectionEgress, merged[0].Id.Direction)
+	assert.EqualValues(t, ip("10.0.0.1"), merged[0].Id.SrcIp)
+	assert.EqualValues(t, 443, merged[0].Id.DstPort)
+	assert.EqualValues(t, 200, merged[0].Metrics.Bytes)
+	assert.EqualValues(t, 2, merged[0].SubFlowCount)
+}
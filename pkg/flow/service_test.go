@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func TestServiceEnricher(t *testing.T) {
+	flowTo := func(srcPort, dstPort uint16) *Record {
+		return &Record{RawRecord: RawRecord{Id: ebpf.BpfFlowId{SrcPort: srcPort, DstPort: dstPort}}}
+	}
+	t.Run("default ports", func(t *testing.T) {
+		chain, err := NewEnrichers([]string{EnricherService}, &EnricherContext{})
+		require.NoError(t, err)
+		enrich := chain[0]
+
+		for port, service := range map[uint16]string{
+			22: "ssh", 53: "dns", 80: "http", 443: "https", 8080: "", 34567: "",
+		} {
+			r := flowTo(40000, port)
+			enrich.Enrich(r)
+			assert.Equal(t, service, r.Service, "port %d", port)
+		}
+		// the source port is ignored
+		r := flowTo(443, 40000)
+		enrich.Enrich(r)
+		assert.Empty(t, r.Service)
+	})
+	t.Run("user-provided ports", func(t *testing.T) {
+		chain, err := NewEnrichers([]string{EnricherService}, &EnricherContext{
+			ServicePorts: map[uint16]string{5432: "postgresql"},
+		})
+		require.NoError(t, err)
+		enrich := chain[0]
+
+		r := flowTo(40000, 5432)
+		enrich.Enrich(r)
+		assert.Equal(t, "postgresql", r.Service)
+		// the default mapping is replaced
+		r = flowTo(40000, 443)
+		enrich.Enrich(r)
+		assert.Empty(t, r.Service)
+	})
+}
@@ -0,0 +1,60 @@
+package flow
+
+import (
+	"sync"
+)
+
+// SplitAccounter keeps the long-lived flows apart from the short-lived ones in two Accounters,
+// which can have different capacities and eviction timeouts, so a storm of ephemeral flows that
+// fills the short-lived cache doesn't evict the long-lived flows with it. The flows are routed by
+// a heuristic on their transport protocol: the flows of the longLived protocols (e.g. TCP) are
+// accounted in the long accounter, and the rest in the short one.
+type SplitAccounter struct {
+	short     *Accounter
+	long      *Accounter
+	longLived map[uint8]struct{}
+}
+
+// NewSplitAccounter creates a SplitAccounter that accounts the flows of the longLived protocols
+// in the long Accounter, and the rest of flows in the short Accounter
+func NewSplitAccounter(short, long *Accounter, longLived []uint8) *SplitAccounter {
+	sa := &SplitAccounter{short: short, long: long, longLived: map[uint8]struct{}{}}
+	for _, proto := range longLived {
+		sa.longLived[proto] = struct{}{}
+	}
+	return sa
+}
+
+// Flush forces the eviction of the flows of both accounters
+func (sa *SplitAccounter) Flush() {
+	sa.short.Flush()
+	sa.long.Flush()
+}
+
+// Account routes the records from the input channel to the accounter of their kind. Both
+// accounters evict their flows, independently, through the output channel. When the input
+// channel is closed, it returns once both accounters have evicted their remaining flows.
+func (sa *SplitAccounter) Account(in <-chan *RawRecord, out chan<- []*Record) {
+	shortIn := make(chan *RawRecord, cap(in))
+	longIn := make(chan *RawRecord, cap(in))
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		sa.short.Account(shortIn, out)
+	}()
+	go func() {
+		defer wg.Done()
+		sa.long.Account(longIn, out)
+	}()
+	for record := range in {
+		if _, ok := sa.longLived[record.Id.TransportProtocol]; ok {
+			longIn <- record
+		} else {
+			shortIn <- record
+		}
+	}
+	close(shortIn)
+	close(longIn)
+	wg.Wait()
+}
@@ -0,0 +1,87 @@
+package flow
+
+import (
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+func newTestAccounter(maxEntries int) *Accounter {
+	return NewAccounter(maxEntries, time.Hour, 0, 0, time.Now, func() time.Duration {
+		return 1000
+	}, nil)
+}
+
+// ephemeralStorm sends 20 short-lived UDP flows after a long-lived TCP flow, and returns the
+// flows of the provided number of evictions
+func ephemeralStorm(
+	t *testing.T, inputs chan<- *RawRecord, evictor <-chan []*Record, evictions int,
+) []*Record {
+	t.Helper()
+	longLived := ebpf.BpfFlowId{SrcPort: 40000, DstPort: 443, TransportProtocol: syscall.IPPROTO_TCP}
+	inputs <- &RawRecord{Id: longLived, Metrics: ebpf.BpfFlowMetrics{Bytes: 1000, Packets: 10}}
+	for port := uint16(1); port <= 20; port++ {
+		inputs <- &RawRecord{
+			Id:      ebpf.BpfFlowId{SrcPort: port, DstPort: 53, TransportProtocol: syscall.IPPROTO_UDP},
+			Metrics: ebpf.BpfFlowMetrics{Bytes: 60, Packets: 1},
+		}
+	}
+	var evicted []*Record
+	for i := 0; i < evictions; i++ {
+		evicted = append(evicted, receiveTimeout(t, evictor)...)
+	}
+	return evicted
+}
+
+func hasProtocol(records []*Record, proto uint8) bool {
+	for _, r := range records {
+		if r.Id.TransportProtocol == proto {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSplitAccounter_LongLivedSurvivesStorm(t *testing.T) {
+	// GIVEN a single accounter, the long-lived flow is evicted by a storm of ephemeral flows
+	inputs := make(chan *RawRecord, 100)
+	evictor := make(chan []*Record, 100)
+	go newTestAccounter(5).Account(inputs, evictor)
+	// the cache of 5 flows is evicted when the 6th, 11th, 16th and 21st flows arrive
+	evicted := ephemeralStorm(t, inputs, evictor, 4)
+	assert.True(t, hasProtocol(evicted, syscall.IPPROTO_TCP))
+	close(inputs)
+
+	// WHEN the TCP flows are accounted in a separate long-lived cache
+	sa := NewSplitAccounter(newTestAccounter(5), newTestAccounter(5), []uint8{syscall.IPPROTO_TCP})
+	inputs = make(chan *RawRecord, 100)
+	evictor = make(chan []*Record, 100)
+	go sa.Account(inputs, evictor)
+	defer close(inputs)
+	// the short-lived cache is evicted when the 6th, 11th and 16th UDP flows arrive
+	evicted = ephemeralStorm(t, inputs, evictor, 3)
+
+	// THEN the storm only evicts the ephemeral flows
+	assert.Len(t, evicted, 15)
+	assert.False(t, hasProtocol(evicted, syscall.IPPROTO_TCP))
+	time.Sleep(30 * time.Millisecond)
+	requireNoEviction(t, evictor)
+
+	// AND the long-lived flow is kept until its cache is flushed
+	sa.Flush()
+	flushed := append(receiveTimeout(t, evictor), receiveTimeout(t, evictor)...)
+	require.Len(t, flushed, 6)
+	var tcp []*Record
+	for _, r := range flushed {
+		if r.Id.TransportProtocol == syscall.IPPROTO_TCP {
+			tcp = append(tcp, r)
+		}
+	}
+	require.Len(t, tcp, 1)
+	assert.EqualValues(t, 1000, tcp[0].Metrics.Bytes)
+}
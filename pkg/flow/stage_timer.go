@@ -0,0 +1,143 @@
+package flow
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+var stageTimerBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5,
+}
+
+// StageTimer records, in a histogram labeled by stage name, the time that the pipeline stages
+// spend processing each batch of flows. The stages are instrumented without modifying them:
+// their input (and output) batches are handed over through unbuffered channels, so the timer
+// knows when a stage takes a batch and when it forwards its result.
+// A batch is measured from the moment the stage takes it until the stage forwards its first
+// output, or until it takes the next batch, if that batch was already waiting for the stage.
+// Otherwise (e.g. a filter that drops the whole batch and then waits for more flows), the end of
+// the processing can't be distinguished from idle time, and the batch isn't observed.
+type StageTimer struct {
+	histogram *prometheus.HistogramVec
+}
+
+// NewStageTimer creates a StageTimer whose histogram is registered in the provided metrics
+func NewStageTimer(m *metrics.Metrics) *StageTimer {
+	return &StageTimer{
+		histogram: m.NewHistogramVec("pipeline_stage_duration_seconds",
+			"Time spent by each pipeline stage processing a batch of flows",
+			stageTimerBuckets, "stage"),
+	}
+}
+
+// stageClock keeps the start time of the batch that is being processed by a stage
+type stageClock struct {
+	observer prometheus.Observer
+	started  time.Time
+}
+
+// start sets the start time of a new batch, observing the previous one if it hadn't been
+// observed yet and the provided time is known to be the end of its processing
+func (sc *stageClock) start(now time.Time, previousEnded bool) {
+	if previousEnded {
+		sc.end(now)
+	}
+	sc.started = now
+}
+
+// end observes the current batch, if it hadn't been observed yet
+func (sc *stageClock) end(now time.Time) {
+	if !sc.started.IsZero() {
+		sc.observer.Observe(now.Sub(sc.started).Seconds())
+		sc.started = time.Time{}
+	}
+}
+
+// relay hands over the input batches to the stage, and forwards the stage output, if any.
+// Both are done from the same goroutine, so the order of the stage events is preserved.
+func (sc *stageClock) relay(
+	in <-chan []*Record, stageIn chan<- []*Record, stageOut <-chan []*Record, out chan<- []*Record,
+) {
+	var pending []*Record
+	hasPending := false
+	for in != nil || hasPending || stageOut != nil {
+		// only one batch is read in advance, while the stage is busy
+		input := in
+		var stageInput chan<- []*Record
+		if hasPending {
+			select {
+			case stageIn <- pending:
+				// the stage was idle, waiting for flows: the end of the previous batch is unknown
+				sc.start(time.Now(), false)
+				pending, hasPending = nil, false
+				continue
+			default:
+				// the stage is still processing the previous batch
+				input, stageInput = nil, stageIn
+			}
+		}
+		select {
+		case records, ok := <-input:
+			if !ok {
+				in = nil
+				close(stageIn)
+				continue
+			}
+			pending, hasPending = records, true
+		case stageInput <- pending:
+			sc.start(time.Now(), true)
+			pending, hasPending = nil, false
+		case records, ok := <-stageOut:
+			if !ok {
+				// the stage returned after processing the last batch
+				sc.end(time.Now())
+				return
+			}
+			sc.end(time.Now())
+			out <- records
+		}
+	}
+}
+
+// Middle instruments a middle stage of the pipeline, whose batches are observed with the
+// provided stage name
+func (st *StageTimer) Middle(
+	name string, stage func(in <-chan []*Record, out chan<- []*Record),
+) func(in <-chan []*Record, out chan<- []*Record) {
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		sc := &stageClock{observer: st.histogram.WithLabelValues(name)}
+		stageIn := make(chan []*Record)
+		stageOut := make(chan []*Record)
+		relayed := make(chan struct{})
+		go func() {
+			defer close(relayed)
+			sc.relay(in, stageIn, stageOut, out)
+		}()
+		stage(stageIn, stageOut)
+		close(stageOut)
+		<-relayed
+	}
+}
+
+// Terminal instruments the terminal stage of the pipeline (the exporter), whose batches are
+// observed with the provided stage name
+func (st *StageTimer) Terminal(
+	name string, stage func(in <-chan []*Record),
+) func(in <-chan []*Record) {
+	return func(in <-chan []*Record) {
+		sc := &stageClock{observer: st.histogram.WithLabelValues(name)}
+		stageIn := make(chan []*Record)
+		relayed := make(chan struct{})
+		go func() {
+			defer close(relayed)
+			sc.relay(in, stageIn, nil, nil)
+		}()
+		stage(stageIn)
+		<-relayed
+		// the stage returns after processing the last batch
+		sc.end(time.Now())
+	}
+}
@@ -0,0 +1,106 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+const stageDelay = 10 * time.Millisecond
+
+func TestStageTimer(t *testing.T) {
+	m := metrics.NoOp()
+	timer := NewStageTimer(m)
+
+	// GIVEN a forwarding stage, a stage that drops all the flows and an exporter
+	forward := timer.Middle("forward", func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			time.Sleep(stageDelay)
+			out <- records
+		}
+	})
+	drop := timer.Middle("drop", func(in <-chan []*Record, _ chan<- []*Record) {
+		for range in {
+			time.Sleep(stageDelay)
+		}
+	})
+	var exported int
+	export := timer.Terminal("export", func(in <-chan []*Record) {
+		for records := range in {
+			time.Sleep(stageDelay)
+			exported += len(records)
+		}
+	})
+
+	// WHEN they process some batches
+	in := make(chan []*Record, 10)
+	forwarded := make(chan []*Record, 10)
+	for i := 0; i < 3; i++ {
+		in <- []*Record{{Interface: "eth0"}}
+	}
+	close(in)
+	forward(in, forwarded)
+	close(forwarded)
+
+	dropIn := make(chan []*Record, 10)
+	for i := 0; i < 3; i++ {
+		dropIn <- []*Record{{Interface: "eth0"}}
+	}
+	close(dropIn)
+	drop(dropIn, make(chan []*Record, 10))
+
+	export(forwarded)
+	assert.Equal(t, 3, exported)
+
+	// THEN the processing time of each batch is recorded, labeled by stage name
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	assert.Equal(t, "pipeline_stage_duration_seconds", families[0].GetName())
+	observed := map[string]uint64{}
+	for _, metric := range families[0].Metric {
+		require.Len(t, metric.Label, 1)
+		histogram := metric.GetHistogram()
+		observed[metric.Label[0].GetValue()] = histogram.GetSampleCount()
+		assert.GreaterOrEqual(t, histogram.GetSampleSum(),
+			float64(histogram.GetSampleCount())*stageDelay.Seconds())
+	}
+	assert.Equal(t, map[string]uint64{"forward": 3, "drop": 3, "export": 3}, observed)
+}
+
+func TestStageTimer_IdleTimeNotObserved(t *testing.T) {
+	m := metrics.NoOp()
+	timer := NewStageTimer(m)
+	dropped := 0
+	drop := timer.Middle("drop", func(in <-chan []*Record, _ chan<- []*Record) {
+		for records := range in {
+			dropped += len(records)
+		}
+	})
+
+	// WHEN a stage that doesn't forward flows receives batches spaced in time
+	in := make(chan []*Record)
+	done := make(chan struct{})
+	go func() {
+		drop(in, make(chan []*Record))
+		close(done)
+	}()
+	in <- []*Record{{}}
+	time.Sleep(5 * stageDelay)
+	in <- []*Record{{}}
+	close(in)
+	<-done
+	assert.Equal(t, 2, dropped)
+
+	// THEN the time between the batches is not accounted as processing time
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	histogram := families[0].Metric[0].GetHistogram()
+	assert.EqualValues(t, 1, histogram.GetSampleCount())
+	assert.Less(t, histogram.GetSampleSum(), stageDelay.Seconds())
+}
@@ -0,0 +1,237 @@
+package flow
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// EnricherSubnetLabels decorates the flows with the labels of the subnets of their source and
+// destination addresses, as defined in a static mapping file
+const EnricherSubnetLabels = "subnetLabels"
+
+var sllog = logrus.WithField("component", "flow.SubnetLabels")
+
+// SubnetLabelsConfig configures the subnet labels enricher
+type SubnetLabelsConfig struct {
+	// Path of the file that maps the subnets to their labels. Its format is selected by its
+	// extension: CSV (.csv) or YAML (.yaml, .yml).
+	Path string
+	// ReloadPeriod is how often the file is checked for changes, to reload it. If 0, the file is
+	// only loaded at startup.
+	ReloadPeriod time.Duration
+}
+
+func init() {
+	RegisterEnricher(EnricherSubnetLabels, func(ctx *EnricherContext) (Enricher, error) {
+		if ctx.SubnetLabels == nil || ctx.SubnetLabels.Path == "" {
+			return nil, errors.New("missing subnet labels file")
+		}
+		return NewSubnetLabels(ctx.SubnetLabels)
+	})
+}
+
+// SubnetLabels enricher sets the SrcLabels and DstLabels fields of the flows with the labels of
+// the most specific subnet (longest prefix match) that contains their source and destination
+// addresses. The mapping is loaded from a file, which is reloaded when it changes.
+type SubnetLabels struct {
+	path  string
+	mt    sync.RWMutex
+	table *subnetTable
+	// modification time and size of the last loaded file, to detect changes
+	modTime time.Time
+	size    int64
+}
+
+// subnetTable indexes the labels by prefix length and masked address. IPv4 subnets are stored
+// as IPv4-mapped IPv6 subnets, as the flow addresses are.
+type subnetTable struct {
+	// prefix lengths in the table, from the longest to the shortest
+	lengths []int
+	subnets map[int]map[IPAddr]map[string]string
+}
+
+// subnetLabelsEntry is an entry of the YAML mapping file
+type subnetLabelsEntry struct {
+	Subnet string            `yaml:"subnet"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// NewSubnetLabels creates a SubnetLabels enricher. It returns error if the file can't be loaded.
+// Invalid entries are skipped with a warning.
+func NewSubnetLabels(cfg *SubnetLabelsConfig) (*SubnetLabels, error) {
+	sl := &SubnetLabels{path: cfg.Path}
+	if _, err := sl.reload(); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadPeriod > 0 {
+		go sl.watch(cfg.ReloadPeriod)
+	}
+	return sl, nil
+}
+
+func (sl *SubnetLabels) Enrich(record *Record) {
+	sl.mt.RLock()
+	defer sl.mt.RUnlock()
+	record.SrcLabels = sl.table.lookup(record.Id.SrcIp)
+	record.DstLabels = sl.table.lookup(record.Id.DstIp)
+}
+
+// watch reloads the file each period, if its modification time or size changed. If the new
+// file can't be loaded, the previous mapping is kept.
+func (sl *SubnetLabels) watch(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := sl.reload()
+		if err != nil {
+			sllog.WithError(err).Warn("can't reload subnet labels file. Keeping previous labels")
+		} else if reloaded {
+			sllog.WithField("path", sl.path).Info("subnet labels file reloaded")
+		}
+	}
+}
+
+// reload loads the file if it changed since the last load, and returns whether it did
+func (sl *SubnetLabels) reload() (bool, error) {
+	info, err := os.Stat(sl.path)
+	if err != nil {
+		return false, fmt.Errorf("reading subnet labels file: %w", err)
+	}
+	if sl.table != nil && info.ModTime().Equal(sl.modTime) && info.Size() == sl.size {
+		return false, nil
+	}
+	table, err := loadSubnetTable(sl.path)
+	if err != nil {
+		return false, err
+	}
+	sl.mt.Lock()
+	sl.table = table
+	sl.mt.Unlock()
+	sl.modTime, sl.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+func loadSubnetTable(path string) (*subnetTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading subnet labels file: %w", err)
+	}
+	defer file.Close()
+	table := &subnetTable{subnets: map[int]map[IPAddr]map[string]string{}}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		err = table.loadCSV(file)
+	case ".yaml", ".yml":
+		err = table.loadYAML(file)
+	default:
+		err = errors.New("unknown format. Accepted extensions are .csv, .yaml and .yml")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing subnet labels file %s: %w", path, err)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(table.lengths)))
+	return table, nil
+}
+
+// loadCSV reads a CSV file whose header has a "subnet" column followed by the label names,
+// e.g. "subnet,team,environment". The empty cells are not set as labels.
+func (st *subnetTable) loadCSV(in io.Reader) error {
+	reader := csv.NewReader(in)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("reading CSV header: %w", err)
+	}
+	if len(header) < 2 || strings.TrimSpace(header[0]) != "subnet" {
+		return errors.New(`the CSV header must be "subnet" followed by the label names`)
+	}
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(row) != len(header) {
+			sllog.WithField("line", line).Warnf("expected %d columns, found %d. Skipping entry",
+				len(header), len(row))
+			continue
+		}
+		labels := map[string]string{}
+		for i, value := range row[1:] {
+			if value = strings.TrimSpace(value); value != "" {
+				labels[strings.TrimSpace(header[i+1])] = value
+			}
+		}
+		if err := st.add(row[0], labels); err != nil {
+			sllog.WithField("line", line).WithError(err).Warn("invalid entry. Skipping it")
+		}
+	}
+}
+
+// loadYAML reads a YAML list of entries with the subnet and its labels
+func (st *subnetTable) loadYAML(in io.Reader) error {
+	var entries []subnetLabelsEntry
+	if err := yaml.NewDecoder(in).Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	for i, entry := range entries {
+		if err := st.add(entry.Subnet, entry.Labels); err != nil {
+			sllog.WithField("entry", i).WithError(err).Warn("invalid entry. Skipping it")
+		}
+	}
+	return nil
+}
+
+func (st *subnetTable) add(subnet string, labels map[string]string) error {
+	_, ipNet, err := net.ParseCIDR(strings.TrimSpace(subnet))
+	if err != nil {
+		return err
+	}
+	if len(labels) == 0 {
+		return fmt.Errorf("subnet %s has no labels", subnet)
+	}
+	ones, _ := ipNet.Mask.Size()
+	if ipNet.IP.To4() != nil {
+		ones += 8 * (net.IPv6len - net.IPv4len)
+	}
+	var addr IPAddr
+	copy(addr[:], ipNet.IP.To16())
+	bySubnet, ok := st.subnets[ones]
+	if !ok {
+		bySubnet = map[IPAddr]map[string]string{}
+		st.subnets[ones] = bySubnet
+		st.lengths = append(st.lengths, ones)
+	}
+	if _, ok := bySubnet[addr]; ok {
+		return fmt.Errorf("duplicate subnet %s", subnet)
+	}
+	bySubnet[addr] = labels
+	return nil
+}
+
+// lookup returns the labels of the longest prefix that contains the address, or nil if none
+func (st *subnetTable) lookup(addr IPAddr) map[string]string {
+	for _, ones := range st.lengths {
+		if labels, ok := st.subnets[ones][maskIPAddr(addr, ones)]; ok {
+			return labels
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package flow
+
+import (
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func subnetLabelsRecord(src, dst string) *Record {
+	r := &Record{}
+	copy(r.Id.SrcIp[:], net.ParseIP(src).To16())
+	copy(r.Id.DstIp[:], net.ParseIP(dst).To16())
+	return r
+}
+
+func TestSubnetLabels_LongestPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		file    string
+		content string
+	}{{
+		file: "labels.csv",
+		content: "subnet,team,environment\n" +
+			"# the whole private range\n" +
+			"10.0.0.0/8,platform,prod\n" +
+			"10.1.0.0/16,payments,\n" +
+			"10.1.2.0/24,payments,staging\n" +
+			"not-a-subnet,foo,bar\n" +
+			"10.2.0.0/16,too,many,columns\n" +
+			"2001:db8::/32,edge,prod\n",
+	}, {
+		file: "labels.yaml",
+		content: `
+- subnet: 10.0.0.0/8
+  labels: {team: platform, environment: prod}
+- subnet: 10.1.0.0/16
+  labels: {team: payments}
+- subnet: 10.1.2.0/24
+  labels: {team: payments, environment: staging}
+- subnet: not-a-subnet
+  labels: {team: foo}
+- subnet: 10.2.0.0/16
+- subnet: 2001:db8::/32
+  labels: {team: edge, environment: prod}
+`,
+	}} {
+		t.Run(tc.file, func(t *testing.T) {
+			file := path.Join(t.TempDir(), tc.file)
+			require.NoError(t, os.WriteFile(file, []byte(tc.content), 0o600))
+			sl, err := NewSubnetLabels(&SubnetLabelsConfig{Path: file})
+			require.NoError(t, err)
+
+			// the labels of the most specific subnet are assigned to each address
+			for _, c := range []struct {
+				addr   string
+				labels map[string]string
+			}{
+				{"10.1.2.3", map[string]string{"team": "payments", "environment": "staging"}},
+				{"10.1.3.3", map[string]string{"team": "payments"}},
+				{"10.2.0.1", map[string]string{"team": "platform", "environment": "prod"}},
+				{"10.200.0.1", map[string]string{"team": "platform", "environment": "prod"}},
+				{"2001:db8::1", map[string]string{"team": "edge", "environment": "prod"}},
+				{"192.168.0.1", nil},
+				{"2001:db9::1", nil},
+			} {
+				record := subnetLabelsRecord(c.addr, "192.168.0.1")
+				sl.Enrich(record)
+				assert.Equal(t, c.labels, record.SrcLabels, c.addr)
+				assert.Nil(t, record.DstLabels, c.addr)
+
+				record = subnetLabelsRecord("192.168.0.1", c.addr)
+				sl.Enrich(record)
+				assert.Nil(t, record.SrcLabels, c.addr)
+				assert.Equal(t, c.labels, record.DstLabels, c.addr)
+			}
+		})
+	}
+}
+
+func TestSubnetLabels_Reload(t *testing.T) {
+	file := path.Join(t.TempDir(), "labels.csv")
+	require.NoError(t, os.WriteFile(file, []byte("subnet,team\n10.0.0.0/8,platform\n"), 0o600))
+	sl, err := NewSubnetLabels(&SubnetLabelsConfig{Path: file, ReloadPeriod: 10 * time.Millisecond})
+	require.NoError(t, err)
+	record := subnetLabelsRecord("10.1.2.3", "10.3.2.1")
+	sl.Enrich(record)
+	assert.Equal(t, map[string]string{"team": "platform"}, record.SrcLabels)
+
+	// WHEN the file changes
+	require.NoError(t, os.WriteFile(file,
+		[]byte("subnet,team\n10.0.0.0/8,platform\n10.1.0.0/16,payments\n"), 0o600))
+	// THEN the new labels are eventually assigned
+	assert.Eventually(t, func() bool {
+		record := subnetLabelsRecord("10.1.2.3", "10.3.2.1")
+		sl.Enrich(record)
+		return record.SrcLabels["team"] == "payments" && record.DstLabels["team"] == "platform"
+	}, timeout, 10*time.Millisecond)
+}
+
+func TestSubnetLabels_InvalidFile(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewSubnetLabels(&SubnetLabelsConfig{Path: path.Join(dir, "missing.csv")})
+	assert.Error(t, err)
+
+	for name, content := range map[string]string{
+		"labels.json":    `[{"subnet": "10.0.0.0/8"}]`,
+		"no-header.csv":  "10.0.0.0/8,platform\n",
+		"malformed.yaml": "subnet: [",
+	} {
+		file := path.Join(dir, name)
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		_, err := NewSubnetLabels(&SubnetLabelsConfig{Path: file})
+		assert.Error(t, err, name)
+	}
+}
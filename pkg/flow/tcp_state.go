@@ -0,0 +1,200 @@
+package flow
+
+import (
+	"bytes"
+	"syscall"
+	"time"
+)
+
+// TCP flags, as set by the eBPF program in the flow metrics. Values according to RFC 9293 and
+// https://www.iana.org/assignments/ipfix/ipfix.xhtml, plus custom flags for combined values.
+const (
+	TCPFlagFIN    = uint16(0x01)
+	TCPFlagSYN    = uint16(0x02)
+	TCPFlagRST    = uint16(0x04)
+	TCPFlagPSH    = uint16(0x08)
+	TCPFlagACK    = uint16(0x10)
+	TCPFlagURG    = uint16(0x20)
+	TCPFlagECE    = uint16(0x40)
+	TCPFlagCWR    = uint16(0x80)
+	TCPFlagSYNACK = uint16(0x100)
+	TCPFlagFINACK = uint16(0x200)
+	TCPFlagRSTACK = uint16(0x400)
+)
+
+// EnricherTCPState decorates the TCP flows with the connection state derived from the
+// observed TCP flags
+const EnricherTCPState = "tcpState"
+
+// DefaultTCPStateExpiry is the time after which a TCP connection is forgotten by the
+// TCPStateTracker if no flows have been observed for it
+const DefaultTCPStateExpiry = 5 * time.Minute
+
+// TCPState is a simplified view of the state of a TCP connection, as derived from the
+// TCP flags that are observed in both directions.
+type TCPState uint8
+
+const (
+	// TCPStateUnknown is reported for non-TCP flows, or TCP flows whose state can't be derived
+	TCPStateUnknown TCPState = iota
+	// TCPStateSynSent means that the connection has been requested by the client (SYN)
+	TCPStateSynSent
+	// TCPStateSynReceived means that the server accepted the connection (SYN+ACK)
+	TCPStateSynReceived
+	// TCPStateEstablished means that the handshake finished or that data is being exchanged
+	TCPStateEstablished
+	// TCPStateFinWait means that one of the sides requested closing the connection (FIN)
+	TCPStateFinWait
+	// TCPStateClosed means that both sides closed the connection, or that it was reset (RST)
+	TCPStateClosed
+)
+
+func (s TCPState) String() string {
+	switch s {
+	case TCPStateUnknown:
+		return "UNKNOWN"
+	case TCPStateSynSent:
+		return "SYN_SENT"
+	case TCPStateSynReceived:
+		return "SYN_RECEIVED"
+	case TCPStateEstablished:
+		return "ESTABLISHED"
+	case TCPStateFinWait:
+		return "FIN_WAIT"
+	case TCPStateClosed:
+		return "CLOSED"
+	default:
+		return "invalid"
+	}
+}
+
+func (s TCPState) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + s.String() + `"`), nil
+}
+
+func (s TCPState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// connection side from which a flow is observed
+type connSide uint8
+
+const (
+	sideLow connSide = 1 << iota
+	sideHigh
+)
+
+// connKey identifies a TCP connection in both directions
+type connKey struct {
+	lowIP    IPAddr
+	highIP   IPAddr
+	lowPort  uint16
+	highPort uint16
+}
+
+type connEntry struct {
+	state    TCPState
+	finSides connSide
+	lastSeen time.Time
+}
+
+// TCPStateTracker derives the state of the TCP connections from the flags that are
+// accumulated in the flows. Since the eBPF program aggregates the flags of all the packets
+// received during an eviction period, the flags of each flow are applied in the order they
+// would normally be sent within a connection lifecycle.
+type TCPStateTracker struct {
+	conns     map[connKey]*connEntry
+	expiry    time.Duration
+	lastPurge time.Time
+	clock     func() time.Time
+}
+
+func NewTCPStateTracker(expiry time.Duration, clock func() time.Time) *TCPStateTracker {
+	return &TCPStateTracker{
+		conns:     map[connKey]*connEntry{},
+		expiry:    expiry,
+		lastPurge: clock(),
+		clock:     clock,
+	}
+}
+
+func init() {
+	RegisterEnricher(EnricherTCPState, func(_ *EnricherContext) (Enricher, error) {
+		return NewTCPStateTracker(DefaultTCPStateExpiry, time.Now), nil
+	})
+}
+
+// Enrich updates the state of the connection the record belongs to, and sets it into
+// the record TCPState field
+func (t *TCPStateTracker) Enrich(record *Record) {
+	if record.Id.TransportProtocol != syscall.IPPROTO_TCP {
+		return
+	}
+	now := t.clock()
+	t.purgeExpired(now)
+
+	key, side := connectionOf(record)
+	entry, ok := t.conns[key]
+	if !ok {
+		entry = &connEntry{}
+		t.conns[key] = entry
+	}
+	entry.lastSeen = now
+	entry.update(record.Metrics.Flags, side)
+	record.TCPState = entry.state
+}
+
+func connectionOf(record *Record) (connKey, connSide) {
+	id := &record.Id
+	cmp := bytes.Compare(id.SrcIp[:], id.DstIp[:])
+	if cmp < 0 || (cmp == 0 && id.SrcPort <= id.DstPort) {
+		return connKey{lowIP: id.SrcIp, highIP: id.DstIp, lowPort: id.SrcPort, highPort: id.DstPort},
+			sideLow
+	}
+	return connKey{lowIP: id.DstIp, highIP: id.SrcIp, lowPort: id.DstPort, highPort: id.SrcPort},
+		sideHigh
+}
+
+func (e *connEntry) update(flags uint16, side connSide) {
+	if flags&TCPFlagSYN != 0 {
+		if e.state == TCPStateUnknown || e.state == TCPStateClosed {
+			// new connection, or connection reuse after being closed
+			e.state = TCPStateSynSent
+			e.finSides = 0
+		}
+	}
+	if flags&TCPFlagSYNACK != 0 {
+		if e.state == TCPStateUnknown || e.state == TCPStateSynSent {
+			e.state = TCPStateSynReceived
+		}
+	}
+	if flags&(TCPFlagACK|TCPFlagPSH) != 0 {
+		if e.state == TCPStateUnknown || e.state == TCPStateSynSent ||
+			e.state == TCPStateSynReceived {
+			e.state = TCPStateEstablished
+		}
+	}
+	if flags&(TCPFlagFIN|TCPFlagFINACK) != 0 && e.state != TCPStateClosed {
+		e.finSides |= side
+		if e.finSides == sideLow|sideHigh {
+			e.state = TCPStateClosed
+		} else {
+			e.state = TCPStateFinWait
+		}
+	}
+	if flags&(TCPFlagRST|TCPFlagRSTACK) != 0 {
+		e.state = TCPStateClosed
+	}
+}
+
+func (t *TCPStateTracker) purgeExpired(now time.Time) {
+	if now.Sub(t.lastPurge) < t.expiry {
+		return
+	}
+	t.lastPurge = now
+	for key, entry := range t.conns {
+		if now.Sub(entry.lastSeen) >= t.expiry {
+			delete(t.conns, key)
+		}
+	}
+}
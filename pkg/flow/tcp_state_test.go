@@ -0,0 +1,104 @@
+package flow
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+)
+
+var (
+	tcpClient = ebpf.BpfFlowId{
+		SrcIp: IPAddr{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 2}, SrcPort: 34567,
+		DstIp: IPAddr{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1}, DstPort: 80,
+		TransportProtocol: 6,
+	}
+	tcpServer = ebpf.BpfFlowId{
+		SrcIp: tcpClient.DstIp, SrcPort: tcpClient.DstPort,
+		DstIp: tcpClient.SrcIp, DstPort: tcpClient.SrcPort,
+		TransportProtocol: 6,
+	}
+)
+
+func tcpFlow(id ebpf.BpfFlowId, flags uint16) *Record {
+	return &Record{RawRecord: RawRecord{Id: id, Metrics: ebpf.BpfFlowMetrics{Flags: flags}}}
+}
+
+func TestTCPStateTracker_Lifecycle(t *testing.T) {
+	tracker := NewTCPStateTracker(time.Minute, time.Now)
+	for _, step := range []struct {
+		desc     string
+		flow     *Record
+		expected TCPState
+	}{
+		{"client SYN", tcpFlow(tcpClient, TCPFlagSYN), TCPStateSynSent},
+		{"server SYN+ACK", tcpFlow(tcpServer, TCPFlagSYNACK), TCPStateSynReceived},
+		{"client ACK", tcpFlow(tcpClient, TCPFlagACK), TCPStateEstablished},
+		{"client data", tcpFlow(tcpClient, TCPFlagPSH|TCPFlagACK), TCPStateEstablished},
+		{"server data", tcpFlow(tcpServer, TCPFlagACK), TCPStateEstablished},
+		{"client FIN", tcpFlow(tcpClient, TCPFlagFINACK), TCPStateFinWait},
+		{"server ACK", tcpFlow(tcpServer, TCPFlagACK), TCPStateFinWait},
+		{"server FIN", tcpFlow(tcpServer, TCPFlagFINACK), TCPStateClosed},
+		{"client last ACK", tcpFlow(tcpClient, TCPFlagACK), TCPStateClosed},
+		{"connection reuse", tcpFlow(tcpClient, TCPFlagSYN), TCPStateSynSent},
+	} {
+		tracker.Enrich(step.flow)
+		assert.Equalf(t, step.expected, step.flow.TCPState, "%s: expected %s. Got %s",
+			step.desc, step.expected, step.flow.TCPState)
+	}
+}
+
+func TestTCPStateTracker_AggregatedFlags(t *testing.T) {
+	// handshake and data are aggregated in the same eviction period
+	tracker := NewTCPStateTracker(time.Minute, time.Now)
+	client := tcpFlow(tcpClient, TCPFlagSYN|TCPFlagACK|TCPFlagPSH)
+	tracker.Enrich(client)
+	assert.Equal(t, TCPStateEstablished, client.TCPState)
+
+	// whole connection lifecycle aggregated in a single flow
+	tracker = NewTCPStateTracker(time.Minute, time.Now)
+	client = tcpFlow(tcpClient, TCPFlagSYN|TCPFlagACK|TCPFlagFINACK)
+	server := tcpFlow(tcpServer, TCPFlagSYNACK|TCPFlagACK|TCPFlagFINACK)
+	tracker.Enrich(client)
+	tracker.Enrich(server)
+	assert.Equal(t, TCPStateFinWait, client.TCPState)
+	assert.Equal(t, TCPStateClosed, server.TCPState)
+}
+
+func TestTCPStateTracker_Reset(t *testing.T) {
+	tracker := NewTCPStateTracker(time.Minute, time.Now)
+	tracker.Enrich(tcpFlow(tcpClient, TCPFlagSYN))
+	server := tcpFlow(tcpServer, TCPFlagRSTACK)
+	tracker.Enrich(server)
+	assert.Equal(t, TCPStateClosed, server.TCPState)
+}
+
+func TestTCPStateTracker_IgnoreNonTCP(t *testing.T) {
+	tracker := NewTCPStateTracker(time.Minute, time.Now)
+	udp := tcpFlow(tcpClient, 0)
+	udp.Id.TransportProtocol = 17
+	tracker.Enrich(udp)
+	assert.Equal(t, TCPStateUnknown, udp.TCPState)
+	assert.Empty(t, tracker.conns)
+}
+
+func TestTCPStateTracker_Expiry(t *testing.T) {
+	now := time.Now()
+	tracker := NewTCPStateTracker(time.Minute, func() time.Time { return now })
+	tracker.Enrich(tcpFlow(tcpClient, TCPFlagSYN))
+
+	// the connection is forgotten after being inactive for the expiry time
+	now = now.Add(2 * time.Minute)
+	other := tcpFlow(tcpClient, TCPFlagACK)
+	copy(other.Id.SrcIp[:], net.ParseIP("10.0.0.3").To16())
+	tracker.Enrich(other)
+	assert.Len(t, tracker.conns, 1)
+
+	client := tcpFlow(tcpClient, TCPFlagFIN)
+	tracker.Enrich(client)
+	assert.Equal(t, TCPStateFinWait, client.TCPState)
+	assert.Len(t, tracker.conns, 2)
+}
@@ -0,0 +1,106 @@
+package flow
+
+import (
+	"time"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// FilterBelowThreshold receives flows and drops those whose bytes and/or packets are below the
+// provided minimums. A zero minimum disables its threshold. If both thresholds are enabled,
+// matchAll tells whether a flow must reach both of them to be forwarded, or any of them.
+func FilterBelowThreshold(
+	minBytes uint64, minPackets uint32, matchAll bool, m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	droppedCounter := m.NewCounter("below_threshold_dropped_flows_total",
+		"Number of flows that have been dropped because they didn't reach the minimum "+
+			"bytes or packets")
+	reaches := func(r *Record) bool {
+		bytesOK := r.Metrics.Bytes >= minBytes
+		packetsOK := r.Metrics.Packets >= minPackets
+		switch {
+		case minBytes == 0:
+			return packetsOK
+		case minPackets == 0:
+			return bytesOK
+		case matchAll:
+			return bytesOK && packetsOK
+		default:
+			return bytesOK || packetsOK
+		}
+	}
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			fwd := make([]*Record, 0, len(records))
+			for _, record := range records {
+				if reaches(record) {
+					fwd = append(fwd, record)
+				}
+			}
+			if dropped := len(records) - len(fwd); dropped > 0 {
+				droppedCounter.Add(float64(dropped))
+			}
+			if len(fwd) > 0 {
+				out <- fwd
+			}
+		}
+	}
+}
+
+// DropEmpty receives flows and drops those without any accounted packet nor byte (e.g. map
+// entries that have been evicted before any packet was accounted), so they don't pollute the
+// downstream counts. Flows with any packet or byte are always forwarded.
+func DropEmpty(m *metrics.Metrics) func(in <-chan []*Record, out chan<- []*Record) {
+	droppedCounter := m.NewCounter("empty_dropped_flows_total",
+		"Number of flows that have been dropped because they had zero packets and bytes")
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			fwd := make([]*Record, 0, len(records))
+			for _, record := range records {
+				if record.Metrics.Packets > 0 || record.Metrics.Bytes > 0 {
+					fwd = append(fwd, record)
+				}
+			}
+			if dropped := len(records) - len(fwd); dropped > 0 {
+				droppedCounter.Add(float64(dropped))
+			}
+			if len(fwd) > 0 {
+				out <- fwd
+			}
+		}
+	}
+}
+
+// FilterShorterThan receives flows and drops those whose duration, measured between the eBPF
+// timestamps of their first and last packets, is below the provided minimum. Single-packet
+// flows have a zero duration, so keepSinglePacket tells whether they are forwarded regardless
+// of the minimum duration, or dropped as any other short flow.
+func FilterShorterThan(
+	minDuration time.Duration, keepSinglePacket bool, m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	droppedCounter := m.NewCounter("short_dropped_flows_total",
+		"Number of flows that have been dropped because they didn't reach the minimum duration")
+	lasts := func(r *Record) bool {
+		if r.Metrics.Packets <= 1 {
+			return keepSinglePacket
+		}
+		return r.Metrics.EndMonoTimeTs >= r.Metrics.StartMonoTimeTs &&
+			time.Duration(r.Metrics.EndMonoTimeTs-r.Metrics.StartMonoTimeTs) >= minDuration
+	}
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			fwd := make([]*Record, 0, len(records))
+			for _, record := range records {
+				if lasts(record) {
+					fwd = append(fwd, record)
+				}
+			}
+			if dropped := len(records) - len(fwd); dropped > 0 {
+				droppedCounter.Add(float64(dropped))
+			}
+			if len(fwd) > 0 {
+				out <- fwd
+			}
+		}
+	}
+}
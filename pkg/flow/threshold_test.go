@@ -0,0 +1,135 @@
+package flow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestFilterBelowThreshold(t *testing.T) {
+	flow := func(srcPort uint16, bytes uint64, packets uint32) *Record {
+		return &Record{RawRecord: RawRecord{
+			Id:      ebpf.BpfFlowId{SrcPort: srcPort},
+			Metrics: ebpf.BpfFlowMetrics{Bytes: bytes, Packets: packets},
+		}}
+	}
+	flows := []*Record{
+		flow(1, 100, 1),      // below both thresholds
+		flow(2, 10_000, 2),   // only reaches the bytes threshold
+		flow(3, 500, 20),     // only reaches the packets threshold
+		flow(4, 20_000, 100), // reaches both thresholds
+	}
+	for _, tc := range []struct {
+		name       string
+		minBytes   uint64
+		minPackets uint32
+		matchAll   bool
+		expected   []uint16
+	}{
+		{name: "bytes", minBytes: 1000, expected: []uint16{2, 4}},
+		{name: "packets", minPackets: 10, expected: []uint16{3, 4}},
+		{name: "any threshold", minBytes: 1000, minPackets: 10, expected: []uint16{2, 3, 4}},
+		{name: "all thresholds", minBytes: 1000, minPackets: 10, matchAll: true, expected: []uint16{4}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := metrics.NoOp()
+			in := make(chan []*Record, 1)
+			out := make(chan []*Record, 1)
+			go FilterBelowThreshold(tc.minBytes, tc.minPackets, tc.matchAll, m)(in, out)
+			defer close(in)
+
+			in <- flows
+			var srcPorts []uint16
+			for _, r := range receiveTimeout(t, out) {
+				srcPorts = append(srcPorts, r.Id.SrcPort)
+			}
+			assert.Equal(t, tc.expected, srcPorts)
+			assert.EqualValues(t, len(flows)-len(tc.expected),
+				counterValue(t, m, "below_threshold_dropped_flows_total"))
+		})
+	}
+}
+
+func TestDropEmpty(t *testing.T) {
+	flow := func(srcPort uint16, bytes uint64, packets uint32) *Record {
+		return &Record{RawRecord: RawRecord{
+			Id:      ebpf.BpfFlowId{SrcPort: srcPort},
+			Metrics: ebpf.BpfFlowMetrics{Bytes: bytes, Packets: packets},
+		}}
+	}
+	m := metrics.NoOp()
+	in := make(chan []*Record, 2)
+	out := make(chan []*Record, 2)
+	go DropEmpty(m)(in, out)
+	defer close(in)
+
+	in <- []*Record{
+		flow(1, 0, 0), // empty
+		flow(2, 100, 1),
+		flow(3, 0, 1), // a packet without L3 payload bytes is still accounted
+		flow(4, 60, 0),
+	}
+	var srcPorts []uint16
+	for _, r := range receiveTimeout(t, out) {
+		srcPorts = append(srcPorts, r.Id.SrcPort)
+	}
+	assert.Equal(t, []uint16{2, 3, 4}, srcPorts)
+
+	// batches with only empty flows are not forwarded
+	in <- []*Record{flow(5, 0, 0)}
+	in <- []*Record{flow(6, 10, 1)}
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.EqualValues(t, 6, records[0].Id.SrcPort)
+	assert.EqualValues(t, 2, counterValue(t, m, "empty_dropped_flows_total"))
+}
+
+func TestFilterShorterThan(t *testing.T) {
+	flow := func(srcPort uint16, packets uint32, duration time.Duration) *Record {
+		return &Record{RawRecord: RawRecord{
+			Id: ebpf.BpfFlowId{SrcPort: srcPort},
+			Metrics: ebpf.BpfFlowMetrics{
+				Packets:         packets,
+				StartMonoTimeTs: 1_000_000,
+				EndMonoTimeTs:   1_000_000 + uint64(duration),
+			},
+		}}
+	}
+	flows := []*Record{
+		flow(1, 1, 0),                    // single packet
+		flow(2, 3, 500*time.Microsecond), // too short
+		flow(3, 3, time.Millisecond),     // exactly the minimum duration
+		flow(4, 100, 2*time.Second),      // long flow
+		flow(5, 2, 100*time.Microsecond), // too short
+	}
+	for _, tc := range []struct {
+		name             string
+		keepSinglePacket bool
+		expected         []uint16
+	}{
+		{name: "exclude single packet", expected: []uint16{3, 4}},
+		{name: "include single packet", keepSinglePacket: true, expected: []uint16{1, 3, 4}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := metrics.NoOp()
+			in := make(chan []*Record, 1)
+			out := make(chan []*Record, 1)
+			go FilterShorterThan(time.Millisecond, tc.keepSinglePacket, m)(in, out)
+			defer close(in)
+
+			in <- flows
+			var srcPorts []uint16
+			for _, r := range receiveTimeout(t, out) {
+				srcPorts = append(srcPorts, r.Id.SrcPort)
+			}
+			assert.Equal(t, tc.expected, srcPorts)
+			assert.EqualValues(t, len(flows)-len(tc.expected),
+				counterValue(t, m, "short_dropped_flows_total"))
+		})
+	}
+}
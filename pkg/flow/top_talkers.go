@@ -0,0 +1,121 @@
+package flow
+
+import (
+	"container/heap"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// TopTalkers receives flows and, at the end of each window, forwards only the n flows with the
+// most bytes (or packets, if byPackets is true) that have been received during the window, from
+// the largest to the smallest. Only n flows are kept in memory. Each received record competes
+// on its own, so the window should match the period of the flows' eviction, to avoid the same
+// flow being accounted from multiple evictions. The remaining top flows are forwarded when the
+// input channel is closed.
+func TopTalkers(
+	n int, byPackets bool, window time.Duration, m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	discardedCounter := m.NewCounter("top_talkers_discarded_flows_total",
+		"Number of flows that have been discarded because they weren't among the top talkers "+
+			"of their window")
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		top := newTopTalkersHeap(n, byPackets, discardedCounter)
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+		for {
+			select {
+			case records, ok := <-in:
+				if !ok {
+					if flows := top.flush(); len(flows) > 0 {
+						out <- flows
+					}
+					return
+				}
+				for _, record := range records {
+					top.add(record)
+				}
+			case <-ticker.C:
+				if flows := top.flush(); len(flows) > 0 {
+					out <- flows
+				}
+			}
+		}
+	}
+}
+
+// topTalkersHeap is a min-heap bounded to n records, whose root is the smallest of the top
+// records, so it can be replaced by any larger record
+type topTalkersHeap struct {
+	n                int
+	byPackets        bool
+	records          []*Record
+	discardedCounter prometheus.Counter
+}
+
+func newTopTalkersHeap(n int, byPackets bool, discarded prometheus.Counter) *topTalkersHeap {
+	return &topTalkersHeap{
+		n:                n,
+		byPackets:        byPackets,
+		records:          make([]*Record, 0, n),
+		discardedCounter: discarded,
+	}
+}
+
+func (th *topTalkersHeap) size(r *Record) uint64 {
+	if th.byPackets {
+		return uint64(r.Metrics.Packets)
+	}
+	return r.Metrics.Bytes
+}
+
+// add the record to the heap if it is among the n largest received records
+func (th *topTalkersHeap) add(r *Record) {
+	if len(th.records) < th.n {
+		heap.Push(th, r)
+		return
+	}
+	th.discardedCounter.Inc()
+	if th.size(r) > th.size(th.records[0]) {
+		th.records[0] = r
+		heap.Fix(th, 0)
+	}
+}
+
+// flush returns the top records, from the largest to the smallest, and resets the heap
+func (th *topTalkersHeap) flush() []*Record {
+	if len(th.records) == 0 {
+		return nil
+	}
+	flows := th.records
+	sort.SliceStable(flows, func(i, j int) bool {
+		return th.size(flows[i]) > th.size(flows[j])
+	})
+	th.records = make([]*Record, 0, th.n)
+	return flows
+}
+
+// heap.Interface implementation
+
+func (th *topTalkersHeap) Len() int { return len(th.records) }
+
+func (th *topTalkersHeap) Less(i, j int) bool {
+	return th.size(th.records[i]) < th.size(th.records[j])
+}
+
+func (th *topTalkersHeap) Swap(i, j int) {
+	th.records[i], th.records[j] = th.records[j], th.records[i]
+}
+
+func (th *topTalkersHeap) Push(x interface{}) {
+	th.records = append(th.records, x.(*Record))
+}
+
+func (th *topTalkersHeap) Pop() interface{} {
+	last := th.records[len(th.records)-1]
+	th.records = th.records[:len(th.records)-1]
+	return last
+}
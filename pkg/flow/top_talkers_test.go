@@ -0,0 +1,84 @@
+package flow
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestTopTalkers(t *testing.T) {
+	flow := func(srcPort uint16, bytes uint64, packets uint32) *Record {
+		return &Record{RawRecord: RawRecord{
+			Id:      ebpf.BpfFlowId{SrcPort: srcPort},
+			Metrics: ebpf.BpfFlowMetrics{Bytes: bytes, Packets: packets},
+		}}
+	}
+	// many flows, whose source port is its rank by bytes (1 is the largest) and whose packets
+	// rank in the opposite order
+	flows := make([]*Record, 0, 1000)
+	for port := 1; port <= 1000; port++ {
+		flows = append(flows, flow(uint16(port), uint64(1_000_000-port), uint32(port)))
+	}
+	rand.Shuffle(len(flows), func(i, j int) { flows[i], flows[j] = flows[j], flows[i] })
+
+	for _, tc := range []struct {
+		name      string
+		byPackets bool
+		expected  []uint16
+	}{
+		{name: "bytes", expected: []uint16{1, 2, 3, 4, 5}},
+		{name: "packets", byPackets: true, expected: []uint16{1000, 999, 998, 997, 996}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := metrics.NoOp()
+			in := make(chan []*Record, 10)
+			out := make(chan []*Record, 10)
+			go TopTalkers(5, tc.byPackets, time.Hour, m)(in, out)
+
+			// WHEN the flows are received in multiple batches during a window
+			for i := 0; i < len(flows); i += 100 {
+				in <- flows[i : i+100]
+			}
+			close(in)
+
+			// THEN only the N largest flows are forwarded at the end of the window
+			var srcPorts []uint16
+			for _, r := range receiveTimeout(t, out) {
+				srcPorts = append(srcPorts, r.Id.SrcPort)
+			}
+			assert.Equal(t, tc.expected, srcPorts)
+			assert.EqualValues(t, len(flows)-5,
+				counterValue(t, m, "top_talkers_discarded_flows_total"))
+		})
+	}
+}
+
+func TestTopTalkers_Windows(t *testing.T) {
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go TopTalkers(2, false, 20*time.Millisecond, metrics.NoOp())(in, out)
+	defer close(in)
+
+	flow := func(srcPort uint16, bytes uint64) *Record {
+		return &Record{RawRecord: RawRecord{
+			Id: ebpf.BpfFlowId{SrcPort: srcPort}, Metrics: ebpf.BpfFlowMetrics{Bytes: bytes},
+		}}
+	}
+	// the top talkers are forwarded at the end of each window, which starts from scratch
+	in <- []*Record{flow(1, 100), flow(2, 300), flow(3, 200)}
+	top := receiveTimeout(t, out)
+	require.Len(t, top, 2)
+	assert.EqualValues(t, 2, top[0].Id.SrcPort)
+	assert.EqualValues(t, 3, top[1].Id.SrcPort)
+
+	in <- []*Record{flow(4, 10)}
+	top = receiveTimeout(t, out)
+	require.Len(t, top, 1)
+	assert.EqualValues(t, 4, top[0].Id.SrcPort)
+}
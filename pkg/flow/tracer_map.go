@@ -2,7 +2,11 @@ package flow
 
 import (
 	"context"
+	"encoding/binary"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gavv/monotime"
@@ -14,53 +18,266 @@ import (
 
 var mtlog = logrus.WithField("component", "flow.MapTracer")
 
+// lifetimeChecksPerCap is the number of times the flows' lifetime is checked during the maximum
+// flow lifetime period. A flow might exceed the cap by up to cap/lifetimeChecksPerCap.
+const lifetimeChecksPerCap = 4
+
+// closeChecksPerGrace is the number of times the closed TCP flows are checked during the TCP
+// close grace period. A closed flow might be exported up to grace/closeChecksPerGrace after the
+// grace period.
+const closeChecksPerGrace = 2
+
+// jitterChecksPerWindow is the maximum number of times the flows' flush deadlines are checked
+// during the flush jitter window. A flow might be flushed up to jitter/jitterChecksPerWindow after
+// its deadline.
+const jitterChecksPerWindow = 10
+
+// timeoutChecksPerPeriod is the maximum number of times the flows' flush deadlines are checked
+// during the shortest per-protocol timeout. A flow might be flushed up to
+// timeout/timeoutChecksPerPeriod after its deadline.
+const timeoutChecksPerPeriod = 10
+
+// removedIfacesQueueLen is the number of removed interfaces whose flows can be pending to be
+// evicted. If the queue is full, the flows of the next removed interfaces are evicted with the
+// rest of the flows.
+const removedIfacesQueueLen = 100
+
+// ProtocolTimeouts overrides the eviction timeouts for the flows of a given transport protocol
+type ProtocolTimeouts struct {
+	// Active is the maximum time since a flow started until it is evicted. If zero, the global
+	// eviction timeout is applied.
+	Active time.Duration
+	// Inactive is the time since the last packet of a flow after which it is evicted, even if the
+	// active timeout hasn't elapsed. If zero, the flows are only evicted after the active timeout.
+	Inactive time.Duration
+}
+
 // MapTracer accesses a mapped source of flows (the eBPF PerCPU HashMap), deserializes it into
 // a flow Record structure, and performs the accumulation of each perCPU-record into a single flow
 type MapTracer struct {
 	mapFetcher      mapFetcher
 	evictionTimeout time.Duration
+	maxLifetime     time.Duration
+	flushJitter     time.Duration
+	tcpCloseGrace   time.Duration
+	// protocolTimeouts overrides the eviction timeouts for some transport protocols
+	protocolTimeouts map[uint8]ProtocolTimeouts
+	// removedIfaces receives the indexes of the removed interfaces, whose flows must be evicted
+	removedIfaces chan uint32
 	// manages the access to the eviction routines, avoiding two evictions happening at the same time
 	evictionCond   *sync.Cond
 	lastEvictionNs uint64
+	// completedFirstMin enables the eviction of the completed flows first when the map is full
+	completedFirstMin int
+	// mapFull is 1 if the pending eviction was triggered because the map is full
+	mapFull int32
+	// minFlushFlows is the number of flows that must be in the map to flush them on timer
+	minFlushFlows   int
+	minFlushMaxHold time.Duration
+	// lastEvictedFlows is the number of flows of the last full eviction, which accumulated the
+	// flows of lastEvictedWindows eviction windows. They estimate how many flows are accounted
+	// per window, without scanning the map
+	lastEvictedFlows   int
+	lastEvictedWindows int
+	// heldWindows is the number of flushes that have been skipped since the last full eviction
+	heldWindows int
+	// heldSince is the time of the first timer flush that was skipped, or zero if none is held
+	heldSince time.Time
 }
 
+// mapFetcher reads the flows from the kernel space. The returned flows must be removed from the
+// source map, so a flow that spans multiple eviction windows is accounted from scratch in each
+// window, and the exported records report the delta since the previous eviction.
 type mapFetcher interface {
 	LookupAndDeleteMap() map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	LookupAndDeleteStartedBefore(monoTs uint64) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	LookupAndDeleteMatching(
+		match func(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool,
+	) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+}
+
+// MapTracerConfig holds the eviction options of a MapTracer
+type MapTracerConfig struct {
+	// EvictionTimeout is the period after which all the flows are evicted
+	EvictionTimeout time.Duration
+	// MaxLifetime, if higher than zero, evicts the flows that started longer than MaxLifetime ago,
+	// independently of the EvictionTimeout
+	MaxLifetime time.Duration
+	// FlushJitter, if higher than zero, spreads the flushes in time: instead of evicting all the
+	// flows together, each flow is evicted when EvictionTimeout has elapsed since it started, plus
+	// a per-flow offset within the FlushJitter window
+	FlushJitter time.Duration
+	// TCPCloseGrace, if higher than zero, evicts the TCP flows whose FIN or RST has been observed
+	// once no packet has been observed for them during TCPCloseGrace, independently of the
+	// EvictionTimeout, so the closed connections are exported promptly with their actual
+	// duration. The grace period lets the last packets of the connection (e.g. the final ACKs) be
+	// accounted.
+	TCPCloseGrace time.Duration
+	// ProtocolTimeouts override, for the flows of the transport protocols they are keyed by, the
+	// EvictionTimeout and add an inactive timeout. Then each flow is evicted at its own deadline,
+	// as with the FlushJitter.
+	ProtocolTimeouts map[uint8]ProtocolTimeouts
+	// CompletedFirstMin, if higher than zero, only evicts the completed TCP flows (whose FIN or
+	// RST has been observed) when the map is full, as no more packets are expected for them,
+	// while the active flows keep being aggregated in the map. If less than CompletedFirstMin
+	// flows are completed, all the flows are evicted.
+	CompletedFirstMin int
+	// MinFlushFlows, if higher than zero, skips the flush of each EvictionTimeout while less than
+	// MinFlushFlows flows are estimated to be in the map, according to the flows per window of
+	// the last flush, so they are retained until the next window. The flows are not held longer
+	// than MinFlushMaxHold since the first skipped flush. It doesn't apply to the flows that are
+	// evicted at their own deadline (with FlushJitter or ProtocolTimeouts).
+	MinFlushFlows   int
+	MinFlushMaxHold time.Duration
 }
 
-func NewMapTracer(fetcher mapFetcher, evictionTimeout time.Duration) *MapTracer {
+// NewMapTracer creates a MapTracer that evicts the flows from the fetcher according to the
+// provided configuration
+func NewMapTracer(fetcher mapFetcher, cfg *MapTracerConfig) *MapTracer {
 	return &MapTracer{
-		mapFetcher:      fetcher,
-		evictionTimeout: evictionTimeout,
-		lastEvictionNs:  uint64(monotime.Now()),
-		evictionCond:    sync.NewCond(&sync.Mutex{}),
+		mapFetcher:        fetcher,
+		evictionTimeout:   cfg.EvictionTimeout,
+		maxLifetime:       cfg.MaxLifetime,
+		flushJitter:       cfg.FlushJitter,
+		tcpCloseGrace:     cfg.TCPCloseGrace,
+		protocolTimeouts:  cfg.ProtocolTimeouts,
+		removedIfaces:     make(chan uint32, removedIfacesQueueLen),
+		lastEvictionNs:    uint64(monotime.Now()),
+		evictionCond:      sync.NewCond(&sync.Mutex{}),
+		completedFirstMin: cfg.CompletedFirstMin,
+		minFlushFlows:     cfg.MinFlushFlows,
+		minFlushMaxHold:   cfg.MinFlushMaxHold,
+		// the first window is always flushed, to know how many flows are accounted per window
+		lastEvictedFlows:   cfg.MinFlushFlows,
+		lastEvictedWindows: 1,
 	}
 }
 
 // Flush forces reading (and removing) all the flows from the source eBPF map
 // and sending the entries to the next stage in the pipeline
 func (m *MapTracer) Flush() {
+	atomic.StoreInt32(&m.mapFull, 0)
 	m.evictionCond.Broadcast()
 }
 
+// MapFull makes room in the source eBPF map, after a flow couldn't be added to it. Unless the
+// eviction of completed flows first is enabled, it is equivalent to Flush.
+func (m *MapTracer) MapFull() {
+	if m.completedFirstMin > 0 {
+		atomic.StoreInt32(&m.mapFull, 1)
+	}
+	m.evictionCond.Broadcast()
+}
+
+// InterfaceRemoved evicts, asynchronously, the flows that were observed in the removed interface,
+// so they are exported without waiting for the eviction timeout
+func (m *MapTracer) InterfaceRemoved(ifIndex uint32) {
+	select {
+	case m.removedIfaces <- ifIndex:
+	default:
+		mtlog.WithField("ifIndex", ifIndex).
+			Debug("too many removed interfaces pending. Its flows will be evicted later")
+	}
+}
+
 func (m *MapTracer) TraceLoop(ctx context.Context) node.StartFunc[[]*Record] {
 	return func(out chan<- []*Record) {
-		evictionTicker := time.NewTicker(m.evictionTimeout)
+		// with flush jitter or per-protocol timeouts, the flows are evicted when their own
+		// deadline expires, instead of all together at each eviction timeout. The map is only
+		// checked again when the earliest deadline of the remaining flows is due
+		var evictionTick, deadlineTick <-chan time.Time
+		var deadlineTimer *time.Timer
+		checkPeriod := m.deadlineCheckPeriod()
+		if checkPeriod > 0 {
+			deadlineTimer = time.NewTimer(checkPeriod)
+			defer deadlineTimer.Stop()
+			deadlineTick = deadlineTimer.C
+		} else {
+			evictionTicker := time.NewTicker(m.evictionTimeout)
+			defer evictionTicker.Stop()
+			evictionTick = evictionTicker.C
+		}
+		// a nil channel never receives, so the lifetime check is disabled if there is no cap
+		var lifetimeTick <-chan time.Time
+		if m.maxLifetime > 0 {
+			lifetimeTicker := time.NewTicker(m.maxLifetime / lifetimeChecksPerCap)
+			defer lifetimeTicker.Stop()
+			lifetimeTick = lifetimeTicker.C
+		}
+		var closeTick <-chan time.Time
+		if m.tcpCloseGrace > 0 {
+			closeTicker := time.NewTicker(m.tcpCloseGrace / closeChecksPerGrace)
+			defer closeTicker.Stop()
+			closeTick = closeTicker.C
+		}
 		go m.evictionSynchronization(ctx, out)
 		for {
 			select {
 			case <-ctx.Done():
-				evictionTicker.Stop()
 				mtlog.Debug("exiting trace loop due to context cancellation")
 				return
-			case <-evictionTicker.C:
+			case <-evictionTick:
+				if m.holdFlush(time.Now()) {
+					break
+				}
 				mtlog.Debug("triggering flow eviction on timer")
 				m.Flush()
+			case <-deadlineTick:
+				m.evictionCond.L.Lock()
+				now, earliest := m.evictExpiredFlows(ctx, out)
+				m.evictionCond.L.Unlock()
+				deadlineTimer.Reset(m.nextDeadlineCheck(now, earliest, checkPeriod))
+			case <-lifetimeTick:
+				m.evictionCond.L.Lock()
+				m.evictLongLivedFlows(ctx, out)
+				m.evictionCond.L.Unlock()
+			case <-closeTick:
+				m.evictionCond.L.Lock()
+				m.evictClosedFlows(ctx, out)
+				m.evictionCond.L.Unlock()
+			case ifIndex := <-m.removedIfaces:
+				m.evictionCond.L.Lock()
+				m.evictInterfaceFlows(ctx, out, ifIndex)
+				m.evictionCond.L.Unlock()
 			}
 		}
 	}
 }
 
+// holdFlush returns whether the flush of the current eviction window must be skipped, as there
+// are not enough flows in the map and they haven't been held for the maximum hold time yet.
+// Counting the flows in the map would require iterating over all of them, so they are estimated
+// from the flows per window that were evicted by the last flush, times the windows that have been
+// accumulated since then. If the last flush didn't evict any flow (e.g. the node was idle), the
+// estimate is unknown, so the window is flushed to measure it again.
+func (m *MapTracer) holdFlush(now time.Time) bool {
+	if m.minFlushFlows <= 0 {
+		return false
+	}
+	m.evictionCond.L.Lock()
+	defer m.evictionCond.L.Unlock()
+	if m.lastEvictedFlows == 0 {
+		m.heldSince = time.Time{}
+		return false
+	}
+	flows := m.lastEvictedFlows * (m.heldWindows + 1) / m.lastEvictedWindows
+	if flows >= m.minFlushFlows {
+		m.heldSince = time.Time{}
+		return false
+	}
+	if m.heldSince.IsZero() {
+		m.heldSince = now
+	}
+	if now.Sub(m.heldSince) >= m.minFlushMaxHold {
+		mtlog.Debug("flushing the held flows after reaching the maximum hold time")
+		m.heldSince = time.Time{}
+		return false
+	}
+	mtlog.Debugf("skipping flow eviction on timer: only %d flows estimated in the map", flows)
+	m.heldWindows++
+	return true
+}
+
 // evictionSynchronization loop just waits for the evictionCond to happen
 // and triggers the actual eviction. It makes sure that only one eviction
 // is being triggered at the same time
@@ -77,7 +294,10 @@ func (m *MapTracer) evictionSynchronization(ctx context.Context, out chan<- []*R
 			return
 		default:
 			mtlog.Debug("evictionSynchronization signal received")
-			m.evictFlows(ctx, out)
+			if atomic.SwapInt32(&m.mapFull, 0) == 0 ||
+				m.evictCompletedFlows(ctx, out) < m.completedFirstMin {
+				m.evictFlows(ctx, out)
+			}
 		}
 		m.evictionCond.L.Unlock()
 
@@ -109,6 +329,9 @@ func (m *MapTracer) evictFlows(ctx context.Context, forwardFlows chan<- []*Recor
 		))
 	}
 	m.lastEvictionNs = laterFlowNs
+	m.lastEvictedFlows = len(forwardingFlows)
+	m.lastEvictedWindows = m.heldWindows + 1
+	m.heldWindows = 0
 	select {
 	case <-ctx.Done():
 		mtlog.Debug("skipping flow eviction as agent is being stopped")
@@ -117,3 +340,266 @@ func (m *MapTracer) evictFlows(ctx context.Context, forwardFlows chan<- []*Recor
 	}
 	mtlog.Debugf("%d flows evicted", len(forwardingFlows))
 }
+
+// evictCompletedFlows evicts the TCP flows whose FIN or RST has been observed, and returns the
+// number of evicted flows
+func (m *MapTracer) evictCompletedFlows(ctx context.Context, forwardFlows chan<- []*Record) int {
+	monotonicTimeNow := monotime.Now()
+	currentTime := time.Now()
+
+	var forwardingFlows []*Record
+	completed := m.mapFetcher.LookupAndDeleteMatching(
+		func(_ *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool {
+			// the entries that haven't been aggregated are left for the next full eviction
+			return metric.Flags&(TCPFlagFIN|TCPFlagFINACK|TCPFlagRST|TCPFlagRSTACK) != 0 &&
+				metric.EndMonoTimeTs != 0
+		})
+	for flowKey, flowMetrics := range completed {
+		forwardingFlows = append(forwardingFlows,
+			NewRecord(flowKey, flowMetrics, currentTime, uint64(monotonicTimeNow)))
+	}
+	if len(forwardingFlows) > 0 {
+		select {
+		case <-ctx.Done():
+			mtlog.Debug("skipping flow eviction as agent is being stopped")
+		default:
+			forwardFlows <- forwardingFlows
+		}
+	}
+	mtlog.Debugf("%d completed flows evicted as the map is full", len(forwardingFlows))
+	return len(forwardingFlows)
+}
+
+// evictLongLivedFlows evicts the flows that exceeded the maximum flow lifetime. The next packets
+// of these flows will be accounted in a new entry of the eBPF map, starting fresh.
+func (m *MapTracer) evictLongLivedFlows(ctx context.Context, forwardFlows chan<- []*Record) {
+	monotonicTimeNow := monotime.Now()
+	currentTime := time.Now()
+	if monotonicTimeNow < m.maxLifetime {
+		// the host has been running for less than the flow lifetime
+		return
+	}
+	startedBefore := uint64(monotonicTimeNow - m.maxLifetime)
+
+	var forwardingFlows []*Record
+	for flowKey, flowMetrics := range m.mapFetcher.LookupAndDeleteStartedBefore(startedBefore) {
+		if flowMetrics.EndMonoTimeTs == 0 {
+			continue
+		}
+		record := NewRecord(flowKey, flowMetrics, currentTime, uint64(monotonicTimeNow))
+		record.EndReason = FlowEndReasonLifetimeCap
+		forwardingFlows = append(forwardingFlows, record)
+	}
+	if len(forwardingFlows) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		mtlog.Debug("skipping flow eviction as agent is being stopped")
+	default:
+		forwardFlows <- forwardingFlows
+	}
+	mtlog.Debugf("%d flows evicted after exceeding the maximum lifetime", len(forwardingFlows))
+}
+
+// evictClosedFlows evicts the TCP flows whose FIN or RST has been observed, and whose last packet
+// was observed longer than the TCP close grace period ago
+func (m *MapTracer) evictClosedFlows(ctx context.Context, forwardFlows chan<- []*Record) {
+	monotonicTimeNow := monotime.Now()
+	currentTime := time.Now()
+	if monotonicTimeNow < m.tcpCloseGrace {
+		return
+	}
+	idleSince := uint64(monotonicTimeNow - m.tcpCloseGrace)
+
+	var forwardingFlows []*Record
+	closed := m.mapFetcher.LookupAndDeleteMatching(
+		func(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool {
+			return id.TransportProtocol == syscall.IPPROTO_TCP &&
+				metric.Flags&(TCPFlagFIN|TCPFlagFINACK|TCPFlagRST|TCPFlagRSTACK) != 0 &&
+				metric.EndMonoTimeTs != 0 && metric.EndMonoTimeTs <= idleSince
+		})
+	for flowKey, flowMetrics := range closed {
+		record := NewRecord(flowKey, flowMetrics, currentTime, uint64(monotonicTimeNow))
+		record.EndReason = FlowEndReasonTCPClose
+		forwardingFlows = append(forwardingFlows, record)
+	}
+	if len(forwardingFlows) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		mtlog.Debug("skipping flow eviction as agent is being stopped")
+	default:
+		forwardFlows <- forwardingFlows
+	}
+	mtlog.Debugf("%d closed TCP flows evicted", len(forwardingFlows))
+}
+
+// evictInterfaceFlows evicts the flows that were observed in the interface with the provided index
+func (m *MapTracer) evictInterfaceFlows(ctx context.Context, forwardFlows chan<- []*Record, ifIndex uint32) {
+	monotonicTimeNow := monotime.Now()
+	currentTime := time.Now()
+
+	var forwardingFlows []*Record
+	removed := m.mapFetcher.LookupAndDeleteMatching(
+		func(id *ebpf.BpfFlowId, _ *ebpf.BpfFlowMetrics) bool {
+			return id.IfIndex == ifIndex
+		})
+	for flowKey, flowMetrics := range removed {
+		if flowMetrics.EndMonoTimeTs == 0 {
+			continue
+		}
+		record := NewRecord(flowKey, flowMetrics, currentTime, uint64(monotonicTimeNow))
+		record.EndReason = FlowEndReasonIfaceRemoved
+		forwardingFlows = append(forwardingFlows, record)
+	}
+	if len(forwardingFlows) == 0 {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		mtlog.Debug("skipping flow eviction as agent is being stopped")
+	default:
+		forwardFlows <- forwardingFlows
+	}
+	mtlog.Debugf("%d flows evicted from removed interface %d", len(forwardingFlows), ifIndex)
+}
+
+// evictExpiredFlows evicts the flows whose flush deadline, as calculated by flushDeadline, is
+// already in the past. It returns the monotonic timestamp of the check and the earliest deadline
+// of the flows that remain in the map, or zero if the map is empty.
+func (m *MapTracer) evictExpiredFlows(
+	ctx context.Context, forwardFlows chan<- []*Record,
+) (now, earliest uint64) {
+	monotonicTimeNow := monotime.Now()
+	currentTime := time.Now()
+	now = uint64(monotonicTimeNow)
+
+	var forwardingFlows []*Record
+	expired := m.mapFetcher.LookupAndDeleteMatching(
+		func(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool {
+			deadline := m.flushDeadline(id, metric)
+			if deadline <= now {
+				return true
+			}
+			if earliest == 0 || deadline < earliest {
+				earliest = deadline
+			}
+			return false
+		})
+	for flowKey, flowMetrics := range expired {
+		if flowMetrics.EndMonoTimeTs == 0 {
+			continue
+		}
+		forwardingFlows = append(forwardingFlows,
+			NewRecord(flowKey, flowMetrics, currentTime, now))
+	}
+	if len(forwardingFlows) == 0 {
+		return now, earliest
+	}
+	select {
+	case <-ctx.Done():
+		mtlog.Debug("skipping flow eviction as agent is being stopped")
+	default:
+		forwardFlows <- forwardingFlows
+	}
+	mtlog.Debugf("%d flows evicted after their flush deadline", len(forwardingFlows))
+	return now, earliest
+}
+
+// nextDeadlineCheck returns how long to wait until the next check of the flush deadlines, given
+// the monotonic timestamp of the last check and the earliest deadline of the flows that remained
+// in the map (zero if none). The deadlines only move forward as the flows receive more packets,
+// and the flows that are added after the check can't expire before the shortest timeout, so the
+// map isn't scanned while no flow can be due. The checks are never more frequent than checkPeriod.
+func (m *MapTracer) nextDeadlineCheck(now, earliest uint64, checkPeriod time.Duration) time.Duration {
+	wait := m.shortestTimeout()
+	if earliest != 0 {
+		if earliest <= now {
+			return checkPeriod
+		}
+		if untilEarliest := time.Duration(earliest - now); untilEarliest < wait {
+			wait = untilEarliest
+		}
+	}
+	if wait < checkPeriod {
+		return checkPeriod
+	}
+	return wait
+}
+
+// shortestTimeout returns the shortest time since a flow is added to the map until it can be
+// due: the shortest of the active and inactive timeouts of all the protocols
+func (m *MapTracer) shortestTimeout() time.Duration {
+	shortest := m.evictionTimeout
+	for _, pt := range m.protocolTimeouts {
+		if pt.Active > 0 && pt.Active < shortest {
+			shortest = pt.Active
+		}
+		if pt.Inactive > 0 && pt.Inactive < shortest {
+			shortest = pt.Inactive
+		}
+	}
+	return shortest
+}
+
+// deadlineCheckPeriod returns how often the flows' flush deadlines are checked, or zero if the
+// flows are evicted all together at each eviction timeout
+func (m *MapTracer) deadlineCheckPeriod() time.Duration {
+	var period time.Duration
+	shorter := func(p time.Duration) {
+		if p > 0 && (period == 0 || p < period) {
+			period = p
+		}
+	}
+	shorter(m.flushJitter / jitterChecksPerWindow)
+	if len(m.protocolTimeouts) > 0 {
+		shorter(m.evictionTimeout / timeoutChecksPerPeriod)
+		for _, pt := range m.protocolTimeouts {
+			shorter(pt.Active / timeoutChecksPerPeriod)
+			shorter(pt.Inactive / timeoutChecksPerPeriod)
+		}
+	}
+	return period
+}
+
+// flushDeadline returns the monotonic timestamp, in nanoseconds, after which the flow must be
+// evicted: the eviction timeout of its protocol since the flow started, plus the flow's jitter
+// offset, or the inactive timeout of its protocol since its last packet, whatever comes first.
+// As the next packets of an evicted flow start a new map entry, the continuation of a long-lived
+// flow keeps being evicted at a spread time.
+func (m *MapTracer) flushDeadline(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) uint64 {
+	active := m.evictionTimeout
+	timeouts := m.protocolTimeouts[id.TransportProtocol]
+	if timeouts.Active > 0 {
+		active = timeouts.Active
+	}
+	deadline := metric.StartMonoTimeTs + uint64(active) + flushJitterOffset(id, m.flushJitter)
+	if timeouts.Inactive > 0 {
+		if inactive := metric.EndMonoTimeTs + uint64(timeouts.Inactive); inactive < deadline {
+			deadline = inactive
+		}
+	}
+	return deadline
+}
+
+// flushJitterOffset returns a pseudo-random offset in the [0, jitter) range, in nanoseconds. It
+// is derived from a hash of the flow identity, so it is stable for the same flow.
+func flushJitterOffset(id *ebpf.BpfFlowId, jitter time.Duration) uint64 {
+	if jitter <= 0 {
+		return 0
+	}
+	var fields [12]byte
+	binary.BigEndian.PutUint16(fields[0:], id.EthProtocol)
+	binary.BigEndian.PutUint16(fields[2:], id.SrcPort)
+	binary.BigEndian.PutUint16(fields[4:], id.DstPort)
+	binary.BigEndian.PutUint32(fields[6:], id.IfIndex)
+	fields[10] = id.TransportProtocol
+	fields[11] = id.Direction
+	h := fnv.New64a()
+	_, _ = h.Write(id.SrcIp[:])
+	_, _ = h.Write(id.DstIp[:])
+	_, _ = h.Write(fields[:])
+	return h.Sum64() % uint64(jitter)
+}
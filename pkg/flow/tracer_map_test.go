@@ -1,10 +1,16 @@
 package flow
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/gavv/monotime"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
 )
@@ -33,3 +39,486 @@ func TestPacketAggregation(t *testing.T) {
 		})
 	}
 }
+
+func TestMapTracer_MaxFlowLifetime(t *testing.T) {
+	const maxLifetime = 200 * time.Millisecond
+	now := uint64(monotime.Now())
+	longLived := ebpf.BpfFlowId{SrcPort: 1}
+	shortLived := ebpf.BpfFlowId{SrcPort: 2}
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		longLived: {
+			Packets: 10, StartMonoTimeTs: now - uint64(time.Hour), EndMonoTimeTs: now,
+		},
+		shortLived: {
+			Packets: 3, StartMonoTimeTs: now + uint64(time.Hour), EndMonoTimeTs: now + uint64(time.Hour),
+		},
+	}}
+
+	// GIVEN a map tracer whose eviction timeout is much longer than the flow lifetime cap
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: time.Hour,
+		MaxLifetime:     maxLifetime,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// THEN the flow exceeding the cap is exported, before the eviction timeout
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.Equal(t, longLived, records[0].Id)
+	assert.EqualValues(t, 10, records[0].Metrics.Packets)
+	assert.Equal(t, FlowEndReasonLifetimeCap, records[0].EndReason)
+
+	// AND the next packets of the flow are accounted in a continuation record starting fresh,
+	// which is normally exported with the rest of the flows
+	fetcher.put(longLived, ebpf.BpfFlowMetrics{
+		Packets: 2, StartMonoTimeTs: now + uint64(time.Hour), EndMonoTimeTs: now + uint64(time.Hour),
+	})
+	tracer.Flush()
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 2)
+	for _, r := range records {
+		assert.Equal(t, FlowEndReasonEviction, r.EndReason)
+		if r.Id == longLived {
+			assert.EqualValues(t, 2, r.Metrics.Packets)
+		} else {
+			assert.Equal(t, shortLived, r.Id)
+		}
+	}
+}
+
+func TestMapTracer_TCPClose(t *testing.T) {
+	const grace = 100 * time.Millisecond
+	start := uint64(monotime.Now())
+	tcpFlow := func(srcPort uint16, flags uint16) (ebpf.BpfFlowId, ebpf.BpfFlowMetrics) {
+		return ebpf.BpfFlowId{TransportProtocol: syscall.IPPROTO_TCP, SrcPort: srcPort},
+			ebpf.BpfFlowMetrics{Packets: 4, StartMonoTimeTs: start, EndMonoTimeTs: start, Flags: flags}
+	}
+	// GIVEN a connection that has been cleanly closed from both sides, and an active connection
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}}
+	fetcher.put(tcpFlow(34567, TCPFlagSYN|TCPFlagACK|TCPFlagFIN))
+	fetcher.put(tcpFlow(80, TCPFlagSYNACK|TCPFlagFINACK))
+	fetcher.put(tcpFlow(34568, TCPFlagSYN|TCPFlagACK))
+
+	// WHEN they are traced by a map tracer with a TCP close grace period, whose eviction timeout
+	// is much longer
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: time.Hour,
+		TCPCloseGrace:   grace,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// THEN the closed flows are promptly exported after the grace period
+	records := receiveTimeout(t, out)
+	assert.GreaterOrEqual(t, uint64(monotime.Now()), start+uint64(grace))
+	assert.ElementsMatch(t, []uint16{34567, 80}, srcPorts(records))
+	for _, r := range records {
+		assert.Equal(t, FlowEndReasonTCPClose, r.EndReason)
+		assert.EqualValues(t, 4, r.Metrics.Packets)
+	}
+	// AND the active flow is kept in the map
+	fetcher.mt.Lock()
+	assert.Len(t, fetcher.flows, 1)
+	fetcher.mt.Unlock()
+
+	// AND a closed flow that keeps receiving late packets waits for the grace period after them
+	id, metrics := tcpFlow(34569, TCPFlagRST)
+	metrics.EndMonoTimeTs = uint64(monotime.Now()) + uint64(time.Hour)
+	fetcher.put(id, metrics)
+	time.Sleep(2 * grace)
+	select {
+	case records := <-out:
+		assert.Failf(t, "closed flow shouldn't be exported during the grace period", "%v", records)
+	default:
+	}
+}
+
+func TestFlushJitterOffset(t *testing.T) {
+	const jitter = time.Second
+	assert.Zero(t, flushJitterOffset(&ebpf.BpfFlowId{SrcPort: 1}, 0))
+
+	// the offset of each flow is stable
+	id := ebpf.BpfFlowId{SrcPort: 1234, DstPort: 80, TransportProtocol: 6}
+	assert.Equal(t, flushJitterOffset(&id, jitter), flushJitterOffset(&id, jitter))
+
+	// and the offsets of the different flows are spread across the jitter window
+	const flows = 1000
+	var quarters [4]int
+	for i := 0; i < flows; i++ {
+		offset := flushJitterOffset(&ebpf.BpfFlowId{SrcPort: uint16(i), DstPort: 443}, jitter)
+		require.Less(t, offset, uint64(jitter))
+		quarters[offset/uint64(jitter/4)]++
+	}
+	for q, count := range quarters {
+		assert.Greaterf(t, count, flows/8, "too few flows in quarter %d: %v", q, quarters)
+	}
+}
+
+func TestMapTracer_FlushJitter(t *testing.T) {
+	const (
+		evictionTimeout = 50 * time.Millisecond
+		jitter          = 500 * time.Millisecond
+		flows           = 100
+	)
+	// GIVEN a set of flows that started at the same time
+	start := uint64(monotime.Now())
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}}
+	for i := 0; i < flows; i++ {
+		fetcher.put(ebpf.BpfFlowId{SrcPort: uint16(i)},
+			ebpf.BpfFlowMetrics{Packets: 1, StartMonoTimeTs: start, EndMonoTimeTs: start})
+	}
+
+	// WHEN they are evicted by a map tracer with flush jitter
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: evictionTimeout,
+		FlushJitter:     jitter,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, flows)
+	go tracer.TraceLoop(ctx)(out)
+
+	// THEN each flow is not evicted before its own deadline
+	var flushTimes []uint64
+	for received := 0; received < flows; {
+		records := receiveTimeout(t, out)
+		now := uint64(monotime.Now())
+		for _, r := range records {
+			require.GreaterOrEqual(t, now,
+				start+uint64(evictionTimeout)+flushJitterOffset(&r.Id, jitter))
+		}
+		received += len(records)
+		flushTimes = append(flushTimes, now)
+	}
+	// AND the flows are not flushed all at once, but spread within the jitter window
+	assert.Greater(t, len(flushTimes), 2)
+	assert.Greater(t, flushTimes[len(flushTimes)-1]-flushTimes[0], uint64(jitter/2))
+}
+
+func TestMapTracer_FlushJitterSkipsScansUntilDue(t *testing.T) {
+	const (
+		evictionTimeout = 300 * time.Millisecond
+		jitter          = 100 * time.Millisecond
+	)
+	// GIVEN a flow that has just started
+	start := uint64(monotime.Now())
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}}
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 1},
+		ebpf.BpfFlowMetrics{Packets: 1, StartMonoTimeTs: start, EndMonoTimeTs: start})
+
+	// WHEN it is traced by a map tracer with flush jitter
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: evictionTimeout,
+		FlushJitter:     jitter,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// THEN the map isn't scanned again until the flow deadline is due
+	time.Sleep(evictionTimeout * 2 / 3)
+	fetcher.mt.Lock()
+	assert.LessOrEqual(t, fetcher.scans, 2)
+	fetcher.mt.Unlock()
+
+	// AND the flow is evicted after its deadline
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.GreaterOrEqual(t, uint64(monotime.Now()),
+		start+uint64(evictionTimeout)+flushJitterOffset(&records[0].Id, jitter))
+}
+
+func TestMapTracer_ProtocolTimeouts(t *testing.T) {
+	const (
+		udpInactive = 100 * time.Millisecond
+		tcpActive   = 500 * time.Millisecond
+	)
+	// GIVEN a TCP flow and a UDP flow whose last packets were observed at the same time, and an
+	// ICMP flow without protocol-specific timeouts
+	start := uint64(monotime.Now())
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}}
+	for _, proto := range []uint8{syscall.IPPROTO_TCP, syscall.IPPROTO_UDP, syscall.IPPROTO_ICMP} {
+		fetcher.put(ebpf.BpfFlowId{TransportProtocol: proto},
+			ebpf.BpfFlowMetrics{Packets: 1, StartMonoTimeTs: start, EndMonoTimeTs: start})
+	}
+
+	// WHEN they are traced by a map tracer with distinct timeouts for TCP and UDP
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: time.Hour,
+		ProtocolTimeouts: map[uint8]ProtocolTimeouts{
+			syscall.IPPROTO_TCP: {Active: tcpActive},
+			syscall.IPPROTO_UDP: {Inactive: udpInactive},
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// THEN the UDP flow expires first, after its inactive timeout
+	records := receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.EqualValues(t, syscall.IPPROTO_UDP, records[0].Id.TransportProtocol)
+	assert.GreaterOrEqual(t, uint64(monotime.Now()), start+uint64(udpInactive))
+
+	// AND the TCP flow expires later, after its active timeout
+	records = receiveTimeout(t, out)
+	require.Len(t, records, 1)
+	assert.EqualValues(t, syscall.IPPROTO_TCP, records[0].Id.TransportProtocol)
+	assert.GreaterOrEqual(t, uint64(monotime.Now()), start+uint64(tcpActive))
+
+	// AND the ICMP flow keeps waiting for the global eviction timeout
+	fetcher.mt.Lock()
+	assert.Len(t, fetcher.flows, 1)
+	fetcher.mt.Unlock()
+}
+
+func TestMapTracer_MapFullCompletedFirst(t *testing.T) {
+	now := uint64(monotime.Now())
+	metrics := func(flags uint16) ebpf.BpfFlowMetrics {
+		return ebpf.BpfFlowMetrics{Packets: 3, StartMonoTimeTs: now, EndMonoTimeTs: now, Flags: flags}
+	}
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		{SrcPort: 1}: metrics(TCPFlagSYN | TCPFlagACK | TCPFlagFIN),
+		{SrcPort: 2}: metrics(TCPFlagACK | TCPFlagRST),
+		{SrcPort: 3}: metrics(TCPFlagSYNACK | TCPFlagFINACK),
+		{SrcPort: 4}: metrics(TCPFlagSYN | TCPFlagACK | TCPFlagPSH),
+		{SrcPort: 5}: metrics(0),
+	}}
+
+	// GIVEN a map tracer that evicts the completed flows first, requiring at least 2 of them
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout:   time.Hour,
+		CompletedFirstMin: 2,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// WHEN the map is full
+	records := mapFullEviction(t, tracer, out)
+
+	// THEN only the flows whose FIN or RST was observed are evicted
+	assert.ElementsMatch(t, []uint16{1, 2, 3}, srcPorts(records))
+	// AND the active flows are kept in the map
+	assert.Len(t, fetcher.flows, 2)
+
+	// WHEN the map is full again, but there are not enough completed flows, as the entries that
+	// haven't been aggregated are not accounted
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 6}, metrics(TCPFlagFIN))
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 7}, ebpf.BpfFlowMetrics{Flags: TCPFlagFIN})
+	records = mapFullEviction(t, tracer, out)
+	assert.ElementsMatch(t, []uint16{6}, srcPorts(records))
+	// THEN all the flows are evicted
+	records = receiveTimeout(t, out)
+	assert.ElementsMatch(t, []uint16{4, 5}, srcPorts(records))
+	assert.Empty(t, fetcher.flows)
+}
+
+func TestMapTracer_MapFullEvictAll(t *testing.T) {
+	now := uint64(monotime.Now())
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		{SrcPort: 1}: {Packets: 1, StartMonoTimeTs: now, EndMonoTimeTs: now, Flags: TCPFlagFIN},
+		{SrcPort: 2}: {Packets: 1, StartMonoTimeTs: now, EndMonoTimeTs: now, Flags: TCPFlagACK},
+	}}
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{EvictionTimeout: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// without completed-first eviction, all the flows are evicted when the map is full
+	records := mapFullEviction(t, tracer, out)
+	assert.ElementsMatch(t, []uint16{1, 2}, srcPorts(records))
+}
+
+func TestMapTracer_MinFlushFlows(t *testing.T) {
+	const evictionTimeout = 50 * time.Millisecond
+	now := uint64(monotime.Now())
+	metrics := ebpf.BpfFlowMetrics{Packets: 1, StartMonoTimeTs: now, EndMonoTimeTs: now}
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		{SrcPort: 1}: metrics,
+	}}
+
+	// GIVEN a map tracer that requires at least 3 flows to flush them
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: evictionTimeout,
+		MinFlushFlows:   3,
+		MinFlushMaxHold: time.Hour,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+
+	// WHEN the first window is flushed with less flows than the threshold
+	records := receiveTimeout(t, out)
+	assert.ElementsMatch(t, []uint16{1}, srcPorts(records))
+	firstFlush := time.Now()
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 2}, metrics)
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 3}, metrics)
+
+	// THEN the next flushes are deferred during multiple eviction windows
+	select {
+	case records := <-out:
+		require.Failf(t, "unexpected flush", "records: %v", records)
+	case <-time.After(3 * evictionTimeout / 2):
+	}
+	fetcher.mt.Lock()
+	assert.Len(t, fetcher.flows, 2)
+	fetcher.mt.Unlock()
+
+	// AND once the windows are estimated to have accumulated the threshold, all the held flows
+	// are flushed
+	records = receiveTimeout(t, out)
+	assert.ElementsMatch(t, []uint16{2, 3}, srcPorts(records))
+	assert.GreaterOrEqual(t, time.Since(firstFlush), 2*evictionTimeout)
+}
+
+func TestMapTracer_MinFlushFlows_EmptyWindow(t *testing.T) {
+	const evictionTimeout = 50 * time.Millisecond
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}}
+
+	// GIVEN a map tracer that requires at least 3 flows to flush them, and whose first window
+	// is empty
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: evictionTimeout,
+		MinFlushFlows:   3,
+		MinFlushMaxHold: time.Hour,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+	assert.Empty(t, receiveTimeout(t, out))
+
+	// WHEN a flow is accounted afterwards
+	now := uint64(monotime.Now())
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 1},
+		ebpf.BpfFlowMetrics{Packets: 1, StartMonoTimeTs: now, EndMonoTimeTs: now})
+
+	// THEN it is flushed in the next windows, instead of being held until the max hold time
+	deadline := time.After(10 * evictionTimeout)
+	for {
+		select {
+		case records := <-out:
+			if len(records) == 0 {
+				continue
+			}
+			assert.ElementsMatch(t, []uint16{1}, srcPorts(records))
+			return
+		case <-deadline:
+			require.Fail(t, "the flows are held after an empty window")
+			return
+		}
+	}
+}
+
+func TestMapTracer_MinFlushMaxHold(t *testing.T) {
+	const (
+		evictionTimeout = 50 * time.Millisecond
+		maxHold         = 300 * time.Millisecond
+	)
+	now := uint64(monotime.Now())
+	metrics := ebpf.BpfFlowMetrics{Packets: 1, StartMonoTimeTs: now, EndMonoTimeTs: now}
+	fetcher := &mapFetcherFake{flows: map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{
+		{SrcPort: 1}: metrics,
+	}}
+
+	// GIVEN a map tracer whose flush threshold is never reached
+	tracer := NewMapTracer(fetcher, &MapTracerConfig{
+		EvictionTimeout: evictionTimeout,
+		MinFlushFlows:   100,
+		MinFlushMaxHold: maxHold,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan []*Record, 10)
+	go tracer.TraceLoop(ctx)(out)
+	records := receiveTimeout(t, out)
+	assert.ElementsMatch(t, []uint16{1}, srcPorts(records))
+	firstFlush := time.Now()
+	fetcher.put(ebpf.BpfFlowId{SrcPort: 2}, metrics)
+
+	// THEN the flows are flushed anyway after the max hold time
+	records = receiveTimeout(t, out)
+	assert.ElementsMatch(t, []uint16{2}, srcPorts(records))
+	assert.GreaterOrEqual(t, time.Since(firstFlush), maxHold)
+}
+
+// mapFullEviction notifies the tracer that the map is full until it evicts some flows, as the
+// notification is ignored if the tracer is not waiting for it yet
+func mapFullEviction(t *testing.T, tracer *MapTracer, out <-chan []*Record) []*Record {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		tracer.MapFull()
+		select {
+		case records := <-out:
+			return records
+		case <-time.After(200 * time.Millisecond):
+		case <-deadline:
+			require.Fail(t, "timeout while waiting for evicted records")
+			return nil
+		}
+	}
+}
+
+func srcPorts(records []*Record) []uint16 {
+	ports := make([]uint16, 0, len(records))
+	for _, r := range records {
+		ports = append(ports, r.Id.SrcPort)
+	}
+	return ports
+}
+
+type mapFetcherFake struct {
+	mt    sync.Mutex
+	flows map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	// scans counts the invocations to LookupAndDeleteMatching
+	scans int
+}
+
+func (m *mapFetcherFake) put(id ebpf.BpfFlowId, metrics ebpf.BpfFlowMetrics) {
+	m.mt.Lock()
+	defer m.mt.Unlock()
+	m.flows[id] = metrics
+}
+
+func (m *mapFetcherFake) LookupAndDeleteMap() map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics {
+	m.mt.Lock()
+	defer m.mt.Unlock()
+	flows := m.flows
+	m.flows = map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}
+	return flows
+}
+
+func (m *mapFetcherFake) LookupAndDeleteStartedBefore(monoTs uint64) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics {
+	return m.LookupAndDeleteMatching(func(_ *ebpf.BpfFlowId, metrics *ebpf.BpfFlowMetrics) bool {
+		return metrics.StartMonoTimeTs < monoTs
+	})
+}
+
+func (m *mapFetcherFake) LookupAndDeleteMatching(
+	match func(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool,
+) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics {
+	m.mt.Lock()
+	defer m.mt.Unlock()
+	m.scans++
+	flows := map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}
+	for id, metrics := range m.flows {
+		id, metrics := id, metrics
+		if match(&id, &metrics) {
+			flows[id] = metrics
+			delete(m.flows, id)
+		}
+	}
+	return flows
+}
@@ -11,11 +11,46 @@ import (
 
 	"github.com/cilium/ebpf/ringbuf"
 	"github.com/netobserv/gopipes/pkg/node"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
 )
 
 var rtlog = logrus.WithField("component", "flow.RingBufTracer")
 
+// MapFullPolicy tells how the RingBufTracer handles the flows that couldn't be added to the
+// eBPF map because it was full
+type MapFullPolicy uint8
+
+const (
+	// MapFullSpill forwards all the flows to the userspace accounter
+	MapFullSpill MapFullPolicy = iota
+	// MapFullDrop discards the flows, reducing the userspace load at the cost of completeness
+	MapFullDrop
+	// MapFullSample forwards one out of each N flows, discarding the rest
+	MapFullSample
+)
+
+// MapFullDropSampling is the ratio of the flows that couldn't be added to the full eBPF map that
+// are still submitted through the ring buffer with the MapFullDrop policy, only to notify
+// userspace that the map must be evicted
+const MapFullDropSampling = 100
+
+// KernelSampling returns the ratio of the flows that couldn't be added to the full eBPF map that
+// the eBPF program must submit through the ring buffer, so the discarded flows don't reach
+// userspace. mapFullSampling is the ratio of forwarded flows for the MapFullSample policy.
+func (p MapFullPolicy) KernelSampling(mapFullSampling int) int {
+	switch p {
+	case MapFullDrop:
+		return MapFullDropSampling
+	case MapFullSample:
+		return mapFullSampling
+	default:
+		return 1
+	}
+}
+
 // RingBufTracer receives single-packet flows via ringbuffer (usually, these that couldn't be
 // added in the eBPF kernel space due to the map being full or busy) and submits them to the
 // userspace Aggregator map
@@ -23,6 +58,20 @@ type RingBufTracer struct {
 	mapFlusher mapFlusher
 	ringBuffer ringBufReader
 	stats      stats
+	policy     MapFullPolicy
+	// mapFullSampling is the ratio of the map-full flows that the eBPF program submits, as
+	// returned by MapFullPolicy.KernelSampling
+	mapFullSampling int
+	mapFullDrops    prometheus.Counter
+	// sampler samples all the flows from the ring buffer, independently of the reason they were
+	// sent through it
+	sampler    sampler
+	sampledOut prometheus.Counter
+	// readErrors and parseErrors make the failures in the ring buffer path observable without
+	// enabling debug logs. E.g. a growing parseErrors might reveal an ABI mismatch between the
+	// kernel and user spaces.
+	readErrors  prometheus.Counter
+	parseErrors prometheus.Counter
 }
 
 type ringBufReader interface {
@@ -38,16 +87,54 @@ type stats struct {
 }
 
 type mapFlusher interface {
-	Flush()
+	// MapFull is invoked when a flow couldn't be added to the eBPF map because it was full
+	MapFull()
+}
+
+// sampler admits one out of each "rate" invocations
+type sampler struct {
+	rate  int
+	count int
 }
 
+// admit returns true for the first invocation of each group of "rate" invocations
+func (s *sampler) admit() bool {
+	if s.rate <= 1 {
+		return true
+	}
+	admit := s.count%s.rate == 0
+	s.count++
+	return admit
+}
+
+// NewRingBufTracer creates a RingBufTracer. The policy argument tells how to handle the flows
+// received because the eBPF map was full. For the MapFullSample policy, mapFullSampling is the
+// ratio of forwarded flows (e.g. 10 means that 1 out of each 10 flows is forwarded). The flows
+// are discarded by the eBPF program, which must be configured with policy.KernelSampling, so the
+// tracer only accounts the discarded flows.
+// The sampling argument is the ratio of forwarded flows among all the flows received from the
+// ring buffer, which allows reducing the userspace load during eviction storms without
+// affecting the flows that are aggregated in the eBPF map. 0 or 1 disables it.
 func NewRingBufTracer(
 	reader ringBufReader, flusher mapFlusher, logTimeout time.Duration,
+	policy MapFullPolicy, mapFullSampling, sampling int, m *metrics.Metrics,
 ) *RingBufTracer {
 	return &RingBufTracer{
-		mapFlusher: flusher,
-		ringBuffer: reader,
-		stats:      stats{loggingTimeout: logTimeout},
+		mapFlusher:      flusher,
+		ringBuffer:      reader,
+		stats:           stats{loggingTimeout: logTimeout},
+		policy:          policy,
+		mapFullSampling: policy.KernelSampling(mapFullSampling),
+		sampler:         sampler{rate: sampling},
+		sampledOut: m.NewCounter("ringbuf_sampled_out_flows_total",
+			"Number of flows from the ring buffer that have been discarded by sampling"),
+		mapFullDrops: m.NewCounter("ringbuf_map_full_dropped_flows_total",
+			"Number of flows that couldn't be added to the full eBPF map and have been "+
+				"dropped according to the map-full policy"),
+		readErrors: m.NewCounter("ringbuf_read_errors_total",
+			"Number of errors while reading flow events from the ring buffer"),
+		parseErrors: m.NewCounter("ringbuf_parse_errors_total",
+			"Number of flow events from the ring buffer that couldn't be parsed"),
 	}
 }
 
@@ -76,11 +163,15 @@ func (m *RingBufTracer) TraceLoop(ctx context.Context) node.StartFunc[*RawRecord
 func (m *RingBufTracer) listenAndForwardRingBuffer(debugging bool, forwardCh chan<- *RawRecord) error {
 	event, err := m.ringBuffer.ReadRingBuf()
 	if err != nil {
+		if !errors.Is(err, ringbuf.ErrClosed) {
+			m.readErrors.Inc()
+		}
 		return fmt.Errorf("reading from ring buffer: %w", err)
 	}
 	// Parses the ringbuf event entry into an Event structure.
 	readFlow, err := ReadFrom(bytes.NewBuffer(event.RawSample))
 	if err != nil {
+		m.parseErrors.Inc()
 		return fmt.Errorf("parsing data received from the ring buffer: %w", err)
 	}
 	mapFullError := readFlow.Metrics.Errno == uint8(syscall.E2BIG)
@@ -90,7 +181,14 @@ func (m *RingBufTracer) listenAndForwardRingBuffer(debugging bool, forwardCh cha
 	// if the flow was received due to lack of space in the eBPF map
 	// forces a flow's eviction to leave room for new flows in the ebpf cache
 	if mapFullError {
-		m.mapFlusher.Flush()
+		m.mapFlusher.MapFull()
+		if !m.accountMapFull() {
+			return nil
+		}
+	}
+	if !m.sampler.admit() {
+		m.sampledOut.Inc()
+		return nil
 	}
 
 	// Will need to send it to accounter anyway to account regardless of complete/ongoing flow
@@ -99,6 +197,25 @@ func (m *RingBufTracer) listenAndForwardRingBuffer(debugging bool, forwardCh cha
 	return nil
 }
 
+// accountMapFull accounts the flows that the eBPF program discarded, according to the map-full
+// policy, before submitting a flow that couldn't be added to the full eBPF map. It returns whether
+// the submitted flow must be forwarded, as with the MapFullDrop policy it only notifies that the
+// map is full.
+func (m *RingBufTracer) accountMapFull() bool {
+	switch m.policy {
+	case MapFullDrop:
+		m.mapFullDrops.Add(float64(m.mapFullSampling))
+		return false
+	case MapFullSample:
+		if m.mapFullSampling > 1 {
+			m.mapFullDrops.Add(float64(m.mapFullSampling - 1))
+		}
+		return true
+	default:
+		return true
+	}
+}
+
 // logRingBufferFlows avoids flooding logs on long series of evicted flows by grouping how
 // many flows are forwarded
 func (m *stats) logRingBufferFlows(mapFullErr bool) {
@@ -0,0 +1,171 @@
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// ringBufFake returns the provided events, and then ringbuf.ErrClosed
+type ringBufFake struct {
+	events chan ringBufEvent
+}
+
+type ringBufEvent struct {
+	record ringbuf.Record
+	err    error
+}
+
+func (r *ringBufFake) ReadRingBuf() (ringbuf.Record, error) {
+	ev, ok := <-r.events
+	if !ok {
+		return ringbuf.Record{}, ringbuf.ErrClosed
+	}
+	return ev.record, ev.err
+}
+
+type flusherFake struct{}
+
+func (flusherFake) MapFull() {}
+
+// flushCounter counts the invocations to MapFull
+type flushCounter struct {
+	flushes int
+}
+
+func (f *flushCounter) MapFull() {
+	f.flushes++
+}
+
+func TestRingBufTracer_Errors(t *testing.T) {
+	valid := bytes.Buffer{}
+	require.NoError(t, binary.Write(&valid, binary.LittleEndian, &RawRecord{}))
+
+	reader := &ringBufFake{events: make(chan ringBufEvent, 10)}
+	reader.events <- ringBufEvent{err: errors.New("read failure")}
+	// malformed sample: shorter than the flow record
+	reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: []byte{1, 2, 3}}}
+	reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: valid.Bytes()}}
+	close(reader.events)
+
+	m := metrics.NoOp()
+	tracer := NewRingBufTracer(reader, flusherFake{}, time.Minute, MapFullSpill, 1, 1, m)
+	out := make(chan *RawRecord, 10)
+	tracer.TraceLoop(context.Background())(out)
+
+	// only the valid event is forwarded
+	assert.Len(t, out, 1)
+	// the read and parse errors are accounted separately. Closing the ring buffer is not an error
+	assert.EqualValues(t, 1, counterValue(t, m, "ringbuf_read_errors_total"))
+	assert.EqualValues(t, 1, counterValue(t, m, "ringbuf_parse_errors_total"))
+}
+
+func TestRingBufTracer_MapFullPolicy(t *testing.T) {
+	rawSample := func(errno syscall.Errno, srcPort uint16) []byte {
+		rec := RawRecord{}
+		rec.Id.SrcPort = srcPort
+		rec.Metrics.Errno = uint8(errno)
+		buf := bytes.Buffer{}
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, &rec))
+		return buf.Bytes()
+	}
+	// runs the tracer with 10 flows received because the map was full, as submitted by the eBPF
+	// program after sampling them, and 2 flows received because the map was busy. It returns the
+	// source ports of the forwarded flows
+	run := func(t *testing.T, policy MapFullPolicy, sampling int, m *metrics.Metrics) []uint16 {
+		reader := &ringBufFake{events: make(chan ringBufEvent, 20)}
+		for i := uint16(1); i <= 10; i++ {
+			reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: rawSample(syscall.E2BIG, i)}}
+		}
+		reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: rawSample(syscall.EBUSY, 100)}}
+		reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: rawSample(syscall.EBUSY, 101)}}
+		close(reader.events)
+
+		flusher := &flushCounter{}
+		out := make(chan *RawRecord, 20)
+		NewRingBufTracer(reader, flusher, time.Minute, policy, sampling, 1, m).
+			TraceLoop(context.Background())(out)
+		close(out)
+
+		// the map is flushed on each map-full flow, independently of the policy
+		assert.Equal(t, 10, flusher.flushes)
+		var ports []uint16
+		for r := range out {
+			ports = append(ports, r.Id.SrcPort)
+		}
+		return ports
+	}
+
+	t.Run("spill", func(t *testing.T) {
+		m := metrics.NoOp()
+		assert.Equal(t, []uint16{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 100, 101},
+			run(t, MapFullSpill, 1, m))
+		assert.Zero(t, counterValue(t, m, "ringbuf_map_full_dropped_flows_total"))
+	})
+	t.Run("drop", func(t *testing.T) {
+		m := metrics.NoOp()
+		// only the flows from other errors are forwarded. Each map-full flow just notifies
+		// that the eBPF program discarded MapFullDropSampling flows
+		assert.Equal(t, []uint16{100, 101}, run(t, MapFullDrop, 1, m))
+		assert.EqualValues(t, 10*MapFullDropSampling,
+			counterValue(t, m, "ringbuf_map_full_dropped_flows_total"))
+	})
+	t.Run("sample", func(t *testing.T) {
+		m := metrics.NoOp()
+		// the flows have been already sampled by the eBPF program, which discarded 2 flows
+		// before submitting each of them
+		assert.Equal(t, []uint16{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 100, 101},
+			run(t, MapFullSample, 3, m))
+		assert.EqualValues(t, 20, counterValue(t, m, "ringbuf_map_full_dropped_flows_total"))
+	})
+}
+
+func TestMapFullPolicy_KernelSampling(t *testing.T) {
+	assert.Equal(t, 1, MapFullSpill.KernelSampling(10))
+	assert.Equal(t, MapFullDropSampling, MapFullDrop.KernelSampling(10))
+	assert.Equal(t, 10, MapFullSample.KernelSampling(10))
+}
+
+func TestRingBufTracer_Sampling(t *testing.T) {
+	const events = 1000
+	reader := &ringBufFake{events: make(chan ringBufEvent, events)}
+	for i := 0; i < events; i++ {
+		rec := RawRecord{}
+		rec.Id.SrcPort = uint16(i)
+		// mixing flows spilled over because the map was full or busy
+		rec.Metrics.Errno = uint8(syscall.E2BIG)
+		if i%2 == 0 {
+			rec.Metrics.Errno = uint8(syscall.EBUSY)
+		}
+		buf := bytes.Buffer{}
+		require.NoError(t, binary.Write(&buf, binary.LittleEndian, &rec))
+		reader.events <- ringBufEvent{record: ringbuf.Record{RawSample: buf.Bytes()}}
+	}
+	close(reader.events)
+
+	m := metrics.NoOp()
+	out := make(chan *RawRecord, events)
+	NewRingBufTracer(reader, flusherFake{}, time.Minute, MapFullSpill, 1, 10, m).
+		TraceLoop(context.Background())(out)
+	close(out)
+
+	// one out of 10 flows is forwarded
+	forwarded := 0
+	for r := range out {
+		assert.Zero(t, r.Id.SrcPort%10)
+		forwarded++
+	}
+	assert.Equal(t, events/10, forwarded)
+	assert.EqualValues(t, events-events/10, counterValue(t, m, "ringbuf_sampled_out_flows_total"))
+	assert.Zero(t, counterValue(t, m, "ringbuf_map_full_dropped_flows_total"))
+}
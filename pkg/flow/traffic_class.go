@@ -0,0 +1,130 @@
+package flow
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// EnricherTrafficClass decorates the flows with their traffic class (unicast, multicast or
+// broadcast), according to their destination MAC and IP addresses
+const EnricherTrafficClass = "trafficClass"
+
+// TrafficClass tells whether a flow is addressed to a single host, a group of hosts or all the
+// hosts of the network segment
+type TrafficClass uint8
+
+const (
+	// TrafficClassUnknown is reported when the flows are not classified (trafficClass enricher
+	// not enabled)
+	TrafficClassUnknown TrafficClass = iota
+	TrafficClassUnicast
+	TrafficClassMulticast
+	TrafficClassBroadcast
+)
+
+func (c TrafficClass) String() string {
+	switch c {
+	case TrafficClassUnknown:
+		return "unknown"
+	case TrafficClassUnicast:
+		return "unicast"
+	case TrafficClassMulticast:
+		return "multicast"
+	case TrafficClassBroadcast:
+		return "broadcast"
+	default:
+		return "invalid"
+	}
+}
+
+func (c TrafficClass) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + c.String() + `"`), nil
+}
+
+func (c TrafficClass) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// ParseTrafficClasses parses a list of "unicast", "multicast" or "broadcast" names
+func ParseTrafficClasses(names []string) ([]TrafficClass, error) {
+	classes := make([]TrafficClass, 0, len(names))
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "unicast":
+			classes = append(classes, TrafficClassUnicast)
+		case "multicast":
+			classes = append(classes, TrafficClassMulticast)
+		case "broadcast":
+			classes = append(classes, TrafficClassBroadcast)
+		default:
+			return nil, fmt.Errorf("unknown traffic class %q. Accepted values: unicast, multicast, broadcast",
+				name)
+		}
+	}
+	return classes, nil
+}
+
+// ClassifyTraffic returns the traffic class of a flow from its destination addresses. Layer 2
+// broadcast and multicast are detected from the destination MAC (all-ones address and group
+// bit, respectively), so non-IP flows are also classified.
+func ClassifyTraffic(id *ebpf.BpfFlowId) TrafficClass {
+	dstMac := id.DstMac
+	if dstMac == [MacLen]uint8{0xff, 0xff, 0xff, 0xff, 0xff, 0xff} {
+		return TrafficClassBroadcast
+	}
+	dstIP := IP(id.DstIp)
+	if dstIP.Equal(net.IPv4bcast) {
+		return TrafficClassBroadcast
+	}
+	if dstMac[0]&0x01 != 0 || dstIP.IsMulticast() {
+		return TrafficClassMulticast
+	}
+	return TrafficClassUnicast
+}
+
+func init() {
+	RegisterEnricher(EnricherTrafficClass, func(_ *EnricherContext) (Enricher, error) {
+		return EnricherFunc(func(record *Record) {
+			record.TrafficClass = ClassifyTraffic(&record.Id)
+		}), nil
+	})
+}
+
+// FilterTrafficClasses receives flows and drops those whose traffic class is listed in
+// exclude, or not listed in include, if it isn't empty.
+func FilterTrafficClasses(
+	include, exclude []TrafficClass, m *metrics.Metrics,
+) func(in <-chan []*Record, out chan<- []*Record) {
+	droppedCounter := m.NewCounter("traffic_class_dropped_flows_total",
+		"Number of flows that have been dropped because of their traffic class")
+	var accepted [TrafficClassBroadcast + 1]bool
+	for c := TrafficClassUnicast; c <= TrafficClassBroadcast; c++ {
+		accepted[c] = len(include) == 0
+	}
+	for _, c := range include {
+		accepted[c] = true
+	}
+	for _, c := range exclude {
+		accepted[c] = false
+	}
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			fwd := make([]*Record, 0, len(records))
+			for _, record := range records {
+				if accepted[ClassifyTraffic(&record.Id)] {
+					fwd = append(fwd, record)
+				}
+			}
+			if dropped := len(records) - len(fwd); dropped > 0 {
+				droppedCounter.Add(float64(dropped))
+			}
+			if len(fwd) > 0 {
+				out <- fwd
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package flow
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func classFlow(dstMac string, dstIP string) *Record {
+	r := &Record{}
+	mac, _ := net.ParseMAC(dstMac)
+	copy(r.Id.DstMac[:], mac)
+	copy(r.Id.DstIp[:], net.ParseIP(dstIP).To16())
+	r.Metrics.Packets = 1
+	return r
+}
+
+func TestClassifyTraffic(t *testing.T) {
+	for _, tc := range []struct {
+		dstMac string
+		dstIP  string
+		expect TrafficClass
+	}{
+		{dstMac: "ff:ff:ff:ff:ff:ff", dstIP: "10.0.0.255", expect: TrafficClassBroadcast},
+		{dstMac: "11:22:33:44:55:66", dstIP: "255.255.255.255", expect: TrafficClassBroadcast},
+		{dstMac: "01:00:5e:00:00:fb", dstIP: "224.0.0.251", expect: TrafficClassMulticast},
+		{dstMac: "33:33:00:00:00:01", dstIP: "ff02::1", expect: TrafficClassMulticast},
+		{dstMac: "02:42:ac:11:00:02", dstIP: "239.1.2.3", expect: TrafficClassMulticast},
+		{dstMac: "02:42:ac:11:00:02", dstIP: "10.0.0.2", expect: TrafficClassUnicast},
+		{dstMac: "02:42:ac:11:00:02", dstIP: "fd00::2", expect: TrafficClassUnicast},
+	} {
+		r := classFlow(tc.dstMac, tc.dstIP)
+		assert.Equal(t, tc.expect, ClassifyTraffic(&r.Id), "%s %s", tc.dstMac, tc.dstIP)
+	}
+}
+
+func TestTrafficClassEnricher(t *testing.T) {
+	chain, err := NewEnrichers([]string{EnricherTrafficClass}, &EnricherContext{})
+	require.NoError(t, err)
+
+	// GIVEN a flow towards the broadcast address
+	record := classFlow("ff:ff:ff:ff:ff:ff", "255.255.255.255")
+	// WHEN it is decorated
+	chain[0].Enrich(record)
+	// THEN it is classified as broadcast
+	assert.Equal(t, TrafficClassBroadcast, record.TrafficClass)
+	js, err := record.TrafficClass.MarshalJSON()
+	require.NoError(t, err)
+	assert.Equal(t, `"broadcast"`, string(js))
+}
+
+func TestFilterTrafficClasses(t *testing.T) {
+	broadcast := classFlow("ff:ff:ff:ff:ff:ff", "192.168.1.255")
+	multicast := classFlow("01:00:5e:00:00:fb", "224.0.0.251")
+	unicast := classFlow("02:42:ac:11:00:02", "10.0.0.2")
+	filter := func(include, exclude []TrafficClass) []*Record {
+		in := make(chan []*Record, 1)
+		out := make(chan []*Record, 1)
+		in <- []*Record{broadcast, multicast, unicast}
+		close(in)
+		FilterTrafficClasses(include, exclude, metrics.NoOp())(in, out)
+		close(out)
+		var fwd []*Record
+		for records := range out {
+			fwd = append(fwd, records...)
+		}
+		return fwd
+	}
+
+	assert.Equal(t, []*Record{unicast},
+		filter(nil, []TrafficClass{TrafficClassBroadcast, TrafficClassMulticast}))
+	assert.Equal(t, []*Record{broadcast, multicast},
+		filter([]TrafficClass{TrafficClassBroadcast, TrafficClassMulticast}, nil))
+	assert.Equal(t, []*Record{multicast},
+		filter([]TrafficClass{TrafficClassBroadcast, TrafficClassMulticast},
+			[]TrafficClass{TrafficClassBroadcast}))
+}
+
+func TestParseTrafficClasses(t *testing.T) {
+	classes, err := ParseTrafficClasses([]string{"Broadcast", " multicast", "unicast"})
+	require.NoError(t, err)
+	assert.Equal(t,
+		[]TrafficClass{TrafficClassBroadcast, TrafficClassMulticast, TrafficClassUnicast}, classes)
+
+	_, err = ParseTrafficClasses([]string{"anycast"})
+	assert.Error(t, err)
+}
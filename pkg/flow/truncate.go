@@ -0,0 +1,88 @@
+package flow
+
+import (
+	"unicode/utf8"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+// TruncationMarker replaces the end of the string fields that exceed the maximum length
+const TruncationMarker = "..."
+
+// TruncateStrings returns a stage that truncates the string fields of the flows (e.g. hostnames,
+// interface names, labels), so none of them is longer than maxLen bytes. The truncated fields
+// end with the TruncationMarker.
+func TruncateStrings(maxLen int, m *metrics.Metrics) func(in <-chan []*Record, out chan<- []*Record) {
+	truncated := m.NewCounter("truncated_fields_total",
+		"Number of flow string fields that have been truncated because they exceeded the maximum length")
+	return func(in <-chan []*Record, out chan<- []*Record) {
+		for records := range in {
+			for _, record := range records {
+				if n := truncateRecordStrings(record, maxLen); n > 0 {
+					truncated.Add(float64(n))
+				}
+			}
+			out <- records
+		}
+	}
+}
+
+// truncateRecordStrings truncates the string fields of the record and returns how many of them
+// have been truncated
+func truncateRecordStrings(record *Record, maxLen int) int {
+	count := 0
+	for _, field := range []*string{
+		&record.Interface, &record.InterfaceID, &record.Service, &record.ClusterID,
+		&record.TenantID, &record.AgentVersion, &record.BpfProgHash,
+		&record.SrcHostname, &record.DstHostname,
+	} {
+		if len(*field) > maxLen {
+			*field = truncateString(*field, maxLen)
+			count++
+		}
+	}
+	var n int
+	record.SrcLabels, n = truncateLabels(record.SrcLabels, maxLen)
+	count += n
+	record.DstLabels, n = truncateLabels(record.DstLabels, maxLen)
+	return count + n
+}
+
+// truncateLabels returns the labels with their keys and values truncated. Since the labels maps
+// are shared between records, a copy is returned if any of them needs to be truncated.
+func truncateLabels(labels map[string]string, maxLen int) (map[string]string, int) {
+	count := 0
+	for k, v := range labels {
+		if len(k) > maxLen {
+			count++
+		}
+		if len(v) > maxLen {
+			count++
+		}
+	}
+	if count == 0 {
+		return labels, 0
+	}
+	truncated := make(map[string]string, len(labels))
+	for k, v := range labels {
+		truncated[truncateString(k, maxLen)] = truncateString(v, maxLen)
+	}
+	return truncated, count
+}
+
+// truncateString cuts the string at a UTF-8 character boundary and appends the TruncationMarker,
+// so the result is not longer than maxLen bytes
+func truncateString(s string, maxLen int) string {
+	if len(s) <= maxLen {
+		return s
+	}
+	marker := TruncationMarker
+	if maxLen < len(marker) {
+		marker = ""
+	}
+	cut := maxLen - len(marker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + marker
+}
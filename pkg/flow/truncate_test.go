@@ -0,0 +1,48 @@
+package flow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/netobserv/netobserv-ebpf-agent/pkg/metrics"
+)
+
+func TestTruncateStrings(t *testing.T) {
+	m := metrics.NoOp()
+	in := make(chan []*Record, 10)
+	out := make(chan []*Record, 10)
+	go TruncateStrings(16, m)(in, out)
+	defer close(in)
+
+	longHost := "a-very-long-hostname.with-many-subdomains.example.com"
+	sharedLabels := map[string]string{"team": "networking-and-observability"}
+	in <- []*Record{{
+		Interface:   "eth0",
+		SrcHostname: longHost,
+		DstHostname: "short.example",
+		SrcLabels:   sharedLabels,
+	}}
+	records := receiveTimeout(t, out)
+	assert.Len(t, records, 1)
+
+	// the long fields are truncated with the marker, and the short ones are kept
+	assert.Equal(t, "a-very-long-h...", records[0].SrcHostname)
+	assert.Len(t, records[0].SrcHostname, 16)
+	assert.True(t, strings.HasPrefix(longHost, strings.TrimSuffix(records[0].SrcHostname, TruncationMarker)))
+	assert.Equal(t, "short.example", records[0].DstHostname)
+	assert.Equal(t, "eth0", records[0].Interface)
+	assert.Equal(t, map[string]string{"team": "networking-an..."}, records[0].SrcLabels)
+	// the shared labels are not modified
+	assert.Equal(t, "networking-and-observability", sharedLabels["team"])
+	assert.EqualValues(t, 2, counterValue(t, m, "truncated_fields_total"))
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "hello", truncateString("hello", 5))
+	assert.Equal(t, "he...", truncateString("hello world", 5))
+	assert.Equal(t, "he", truncateString("hello", 2))
+	// multi-byte characters are not split
+	assert.Equal(t, "ñ...", truncateString("ññññ", 6))
+}
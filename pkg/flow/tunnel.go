@@ -0,0 +1,39 @@
+package flow
+
+// TunnelType is the encapsulation of a flow, as parsed by the eBPF program if the tunnel parsing
+// is enabled. The tunneled flows report the attributes of their inner flow in the Inner* fields
+// of their identifier.
+type TunnelType uint8
+
+// Values according to the TUNNEL_* definitions in bpf/flow.h
+const (
+	TunnelNone TunnelType = iota
+	TunnelVXLAN
+	TunnelGeneve
+)
+
+func (t TunnelType) String() string {
+	switch t {
+	case TunnelNone:
+		return ""
+	case TunnelVXLAN:
+		return "vxlan"
+	case TunnelGeneve:
+		return "geneve"
+	default:
+		return "invalid"
+	}
+}
+
+func (t TunnelType) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + t.String() + `"`), nil
+}
+
+func (t TunnelType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Tunnel returns the encapsulation of the flow
+func (r *Record) Tunnel() TunnelType {
+	return TunnelType(r.Id.TunnelType)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"sync"
+	"time"
 )
 
 // Registerer is an informer that wraps another informer implementation, and keeps track of
@@ -12,14 +13,28 @@ type Registerer struct {
 	m      sync.RWMutex
 	inner  Informer
 	ifaces map[int]string
+	// expiry time of each entry in the ifaces map, after which the name of the interface
+	// is looked up again, since the index might have been reused by another interface
+	expiry map[int]time.Time
+	ttl    time.Duration
 	bufLen int
+
+	clock            func() time.Time
+	interfaceByIndex func(idx int) (string, error)
 }
 
-func NewRegisterer(inner Informer, bufLen int) *Registerer {
+// NewRegisterer creates a Registerer. The interfaces' names are cached for the provided ttl,
+// after which they are looked up again. If ttl is 0, the names are cached until the
+// underlying informer notifies the interface deletion.
+func NewRegisterer(inner Informer, bufLen int, ttl time.Duration) *Registerer {
 	return &Registerer{
-		inner:  inner,
-		bufLen: bufLen,
-		ifaces: map[int]string{},
+		inner:            inner,
+		bufLen:           bufLen,
+		ttl:              ttl,
+		ifaces:           map[int]string{},
+		expiry:           map[int]time.Time{},
+		clock:            time.Now,
+		interfaceByIndex: netInterfaceName,
 	}
 }
 
@@ -34,7 +49,7 @@ func (r *Registerer) Subscribe(ctx context.Context) (<-chan Event, error) {
 			switch ev.Type {
 			case EventAdded:
 				r.m.Lock()
-				r.ifaces[ev.Interface.Index] = ev.Interface.Name
+				r.store(ev.Interface.Index, ev.Interface.Name)
 				r.m.Unlock()
 			case EventDeleted:
 				r.m.Lock()
@@ -43,6 +58,10 @@ func (r *Registerer) Subscribe(ctx context.Context) (<-chan Event, error) {
 				// e.g. due to an out-of-order add/delete signaling
 				if ok && name == ev.Interface.Name {
 					delete(r.ifaces, ev.Interface.Index)
+					delete(r.expiry, ev.Interface.Index)
+				} else if ok {
+					// force checking again the interface name on its next lookup
+					r.expiry[ev.Interface.Index] = time.Time{}
 				}
 				r.m.Unlock()
 			}
@@ -54,20 +73,49 @@ func (r *Registerer) Subscribe(ctx context.Context) (<-chan Event, error) {
 
 // IfaceNameForIndex gets the interface name given an index as recorded by the underlying
 // interfaces' informer. It backs up into the net.InterfaceByIndex function if the interface
-// has not been previously registered
+// has not been previously registered, or its cached name has expired
 func (r *Registerer) IfaceNameForIndex(idx int) (string, bool) {
 	r.m.RLock()
 	name, ok := r.ifaces[idx]
+	expired := ok && r.expired(idx)
 	r.m.RUnlock()
-	if !ok {
-		iface, err := net.InterfaceByIndex(idx)
-		if err != nil {
-			return "", false
-		}
-		name = iface.Name
-		r.m.Lock()
-		r.ifaces[idx] = name
-		r.m.Unlock()
+	if ok && !expired {
+		return name, true
+	}
+	name, err := r.interfaceByIndex(idx)
+	r.m.Lock()
+	defer r.m.Unlock()
+	if err != nil {
+		// the interface does not exist anymore
+		delete(r.ifaces, idx)
+		delete(r.expiry, idx)
+		return "", false
+	}
+	r.store(idx, name)
+	return name, true
+}
+
+// store the interface name. The invoker must hold the write lock
+func (r *Registerer) store(idx int, name string) {
+	r.ifaces[idx] = name
+	if r.ttl > 0 {
+		r.expiry[idx] = r.clock().Add(r.ttl)
+	} else {
+		delete(r.expiry, idx)
+	}
+}
+
+// expired returns whether the cached interface name needs to be looked up again.
+// The invoker must hold the read lock
+func (r *Registerer) expired(idx int) bool {
+	expiry, ok := r.expiry[idx]
+	return ok && !r.clock().Before(expiry)
+}
+
+func netInterfaceName(idx int) (string, error) {
+	iface, err := net.InterfaceByIndex(idx)
+	if err != nil {
+		return "", err
 	}
-	return name, ok
+	return iface.Name, nil
 }
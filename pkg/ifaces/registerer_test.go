@@ -2,7 +2,9 @@ package ifaces
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +16,7 @@ func TestRegisterer(t *testing.T) {
 	defer cancel()
 
 	watcher := NewWatcher(10)
-	registry := NewRegisterer(watcher, 10)
+	registry := NewRegisterer(watcher, 10, 0)
 	// mock net.Interfaces and linkSubscriber to control which interfaces are discovered
 	watcher.interfaces = func() ([]Interface, error) {
 		return []Interface{{"foo", 1}, {"bar", 2}, {"baz", 3}}, nil
@@ -64,3 +66,76 @@ func TestRegisterer(t *testing.T) {
 	assert.Equal(t, "baz", registry.ifaces[3])
 	assert.Equal(t, "bae", registry.ifaces[4])
 }
+
+// informerFake forwards the events from a channel
+type informerFake chan Event
+
+func (i informerFake) Subscribe(_ context.Context) (<-chan Event, error) {
+	return i, nil
+}
+
+func TestRegisterer_IndexReuse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	// names of the interfaces, as reported by the system
+	system := map[int]string{1: "foo", 2: "bar", 5: "unwatched"}
+	informer := make(informerFake, 10)
+	registry := NewRegisterer(informer, 10, time.Minute)
+	registry.clock = func() time.Time { return now }
+	registry.interfaceByIndex = func(idx int) (string, error) {
+		if name, ok := system[idx]; ok {
+			return name, nil
+		}
+		return "", errors.New("not found")
+	}
+	outputEvents, err := registry.Subscribe(ctx)
+	require.NoError(t, err)
+
+	informer <- Event{Type: EventAdded, Interface: Interface{Name: "foo", Index: 1}}
+	informer <- Event{Type: EventAdded, Interface: Interface{Name: "bar", Index: 2}}
+	for i := 0; i < 2; i++ {
+		getEvent(t, outputEvents, timeout)
+	}
+	assertName(t, registry, 1, "foo")
+	assertName(t, registry, 2, "bar")
+	// interfaces that are not notified by the informer are looked up in the system
+	assertName(t, registry, 5, "unwatched")
+
+	// WHEN an interface is removed and a new one reuses its index
+	delete(system, 1)
+	informer <- Event{Type: EventDeleted, Interface: Interface{Name: "foo", Index: 1}}
+	system[1] = "foo2"
+	informer <- Event{Type: EventAdded, Interface: Interface{Name: "foo2", Index: 1}}
+	// AND the add/delete events of another reused index arrive out of order
+	system[2] = "bar2"
+	informer <- Event{Type: EventAdded, Interface: Interface{Name: "bar2", Index: 2}}
+	informer <- Event{Type: EventDeleted, Interface: Interface{Name: "bar", Index: 2}}
+	for i := 0; i < 4; i++ {
+		getEvent(t, outputEvents, timeout)
+	}
+	// THEN the new names are applied
+	assertName(t, registry, 1, "foo2")
+	assertName(t, registry, 2, "bar2")
+
+	// WHEN the index of an interface that is not notified by the informer is reused
+	system[5] = "reused"
+	// THEN the cached name is kept until its TTL expires
+	assertName(t, registry, 5, "unwatched")
+	now = now.Add(time.Minute)
+	assertName(t, registry, 5, "reused")
+
+	// AND removed interfaces are forgotten after the TTL expires
+	delete(system, 5)
+	now = now.Add(time.Minute)
+	_, ok := registry.IfaceNameForIndex(5)
+	assert.False(t, ok)
+}
+
+func assertName(t *testing.T, r *Registerer, idx int, expected string) {
+	t.Helper()
+	name, ok := r.IfaceNameForIndex(idx)
+	assert.True(t, ok)
+	assert.Equal(t, expected, name)
+}
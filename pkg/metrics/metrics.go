@@ -0,0 +1,102 @@
+// Package metrics provides the internal metrics of the agent, which can be exposed via a
+// Prometheus HTTP endpoint
+package metrics
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the registry of the agent internal metrics. Each component that provides metrics
+// creates them from the Metrics instance, so they are registered with the configured prefix.
+type Metrics struct {
+	prefix   string
+	registry *prometheus.Registry
+}
+
+// NewMetrics creates a Metrics registry whose metrics' names are prepended by the provided prefix
+func NewMetrics(prefix string) *Metrics {
+	return &Metrics{
+		prefix:   prefix,
+		registry: prometheus.NewRegistry(),
+	}
+}
+
+// NoOp returns a Metrics registry that is never exposed. Useful for tests or for components that
+// are instantiated without metrics.
+func NoOp() *Metrics {
+	return NewMetrics("")
+}
+
+// Registry returns the underlying Prometheus registry. It can be used by tests to gather the
+// current metrics values.
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler returns an HTTP handler that exposes the metrics in the Prometheus format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// NewCounter creates and registers a counter
+func (m *Metrics) NewCounter(name, help string) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Name: m.prefix + name, Help: help})
+	m.registry.MustRegister(c)
+	return c
+}
+
+// NewCounterVec creates and registers a counter vector with the provided label names. If an
+// equal counter vector is already registered, it is returned instead, so multiple instances of a
+// component (e.g. of an exporter) can share it, each one accounting its own labels.
+func (m *Metrics) NewCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: m.prefix + name, Help: help}, labels)
+	if err := m.registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// NewGauge creates and registers a gauge
+func (m *Metrics) NewGauge(name, help string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Name: m.prefix + name, Help: help})
+	m.registry.MustRegister(g)
+	return g
+}
+
+// NewGaugeVec creates and registers a gauge vector with the provided label names
+func (m *Metrics) NewGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: m.prefix + name, Help: help}, labels)
+	m.registry.MustRegister(g)
+	return g
+}
+
+// NewHistogram creates and registers a histogram with the provided buckets
+func (m *Metrics) NewHistogram(name, help string, buckets []float64) prometheus.Histogram {
+	h := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: m.prefix + name, Help: help, Buckets: buckets,
+	})
+	m.registry.MustRegister(h)
+	return h
+}
+
+// NewHistogramVec creates and registers a histogram vector with the provided buckets and
+// label names
+func (m *Metrics) NewHistogramVec(
+	name, help string, buckets []float64, labels ...string,
+) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: m.prefix + name, Help: help, Buckets: buckets,
+	}, labels)
+	m.registry.MustRegister(h)
+	return h
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/procfs"
+	"github.com/sirupsen/logrus"
+)
+
+var stlog = logrus.WithField("component", "metrics.SelfTelemetry")
+
+// SelfStats is a snapshot of the resource usage of the agent process
+type SelfStats struct {
+	// CPUSeconds is the total user and system CPU time consumed by the process
+	CPUSeconds float64
+	// ResidentMemory is the resident set size (RSS) of the process, in bytes
+	ResidentMemory uint64
+	// HeapAlloc is the number of bytes of allocated heap objects
+	HeapAlloc uint64
+	// Goroutines is the number of running goroutines
+	Goroutines int
+}
+
+// SelfTelemetry periodically samples the resource usage of the agent and exposes it as metrics,
+// so it can be correlated with the volume of processed flows
+type SelfTelemetry struct {
+	cpu prometheus.Counter
+	// CPU time already added to the cpu counter, as the counter only accepts increments
+	cpuSeconds float64
+	memory     prometheus.Gauge
+	heap       prometheus.Gauge
+	goroutines prometheus.Gauge
+}
+
+// NewSelfTelemetry creates a SelfTelemetry whose metrics are registered in the provided registry
+func NewSelfTelemetry(m *Metrics) *SelfTelemetry {
+	return &SelfTelemetry{
+		cpu: m.NewCounter("self_cpu_seconds_total",
+			"Total user and system CPU time consumed by the agent, in seconds"),
+		memory: m.NewGauge("self_resident_memory_bytes",
+			"Resident memory size of the agent, in bytes"),
+		heap: m.NewGauge("self_heap_alloc_bytes",
+			"Bytes of allocated heap objects of the agent"),
+		goroutines: m.NewGauge("self_goroutines",
+			"Number of goroutines of the agent"),
+	}
+}
+
+// Run samples the resource usage each interval, until the context is canceled
+func (s *SelfTelemetry) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.Sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample reads the current resource usage of the agent, updates the metrics and returns it
+func (s *SelfTelemetry) Sample() SelfStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := SelfStats{
+		HeapAlloc:  mem.HeapAlloc,
+		Goroutines: runtime.NumGoroutine(),
+	}
+	if proc, err := procfs.Self(); err != nil {
+		stlog.WithError(err).Debug("can't read process information")
+	} else if stat, err := proc.Stat(); err != nil {
+		stlog.WithError(err).Debug("can't read process stats")
+	} else {
+		stats.CPUSeconds = stat.CPUTime()
+		stats.ResidentMemory = uint64(stat.ResidentMemory())
+	}
+	if stats.CPUSeconds > s.cpuSeconds {
+		s.cpu.Add(stats.CPUSeconds - s.cpuSeconds)
+		s.cpuSeconds = stats.CPUSeconds
+	}
+	s.memory.Set(float64(stats.ResidentMemory))
+	s.heap.Set(float64(stats.HeapAlloc))
+	s.goroutines.Set(float64(stats.Goroutines))
+	stlog.WithField("stats", stats).Debug("agent resource usage")
+	return stats
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelfTelemetry(t *testing.T) {
+	m := NewMetrics("test_")
+	st := NewSelfTelemetry(m)
+
+	// burn some CPU so the consumed CPU time is measurable
+	sum := 0
+	for i := 0; i < 200_000_000; i++ {
+		sum += i
+	}
+	require.NotZero(t, sum)
+
+	stats := st.Sample()
+	assert.Positive(t, stats.CPUSeconds)
+	assert.Positive(t, stats.ResidentMemory)
+	assert.Positive(t, stats.HeapAlloc)
+	assert.Positive(t, stats.Goroutines)
+
+	// the CPU time is exposed as a counter, and the rest of the stats as gauges
+	families, err := m.Registry().Gather()
+	require.NoError(t, err)
+	values := map[string]float64{}
+	for _, f := range families {
+		if f.GetName() == "test_self_cpu_seconds_total" {
+			values[f.GetName()] = f.GetMetric()[0].GetCounter().GetValue()
+		} else {
+			values[f.GetName()] = f.GetMetric()[0].GetGauge().GetValue()
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"test_self_cpu_seconds_total":     stats.CPUSeconds,
+		"test_self_resident_memory_bytes": float64(stats.ResidentMemory),
+		"test_self_heap_alloc_bytes":      float64(stats.HeapAlloc),
+		"test_self_goroutines":            float64(stats.Goroutines),
+	}, values)
+
+	// AND the counter only grows by the CPU time consumed since the previous sample
+	next := st.Sample()
+	families, err = m.Registry().Gather()
+	require.NoError(t, err)
+	for _, f := range families {
+		if f.GetName() == "test_self_cpu_seconds_total" {
+			assert.Equal(t, next.CPUSeconds, f.GetMetric()[0].GetCounter().GetValue())
+		}
+	}
+}
@@ -9,6 +9,7 @@ package pbflow
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
 	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
@@ -21,13 +22,281 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// simplified state of a TCP connection, as derived from the observed TCP flags
+type TCPState int32
+
+const (
+	TCPState_TCP_STATE_UNKNOWN      TCPState = 0
+	TCPState_TCP_STATE_SYN_SENT     TCPState = 1
+	TCPState_TCP_STATE_SYN_RECEIVED TCPState = 2
+	TCPState_TCP_STATE_ESTABLISHED  TCPState = 3
+	TCPState_TCP_STATE_FIN_WAIT     TCPState = 4
+	TCPState_TCP_STATE_CLOSED       TCPState = 5
+)
+
+// Enum value maps for TCPState.
+var (
+	TCPState_name = map[int32]string{
+		0: "TCP_STATE_UNKNOWN",
+		1: "TCP_STATE_SYN_SENT",
+		2: "TCP_STATE_SYN_RECEIVED",
+		3: "TCP_STATE_ESTABLISHED",
+		4: "TCP_STATE_FIN_WAIT",
+		5: "TCP_STATE_CLOSED",
+	}
+	TCPState_value = map[string]int32{
+		"TCP_STATE_UNKNOWN":      0,
+		"TCP_STATE_SYN_SENT":     1,
+		"TCP_STATE_SYN_RECEIVED": 2,
+		"TCP_STATE_ESTABLISHED":  3,
+		"TCP_STATE_FIN_WAIT":     4,
+		"TCP_STATE_CLOSED":       5,
+	}
+)
+
+func (x TCPState) Enum() *TCPState {
+	p := new(TCPState)
+	*p = x
+	return p
+}
+
+func (x TCPState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TCPState) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_flow_proto_enumTypes[0].Descriptor()
+}
+
+func (TCPState) Type() protoreflect.EnumType {
+	return &file_proto_flow_proto_enumTypes[0]
+}
+
+func (x TCPState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TCPState.Descriptor instead.
+func (TCPState) EnumDescriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{0}
+}
+
+type FlowEndReason int32
+
+const (
+	FlowEndReason_FLOW_END_REASON_EVICTION          FlowEndReason = 0
+	FlowEndReason_FLOW_END_REASON_LIFETIME_CAP      FlowEndReason = 1
+	FlowEndReason_FLOW_END_REASON_HEARTBEAT         FlowEndReason = 2
+	FlowEndReason_FLOW_END_REASON_TCP_CLOSE         FlowEndReason = 3
+	FlowEndReason_FLOW_END_REASON_IFACE_REMOVED     FlowEndReason = 4
+	FlowEndReason_FLOW_END_REASON_PORT_SCAN_SUMMARY FlowEndReason = 5
+)
+
+// Enum value maps for FlowEndReason.
+var (
+	FlowEndReason_name = map[int32]string{
+		0: "FLOW_END_REASON_EVICTION",
+		1: "FLOW_END_REASON_LIFETIME_CAP",
+		2: "FLOW_END_REASON_HEARTBEAT",
+		3: "FLOW_END_REASON_TCP_CLOSE",
+		4: "FLOW_END_REASON_IFACE_REMOVED",
+		5: "FLOW_END_REASON_PORT_SCAN_SUMMARY",
+	}
+	FlowEndReason_value = map[string]int32{
+		"FLOW_END_REASON_EVICTION":          0,
+		"FLOW_END_REASON_LIFETIME_CAP":      1,
+		"FLOW_END_REASON_HEARTBEAT":         2,
+		"FLOW_END_REASON_TCP_CLOSE":         3,
+		"FLOW_END_REASON_IFACE_REMOVED":     4,
+		"FLOW_END_REASON_PORT_SCAN_SUMMARY": 5,
+	}
+)
+
+func (x FlowEndReason) Enum() *FlowEndReason {
+	p := new(FlowEndReason)
+	*p = x
+	return p
+}
+
+func (x FlowEndReason) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (FlowEndReason) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_flow_proto_enumTypes[1].Descriptor()
+}
+
+func (FlowEndReason) Type() protoreflect.EnumType {
+	return &file_proto_flow_proto_enumTypes[1]
+}
+
+func (x FlowEndReason) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use FlowEndReason.Descriptor instead.
+func (FlowEndReason) EnumDescriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{1}
+}
+
+type PolicyVerdict int32
+
+const (
+	PolicyVerdict_POLICY_VERDICT_UNKNOWN PolicyVerdict = 0
+	PolicyVerdict_POLICY_VERDICT_ALLOWED PolicyVerdict = 1
+	PolicyVerdict_POLICY_VERDICT_DENIED  PolicyVerdict = 2
+)
+
+// Enum value maps for PolicyVerdict.
+var (
+	PolicyVerdict_name = map[int32]string{
+		0: "POLICY_VERDICT_UNKNOWN",
+		1: "POLICY_VERDICT_ALLOWED",
+		2: "POLICY_VERDICT_DENIED",
+	}
+	PolicyVerdict_value = map[string]int32{
+		"POLICY_VERDICT_UNKNOWN": 0,
+		"POLICY_VERDICT_ALLOWED": 1,
+		"POLICY_VERDICT_DENIED":  2,
+	}
+)
+
+func (x PolicyVerdict) Enum() *PolicyVerdict {
+	p := new(PolicyVerdict)
+	*p = x
+	return p
+}
+
+func (x PolicyVerdict) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (PolicyVerdict) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_flow_proto_enumTypes[2].Descriptor()
+}
+
+func (PolicyVerdict) Type() protoreflect.EnumType {
+	return &file_proto_flow_proto_enumTypes[2]
+}
+
+func (x PolicyVerdict) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use PolicyVerdict.Descriptor instead.
+func (PolicyVerdict) EnumDescriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{2}
+}
+
+type TunnelType int32
+
+const (
+	TunnelType_TUNNEL_TYPE_NONE   TunnelType = 0
+	TunnelType_TUNNEL_TYPE_VXLAN  TunnelType = 1
+	TunnelType_TUNNEL_TYPE_GENEVE TunnelType = 2
+)
+
+// Enum value maps for TunnelType.
+var (
+	TunnelType_name = map[int32]string{
+		0: "TUNNEL_TYPE_NONE",
+		1: "TUNNEL_TYPE_VXLAN",
+		2: "TUNNEL_TYPE_GENEVE",
+	}
+	TunnelType_value = map[string]int32{
+		"TUNNEL_TYPE_NONE":   0,
+		"TUNNEL_TYPE_VXLAN":  1,
+		"TUNNEL_TYPE_GENEVE": 2,
+	}
+)
+
+func (x TunnelType) Enum() *TunnelType {
+	p := new(TunnelType)
+	*p = x
+	return p
+}
+
+func (x TunnelType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TunnelType) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_flow_proto_enumTypes[3].Descriptor()
+}
+
+func (TunnelType) Type() protoreflect.EnumType {
+	return &file_proto_flow_proto_enumTypes[3]
+}
+
+func (x TunnelType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TunnelType.Descriptor instead.
+func (TunnelType) EnumDescriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{3}
+}
+
+type TrafficClass int32
+
+const (
+	TrafficClass_TRAFFIC_CLASS_UNKNOWN   TrafficClass = 0
+	TrafficClass_TRAFFIC_CLASS_UNICAST   TrafficClass = 1
+	TrafficClass_TRAFFIC_CLASS_MULTICAST TrafficClass = 2
+	TrafficClass_TRAFFIC_CLASS_BROADCAST TrafficClass = 3
+)
+
+// Enum value maps for TrafficClass.
+var (
+	TrafficClass_name = map[int32]string{
+		0: "TRAFFIC_CLASS_UNKNOWN",
+		1: "TRAFFIC_CLASS_UNICAST",
+		2: "TRAFFIC_CLASS_MULTICAST",
+		3: "TRAFFIC_CLASS_BROADCAST",
+	}
+	TrafficClass_value = map[string]int32{
+		"TRAFFIC_CLASS_UNKNOWN":   0,
+		"TRAFFIC_CLASS_UNICAST":   1,
+		"TRAFFIC_CLASS_MULTICAST": 2,
+		"TRAFFIC_CLASS_BROADCAST": 3,
+	}
+)
+
+func (x TrafficClass) Enum() *TrafficClass {
+	p := new(TrafficClass)
+	*p = x
+	return p
+}
+
+func (x TrafficClass) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TrafficClass) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_flow_proto_enumTypes[4].Descriptor()
+}
+
+func (TrafficClass) Type() protoreflect.EnumType {
+	return &file_proto_flow_proto_enumTypes[4]
+}
+
+func (x TrafficClass) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TrafficClass.Descriptor instead.
+func (TrafficClass) EnumDescriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{4}
+}
+
 // as defined by field 61 in
 // https://www.iana.org/assignments/ipfix/ipfix.xhtml
 type Direction int32
 
 const (
-	Direction_INGRESS Direction = 0
-	Direction_EGRESS  Direction = 1
+	Direction_INGRESS           Direction = 0
+	Direction_EGRESS            Direction = 1
+	Direction_DIRECTION_UNKNOWN Direction = 2
 )
 
 // Enum value maps for Direction.
@@ -35,10 +304,12 @@ var (
 	Direction_name = map[int32]string{
 		0: "INGRESS",
 		1: "EGRESS",
+		2: "DIRECTION_UNKNOWN",
 	}
 	Direction_value = map[string]int32{
-		"INGRESS": 0,
-		"EGRESS":  1,
+		"INGRESS":           0,
+		"EGRESS":            1,
+		"DIRECTION_UNKNOWN": 2,
 	}
 )
 
@@ -53,11 +324,11 @@ func (x Direction) String() string {
 }
 
 func (Direction) Descriptor() protoreflect.EnumDescriptor {
-	return file_proto_flow_proto_enumTypes[0].Descriptor()
+	return file_proto_flow_proto_enumTypes[5].Descriptor()
 }
 
 func (Direction) Type() protoreflect.EnumType {
-	return &file_proto_flow_proto_enumTypes[0]
+	return &file_proto_flow_proto_enumTypes[5]
 }
 
 func (x Direction) Number() protoreflect.EnumNumber {
@@ -66,7 +337,7 @@ func (x Direction) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use Direction.Descriptor instead.
 func (Direction) EnumDescriptor() ([]byte, []int) {
-	return file_proto_flow_proto_rawDescGZIP(), []int{0}
+	return file_proto_flow_proto_rawDescGZIP(), []int{5}
 }
 
 // intentionally empty
@@ -180,6 +451,103 @@ type Record struct {
 	AgentIp *IP    `protobuf:"bytes,12,opt,name=agent_ip,json=agentIp,proto3" json:"agent_ip,omitempty"`
 	Flags   uint32 `protobuf:"varint,13,opt,name=flags,proto3" json:"flags,omitempty"`
 	Icmp    *Icmp  `protobuf:"bytes,14,opt,name=icmp,proto3" json:"icmp,omitempty"`
+	// first bytes of the transport-layer payload, if payload sampling is enabled
+	PayloadSample []byte `protobuf:"bytes,15,opt,name=payload_sample,json=payloadSample,proto3" json:"payload_sample,omitempty"`
+	// state of the TCP connection, if TCP state tracking is enabled
+	TcpState TCPState `protobuf:"varint,16,opt,name=tcp_state,json=tcpState,proto3,enum=pbflow.TCPState" json:"tcp_state,omitempty"`
+	// minimum and maximum IPv4 TTL or IPv6 hop limit observed in the flow packets
+	MinTtl uint32 `protobuf:"varint,17,opt,name=min_ttl,json=minTtl,proto3" json:"min_ttl,omitempty"`
+	MaxTtl uint32 `protobuf:"varint,18,opt,name=max_ttl,json=maxTtl,proto3" json:"max_ttl,omitempty"`
+	// number of IP fragments accounted in the flow
+	FragmentedPackets uint32 `protobuf:"varint,19,opt,name=fragmented_packets,json=fragmentedPackets,proto3" json:"fragmented_packets,omitempty"`
+	// number of packets in each bucket of the packet size histogram
+	PacketSizeBuckets []uint32 `protobuf:"varint,20,rep,packed,name=packet_size_buckets,json=packetSizeBuckets,proto3" json:"packet_size_buckets,omitempty"`
+	// time between a TCP SYN and the SYN-ACK answering it. Only set in the flow carrying the
+	// SYN-ACK, when both packets have been observed
+	ServerConnectLatency *durationpb.Duration `protobuf:"bytes,21,opt,name=server_connect_latency,json=serverConnectLatency,proto3" json:"server_connect_latency,omitempty"`
+	// reason why the flow has been exported
+	EndReason FlowEndReason `protobuf:"varint,22,opt,name=end_reason,json=endReason,proto3,enum=pbflow.FlowEndReason" json:"end_reason,omitempty"`
+	// name of the well-known service associated to the destination port, if service tagging is enabled
+	Service string `protobuf:"bytes,23,opt,name=service,proto3" json:"service,omitempty"`
+	// cgroup v2 id of the local socket that sent or received the flow packets. 0 if unknown
+	CgroupId uint64 `protobuf:"varint,24,opt,name=cgroup_id,json=cgroupId,proto3" json:"cgroup_id,omitempty"`
+	// whether the flow was allowed or denied by a network policy, if that information is available
+	PolicyVerdict PolicyVerdict `protobuf:"varint,25,opt,name=policy_verdict,json=policyVerdict,proto3,enum=pbflow.PolicyVerdict" json:"policy_verdict,omitempty"`
+	// hostnames of the external source and destination addresses, if reverse DNS is enabled
+	SrcHostname string `protobuf:"bytes,26,opt,name=src_hostname,json=srcHostname,proto3" json:"src_hostname,omitempty"`
+	DstHostname string `protobuf:"bytes,27,opt,name=dst_hostname,json=dstHostname,proto3" json:"dst_hostname,omitempty"`
+	// identifier of the connection the flow belongs to, to correlate the flows of both directions
+	ConnectionId uint64 `protobuf:"varint,30,opt,name=connection_id,json=connectionId,proto3" json:"connection_id,omitempty"`
+	// identifiers of the cluster and the tenant where the flow has been observed
+	ClusterId string `protobuf:"bytes,31,opt,name=cluster_id,json=clusterId,proto3" json:"cluster_id,omitempty"`
+	TenantId  string `protobuf:"bytes,32,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	// number of distinct flows merged into this record (e.g. when keyed by service port)
+	SubFlowCount uint32 `protobuf:"varint,33,opt,name=sub_flow_count,json=subFlowCount,proto3" json:"sub_flow_count,omitempty"`
+	// whether the flow is unicast, multicast or broadcast, if traffic classification is enabled
+	TrafficClass TrafficClass `protobuf:"varint,34,opt,name=traffic_class,json=trafficClass,proto3,enum=pbflow.TrafficClass" json:"traffic_class,omitempty"`
+	// minimum, maximum and mean times between two consecutive packets of the flow. Only set for
+	// flows with more than one packet
+	MinIpg  *durationpb.Duration `protobuf:"bytes,35,opt,name=min_ipg,json=minIpg,proto3" json:"min_ipg,omitempty"`
+	MaxIpg  *durationpb.Duration `protobuf:"bytes,36,opt,name=max_ipg,json=maxIpg,proto3" json:"max_ipg,omitempty"`
+	MeanIpg *durationpb.Duration `protobuf:"bytes,37,opt,name=mean_ipg,json=meanIpg,proto3" json:"mean_ipg,omitempty"`
+	// labels of the most specific subnets of the source and destination addresses, if subnet
+	// labels are enabled
+	SrcLabels map[string]string `protobuf:"bytes,38,rep,name=src_labels,json=srcLabels,proto3" json:"src_labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	DstLabels map[string]string `protobuf:"bytes,39,rep,name=dst_labels,json=dstLabels,proto3" json:"dst_labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// round trip time, and packets and bytes of the echo reply flow, if the ICMP echo request flow
+	// has been merged with its reply flow
+	EchoRtt          *durationpb.Duration `protobuf:"bytes,40,opt,name=echo_rtt,json=echoRtt,proto3" json:"echo_rtt,omitempty"`
+	EchoReplyPackets uint32               `protobuf:"varint,41,opt,name=echo_reply_packets,json=echoReplyPackets,proto3" json:"echo_reply_packets,omitempty"`
+	EchoReplyBytes   uint64               `protobuf:"varint,42,opt,name=echo_reply_bytes,json=echoReplyBytes,proto3" json:"echo_reply_bytes,omitempty"`
+	// version of the agent and hash of its eBPF program, if build info tagging is enabled
+	AgentVersion string `protobuf:"bytes,43,opt,name=agent_version,json=agentVersion,proto3" json:"agent_version,omitempty"`
+	BpfProgHash  string `protobuf:"bytes,44,opt,name=bpf_prog_hash,json=bpfProgHash,proto3" json:"bpf_prog_hash,omitempty"`
+	// identifier of the interface that is qualified by the node name, so it is unique across the
+	// cluster, if interface qualification is enabled
+	InterfaceId string `protobuf:"bytes,45,opt,name=interface_id,json=interfaceId,proto3" json:"interface_id,omitempty"`
+	// encapsulation of the flow, if tunnel parsing is enabled. Absent for the non-tunneled traffic
+	Tunnel *Tunnel `protobuf:"bytes,46,opt,name=tunnel,proto3" json:"tunnel,omitempty"`
+	// version of the schema of the record, which is bumped whenever its fields change
+	SchemaVersion uint32 `protobuf:"varint,47,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	// whether the source of the flow has hit many distinct destination ports in a short time, if
+	// port scan detection is enabled
+	ScanSuspect bool `protobuf:"varint,48,opt,name=scan_suspect,json=scanSuspect,proto3" json:"scan_suspect,omitempty"`
+	// identifier and name of the process that owns the local socket of the flow, if the process
+	// enricher is enabled. Absent for the forwarded or transit flows
+	Pid  uint32 `protobuf:"varint,49,opt,name=pid,proto3" json:"pid,omitempty"`
+	Comm string `protobuf:"bytes,50,opt,name=comm,proto3" json:"comm,omitempty"`
+	// deterministic identifier of the record, derived from the flow key and the window start, if
+	// record identifiers are enabled
+	RecordId uint64 `protobuf:"varint,51,opt,name=record_id,json=recordId,proto3" json:"record_id,omitempty"`
+	// gateway of the local route towards the destination, if the next hop enricher is enabled.
+	// Absent if no route matches, or if the destination is directly reachable
+	NextHop *IP `protobuf:"bytes,52,opt,name=next_hop,json=nextHop,proto3" json:"next_hop,omitempty"`
+	// QUIC long header of the first packet with payload of the flow, if QUIC tracking is enabled.
+	// Absent if the flow isn't recognized as QUIC
+	Quic *Quic `protobuf:"bytes,53,opt,name=quic,proto3" json:"quic,omitempty"`
+	// number of IPv4 packets whose identification was repeated by a recent packet of the flow
+	// (e.g. duplicated by a misconfigured port mirror), if IP ID tracking is enabled
+	DuplicatePackets uint32 `protobuf:"varint,54,opt,name=duplicate_packets,json=duplicatePackets,proto3" json:"duplicate_packets,omitempty"`
+	// distinct destination ports that the source has hit within the port scan window, in the order
+	// they were first hit. Only set in the per-source records whose end_reason is
+	// FLOW_END_REASON_PORT_SCAN_SUMMARY. The distinct ports that didn't fit in the bounded list are
+	// counted in observed_ports_overflow
+	ObservedPorts         []uint32 `protobuf:"varint,55,rep,packed,name=observed_ports,json=observedPorts,proto3" json:"observed_ports,omitempty"`
+	ObservedPortsOverflow uint32   `protobuf:"varint,56,opt,name=observed_ports_overflow,json=observedPortsOverflow,proto3" json:"observed_ports_overflow,omitempty"`
+	// container and pod of the local socket of the flow, as resolved from its cgroup, if the
+	// container enricher is enabled. Absent if the cgroup doesn't belong to a container or a pod
+	ContainerId string `protobuf:"bytes,57,opt,name=container_id,json=containerId,proto3" json:"container_id,omitempty"`
+	PodUid      string `protobuf:"bytes,58,opt,name=pod_uid,json=podUid,proto3" json:"pod_uid,omitempty"`
+	// namespaces and names of the pods of the source and destination addresses, if the Kubernetes
+	// enricher is enabled. Absent if the address doesn't belong to a pod
+	SrcNamespace string `protobuf:"bytes,59,opt,name=src_namespace,json=srcNamespace,proto3" json:"src_namespace,omitempty"`
+	SrcPod       string `protobuf:"bytes,60,opt,name=src_pod,json=srcPod,proto3" json:"src_pod,omitempty"`
+	DstNamespace string `protobuf:"bytes,61,opt,name=dst_namespace,json=dstNamespace,proto3" json:"dst_namespace,omitempty"`
+	DstPod       string `protobuf:"bytes,62,opt,name=dst_pod,json=dstPod,proto3" json:"dst_pod,omitempty"`
+	// ISO 3166-1 country codes of the source and destination addresses, if the GeoIP enricher is
+	// enabled. Absent if the address isn't found in the GeoIP database
+	SrcCountry string `protobuf:"bytes,63,opt,name=src_country,json=srcCountry,proto3" json:"src_country,omitempty"`
+	DstCountry string `protobuf:"bytes,64,opt,name=dst_country,json=dstCountry,proto3" json:"dst_country,omitempty"`
 }
 
 func (x *Record) Reset() {
@@ -312,6 +680,342 @@ func (x *Record) GetIcmp() *Icmp {
 	return nil
 }
 
+func (x *Record) GetPayloadSample() []byte {
+	if x != nil {
+		return x.PayloadSample
+	}
+	return nil
+}
+
+func (x *Record) GetTcpState() TCPState {
+	if x != nil {
+		return x.TcpState
+	}
+	return TCPState_TCP_STATE_UNKNOWN
+}
+
+func (x *Record) GetMinTtl() uint32 {
+	if x != nil {
+		return x.MinTtl
+	}
+	return 0
+}
+
+func (x *Record) GetMaxTtl() uint32 {
+	if x != nil {
+		return x.MaxTtl
+	}
+	return 0
+}
+
+func (x *Record) GetFragmentedPackets() uint32 {
+	if x != nil {
+		return x.FragmentedPackets
+	}
+	return 0
+}
+
+func (x *Record) GetPacketSizeBuckets() []uint32 {
+	if x != nil {
+		return x.PacketSizeBuckets
+	}
+	return nil
+}
+
+func (x *Record) GetServerConnectLatency() *durationpb.Duration {
+	if x != nil {
+		return x.ServerConnectLatency
+	}
+	return nil
+}
+
+func (x *Record) GetEndReason() FlowEndReason {
+	if x != nil {
+		return x.EndReason
+	}
+	return FlowEndReason_FLOW_END_REASON_EVICTION
+}
+
+func (x *Record) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *Record) GetCgroupId() uint64 {
+	if x != nil {
+		return x.CgroupId
+	}
+	return 0
+}
+
+func (x *Record) GetPolicyVerdict() PolicyVerdict {
+	if x != nil {
+		return x.PolicyVerdict
+	}
+	return PolicyVerdict_POLICY_VERDICT_UNKNOWN
+}
+
+func (x *Record) GetSrcHostname() string {
+	if x != nil {
+		return x.SrcHostname
+	}
+	return ""
+}
+
+func (x *Record) GetDstHostname() string {
+	if x != nil {
+		return x.DstHostname
+	}
+	return ""
+}
+
+func (x *Record) GetConnectionId() uint64 {
+	if x != nil {
+		return x.ConnectionId
+	}
+	return 0
+}
+
+func (x *Record) GetClusterId() string {
+	if x != nil {
+		return x.ClusterId
+	}
+	return ""
+}
+
+func (x *Record) GetTenantId() string {
+	if x != nil {
+		return x.TenantId
+	}
+	return ""
+}
+
+func (x *Record) GetSubFlowCount() uint32 {
+	if x != nil {
+		return x.SubFlowCount
+	}
+	return 0
+}
+
+func (x *Record) GetTrafficClass() TrafficClass {
+	if x != nil {
+		return x.TrafficClass
+	}
+	return TrafficClass_TRAFFIC_CLASS_UNKNOWN
+}
+
+func (x *Record) GetMinIpg() *durationpb.Duration {
+	if x != nil {
+		return x.MinIpg
+	}
+	return nil
+}
+
+func (x *Record) GetMaxIpg() *durationpb.Duration {
+	if x != nil {
+		return x.MaxIpg
+	}
+	return nil
+}
+
+func (x *Record) GetMeanIpg() *durationpb.Duration {
+	if x != nil {
+		return x.MeanIpg
+	}
+	return nil
+}
+
+func (x *Record) GetSrcLabels() map[string]string {
+	if x != nil {
+		return x.SrcLabels
+	}
+	return nil
+}
+
+func (x *Record) GetDstLabels() map[string]string {
+	if x != nil {
+		return x.DstLabels
+	}
+	return nil
+}
+
+func (x *Record) GetEchoRtt() *durationpb.Duration {
+	if x != nil {
+		return x.EchoRtt
+	}
+	return nil
+}
+
+func (x *Record) GetEchoReplyPackets() uint32 {
+	if x != nil {
+		return x.EchoReplyPackets
+	}
+	return 0
+}
+
+func (x *Record) GetEchoReplyBytes() uint64 {
+	if x != nil {
+		return x.EchoReplyBytes
+	}
+	return 0
+}
+
+func (x *Record) GetAgentVersion() string {
+	if x != nil {
+		return x.AgentVersion
+	}
+	return ""
+}
+
+func (x *Record) GetBpfProgHash() string {
+	if x != nil {
+		return x.BpfProgHash
+	}
+	return ""
+}
+
+func (x *Record) GetInterfaceId() string {
+	if x != nil {
+		return x.InterfaceId
+	}
+	return ""
+}
+
+func (x *Record) GetTunnel() *Tunnel {
+	if x != nil {
+		return x.Tunnel
+	}
+	return nil
+}
+
+func (x *Record) GetSchemaVersion() uint32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *Record) GetScanSuspect() bool {
+	if x != nil {
+		return x.ScanSuspect
+	}
+	return false
+}
+
+func (x *Record) GetPid() uint32 {
+	if x != nil {
+		return x.Pid
+	}
+	return 0
+}
+
+func (x *Record) GetComm() string {
+	if x != nil {
+		return x.Comm
+	}
+	return ""
+}
+
+func (x *Record) GetRecordId() uint64 {
+	if x != nil {
+		return x.RecordId
+	}
+	return 0
+}
+
+func (x *Record) GetNextHop() *IP {
+	if x != nil {
+		return x.NextHop
+	}
+	return nil
+}
+
+func (x *Record) GetQuic() *Quic {
+	if x != nil {
+		return x.Quic
+	}
+	return nil
+}
+
+func (x *Record) GetDuplicatePackets() uint32 {
+	if x != nil {
+		return x.DuplicatePackets
+	}
+	return 0
+}
+
+func (x *Record) GetObservedPorts() []uint32 {
+	if x != nil {
+		return x.ObservedPorts
+	}
+	return nil
+}
+
+func (x *Record) GetObservedPortsOverflow() uint32 {
+	if x != nil {
+		return x.ObservedPortsOverflow
+	}
+	return 0
+}
+
+func (x *Record) GetContainerId() string {
+	if x != nil {
+		return x.ContainerId
+	}
+	return ""
+}
+
+func (x *Record) GetPodUid() string {
+	if x != nil {
+		return x.PodUid
+	}
+	return ""
+}
+
+func (x *Record) GetSrcNamespace() string {
+	if x != nil {
+		return x.SrcNamespace
+	}
+	return ""
+}
+
+func (x *Record) GetSrcPod() string {
+	if x != nil {
+		return x.SrcPod
+	}
+	return ""
+}
+
+func (x *Record) GetDstNamespace() string {
+	if x != nil {
+		return x.DstNamespace
+	}
+	return ""
+}
+
+func (x *Record) GetDstPod() string {
+	if x != nil {
+		return x.DstPod
+	}
+	return ""
+}
+
+func (x *Record) GetSrcCountry() string {
+	if x != nil {
+		return x.SrcCountry
+	}
+	return ""
+}
+
+func (x *Record) GetDstCountry() string {
+	if x != nil {
+		return x.DstCountry
+	}
+	return ""
+}
+
 type DataLink struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -567,6 +1271,143 @@ func (x *Transport) GetProtocol() uint32 {
 	return 0
 }
 
+type Tunnel struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Type TunnelType `protobuf:"varint,1,opt,name=type,proto3,enum=pbflow.TunnelType" json:"type,omitempty"`
+	// VXLAN or Geneve network identifier
+	Vni uint32 `protobuf:"varint,2,opt,name=vni,proto3" json:"vni,omitempty"`
+	// attributes of the encapsulated flow. Absent if the inner headers couldn't be parsed
+	InnerNetwork   *Network   `protobuf:"bytes,3,opt,name=inner_network,json=innerNetwork,proto3" json:"inner_network,omitempty"`
+	InnerTransport *Transport `protobuf:"bytes,4,opt,name=inner_transport,json=innerTransport,proto3" json:"inner_transport,omitempty"`
+}
+
+func (x *Tunnel) Reset() {
+	*x = Tunnel{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_flow_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Tunnel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tunnel) ProtoMessage() {}
+
+func (x *Tunnel) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_flow_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tunnel.ProtoReflect.Descriptor instead.
+func (*Tunnel) Descriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Tunnel) GetType() TunnelType {
+	if x != nil {
+		return x.Type
+	}
+	return TunnelType_TUNNEL_TYPE_NONE
+}
+
+func (x *Tunnel) GetVni() uint32 {
+	if x != nil {
+		return x.Vni
+	}
+	return 0
+}
+
+func (x *Tunnel) GetInnerNetwork() *Network {
+	if x != nil {
+		return x.InnerNetwork
+	}
+	return nil
+}
+
+func (x *Tunnel) GetInnerTransport() *Transport {
+	if x != nil {
+		return x.InnerTransport
+	}
+	return nil
+}
+
+type Quic struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version uint32 `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	// destination and source connection IDs
+	Dcid []byte `protobuf:"bytes,2,opt,name=dcid,proto3" json:"dcid,omitempty"`
+	Scid []byte `protobuf:"bytes,3,opt,name=scid,proto3" json:"scid,omitempty"`
+}
+
+func (x *Quic) Reset() {
+	*x = Quic{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_flow_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Quic) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Quic) ProtoMessage() {}
+
+func (x *Quic) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_flow_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Quic.ProtoReflect.Descriptor instead.
+func (*Quic) Descriptor() ([]byte, []int) {
+	return file_proto_flow_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Quic) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Quic) GetDcid() []byte {
+	if x != nil {
+		return x.Dcid
+	}
+	return nil
+}
+
+func (x *Quic) GetScid() []byte {
+	if x != nil {
+		return x.Scid
+	}
+	return nil
+}
+
 type Icmp struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -579,7 +1420,7 @@ type Icmp struct {
 func (x *Icmp) Reset() {
 	*x = Icmp{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_proto_flow_proto_msgTypes[7]
+		mi := &file_proto_flow_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -592,7 +1433,7 @@ func (x *Icmp) String() string {
 func (*Icmp) ProtoMessage() {}
 
 func (x *Icmp) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_flow_proto_msgTypes[7]
+	mi := &file_proto_flow_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -605,7 +1446,7 @@ func (x *Icmp) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Icmp.ProtoReflect.Descriptor instead.
 func (*Icmp) Descriptor() ([]byte, []int) {
-	return file_proto_flow_proto_rawDescGZIP(), []int{7}
+	return file_proto_flow_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *Icmp) GetIcmpType() uint32 {
@@ -628,12 +1469,14 @@ var file_proto_flow_proto_rawDesc = []byte{
 	0x0a, 0x10, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x70, 0x72, 0x6f,
 	0x74, 0x6f, 0x12, 0x06, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67,
 	0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65,
-	0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x10, 0x0a, 0x0e, 0x43,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x10, 0x0a, 0x0e, 0x43,
 	0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x33, 0x0a,
 	0x07, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x12, 0x28, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72,
 	0x69, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x62, 0x66, 0x6c,
 	0x6f, 0x77, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69,
-	0x65, 0x73, 0x22, 0xb6, 0x04, 0x0a, 0x06, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x21, 0x0a,
+	0x65, 0x73, 0x22, 0xb5, 0x14, 0x0a, 0x06, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x21, 0x0a,
 	0x0c, 0x65, 0x74, 0x68, 0x5f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x01, 0x20,
 	0x01, 0x28, 0x0d, 0x52, 0x0b, 0x65, 0x74, 0x68, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
 	0x12, 0x2f, 0x0a, 0x09, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20,
@@ -668,38 +1511,227 @@ var file_proto_flow_proto_rawDesc = []byte{
 	0x74, 0x49, 0x70, 0x12, 0x14, 0x0a, 0x05, 0x66, 0x6c, 0x61, 0x67, 0x73, 0x18, 0x0d, 0x20, 0x01,
 	0x28, 0x0d, 0x52, 0x05, 0x66, 0x6c, 0x61, 0x67, 0x73, 0x12, 0x20, 0x0a, 0x04, 0x69, 0x63, 0x6d,
 	0x70, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77,
-	0x2e, 0x49, 0x63, 0x6d, 0x70, 0x52, 0x04, 0x69, 0x63, 0x6d, 0x70, 0x22, 0x3c, 0x0a, 0x08, 0x44,
-	0x61, 0x74, 0x61, 0x4c, 0x69, 0x6e, 0x6b, 0x12, 0x17, 0x0a, 0x07, 0x73, 0x72, 0x63, 0x5f, 0x6d,
-	0x61, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x73, 0x72, 0x63, 0x4d, 0x61, 0x63,
-	0x12, 0x17, 0x0a, 0x07, 0x64, 0x73, 0x74, 0x5f, 0x6d, 0x61, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x04, 0x52, 0x06, 0x64, 0x73, 0x74, 0x4d, 0x61, 0x63, 0x22, 0x57, 0x0a, 0x07, 0x4e, 0x65, 0x74,
-	0x77, 0x6f, 0x72, 0x6b, 0x12, 0x25, 0x0a, 0x08, 0x73, 0x72, 0x63, 0x5f, 0x61, 0x64, 0x64, 0x72,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e,
-	0x49, 0x50, 0x52, 0x07, 0x73, 0x72, 0x63, 0x41, 0x64, 0x64, 0x72, 0x12, 0x25, 0x0a, 0x08, 0x64,
-	0x73, 0x74, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e,
-	0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x49, 0x50, 0x52, 0x07, 0x64, 0x73, 0x74, 0x41, 0x64,
-	0x64, 0x72, 0x22, 0x3d, 0x0a, 0x02, 0x49, 0x50, 0x12, 0x14, 0x0a, 0x04, 0x69, 0x70, 0x76, 0x34,
-	0x18, 0x01, 0x20, 0x01, 0x28, 0x07, 0x48, 0x00, 0x52, 0x04, 0x69, 0x70, 0x76, 0x34, 0x12, 0x14,
-	0x0a, 0x04, 0x69, 0x70, 0x76, 0x36, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x04,
-	0x69, 0x70, 0x76, 0x36, 0x42, 0x0b, 0x0a, 0x09, 0x69, 0x70, 0x5f, 0x66, 0x61, 0x6d, 0x69, 0x6c,
-	0x79, 0x22, 0x5d, 0x0a, 0x09, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x19,
-	0x0a, 0x08, 0x73, 0x72, 0x63, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
-	0x52, 0x07, 0x73, 0x72, 0x63, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x73, 0x74,
-	0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x64, 0x73, 0x74,
-	0x50, 0x6f, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
+	0x2e, 0x49, 0x63, 0x6d, 0x70, 0x52, 0x04, 0x69, 0x63, 0x6d, 0x70, 0x12, 0x25, 0x0a, 0x0e, 0x70,
+	0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x18, 0x0f, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x0d, 0x70, 0x61, 0x79, 0x6c, 0x6f, 0x61, 0x64, 0x53, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x12, 0x2d, 0x0a, 0x09, 0x74, 0x63, 0x70, 0x5f, 0x73, 0x74, 0x61, 0x74, 0x65, 0x18,
+	0x10, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x10, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x54,
+	0x43, 0x50, 0x53, 0x74, 0x61, 0x74, 0x65, 0x52, 0x08, 0x74, 0x63, 0x70, 0x53, 0x74, 0x61, 0x74,
+	0x65, 0x12, 0x17, 0x0a, 0x07, 0x6d, 0x69, 0x6e, 0x5f, 0x74, 0x74, 0x6c, 0x18, 0x11, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x06, 0x6d, 0x69, 0x6e, 0x54, 0x74, 0x6c, 0x12, 0x17, 0x0a, 0x07, 0x6d, 0x61,
+	0x78, 0x5f, 0x74, 0x74, 0x6c, 0x18, 0x12, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x06, 0x6d, 0x61, 0x78,
+	0x54, 0x74, 0x6c, 0x12, 0x2d, 0x0a, 0x12, 0x66, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x65,
+	0x64, 0x5f, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x18, 0x13, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x11, 0x66, 0x72, 0x61, 0x67, 0x6d, 0x65, 0x6e, 0x74, 0x65, 0x64, 0x50, 0x61, 0x63, 0x6b, 0x65,
+	0x74, 0x73, 0x12, 0x2e, 0x0a, 0x13, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x73, 0x69, 0x7a,
+	0x65, 0x5f, 0x62, 0x75, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x18, 0x14, 0x20, 0x03, 0x28, 0x0d, 0x52,
+	0x11, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x53, 0x69, 0x7a, 0x65, 0x42, 0x75, 0x63, 0x6b, 0x65,
+	0x74, 0x73, 0x12, 0x4f, 0x0a, 0x16, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x5f, 0x63, 0x6f, 0x6e,
+	0x6e, 0x65, 0x63, 0x74, 0x5f, 0x6c, 0x61, 0x74, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x15, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x14, 0x73,
+	0x65, 0x72, 0x76, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x4c, 0x61, 0x74, 0x65,
+	0x6e, 0x63, 0x79, 0x12, 0x34, 0x0a, 0x0a, 0x65, 0x6e, 0x64, 0x5f, 0x72, 0x65, 0x61, 0x73, 0x6f,
+	0x6e, 0x18, 0x16, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x15, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77,
+	0x2e, 0x46, 0x6c, 0x6f, 0x77, 0x45, 0x6e, 0x64, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x52, 0x09,
+	0x65, 0x6e, 0x64, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x65, 0x72,
+	0x76, 0x69, 0x63, 0x65, 0x18, 0x17, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x73, 0x65, 0x72, 0x76,
+	0x69, 0x63, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x63, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x5f, 0x69, 0x64,
+	0x18, 0x18, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x63, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x49, 0x64,
+	0x12, 0x3c, 0x0a, 0x0e, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x5f, 0x76, 0x65, 0x72, 0x64, 0x69,
+	0x63, 0x74, 0x18, 0x19, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x15, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f,
+	0x77, 0x2e, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x56, 0x65, 0x72, 0x64, 0x69, 0x63, 0x74, 0x52,
+	0x0d, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x56, 0x65, 0x72, 0x64, 0x69, 0x63, 0x74, 0x12, 0x21,
+	0x0a, 0x0c, 0x73, 0x72, 0x63, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x1a,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x73, 0x72, 0x63, 0x48, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d,
+	0x65, 0x12, 0x21, 0x0a, 0x0c, 0x64, 0x73, 0x74, 0x5f, 0x68, 0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x1b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x64, 0x73, 0x74, 0x48, 0x6f, 0x73, 0x74,
+	0x6e, 0x61, 0x6d, 0x65, 0x12, 0x23, 0x0a, 0x0d, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x1e, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x63, 0x6f, 0x6e,
+	0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x63, 0x6c, 0x75,
+	0x73, 0x74, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x1f, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x63,
+	0x6c, 0x75, 0x73, 0x74, 0x65, 0x72, 0x49, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x74, 0x65, 0x6e, 0x61,
+	0x6e, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x20, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x74, 0x65, 0x6e,
+	0x61, 0x6e, 0x74, 0x49, 0x64, 0x12, 0x24, 0x0a, 0x0e, 0x73, 0x75, 0x62, 0x5f, 0x66, 0x6c, 0x6f,
+	0x77, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x18, 0x21, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x73,
+	0x75, 0x62, 0x46, 0x6c, 0x6f, 0x77, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x39, 0x0a, 0x0d, 0x74,
+	0x72, 0x61, 0x66, 0x66, 0x69, 0x63, 0x5f, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x22, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x14, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x54, 0x72, 0x61, 0x66,
+	0x66, 0x69, 0x63, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x52, 0x0c, 0x74, 0x72, 0x61, 0x66, 0x66, 0x69,
+	0x63, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x12, 0x32, 0x0a, 0x07, 0x6d, 0x69, 0x6e, 0x5f, 0x69, 0x70,
+	0x67, 0x18, 0x23, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x06, 0x6d, 0x69, 0x6e, 0x49, 0x70, 0x67, 0x12, 0x32, 0x0a, 0x07, 0x6d, 0x61,
+	0x78, 0x5f, 0x69, 0x70, 0x67, 0x18, 0x24, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x6d, 0x61, 0x78, 0x49, 0x70, 0x67, 0x12, 0x34,
+	0x0a, 0x08, 0x6d, 0x65, 0x61, 0x6e, 0x5f, 0x69, 0x70, 0x67, 0x18, 0x25, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x6d, 0x65, 0x61,
+	0x6e, 0x49, 0x70, 0x67, 0x12, 0x3c, 0x0a, 0x0a, 0x73, 0x72, 0x63, 0x5f, 0x6c, 0x61, 0x62, 0x65,
+	0x6c, 0x73, 0x18, 0x26, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f,
+	0x77, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x53, 0x72, 0x63, 0x4c, 0x61, 0x62, 0x65,
+	0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x09, 0x73, 0x72, 0x63, 0x4c, 0x61, 0x62, 0x65,
+	0x6c, 0x73, 0x12, 0x3c, 0x0a, 0x0a, 0x64, 0x73, 0x74, 0x5f, 0x6c, 0x61, 0x62, 0x65, 0x6c, 0x73,
+	0x18, 0x27, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e,
+	0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x44, 0x73, 0x74, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x09, 0x64, 0x73, 0x74, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73,
+	0x12, 0x34, 0x0a, 0x08, 0x65, 0x63, 0x68, 0x6f, 0x5f, 0x72, 0x74, 0x74, 0x18, 0x28, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x65,
+	0x63, 0x68, 0x6f, 0x52, 0x74, 0x74, 0x12, 0x2c, 0x0a, 0x12, 0x65, 0x63, 0x68, 0x6f, 0x5f, 0x72,
+	0x65, 0x70, 0x6c, 0x79, 0x5f, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x18, 0x29, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x10, 0x65, 0x63, 0x68, 0x6f, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x50, 0x61, 0x63,
+	0x6b, 0x65, 0x74, 0x73, 0x12, 0x28, 0x0a, 0x10, 0x65, 0x63, 0x68, 0x6f, 0x5f, 0x72, 0x65, 0x70,
+	0x6c, 0x79, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x2a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0e,
+	0x65, 0x63, 0x68, 0x6f, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x23,
+	0x0a, 0x0d, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x2b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x61, 0x67, 0x65, 0x6e, 0x74, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x22, 0x0a, 0x0d, 0x62, 0x70, 0x66, 0x5f, 0x70, 0x72, 0x6f, 0x67, 0x5f,
+	0x68, 0x61, 0x73, 0x68, 0x18, 0x2c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x62, 0x70, 0x66, 0x50,
+	0x72, 0x6f, 0x67, 0x48, 0x61, 0x73, 0x68, 0x12, 0x21, 0x0a, 0x0c, 0x69, 0x6e, 0x74, 0x65, 0x72,
+	0x66, 0x61, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x2d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x49, 0x64, 0x12, 0x26, 0x0a, 0x06, 0x74, 0x75,
+	0x6e, 0x6e, 0x65, 0x6c, 0x18, 0x2e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x62, 0x66,
+	0x6c, 0x6f, 0x77, 0x2e, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x52, 0x06, 0x74, 0x75, 0x6e, 0x6e,
+	0x65, 0x6c, 0x12, 0x25, 0x0a, 0x0e, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x5f, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x2f, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0d, 0x73, 0x63, 0x68, 0x65,
+	0x6d, 0x61, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x21, 0x0a, 0x0c, 0x73, 0x63, 0x61,
+	0x6e, 0x5f, 0x73, 0x75, 0x73, 0x70, 0x65, 0x63, 0x74, 0x18, 0x30, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0b, 0x73, 0x63, 0x61, 0x6e, 0x53, 0x75, 0x73, 0x70, 0x65, 0x63, 0x74, 0x12, 0x10, 0x0a, 0x03,
+	0x70, 0x69, 0x64, 0x18, 0x31, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x70, 0x69, 0x64, 0x12, 0x12,
+	0x0a, 0x04, 0x63, 0x6f, 0x6d, 0x6d, 0x18, 0x32, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x63, 0x6f,
+	0x6d, 0x6d, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x5f, 0x69, 0x64, 0x18,
+	0x33, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x49, 0x64, 0x12,
+	0x25, 0x0a, 0x08, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x68, 0x6f, 0x70, 0x18, 0x34, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0a, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x49, 0x50, 0x52, 0x07, 0x6e,
+	0x65, 0x78, 0x74, 0x48, 0x6f, 0x70, 0x12, 0x20, 0x0a, 0x04, 0x71, 0x75, 0x69, 0x63, 0x18, 0x35,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x51, 0x75,
+	0x69, 0x63, 0x52, 0x04, 0x71, 0x75, 0x69, 0x63, 0x12, 0x2b, 0x0a, 0x11, 0x64, 0x75, 0x70, 0x6c,
+	0x69, 0x63, 0x61, 0x74, 0x65, 0x5f, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x18, 0x36, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x10, 0x64, 0x75, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x65, 0x50, 0x61,
+	0x63, 0x6b, 0x65, 0x74, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x6f, 0x62, 0x73, 0x65, 0x72, 0x76, 0x65,
+	0x64, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x37, 0x20, 0x03, 0x28, 0x0d, 0x52, 0x0d, 0x6f,
+	0x62, 0x73, 0x65, 0x72, 0x76, 0x65, 0x64, 0x50, 0x6f, 0x72, 0x74, 0x73, 0x12, 0x36, 0x0a, 0x17,
+	0x6f, 0x62, 0x73, 0x65, 0x72, 0x76, 0x65, 0x64, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x5f, 0x6f,
+	0x76, 0x65, 0x72, 0x66, 0x6c, 0x6f, 0x77, 0x18, 0x38, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x15, 0x6f,
+	0x62, 0x73, 0x65, 0x72, 0x76, 0x65, 0x64, 0x50, 0x6f, 0x72, 0x74, 0x73, 0x4f, 0x76, 0x65, 0x72,
+	0x66, 0x6c, 0x6f, 0x77, 0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65,
+	0x72, 0x5f, 0x69, 0x64, 0x18, 0x39, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x63, 0x6f, 0x6e, 0x74,
+	0x61, 0x69, 0x6e, 0x65, 0x72, 0x49, 0x64, 0x12, 0x17, 0x0a, 0x07, 0x70, 0x6f, 0x64, 0x5f, 0x75,
+	0x69, 0x64, 0x18, 0x3a, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x70, 0x6f, 0x64, 0x55, 0x69, 0x64,
+	0x12, 0x23, 0x0a, 0x0d, 0x73, 0x72, 0x63, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63,
+	0x65, 0x18, 0x3b, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x73, 0x72, 0x63, 0x4e, 0x61, 0x6d, 0x65,
+	0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x73, 0x72, 0x63, 0x5f, 0x70, 0x6f, 0x64,
+	0x18, 0x3c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x72, 0x63, 0x50, 0x6f, 0x64, 0x12, 0x23,
+	0x0a, 0x0d, 0x64, 0x73, 0x74, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18,
+	0x3d, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x64, 0x73, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x73, 0x70,
+	0x61, 0x63, 0x65, 0x12, 0x17, 0x0a, 0x07, 0x64, 0x73, 0x74, 0x5f, 0x70, 0x6f, 0x64, 0x18, 0x3e,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64, 0x73, 0x74, 0x50, 0x6f, 0x64, 0x12, 0x1f, 0x0a, 0x0b,
+	0x73, 0x72, 0x63, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x72, 0x79, 0x18, 0x3f, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x0a, 0x73, 0x72, 0x63, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x1f, 0x0a,
+	0x0b, 0x64, 0x73, 0x74, 0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x72, 0x79, 0x18, 0x40, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x0a, 0x64, 0x73, 0x74, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x72, 0x79, 0x1a, 0x3c,
+	0x0a, 0x0e, 0x53, 0x72, 0x63, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79,
+	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
+	0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x1a, 0x3c, 0x0a, 0x0e,
+	0x44, 0x73, 0x74, 0x4c, 0x61, 0x62, 0x65, 0x6c, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3c, 0x0a, 0x08, 0x44, 0x61,
+	0x74, 0x61, 0x4c, 0x69, 0x6e, 0x6b, 0x12, 0x17, 0x0a, 0x07, 0x73, 0x72, 0x63, 0x5f, 0x6d, 0x61,
+	0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x06, 0x73, 0x72, 0x63, 0x4d, 0x61, 0x63, 0x12,
+	0x17, 0x0a, 0x07, 0x64, 0x73, 0x74, 0x5f, 0x6d, 0x61, 0x63, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x06, 0x64, 0x73, 0x74, 0x4d, 0x61, 0x63, 0x22, 0x57, 0x0a, 0x07, 0x4e, 0x65, 0x74, 0x77,
+	0x6f, 0x72, 0x6b, 0x12, 0x25, 0x0a, 0x08, 0x73, 0x72, 0x63, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x49,
+	0x50, 0x52, 0x07, 0x73, 0x72, 0x63, 0x41, 0x64, 0x64, 0x72, 0x12, 0x25, 0x0a, 0x08, 0x64, 0x73,
+	0x74, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x70,
+	0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x49, 0x50, 0x52, 0x07, 0x64, 0x73, 0x74, 0x41, 0x64, 0x64,
+	0x72, 0x22, 0x3d, 0x0a, 0x02, 0x49, 0x50, 0x12, 0x14, 0x0a, 0x04, 0x69, 0x70, 0x76, 0x34, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x07, 0x48, 0x00, 0x52, 0x04, 0x69, 0x70, 0x76, 0x34, 0x12, 0x14, 0x0a,
+	0x04, 0x69, 0x70, 0x76, 0x36, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x48, 0x00, 0x52, 0x04, 0x69,
+	0x70, 0x76, 0x36, 0x42, 0x0b, 0x0a, 0x09, 0x69, 0x70, 0x5f, 0x66, 0x61, 0x6d, 0x69, 0x6c, 0x79,
+	0x22, 0x5d, 0x0a, 0x09, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x19, 0x0a,
+	0x08, 0x73, 0x72, 0x63, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x73, 0x72, 0x63, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x73, 0x74, 0x5f,
+	0x70, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x64, 0x73, 0x74, 0x50,
+	0x6f, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x22,
+	0xb4, 0x01, 0x0a, 0x06, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x12, 0x26, 0x0a, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x12, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f,
+	0x77, 0x2e, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79,
+	0x70, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x76, 0x6e, 0x69, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x03, 0x76, 0x6e, 0x69, 0x12, 0x34, 0x0a, 0x0d, 0x69, 0x6e, 0x6e, 0x65, 0x72, 0x5f, 0x6e, 0x65,
+	0x74, 0x77, 0x6f, 0x72, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x70, 0x62,
+	0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x52, 0x0c, 0x69, 0x6e,
+	0x6e, 0x65, 0x72, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b, 0x12, 0x3a, 0x0a, 0x0f, 0x69, 0x6e,
+	0x6e, 0x65, 0x72, 0x5f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x54, 0x72, 0x61,
+	0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x52, 0x0e, 0x69, 0x6e, 0x6e, 0x65, 0x72, 0x54, 0x72, 0x61,
+	0x6e, 0x73, 0x70, 0x6f, 0x72, 0x74, 0x22, 0x48, 0x0a, 0x04, 0x51, 0x75, 0x69, 0x63, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x63, 0x69, 0x64,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x63, 0x69, 0x64, 0x12, 0x12, 0x0a, 0x04,
+	0x73, 0x63, 0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x73, 0x63, 0x69, 0x64,
 	0x22, 0x40, 0x0a, 0x04, 0x49, 0x63, 0x6d, 0x70, 0x12, 0x1b, 0x0a, 0x09, 0x69, 0x63, 0x6d, 0x70,
 	0x5f, 0x74, 0x79, 0x70, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x69, 0x63, 0x6d,
 	0x70, 0x54, 0x79, 0x70, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x69, 0x63, 0x6d, 0x70, 0x5f, 0x63, 0x6f,
 	0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x69, 0x63, 0x6d, 0x70, 0x43, 0x6f,
-	0x64, 0x65, 0x2a, 0x24, 0x0a, 0x09, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12,
-	0x0b, 0x0a, 0x07, 0x49, 0x4e, 0x47, 0x52, 0x45, 0x53, 0x53, 0x10, 0x00, 0x12, 0x0a, 0x0a, 0x06,
-	0x45, 0x47, 0x52, 0x45, 0x53, 0x53, 0x10, 0x01, 0x32, 0x3e, 0x0a, 0x09, 0x43, 0x6f, 0x6c, 0x6c,
-	0x65, 0x63, 0x74, 0x6f, 0x72, 0x12, 0x31, 0x0a, 0x04, 0x53, 0x65, 0x6e, 0x64, 0x12, 0x0f, 0x2e,
-	0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x1a, 0x16,
-	0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f,
-	0x72, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22, 0x00, 0x42, 0x0a, 0x5a, 0x08, 0x2e, 0x2f, 0x70, 0x62,
-	0x66, 0x6c, 0x6f, 0x77, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x64, 0x65, 0x2a, 0x9e, 0x01, 0x0a, 0x08, 0x54, 0x43, 0x50, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12,
+	0x15, 0x0a, 0x11, 0x54, 0x43, 0x50, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x45, 0x5f, 0x55, 0x4e, 0x4b,
+	0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x16, 0x0a, 0x12, 0x54, 0x43, 0x50, 0x5f, 0x53, 0x54,
+	0x41, 0x54, 0x45, 0x5f, 0x53, 0x59, 0x4e, 0x5f, 0x53, 0x45, 0x4e, 0x54, 0x10, 0x01, 0x12, 0x1a,
+	0x0a, 0x16, 0x54, 0x43, 0x50, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x45, 0x5f, 0x53, 0x59, 0x4e, 0x5f,
+	0x52, 0x45, 0x43, 0x45, 0x49, 0x56, 0x45, 0x44, 0x10, 0x02, 0x12, 0x19, 0x0a, 0x15, 0x54, 0x43,
+	0x50, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x45, 0x5f, 0x45, 0x53, 0x54, 0x41, 0x42, 0x4c, 0x49, 0x53,
+	0x48, 0x45, 0x44, 0x10, 0x03, 0x12, 0x16, 0x0a, 0x12, 0x54, 0x43, 0x50, 0x5f, 0x53, 0x54, 0x41,
+	0x54, 0x45, 0x5f, 0x46, 0x49, 0x4e, 0x5f, 0x57, 0x41, 0x49, 0x54, 0x10, 0x04, 0x12, 0x14, 0x0a,
+	0x10, 0x54, 0x43, 0x50, 0x5f, 0x53, 0x54, 0x41, 0x54, 0x45, 0x5f, 0x43, 0x4c, 0x4f, 0x53, 0x45,
+	0x44, 0x10, 0x05, 0x2a, 0xd7, 0x01, 0x0a, 0x0d, 0x46, 0x6c, 0x6f, 0x77, 0x45, 0x6e, 0x64, 0x52,
+	0x65, 0x61, 0x73, 0x6f, 0x6e, 0x12, 0x1c, 0x0a, 0x18, 0x46, 0x4c, 0x4f, 0x57, 0x5f, 0x45, 0x4e,
+	0x44, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x45, 0x56, 0x49, 0x43, 0x54, 0x49, 0x4f,
+	0x4e, 0x10, 0x00, 0x12, 0x20, 0x0a, 0x1c, 0x46, 0x4c, 0x4f, 0x57, 0x5f, 0x45, 0x4e, 0x44, 0x5f,
+	0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x4c, 0x49, 0x46, 0x45, 0x54, 0x49, 0x4d, 0x45, 0x5f,
+	0x43, 0x41, 0x50, 0x10, 0x01, 0x12, 0x1d, 0x0a, 0x19, 0x46, 0x4c, 0x4f, 0x57, 0x5f, 0x45, 0x4e,
+	0x44, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x48, 0x45, 0x41, 0x52, 0x54, 0x42, 0x45,
+	0x41, 0x54, 0x10, 0x02, 0x12, 0x1d, 0x0a, 0x19, 0x46, 0x4c, 0x4f, 0x57, 0x5f, 0x45, 0x4e, 0x44,
+	0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x54, 0x43, 0x50, 0x5f, 0x43, 0x4c, 0x4f, 0x53,
+	0x45, 0x10, 0x03, 0x12, 0x21, 0x0a, 0x1d, 0x46, 0x4c, 0x4f, 0x57, 0x5f, 0x45, 0x4e, 0x44, 0x5f,
+	0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x49, 0x46, 0x41, 0x43, 0x45, 0x5f, 0x52, 0x45, 0x4d,
+	0x4f, 0x56, 0x45, 0x44, 0x10, 0x04, 0x12, 0x25, 0x0a, 0x21, 0x46, 0x4c, 0x4f, 0x57, 0x5f, 0x45,
+	0x4e, 0x44, 0x5f, 0x52, 0x45, 0x41, 0x53, 0x4f, 0x4e, 0x5f, 0x50, 0x4f, 0x52, 0x54, 0x5f, 0x53,
+	0x43, 0x41, 0x4e, 0x5f, 0x53, 0x55, 0x4d, 0x4d, 0x41, 0x52, 0x59, 0x10, 0x05, 0x2a, 0x62, 0x0a,
+	0x0d, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x56, 0x65, 0x72, 0x64, 0x69, 0x63, 0x74, 0x12, 0x1a,
+	0x0a, 0x16, 0x50, 0x4f, 0x4c, 0x49, 0x43, 0x59, 0x5f, 0x56, 0x45, 0x52, 0x44, 0x49, 0x43, 0x54,
+	0x5f, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12, 0x1a, 0x0a, 0x16, 0x50, 0x4f,
+	0x4c, 0x49, 0x43, 0x59, 0x5f, 0x56, 0x45, 0x52, 0x44, 0x49, 0x43, 0x54, 0x5f, 0x41, 0x4c, 0x4c,
+	0x4f, 0x57, 0x45, 0x44, 0x10, 0x01, 0x12, 0x19, 0x0a, 0x15, 0x50, 0x4f, 0x4c, 0x49, 0x43, 0x59,
+	0x5f, 0x56, 0x45, 0x52, 0x44, 0x49, 0x43, 0x54, 0x5f, 0x44, 0x45, 0x4e, 0x49, 0x45, 0x44, 0x10,
+	0x02, 0x2a, 0x51, 0x0a, 0x0a, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x54, 0x79, 0x70, 0x65, 0x12,
+	0x14, 0x0a, 0x10, 0x54, 0x55, 0x4e, 0x4e, 0x45, 0x4c, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x4e,
+	0x4f, 0x4e, 0x45, 0x10, 0x00, 0x12, 0x15, 0x0a, 0x11, 0x54, 0x55, 0x4e, 0x4e, 0x45, 0x4c, 0x5f,
+	0x54, 0x59, 0x50, 0x45, 0x5f, 0x56, 0x58, 0x4c, 0x41, 0x4e, 0x10, 0x01, 0x12, 0x16, 0x0a, 0x12,
+	0x54, 0x55, 0x4e, 0x4e, 0x45, 0x4c, 0x5f, 0x54, 0x59, 0x50, 0x45, 0x5f, 0x47, 0x45, 0x4e, 0x45,
+	0x56, 0x45, 0x10, 0x02, 0x2a, 0x7e, 0x0a, 0x0c, 0x54, 0x72, 0x61, 0x66, 0x66, 0x69, 0x63, 0x43,
+	0x6c, 0x61, 0x73, 0x73, 0x12, 0x19, 0x0a, 0x15, 0x54, 0x52, 0x41, 0x46, 0x46, 0x49, 0x43, 0x5f,
+	0x43, 0x4c, 0x41, 0x53, 0x53, 0x5f, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10, 0x00, 0x12,
+	0x19, 0x0a, 0x15, 0x54, 0x52, 0x41, 0x46, 0x46, 0x49, 0x43, 0x5f, 0x43, 0x4c, 0x41, 0x53, 0x53,
+	0x5f, 0x55, 0x4e, 0x49, 0x43, 0x41, 0x53, 0x54, 0x10, 0x01, 0x12, 0x1b, 0x0a, 0x17, 0x54, 0x52,
+	0x41, 0x46, 0x46, 0x49, 0x43, 0x5f, 0x43, 0x4c, 0x41, 0x53, 0x53, 0x5f, 0x4d, 0x55, 0x4c, 0x54,
+	0x49, 0x43, 0x41, 0x53, 0x54, 0x10, 0x02, 0x12, 0x1b, 0x0a, 0x17, 0x54, 0x52, 0x41, 0x46, 0x46,
+	0x49, 0x43, 0x5f, 0x43, 0x4c, 0x41, 0x53, 0x53, 0x5f, 0x42, 0x52, 0x4f, 0x41, 0x44, 0x43, 0x41,
+	0x53, 0x54, 0x10, 0x03, 0x2a, 0x3b, 0x0a, 0x09, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f,
+	0x6e, 0x12, 0x0b, 0x0a, 0x07, 0x49, 0x4e, 0x47, 0x52, 0x45, 0x53, 0x53, 0x10, 0x00, 0x12, 0x0a,
+	0x0a, 0x06, 0x45, 0x47, 0x52, 0x45, 0x53, 0x53, 0x10, 0x01, 0x12, 0x15, 0x0a, 0x11, 0x44, 0x49,
+	0x52, 0x45, 0x43, 0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x55, 0x4e, 0x4b, 0x4e, 0x4f, 0x57, 0x4e, 0x10,
+	0x02, 0x32, 0x3e, 0x0a, 0x09, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x12, 0x31,
+	0x0a, 0x04, 0x53, 0x65, 0x6e, 0x64, 0x12, 0x0f, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x2e,
+	0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x73, 0x1a, 0x16, 0x2e, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77,
+	0x2e, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x6f, 0x72, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x22,
+	0x00, 0x42, 0x0a, 0x5a, 0x08, 0x2e, 0x2f, 0x70, 0x62, 0x66, 0x6c, 0x6f, 0x77, 0x62, 0x06, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -714,39 +1746,66 @@ func file_proto_flow_proto_rawDescGZIP() []byte {
 	return file_proto_flow_proto_rawDescData
 }
 
-var file_proto_flow_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_proto_flow_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_proto_flow_proto_enumTypes = make([]protoimpl.EnumInfo, 6)
+var file_proto_flow_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
 var file_proto_flow_proto_goTypes = []interface{}{
-	(Direction)(0),                // 0: pbflow.Direction
-	(*CollectorReply)(nil),        // 1: pbflow.CollectorReply
-	(*Records)(nil),               // 2: pbflow.Records
-	(*Record)(nil),                // 3: pbflow.Record
-	(*DataLink)(nil),              // 4: pbflow.DataLink
-	(*Network)(nil),               // 5: pbflow.Network
-	(*IP)(nil),                    // 6: pbflow.IP
-	(*Transport)(nil),             // 7: pbflow.Transport
-	(*Icmp)(nil),                  // 8: pbflow.Icmp
-	(*timestamppb.Timestamp)(nil), // 9: google.protobuf.Timestamp
+	(TCPState)(0),                 // 0: pbflow.TCPState
+	(FlowEndReason)(0),            // 1: pbflow.FlowEndReason
+	(PolicyVerdict)(0),            // 2: pbflow.PolicyVerdict
+	(TunnelType)(0),               // 3: pbflow.TunnelType
+	(TrafficClass)(0),             // 4: pbflow.TrafficClass
+	(Direction)(0),                // 5: pbflow.Direction
+	(*CollectorReply)(nil),        // 6: pbflow.CollectorReply
+	(*Records)(nil),               // 7: pbflow.Records
+	(*Record)(nil),                // 8: pbflow.Record
+	(*DataLink)(nil),              // 9: pbflow.DataLink
+	(*Network)(nil),               // 10: pbflow.Network
+	(*IP)(nil),                    // 11: pbflow.IP
+	(*Transport)(nil),             // 12: pbflow.Transport
+	(*Tunnel)(nil),                // 13: pbflow.Tunnel
+	(*Quic)(nil),                  // 14: pbflow.Quic
+	(*Icmp)(nil),                  // 15: pbflow.Icmp
+	nil,                           // 16: pbflow.Record.SrcLabelsEntry
+	nil,                           // 17: pbflow.Record.DstLabelsEntry
+	(*timestamppb.Timestamp)(nil), // 18: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 19: google.protobuf.Duration
 }
 var file_proto_flow_proto_depIdxs = []int32{
-	3,  // 0: pbflow.Records.entries:type_name -> pbflow.Record
-	0,  // 1: pbflow.Record.direction:type_name -> pbflow.Direction
-	9,  // 2: pbflow.Record.time_flow_start:type_name -> google.protobuf.Timestamp
-	9,  // 3: pbflow.Record.time_flow_end:type_name -> google.protobuf.Timestamp
-	4,  // 4: pbflow.Record.data_link:type_name -> pbflow.DataLink
-	5,  // 5: pbflow.Record.network:type_name -> pbflow.Network
-	7,  // 6: pbflow.Record.transport:type_name -> pbflow.Transport
-	6,  // 7: pbflow.Record.agent_ip:type_name -> pbflow.IP
-	8,  // 8: pbflow.Record.icmp:type_name -> pbflow.Icmp
-	6,  // 9: pbflow.Network.src_addr:type_name -> pbflow.IP
-	6,  // 10: pbflow.Network.dst_addr:type_name -> pbflow.IP
-	2,  // 11: pbflow.Collector.Send:input_type -> pbflow.Records
-	1,  // 12: pbflow.Collector.Send:output_type -> pbflow.CollectorReply
-	12, // [12:13] is the sub-list for method output_type
-	11, // [11:12] is the sub-list for method input_type
-	11, // [11:11] is the sub-list for extension type_name
-	11, // [11:11] is the sub-list for extension extendee
-	0,  // [0:11] is the sub-list for field type_name
+	8,  // 0: pbflow.Records.entries:type_name -> pbflow.Record
+	5,  // 1: pbflow.Record.direction:type_name -> pbflow.Direction
+	18, // 2: pbflow.Record.time_flow_start:type_name -> google.protobuf.Timestamp
+	18, // 3: pbflow.Record.time_flow_end:type_name -> google.protobuf.Timestamp
+	9,  // 4: pbflow.Record.data_link:type_name -> pbflow.DataLink
+	10, // 5: pbflow.Record.network:type_name -> pbflow.Network
+	12, // 6: pbflow.Record.transport:type_name -> pbflow.Transport
+	11, // 7: pbflow.Record.agent_ip:type_name -> pbflow.IP
+	15, // 8: pbflow.Record.icmp:type_name -> pbflow.Icmp
+	0,  // 9: pbflow.Record.tcp_state:type_name -> pbflow.TCPState
+	19, // 10: pbflow.Record.server_connect_latency:type_name -> google.protobuf.Duration
+	1,  // 11: pbflow.Record.end_reason:type_name -> pbflow.FlowEndReason
+	2,  // 12: pbflow.Record.policy_verdict:type_name -> pbflow.PolicyVerdict
+	4,  // 13: pbflow.Record.traffic_class:type_name -> pbflow.TrafficClass
+	19, // 14: pbflow.Record.min_ipg:type_name -> google.protobuf.Duration
+	19, // 15: pbflow.Record.max_ipg:type_name -> google.protobuf.Duration
+	19, // 16: pbflow.Record.mean_ipg:type_name -> google.protobuf.Duration
+	16, // 17: pbflow.Record.src_labels:type_name -> pbflow.Record.SrcLabelsEntry
+	17, // 18: pbflow.Record.dst_labels:type_name -> pbflow.Record.DstLabelsEntry
+	19, // 19: pbflow.Record.echo_rtt:type_name -> google.protobuf.Duration
+	13, // 20: pbflow.Record.tunnel:type_name -> pbflow.Tunnel
+	11, // 21: pbflow.Record.next_hop:type_name -> pbflow.IP
+	14, // 22: pbflow.Record.quic:type_name -> pbflow.Quic
+	11, // 23: pbflow.Network.src_addr:type_name -> pbflow.IP
+	11, // 24: pbflow.Network.dst_addr:type_name -> pbflow.IP
+	3,  // 25: pbflow.Tunnel.type:type_name -> pbflow.TunnelType
+	10, // 26: pbflow.Tunnel.inner_network:type_name -> pbflow.Network
+	12, // 27: pbflow.Tunnel.inner_transport:type_name -> pbflow.Transport
+	7,  // 28: pbflow.Collector.Send:input_type -> pbflow.Records
+	6,  // 29: pbflow.Collector.Send:output_type -> pbflow.CollectorReply
+	29, // [29:30] is the sub-list for method output_type
+	28, // [28:29] is the sub-list for method input_type
+	28, // [28:28] is the sub-list for extension type_name
+	28, // [28:28] is the sub-list for extension extendee
+	0,  // [0:28] is the sub-list for field type_name
 }
 
 func init() { file_proto_flow_proto_init() }
@@ -840,6 +1899,30 @@ func file_proto_flow_proto_init() {
 			}
 		}
 		file_proto_flow_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Tunnel); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_flow_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Quic); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_flow_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Icmp); i {
 			case 0:
 				return &v.state
@@ -861,8 +1944,8 @@ func file_proto_flow_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_flow_proto_rawDesc,
-			NumEnums:      1,
-			NumMessages:   8,
+			NumEnums:      6,
+			NumMessages:   12,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -25,6 +25,11 @@ func (ef *ExporterFake) Export(in <-chan []*flow.Record) {
 	}
 }
 
+// Messages returns the channel where the exported messages are forwarded
+func (ef *ExporterFake) Messages() <-chan []*flow.Record {
+	return ef.messages
+}
+
 func (ef *ExporterFake) Get(t *testing.T, timeout time.Duration) []*flow.Record {
 	t.Helper()
 	select {
@@ -17,3 +17,11 @@ func (sif SliceInformerFake) Subscribe(_ context.Context) (<-chan ifaces.Event,
 	}
 	return ifs, nil
 }
+
+// EventsInformerFake fakes the ifaces.Informer implementation by forwarding the events that are
+// submitted to it
+type EventsInformerFake chan ifaces.Event
+
+func (eif EventsInformerFake) Subscribe(_ context.Context) (<-chan ifaces.Event, error) {
+	return eif, nil
+}
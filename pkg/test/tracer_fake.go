@@ -3,6 +3,8 @@ package test
 import (
 	"bytes"
 	"encoding/binary"
+	"sync"
+	"sync/atomic"
 
 	"github.com/cilium/ebpf/ringbuf"
 	"github.com/netobserv/netobserv-ebpf-agent/pkg/ebpf"
@@ -12,9 +14,14 @@ import (
 
 // TracerFake fakes the kernel-side eBPF map structures for testing
 type TracerFake struct {
-	interfaces map[ifaces.Interface]struct{}
-	mapLookups chan map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
-	ringBuf    chan ringbuf.Record
+	interfaces   map[ifaces.Interface]struct{}
+	mapLookups   chan map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
+	ringBuf      chan ringbuf.Record
+	closeOnce    sync.Once
+	closed       chan struct{}
+	pressure     uint32
+	samplingRate uint32
+	noRingBuf    bool
 }
 
 func NewTracerFake() *TracerFake {
@@ -22,10 +29,15 @@ func NewTracerFake() *TracerFake {
 		interfaces: map[ifaces.Interface]struct{}{},
 		mapLookups: make(chan map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics, 100),
 		ringBuf:    make(chan ringbuf.Record, 100),
+		closed:     make(chan struct{}),
 	}
 }
 
+// Close unblocks any pending ReadRingBuf invocation, as the actual ring buffer does
 func (m *TracerFake) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.closed)
+	})
 	return nil
 }
 func (m *TracerFake) Register(iface ifaces.Interface) error {
@@ -42,8 +54,72 @@ func (m *TracerFake) LookupAndDeleteMap() map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics
 	}
 }
 
+func (m *TracerFake) LookupAndDeleteStartedBefore(_ uint64) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics {
+	return map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}
+}
+
+// LookupAndDeleteMatching returns the matching flows from the next pending lookup results. The
+// rest of the flows are queued again as lookup results.
+func (m *TracerFake) LookupAndDeleteMatching(
+	match func(id *ebpf.BpfFlowId, metric *ebpf.BpfFlowMetrics) bool,
+) map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics {
+	matched := map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}
+	select {
+	case r := <-m.mapLookups:
+		rest := map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics{}
+		for id, metric := range r {
+			id, metric := id, metric
+			if match(&id, &metric) {
+				matched[id] = metric
+			} else {
+				rest[id] = metric
+			}
+		}
+		if len(rest) > 0 {
+			m.mapLookups <- rest
+		}
+	default:
+	}
+	return matched
+}
+
+func (m *TracerFake) SetPressureLevel(level uint32) error {
+	atomic.StoreUint32(&m.pressure, level)
+	return nil
+}
+
+func (m *TracerFake) SetSamplingRate(rate uint32) error {
+	atomic.StoreUint32(&m.samplingRate, rate)
+	return nil
+}
+
+// SamplingRate returns the last rate set with SetSamplingRate
+func (m *TracerFake) SamplingRate() uint32 {
+	return atomic.LoadUint32(&m.samplingRate)
+}
+
+// PressureLevel returns the last level set with SetPressureLevel
+func (m *TracerFake) PressureLevel() uint32 {
+	return atomic.LoadUint32(&m.pressure)
+}
+
 func (m *TracerFake) ReadRingBuf() (ringbuf.Record, error) {
-	return <-m.ringBuf, nil
+	select {
+	case r := <-m.ringBuf:
+		return r, nil
+	case <-m.closed:
+		return ringbuf.Record{}, ringbuf.ErrClosed
+	}
+}
+
+func (m *TracerFake) RingBufEnabled() bool {
+	return !m.noRingBuf
+}
+
+// DisableRingBuf makes the fake behave as a kernel without ring buffer support. It must be
+// invoked before the fake is passed to the agent.
+func (m *TracerFake) DisableRingBuf() {
+	m.noRingBuf = true
 }
 
 func (m *TracerFake) AppendLookupResults(results map[ebpf.BpfFlowId]ebpf.BpfFlowMetrics) {